@@ -1,3 +1,7 @@
 go build -tags "sqlite_math_functions" -o bin\server.exe ./cmd/server
 go build -tags "sqlite_math_functions" -o bin\querytool.exe ./tools/querytool
-go build -tags "sqlite_math_functions" -o bin\migrate.exe ./tools/buckets_to_db
\ No newline at end of file
+go build -tags "sqlite_math_functions" -o bin\migrate.exe ./tools/buckets_to_db
+go build -tags "sqlite_math_functions" -o bin\ofx_import.exe ./tools/ofx_import
+go build -tags "sqlite_math_functions" -o bin\csv_import.exe ./tools/csv_import
+go build -tags "sqlite_math_functions" -o bin\qif.exe ./tools/qif
+go build -tags "sqlite_math_functions" -o bin\ledger.exe ./tools/ledger
\ No newline at end of file
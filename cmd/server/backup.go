@@ -0,0 +1,29 @@
+package main
+
+import (
+	"budgeting/internal/pkg/backup"
+	"budgeting/internal/pkg/db"
+	"log"
+	"time"
+)
+
+// Snapshot the live DB on a timer, dropping the oldest beyond the retention
+// A failed snapshot is logged and the next tick tries again
+func runBackups(sdb db.DB, backups backup.Dir, every time.Duration) {
+	for range time.Tick(every) {
+		s, err := backups.Snapshot(sdb, time.Now())
+		if err != nil {
+			log.Printf("Backup failed: %s", err.Error())
+			continue
+		}
+		log.Printf("Backup -- %s, %d bytes", s.Path, s.Size)
+
+		pruned, err := backups.Prune()
+		if err != nil {
+			log.Printf("Backup prune failed: %s", err.Error())
+		}
+		for _, p := range pruned {
+			log.Printf("Backup -- pruned %s", p.Path)
+		}
+	}
+}
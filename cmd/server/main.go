@@ -2,9 +2,13 @@ package main
 
 import (
 	"budgeting/internal/pkg/app"
+	"budgeting/internal/pkg/backup"
 	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/auth"
 	"budgeting/internal/pkg/middleware/logger"
 	"budgeting/internal/pkg/middleware/querymonth"
+	"budgeting/internal/pkg/middleware/session"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,15 +17,41 @@ import (
 
 func main() {
 
-	// TODO: Set up DB
+	dbname := flag.String("db", "bin/db.db", "SQLite file or postgres:// URL to serve")
+	backupDir := flag.String("backups", "bin/backups", "Directory for DB snapshots")
+	backupEvery := flag.Duration("backup-every", 24*time.Hour, "Time between scheduled snapshots, 0 turns them off")
+	backupKeep := flag.Int("backup-keep", 14, "Snapshots to keep, 0 keeps them all")
+	scheduleEvery := flag.Duration("schedule-every", time.Hour, "Time between checks for due scheduled transactions, 0 turns them off")
+	noAuth := flag.Bool("no-auth", false, "Serve everyone without logging in, only for a server nobody else can reach")
+	sessionIdle := flag.Duration("session-idle", session.DefaultIdle, "Log a browser out after this long without a request")
+	sessionMax := flag.Duration("session-max", session.DefaultAbsolute, "Log a browser out this long after it logged in")
+	cookieSecure := flag.Bool("cookie-secure", false, "Mark the session cookie HTTPS only even on plain HTTP requests, for a server behind an HTTPS proxy")
+	flag.Parse()
+
 	log.Println("Startup -- create DB")
 
-	sdb := db.NewSQLite()
-	err := sdb.Open("bin/db.db")
+	sdb := db.NewFor(*dbname)
+	err := sdb.Open(*dbname)
 	if err != nil {
 		log.Fatalf("Failed to open DB: %s", err.Error())
 	}
 
+	// Only SQLite can snapshot itself, Postgres is left to pg_dump
+	backups := backup.Dir{Path: *backupDir, Keep: *backupKeep}
+	_, sqlite := sdb.(*db.SQLite)
+	switch {
+	case *backupEvery > 0 && !sqlite:
+		log.Printf("Startup -- no scheduled snapshots, %s is not a SQLite file", *dbname)
+	case *backupEvery > 0:
+		log.Printf("Startup -- snapshots to %s every %s", backups.Path, *backupEvery)
+		go runBackups(sdb, backups, *backupEvery)
+	}
+
+	if *scheduleEvery > 0 {
+		log.Printf("Startup -- scheduled transactions every %s", *scheduleEvery)
+		go runSchedules(sdb, *scheduleEvery)
+	}
+
 	log.Println("Startup -- create mux")
 
 	// Set up top level muxer
@@ -36,18 +66,39 @@ func main() {
 	mux.Handle("/uptime", NewUptimeHandler(time.Now()))
 
 	// These set up their own muxers
-	mux.Handle("/api/", http.StripPrefix("/api", app.NewAPIHandler(sdb)))
+	mux.Handle("/api/", http.StripPrefix("/api", app.NewAPIHandler(sdb, backups)))
 	mux.Handle("/", app.NewViewHandler(sdb))
 
 	// Nearly done, static resources
 	mux.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("web/static"))))
 
+	// Everything but the login page needs a user, add them with querytool
+	var handler http.Handler = querymonth.NewQueryMonth(mux)
+	if *noAuth {
+		log.Println("Startup -- auth is off, anyone who can reach the server can use it")
+	} else {
+		us, err := sdb.GetUsers()
+		if err != nil {
+			log.Fatalf("Failed to read users: %s", err.Error())
+		}
+		if len(us) == 0 {
+			log.Println("Startup -- no users yet, add one with: querytool <dbfile> user add --name <name>")
+		}
+		if !*cookieSecure {
+			log.Println("Startup -- session cookies also go over plain HTTP")
+		}
+		handler = session.NewSessions(auth.NewAuth(handler, sdb), sdb, session.Config{
+			Idle:     *sessionIdle,
+			Absolute: *sessionMax,
+			Secure:   *cookieSecure,
+		})
+	}
+
 	log.Println("Listening...")
 
 	log.Fatal(http.ListenAndServe(":8000",
 		logger.NewLogger(
-			querymonth.NewQueryMonth(
-				mux))))
+			handler)))
 
 }
 
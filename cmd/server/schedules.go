@@ -0,0 +1,28 @@
+package main
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"log"
+	"time"
+)
+
+// Enter scheduled transactions as they come due, once at startup to catch up and then on a timer
+// A failed run or schedule is logged and the next tick tries again, nothing is entered twice as each run is one DB transaction
+func runSchedules(sdb db.DB, every time.Duration) {
+	sdb = sdb.WithActor("schedules")
+	for {
+		ats, failed, err := sdb.RunSchedules(bcdate.FromTime(time.Now()))
+		if err != nil {
+			log.Printf("Schedules failed: %s", err.Error())
+		}
+		for _, f := range failed {
+			log.Printf("Schedules -- skipped schedule %d: %s", f.ScheduleID, f.Err.Error())
+		}
+		for _, at := range ats {
+			log.Printf("Schedules -- entered a %03d %08d %d %q", at.AccountID, at.PostDate, at.Amount, at.Memo)
+		}
+
+		time.Sleep(every)
+	}
+}
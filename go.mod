@@ -3,3 +3,7 @@ module budgeting
 go 1.19
 
 require github.com/mattn/go-sqlite3 v1.14.17
+
+require github.com/lib/pq v1.10.9
+
+require golang.org/x/crypto v0.17.0
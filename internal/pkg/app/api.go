@@ -1,23 +1,48 @@
 package app
 
 import (
+	"budgeting/internal/pkg/backup"
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/budget"
 	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/auth"
+	"budgeting/internal/pkg/middleware/querymonth"
+	"budgeting/internal/pkg/middleware/session"
+	"budgeting/internal/pkg/model"
 	"budgeting/internal/pkg/shiftpath"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-// TODO: Handler for API endpoints
+// Handler for API endpoints
 // Call Controllers, then dump the result to JSON
+//
+// Collections are read with GET on the plural name, items live under the singular name:
+//
+//	POST   /api/<item>      creates, returns 201 and the new item
+//	GET    /api/<item>/<id> reads
+//	PATCH  /api/<item>/<id> overlays the given fields onto the stored item
+//	DELETE /api/<item>/<id> deletes, returns 204
+//
+// Month scoped reads use the qm query parameter like the views
+// Errors come back as {"status", "error", "message"} with a matching status code, a 500 only names what failed and leaves the cause to the server log
+// Endpoints beyond these are described on their handlers
 type APIHandler struct {
-	sdb db.DB
+	sdb     db.DB
+	backups backup.Dir
 }
 
-func NewAPIHandler(sdb db.DB) http.Handler {
-	return &APIHandler{sdb}
+func NewAPIHandler(sdb db.DB, backups backup.Dir) http.Handler {
+	return &APIHandler{sdb, backups}
 }
 
 func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	// Writes made for this request go down to whoever made it in the audit log
+	h = &APIHandler{h.sdb.WithActor(requestActor(r)), h.backups}
+
 	head, tail := shiftpath.ShiftPath(r.URL.Path)
 
 	switch head {
@@ -28,72 +53,1962 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "account":
 		h.ServeHTTP_account(w, r, tail)
 	case "transactions":
-		h.ServeHTTP_transactions(w, r)
+		h.ServeHTTP_transactions(w, r, tail)
 	case "transaction":
 		h.ServeHTTP_transaction(w, r, tail)
+	case "payees":
+		h.ServeHTTP_payees(w, r)
+	case "payee":
+		h.ServeHTTP_payee(w, r, tail)
+	case "rules":
+		h.ServeHTTP_rules(w, r, tail)
+	case "rule":
+		h.ServeHTTP_rule(w, r, tail)
+	case "schedules":
+		h.ServeHTTP_schedules(w, r)
+	case "schedule":
+		h.ServeHTTP_schedule(w, r, tail)
+	case "transfer":
+		h.ServeHTTP_transfer(w, r, tail)
+	case "groups":
+		h.ServeHTTP_groups(w, r)
+	case "group":
+		h.ServeHTTP_group(w, r, tail)
 	case "envelopes":
 		h.ServeHTTP_envelopes(w, r)
 	case "envelope":
 		h.ServeHTTP_envelope(w, r, tail)
+	case "envelope_transaction":
+		h.ServeHTTP_envelope_transaction(w, r, tail)
+	case "budget":
+		h.ServeHTTP_budget(w, r, tail)
 	case "sanity":
 		h.ServeHTTP_sanity(w, r)
+	case "admin":
+		h.ServeHTTP_admin(w, r, tail)
+	case "sessions":
+		h.ServeHTTP_sessions(w, r)
+	case "session":
+		h.ServeHTTP_session(w, r, tail)
+	case "history":
+		h.ServeHTTP_history(w, r, tail)
+	case "undo":
+		h.ServeHTTP_undo(w, r)
 
 	// Anything else, 404
 	default:
-		http.NotFound(w, r)
+		writeError(w, http.StatusNotFound, "no such endpoint: %s", r.URL.Path)
+	}
+
+}
+
+// Handlers for a single item, nil when the item does not support the method
+type itemHandlers struct {
+	create func(w http.ResponseWriter, r *http.Request)
+	get    func(w http.ResponseWriter, r *http.Request, id model.PKEY)
+	patch  func(w http.ResponseWriter, r *http.Request, id model.PKEY)
+	delete func(w http.ResponseWriter, r *http.Request, id model.PKEY)
+}
+
+func (ih itemHandlers) serve(w http.ResponseWriter, r *http.Request, tail string) {
+	id, ok, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if !ok {
+		if r.Method != http.MethodPost || ih.create == nil {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		ih.create(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && ih.get != nil:
+		ih.get(w, r, id)
+	case r.Method == http.MethodPatch && ih.patch != nil:
+		ih.patch(w, r, id)
+	case r.Method == http.MethodDelete && ih.delete != nil:
+		ih.delete(w, r, id)
+	default:
+		allowed := make([]string, 0, 3)
+		if ih.get != nil {
+			allowed = append(allowed, http.MethodGet)
+		}
+		if ih.patch != nil {
+			allowed = append(allowed, http.MethodPatch)
+		}
+		if ih.delete != nil {
+			allowed = append(allowed, http.MethodDelete)
+		}
+		writeMethodNotAllowed(w, allowed...)
 	}
+}
 
+func created(w http.ResponseWriter, item string, id model.PKEY, v any) {
+	w.Header().Set("Location", "/api/"+item+"/"+strconv.Itoa(int(id)))
+	writeJSON(w, http.StatusCreated, v)
 }
 
 func (h *APIHandler) ServeHTTP_summary(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("/api/summary"))
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	summ, err := h.sdb.GetOverallSummary(month)
+	if err != nil {
+		writeDBError(w, err, "overall summary")
+		return
+	}
 
-	// TODO: Return summary info
+	writeJSON(w, http.StatusOK, toJSONSummary(summ))
 }
 
+// Accounts
+
 func (h *APIHandler) ServeHTTP_accounts(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("/api/accounts"))
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	type as struct {
+		Account jsonAccount        `json:"account"`
+		Summary jsonAccountSummary `json:"summary"`
+	}
+
+	accts, err := h.sdb.GetAccounts()
+	if err != nil {
+		writeDBError(w, err, "account list")
+		return
+	}
 
-	// TODO: Return account list
+	ret := make([]as, 0, len(accts))
+	for _, acct := range accts {
+		s, err := h.sdb.GetAccountSummary(month, acct.ID)
+		if err != nil {
+			writeDBError(w, err, fmt.Sprintf("summary of account %d", acct.ID))
+			return
+		}
+		ret = append(ret, as{toJSONAccount(acct), toJSONAccountSummary(s)})
+	}
+
+	writeJSON(w, http.StatusOK, ret)
 }
 
 func (h *APIHandler) ServeHTTP_account(w http.ResponseWriter, r *http.Request, tail string) {
-	w.Write([]byte("/api/account"))
+	switch _, rest := shiftpath.ShiftPath(tail); rest {
+	case "/reconcile":
+		h.reconcileAccount(w, r, tail)
+		return
+	case "/close":
+		h.closeAccount(w, r, tail)
+		return
+	case "/reopen":
+		h.reopenAccount(w, r, tail)
+		return
+	}
+
+	itemHandlers{
+		create: h.createAccount,
+		get:    h.getAccount,
+		patch:  h.patchAccount,
+		delete: h.deleteAccount,
+	}.serve(w, r, tail)
+}
+
+func validateAccount(a jsonAccount) error {
+	if a.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if a.Class > model.AT_CREDITCARD {
+		return fmt.Errorf("unknown account class %d", a.Class)
+	}
+	return nil
+}
+
+func (h *APIHandler) createAccount(w http.ResponseWriter, r *http.Request) {
+	ja := jsonAccount{}
+	if !readJSON(w, r, &ja) {
+		return
+	}
+	if err := validateAccount(ja); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	a := ja.model()
+	a.ID = 0
+	if err := h.sdb.NewAccount(&a); err != nil {
+		writeDBError(w, err, "new account")
+		return
+	}
+
+	sbal := 0
+	if ja.StartingBalance != nil {
+		sbal = *ja.StartingBalance
+		if err := h.sdb.SetStartingBalance(a.ID, sbal); err != nil {
+			writeDBError(w, err, fmt.Sprintf("starting balance of account %d", a.ID))
+			return
+		}
+	}
+
+	ret := toJSONAccount(a)
+	ret.StartingBalance = &sbal
+	created(w, "account", a.ID, ret)
+}
+
+func (h *APIHandler) getAccount(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	acct, err := h.sdb.GetAccount(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	sbal, err := h.sdb.GetStartingBalance(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("starting balance of account %d", id))
+		return
+	}
+
+	summ, err := h.sdb.GetAccountSummary(month, id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("summary of account %d", id))
+		return
+	}
+
+	trans, err := h.sdb.GetAccountTransactions(month, id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("transactions of account %d", id))
+		return
+	}
+
+	ja := toJSONAccount(acct)
+	ja.StartingBalance = &sbal
+
+	jts := make([]jsonAccountTransaction, 0, len(trans))
+	for _, at := range trans {
+		jts = append(jts, toJSONAccountTransaction(at))
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Account      jsonAccount              `json:"account"`
+		Summary      jsonAccountSummary       `json:"summary"`
+		Transactions []jsonAccountTransaction `json:"transactions"`
+	}{
+		Account:      ja,
+		Summary:      toJSONAccountSummary(summ),
+		Transactions: jts,
+	})
+}
+
+func (h *APIHandler) patchAccount(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	acct, err := h.sdb.GetAccount(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	ja := toJSONAccount(acct)
+	if !readJSON(w, r, &ja) {
+		return
+	}
+
+	if ja.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	ja.Closed = nullToDate(acct.Closed)
+	// Changing type would invalidate how the existing history was budgeted
+	if ja.Class != acct.Class {
+		writeError(w, http.StatusBadRequest, "class cannot be changed")
+		return
+	}
+	if err := validateAccount(ja); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
 
-	// TODO: GET takes account id, returns transactions
-	// TODO: POST creates a new account
-	// TODO: DELETE deletes an account and all associated data (eek!)
-	// TODO: PATCH updates an accounts info -- does not allow type changes
+	if err := h.sdb.UpdateAccount(ja.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	if ja.StartingBalance != nil {
+		if err := h.sdb.SetStartingBalance(id, *ja.StartingBalance); err != nil {
+			writeDBError(w, err, fmt.Sprintf("starting balance of account %d", id))
+			return
+		}
+	} else {
+		sbal, err := h.sdb.GetStartingBalance(id)
+		if err != nil {
+			writeDBError(w, err, fmt.Sprintf("starting balance of account %d", id))
+			return
+		}
+		ja.StartingBalance = &sbal
+	}
+
+	writeJSON(w, http.StatusOK, ja)
+}
+
+func (h *APIHandler) deleteAccount(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	// Deletes the account and all associated data (eek!)
+	if _, err := h.sdb.GetAccount(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	if err := h.sdb.DeleteAccount(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Closes the account on the given date, returns it as it stands after
+// A balance left on the account needs transferTo, envelopeId picks the transfer's envelope as for any transfer
+func (h *APIHandler) closeAccount(w http.ResponseWriter, r *http.Request, tail string) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id, _, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	jc := jsonClose{}
+	if !readJSON(w, r, &jc) {
+		return
+	}
+	if !validDate(jc.Date) {
+		writeError(w, http.StatusBadRequest, "date must be YYYYMMDD, got %d", jc.Date)
+		return
+	}
+	if jc.TransferTo != nil {
+		if _, err := h.sdb.GetAccount(*jc.TransferTo); err != nil {
+			writeError(w, http.StatusBadRequest, "account %d does not exist", *jc.TransferTo)
+			return
+		}
+	}
+	if jc.EnvelopeID != nil {
+		if _, err := h.sdb.GetEnvelope(*jc.EnvelopeID); err != nil {
+			writeError(w, http.StatusBadRequest, "envelope %d does not exist", *jc.EnvelopeID)
+			return
+		}
+	}
+
+	c := db.Closing{AccountID: id, Date: jc.Date, TransferTo: pkeyToNull(jc.TransferTo), EnvelopeID: pkeyToNull(jc.EnvelopeID)}
+	if err := h.sdb.CloseAccount(c); err != nil {
+		writeDBError(w, err, fmt.Sprintf("close account %d", id))
+		return
+	}
+
+	h.writeAccount(w, id)
+}
+
+func (h *APIHandler) reopenAccount(w http.ResponseWriter, r *http.Request, tail string) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id, _, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.ReopenAccount(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("reopen account %d", id))
+		return
+	}
+
+	h.writeAccount(w, id)
+}
+
+func (h *APIHandler) writeAccount(w http.ResponseWriter, id model.PKEY) {
+	acct, err := h.sdb.GetAccount(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONAccount(acct))
+}
+
+// Clears the listed transactions and locks every cleared one up to the statement date
+// A statement that does not balance is a 409 unless adjust asks for a TT_ADJUST making up the difference
+func (h *APIHandler) reconcileAccount(w http.ResponseWriter, r *http.Request, tail string) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id, _, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	jr := jsonReconcile{}
+	if !readJSON(w, r, &jr) {
+		return
+	}
+	if !validDate(jr.Date) {
+		writeError(w, http.StatusBadRequest, "date must be YYYYMMDD, got %d", jr.Date)
+		return
+	}
+	if _, err := h.sdb.GetAccount(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("account %d", id))
+		return
+	}
+
+	res, err := h.sdb.Reconcile(db.Reconciliation{AccountID: id, Date: jr.Date, Balance: jr.Balance, Cleared: jr.Cleared, Adjust: jr.Adjust})
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("reconcile account %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jsonReconcileResult{
+		ClearedBalance: res.ClearedBalance,
+		Difference:     res.Difference,
+		AdjustmentID:   nullToPKEY(res.Adjustment),
+		Reconciled:     res.Reconciled,
+	})
+}
+
+// Account Transactions
+
+// GET lists the month's transactions
+// /api/transactions/duplicates lists stored pairs that look like the same money twice on GET, and checks a batch against the stored transactions on POST
+func (h *APIHandler) ServeHTTP_transactions(w http.ResponseWriter, r *http.Request, tail string) {
+	if tail == "/duplicates" {
+		h.duplicates(w, r)
+		return
+	}
+	if tail != "/" {
+		writeError(w, http.StatusNotFound, "no such endpoint: %s", r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	trans, err := h.sdb.GetAllTransactions(month)
+	if err != nil {
+		writeDBError(w, err, "transaction list")
+		return
+	}
+
+	ret := make([]jsonAccountTransaction, 0, len(trans))
+	for _, at := range trans {
+		ret = append(ret, toJSONAccountTransaction(at))
+	}
+
+	writeJSON(w, http.StatusOK, ret)
 }
 
-func (h *APIHandler) ServeHTTP_transactions(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("/api/transactions"))
+func (h *APIHandler) duplicates(w http.ResponseWriter, r *http.Request) {
+	var ds []db.Duplicate
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		if ds, err = h.sdb.GetDuplicates(); err != nil {
+			writeDBError(w, err, "duplicate list")
+			return
+		}
+	case http.MethodPost:
+		jts := make([]jsonAccountTransaction, 0)
+		if !readJSON(w, r, &jts) {
+			return
+		}
+		ats := make([]model.AccountTransaction, 0, len(jts))
+		for _, jt := range jts {
+			ats = append(ats, jt.model())
+		}
+		if ds, err = h.sdb.FindDuplicates(ats); err != nil {
+			writeDBError(w, err, "duplicate check")
+			return
+		}
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	ret := make([]jsonDuplicate, 0, len(ds))
+	for _, d := range ds {
+		ret = append(ret, jsonDuplicate{
+			Index:       d.Index,
+			Score:       d.Score,
+			Transaction: toJSONAccountTransaction(d.Transaction),
+			Match:       toJSONAccountTransaction(d.Match),
+		})
+	}
 
-	// TODO: Return full transaction list
+	writeJSON(w, http.StatusOK, ret)
 }
 
 func (h *APIHandler) ServeHTTP_transaction(w http.ResponseWriter, r *http.Request, tail string) {
-	w.Write([]byte("/api/transaction"))
+	switch _, rest := shiftpath.ShiftPath(tail); rest {
+	case "/reconcile":
+		h.lockTransaction(w, r, tail, true)
+		return
+	case "/unreconcile":
+		h.lockTransaction(w, r, tail, false)
+		return
+	}
 
-	// TODO: POST creates a new transaction
-	// TODO: DELETE deletes a transaction
-	// TODO: PATCH updates a transaction by removing old, and creating new
+	itemHandlers{
+		create: h.createTransaction,
+		get:    h.getTransaction,
+		patch:  h.patchTransaction,
+		delete: h.deleteTransaction,
+	}.serve(w, r, tail)
 }
 
-func (h *APIHandler) ServeHTTP_envelopes(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("/api/envelopes"))
+// Checks needing the DB are reported as bad requests, the caller pointed at something that is not there
+func (h *APIHandler) validateTransaction(at jsonAccountTransaction) error {
+	if at.Typ > model.TT_ADJUST {
+		return fmt.Errorf("unknown transaction type %d", at.Typ)
+	}
+	if !validDate(at.PostDate) {
+		return fmt.Errorf("postDate must be YYYYMMDD, got %d", at.PostDate)
+	}
+	if _, err := h.sdb.GetAccount(at.AccountID); err != nil {
+		return fmt.Errorf("account %d does not exist", at.AccountID)
+	}
+	if at.EnvelopeID != nil {
+		if _, err := h.sdb.GetEnvelope(*at.EnvelopeID); err != nil {
+			return fmt.Errorf("envelope %d does not exist", *at.EnvelopeID)
+		}
+	}
+	if at.PayeeID != nil {
+		if _, err := h.sdb.GetPayee(*at.PayeeID); err != nil {
+			return fmt.Errorf("payee %d does not exist", *at.PayeeID)
+		}
+	}
+	// Sums and shapes of splits are the DB's rules, only references are checked here
+	for _, s := range at.Splits {
+		if s.EnvelopeID != nil {
+			if _, err := h.sdb.GetEnvelope(*s.EnvelopeID); err != nil {
+				return fmt.Errorf("split envelope %d does not exist", *s.EnvelopeID)
+			}
+		}
+	}
+	return nil
+}
+
+// One that looks like a transaction already stored answers 409, ?allowDuplicate=true enters it anyway
+func (h *APIHandler) createTransaction(w http.ResponseWriter, r *http.Request) {
+	jt := jsonAccountTransaction{}
+	if !readJSON(w, r, &jt) {
+		return
+	}
+	if err := h.validateTransaction(jt); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
 
-	// TODO: Return envelope list
+	at := jt.model()
+	at.ID = 0
+
+	// Something that looks like a transaction already there is refused, ?allowDuplicate=true enters it anyway
+	allow := false
+	if v := r.URL.Query().Get("allowDuplicate"); v != "" {
+		var err error
+		if allow, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "allowDuplicate must be true or false, got %q", v)
+			return
+		}
+	}
+	if !allow {
+		ds, err := h.sdb.FindDuplicates([]model.AccountTransaction{at})
+		if err != nil {
+			writeDBError(w, err, "duplicate check")
+			return
+		}
+		if len(ds) > 0 {
+			writeError(w, http.StatusConflict, "new transaction looks like transaction %d, post with ?allowDuplicate=true to enter it anyway", ds[0].Match.ID)
+			return
+		}
+	}
+
+	if err := h.sdb.NewAccountTransaction(&at); err != nil {
+		writeDBError(w, err, "new transaction")
+		return
+	}
+
+	created(w, "transaction", at.ID, toJSONAccountTransaction(at))
 }
 
-func (h *APIHandler) ServeHTTP_envelope(w http.ResponseWriter, r *http.Request, tail string) {
-	w.Write([]byte("/api/envelope"))
+func (h *APIHandler) getTransaction(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	at, err := h.sdb.GetAccountTransaction(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("transaction %d", id))
+		return
+	}
 
-	// TODO: Return envelope transaction list
+	writeJSON(w, http.StatusOK, toJSONAccountTransaction(at))
 }
 
-func (h *APIHandler) ServeHTTP_sanity(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("/api/sanity"))
+// Patching accountId moves the transaction, the old one is deleted and a new one created on the other account
+// That answers 201 with the new item and its Location, transfer legs and reconciled transactions cannot move
+func (h *APIHandler) patchTransaction(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	at, err := h.sdb.GetAccountTransaction(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("transaction %d", id))
+		return
+	}
+
+	jt := toJSONAccountTransaction(at)
+	if !readJSON(w, r, &jt) {
+		return
+	}
+
+	if jt.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	if err := h.validateTransaction(jt); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	// Another account removes the old transaction and creates a new one, answered like a POST
+	if jt.AccountID != at.AccountID {
+		moved := jt.model()
+		if err := h.sdb.MoveAccountTransaction(&moved); err != nil {
+			writeDBError(w, err, fmt.Sprintf("transaction %d", id))
+			return
+		}
+		if moved, err = h.sdb.GetAccountTransaction(moved.ID); err != nil {
+			writeDBError(w, err, fmt.Sprintf("transaction %d", moved.ID))
+			return
+		}
+		created(w, "transaction", moved.ID, toJSONAccountTransaction(moved))
+		return
+	}
+
+	if err := h.sdb.UpdateAccountTransaction(jt.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("transaction %d", id))
+		return
+	}
+
+	// Read it back, replaced splits have new IDs
+	h.getTransaction(w, r, id)
+}
+
+func (h *APIHandler) deleteTransaction(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	if err := h.sdb.DeleteAccountTransaction(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("transaction %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lock or unlock one transaction by hand, returns it as it stands after
+func (h *APIHandler) lockTransaction(w http.ResponseWriter, r *http.Request, tail string, reconciled bool) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id, _, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.SetReconciled(id, reconciled); err != nil {
+		writeDBError(w, err, fmt.Sprintf("transaction %d", id))
+		return
+	}
+
+	h.getTransaction(w, r, id)
+}
+
+// Payees
+
+func (h *APIHandler) ServeHTTP_payees(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	ps, err := h.sdb.GetPayees()
+	if err != nil {
+		writeDBError(w, err, "payee list")
+		return
+	}
+
+	ret := make([]jsonPayee, 0, len(ps))
+	for _, p := range ps {
+		ret = append(ret, toJSONPayee(p))
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *APIHandler) ServeHTTP_payee(w http.ResponseWriter, r *http.Request, tail string) {
+	itemHandlers{
+		create: h.createPayee,
+		get:    h.getPayee,
+		patch:  h.patchPayee,
+		delete: h.deletePayee,
+	}.serve(w, r, tail)
+}
+
+// Returns the status to fail with, names are unique so a clash is a conflict rather than a bad request
+func (h *APIHandler) validatePayee(p jsonPayee) (int, error) {
+	if p.Name == "" {
+		return http.StatusBadRequest, fmt.Errorf("name is required")
+	}
+	if p.EnvelopeID != nil {
+		if _, err := h.sdb.GetEnvelope(*p.EnvelopeID); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("envelope %d does not exist", *p.EnvelopeID)
+		}
+	}
+	if other, err := h.sdb.GetPayeeByName(p.Name); err == nil && other.ID != p.ID {
+		return http.StatusConflict, fmt.Errorf("payee %q already exists as %d", p.Name, other.ID)
+	}
+	return http.StatusOK, nil
+}
+
+func (h *APIHandler) createPayee(w http.ResponseWriter, r *http.Request) {
+	jp := jsonPayee{}
+	if !readJSON(w, r, &jp) {
+		return
+	}
+	jp.ID = 0
+	if status, err := h.validatePayee(jp); err != nil {
+		writeError(w, status, "%s", err.Error())
+		return
+	}
+
+	p := jp.model()
+	if err := h.sdb.NewPayee(&p); err != nil {
+		writeDBError(w, err, "new payee")
+		return
+	}
+
+	created(w, "payee", p.ID, toJSONPayee(p))
+}
+
+func (h *APIHandler) getPayee(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	p, err := h.sdb.GetPayee(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("payee %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONPayee(p))
+}
+
+func (h *APIHandler) patchPayee(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	p, err := h.sdb.GetPayee(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("payee %d", id))
+		return
+	}
+
+	jp := toJSONPayee(p)
+	if !readJSON(w, r, &jp) {
+		return
+	}
+
+	if jp.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	if status, err := h.validatePayee(jp); err != nil {
+		writeError(w, status, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.UpdatePayee(jp.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("payee %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jp)
+}
+
+func (h *APIHandler) deletePayee(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	if _, err := h.sdb.GetPayee(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("payee %d", id))
+		return
+	}
+
+	if err := h.sdb.DeletePayee(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("payee %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Rules
+
+// GET lists the rules in the order they run, POST /api/rules/preview dry runs them over a batch of transactions
+func (h *APIHandler) ServeHTTP_rules(w http.ResponseWriter, r *http.Request, tail string) {
+	if tail == "/preview" {
+		h.previewRules(w, r)
+		return
+	}
+	if tail != "/" {
+		writeError(w, http.StatusNotFound, "no such endpoint: %s", r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	rs, err := h.sdb.GetRules()
+	if err != nil {
+		writeDBError(w, err, "rule list")
+		return
+	}
+
+	ret := make([]jsonRule, 0, len(rs))
+	for _, rule := range rs {
+		ret = append(ret, toJSONRule(rule))
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *APIHandler) previewRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	jts := make([]jsonAccountTransaction, 0)
+	if !readJSON(w, r, &jts) {
+		return
+	}
+
+	ats := make([]model.AccountTransaction, 0, len(jts))
+	for _, jt := range jts {
+		ats = append(ats, jt.model())
+	}
+
+	ms, err := h.sdb.PreviewRules(ats)
+	if err != nil {
+		writeDBError(w, err, "rule preview")
+		return
+	}
+
+	ret := make([]jsonRuleMatch, 0, len(ms))
+	for _, m := range ms {
+		ret = append(ret, jsonRuleMatch{
+			Index: m.Index,
+			Rules: m.Rules,
+			After: toJSONAccountTransaction(m.After),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *APIHandler) ServeHTTP_rule(w http.ResponseWriter, r *http.Request, tail string) {
+	itemHandlers{
+		create: h.createRule,
+		get:    h.getRule,
+		patch:  h.patchRule,
+		delete: h.deleteRule,
+	}.serve(w, r, tail)
+}
+
+// Shapes of rules are the DB's to check, only references and dates are checked here
+func (h *APIHandler) validateRule(rule jsonRule) error {
+	if rule.AccountID != nil {
+		if _, err := h.sdb.GetAccount(*rule.AccountID); err != nil {
+			return fmt.Errorf("account %d does not exist", *rule.AccountID)
+		}
+	}
+	if rule.EnvelopeID != nil {
+		if _, err := h.sdb.GetEnvelope(*rule.EnvelopeID); err != nil {
+			return fmt.Errorf("envelope %d does not exist", *rule.EnvelopeID)
+		}
+	}
+	if rule.FromDate != nil && !validDate(*rule.FromDate) {
+		return fmt.Errorf("fromDate must be YYYYMMDD, got %d", *rule.FromDate)
+	}
+	if rule.ToDate != nil && !validDate(*rule.ToDate) {
+		return fmt.Errorf("toDate must be YYYYMMDD, got %d", *rule.ToDate)
+	}
+	return nil
+}
+
+func (h *APIHandler) createRule(w http.ResponseWriter, r *http.Request) {
+	jr := jsonRule{}
+	if !readJSON(w, r, &jr) {
+		return
+	}
+	jr.ID = 0
+	if err := h.validateRule(jr); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	rule := jr.model()
+	if err := h.sdb.NewRule(&rule); err != nil {
+		writeDBError(w, err, "new rule")
+		return
+	}
+
+	created(w, "rule", rule.ID, toJSONRule(rule))
+}
+
+func (h *APIHandler) getRule(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	rule, err := h.sdb.GetRule(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("rule %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONRule(rule))
+}
+
+func (h *APIHandler) patchRule(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	rule, err := h.sdb.GetRule(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("rule %d", id))
+		return
+	}
 
-	// TODO: Run sanity checks on the database to ensure all temp values are correct
+	jr := toJSONRule(rule)
+	if !readJSON(w, r, &jr) {
+		return
+	}
+
+	if jr.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	if err := h.validateRule(jr); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.UpdateRule(jr.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("rule %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jr)
+}
+
+func (h *APIHandler) deleteRule(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	if _, err := h.sdb.GetRule(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("rule %d", id))
+		return
+	}
+
+	if err := h.sdb.DeleteRule(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("rule %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Schedules
+
+// GET lists every schedule, the server enters their occurrences as they come due
+func (h *APIHandler) ServeHTTP_schedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	ss, err := h.sdb.GetSchedules()
+	if err != nil {
+		writeDBError(w, err, "schedule list")
+		return
+	}
+
+	ret := make([]jsonSchedule, 0, len(ss))
+	for _, s := range ss {
+		ret = append(ret, toJSONSchedule(s))
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// POST /api/schedule/<id>/skip passes over the next occurrence without entering it
+func (h *APIHandler) ServeHTTP_schedule(w http.ResponseWriter, r *http.Request, tail string) {
+	if _, rest := shiftpath.ShiftPath(tail); rest == "/skip" {
+		h.skipSchedule(w, r, tail)
+		return
+	}
+
+	itemHandlers{
+		create: h.createSchedule,
+		get:    h.getSchedule,
+		patch:  h.patchSchedule,
+		delete: h.deleteSchedule,
+	}.serve(w, r, tail)
+}
+
+// Shapes of recurrences are the DB's to check, only references and dates are checked here
+func (h *APIHandler) validateSchedule(s jsonSchedule) error {
+	if _, err := h.sdb.GetAccount(s.AccountID); err != nil {
+		return fmt.Errorf("account %d does not exist", s.AccountID)
+	}
+	if s.EnvelopeID != nil {
+		if _, err := h.sdb.GetEnvelope(*s.EnvelopeID); err != nil {
+			return fmt.Errorf("envelope %d does not exist", *s.EnvelopeID)
+		}
+	}
+	if s.PayeeID != nil {
+		if _, err := h.sdb.GetPayee(*s.PayeeID); err != nil {
+			return fmt.Errorf("payee %d does not exist", *s.PayeeID)
+		}
+	}
+	if !validDate(s.Start) {
+		return fmt.Errorf("start must be YYYYMMDD, got %d", s.Start)
+	}
+	if s.End != nil && !validDate(*s.End) {
+		return fmt.Errorf("end must be YYYYMMDD, got %d", *s.End)
+	}
+	return nil
+}
+
+func (h *APIHandler) createSchedule(w http.ResponseWriter, r *http.Request) {
+	js := jsonSchedule{}
+	if !readJSON(w, r, &js) {
+		return
+	}
+	js.ID, js.Last = 0, bcdate.Epoch()
+	if err := h.validateSchedule(js); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	s := js.model()
+	if err := h.sdb.NewSchedule(&s); err != nil {
+		writeDBError(w, err, "new schedule")
+		return
+	}
+
+	created(w, "schedule", s.ID, toJSONSchedule(s))
+}
+
+func (h *APIHandler) getSchedule(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	s, err := h.sdb.GetSchedule(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONSchedule(s))
+}
+
+func (h *APIHandler) patchSchedule(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	s, err := h.sdb.GetSchedule(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	js := toJSONSchedule(s)
+	if !readJSON(w, r, &js) {
+		return
+	}
+
+	if js.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	js.Last = s.Last
+	if err := h.validateSchedule(js); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	s = js.model()
+	if err := h.sdb.UpdateSchedule(s); err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONSchedule(s))
+}
+
+func (h *APIHandler) deleteSchedule(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	if _, err := h.sdb.GetSchedule(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	if err := h.sdb.DeleteSchedule(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the schedule as it stands after the skip
+func (h *APIHandler) skipSchedule(w http.ResponseWriter, r *http.Request, tail string) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id, _, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if _, err := h.sdb.SkipSchedule(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	s, err := h.sdb.GetSchedule(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("schedule %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONSchedule(s))
+}
+
+// Transfers
+
+func (h *APIHandler) ServeHTTP_transfer(w http.ResponseWriter, r *http.Request, tail string) {
+	itemHandlers{
+		create: h.createTransfer,
+		get:    h.getTransfer,
+		patch:  h.patchTransfer,
+		delete: h.deleteTransfer,
+	}.serve(w, r, tail)
+}
+
+// Which transfers need an envelope is the DB's rule, only references are checked here
+func (h *APIHandler) validateTransfer(t jsonTransfer) error {
+	if !validDate(t.PostDate) {
+		return fmt.Errorf("postDate must be YYYYMMDD, got %d", t.PostDate)
+	}
+	if _, err := h.sdb.GetAccount(t.FromAccountID); err != nil {
+		return fmt.Errorf("account %d does not exist", t.FromAccountID)
+	}
+	if _, err := h.sdb.GetAccount(t.ToAccountID); err != nil {
+		return fmt.Errorf("account %d does not exist", t.ToAccountID)
+	}
+	if t.EnvelopeID != nil {
+		if _, err := h.sdb.GetEnvelope(*t.EnvelopeID); err != nil {
+			return fmt.Errorf("envelope %d does not exist", *t.EnvelopeID)
+		}
+	}
+	return nil
+}
+
+func (h *APIHandler) createTransfer(w http.ResponseWriter, r *http.Request) {
+	jt := jsonTransfer{}
+	if !readJSON(w, r, &jt) {
+		return
+	}
+	if err := h.validateTransfer(jt); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	t := jt.model()
+	t.ID = 0
+	if err := h.sdb.NewTransfer(&t); err != nil {
+		writeDBError(w, err, "new transfer")
+		return
+	}
+
+	created(w, "transfer", t.ID, toJSONTransfer(t))
+}
+
+func (h *APIHandler) getTransfer(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	t, err := h.sdb.GetTransfer(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("transfer %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONTransfer(t))
+}
+
+func (h *APIHandler) patchTransfer(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	t, err := h.sdb.GetTransfer(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("transfer %d", id))
+		return
+	}
+
+	jt := toJSONTransfer(t)
+	if !readJSON(w, r, &jt) {
+		return
+	}
+
+	if jt.ID != id || jt.FromID != t.FromID || jt.ToID != t.ToID {
+		writeError(w, http.StatusBadRequest, "id, fromId and toId cannot be changed")
+		return
+	}
+	if err := h.validateTransfer(jt); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.UpdateTransfer(jt.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("transfer %d", id))
+		return
+	}
+
+	// Read it back, the DB may have picked the envelope
+	h.getTransfer(w, r, id)
+}
+
+func (h *APIHandler) deleteTransfer(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	if err := h.sdb.DeleteTransfer(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("transfer %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Envelope Groups
+
+func (h *APIHandler) ServeHTTP_groups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	egs, err := h.sdb.GetEnvelopeGroups()
+	if err != nil {
+		writeDBError(w, err, "envelope group list")
+		return
+	}
+
+	ret := make([]jsonEnvelopeGroup, 0, len(egs))
+	for _, eg := range egs {
+		ret = append(ret, toJSONEnvelopeGroup(eg))
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *APIHandler) ServeHTTP_group(w http.ResponseWriter, r *http.Request, tail string) {
+	itemHandlers{
+		create: h.createGroup,
+		get:    h.getGroup,
+		patch:  h.patchGroup,
+		delete: h.deleteGroup,
+	}.serve(w, r, tail)
+}
+
+func (h *APIHandler) createGroup(w http.ResponseWriter, r *http.Request) {
+	jg := jsonEnvelopeGroup{}
+	if !readJSON(w, r, &jg) {
+		return
+	}
+	if jg.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	eg := jg.model()
+	eg.ID = 0
+	if err := h.sdb.NewEnvelopeGroup(&eg); err != nil {
+		writeDBError(w, err, "new envelope group")
+		return
+	}
+
+	created(w, "group", eg.ID, toJSONEnvelopeGroup(eg))
+}
+
+func (h *APIHandler) getGroup(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	eg, err := h.sdb.GetEnvelopeGroup(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope group %d", id))
+		return
+	}
+
+	es, err := h.sdb.GetEnvelopesInGroup(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelopes in group %d", id))
+		return
+	}
+
+	type esum struct {
+		Envelope jsonEnvelope        `json:"envelope"`
+		Summary  jsonEnvelopeSummary `json:"summary"`
+	}
+
+	ess := make([]esum, 0, len(es))
+	for _, e := range es {
+		s, err := h.sdb.GetEnvelopeSummary(month, e.ID)
+		if err != nil {
+			writeDBError(w, err, fmt.Sprintf("summary of envelope %d", e.ID))
+			return
+		}
+		ess = append(ess, esum{toJSONEnvelope(e), toJSONEnvelopeSummary(s)})
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Group     jsonEnvelopeGroup `json:"group"`
+		Envelopes []esum            `json:"envelopes"`
+	}{
+		Group:     toJSONEnvelopeGroup(eg),
+		Envelopes: ess,
+	})
+}
+
+func (h *APIHandler) patchGroup(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	eg, err := h.sdb.GetEnvelopeGroup(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope group %d", id))
+		return
+	}
+
+	jg := toJSONEnvelopeGroup(eg)
+	if !readJSON(w, r, &jg) {
+		return
+	}
+
+	if jg.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	if jg.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	if err := h.sdb.UpdateEnvelopeGroup(jg.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope group %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jg)
+}
+
+func (h *APIHandler) deleteGroup(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	// Envelopes of a deleted group fall back to the first group, so that one has to stay
+	if id == 1 {
+		writeError(w, http.StatusConflict, "envelope group 1 holds envelopes of deleted groups and cannot be deleted")
+		return
+	}
+
+	if _, err := h.sdb.GetEnvelopeGroup(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope group %d", id))
+		return
+	}
+
+	if err := h.sdb.DeleteEnvelopeGroup(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope group %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Envelopes
+
+func (h *APIHandler) ServeHTTP_envelopes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	type esum struct {
+		Envelope jsonEnvelope        `json:"envelope"`
+		Summary  jsonEnvelopeSummary `json:"summary"`
+	}
+
+	es, err := h.sdb.GetEnvelopes()
+	if err != nil {
+		writeDBError(w, err, "envelope list")
+		return
+	}
+
+	ret := make([]esum, 0, len(es))
+	for _, e := range es {
+		s, err := h.sdb.GetEnvelopeSummary(month, e.ID)
+		if err != nil {
+			writeDBError(w, err, fmt.Sprintf("summary of envelope %d", e.ID))
+			return
+		}
+		ret = append(ret, esum{toJSONEnvelope(e), toJSONEnvelopeSummary(s)})
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *APIHandler) ServeHTTP_envelope(w http.ResponseWriter, r *http.Request, tail string) {
+	itemHandlers{
+		create: h.createEnvelope,
+		get:    h.getEnvelope,
+		patch:  h.patchEnvelope,
+		delete: h.deleteEnvelope,
+	}.serve(w, r, tail)
+}
+
+func (h *APIHandler) validateEnvelope(e jsonEnvelope) error {
+	if e.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if e.Goal > model.GT_RECTIL {
+		return fmt.Errorf("unknown goal type %d", e.Goal)
+	}
+	if _, err := h.sdb.GetEnvelopeGroup(e.GroupID); err != nil {
+		return fmt.Errorf("envelope group %d does not exist", e.GroupID)
+	}
+	return nil
+}
+
+func (h *APIHandler) createEnvelope(w http.ResponseWriter, r *http.Request) {
+	je := jsonEnvelope{}
+	if !readJSON(w, r, &je) {
+		return
+	}
+	// Debt envelopes come and go with their accounts
+	if je.DebtAccount != nil {
+		writeError(w, http.StatusBadRequest, "debt envelopes are created by marking an account as debt")
+		return
+	}
+	if err := h.validateEnvelope(je); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	e := je.model()
+	e.ID = 0
+	if err := h.sdb.NewEnvelope(&e); err != nil {
+		writeDBError(w, err, "new envelope")
+		return
+	}
+
+	created(w, "envelope", e.ID, toJSONEnvelope(e))
+}
+
+func (h *APIHandler) getEnvelope(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	e, err := h.sdb.GetEnvelope(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope %d", id))
+		return
+	}
+
+	summ, err := h.sdb.GetEnvelopeSummary(month, id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("summary of envelope %d", id))
+		return
+	}
+
+	trans, err := h.sdb.GetEnvelopeTransactions(month, id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("transactions of envelope %d", id))
+		return
+	}
+
+	jts := make([]jsonEnvelopeTransaction, 0, len(trans))
+	for _, et := range trans {
+		jts = append(jts, toJSONEnvelopeTransaction(et))
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Envelope     jsonEnvelope              `json:"envelope"`
+		Summary      jsonEnvelopeSummary       `json:"summary"`
+		Transactions []jsonEnvelopeTransaction `json:"transactions"`
+	}{
+		Envelope:     toJSONEnvelope(e),
+		Summary:      toJSONEnvelopeSummary(summ),
+		Transactions: jts,
+	})
+}
+
+func (h *APIHandler) patchEnvelope(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	e, err := h.sdb.GetEnvelope(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope %d", id))
+		return
+	}
+
+	je := toJSONEnvelope(e)
+	if !readJSON(w, r, &je) {
+		return
+	}
+
+	if je.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	if pkeyToNull(je.DebtAccount) != e.DebtAccount {
+		writeError(w, http.StatusBadRequest, "debtAccount cannot be changed")
+		return
+	}
+	if err := h.validateEnvelope(je); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.UpdateEnvelope(je.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, je)
+}
+
+func (h *APIHandler) deleteEnvelope(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	e, err := h.sdb.GetEnvelope(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope %d", id))
+		return
+	}
+	if e.DebtAccount.Valid {
+		writeError(w, http.StatusConflict, "envelope %d belongs to debt account %d, clear the debt flag on the account instead", id, e.DebtAccount.Int32)
+		return
+	}
+
+	if err := h.sdb.DeleteEnvelope(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Envelope Transactions
+// Listed per envelope and month by GET /api/envelope/<id>
+
+func (h *APIHandler) ServeHTTP_envelope_transaction(w http.ResponseWriter, r *http.Request, tail string) {
+	itemHandlers{
+		create: h.createEnvelopeTransaction,
+		get:    h.getEnvelopeTransaction,
+		patch:  h.patchEnvelopeTransaction,
+		delete: h.deleteEnvelopeTransaction,
+	}.serve(w, r, tail)
+}
+
+func (h *APIHandler) validateEnvelopeTransaction(et jsonEnvelopeTransaction) error {
+	if !validDate(et.PostDate) {
+		return fmt.Errorf("postDate must be YYYYMMDD, got %d", et.PostDate)
+	}
+	if _, err := h.sdb.GetEnvelope(et.EnvelopeID); err != nil {
+		return fmt.Errorf("envelope %d does not exist", et.EnvelopeID)
+	}
+	return nil
+}
+
+func (h *APIHandler) createEnvelopeTransaction(w http.ResponseWriter, r *http.Request) {
+	jt := jsonEnvelopeTransaction{}
+	if !readJSON(w, r, &jt) {
+		return
+	}
+	if err := h.validateEnvelopeTransaction(jt); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	et := jt.model()
+	et.ID = 0
+	if err := h.sdb.NewEnvelopeTransaction(&et); err != nil {
+		writeDBError(w, err, "new envelope transaction")
+		return
+	}
+
+	created(w, "envelope_transaction", et.ID, toJSONEnvelopeTransaction(et))
+}
+
+func (h *APIHandler) getEnvelopeTransaction(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	et, err := h.sdb.GetEnvelopeTransaction(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope transaction %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONEnvelopeTransaction(et))
+}
+
+func (h *APIHandler) patchEnvelopeTransaction(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	et, err := h.sdb.GetEnvelopeTransaction(id)
+	if err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope transaction %d", id))
+		return
+	}
+
+	jt := toJSONEnvelopeTransaction(et)
+	if !readJSON(w, r, &jt) {
+		return
+	}
+
+	if jt.ID != id {
+		writeError(w, http.StatusBadRequest, "id cannot be changed")
+		return
+	}
+	if jt.EnvelopeID != et.EnvelopeID {
+		writeError(w, http.StatusBadRequest, "envelopeId cannot be changed")
+		return
+	}
+	if err := h.validateEnvelopeTransaction(jt); err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := h.sdb.UpdateEnvelopeTransaction(jt.model()); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope transaction %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jt)
+}
+
+func (h *APIHandler) deleteEnvelopeTransaction(w http.ResponseWriter, r *http.Request, id model.PKEY) {
+	if err := h.sdb.DeleteEnvelopeTransaction(id); err != nil {
+		writeDBError(w, err, fmt.Sprintf("envelope transaction %d", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Budget
+
+// GET the month's budget, or POST an assign, move or fund to change it, each answers with the budget as it is afterwards
+func (h *APIHandler) ServeHTTP_budget(w http.ResponseWriter, r *http.Request, tail string) {
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	if tail == "/" {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.getBudget(w, month)
+		return
+	}
+
+	var action func(w http.ResponseWriter, r *http.Request, month bcdate.BCDate) bool
+	switch tail {
+	case "/assign":
+		action = h.assignBudget
+	case "/move":
+		action = h.moveBudget
+	case "/fund":
+		action = h.fundBudget
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint: %s", r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if action(w, r, month) {
+		h.getBudget(w, month)
+	}
+}
+
+func (h *APIHandler) getBudget(w http.ResponseWriter, month bcdate.BCDate) {
+	b, err := budget.Get(h.sdb, month)
+	if err != nil {
+		writeDBError(w, err, "budget")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toJSONBudget(b))
+}
+
+func (h *APIHandler) assignBudget(w http.ResponseWriter, r *http.Request, month bcdate.BCDate) bool {
+	ja := jsonAssign{}
+	if !readJSON(w, r, &ja) {
+		return false
+	}
+	if _, err := h.sdb.GetEnvelope(ja.EnvelopeID); err != nil {
+		writeError(w, http.StatusBadRequest, "envelope %d does not exist", ja.EnvelopeID)
+		return false
+	}
+
+	if _, err := budget.Assign(h.sdb, month, ja.EnvelopeID, ja.Amount); err != nil {
+		writeDBError(w, err, "assign")
+		return false
+	}
+	return true
+}
+
+func (h *APIHandler) moveBudget(w http.ResponseWriter, r *http.Request, month bcdate.BCDate) bool {
+	jm := jsonMove{}
+	if !readJSON(w, r, &jm) {
+		return false
+	}
+	for _, id := range []model.PKEY{jm.FromID, jm.ToID} {
+		if _, err := h.sdb.GetEnvelope(id); err != nil {
+			writeError(w, http.StatusBadRequest, "envelope %d does not exist", id)
+			return false
+		}
+	}
+
+	if _, err := budget.Move(h.sdb, month, jm.FromID, jm.ToID, jm.Amount); err != nil {
+		writeDBError(w, err, "move")
+		return false
+	}
+	return true
+}
+
+func (h *APIHandler) fundBudget(w http.ResponseWriter, r *http.Request, month bcdate.BCDate) bool {
+	if _, err := budget.FundGoals(h.sdb, month); err != nil {
+		writeDBError(w, err, "fund goals")
+		return false
+	}
+	return true
+}
+
+func (h *APIHandler) ServeHTTP_sanity(w http.ResponseWriter, r *http.Request) {
+	// Run sanity checks on the database to ensure all temp values are correct
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	vs, err := h.sdb.Check()
+	if err != nil {
+		writeDBError(w, err, "sanity check")
+		return
+	}
+
+	ret := make([]jsonViolation, 0, len(vs))
+	for _, v := range vs {
+		ret = append(ret, jsonViolation(v))
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		OK         bool            `json:"ok"`
+		Violations []jsonViolation `json:"violations"`
+	}{
+		OK:         len(ret) == 0,
+		Violations: ret,
+	})
+}
+
+// GET /api/sessions lists the sessions of the logged in user, latest used first, it needs the auth middleware in front
+func (h *APIHandler) ServeHTTP_sessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	uid, ok := auth.GetUserID(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "sessions need the server to run with auth")
+		return
+	}
+
+	ss, err := h.sdb.GetSessions(uid)
+	if err != nil {
+		writeDBError(w, err, "sessions")
+		return
+	}
+
+	cur, _ := session.Get(r)
+	ret := make([]jsonSession, 0, len(ss))
+	for _, s := range ss {
+		ret = append(ret, jsonSession{
+			ID:       s.ID,
+			Created:  s.Created,
+			LastSeen: s.LastSeen,
+			Agent:    s.Agent,
+			Addr:     s.Addr,
+			Current:  s.ID == cur.ID,
+		})
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// DELETE /api/session/<id> logs one of the user's sessions out
+func (h *APIHandler) ServeHTTP_session(w http.ResponseWriter, r *http.Request, tail string) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	id, ok, err := parseID(tail)
+	if err != nil || !ok {
+		writeError(w, http.StatusBadRequest, "DELETE needs a session id")
+		return
+	}
+	uid, ok := auth.GetUserID(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "sessions need the server to run with auth")
+		return
+	}
+
+	// Only the user's own, anyone else's is as good as missing
+	ss, err := h.sdb.GetSessions(uid)
+	if err != nil {
+		writeDBError(w, err, "sessions")
+		return
+	}
+	for _, s := range ss {
+		if s.ID == id {
+			if err := h.sdb.DeleteSession(id); err != nil {
+				writeDBError(w, err, fmt.Sprintf("session %d", id))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "session %d not found", id)
+}
+
+// Every write is logged as an op, /api/undo reverts them
+// GET /api/history lists the latest n ops, 20 unless the n query parameter says otherwise
+// GET /api/history/<id> lists the rows one op changed with their before and after images
+func (h *APIHandler) ServeHTTP_history(w http.ResponseWriter, r *http.Request, tail string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	id, ok, err := parseID(tail)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	if ok {
+		cs, err := h.sdb.GetAuditChanges(id)
+		if err != nil {
+			writeDBError(w, err, "history")
+			return
+		}
+		ret := make([]jsonAuditChange, 0, len(cs))
+		for _, c := range cs {
+			ret = append(ret, jsonAuditChange(c))
+		}
+		writeJSON(w, http.StatusOK, ret)
+		return
+	}
+
+	n := 20
+	if v := r.URL.Query().Get("n"); v != "" {
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "n must be a positive integer, got %q", v)
+			return
+		}
+	}
+
+	ops, err := h.sdb.GetHistory(n)
+	if err != nil {
+		writeDBError(w, err, "history")
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSONAuditOps(ops))
+}
+
+// POST /api/undo reverts the latest n ops not undone yet, n defaults to 1, returns the ops reverted
+func (h *APIHandler) ServeHTTP_undo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	req := struct {
+		N *int `json:"n"`
+	}{}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+	n := 1
+	if req.N != nil {
+		n = *req.N
+	}
+	if n < 1 {
+		writeError(w, http.StatusBadRequest, "n must be a positive integer, got %d", n)
+		return
+	}
+
+	ops, err := h.sdb.Undo(n)
+	if err != nil {
+		writeDBError(w, err, "undo")
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSONAuditOps(ops))
+}
+
+// Maintenance, snapshots go to the backups directory
+// GET /api/admin/backups lists the snapshots, newest first
+// POST /api/admin/backup writes one now and prunes the oldest beyond the retention
+func (h *APIHandler) ServeHTTP_admin(w http.ResponseWriter, r *http.Request, tail string) {
+	head, _ := shiftpath.ShiftPath(tail)
+	if (head == "backups" || head == "backup") && h.backups.Path == "" {
+		writeError(w, http.StatusNotFound, "backups are not configured")
+		return
+	}
+
+	switch head {
+	case "backups":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		ss, err := h.backups.List()
+		if err != nil {
+			writeDBError(w, err, "backup list")
+			return
+		}
+		ret := make([]jsonSnapshot, 0, len(ss))
+		for _, s := range ss {
+			ret = append(ret, toJSONSnapshot(s))
+		}
+		writeJSON(w, http.StatusOK, ret)
+
+	case "backup":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s, err := h.backups.Snapshot(h.sdb, time.Now())
+		if err != nil {
+			writeDBError(w, err, "backup")
+			return
+		}
+		pruned, err := h.backups.Prune()
+		if err != nil {
+			writeDBError(w, err, "backup prune")
+			return
+		}
+		ret := struct {
+			jsonSnapshot
+			Pruned []string `json:"pruned"`
+		}{toJSONSnapshot(s), make([]string, 0, len(pruned))}
+		for _, p := range pruned {
+			ret.Pruned = append(ret.Pruned, p.Name)
+		}
+		writeJSON(w, http.StatusCreated, ret)
+
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint: %s", r.URL.Path)
+	}
 }
@@ -0,0 +1,779 @@
+package app
+
+import (
+	"budgeting/internal/pkg/backup"
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/budget"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/auth"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/shiftpath"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON shapes of the models for the API
+// Kept separate from the models so the wire format does not follow every DB change, and nullable keys are plain nulls
+
+// Largest request body the API will read
+const maxBodyBytes = 1 << 20
+
+type jsonError struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type jsonAccount struct {
+	ID          model.PKEY         `json:"id"`
+	Hidden      bool               `json:"hidden"`
+	Offbudget   bool               `json:"offbudget"`
+	Debt        bool               `json:"debt"`
+	Institution string             `json:"institution"`
+	Name        string             `json:"name"`
+	Class       model.AccountClass `json:"class"`
+
+	// Only filled on single account reads, optional on writes
+	StartingBalance *int `json:"startingBalance,omitempty"`
+
+	// Read only, see POST /api/account/<id>/close
+	Closed *bcdate.BCDate `json:"closed"`
+}
+
+type jsonAccountSummary struct {
+	AccountID model.PKEY    `json:"accountId"`
+	Month     bcdate.BCDate `json:"month"`
+	Bal       int           `json:"bal"`
+	In        int           `json:"in"`
+	Out       int           `json:"out"`
+	Uncleared int           `json:"uncleared"`
+}
+
+type jsonAccountTransaction struct {
+	ID         model.PKEY            `json:"id"`
+	AccountID  model.PKEY            `json:"accountId"`
+	EnvelopeID *model.PKEY           `json:"envelopeId"`
+	Typ        model.TransactionType `json:"type"`
+	PostDate   bcdate.BCDate         `json:"postDate"`
+	Amount     int                   `json:"amount"`
+	Cleared    bool                  `json:"cleared"`
+	Memo       string                `json:"memo"`
+	PayeeID    *model.PKEY           `json:"payeeId"`
+
+	// Shares of the amount by envelope, envelopeId is null when given
+	Splits []jsonSplit `json:"splits,omitempty"`
+
+	// Read only, set on transfer legs
+	TransferID       *model.PKEY `json:"transferId,omitempty"`
+	CounterAccountID *model.PKEY `json:"counterAccountId,omitempty"`
+
+	// Read only, set on imported transactions
+	ImportID string `json:"importId,omitempty"`
+
+	// Read only, see POST /api/account/<id>/reconcile
+	Reconciled bool `json:"reconciled"`
+}
+
+// A transaction that looks like another, index is its position in a checked batch or -1 for stored pairs
+// The match of a batch row may be an earlier row of the batch, with no id
+type jsonDuplicate struct {
+	Index       int                    `json:"index"`
+	Score       float64                `json:"score"`
+	Transaction jsonAccountTransaction `json:"transaction"`
+	Match       jsonAccountTransaction `json:"match"`
+}
+
+type jsonSplit struct {
+	ID         model.PKEY  `json:"id"`
+	EnvelopeID *model.PKEY `json:"envelopeId"`
+	Amount     int         `json:"amount"`
+	Memo       string      `json:"memo"`
+}
+
+type jsonPayee struct {
+	ID         model.PKEY  `json:"id"`
+	Name       string      `json:"name"`
+	EnvelopeID *model.PKEY `json:"envelopeId"`
+}
+
+// Match keys left null match anything, action keys left null keep what the transaction has
+type jsonRule struct {
+	ID       model.PKEY `json:"id"`
+	Priority int        `json:"priority"`
+	Name     string     `json:"name"`
+
+	MemoPattern string         `json:"memoPattern"`
+	MinAmount   *int64         `json:"minAmount"`
+	MaxAmount   *int64         `json:"maxAmount"`
+	AccountID   *model.PKEY    `json:"accountId"`
+	FromDate    *bcdate.BCDate `json:"fromDate"`
+	ToDate      *bcdate.BCDate `json:"toDate"`
+
+	EnvelopeID *model.PKEY            `json:"envelopeId"`
+	Typ        *model.TransactionType `json:"type"`
+	Cleared    *bool                  `json:"cleared"`
+	Memo       *string                `json:"memo"`
+}
+
+// One transaction of a previewed batch the rules fired on, index is its position in the request
+type jsonRuleMatch struct {
+	Index int                    `json:"index"`
+	Rules []model.PKEY           `json:"rules"`
+	After jsonAccountTransaction `json:"after"`
+}
+
+// Last and next are read only, they move as occurrences are entered or skipped, next is null once the schedule has ended
+type jsonSchedule struct {
+	ID         model.PKEY            `json:"id"`
+	AccountID  model.PKEY            `json:"accountId"`
+	EnvelopeID *model.PKEY           `json:"envelopeId"`
+	PayeeID    *model.PKEY           `json:"payeeId"`
+	Typ        model.TransactionType `json:"type"`
+	Amount     int                   `json:"amount"`
+	Memo       string                `json:"memo"`
+	Kind       model.ScheduleKind    `json:"kind"`
+	N          int                   `json:"n"`
+	Start      bcdate.BCDate         `json:"start"`
+	End        *bcdate.BCDate        `json:"end"`
+	Last       bcdate.BCDate         `json:"last"`
+	Next       *bcdate.BCDate        `json:"next"`
+}
+
+type jsonTransfer struct {
+	ID            model.PKEY    `json:"id"`
+	FromID        model.PKEY    `json:"fromId"`
+	ToID          model.PKEY    `json:"toId"`
+	FromAccountID model.PKEY    `json:"fromAccountId"`
+	ToAccountID   model.PKEY    `json:"toAccountId"`
+	EnvelopeID    *model.PKEY   `json:"envelopeId"`
+	PostDate      bcdate.BCDate `json:"postDate"`
+	Amount        int           `json:"amount"`
+	Memo          string        `json:"memo"`
+}
+
+type jsonEnvelopeGroup struct {
+	ID   model.PKEY `json:"id"`
+	Name string     `json:"name"`
+	Sort int        `json:"sort"`
+}
+
+type jsonEnvelope struct {
+	ID          model.PKEY     `json:"id"`
+	GroupID     model.PKEY     `json:"groupId"`
+	DebtAccount *model.PKEY    `json:"debtAccount"`
+	Hidden      bool           `json:"hidden"`
+	Name        string         `json:"name"`
+	Notes       string         `json:"notes"`
+	Goal        model.GoalType `json:"goal"`
+	GoalAmt     int            `json:"goalAmt"`
+	GoalTgt     int            `json:"goalTgt"`
+	Sort        int            `json:"sort"`
+}
+
+type jsonEnvelopeSummary struct {
+	EnvelopeID model.PKEY    `json:"envelopeId"`
+	Month      bcdate.BCDate `json:"month"`
+	Bal        int           `json:"bal"`
+	In         int           `json:"in"`
+	Out        int           `json:"out"`
+}
+
+type jsonEnvelopeTransaction struct {
+	ID         model.PKEY    `json:"id"`
+	EnvelopeID model.PKEY    `json:"envelopeId"`
+	PostDate   bcdate.BCDate `json:"postDate"`
+	Amount     int           `json:"amount"`
+}
+
+type jsonBudget struct {
+	Month bcdate.BCDate    `json:"month"`
+	Float int              `json:"float"`
+	Lines []jsonBudgetLine `json:"lines"`
+}
+
+type jsonBudgetLine struct {
+	EnvelopeID model.PKEY `json:"envelopeId"`
+	Name       string     `json:"name"`
+	Bal        int        `json:"bal"`
+	In         int        `json:"in"`
+	Out        int        `json:"out"`
+	Want       int        `json:"want"`
+	Need       int        `json:"need"`
+}
+
+// Set what the envelope has assigned in the month
+type jsonAssign struct {
+	EnvelopeID model.PKEY `json:"envelopeId"`
+	Amount     int        `json:"amount"`
+}
+
+type jsonMove struct {
+	FromID model.PKEY `json:"fromId"`
+	ToID   model.PKEY `json:"toId"`
+	Amount int        `json:"amount"`
+}
+
+// A statement to reconcile an account against, cleared lists the transactions ticked off it
+type jsonReconcile struct {
+	Date    bcdate.BCDate `json:"date"`
+	Balance int           `json:"balance"`
+	Cleared []model.PKEY  `json:"cleared"`
+	Adjust  bool          `json:"adjust"`
+}
+
+type jsonReconcileResult struct {
+	ClearedBalance int         `json:"clearedBalance"`
+	Difference     int         `json:"difference"`
+	AdjustmentID   *model.PKEY `json:"adjustmentId"`
+	Reconciled     int         `json:"reconciled"`
+}
+
+// Closes the account on date, a balance left on it goes to transferTo
+type jsonClose struct {
+	Date       bcdate.BCDate `json:"date"`
+	TransferTo *model.PKEY   `json:"transferTo"`
+	EnvelopeID *model.PKEY   `json:"envelopeId"`
+}
+
+type jsonSummary struct {
+	Month    bcdate.BCDate `json:"month"`
+	Float    int           `json:"float"`
+	Income   int           `json:"income"`
+	Expenses int           `json:"expenses"`
+	Banked   int           `json:"banked"`
+	NetWorth int           `json:"netWorth"`
+	Delta    int           `json:"delta"`
+	Gain     int           `json:"gain"`
+	Missing  int           `json:"missing"`
+}
+
+type jsonViolation struct {
+	Check    string `json:"check"`
+	Table    string `json:"table"`
+	Key      string `json:"key"`
+	Column   string `json:"column,omitempty"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+type jsonSnapshot struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+type jsonAuditOp struct {
+	ID     model.PKEY  `json:"id"`
+	At     time.Time   `json:"at"`
+	Actor  string      `json:"actor"`
+	Name   string      `json:"name"`
+	Undoes *model.PKEY `json:"undoes"`
+	Undone bool        `json:"undone"`
+	Rows   int         `json:"rows"`
+}
+
+type jsonSession struct {
+	ID       model.PKEY `json:"id"`
+	Created  time.Time  `json:"created"`
+	LastSeen time.Time  `json:"lastSeen"`
+	Agent    string     `json:"agent"`
+	Addr     string     `json:"addr"`
+	// The session the request came with
+	Current bool `json:"current"`
+}
+
+type jsonAuditChange struct {
+	Table  string         `json:"table"`
+	Before map[string]any `json:"before"`
+	After  map[string]any `json:"after"`
+}
+
+func toJSONBudget(b budget.Budget) jsonBudget {
+	jb := jsonBudget{Month: b.Month, Float: b.Float, Lines: make([]jsonBudgetLine, 0, len(b.Lines))}
+	for _, l := range b.Lines {
+		jb.Lines = append(jb.Lines, jsonBudgetLine{
+			EnvelopeID: l.Envelope.ID,
+			Name:       l.Envelope.Name,
+			Bal:        l.Bal,
+			In:         l.In,
+			Out:        l.Out,
+			Want:       l.Want,
+			Need:       l.Need,
+		})
+	}
+	return jb
+}
+
+func toJSONSnapshot(s backup.Snapshot) jsonSnapshot {
+	return jsonSnapshot{Name: s.Name, Time: s.Time, Size: s.Size}
+}
+
+func toJSONAuditOps(ops []db.AuditOp) []jsonAuditOp {
+	ret := make([]jsonAuditOp, 0, len(ops))
+	for _, op := range ops {
+		ret = append(ret, jsonAuditOp{
+			ID:     op.ID,
+			At:     op.At,
+			Actor:  op.Actor,
+			Name:   op.Name,
+			Undoes: nullToPKEY(op.Undoes),
+			Undone: op.Undone,
+			Rows:   op.Rows,
+		})
+	}
+	return ret
+}
+
+func nullToPKEY(n sql.NullInt32) *model.PKEY {
+	if !n.Valid {
+		return nil
+	}
+	id := model.PKEY(n.Int32)
+	return &id
+}
+
+func pkeyToNull(id *model.PKEY) sql.NullInt32 {
+	if id == nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: int32(*id), Valid: true}
+}
+
+func nullToDate(n sql.NullInt32) *bcdate.BCDate {
+	if !n.Valid {
+		return nil
+	}
+	d := bcdate.BCDate(n.Int32)
+	return &d
+}
+
+func toJSONAccount(a model.Account) jsonAccount {
+	return jsonAccount{
+		ID:          a.ID,
+		Hidden:      a.Hidden,
+		Offbudget:   a.Offbudget,
+		Debt:        a.Debt,
+		Institution: a.Institution,
+		Name:        a.Name,
+		Class:       a.Class,
+		Closed:      nullToDate(a.Closed),
+	}
+}
+
+func (a jsonAccount) model() model.Account {
+	return model.Account{
+		ID:          a.ID,
+		Hidden:      a.Hidden,
+		Offbudget:   a.Offbudget,
+		Debt:        a.Debt,
+		Institution: a.Institution,
+		Name:        a.Name,
+		Class:       a.Class,
+	}
+}
+
+func toJSONAccountSummary(s model.AccountSummary) jsonAccountSummary {
+	return jsonAccountSummary(s)
+}
+
+func toJSONAccountTransaction(at model.AccountTransaction) jsonAccountTransaction {
+	return jsonAccountTransaction{
+		ID:         at.ID,
+		AccountID:  at.AccountID,
+		EnvelopeID: nullToPKEY(at.EnvelopeID),
+		Typ:        at.Typ,
+		PostDate:   at.PostDate,
+		Amount:     at.Amount,
+		Cleared:    at.Cleared,
+		Memo:       at.Memo,
+		PayeeID:    nullToPKEY(at.PayeeID),
+		Splits:     toJSONSplits(at.Splits),
+
+		TransferID:       nullToPKEY(at.TransferID),
+		CounterAccountID: nullToPKEY(at.CounterAccount),
+
+		ImportID: at.ImportID.String,
+
+		Reconciled: at.Reconciled,
+	}
+}
+
+func (at jsonAccountTransaction) model() model.AccountTransaction {
+	mat := model.AccountTransaction{
+		ID:         at.ID,
+		AccountID:  at.AccountID,
+		EnvelopeID: pkeyToNull(at.EnvelopeID),
+		Typ:        at.Typ,
+		PostDate:   at.PostDate,
+		Amount:     at.Amount,
+		Cleared:    at.Cleared,
+		Memo:       at.Memo,
+		PayeeID:    pkeyToNull(at.PayeeID),
+	}
+	for _, s := range at.Splits {
+		mat.Splits = append(mat.Splits, model.Split{
+			ID:            s.ID,
+			TransactionID: at.ID,
+			EnvelopeID:    pkeyToNull(s.EnvelopeID),
+			Amount:        s.Amount,
+			Memo:          s.Memo,
+		})
+	}
+	return mat
+}
+
+func toJSONSplits(ss []model.Split) []jsonSplit {
+	if len(ss) == 0 {
+		return nil
+	}
+	ret := make([]jsonSplit, 0, len(ss))
+	for _, s := range ss {
+		ret = append(ret, jsonSplit{
+			ID:         s.ID,
+			EnvelopeID: nullToPKEY(s.EnvelopeID),
+			Amount:     s.Amount,
+			Memo:       s.Memo,
+		})
+	}
+	return ret
+}
+
+func toJSONPayee(p model.Payee) jsonPayee {
+	return jsonPayee{
+		ID:         p.ID,
+		Name:       p.Name,
+		EnvelopeID: nullToPKEY(p.EnvelopeID),
+	}
+}
+
+func (p jsonPayee) model() model.Payee {
+	return model.Payee{
+		ID:         p.ID,
+		Name:       p.Name,
+		EnvelopeID: pkeyToNull(p.EnvelopeID),
+	}
+}
+
+func toJSONRule(r model.Rule) jsonRule {
+	jr := jsonRule{
+		ID:          r.ID,
+		Priority:    r.Priority,
+		Name:        r.Name,
+		MemoPattern: r.MemoPattern,
+		AccountID:   nullToPKEY(r.AccountID),
+		EnvelopeID:  nullToPKEY(r.EnvelopeID),
+	}
+	if r.MinAmount.Valid {
+		jr.MinAmount = &r.MinAmount.Int64
+	}
+	if r.MaxAmount.Valid {
+		jr.MaxAmount = &r.MaxAmount.Int64
+	}
+	if r.FromDate.Valid {
+		d := bcdate.BCDate(r.FromDate.Int32)
+		jr.FromDate = &d
+	}
+	if r.ToDate.Valid {
+		d := bcdate.BCDate(r.ToDate.Int32)
+		jr.ToDate = &d
+	}
+	if r.Typ.Valid {
+		typ := model.TransactionType(r.Typ.Int32)
+		jr.Typ = &typ
+	}
+	if r.Cleared.Valid {
+		jr.Cleared = &r.Cleared.Bool
+	}
+	if r.Memo.Valid {
+		jr.Memo = &r.Memo.String
+	}
+	return jr
+}
+
+func (r jsonRule) model() model.Rule {
+	mr := model.Rule{
+		ID:          r.ID,
+		Priority:    r.Priority,
+		Name:        r.Name,
+		MemoPattern: r.MemoPattern,
+		AccountID:   pkeyToNull(r.AccountID),
+		EnvelopeID:  pkeyToNull(r.EnvelopeID),
+	}
+	if r.MinAmount != nil {
+		mr.MinAmount = sql.NullInt64{Int64: *r.MinAmount, Valid: true}
+	}
+	if r.MaxAmount != nil {
+		mr.MaxAmount = sql.NullInt64{Int64: *r.MaxAmount, Valid: true}
+	}
+	if r.FromDate != nil {
+		mr.FromDate = sql.NullInt32{Int32: int32(*r.FromDate), Valid: true}
+	}
+	if r.ToDate != nil {
+		mr.ToDate = sql.NullInt32{Int32: int32(*r.ToDate), Valid: true}
+	}
+	if r.Typ != nil {
+		mr.Typ = sql.NullInt32{Int32: int32(*r.Typ), Valid: true}
+	}
+	if r.Cleared != nil {
+		mr.Cleared = sql.NullBool{Bool: *r.Cleared, Valid: true}
+	}
+	if r.Memo != nil {
+		mr.Memo = sql.NullString{String: *r.Memo, Valid: true}
+	}
+	return mr
+}
+
+func toJSONSchedule(s model.Schedule) jsonSchedule {
+	js := jsonSchedule{
+		ID:         s.ID,
+		AccountID:  s.AccountID,
+		EnvelopeID: nullToPKEY(s.EnvelopeID),
+		PayeeID:    nullToPKEY(s.PayeeID),
+		Typ:        s.Typ,
+		Amount:     s.Amount,
+		Memo:       s.Memo,
+		Kind:       s.Kind,
+		N:          s.N,
+		Start:      s.Start,
+		Last:       s.Last,
+	}
+	if s.End.Valid {
+		end := bcdate.BCDate(s.End.Int32)
+		js.End = &end
+	}
+	if next := s.Next(); next != bcdate.Never() {
+		js.Next = &next
+	}
+	return js
+}
+
+func (s jsonSchedule) model() model.Schedule {
+	ms := model.Schedule{
+		ID:         s.ID,
+		AccountID:  s.AccountID,
+		EnvelopeID: pkeyToNull(s.EnvelopeID),
+		PayeeID:    pkeyToNull(s.PayeeID),
+		Typ:        s.Typ,
+		Amount:     s.Amount,
+		Memo:       s.Memo,
+		Kind:       s.Kind,
+		N:          s.N,
+		Start:      s.Start,
+		Last:       s.Last,
+	}
+	if s.End != nil {
+		ms.End = sql.NullInt32{Int32: int32(*s.End), Valid: true}
+	}
+	return ms
+}
+
+func toJSONTransfer(t model.Transfer) jsonTransfer {
+	return jsonTransfer{
+		ID:            t.ID,
+		FromID:        t.FromID,
+		ToID:          t.ToID,
+		FromAccountID: t.FromAccountID,
+		ToAccountID:   t.ToAccountID,
+		EnvelopeID:    nullToPKEY(t.EnvelopeID),
+		PostDate:      t.PostDate,
+		Amount:        t.Amount,
+		Memo:          t.Memo,
+	}
+}
+
+func (t jsonTransfer) model() model.Transfer {
+	return model.Transfer{
+		ID:            t.ID,
+		FromID:        t.FromID,
+		ToID:          t.ToID,
+		FromAccountID: t.FromAccountID,
+		ToAccountID:   t.ToAccountID,
+		EnvelopeID:    pkeyToNull(t.EnvelopeID),
+		PostDate:      t.PostDate,
+		Amount:        t.Amount,
+		Memo:          t.Memo,
+	}
+}
+
+func toJSONEnvelopeGroup(eg model.EnvelopeGroup) jsonEnvelopeGroup {
+	return jsonEnvelopeGroup(eg)
+}
+
+func (eg jsonEnvelopeGroup) model() model.EnvelopeGroup {
+	return model.EnvelopeGroup(eg)
+}
+
+func toJSONEnvelope(e model.Envelope) jsonEnvelope {
+	return jsonEnvelope{
+		ID:          e.ID,
+		GroupID:     e.GroupID,
+		DebtAccount: nullToPKEY(e.DebtAccount),
+		Hidden:      e.Hidden,
+		Name:        e.Name,
+		Notes:       e.Notes,
+		Goal:        e.Goal,
+		GoalAmt:     e.GoalAmt,
+		GoalTgt:     e.GoalTgt,
+		Sort:        e.Sort,
+	}
+}
+
+func (e jsonEnvelope) model() model.Envelope {
+	return model.Envelope{
+		ID:          e.ID,
+		GroupID:     e.GroupID,
+		DebtAccount: pkeyToNull(e.DebtAccount),
+		Hidden:      e.Hidden,
+		Name:        e.Name,
+		Notes:       e.Notes,
+		Goal:        e.Goal,
+		GoalAmt:     e.GoalAmt,
+		GoalTgt:     e.GoalTgt,
+		Sort:        e.Sort,
+	}
+}
+
+func toJSONEnvelopeSummary(s model.EnvelopeSummary) jsonEnvelopeSummary {
+	return jsonEnvelopeSummary(s)
+}
+
+func toJSONEnvelopeTransaction(et model.EnvelopeTransaction) jsonEnvelopeTransaction {
+	return jsonEnvelopeTransaction(et)
+}
+
+func (et jsonEnvelopeTransaction) model() model.EnvelopeTransaction {
+	return model.EnvelopeTransaction(et)
+}
+
+func toJSONSummary(s model.Summary) jsonSummary {
+	return jsonSummary{
+		Month:    s.Month,
+		Float:    s.Float,
+		Income:   s.Income,
+		Expenses: s.Expenses,
+		Banked:   s.Banked,
+		NetWorth: s.NetWorth,
+		Delta:    s.Delta,
+		Gain:     s.Gain(),
+		Missing:  s.Missing(),
+	}
+}
+
+// Response helpers
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API: failed to encode response -- %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, jsonError{
+		Status:  status,
+		Error:   http.StatusText(status),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Missing rows are the caller asking for something that is not there, so are rules the DB enforces, anything else is on us
+func writeDBError(w http.ResponseWriter, err error, what string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "%s not found", what)
+		return
+	}
+	if errors.Is(err, db.ErrInvalidSplit) || errors.Is(err, db.ErrInvalidTransfer) || errors.Is(err, db.ErrInvalidRule) || errors.Is(err, db.ErrInvalidSchedule) || errors.Is(err, budget.ErrInvalid) {
+		writeError(w, http.StatusBadRequest, "%s -- %s", what, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrInvalidReconcile) || errors.Is(err, db.ErrInvalidClose) || errors.Is(err, db.ErrInvalidMove) {
+		writeError(w, http.StatusBadRequest, "%s -- %s", what, err.Error())
+		return
+	}
+	// Locked transactions, statements that do not balance and likely duplicates conflict with what is stored
+	if errors.Is(err, db.ErrReconciled) || errors.Is(err, db.ErrReconcileMismatch) || errors.Is(err, db.ErrAccountClosed) || errors.Is(err, db.ErrDuplicate) {
+		writeError(w, http.StatusConflict, "%s -- %s", what, err.Error())
+		return
+	}
+	// The log has nothing left to revert, or a later write changed the rows since
+	if errors.Is(err, db.ErrNothingToUndo) || errors.Is(err, db.ErrUndoConflict) {
+		writeError(w, http.StatusConflict, "%s -- %s", what, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrBackupUnsupported) {
+		writeError(w, http.StatusNotImplemented, "%s -- %s", what, err.Error())
+		return
+	}
+	// The wrapped error names our queries and the driver's own text, so it stays in the log
+	log.Printf("API: %s -- %s", what, err.Error())
+	writeError(w, http.StatusInternalServerError, "%s failed, see the server log", what)
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed, use %s", strings.Join(allowed, ", "))
+}
+
+// Request helpers
+
+// Decode a JSON body onto v, fields missing from the body keep whatever v already holds
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "request body is empty")
+		} else {
+			writeError(w, http.StatusBadRequest, "malformed request body -- %s", err.Error())
+		}
+		return false
+	}
+	if dec.More() {
+		writeError(w, http.StatusBadRequest, "request body holds more than one JSON value")
+		return false
+	}
+	return true
+}
+
+// Who the audit log puts the writes made for r down to, the logged in user or else the client address
+func requestActor(r *http.Request) string {
+	if name, ok := auth.GetUser(r); ok {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Pull an ID off the front of tail, ok is false when there is none
+func parseID(tail string) (id model.PKEY, ok bool, err error) {
+	head, _ := shiftpath.ShiftPath(tail)
+	if head == "" {
+		return 0, false, nil
+	}
+
+	iid, err := strconv.ParseInt(head, 10, 32)
+	if err != nil || iid <= 0 {
+		return 0, false, fmt.Errorf("id must be a positive integer, got %q", head)
+	}
+	return model.PKEY(iid), true, nil
+}
+
+func validDate(d bcdate.BCDate) bool {
+	day := d % 100
+	mon := (d / 100) % 100
+	return day >= 1 && day <= 31 && mon >= 1 && mon <= 12
+}
@@ -0,0 +1,758 @@
+package app_test
+
+import (
+	"budgeting/internal/pkg/app"
+	"budgeting/internal/pkg/backup"
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/auth"
+	"budgeting/internal/pkg/middleware/querymonth"
+	"budgeting/internal/pkg/middleware/session"
+	"budgeting/internal/pkg/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAPI(t *testing.T) http.Handler {
+	t.Helper()
+	d := db.NewSQLite()
+	if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	return querymonth.NewQueryMonth(app.NewAPIHandler(d, backup.Dir{Path: filepath.Join(t.TempDir(), "backups"), Keep: 2}))
+}
+
+// Send a request and decode the JSON reply into out, if given
+func call(t *testing.T, h http.Handler, method, path, body string, wantStatus int, out any) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != wantStatus {
+		t.Fatalf("%s %s = %d, want %d: %s", method, path, w.Code, wantStatus, w.Body.String())
+	}
+	if w.Code != http.StatusNoContent && w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("%s %s Content-Type = %q", method, path, w.Header().Get("Content-Type"))
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s returned bad JSON: %s", method, path, err)
+		}
+	}
+}
+
+type idOnly struct {
+	ID int `json:"id"`
+}
+
+func TestAPICrud(t *testing.T) {
+	h := newAPI(t)
+	day := int(bcdate.CurrentMonth()) + 1
+
+	var acct idOnly
+	call(t, h, "POST", "/account", `{"institution":"Bank","name":"Checking","startingBalance":10000}`, http.StatusCreated, &acct)
+
+	var env idOnly
+	call(t, h, "POST", "/envelope", `{"groupId":1,"name":"Food"}`, http.StatusCreated, &env)
+
+	var et idOnly
+	call(t, h, "POST", "/envelope_transaction", `{"envelopeId":`+strconv.Itoa(env.ID)+`,"postDate":`+strconv.Itoa(day)+`,"amount":3000}`, http.StatusCreated, &et)
+
+	var at idOnly
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"envelopeId":`+strconv.Itoa(env.ID)+`,"postDate":`+strconv.Itoa(day)+`,"amount":-1200,"memo":"Groceries"}`, http.StatusCreated, &at)
+
+	var got struct {
+		Account struct {
+			StartingBalance int `json:"startingBalance"`
+		} `json:"account"`
+		Summary struct {
+			Bal int `json:"bal"`
+		} `json:"summary"`
+		Transactions []struct {
+			Memo       string `json:"memo"`
+			EnvelopeID *int   `json:"envelopeId"`
+		} `json:"transactions"`
+	}
+	call(t, h, "GET", "/account/"+strconv.Itoa(acct.ID), "", http.StatusOK, &got)
+	if got.Account.StartingBalance != 10000 || got.Summary.Bal != 8800 || len(got.Transactions) != 1 || got.Transactions[0].Memo != "Groceries" {
+		t.Fatalf("GET account = %+v", got)
+	}
+
+	// PATCH only touches the given fields, and null clears the envelope
+	call(t, h, "PATCH", "/transaction/"+strconv.Itoa(at.ID), `{"envelopeId":null}`, http.StatusOK, nil)
+	call(t, h, "GET", "/account/"+strconv.Itoa(acct.ID), "", http.StatusOK, &got)
+	if got.Transactions[0].Memo != "Groceries" || got.Transactions[0].EnvelopeID != nil {
+		t.Fatalf("Transaction after PATCH = %+v", got.Transactions[0])
+	}
+
+	var sanity struct {
+		OK         bool  `json:"ok"`
+		Violations []any `json:"violations"`
+	}
+	call(t, h, "GET", "/sanity", "", http.StatusOK, &sanity)
+	if !sanity.OK || len(sanity.Violations) != 0 {
+		t.Fatalf("GET sanity = %+v", sanity)
+	}
+
+	var summ struct {
+		Float    int `json:"float"`
+		NetWorth int `json:"netWorth"`
+	}
+	call(t, h, "GET", "/summary", "", http.StatusOK, &summ)
+	if summ.NetWorth != 8800 || summ.Float != 5800 {
+		t.Fatalf("GET summary = %+v", summ)
+	}
+
+	// Splitting keeps the envelope's share and leaves the rest unassigned
+	var split struct {
+		Splits []struct {
+			ID     int `json:"id"`
+			Amount int `json:"amount"`
+		} `json:"splits"`
+	}
+	call(t, h, "PATCH", "/transaction/"+strconv.Itoa(at.ID), `{"splits":[{"envelopeId":`+strconv.Itoa(env.ID)+`,"amount":-1000},{"envelopeId":null,"amount":-200}]}`, http.StatusOK, &split)
+	if len(split.Splits) != 2 || split.Splits[0].ID == 0 || split.Splits[1].Amount != -200 {
+		t.Fatalf("Transaction after split PATCH = %+v", split)
+	}
+	call(t, h, "GET", "/summary", "", http.StatusOK, &summ)
+	if summ.Float != 6800 {
+		t.Fatalf("GET summary after split = %+v", summ)
+	}
+
+	call(t, h, "DELETE", "/transaction/"+strconv.Itoa(at.ID), "", http.StatusNoContent, nil)
+	call(t, h, "DELETE", "/envelope_transaction/"+strconv.Itoa(et.ID), "", http.StatusNoContent, nil)
+	call(t, h, "DELETE", "/envelope/"+strconv.Itoa(env.ID), "", http.StatusNoContent, nil)
+	call(t, h, "DELETE", "/account/"+strconv.Itoa(acct.ID), "", http.StatusNoContent, nil)
+
+	var list []any
+	call(t, h, "GET", "/accounts", "", http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("GET accounts after delete = %v", list)
+	}
+}
+
+func TestAPIPayees(t *testing.T) {
+	h := newAPI(t)
+	day := strconv.Itoa(int(bcdate.CurrentMonth()) + 1)
+
+	var acct, env, payee idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	call(t, h, "POST", "/envelope", `{"groupId":1,"name":"Food"}`, http.StatusCreated, &env)
+	call(t, h, "POST", "/payee", `{"name":"Grocer","envelopeId":`+strconv.Itoa(env.ID)+`}`, http.StatusCreated, &payee)
+	call(t, h, "POST", "/payee", `{"name":"Grocer"}`, http.StatusConflict, nil)
+
+	// No envelope given, so the payee's is used
+	var at struct {
+		EnvelopeID *int `json:"envelopeId"`
+		PayeeID    *int `json:"payeeId"`
+	}
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"payeeId":`+strconv.Itoa(payee.ID)+`,"postDate":`+day+`,"amount":-700}`, http.StatusCreated, &at)
+	if at.EnvelopeID == nil || *at.EnvelopeID != env.ID || at.PayeeID == nil || *at.PayeeID != payee.ID {
+		t.Fatalf("POST transaction with payee = %+v", at)
+	}
+
+	var list []struct {
+		Name string `json:"name"`
+	}
+	call(t, h, "PATCH", "/payee/"+strconv.Itoa(payee.ID), `{"name":"Corner Grocer"}`, http.StatusOK, nil)
+	call(t, h, "GET", "/payees", "", http.StatusOK, &list)
+	if len(list) != 1 || list[0].Name != "Corner Grocer" {
+		t.Fatalf("GET payees = %+v", list)
+	}
+
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"payeeId":9999,"postDate":`+day+`,"amount":-1}`, http.StatusBadRequest, nil)
+	call(t, h, "DELETE", "/payee/"+strconv.Itoa(payee.ID), "", http.StatusNoContent, nil)
+	call(t, h, "GET", "/payee/"+strconv.Itoa(payee.ID), "", http.StatusNotFound, nil)
+}
+
+func TestAPIRules(t *testing.T) {
+	h := newAPI(t)
+	day := strconv.Itoa(int(bcdate.CurrentMonth()) + 1)
+
+	var acct, env, rule idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	call(t, h, "POST", "/envelope", `{"groupId":1,"name":"Coffee"}`, http.StatusCreated, &env)
+	call(t, h, "POST", "/rule", `{"name":"Cafe","memoPattern":"(?i)^cafe (\\w+)","maxAmount":0,"envelopeId":`+strconv.Itoa(env.ID)+`,"memo":"Cafe $1"}`, http.StatusCreated, &rule)
+	call(t, h, "POST", "/rule", `{"name":"Bad","memoPattern":"(","cleared":true}`, http.StatusBadRequest, nil)
+	call(t, h, "POST", "/rule", `{"name":"Idle"}`, http.StatusBadRequest, nil)
+	call(t, h, "POST", "/rule", `{"name":"Gone","envelopeId":9999}`, http.StatusBadRequest, nil)
+
+	var ms []struct {
+		Index int   `json:"index"`
+		Rules []int `json:"rules"`
+		After struct {
+			EnvelopeID *int   `json:"envelopeId"`
+			Memo       string `json:"memo"`
+		} `json:"after"`
+	}
+	batch := `[{"accountId":` + strconv.Itoa(acct.ID) + `,"postDate":` + day + `,"amount":-450,"memo":"CAFE Luna 12"},` +
+		`{"accountId":` + strconv.Itoa(acct.ID) + `,"postDate":` + day + `,"amount":-900,"memo":"Bakery"}]`
+	call(t, h, "POST", "/rules/preview", batch, http.StatusOK, &ms)
+	if len(ms) != 1 || ms[0].Index != 0 || len(ms[0].Rules) != 1 || ms[0].Rules[0] != rule.ID ||
+		ms[0].After.EnvelopeID == nil || *ms[0].After.EnvelopeID != env.ID || ms[0].After.Memo != "Cafe Luna" {
+		t.Fatalf("POST rules/preview = %+v", ms)
+	}
+
+	var list []struct {
+		Name      string `json:"name"`
+		MaxAmount *int   `json:"maxAmount"`
+		MinAmount *int   `json:"minAmount"`
+	}
+	call(t, h, "PATCH", "/rule/"+strconv.Itoa(rule.ID), `{"name":"Coffee shops","maxAmount":null}`, http.StatusOK, nil)
+	call(t, h, "GET", "/rules", "", http.StatusOK, &list)
+	if len(list) != 1 || list[0].Name != "Coffee shops" || list[0].MaxAmount != nil || list[0].MinAmount != nil {
+		t.Fatalf("GET rules = %+v", list)
+	}
+	call(t, h, "PATCH", "/rule/"+strconv.Itoa(rule.ID), `{"type":2}`, http.StatusBadRequest, nil)
+	call(t, h, "GET", "/rules/preview", "", http.StatusMethodNotAllowed, nil)
+
+	call(t, h, "DELETE", "/rule/"+strconv.Itoa(rule.ID), "", http.StatusNoContent, nil)
+	call(t, h, "GET", "/rule/"+strconv.Itoa(rule.ID), "", http.StatusNotFound, nil)
+}
+
+func TestAPISchedules(t *testing.T) {
+	h := newAPI(t)
+	start := bcdate.CurrentMonth().NextMonth() + 1
+
+	var acct, sched idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	body := `{"accountId":` + strconv.Itoa(acct.ID) + `,"amount":-120000,"memo":"Rent","kind":0,"n":1,"start":` + strconv.Itoa(int(start)) + `,"last":` + strconv.Itoa(int(start))
+	call(t, h, "POST", "/schedule", body+`}`, http.StatusCreated, &sched)
+	call(t, h, "POST", "/schedule", body+`,"n":0}`, http.StatusBadRequest, nil)
+	call(t, h, "POST", "/schedule", body+`,"payeeId":9999}`, http.StatusBadRequest, nil)
+
+	type next struct {
+		Last int  `json:"last"`
+		Next *int `json:"next"`
+		End  *int `json:"end"`
+	}
+	path := "/schedule/" + strconv.Itoa(sched.ID)
+	// Last is the server's to move, a create cannot claim occurrences are done
+	var got next
+	call(t, h, "GET", path, "", http.StatusOK, &got)
+	if got.Last != 0 || got.Next == nil || *got.Next != int(start) {
+		t.Fatalf("GET schedule = %+v", got)
+	}
+
+	call(t, h, "POST", path+"/skip", "", http.StatusOK, &got)
+	if got.Last != int(start) || got.Next == nil || *got.Next != int(start.NextMonth()) {
+		t.Fatalf("POST schedule/skip = %+v", got)
+	}
+	call(t, h, "GET", path+"/skip", "", http.StatusMethodNotAllowed, nil)
+
+	// Ending it before the next occurrence leaves nothing to skip
+	call(t, h, "PATCH", path, `{"end":`+strconv.Itoa(int(start))+`,"last":0}`, http.StatusOK, &got)
+	if got.Last != int(start) || got.Next != nil || got.End == nil {
+		t.Fatalf("PATCH schedule = %+v", got)
+	}
+	call(t, h, "POST", path+"/skip", "", http.StatusBadRequest, nil)
+
+	var list []next
+	call(t, h, "GET", "/schedules", "", http.StatusOK, &list)
+	if len(list) != 1 {
+		t.Fatalf("GET schedules = %+v", list)
+	}
+
+	call(t, h, "DELETE", path, "", http.StatusNoContent, nil)
+	call(t, h, "POST", path+"/skip", "", http.StatusNotFound, nil)
+}
+
+func TestAPIBudget(t *testing.T) {
+	h := newAPI(t)
+	month := bcdate.CurrentMonth()
+	qm := "?qm=" + month.FmtMonth()
+
+	var acct, rent, food idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"type":1,"postDate":`+strconv.Itoa(int(month)+1)+`,"amount":100000}`, http.StatusCreated, nil)
+	call(t, h, "POST", "/envelope", `{"groupId":1,"name":"Rent","goal":1,"goalAmt":60000}`, http.StatusCreated, &rent)
+	call(t, h, "POST", "/envelope", `{"groupId":1,"name":"Food"}`, http.StatusCreated, &food)
+
+	type line struct {
+		EnvelopeID int `json:"envelopeId"`
+		In         int `json:"in"`
+		Need       int `json:"need"`
+	}
+	var b struct {
+		Float int    `json:"float"`
+		Lines []line `json:"lines"`
+	}
+	in := func(id int) int {
+		for _, l := range b.Lines {
+			if l.EnvelopeID == id {
+				return l.In
+			}
+		}
+		t.Fatalf("No line for envelope %d in %+v", id, b)
+		return 0
+	}
+
+	call(t, h, "POST", "/budget/assign"+qm, `{"envelopeId":`+strconv.Itoa(food.ID)+`,"amount":30000}`, http.StatusOK, &b)
+	if b.Float != 70000 || in(food.ID) != 30000 {
+		t.Fatalf("POST budget/assign = %+v", b)
+	}
+	call(t, h, "POST", "/budget/move"+qm, `{"fromId":`+strconv.Itoa(food.ID)+`,"toId":`+strconv.Itoa(rent.ID)+`,"amount":5000}`, http.StatusOK, &b)
+	if b.Float != 70000 || in(food.ID) != 25000 || in(rent.ID) != 5000 {
+		t.Fatalf("POST budget/move = %+v", b)
+	}
+	call(t, h, "POST", "/budget/fund"+qm, "", http.StatusOK, &b)
+	if b.Float != 15000 || in(rent.ID) != 60000 {
+		t.Fatalf("POST budget/fund = %+v", b)
+	}
+	call(t, h, "GET", "/budget"+qm, "", http.StatusOK, &b)
+	if b.Float != 15000 || len(b.Lines) != 2 {
+		t.Fatalf("GET budget = %+v", b)
+	}
+
+	call(t, h, "POST", "/budget/move"+qm, `{"fromId":`+strconv.Itoa(food.ID)+`,"toId":`+strconv.Itoa(food.ID)+`,"amount":5000}`, http.StatusBadRequest, nil)
+	call(t, h, "POST", "/budget/assign"+qm, `{"envelopeId":9999,"amount":1}`, http.StatusBadRequest, nil)
+	call(t, h, "GET", "/budget/fund", "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "POST", "/budget", "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "GET", "/budget/spend", "", http.StatusNotFound, nil)
+}
+
+func TestAPIMoveTransaction(t *testing.T) {
+	h := newAPI(t)
+	day := strconv.Itoa(int(bcdate.CurrentMonth()) + 1)
+
+	var chk, sav, at idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &chk)
+	call(t, h, "POST", "/account", `{"name":"Savings"}`, http.StatusCreated, &sav)
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(chk.ID)+`,"postDate":`+day+`,"amount":-1200,"memo":"Groceries"}`, http.StatusCreated, &at)
+
+	// A new accountId moves it, the old ID is gone and the reply is the new transaction
+	var moved struct {
+		ID        int    `json:"id"`
+		AccountID int    `json:"accountId"`
+		Memo      string `json:"memo"`
+	}
+	call(t, h, "PATCH", "/transaction/"+strconv.Itoa(at.ID), `{"accountId":`+strconv.Itoa(sav.ID)+`}`, http.StatusCreated, &moved)
+	if moved.ID == at.ID || moved.AccountID != sav.ID || moved.Memo != "Groceries" {
+		t.Fatalf("PATCH accountId = %+v", moved)
+	}
+	call(t, h, "GET", "/transaction/"+strconv.Itoa(at.ID), "", http.StatusNotFound, nil)
+
+	var got struct {
+		Summary struct {
+			Bal int `json:"bal"`
+		} `json:"summary"`
+	}
+	for aid, bal := range map[int]int{chk.ID: 0, sav.ID: -1200} {
+		call(t, h, "GET", "/account/"+strconv.Itoa(aid), "", http.StatusOK, &got)
+		if got.Summary.Bal != bal {
+			t.Fatalf("Balance of account %d after the move = %d, want %d", aid, got.Summary.Bal, bal)
+		}
+	}
+
+	// Transfer legs stay on their accounts
+	var x struct {
+		ToID int `json:"toId"`
+	}
+	call(t, h, "POST", "/transfer", `{"fromAccountId":`+strconv.Itoa(chk.ID)+`,"toAccountId":`+strconv.Itoa(sav.ID)+`,"postDate":`+day+`,"amount":500}`, http.StatusCreated, &x)
+	call(t, h, "PATCH", "/transaction/"+strconv.Itoa(x.ToID), `{"accountId":`+strconv.Itoa(chk.ID)+`}`, http.StatusBadRequest, nil)
+}
+
+func TestAPIReconcile(t *testing.T) {
+	h := newAPI(t)
+	date := strconv.Itoa(int(bcdate.CurrentMonth()) + 1)
+
+	var acct, pay idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	aid := strconv.Itoa(acct.ID)
+	call(t, h, "POST", "/transaction", `{"accountId":`+aid+`,"type":1,"postDate":`+date+`,"amount":100000}`, http.StatusCreated, &pay)
+	path := "/account/" + aid + "/reconcile"
+	body := `{"date":` + date + `,"balance":90000,"cleared":[` + strconv.Itoa(pay.ID) + `]`
+
+	call(t, h, "POST", path, body+`}`, http.StatusConflict, nil)
+	var res struct {
+		Difference   int  `json:"difference"`
+		AdjustmentID *int `json:"adjustmentId"`
+		Reconciled   int  `json:"reconciled"`
+	}
+	call(t, h, "POST", path, body+`,"adjust":true}`, http.StatusOK, &res)
+	if res.Difference != -10000 || res.AdjustmentID == nil || res.Reconciled != 2 {
+		t.Fatalf("POST reconcile = %+v", res)
+	}
+
+	var at struct {
+		Reconciled bool `json:"reconciled"`
+	}
+	tpath := "/transaction/" + strconv.Itoa(pay.ID)
+	call(t, h, "GET", tpath, "", http.StatusOK, &at)
+	if !at.Reconciled {
+		t.Fatalf("GET transaction = %+v", at)
+	}
+	call(t, h, "PATCH", tpath, `{"amount":1}`, http.StatusConflict, nil)
+	call(t, h, "DELETE", tpath, "", http.StatusConflict, nil)
+	call(t, h, "POST", tpath+"/unreconcile", "", http.StatusOK, &at)
+	if at.Reconciled {
+		t.Fatalf("POST unreconcile = %+v", at)
+	}
+	call(t, h, "DELETE", tpath, "", http.StatusNoContent, nil)
+
+	call(t, h, "GET", path, "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "POST", path, `{"date":20240100,"balance":0}`, http.StatusBadRequest, nil)
+	call(t, h, "POST", "/account/9999/reconcile", `{"date":`+date+`,"balance":0}`, http.StatusNotFound, nil)
+}
+
+func TestAPICloseAccount(t *testing.T) {
+	h := newAPI(t)
+	date := int(bcdate.CurrentMonth()) + 1
+	day := strconv.Itoa(date)
+
+	var acct, savings idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	call(t, h, "POST", "/account", `{"name":"Savings"}`, http.StatusCreated, &savings)
+	aid := strconv.Itoa(acct.ID)
+	call(t, h, "POST", "/transaction", `{"accountId":`+aid+`,"type":1,"postDate":`+day+`,"amount":5000}`, http.StatusCreated, nil)
+	path := "/account/" + aid
+
+	call(t, h, "POST", path+"/close", `{"date":`+day+`}`, http.StatusBadRequest, nil)
+	var ja struct {
+		Closed *int `json:"closed"`
+	}
+	call(t, h, "POST", path+"/close", `{"date":`+day+`,"transferTo":`+strconv.Itoa(savings.ID)+`}`, http.StatusOK, &ja)
+	if ja.Closed == nil || *ja.Closed != date {
+		t.Fatalf("POST close = %+v", ja)
+	}
+	call(t, h, "PATCH", path, `{"name":"Old checking"}`, http.StatusOK, &ja)
+	if ja.Closed == nil {
+		t.Fatalf("PATCH account = %+v", ja)
+	}
+	call(t, h, "POST", "/transaction", `{"accountId":`+aid+`,"postDate":`+strconv.Itoa(date+1)+`,"amount":1}`, http.StatusConflict, nil)
+	call(t, h, "POST", path+"/close", `{"date":`+day+`}`, http.StatusBadRequest, nil)
+
+	call(t, h, "POST", path+"/reopen", "", http.StatusOK, &ja)
+	if ja.Closed != nil {
+		t.Fatalf("POST reopen = %+v", ja)
+	}
+	call(t, h, "POST", path+"/reopen", "", http.StatusBadRequest, nil)
+	call(t, h, "GET", path+"/close", "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "POST", path+"/close", `{"date":20240100}`, http.StatusBadRequest, nil)
+	call(t, h, "POST", "/account/9999/close", `{"date":`+day+`}`, http.StatusNotFound, nil)
+}
+
+func TestAPIUndo(t *testing.T) {
+	h := newAPI(t)
+
+	call(t, h, "POST", "/undo", "", http.StatusConflict, nil)
+
+	var acct idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	path := "/account/" + strconv.Itoa(acct.ID)
+	call(t, h, "PATCH", path, `{"name":"Old checking"}`, http.StatusOK, nil)
+
+	type op struct {
+		ID     int    `json:"id"`
+		Actor  string `json:"actor"`
+		Name   string `json:"name"`
+		Undoes *int   `json:"undoes"`
+		Undone bool   `json:"undone"`
+	}
+	var ops []op
+	call(t, h, "GET", "/history", "", http.StatusOK, &ops)
+	if len(ops) != 2 || ops[0].Name != "UpdateAccount" || ops[1].Name != "NewAccount" || ops[0].Actor != "192.0.2.1" {
+		t.Fatalf("GET history = %+v", ops)
+	}
+	var cs []struct {
+		Table  string         `json:"table"`
+		Before map[string]any `json:"before"`
+		After  map[string]any `json:"after"`
+	}
+	call(t, h, "GET", "/history/"+strconv.Itoa(ops[0].ID), "", http.StatusOK, &cs)
+	if len(cs) != 1 || cs[0].Table != "a" || cs[0].Before["name"] != "Checking" || cs[0].After["name"] != "Old checking" {
+		t.Fatalf("GET history/%d = %+v", ops[0].ID, cs)
+	}
+
+	var undone []op
+	call(t, h, "POST", "/undo", "", http.StatusOK, &undone)
+	if len(undone) != 1 || undone[0].ID != ops[0].ID {
+		t.Fatalf("POST undo = %+v", undone)
+	}
+	var ja struct {
+		Account struct {
+			Name string `json:"name"`
+		} `json:"account"`
+	}
+	call(t, h, "GET", path, "", http.StatusOK, &ja)
+	if ja.Account.Name != "Checking" {
+		t.Fatalf("GET account after undo = %+v", ja)
+	}
+
+	call(t, h, "GET", "/history?n=1", "", http.StatusOK, &ops)
+	if len(ops) != 1 || ops[0].Name != "Undo" || ops[0].Undoes == nil || *ops[0].Undoes != undone[0].ID {
+		t.Fatalf("GET history?n=1 = %+v", ops)
+	}
+
+	call(t, h, "POST", "/undo", `{"n":5}`, http.StatusOK, &undone)
+	if len(undone) != 1 || undone[0].Name != "NewAccount" {
+		t.Fatalf("POST undo n=5 = %+v", undone)
+	}
+	call(t, h, "GET", path, "", http.StatusNotFound, nil)
+
+	call(t, h, "POST", "/undo", `{"n":0}`, http.StatusBadRequest, nil)
+	call(t, h, "GET", "/history?n=x", "", http.StatusBadRequest, nil)
+	call(t, h, "GET", "/undo", "", http.StatusMethodNotAllowed, nil)
+}
+
+func TestAPISessions(t *testing.T) {
+	d := db.NewSQLite()
+	if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		hash, err := auth.HashPassword("correct horse")
+		if err != nil {
+			t.Fatalf("HashPassword: %s", err)
+		}
+		if err := d.NewUser(&model.User{Name: name, Hash: hash}); err != nil {
+			t.Fatalf("NewUser: %s", err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", app.NewAPIHandler(d, backup.Dir{})))
+	h := session.NewSessions(auth.NewAuth(querymonth.NewQueryMonth(mux), d), d, session.Config{Idle: time.Hour, Absolute: time.Hour})
+
+	login := func(name string) *http.Cookie {
+		r := httptest.NewRequest("POST", "/login", strings.NewReader("name="+name+"&password=correct+horse"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusSeeOther || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("POST /login as %s = %d", name, w.Code)
+		}
+		return w.Result().Cookies()[0]
+	}
+	as := func(c *http.Cookie) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Path = "/api" + r.URL.Path
+			r.AddCookie(c)
+			h.ServeHTTP(w, r)
+		})
+	}
+	laptop, phone, bobs := login("alice"), login("alice"), login("bob")
+
+	type jsonSession struct {
+		ID      int  `json:"id"`
+		Current bool `json:"current"`
+	}
+	var ss []jsonSession
+	call(t, as(laptop), "GET", "/sessions", "", http.StatusOK, &ss)
+	if len(ss) != 2 || ss[0].Current == ss[1].Current {
+		t.Fatalf("GET sessions = %+v", ss)
+	}
+	other := ss[0]
+	if other.Current {
+		other = ss[1]
+	}
+
+	// Writes go down to the user in the audit log
+	call(t, as(laptop), "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, nil)
+	var ops []struct {
+		Actor string `json:"actor"`
+	}
+	call(t, as(laptop), "GET", "/history", "", http.StatusOK, &ops)
+	if len(ops) != 1 || ops[0].Actor != "alice" {
+		t.Fatalf("GET history = %+v", ops)
+	}
+
+	call(t, as(bobs), "DELETE", "/session/"+strconv.Itoa(other.ID), "", http.StatusNotFound, nil)
+	call(t, as(laptop), "DELETE", "/session/"+strconv.Itoa(other.ID), "", http.StatusNoContent, nil)
+	call(t, as(phone), "GET", "/sessions", "", http.StatusUnauthorized, nil)
+	call(t, as(laptop), "GET", "/sessions", "", http.StatusOK, &ss)
+	if len(ss) != 1 || !ss[0].Current {
+		t.Fatalf("GET sessions after revoking = %+v", ss)
+	}
+	call(t, newAPI(t), "GET", "/sessions", "", http.StatusNotFound, nil)
+}
+
+func TestAPIDuplicates(t *testing.T) {
+	h := newAPI(t)
+	day := strconv.Itoa(int(bcdate.CurrentMonth()) + 1)
+
+	var acct, at idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &acct)
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"postDate":`+day+`,"amount":-450,"memo":"Cafe Luna"}`, http.StatusCreated, &at)
+
+	var ds []struct {
+		Index       int     `json:"index"`
+		Score       float64 `json:"score"`
+		Transaction struct {
+			ID   int    `json:"id"`
+			Memo string `json:"memo"`
+		} `json:"transaction"`
+		Match idOnly `json:"match"`
+	}
+	batch := `[{"accountId":` + strconv.Itoa(acct.ID) + `,"postDate":` + day + `,"amount":-900,"memo":"Bakery"},` +
+		`{"accountId":` + strconv.Itoa(acct.ID) + `,"postDate":` + day + `,"amount":-450,"memo":"CAFE LUNA"}]`
+	call(t, h, "POST", "/transactions/duplicates", batch, http.StatusOK, &ds)
+	if len(ds) != 1 || ds[0].Index != 1 || ds[0].Match.ID != at.ID || ds[0].Score != 1 {
+		t.Fatalf("POST transactions/duplicates = %+v", ds)
+	}
+
+	call(t, h, "GET", "/transactions/duplicates", "", http.StatusOK, &ds)
+	if len(ds) != 0 {
+		t.Fatalf("GET transactions/duplicates = %+v", ds)
+	}
+	// Entering the same charge again is refused until it is asked for
+	cafe := `{"accountId":` + strconv.Itoa(acct.ID) + `,"postDate":` + day + `,"amount":-450,"memo":"Cafe Luna"}`
+	call(t, h, "POST", "/transaction", cafe, http.StatusConflict, nil)
+	call(t, h, "POST", "/transaction?allowDuplicate=maybe", cafe, http.StatusBadRequest, nil)
+	var again idOnly
+	call(t, h, "POST", "/transaction?allowDuplicate=true", cafe, http.StatusCreated, &again)
+	// A second coffee somewhere else the same day is its own purchase
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"postDate":`+day+`,"amount":-450,"memo":"Starbucks"}`, http.StatusCreated, nil)
+	call(t, h, "GET", "/transactions/duplicates", "", http.StatusOK, &ds)
+	if len(ds) != 1 || ds[0].Index != -1 || ds[0].Transaction.ID != again.ID || ds[0].Match.ID != at.ID {
+		t.Fatalf("GET transactions/duplicates = %+v", ds)
+	}
+	call(t, h, "DELETE", "/transactions/duplicates", "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "GET", "/transactions/nothing", "", http.StatusNotFound, nil)
+}
+
+func TestAPIBackups(t *testing.T) {
+	h := newAPI(t)
+
+	var list []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	call(t, h, "GET", "/admin/backups", "", http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("GET admin/backups = %+v", list)
+	}
+
+	var made struct {
+		Name   string   `json:"name"`
+		Size   int64    `json:"size"`
+		Pruned []string `json:"pruned"`
+	}
+	call(t, h, "POST", "/admin/backup", "", http.StatusCreated, &made)
+	if !strings.HasPrefix(made.Name, "budget-") || made.Size == 0 || made.Pruned == nil {
+		t.Fatalf("POST admin/backup = %+v", made)
+	}
+	call(t, h, "GET", "/admin/backups", "", http.StatusOK, &list)
+	if len(list) != 1 || list[0].Name != made.Name || list[0].Size != made.Size {
+		t.Fatalf("GET admin/backups = %+v", list)
+	}
+
+	call(t, h, "GET", "/admin/backup", "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "POST", "/admin/backups", "", http.StatusMethodNotAllowed, nil)
+	call(t, h, "GET", "/admin/nothing", "", http.StatusNotFound, nil)
+}
+
+func TestAPITransfers(t *testing.T) {
+	h := newAPI(t)
+	day := strconv.Itoa(int(bcdate.CurrentMonth()) + 1)
+
+	var chk, sav idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking"}`, http.StatusCreated, &chk)
+	call(t, h, "POST", "/account", `{"name":"Savings"}`, http.StatusCreated, &sav)
+
+	var xfer struct {
+		ID     int `json:"id"`
+		FromID int `json:"fromId"`
+		ToID   int `json:"toId"`
+		Amount int `json:"amount"`
+	}
+	call(t, h, "POST", "/transfer", `{"fromAccountId":`+strconv.Itoa(chk.ID)+`,"toAccountId":`+strconv.Itoa(sav.ID)+`,"postDate":`+day+`,"amount":2500}`, http.StatusCreated, &xfer)
+
+	// Each leg names the account on the other side
+	var leg struct {
+		Amount           int  `json:"amount"`
+		TransferID       *int `json:"transferId"`
+		CounterAccountID *int `json:"counterAccountId"`
+	}
+	call(t, h, "GET", "/transaction/"+strconv.Itoa(xfer.FromID), "", http.StatusOK, &leg)
+	if leg.Amount != -2500 || leg.TransferID == nil || *leg.TransferID != xfer.ID || leg.CounterAccountID == nil || *leg.CounterAccountID != sav.ID {
+		t.Fatalf("GET from leg = %+v", leg)
+	}
+
+	// Patching a leg moves the other one with it
+	call(t, h, "PATCH", "/transaction/"+strconv.Itoa(xfer.ToID), `{"amount":3000}`, http.StatusOK, nil)
+	call(t, h, "GET", "/transfer/"+strconv.Itoa(xfer.ID), "", http.StatusOK, &xfer)
+	if xfer.Amount != 3000 {
+		t.Fatalf("GET transfer after leg PATCH = %+v", xfer)
+	}
+
+	call(t, h, "PATCH", "/transfer/"+strconv.Itoa(xfer.ID), `{"toAccountId":`+strconv.Itoa(chk.ID)+`}`, http.StatusBadRequest, nil)
+	call(t, h, "PATCH", "/transfer/"+strconv.Itoa(xfer.ID), `{"envelopeId":null,"amount":-5}`, http.StatusBadRequest, nil)
+
+	call(t, h, "DELETE", "/transaction/"+strconv.Itoa(xfer.FromID), "", http.StatusNoContent, nil)
+	call(t, h, "GET", "/transfer/"+strconv.Itoa(xfer.ID), "", http.StatusNotFound, nil)
+	call(t, h, "GET", "/transaction/"+strconv.Itoa(xfer.ToID), "", http.StatusNotFound, nil)
+}
+
+func TestAPIErrors(t *testing.T) {
+	h := newAPI(t)
+
+	var acct idOnly
+	call(t, h, "POST", "/account", `{"name":"Checking","class":1}`, http.StatusCreated, &acct)
+
+	var at idOnly
+	call(t, h, "POST", "/transaction", `{"accountId":`+strconv.Itoa(acct.ID)+`,"postDate":20200101,"amount":1}`, http.StatusCreated, &at)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/nothing", "", http.StatusNotFound},
+		{"GET", "/account/9999", "", http.StatusNotFound},
+		{"GET", "/account/abc", "", http.StatusBadRequest},
+		{"PUT", "/account/" + strconv.Itoa(acct.ID), "", http.StatusMethodNotAllowed},
+		{"GET", "/account", "", http.StatusMethodNotAllowed},
+		{"POST", "/account", ``, http.StatusBadRequest},
+		{"POST", "/account", `{"name":`, http.StatusBadRequest},
+		{"POST", "/account", `{"name":"x","bogus":1}`, http.StatusBadRequest},
+		{"POST", "/account", `{"institution":"no name"}`, http.StatusBadRequest},
+		{"PATCH", "/account/" + strconv.Itoa(acct.ID), `{"class":2}`, http.StatusBadRequest},
+		{"POST", "/transaction", `{"accountId":9999,"postDate":20200101,"amount":1}`, http.StatusBadRequest},
+		{"POST", "/transaction", `{"accountId":` + strconv.Itoa(acct.ID) + `,"postDate":2020,"amount":1}`, http.StatusBadRequest},
+		{"DELETE", "/transaction/9999", "", http.StatusNotFound},
+		{"PATCH", "/transaction/" + strconv.Itoa(at.ID), `{"accountId":9999}`, http.StatusBadRequest},
+		{"PATCH", "/transaction/" + strconv.Itoa(at.ID), `{"splits":[{"envelopeId":null,"amount":1},{"envelopeId":null,"amount":1}]}`, http.StatusBadRequest},
+		{"PATCH", "/transaction/" + strconv.Itoa(at.ID), `{"splits":[{"envelopeId":9999,"amount":0},{"envelopeId":null,"amount":1}]}`, http.StatusBadRequest},
+		{"POST", "/transfer", `{"fromAccountId":` + strconv.Itoa(acct.ID) + `,"toAccountId":` + strconv.Itoa(acct.ID) + `,"postDate":20200101,"amount":1}`, http.StatusBadRequest},
+		{"POST", "/transfer", `{"fromAccountId":` + strconv.Itoa(acct.ID) + `,"toAccountId":9999,"postDate":20200101,"amount":1}`, http.StatusBadRequest},
+		{"GET", "/transfer/9999", "", http.StatusNotFound},
+		{"DELETE", "/group/1", "", http.StatusConflict},
+		{"POST", "/envelope", `{"groupId":9999,"name":"Lost"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		var e struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		}
+		call(t, h, tt.method, tt.path, tt.body, tt.status, &e)
+		if e.Status != tt.status || e.Message == "" {
+			t.Fatalf("%s %s error body = %+v", tt.method, tt.path, e)
+		}
+	}
+}
+
+// A failure on our side says what failed without the SQL or driver text behind it
+func TestAPIInternalError(t *testing.T) {
+	d := db.NewSQLite()
+	if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	h := querymonth.NewQueryMonth(app.NewAPIHandler(d, backup.Dir{Path: filepath.Join(t.TempDir(), "backups"), Keep: 2}))
+	d.Close()
+
+	var e struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	}
+	call(t, h, "GET", "/accounts", "", http.StatusInternalServerError, &e)
+	if e.Status != http.StatusInternalServerError || strings.Contains(e.Message, "sql") || strings.Contains(e.Message, "--") {
+		t.Fatalf("GET /accounts on a closed DB = %+v", e)
+	}
+}
@@ -2,14 +2,19 @@ package app
 
 import (
 	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/budget"
 	"budgeting/internal/pkg/db"
 	"budgeting/internal/pkg/middleware/querymonth"
 	"budgeting/internal/pkg/model"
 	"budgeting/internal/pkg/shiftpath"
+	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 )
 
 // Handler for View endpoints
@@ -23,7 +28,8 @@ func NewViewHandler(sdb db.DB) http.Handler {
 
 	tmpl, err := template.New("View").
 		Funcs(map[string]any{
-			"FmtVal": model.FormatVal,
+			"FmtVal":    model.FormatVal,
+			"FmtAmount": formatAmount,
 		}).
 		ParseGlob("web/template/*.html")
 	if err != nil {
@@ -42,7 +48,8 @@ func (h *ViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// This is faster for debugging when the templates are constantly changing
 	tmpl, err := template.New("View").
 		Funcs(map[string]any{
-			"FmtVal": model.FormatVal,
+			"FmtVal":    model.FormatVal,
+			"FmtAmount": formatAmount,
 		}).
 		ParseGlob("web/template/*.html")
 	if err != nil {
@@ -50,6 +57,9 @@ func (h *ViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	h.tmpl = tmpl
 
+	// Writes made for this request go down to whoever made it in the audit log
+	h = &ViewHandler{h.sdb.WithActor(requestActor(r)), h.tmpl}
+
 	switch head {
 	case "":
 		// Default to envelopes view
@@ -60,12 +70,16 @@ func (h *ViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.ServeHTTP_envelopes(w, r)
 	case "envelope":
 		h.ServeHTTP_envelope(w, r, tail)
+	case "budget":
+		h.ServeHTTP_budget(w, r)
 	case "accounts":
 		h.ServeHTTP_accounts(w, r)
 	case "account":
 		h.ServeHTTP_account(w, r, tail)
 	case "transactions":
 		h.ServeHTTP_transactions(w, r)
+	case "reconcile":
+		h.ServeHTTP_reconcile(w, r, tail)
 	case "analysis":
 		h.ServeHTTP_analysis(w, r)
 
@@ -112,6 +126,10 @@ func (h *ViewHandler) ServeHTTP_accounts(w http.ResponseWriter, r *http.Request)
 	acctSumm := make(map[model.PKEY]as, len(accts))
 
 	for _, acct := range accts {
+		if acct.ClosedBefore(month) {
+			continue
+		}
+
 		s, err := h.sdb.GetAccountSummary(month, acct.ID)
 		if err != nil {
 			panic(fmt.Errorf("failed to get account summary -- %w", err))
@@ -174,6 +192,16 @@ func (h *ViewHandler) ServeHTTP_transactions(w http.ResponseWriter, r *http.Requ
 		panic(fmt.Errorf("failed to get envelope list -- %w", err))
 	}
 
+	ps := make(map[model.PKEY]string)
+
+	if payees, err := h.sdb.GetPayees(); err == nil {
+		for _, p := range payees {
+			ps[p.ID] = p.Name
+		}
+	} else {
+		panic(fmt.Errorf("failed to get payee list -- %w", err))
+	}
+
 	atList, err := h.sdb.GetAllTransactions(month)
 	if err != nil {
 		panic(fmt.Errorf("failed to get transaction list -- %w", err))
@@ -185,6 +213,7 @@ func (h *ViewHandler) ServeHTTP_transactions(w http.ResponseWriter, r *http.Requ
 		S   model.Summary
 		AS  map[model.PKEY]string
 		ES  map[model.PKEY]string
+		PS  map[model.PKEY]string
 		ATs []model.AccountTransaction
 	}{
 		URL: "/transactions",
@@ -192,6 +221,7 @@ func (h *ViewHandler) ServeHTTP_transactions(w http.ResponseWriter, r *http.Requ
 		S:   summ,
 		AS:  as,
 		ES:  es,
+		PS:  ps,
 		ATs: atList,
 	})
 	if err != nil {
@@ -224,6 +254,8 @@ func (h *ViewHandler) ServeHTTP_envelopes(w http.ResponseWriter, r *http.Request
 	eges := make(map[model.PKEY]ege)
 	egids := make([]model.PKEY, 0)
 
+	closed := h.closedAccounts()
+
 	egs, err := h.sdb.GetEnvelopeGroups()
 	if err != nil {
 		panic(fmt.Errorf("failed to get envelope groups -- %w", err))
@@ -242,6 +274,10 @@ func (h *ViewHandler) ServeHTTP_envelopes(w http.ResponseWriter, r *http.Request
 		ggoal := 0
 
 		for _, e := range es {
+			if a, ok := closedDebt(closed, e); ok && a.ClosedBefore(month) {
+				continue
+			}
+
 			sum, err := h.sdb.GetEnvelopeSummary(month, e.ID)
 			if err != nil {
 				panic(fmt.Errorf("failed to get envelope summary -- %w", err))
@@ -284,6 +320,99 @@ func (h *ViewHandler) ServeHTTP_envelopes(w http.ResponseWriter, r *http.Request
 
 }
 
+func (h *ViewHandler) ServeHTTP_budget(w http.ResponseWriter, r *http.Request) {
+	// Render and return the month's assignments, changes go through /api/budget
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	b, err := budget.Get(h.sdb, month)
+	if err != nil {
+		panic(fmt.Errorf("failed to get budget -- %w", err))
+	}
+
+	egs, err := h.sdb.GetEnvelopeGroups()
+	if err != nil {
+		panic(fmt.Errorf("failed to get envelope groups -- %w", err))
+	}
+
+	// Lines under their group, hidden envelopes only while they still hold money
+	type group struct {
+		G     model.EnvelopeGroup
+		Lines []budget.Line
+	}
+
+	groups := make([]group, 0, len(egs))
+	byID := make(map[model.PKEY]int, len(egs))
+
+	for _, eg := range egs {
+		byID[eg.ID] = len(groups)
+		groups = append(groups, group{G: eg})
+	}
+
+	// A closed debt account's envelope keeps its place up to the close month
+	closed := h.closedAccounts()
+
+	for _, l := range b.Lines {
+		if a, ok := closedDebt(closed, l.Envelope); ok {
+			if a.ClosedBefore(month) {
+				continue
+			}
+		} else if l.Envelope.Hidden && l.Bal == 0 {
+			continue
+		}
+		if i, ok := byID[l.Envelope.GroupID]; ok {
+			groups[i].Lines = append(groups[i].Lines, l)
+		}
+	}
+
+	summ, err := h.sdb.GetOverallSummary(month)
+	if err != nil {
+		panic(fmt.Errorf("failed to get overall summary from DB -- %w", err))
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "budget.html", struct {
+		URL string
+		QM  bcdate.BCDate
+		S   model.Summary
+		B   budget.Budget
+		G   []group
+	}{
+		URL: "/budget",
+		QM:  month,
+		S:   summ,
+		B:   b,
+		G:   groups,
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to execute template -- %w", err))
+	}
+}
+
+// Closed accounts by ID
+func (h *ViewHandler) closedAccounts() map[model.PKEY]model.Account {
+	accts, err := h.sdb.GetAccounts()
+	if err != nil {
+		panic(fmt.Errorf("failed to get account list -- %w", err))
+	}
+
+	closed := make(map[model.PKEY]model.Account)
+	for _, a := range accts {
+		if a.Closed.Valid {
+			closed[a.ID] = a
+		}
+	}
+	return closed
+}
+
+// The closed account behind a debt envelope
+func closedDebt(closed map[model.PKEY]model.Account, e model.Envelope) (model.Account, bool) {
+	if !e.DebtAccount.Valid {
+		return model.Account{}, false
+	}
+	a, ok := closed[model.PKEY(e.DebtAccount.Int32)]
+	return a, ok
+}
+
 func (h *ViewHandler) ServeHTTP_snip_summary(w http.ResponseWriter, r *http.Request) {
 	// Render and return summary bar
 
@@ -327,6 +456,29 @@ func (h *ViewHandler) ServeHTTP_account(w http.ResponseWriter, r *http.Request,
 		envList[env.ID] = env.Name
 	}
 
+	acctNames, err := h.sdb.GetAccounts()
+	if err != nil {
+		panic(fmt.Errorf("failed to get account list -- %w", err))
+	}
+
+	// Named by transfer legs
+	acctList := make(map[model.PKEY]string, len(acctNames))
+
+	for _, a := range acctNames {
+		acctList[a.ID] = a.Name
+	}
+
+	payees, err := h.sdb.GetPayees()
+	if err != nil {
+		panic(fmt.Errorf("failed to get payee list -- %w", err))
+	}
+
+	payeeList := make(map[model.PKEY]string, len(payees))
+
+	for _, p := range payees {
+		payeeList[p.ID] = p.Name
+	}
+
 	acct, err := h.sdb.GetAccount(model.PKEY(iid))
 	if err != nil {
 		panic(fmt.Errorf("failed to get account list -- %w", err))
@@ -342,27 +494,51 @@ func (h *ViewHandler) ServeHTTP_account(w http.ResponseWriter, r *http.Request,
 		panic(fmt.Errorf("failed to get account transactions -- %w", err))
 	}
 
+	// Upcoming occurrences, schedules that have ended are left out
+	scheds, err := h.sdb.GetAccountSchedules(acct.ID)
+	if err != nil {
+		panic(fmt.Errorf("failed to get account schedules -- %w", err))
+	}
+
+	upcoming := make([]model.Schedule, 0, len(scheds))
+	for _, sc := range scheds {
+		if sc.Next() != bcdate.Never() {
+			upcoming = append(upcoming, sc)
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].Next() < upcoming[j].Next() })
+
 	summ, err := h.sdb.GetOverallSummary(month)
 	if err != nil {
 		panic(fmt.Errorf("failed to get overall summary from DB -- %w", err))
 	}
 
 	err = h.tmpl.ExecuteTemplate(w, "account.html", struct {
-		URL string
-		QM  bcdate.BCDate
-		S   model.Summary
-		ES  map[model.PKEY]string
-		A   model.Account
-		AS  model.AccountSummary
-		AT  []model.AccountTransaction
+		URL    string
+		QM     bcdate.BCDate
+		S      model.Summary
+		ES     map[model.PKEY]string
+		AN     map[model.PKEY]string
+		PS     map[model.PKEY]string
+		A      model.Account
+		Closed bcdate.BCDate
+		Today  string
+		AS     model.AccountSummary
+		AT     []model.AccountTransaction
+		SC     []model.Schedule
 	}{
-		URL: "/account/" + id,
-		QM:  month,
-		S:   summ,
-		ES:  envList,
-		A:   acct,
-		AS:  accts,
-		AT:  trans,
+		URL:    "/account/" + id,
+		QM:     month,
+		S:      summ,
+		ES:     envList,
+		AN:     acctList,
+		PS:     payeeList,
+		A:      acct,
+		Closed: bcdate.BCDate(acct.Closed.Int32),
+		Today:  bcdate.FromTime(time.Now()).FmtDate(),
+		AS:     accts,
+		AT:     trans,
+		SC:     upcoming,
 	})
 	if err != nil {
 		panic(fmt.Errorf("failed to execute template -- %w", err))
@@ -370,15 +546,143 @@ func (h *ViewHandler) ServeHTTP_account(w http.ResponseWriter, r *http.Request,
 }
 
 func (h *ViewHandler) ServeHTTP_envelope(w http.ResponseWriter, r *http.Request, tail string) {
-	w.Write([]byte("/envelope/" + tail))
+	// Render and return envelope detail, assignments and the account transactions drawing on it
 
-	// TODO: Render and return envelope transactions
-}
+	id, _ := shiftpath.ShiftPath(tail)
+	iid, err := strconv.Atoi(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+
+	env, err := h.sdb.GetEnvelope(model.PKEY(iid))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		panic(fmt.Errorf("failed to get envelope -- %w", err))
+	}
+
+	envs, err := h.sdb.GetEnvelopeSummary(month, env.ID)
+	if err != nil {
+		panic(fmt.Errorf("failed to get envelope summary -- %w", err))
+	}
+
+	ets, err := h.sdb.GetEnvelopeTransactions(month, env.ID)
+	if err != nil {
+		panic(fmt.Errorf("failed to get envelope transactions -- %w", err))
+	}
+
+	accts, err := h.sdb.GetAccounts()
+	if err != nil {
+		panic(fmt.Errorf("failed to get account list -- %w", err))
+	}
+
+	acctList := make(map[model.PKEY]string, len(accts))
+
+	for _, a := range accts {
+		acctList[a.ID] = a.Name
+	}
 
-func (h *ViewHandler) ServeHTTP_snip_transaction(w http.ResponseWriter, r *http.Request, tail string) {
-	w.Write([]byte("/view/transaction"))
+	payees, err := h.sdb.GetPayees()
+	if err != nil {
+		panic(fmt.Errorf("failed to get payee list -- %w", err))
+	}
+
+	payeeList := make(map[model.PKEY]string, len(payees))
+
+	for _, p := range payees {
+		payeeList[p.ID] = p.Name
+	}
+
+	// Account transactions of the month on this envelope, a split only counts its shares here
+	type eat struct {
+		AT     model.AccountTransaction
+		Amount int
+		Memo   string
+	}
+
+	all, err := h.sdb.GetAllTransactions(month)
+	if err != nil {
+		panic(fmt.Errorf("failed to get transactions -- %w", err))
+	}
+
+	eats := make([]eat, 0)
+
+	for _, at := range all {
+		if at.EnvelopeID.Valid && model.PKEY(at.EnvelopeID.Int32) == env.ID {
+			eats = append(eats, eat{at, at.Amount, at.Memo})
+		}
+		for _, sp := range at.Splits {
+			if sp.EnvelopeID.Valid && model.PKEY(sp.EnvelopeID.Int32) == env.ID {
+				memo := sp.Memo
+				if memo == "" {
+					memo = at.Memo
+				}
+				eats = append(eats, eat{at, sp.Amount, memo})
+			}
+		}
+	}
+
+	// How far along the goal is: monthly goals count what went in this month, targets the balance
+	type progress struct {
+		Want int
+		Have int
+		Of   int
+		Pct  int
+	}
+
+	goal := progress{Want: env.GoalWant(envs.Bal)}
 
-	// TODO: Render and return entry form to add or update a transaction
+	switch env.Goal {
+	case model.GT_RECUR:
+		goal.Have, goal.Of = envs.In, env.GoalAmt
+	case model.GT_TGT, model.GT_RECTIL:
+		goal.Have, goal.Of = envs.Bal, env.GoalTgt
+	}
+	if goal.Of > 0 {
+		switch {
+		case goal.Have >= goal.Of:
+			goal.Pct = 100
+		case goal.Have > 0:
+			goal.Pct = goal.Have * 100 / goal.Of
+		}
+	}
+
+	summ, err := h.sdb.GetOverallSummary(month)
+	if err != nil {
+		panic(fmt.Errorf("failed to get overall summary from DB -- %w", err))
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "envelope.html", struct {
+		URL string
+		QM  bcdate.BCDate
+		S   model.Summary
+		AN  map[model.PKEY]string
+		PS  map[model.PKEY]string
+		E   model.Envelope
+		ES  model.EnvelopeSummary
+		ET  []model.EnvelopeTransaction
+		AT  []eat
+		G   progress
+	}{
+		URL: "/envelope/" + id,
+		QM:  month,
+		S:   summ,
+		AN:  acctList,
+		PS:  payeeList,
+		E:   env,
+		ES:  envs,
+		ET:  ets,
+		AT:  eats,
+		G:   goal,
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to execute template -- %w", err))
+	}
 }
 
 func (h *ViewHandler) ServeHTTP_analysis(w http.ResponseWriter, r *http.Request) {
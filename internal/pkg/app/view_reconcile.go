@@ -0,0 +1,84 @@
+package app
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/middleware/querymonth"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/shiftpath"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Reconcile an account against a statement
+// Lists the transactions not yet reconciled to tick off, the totals run in the page and finishing posts to
+// POST /api/account/<id>/reconcile
+
+func (h *ViewHandler) ServeHTTP_reconcile(w http.ResponseWriter, r *http.Request, tail string) {
+	id, _ := shiftpath.ShiftPath(tail)
+	iid, err := strconv.Atoi(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	acct, err := h.sdb.GetAccount(model.PKEY(iid))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		panic(fmt.Errorf("failed to get account -- %w", err))
+	}
+
+	sbal, err := h.sdb.GetStartingBalance(acct.ID)
+	if err != nil {
+		panic(fmt.Errorf("failed to get starting balance -- %w", err))
+	}
+
+	trans, err := h.sdb.GetAllAccountTransactions(acct.ID)
+	if err != nil {
+		panic(fmt.Errorf("failed to get account transactions -- %w", err))
+	}
+
+	// Everything reconciled so far is settled, only the rest is listed
+	reconciled := sbal
+	open := make([]model.AccountTransaction, 0, len(trans))
+	for _, at := range trans {
+		if at.Reconciled {
+			reconciled += at.Amount
+		} else {
+			open = append(open, at)
+		}
+	}
+
+	month := bcdate.BCDate(querymonth.GetQM(r))
+	summ, err := h.sdb.GetOverallSummary(month)
+	if err != nil {
+		panic(fmt.Errorf("failed to get overall summary from DB -- %w", err))
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "reconcile.html", struct {
+		URL        string
+		QM         bcdate.BCDate
+		S          model.Summary
+		A          model.Account
+		Reconciled int
+		Today      string
+		AT         []model.AccountTransaction
+	}{
+		URL:        "/reconcile/" + id,
+		QM:         month,
+		S:          summ,
+		A:          acct,
+		Reconciled: reconciled,
+		Today:      bcdate.FromTime(time.Now()).FmtDate(),
+		AT:         open,
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to execute template -- %w", err))
+	}
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/middleware/querymonth"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func getPage(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestEnvelopeView(t *testing.T) {
+	views, d := newViews(t)
+	h := querymonth.NewQueryMonth(views)
+	month := bcdate.CurrentMonth()
+
+	chk := model.Account{Institution: "Bank", Name: "Checking"}
+	if err := d.NewAccount(&chk); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	envs := map[string]*model.Envelope{
+		"food":    {GroupID: 1, Name: "Food", Goal: model.GT_RECUR, GoalAmt: 40000},
+		"fun":     {GroupID: 1, Name: "Fun"},
+		"car":     {GroupID: 1, Name: "Car", Goal: model.GT_TGT, GoalTgt: 50000},
+		"holiday": {GroupID: 1, Name: "Holiday", Goal: model.GT_TGT, GoalTgt: 20000},
+	}
+	for _, e := range envs {
+		if err := d.NewEnvelope(e); err != nil {
+			t.Fatalf("NewEnvelope: %s", err)
+		}
+	}
+	for _, et := range []model.EnvelopeTransaction{
+		{EnvelopeID: envs["food"].ID, PostDate: month + 1, Amount: 10000},
+		{EnvelopeID: envs["holiday"].ID, PostDate: month + 1, Amount: 30000},
+	} {
+		if err := d.NewEnvelopeTransaction(&et); err != nil {
+			t.Fatalf("NewEnvelopeTransaction: %s", err)
+		}
+	}
+
+	id := func(e string) model.PKEY { return envs[e].ID }
+	nid := func(e string) sql.NullInt32 { return sql.NullInt32{Int32: int32(id(e)), Valid: true} }
+	for _, at := range []model.AccountTransaction{
+		{AccountID: chk.ID, PostDate: month + 2, Amount: -3456, Memo: "Market", Splits: []model.Split{
+			{EnvelopeID: nid("food"), Amount: -1234, Memo: "Bread"},
+			{EnvelopeID: nid("fun"), Amount: -2222, Memo: "Cinema"},
+		}},
+		{AccountID: chk.ID, EnvelopeID: nid("fun"), PostDate: month + 3, Amount: -500, Memo: "Popcorn"},
+	} {
+		if err := d.NewAccountTransaction(&at); err != nil {
+			t.Fatalf("NewAccountTransaction: %s", err)
+		}
+	}
+
+	page := func(e, query string) string {
+		t.Helper()
+		w := getPage(h, "/envelope/"+strconv.Itoa(int(id(e)))+query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET envelope %s%s = %d", e, query, w.Code)
+		}
+		return w.Body.String()
+	}
+	qm := "?qm=" + month.FmtMonth()
+
+	// A split shows up with only this envelope's share and memo
+	body := page("food", qm)
+	if !strings.Contains(body, "Bread") || !strings.Contains(body, model.FormatVal(-1234)) {
+		t.Fatalf("Food page is missing its share of the split:\n%s", body)
+	}
+	if strings.Contains(body, "Cinema") || strings.Contains(body, model.FormatVal(-3456)) || strings.Contains(body, "Popcorn") {
+		t.Fatalf("Food page shows another envelope's money:\n%s", body)
+	}
+	if body := page("fun", qm); !strings.Contains(body, "Cinema") || !strings.Contains(body, "Popcorn") || strings.Contains(body, "Bread") {
+		t.Fatalf("Fun page = \n%s", body)
+	}
+
+	// Monthly goals count what went in, targets the balance, and progress stops at 100
+	for e, pct := range map[string]string{"food": "25", "car": "0", "holiday": "100"} {
+		if body := page(e, qm); !strings.Contains(body, `<progress max="100" value="`+pct+`">`) {
+			t.Errorf("%s progress is not %s%%:\n%s", e, pct, body)
+		}
+	}
+	if body := page("fun", qm); strings.Contains(body, "<progress") {
+		t.Errorf("Fun has no goal but shows progress:\n%s", body)
+	}
+
+	// Another month shows that month, with links on either side of it
+	prev := month.PrevMonth()
+	body = page("food", "?qm="+prev.FmtMonth())
+	if strings.Contains(body, "Bread") || !strings.Contains(body, `<progress max="100" value="0">`) {
+		t.Fatalf("Food page for last month shows this month:\n%s", body)
+	}
+	for _, link := range []string{prev.PrevMonth().FmtMonth(), month.FmtMonth()} {
+		if !strings.Contains(body, "/envelope/"+strconv.Itoa(int(id("food")))+"?qm="+link) {
+			t.Errorf("Food page for last month has no link to %s", link)
+		}
+	}
+
+	// A missing or unreadable month is the current one
+	for _, query := range []string{"", "?qm=2020-13", "?qm=soon"} {
+		if body := page("food", query); !strings.Contains(body, "Bread") || !strings.Contains(body, "<h1>"+month.FmtMonth()+"</h1>") {
+			t.Errorf("Food page with %q is not this month:\n%s", query, body)
+		}
+	}
+
+	for _, path := range []string{"/envelope/9999", "/envelope/abc", "/envelope/"} {
+		if w := getPage(h, path+qm); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+}
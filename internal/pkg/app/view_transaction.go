@@ -0,0 +1,276 @@
+package app
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/shiftpath"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry form for account transactions
+// GET /view/transaction[?account=ID] is a blank form, GET /view/transaction/<id> edits one
+// Posting the form back saves it and redirects to the account, or renders the form again with the errors next to their fields
+
+// Types the form offers, transfers are made through the transfer API and keep their type
+var formTypes = []model.TransactionType{model.TT_NORM, model.TT_INCOME, model.TT_ADJUST}
+
+type transactionForm struct {
+	ID        model.PKEY
+	AccountID model.PKEY
+	// 0 when unassigned
+	EnvelopeID model.PKEY
+	Typ        model.TransactionType
+	Date       string
+	Amount     string
+	Cleared    bool
+	Memo       string
+
+	// Kept as they are, the form does not edit shares or transfer links
+	Split    bool
+	Transfer bool
+
+	// Field name to message, and errors that belong to no one field
+	Errors map[string]string
+	Err    string
+
+	Accounts  []model.Account
+	Envelopes []model.Envelope
+	Types     []model.TransactionType
+}
+
+func (h *ViewHandler) ServeHTTP_snip_transaction(w http.ResponseWriter, r *http.Request, tail string) {
+	var at model.AccountTransaction
+	id, _ := shiftpath.ShiftPath(tail)
+	if len(id) > 0 {
+		iid, err := strconv.Atoi(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		at, err = h.sdb.GetAccountTransaction(model.PKEY(iid))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			panic(fmt.Errorf("failed to get transaction -- %w", err))
+		}
+	} else {
+		at.PostDate = bcdate.FromTime(time.Now())
+		if aid, err := strconv.Atoi(r.URL.Query().Get("account")); err == nil {
+			at.AccountID = model.PKEY(aid)
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.renderTransactionForm(w, http.StatusOK, toTransactionForm(at))
+	case http.MethodPost:
+		h.postTransaction(w, r, at)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ViewHandler) postTransaction(w http.ResponseWriter, r *http.Request, at model.AccountTransaction) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f := toTransactionForm(at)
+	f.EnvelopeID = 0
+	f.Date = r.PostForm.Get("date")
+	f.Amount = r.PostForm.Get("amount")
+	f.Cleared = r.PostForm.Get("cleared") != ""
+	f.Memo = strings.TrimSpace(r.PostForm.Get("memo"))
+
+	// The account of a saved transaction stays put, moving one is left to the API
+	if at.ID == 0 {
+		aid, err := strconv.Atoi(r.PostForm.Get("account"))
+		f.AccountID = model.PKEY(aid)
+		if err != nil {
+			f.Errors["account"] = "Pick an account"
+		} else if _, err := h.sdb.GetAccount(f.AccountID); err != nil {
+			f.Errors["account"] = "No such account"
+		}
+	}
+	at.AccountID = f.AccountID
+
+	if !f.Transfer {
+		typ, err := strconv.Atoi(r.PostForm.Get("type"))
+		f.Typ = model.TransactionType(typ)
+		if err != nil || !formType(f.Typ) {
+			f.Errors["type"] = "Pick a type"
+		}
+		at.Typ = f.Typ
+	}
+
+	if !f.Split {
+		at.EnvelopeID = sql.NullInt32{}
+		if e := r.PostForm.Get("envelope"); e != "" {
+			eid, err := strconv.Atoi(e)
+			f.EnvelopeID = model.PKEY(eid)
+			if err != nil {
+				f.Errors["envelope"] = "Pick an envelope"
+			} else if _, err := h.sdb.GetEnvelope(f.EnvelopeID); err != nil {
+				f.Errors["envelope"] = "No such envelope"
+			} else {
+				at.EnvelopeID = sql.NullInt32{Int32: int32(eid), Valid: true}
+			}
+		}
+	}
+
+	if d, err := time.Parse("2006-01-02", f.Date); err != nil {
+		f.Errors["date"] = "Date must be YYYY-MM-DD"
+	} else {
+		at.PostDate = bcdate.FromTime(d)
+	}
+
+	if v, err := parseAmount(f.Amount); err != nil {
+		f.Errors["amount"] = "Amount must be like -12.34"
+	} else {
+		at.Amount = v
+	}
+
+	at.Cleared = f.Cleared
+	at.Memo = f.Memo
+
+	if len(f.Errors) > 0 {
+		h.renderTransactionForm(w, http.StatusUnprocessableEntity, f)
+		return
+	}
+
+	var err error
+	if at.ID == 0 {
+		err = h.sdb.NewAccountTransaction(&at)
+	} else {
+		err = h.sdb.UpdateAccountTransaction(at)
+	}
+	// Splits that no longer add up, transfer legs that cannot change, locked transactions and closed accounts are the user's to fix
+	if errors.Is(err, db.ErrInvalidSplit) || errors.Is(err, db.ErrInvalidTransfer) || errors.Is(err, db.ErrReconciled) || errors.Is(err, db.ErrAccountClosed) {
+		f.Err = err.Error()
+		h.renderTransactionForm(w, http.StatusUnprocessableEntity, f)
+		return
+	}
+	if err != nil {
+		panic(fmt.Errorf("failed to save transaction -- %w", err))
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/account/%d?qm=%s", at.AccountID, at.PostDate.FmtMonth()), http.StatusSeeOther)
+}
+
+func (h *ViewHandler) renderTransactionForm(w http.ResponseWriter, status int, f transactionForm) {
+	accts, err := h.sdb.GetAccounts()
+	if err != nil {
+		panic(fmt.Errorf("failed to get account list -- %w", err))
+	}
+	envs, err := h.sdb.GetEnvelopes()
+	if err != nil {
+		panic(fmt.Errorf("failed to get envelope list -- %w", err))
+	}
+	f.Accounts = accts
+	f.Envelopes = envs
+	f.Types = formTypes
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.tmpl.ExecuteTemplate(w, "transaction.html", f); err != nil {
+		panic(fmt.Errorf("failed to execute template -- %w", err))
+	}
+}
+
+func toTransactionForm(at model.AccountTransaction) transactionForm {
+	f := transactionForm{
+		ID:        at.ID,
+		AccountID: at.AccountID,
+		Typ:       at.Typ,
+		Date:      at.PostDate.FmtDate(),
+		Cleared:   at.Cleared,
+		Memo:      at.Memo,
+		Split:     at.IsSplit(),
+		Transfer:  at.TransferID.Valid,
+		Errors:    make(map[string]string),
+	}
+	if at.EnvelopeID.Valid {
+		f.EnvelopeID = model.PKEY(at.EnvelopeID.Int32)
+	}
+	if at.ID != 0 {
+		f.Amount = formatAmount(at.Amount)
+	}
+	return f
+}
+
+func formType(tt model.TransactionType) bool {
+	for _, t := range formTypes {
+		if t == tt {
+			return true
+		}
+	}
+	return false
+}
+
+// Amounts like -1,234.56 or 12, in cents
+// One sign at most, and commas only between groups of three digits
+func parseAmount(s string) (int, error) {
+	v := strings.TrimSpace(s)
+	neg := strings.HasPrefix(v, "-")
+	if neg || strings.HasPrefix(v, "+") {
+		v = v[1:]
+	}
+	if v == "" || v == "." {
+		return 0, fmt.Errorf("amount %q", s)
+	}
+
+	whole, frac, _ := strings.Cut(v, ".")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("amount %q has fractions of a cent", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if strings.Contains(whole, ",") {
+		groups := strings.Split(whole, ",")
+		if len(groups[0]) == 0 || len(groups[0]) > 3 {
+			return 0, fmt.Errorf("amount %q has misplaced commas", s)
+		}
+		for _, g := range groups[1:] {
+			if len(g) != 3 {
+				return 0, fmt.Errorf("amount %q has misplaced commas", s)
+			}
+		}
+		whole = strings.Join(groups, "")
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("amount %q", s)
+		}
+	}
+	cents, err := strconv.Atoi(whole + frac)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q -- %w", s, err)
+	}
+	if neg {
+		cents = -cents
+	}
+	return cents, nil
+}
+
+// Cents as parseAmount reads them back
+func formatAmount(v int) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
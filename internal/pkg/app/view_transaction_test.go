@@ -0,0 +1,245 @@
+package app
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"12", 1200, true},
+		{"-12.34", -1234, true},
+		{"+12.3", 1230, true},
+		{" .5 ", 50, true},
+		{"-1,234.56", -123456, true},
+		{"1,234,567", 123456700, true},
+		{"0.07", 7, true},
+		{"", 0, false},
+		{"-", 0, false},
+		{".", 0, false},
+		{"--5", 0, false},
+		{"+-5", 0, false},
+		{"-+5", 0, false},
+		{"1,2,3", 0, false},
+		{"12,34", 0, false},
+		{",123", 0, false},
+		{"1234,567", 0, false},
+		{"1,234,", 0, false},
+		{"1.234", 0, false},
+		{"1.2.3", 0, false},
+		{"12a", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.in)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseAmount(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseAmount(%q) = %d, want an error", tt.in, got)
+		}
+	}
+}
+
+// The views over a fresh DB, run from the repo root as they read web/template
+func newViews(t *testing.T) (http.Handler, db.DB) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %s", err)
+	}
+	if err := os.Chdir("../../.."); err != nil {
+		t.Fatalf("Chdir: %s", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	d := db.NewSQLite()
+	if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	return NewViewHandler(d), d
+}
+
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestTransactionForm(t *testing.T) {
+	h, d := newViews(t)
+	a := model.Account{Institution: "Bank", Name: "Checking"}
+	if err := d.NewAccount(&a); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	aid := strconv.Itoa(int(a.ID))
+	form := url.Values{"account": {aid}, "type": {"0"}, "date": {(bcdate.CurrentMonth() + 3).FmtDate()}, "memo": {"Groceries"}}
+
+	// A bad amount comes back as the form, with what was typed and the error next to it
+	form.Set("amount", "--5")
+	w := postForm(h, "/view/transaction", form)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST with a bad amount = %d, want 422", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Amount must be like -12.34") || !strings.Contains(body, `value="--5"`) || !strings.Contains(body, "Groceries") {
+		t.Fatalf("Form re-rendered without the error or the typed values:\n%s", body)
+	}
+	if ats, err := d.GetAllAccountTransactions(a.ID); err != nil || len(ats) != 0 {
+		t.Fatalf("Refused form saved %+v, %v", ats, err)
+	}
+
+	form.Set("amount", "-1,234.50")
+	w = postForm(h, "/view/transaction", form)
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/account/"+aid+"?") {
+		t.Fatalf("POST = %d to %q, want 303 to the account", w.Code, w.Header().Get("Location"))
+	}
+	ats, err := d.GetAllAccountTransactions(a.ID)
+	if err != nil || len(ats) != 1 || ats[0].Amount != -123450 || ats[0].Memo != "Groceries" {
+		t.Fatalf("Saved transactions = %+v, %v", ats, err)
+	}
+}
+
+// Entering after the close date is the user's mistake, shown on the form rather than a server error
+func TestTransactionFormClosedAccount(t *testing.T) {
+	h, d := newViews(t)
+	a := model.Account{Institution: "Bank", Name: "Old card"}
+	if err := d.NewAccount(&a); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	month := bcdate.CurrentMonth()
+	if err := d.CloseAccount(db.Closing{AccountID: a.ID, Date: month + 1}); err != nil {
+		t.Fatalf("CloseAccount: %s", err)
+	}
+
+	form := url.Values{"account": {strconv.Itoa(int(a.ID))}, "type": {"0"}, "date": {(month + 3).FmtDate()}, "amount": {"-5"}}
+	w := postForm(h, "/view/transaction", form)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST into a closed account = %d, want 422", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, db.ErrAccountClosed.Error()) {
+		t.Fatalf("Form re-rendered without the closed account error:\n%s", body)
+	}
+}
+
+// References the form cannot resolve come back inline, and nothing is saved
+func TestTransactionFormErrors(t *testing.T) {
+	h, d := newViews(t)
+	a := model.Account{Institution: "Bank", Name: "Checking"}
+	if err := d.NewAccount(&a); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	aid := strconv.Itoa(int(a.ID))
+	date := (bcdate.CurrentMonth() + 3).FmtDate()
+
+	tests := []struct {
+		name string
+		form url.Values
+		want string
+	}{
+		{"unknown account", url.Values{"account": {"9999"}, "type": {"0"}, "date": {date}, "amount": {"-5"}}, "No such account"},
+		{"no account", url.Values{"type": {"0"}, "date": {date}, "amount": {"-5"}}, "Pick an account"},
+		{"unknown envelope", url.Values{"account": {aid}, "envelope": {"9999"}, "type": {"0"}, "date": {date}, "amount": {"-5"}}, "No such envelope"},
+		{"bad envelope", url.Values{"account": {aid}, "envelope": {"food"}, "type": {"0"}, "date": {date}, "amount": {"-5"}}, "Pick an envelope"},
+		{"transfer type", url.Values{"account": {aid}, "type": {strconv.Itoa(int(model.TT_TRANSFER))}, "date": {date}, "amount": {"-5"}}, "Pick a type"},
+		{"unknown type", url.Values{"account": {aid}, "type": {"99"}, "date": {date}, "amount": {"-5"}}, "Pick a type"},
+		{"no type", url.Values{"account": {aid}, "date": {date}, "amount": {"-5"}}, "Pick a type"},
+	}
+	for _, tt := range tests {
+		w := postForm(h, "/view/transaction", tt.form)
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("POST with %s = %d, want 422 with %q:\n%s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+	if ats, err := d.GetAllAccountTransactions(a.ID); err != nil || len(ats) != 0 {
+		t.Fatalf("Refused forms saved %+v, %v", ats, err)
+	}
+}
+
+// Editing a split keeps its shares, editing a transfer leg keeps it a transfer and moves the other leg along
+func TestTransactionFormEditKeepsShape(t *testing.T) {
+	h, d := newViews(t)
+	chk := model.Account{Institution: "Bank", Name: "Checking"}
+	sav := model.Account{Institution: "Bank", Name: "Savings"}
+	for _, a := range []*model.Account{&chk, &sav} {
+		if err := d.NewAccount(a); err != nil {
+			t.Fatalf("NewAccount: %s", err)
+		}
+	}
+	food := model.Envelope{GroupID: 1, Name: "Food"}
+	fun := model.Envelope{GroupID: 1, Name: "Fun"}
+	for _, e := range []*model.Envelope{&food, &fun} {
+		if err := d.NewEnvelope(e); err != nil {
+			t.Fatalf("NewEnvelope: %s", err)
+		}
+	}
+	month := bcdate.CurrentMonth()
+	date := (month + 3).FmtDate()
+
+	split := model.AccountTransaction{AccountID: chk.ID, PostDate: month + 2, Amount: -3000, Memo: "Market", Splits: []model.Split{
+		{EnvelopeID: sql.NullInt32{Int32: int32(food.ID), Valid: true}, Amount: -1000},
+		{EnvelopeID: sql.NullInt32{Int32: int32(fun.ID), Valid: true}, Amount: -2000},
+	}}
+	if err := d.NewAccountTransaction(&split); err != nil {
+		t.Fatalf("NewAccountTransaction: %s", err)
+	}
+	path := "/view/transaction/" + strconv.Itoa(int(split.ID))
+
+	// An envelope posted for a split is ignored, the shares stay
+	w := postForm(h, path, url.Values{"type": {"0"}, "envelope": {strconv.Itoa(int(food.ID))}, "date": {date}, "amount": {"-30.00"}, "memo": {"Market day"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST split edit = %d:\n%s", w.Code, w.Body.String())
+	}
+	got, err := d.GetAccountTransaction(split.ID)
+	if err != nil || got.Memo != "Market day" || got.PostDate != month+3 || got.EnvelopeID.Valid || len(got.Splits) != 2 || got.Splits[0].Amount != -1000 || got.Splits[1].Amount != -2000 {
+		t.Fatalf("Split after edit = %+v, %v", got, err)
+	}
+
+	// A new amount the shares no longer add up to is the user's to fix
+	w = postForm(h, path, url.Values{"type": {"0"}, "date": {date}, "amount": {"-35.00"}})
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), db.ErrInvalidSplit.Error()) {
+		t.Fatalf("POST split with a new amount = %d, want 422:\n%s", w.Code, w.Body.String())
+	}
+	if got, err := d.GetAccountTransaction(split.ID); err != nil || got.Amount != -3000 {
+		t.Fatalf("Split after a refused edit = %+v, %v", got, err)
+	}
+
+	xfer := model.Transfer{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: month + 2, Amount: 2000}
+	if err := d.NewTransfer(&xfer); err != nil {
+		t.Fatalf("NewTransfer: %s", err)
+	}
+
+	// The type posted for a leg is ignored, the other leg follows the amount and date
+	w = postForm(h, "/view/transaction/"+strconv.Itoa(int(xfer.ToID)), url.Values{"type": {"0"}, "date": {date}, "amount": {"25.00"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST leg edit = %d:\n%s", w.Code, w.Body.String())
+	}
+	to, err := d.GetAccountTransaction(xfer.ToID)
+	if err != nil || to.Typ != model.TT_TRANSFER || to.Amount != 2500 || to.AccountID != sav.ID {
+		t.Fatalf("To leg after edit = %+v, %v", to, err)
+	}
+	from, err := d.GetAccountTransaction(xfer.FromID)
+	if err != nil || from.Typ != model.TT_TRANSFER || from.Amount != -2500 || from.PostDate != month+3 {
+		t.Fatalf("From leg after editing the to leg = %+v, %v", from, err)
+	}
+
+	// Flipping the sign of a leg breaks the transfer
+	w = postForm(h, "/view/transaction/"+strconv.Itoa(int(xfer.ToID)), url.Values{"date": {date}, "amount": {"-25.00"}})
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "must stay") {
+		t.Fatalf("POST leg with a flipped sign = %d, want 422:\n%s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"budgeting/internal/pkg/db"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timestamped snapshots of the live DB kept in one directory, and restoring one over a SQLite file
+// Snapshots are plain SQLite files named budget-YYYYMMDD-HHMMSS.db, openable with any of the tools
+
+const (
+	prefix = "budget-"
+	layout = "20060102-150405"
+	ext    = ".db"
+)
+
+var ErrInvalidSnapshot = errors.New("snapshot failed the sanity check")
+
+type Snapshot struct {
+	Name string
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// Where snapshots go and how many to keep, Keep 0 keeps them all
+type Dir struct {
+	Path string
+	Keep int
+}
+
+// Write a snapshot of the live DB named for now
+func (d Dir) Snapshot(sdb db.DB, now time.Time) (Snapshot, error) {
+	if err := os.MkdirAll(d.Path, 0o755); err != nil {
+		return Snapshot{}, fmt.Errorf("Snapshot.MkdirAll -- %w", err)
+	}
+
+	name := prefix + now.Format(layout) + ext
+	path := filepath.Join(d.Path, name)
+	if err := sdb.Backup(path); err != nil {
+		return Snapshot{}, fmt.Errorf("Snapshot.Backup -- %w", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("Snapshot.Stat -- %w", err)
+	}
+	return Snapshot{Name: name, Path: path, Time: now.Truncate(time.Second), Size: fi.Size()}, nil
+}
+
+// The snapshots in the directory, newest first, other files are left out
+func (d Dir) List() ([]Snapshot, error) {
+	ss := make([]Snapshot, 0)
+
+	des, err := os.ReadDir(d.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ss, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("List.ReadDir -- %w", err)
+	}
+
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(layout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return nil, fmt.Errorf("List.Info -- %w", err)
+		}
+		ss = append(ss, Snapshot{Name: name, Path: filepath.Join(d.Path, name), Time: t, Size: fi.Size()})
+	}
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].Time.After(ss[j].Time) })
+	return ss, nil
+}
+
+// Delete the oldest snapshots beyond Keep, returns the ones deleted
+func (d Dir) Prune() ([]Snapshot, error) {
+	removed := make([]Snapshot, 0)
+	if d.Keep <= 0 {
+		return removed, nil
+	}
+
+	ss, err := d.List()
+	if err != nil {
+		return nil, fmt.Errorf("Prune.List -- %w", err)
+	}
+	if len(ss) <= d.Keep {
+		return removed, nil
+	}
+	for _, s := range ss[d.Keep:] {
+		if err := os.Remove(s.Path); err != nil {
+			return removed, fmt.Errorf("Prune.Remove -- %w", err)
+		}
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
+
+// Where Restore at now keeps the file it replaces, <dbname>.pre-restore-YYYYMMDD-HHMMSS
+func PreRestorePath(dbname string, now time.Time) string {
+	return dbname + ".pre-restore-" + now.Format(layout)
+}
+
+// Replace the SQLite file dbname with a copy of the snapshot, the server must not have dbname open
+// The copy is migrated and run through the sanity checker first, a snapshot with violations is refused and they are returned
+// The file it replaces is kept next to it at PreRestorePath, a restore that would overwrite an earlier one's is refused
+func Restore(snapshot, dbname string, now time.Time) ([]db.Violation, error) {
+	if _, ok := db.NewFor(dbname).(*db.SQLite); !ok {
+		return nil, fmt.Errorf("Restore -- %w: %s is not a SQLite file", db.ErrBackupUnsupported, dbname)
+	}
+
+	tmp := dbname + ".restore"
+	if err := copyFile(snapshot, tmp); err != nil {
+		return nil, fmt.Errorf("Restore.copyFile -- %w", err)
+	}
+	defer os.Remove(tmp)
+
+	vs, err := check(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("Restore.check -- %w", err)
+	}
+	if len(vs) > 0 {
+		return vs, fmt.Errorf("Restore -- %w: %d violations", ErrInvalidSnapshot, len(vs))
+	}
+
+	if _, err := os.Stat(dbname); err == nil {
+		kept := PreRestorePath(dbname, now)
+		if _, err := os.Stat(kept); err == nil {
+			return nil, fmt.Errorf("Restore -- %w: %s", os.ErrExist, kept)
+		}
+		if err := os.Rename(dbname, kept); err != nil {
+			return nil, fmt.Errorf("Restore.Rename.old -- %w", err)
+		}
+	}
+	if err := os.Rename(tmp, dbname); err != nil {
+		return nil, fmt.Errorf("Restore.Rename -- %w", err)
+	}
+	return nil, nil
+}
+
+// Empty files and files without our tables are not snapshots, Open would happily make a fresh DB of them
+func check(fname string) ([]db.Violation, error) {
+	sdb := db.NewSQLite()
+	if err := sdb.Connect(fname); err != nil {
+		return nil, fmt.Errorf("check.Connect -- %w", err)
+	}
+	defer sdb.Close()
+
+	v, err := sdb.SchemaVersion()
+	if err != nil {
+		return nil, fmt.Errorf("check.SchemaVersion -- %w: %s", ErrInvalidSnapshot, err.Error())
+	}
+	if v == 0 {
+		return nil, fmt.Errorf("check -- %w: %s holds no budget", ErrInvalidSnapshot, fname)
+	}
+	if _, err := sdb.Migrate(); err != nil {
+		return nil, fmt.Errorf("check.Migrate -- %w", err)
+	}
+
+	vs, err := sdb.Check()
+	if err != nil {
+		return nil, fmt.Errorf("check.Check -- %w", err)
+	}
+	return vs, nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return fmt.Errorf("copyFile.Open -- %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return fmt.Errorf("copyFile.Create -- %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copyFile.Copy -- %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("copyFile.Close -- %w", err)
+	}
+	return nil
+}
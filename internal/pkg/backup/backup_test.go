@@ -0,0 +1,156 @@
+package backup_test
+
+import (
+	"budgeting/internal/pkg/backup"
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newDB(t *testing.T, fname string) db.DB {
+	t.Helper()
+	sdb := db.NewSQLite()
+	if err := sdb.Open(fname); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err := sdb.Init(); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+	return sdb
+}
+
+func accounts(t *testing.T, fname string) []model.Account {
+	t.Helper()
+	sdb := db.NewSQLite()
+	if err := sdb.Open(fname); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer sdb.Close()
+	as, err := sdb.GetAccounts()
+	if err != nil {
+		t.Fatalf("GetAccounts: %s", err)
+	}
+	return as
+}
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	sdb := newDB(t, filepath.Join(dir, "live.db"))
+	backups := backup.Dir{Path: filepath.Join(dir, "backups"), Keep: 2}
+
+	if ss, err := backups.List(); err != nil || len(ss) != 0 {
+		t.Fatalf("List before any = %v, %v", ss, err)
+	}
+
+	start := time.Date(2024, 3, 1, 2, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		if err := sdb.NewAccount(&model.Account{Institution: "Bank", Name: "Account"}); err != nil {
+			t.Fatalf("NewAccount: %s", err)
+		}
+		if _, err := backups.Snapshot(sdb, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("Snapshot: %s", err)
+		}
+	}
+	if _, err := backups.Snapshot(sdb, start); err == nil {
+		t.Fatalf("Snapshot over an existing one succeeded")
+	}
+	// Strays in the directory are not snapshots
+	if err := os.WriteFile(filepath.Join(backups.Path, "notes.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	pruned, err := backups.Prune()
+	if err != nil || len(pruned) != 1 || !pruned[0].Time.Equal(start) {
+		t.Fatalf("Prune = %v, %v", pruned, err)
+	}
+	ss, err := backups.List()
+	if err != nil || len(ss) != 2 || ss[0].Name != "budget-20240301-040000.db" || ss[1].Name != "budget-20240301-030000.db" {
+		t.Fatalf("List = %v, %v", ss, err)
+	}
+	if as := accounts(t, ss[1].Path); len(as) != 2 {
+		t.Fatalf("Snapshot of 03:00 holds %d accounts, want 2", len(as))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.db")
+	sdb := newDB(t, live)
+	a := model.Account{Institution: "Bank", Name: "Checking"}
+	if err := sdb.NewAccount(&a); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	if err := sdb.NewAccountTransaction(&model.AccountTransaction{AccountID: a.ID, PostDate: bcdate.CurrentMonth() + 1, Amount: -1000}); err != nil {
+		t.Fatalf("NewAccountTransaction: %s", err)
+	}
+	backups := backup.Dir{Path: filepath.Join(dir, "backups")}
+	good, err := backups.Snapshot(sdb, time.Now())
+	if err != nil {
+		t.Fatalf("Snapshot: %s", err)
+	}
+
+	// The transaction changes behind the checkpoints' back
+	script := filepath.Join(dir, "break.sql")
+	if err := os.WriteFile(script, []byte("UPDATE a_t SET amount = amount + 1;"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err := sdb.Run(script); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	bad, err := backups.Snapshot(sdb, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Snapshot: %s", err)
+	}
+	sdb.Close()
+
+	target := filepath.Join(dir, "restored.db")
+	newDB(t, target).Close()
+
+	now := time.Date(2024, 3, 1, 2, 0, 0, 0, time.Local)
+	vs, err := backup.Restore(bad.Path, target, now)
+	if !errors.Is(err, backup.ErrInvalidSnapshot) || len(vs) == 0 {
+		t.Fatalf("Restore broken snapshot = %v, %v", vs, err)
+	}
+	empty := filepath.Join(dir, "empty.db")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if _, err := backup.Restore(empty, target, now); !errors.Is(err, backup.ErrInvalidSnapshot) {
+		t.Fatalf("Restore empty file = %v, want ErrInvalidSnapshot", err)
+	}
+	if as := accounts(t, target); len(as) != 0 {
+		t.Fatalf("Refused restores changed the target, %d accounts", len(as))
+	}
+
+	if vs, err := backup.Restore(good.Path, target, now); err != nil || len(vs) != 0 {
+		t.Fatalf("Restore = %v, %v", vs, err)
+	}
+	if as := accounts(t, target); len(as) != 1 || as[0].Name != "Checking" {
+		t.Fatalf("Restored accounts = %v", as)
+	}
+	if as := accounts(t, target+".pre-restore-20240301-020000"); len(as) != 0 {
+		t.Fatalf("Kept file holds %d accounts, want the old empty DB", len(as))
+	}
+
+	// A second restore keeps its own copy, and never overwrites an earlier one's
+	if _, err := backup.Restore(good.Path, target, now); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Restore over a kept file = %v, want ErrExist", err)
+	}
+	if _, err := backup.Restore(good.Path, target, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Restore a minute later: %s", err)
+	}
+	if as := accounts(t, target+".pre-restore-20240301-020000"); len(as) != 0 {
+		t.Fatalf("First kept file holds %d accounts after the second restore, want 0", len(as))
+	}
+	if as := accounts(t, backup.PreRestorePath(target, now.Add(time.Minute))); len(as) != 1 {
+		t.Fatalf("Second kept file holds %d accounts, want 1", len(as))
+	}
+	if _, err := os.Stat(target + ".restore"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Temporary copy left behind: %v", err)
+	}
+}
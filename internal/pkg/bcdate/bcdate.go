@@ -30,10 +30,11 @@ func Oldest(a BCDate, b BCDate) BCDate {
 	}
 }
 func Latest(a BCDate, b BCDate) BCDate {
-	if (a/100)%100 == 1 {
-		a = (a/10000)*10000 - 10000 + 100 + a%100
+	if a > b {
+		return a
+	} else {
+		return b
 	}
-	return a
 }
 
 func (a BCDate) PrevMonth() BCDate {
@@ -66,3 +67,31 @@ func (a BCDate) FmtMonth() string {
 
 	return fmt.Sprintf("%04d-%02d", yer, mon)
 }
+
+// Midnight UTC of the date, the day of a month date is taken as the 1st
+func (a BCDate) Time() time.Time {
+	day := int(a % 100)
+	if day == 0 {
+		day = 1
+	}
+	return time.Date(int(a/10000), time.Month(a/100%100), day, 0, 0, 0, 0, time.UTC)
+}
+
+func FromTime(t time.Time) BCDate {
+	return BCDate(t.Year()*10000 + int(t.Month())*100 + t.Day())
+}
+
+func (a BCDate) AddDays(n int) BCDate {
+	return FromTime(a.Time().AddDate(0, 0, n))
+}
+
+// Whole days from a to b, negative when b is earlier
+func DaysBetween(a BCDate, b BCDate) int {
+	return int(b.Time().Sub(a.Time()).Hours() / 24)
+}
+
+// Last day of the month a falls in
+func (a BCDate) LastDay() BCDate {
+	t := a.Time()
+	return FromTime(time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC))
+}
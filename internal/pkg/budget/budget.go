@@ -0,0 +1,158 @@
+package budget
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"fmt"
+)
+
+// Moving money between the float and the envelopes for one month
+// Every change is entered as envelope transactions dated the 1st of the month
+// An assignment has the float as its other side, a move between envelopes is a pair that nets to zero
+
+var ErrInvalid = errors.New("invalid budget change")
+
+type Line struct {
+	Envelope model.Envelope
+	Bal      int
+	// Assigned and spent in the month itself, 0 when nothing moved
+	In  int
+	Out int
+	// See Envelope.GoalWant and Envelope.GoalNeed
+	Want int
+	Need int
+}
+
+type Budget struct {
+	Month bcdate.BCDate
+	Float int
+	Lines []Line
+}
+
+// Where every envelope stands in the month, in the order GetEnvelopes gives them
+func Get(sdb db.DB, month bcdate.BCDate) (Budget, error) {
+	b := Budget{Month: month, Lines: make([]Line, 0)}
+
+	summ, err := sdb.GetOverallSummary(month)
+	if err != nil {
+		return b, fmt.Errorf("Get.GetOverallSummary -- %w", err)
+	}
+	b.Float = summ.Float
+
+	es, err := sdb.GetEnvelopes()
+	if err != nil {
+		return b, fmt.Errorf("Get.GetEnvelopes -- %w", err)
+	}
+	for _, e := range es {
+		l, err := line(sdb, month, e)
+		if err != nil {
+			return b, fmt.Errorf("Get.line -- %w", err)
+		}
+		b.Lines = append(b.Lines, l)
+	}
+	return b, nil
+}
+
+func line(sdb db.DB, month bcdate.BCDate, e model.Envelope) (Line, error) {
+	s, err := sdb.GetEnvelopeSummary(month, e.ID)
+	if err != nil {
+		return Line{}, fmt.Errorf("line.GetEnvelopeSummary -- %w", err)
+	}
+
+	// The checkpoint can be from an earlier month when nothing moved since, only its balance carries over
+	l := Line{Envelope: e, Bal: s.Bal}
+	if s.Month == month {
+		l.In, l.Out = s.In, s.Out
+	}
+	l.Want = e.GoalWant(l.Bal)
+	l.Need = e.GoalNeed(l.Bal, l.In)
+	return l, nil
+}
+
+// Change what the envelope has assigned in the month to amount, the difference comes from or goes back to the float
+// Returns the transaction entered, with no ID when the envelope already had amount
+func Assign(sdb db.DB, month bcdate.BCDate, id model.PKEY, amount int) (model.EnvelopeTransaction, error) {
+	if err := checkMonth(month); err != nil {
+		return model.EnvelopeTransaction{}, fmt.Errorf("Assign -- %w", err)
+	}
+	e, err := sdb.GetEnvelope(id)
+	if err != nil {
+		return model.EnvelopeTransaction{}, fmt.Errorf("Assign.GetEnvelope -- %w", err)
+	}
+	l, err := line(sdb, month, e)
+	if err != nil {
+		return model.EnvelopeTransaction{}, fmt.Errorf("Assign.line -- %w", err)
+	}
+
+	et := model.EnvelopeTransaction{EnvelopeID: id, PostDate: month + 1, Amount: amount - l.In}
+	if et.Amount == 0 {
+		return et, nil
+	}
+	if err := sdb.NewEnvelopeTransaction(&et); err != nil {
+		return et, fmt.Errorf("Assign.NewEnvelopeTransaction -- %w", err)
+	}
+	return et, nil
+}
+
+// Move amount from one envelope to another in one DB transaction
+func Move(sdb db.DB, month bcdate.BCDate, from model.PKEY, to model.PKEY, amount int) ([]model.EnvelopeTransaction, error) {
+	if err := checkMonth(month); err != nil {
+		return nil, fmt.Errorf("Move -- %w", err)
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("Move -- %w: amount must be positive, got %d", ErrInvalid, amount)
+	}
+	if from == to {
+		return nil, fmt.Errorf("Move -- %w: envelope %d to itself", ErrInvalid, from)
+	}
+	for _, id := range []model.PKEY{from, to} {
+		if _, err := sdb.GetEnvelope(id); err != nil {
+			return nil, fmt.Errorf("Move.GetEnvelope -- %w", err)
+		}
+	}
+
+	ets := []model.EnvelopeTransaction{
+		{EnvelopeID: from, PostDate: month + 1, Amount: -amount},
+		{EnvelopeID: to, PostDate: month + 1, Amount: amount},
+	}
+	if err := sdb.Batch_NewEnvelopeTransaction(ets); err != nil {
+		return nil, fmt.Errorf("Move.Batch_NewEnvelopeTransaction -- %w", err)
+	}
+	return ets, nil
+}
+
+// Assign every envelope what its goal still needs this month, in one DB transaction
+// Goals are funded in full even when the float does not cover them, the float then goes negative
+// Hidden envelopes are left alone
+func FundGoals(sdb db.DB, month bcdate.BCDate) ([]model.EnvelopeTransaction, error) {
+	if err := checkMonth(month); err != nil {
+		return nil, fmt.Errorf("FundGoals -- %w", err)
+	}
+	b, err := Get(sdb, month)
+	if err != nil {
+		return nil, fmt.Errorf("FundGoals.Get -- %w", err)
+	}
+
+	ets := make([]model.EnvelopeTransaction, 0)
+	for _, l := range b.Lines {
+		if l.Need > 0 && !l.Envelope.Hidden {
+			ets = append(ets, model.EnvelopeTransaction{EnvelopeID: l.Envelope.ID, PostDate: month + 1, Amount: l.Need})
+		}
+	}
+	if len(ets) == 0 {
+		return ets, nil
+	}
+	if err := sdb.Batch_NewEnvelopeTransaction(ets); err != nil {
+		return nil, fmt.Errorf("FundGoals.Batch_NewEnvelopeTransaction -- %w", err)
+	}
+	return ets, nil
+}
+
+func checkMonth(month bcdate.BCDate) error {
+	if month%100 != 0 || month/100%100 < 1 || month/100%100 > 12 {
+		return fmt.Errorf("%w: %d is not a month", ErrInvalid, month)
+	}
+	return nil
+}
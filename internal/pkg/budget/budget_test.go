@@ -0,0 +1,121 @@
+package budget_test
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/budget"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newDB(t *testing.T) db.DB {
+	t.Helper()
+	sdb := db.NewSQLite()
+	if err := sdb.Open(filepath.Join(t.TempDir(), "budget.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err := sdb.Init(); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+	return sdb
+}
+
+func lineFor(t *testing.T, sdb db.DB, month bcdate.BCDate, id model.PKEY) budget.Line {
+	t.Helper()
+	b, err := budget.Get(sdb, month)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	for _, l := range b.Lines {
+		if l.Envelope.ID == id {
+			return l
+		}
+	}
+	t.Fatalf("No line for envelope %d", id)
+	return budget.Line{}
+}
+
+func TestBudget(t *testing.T) {
+	sdb := newDB(t)
+	month := bcdate.CurrentMonth()
+
+	chk := model.Account{Institution: "Bank", Name: "Checking"}
+	if err := sdb.NewAccount(&chk); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	if err := sdb.NewAccountTransaction(&model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: month + 1, Amount: 100000}); err != nil {
+		t.Fatalf("NewAccountTransaction: %s", err)
+	}
+	rent := model.Envelope{GroupID: 1, Name: "Rent", Goal: model.GT_RECUR, GoalAmt: 60000}
+	food := model.Envelope{GroupID: 1, Name: "Food", Goal: model.GT_RECTIL, GoalAmt: 20000, GoalTgt: 25000}
+	old := model.Envelope{GroupID: 1, Name: "Old", Goal: model.GT_TGT, GoalTgt: 5000, Hidden: true}
+	for _, e := range []*model.Envelope{&rent, &food, &old} {
+		if err := sdb.NewEnvelope(e); err != nil {
+			t.Fatalf("NewEnvelope: %s", err)
+		}
+	}
+
+	if _, err := budget.Assign(sdb, month, rent.ID, 50000); err != nil {
+		t.Fatalf("Assign: %s", err)
+	}
+	// Assigning is setting the month's total, not adding to it
+	if _, err := budget.Assign(sdb, month, rent.ID, 40000); err != nil {
+		t.Fatalf("Assign again: %s", err)
+	}
+	if et, err := budget.Assign(sdb, month, rent.ID, 40000); err != nil || et.ID != 0 {
+		t.Fatalf("Assign unchanged = %+v, %v", et, err)
+	}
+	if l := lineFor(t, sdb, month, rent.ID); l.In != 40000 || l.Bal != 40000 || l.Need != 20000 {
+		t.Fatalf("Rent = %+v", l)
+	}
+
+	if _, err := budget.Move(sdb, month, rent.ID, food.ID, 10000); err != nil {
+		t.Fatalf("Move: %s", err)
+	}
+	for _, bad := range []struct {
+		from, to model.PKEY
+		amount   int
+	}{{rent.ID, rent.ID, 1}, {rent.ID, food.ID, 0}, {rent.ID, food.ID, -5}} {
+		if _, err := budget.Move(sdb, month, bad.from, bad.to, bad.amount); !errors.Is(err, budget.ErrInvalid) {
+			t.Fatalf("Move %+v = %v, want ErrInvalid", bad, err)
+		}
+	}
+	if _, err := budget.Move(sdb, month+1, rent.ID, food.ID, 1); !errors.Is(err, budget.ErrInvalid) {
+		t.Fatalf("Move on a date = %v, want ErrInvalid", err)
+	}
+
+	ets, err := budget.FundGoals(sdb, month)
+	if err != nil || len(ets) != 2 {
+		t.Fatalf("FundGoals = %+v, %v", ets, err)
+	}
+	b, err := budget.Get(sdb, month)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	for _, l := range b.Lines {
+		if l.Need != 0 && !l.Envelope.Hidden {
+			t.Errorf("%s still needs %d", l.Envelope.Name, l.Need)
+		}
+	}
+	// Moves count towards the month too: rent is topped back up to 60000, food had 10000 moved in and gets 10000 more
+	if b.Float != 100000-60000-20000 {
+		t.Fatalf("Float = %d", b.Float)
+	}
+	if ets, err := budget.FundGoals(sdb, month); err != nil || len(ets) != 0 {
+		t.Fatalf("FundGoals again = %+v, %v", ets, err)
+	}
+
+	// Next month nothing has moved yet, balances carry and recurring goals want their amount again
+	if l := lineFor(t, sdb, month.NextMonth(), rent.ID); l.Bal != 60000 || l.In != 0 || l.Need != 60000 {
+		t.Fatalf("Rent next month = %+v", l)
+	}
+	if l := lineFor(t, sdb, month.NextMonth(), food.ID); l.Bal != 20000 || l.Need != 5000 {
+		t.Fatalf("Food next month = %+v", l)
+	}
+
+	if vs, err := sdb.Check(); err != nil || len(vs) != 0 {
+		t.Fatalf("Check = %v, %v", vs, err)
+	}
+}
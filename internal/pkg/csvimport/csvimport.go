@@ -0,0 +1,304 @@
+package csvimport
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Read bank CSV exports through a profile and hand the rows to the shared importer
+// Columns are header names, or 1-based positions when all digits
+
+var ErrInvalid = errors.New("invalid CSV row")
+
+// Read every row of the export as a transaction of the account
+// Rows come out cleared unless the profile has a cleared column, with the memo doubling as the payee name
+func Parse(p model.CSVProfile, account model.PKEY, r io.Reader) ([]importer.Row, error) {
+	if err := Validate(p); err != nil {
+		return nil, fmt.Errorf("Parse.Validate -- %w", err)
+	}
+	layout, _ := dateLayout(p.DateFormat)
+
+	cr := csv.NewReader(r)
+	if p.Delimiter != "" {
+		cr.Comma, _ = utf8.DecodeRuneInString(p.Delimiter)
+	}
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	for i := 0; i < p.SkipRows; i++ {
+		if _, err := cr.Read(); err != nil {
+			return nil, fmt.Errorf("Parse.Skip -- %w", err)
+		}
+	}
+
+	var header []string
+	if p.Header {
+		var err error
+		if header, err = cr.Read(); err != nil {
+			return nil, fmt.Errorf("Parse.Header -- %w", err)
+		}
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+	}
+
+	c, err := resolve(p, header)
+	if err != nil {
+		return nil, fmt.Errorf("Parse.resolve -- %w", err)
+	}
+
+	rows := make([]importer.Row, 0)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Parse.Read -- %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		if blank(rec) {
+			continue
+		}
+		at, err := c.row(p, layout, account, rec)
+		if err != nil {
+			return nil, fmt.Errorf("Parse.line %d -- %w", line, err)
+		}
+		rows = append(rows, importer.Row{AccountTransaction: at, Payee: at.Memo})
+	}
+	return rows, nil
+}
+
+// Parse the export and insert what is new, see importer.Import
+// Without an ID column nothing marks a row as imported before, importing the same file twice lists every row in Duplicates
+func Import(sdb db.DB, p model.CSVProfile, account model.PKEY, r io.Reader, opts importer.Options) (importer.Result, error) {
+	rows, err := Parse(p, account, r)
+	if err != nil {
+		return importer.Result{}, fmt.Errorf("Import.Parse -- %w", err)
+	}
+
+	res, err := importer.Import(sdb, rows, opts)
+	if err != nil {
+		return res, fmt.Errorf("Import.importer -- %w", err)
+	}
+	return res, nil
+}
+
+// Profile columns as record indexes, -1 when unused
+type columnIndexes struct {
+	date, amount, debit, credit, cleared, id int
+	memo                                     []int
+}
+
+func resolve(p model.CSVProfile, header []string) (columnIndexes, error) {
+	find := func(col string) (int, error) {
+		if col == "" {
+			return -1, nil
+		}
+		if isPosition(col) {
+			n, err := strconv.Atoi(col)
+			if err != nil || n < 1 {
+				return -1, fmt.Errorf("%w: column position %q", ErrInvalid, col)
+			}
+			return n - 1, nil
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), col) {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("%w: no column %q in the header", ErrInvalid, col)
+	}
+
+	var c columnIndexes
+	var err error
+	for _, f := range []struct {
+		col string
+		idx *int
+	}{
+		{p.DateColumn, &c.date},
+		{p.AmountColumn, &c.amount},
+		{p.DebitColumn, &c.debit},
+		{p.CreditColumn, &c.credit},
+		{p.ClearedColumn, &c.cleared},
+		{p.IDColumn, &c.id},
+	} {
+		if *f.idx, err = find(f.col); err != nil {
+			return c, err
+		}
+	}
+	for _, col := range memoColumns(p) {
+		i, err := find(col)
+		if err != nil {
+			return c, err
+		}
+		c.memo = append(c.memo, i)
+	}
+	return c, nil
+}
+
+func (c columnIndexes) row(p model.CSVProfile, layout string, account model.PKEY, rec []string) (model.AccountTransaction, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	date, err := parseDate(layout, field(c.date))
+	if err != nil {
+		return model.AccountTransaction{}, err
+	}
+
+	var amount int
+	if c.amount >= 0 {
+		if amount, err = parseAmount(field(c.amount), p.DecimalComma); err != nil {
+			return model.AccountTransaction{}, err
+		}
+	} else {
+		debit, credit := field(c.debit), field(c.credit)
+		if debit == "" && credit == "" {
+			return model.AccountTransaction{}, fmt.Errorf("%w: neither a debit nor a credit", ErrInvalid)
+		}
+		if debit != "" {
+			d, err := parseAmount(debit, p.DecimalComma)
+			if err != nil {
+				return model.AccountTransaction{}, err
+			}
+			amount -= abs(d)
+		}
+		if credit != "" {
+			cr, err := parseAmount(credit, p.DecimalComma)
+			if err != nil {
+				return model.AccountTransaction{}, err
+			}
+			amount += abs(cr)
+		}
+	}
+	if p.Negate {
+		amount = -amount
+	}
+
+	memo := make([]string, 0, len(c.memo))
+	for _, i := range c.memo {
+		if m := field(i); m != "" {
+			memo = append(memo, m)
+		}
+	}
+
+	at := model.AccountTransaction{
+		AccountID: account,
+		Typ:       model.TT_NORM,
+		PostDate:  date,
+		Amount:    amount,
+		Cleared:   c.cleared < 0 || strings.EqualFold(field(c.cleared), p.ClearedValue),
+		Memo:      strings.Join(memo, " "),
+	}
+	if amount > 0 {
+		at.Typ = model.TT_INCOME
+	}
+	if id := field(c.id); id != "" {
+		at.ImportID = sql.NullString{String: id, Valid: true}
+	}
+	return at, nil
+}
+
+func parseDate(layout string, s string) (bcdate.BCDate, error) {
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: date %q -- %s", ErrInvalid, s, err.Error())
+	}
+	return bcdate.BCDate(t.Year()*10000 + int(t.Month())*100 + t.Day()), nil
+}
+
+// Amounts in cents, taking currency signs, thousands separators, a trailing minus or (brackets) for negatives
+func parseAmount(s string, decimalComma bool) (int, error) {
+	point, thousands := '.', ','
+	if decimalComma {
+		point, thousands = ',', '.'
+	}
+
+	neg := false
+	digits := make([]rune, 0, len(s))
+	frac := -1
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, r)
+			if frac >= 0 {
+				frac++
+			}
+		case r == point:
+			if frac >= 0 {
+				return 0, fmt.Errorf("%w: amount %q", ErrInvalid, s)
+			}
+			frac = 0
+		case r == '-' || r == '(':
+			neg = true
+		case r == thousands || r == ')' || r == '+' || r == ' ' || r == '\u00a0':
+		case i == 0 || len(digits) == 0 || !strings.ContainsAny(s[i:], "0123456789"):
+			// Currency signs and codes either side of the number
+		default:
+			return 0, fmt.Errorf("%w: amount %q", ErrInvalid, s)
+		}
+	}
+	if len(digits) == 0 {
+		return 0, fmt.Errorf("%w: amount %q", ErrInvalid, s)
+	}
+
+	switch {
+	case frac <= 0:
+		digits = append(digits, '0', '0')
+	case frac == 1:
+		digits = append(digits, '0')
+	case frac > 2:
+		return 0, fmt.Errorf("%w: amount %q has fractions of a cent", ErrInvalid, s)
+	}
+
+	v, err := strconv.Atoi(string(digits))
+	if err != nil {
+		return 0, fmt.Errorf("%w: amount %q", ErrInvalid, s)
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+func isPosition(col string) bool {
+	for _, r := range col {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return col != ""
+}
+
+func blank(rec []string) bool {
+	for _, f := range rec {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
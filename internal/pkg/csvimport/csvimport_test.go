@@ -0,0 +1,279 @@
+package csvimport_test
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/csvimport"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A European bank, signed amounts with a decimal comma and a header row
+var signed = model.CSVProfile{
+	Name:          "Giro",
+	Delimiter:     ";",
+	Header:        true,
+	DateColumn:    "Date",
+	DateFormat:    "DD.MM.YYYY",
+	AmountColumn:  "Amount",
+	DecimalComma:  true,
+	MemoColumn:    "Description, Details",
+	ClearedColumn: "status",
+	ClearedValue:  "Booked",
+	IDColumn:      "Reference",
+}
+
+// A card export, two lines of preamble, no header, debit and credit columns by position
+var card = model.CSVProfile{
+	Name:         "Card",
+	SkipRows:     2,
+	DateColumn:   "1",
+	DateFormat:   "MM/DD/YYYY",
+	DebitColumn:  "3",
+	CreditColumn: "4",
+	MemoColumn:   "2",
+	IDColumn:     "5",
+}
+
+func parseFile(t *testing.T, p model.CSVProfile, name string) []model.AccountTransaction {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+
+	rows, err := csvimport.Parse(p, 1, f)
+	if err != nil {
+		t.Fatalf("Parse %s: %s", name, err)
+	}
+	ats := make([]model.AccountTransaction, 0, len(rows))
+	for _, row := range rows {
+		if row.Payee != row.Memo {
+			t.Fatalf("Payee %q, memo %q", row.Payee, row.Memo)
+		}
+		ats = append(ats, row.AccountTransaction)
+	}
+	return ats
+}
+
+func TestParse(t *testing.T) {
+	type want struct {
+		date    bcdate.BCDate
+		amount  int
+		typ     model.TransactionType
+		cleared bool
+		memo    string
+		id      string
+	}
+	tests := []struct {
+		file    string
+		profile model.CSVProfile
+		want    []want
+	}{
+		{"signed.csv", signed, []want{
+			{20240301, 250000, model.TT_INCOME, true, "Salary ACME March", "R-1"},
+			{20240303, -4567, model.TT_NORM, true, "CORNER GROCER 0042", "R-2"},
+			{20240305, -1250, model.TT_NORM, false, "City Parking Card 1234", "R-3"},
+		}},
+		{"card.csv", card, []want{
+			{20240314, -108990, model.TT_NORM, true, "BISTRO LUNA", "CC-1"},
+			{20240320, 50000, model.TT_INCOME, true, "PAYMENT THANK YOU", "CC-2"},
+			{20240322, 1000, model.TT_INCOME, true, "REFUND BISTRO LUNA", "CC-3"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			ats := parseFile(t, tt.profile, tt.file)
+			if len(ats) != len(tt.want) {
+				t.Fatalf("Parse found %d rows, want %d", len(ats), len(tt.want))
+			}
+			for i, w := range tt.want {
+				at := ats[i]
+				if at.AccountID != 1 || at.PostDate != w.date || at.Amount != w.amount || at.Typ != w.typ ||
+					at.Cleared != w.cleared || at.Memo != w.memo || at.ImportID.String != w.id {
+					t.Fatalf("Row %d = %+v, want %+v", i, at, w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAmounts(t *testing.T) {
+	p := model.CSVProfile{Name: "Plain", DateColumn: "1", DateFormat: "YYYY-MM-DD", AmountColumn: "2", Negate: true}
+	for in, want := range map[string]int{
+		"12":          -1200,
+		"12.5":        -1250,
+		"-3.99":       399,
+		"(1,234.56)":  123456,
+		"USD 7.00":    -700,
+		"7.00-":       700,
+		"£0.01":       -1,
+		"+1 000.00":   -100000,
+		"1,000,000.1": -100000010,
+	} {
+		rows, err := csvimport.Parse(p, 1, strings.NewReader("2024-02-29,\""+in+"\"\n"))
+		if err != nil {
+			t.Fatalf("Parse %q: %s", in, err)
+		}
+		if rows[0].Amount != want {
+			t.Errorf("Parse %q = %d, want %d", in, rows[0].Amount, want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1.234", "1.2.3", "12x4", "2024-02-30,1", "1/2/2024,1"} {
+		data := "2024-01-01," + in + "\n"
+		if strings.Contains(in, ",") {
+			data = in + "\n"
+		}
+		if _, err := csvimport.Parse(p, 1, strings.NewReader(data)); !errors.Is(err, csvimport.ErrInvalid) {
+			t.Errorf("Parse %q = %v, want ErrInvalid", in, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := map[string]func(p *model.CSVProfile){
+		"no name":         func(p *model.CSVProfile) { p.Name = "" },
+		"delimiter":       func(p *model.CSVProfile) { p.Delimiter = ";;" },
+		"no date":         func(p *model.CSVProfile) { p.DateColumn = "" },
+		"date format":     func(p *model.CSVProfile) { p.DateFormat = "MM/YYYY" },
+		"both amounts":    func(p *model.CSVProfile) { p.DebitColumn, p.CreditColumn = "Out", "In" },
+		"no amount":       func(p *model.CSVProfile) { p.AmountColumn = "" },
+		"half split":      func(p *model.CSVProfile) { p.AmountColumn, p.DebitColumn = "", "Out" },
+		"cleared value":   func(p *model.CSVProfile) { p.ClearedValue = "" },
+		"names no header": func(p *model.CSVProfile) { p.Header = false },
+	}
+	if err := csvimport.Validate(signed); err != nil {
+		t.Fatalf("Validate signed: %s", err)
+	}
+	for name, f := range bad {
+		p := signed
+		f(&p)
+		if err := csvimport.Validate(p); !errors.Is(err, csvimport.ErrInvalidProfile) {
+			t.Errorf("Validate %s = %v, want ErrInvalidProfile", name, err)
+		}
+	}
+
+	// A header that lacks a named column
+	p := signed
+	p.IDColumn = "Ref"
+	f, _ := os.Open(filepath.Join("testdata", "signed.csv"))
+	defer f.Close()
+	if _, err := csvimport.Parse(p, 1, f); !errors.Is(err, csvimport.ErrInvalid) {
+		t.Errorf("Parse missing column = %v, want ErrInvalid", err)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	ps, err := csvimport.LoadProfiles(strings.NewReader(`[
+		{"name": "Giro", "delimiter": ";", "header": true, "dateColumn": "Date", "dateFormat": "DD.MM.YYYY",
+		 "amountColumn": "Amount", "decimalComma": true, "memoColumn": "Description, Details",
+		 "clearedColumn": "status", "clearedValue": "Booked", "idColumn": "Reference"},
+		{"name": "Card", "skipRows": 2, "dateColumn": "1", "dateFormat": "MM/DD/YYYY",
+		 "debitColumn": "3", "creditColumn": "4", "memoColumn": "2", "idColumn": "5"}
+	]`))
+	if err != nil {
+		t.Fatalf("LoadProfiles: %s", err)
+	}
+	if len(ps) != 2 || ps[0] != signed || ps[1] != card {
+		t.Fatalf("LoadProfiles = %+v", ps)
+	}
+
+	if _, err := csvimport.LoadProfiles(strings.NewReader(`[{"name": "Card", "dateColum": "1"}]`)); err == nil {
+		t.Fatalf("LoadProfiles took an unknown field")
+	}
+	if _, err := csvimport.LoadProfiles(strings.NewReader(`[{"name": "Card", "dateColumn": "1"}]`)); !errors.Is(err, csvimport.ErrInvalidProfile) {
+		t.Fatalf("LoadProfiles invalid = %v, want ErrInvalidProfile", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	sdb := db.NewSQLite()
+	if err := sdb.Open(filepath.Join(t.TempDir(), "csv.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err := sdb.Init(); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+
+	chk := model.Account{Institution: "Bank", Name: "Giro"}
+	if err := sdb.NewAccount(&chk); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+
+	// Profiles kept in the DB come back as they went in
+	p := signed
+	if err := sdb.NewCSVProfile(&p); err != nil {
+		t.Fatalf("NewCSVProfile: %s", err)
+	}
+	stored, err := sdb.GetCSVProfileByName("Giro")
+	if err != nil || stored != p {
+		t.Fatalf("GetCSVProfileByName = %+v, %v", stored, err)
+	}
+
+	importFile := func(dryRun bool) (int, int) {
+		t.Helper()
+		f, err := os.Open(filepath.Join("testdata", "signed.csv"))
+		if err != nil {
+			t.Fatalf("Open: %s", err)
+		}
+		defer f.Close()
+		res, err := csvimport.Import(sdb, stored, chk.ID, f, importer.Options{DryRun: dryRun})
+		if err != nil {
+			t.Fatalf("Import: %s", err)
+		}
+		return len(res.New), len(res.Skipped)
+	}
+
+	if n, s := importFile(true); n != 3 || s != 0 {
+		t.Fatalf("Import dry run = %d new, %d skipped", n, s)
+	}
+	if ats, err := sdb.GetAllAccountTransactions(chk.ID); err != nil || len(ats) != 0 {
+		t.Fatalf("Dry run wrote %d transactions, %v", len(ats), err)
+	}
+
+	if n, s := importFile(false); n != 3 || s != 0 {
+		t.Fatalf("Import = %d new, %d skipped", n, s)
+	}
+	if n, s := importFile(false); n != 0 || s != 3 {
+		t.Fatalf("Import again = %d new, %d skipped", n, s)
+	}
+
+	// Without an ID column the same rows come back as likely duplicates instead
+	noIDs := stored
+	noIDs.IDColumn = ""
+	importDupes := func(skip bool) importer.Result {
+		t.Helper()
+		f, err := os.Open(filepath.Join("testdata", "signed.csv"))
+		if err != nil {
+			t.Fatalf("Open: %s", err)
+		}
+		defer f.Close()
+		res, err := csvimport.Import(sdb, noIDs, chk.ID, f, importer.Options{DryRun: !skip, SkipDuplicates: skip})
+		if err != nil {
+			t.Fatalf("Import: %s", err)
+		}
+		return res
+	}
+	if res := importDupes(false); len(res.New) != 3 || len(res.Duplicates) != 3 || res.Duplicates[2].Index != 2 {
+		t.Fatalf("Import without IDs = %d new, duplicates %v", len(res.New), res.Duplicates)
+	}
+	if res := importDupes(true); len(res.New) != 0 || len(res.Duplicates) != 3 {
+		t.Fatalf("Import skipping duplicates = %d new, %d duplicates", len(res.New), len(res.Duplicates))
+	}
+
+	s, err := sdb.GetAccountSummary(20240300, chk.ID)
+	if err != nil || s.Bal != 250000-4567-1250 {
+		t.Fatalf("GetAccountSummary = %+v, %v", s, err)
+	}
+	if vs, err := sdb.Check(); err != nil || len(vs) != 0 {
+		t.Fatalf("Check = %v, %v", vs, err)
+	}
+}
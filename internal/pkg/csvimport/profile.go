@@ -0,0 +1,175 @@
+package csvimport
+
+import (
+	"budgeting/internal/pkg/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Profiles: how to read one bank's export, stored in the DB or kept in a JSON file
+
+var ErrInvalidProfile = errors.New("invalid CSV profile")
+
+// A profile names every column it needs and says how to read amounts and dates
+func Validate(p model.CSVProfile) error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: needs a name", ErrInvalidProfile)
+	}
+	if p.Delimiter != "" && utf8.RuneCountInString(p.Delimiter) != 1 {
+		return fmt.Errorf("%w: delimiter %q is not one character", ErrInvalidProfile, p.Delimiter)
+	}
+	if p.SkipRows < 0 {
+		return fmt.Errorf("%w: skipRows is negative", ErrInvalidProfile)
+	}
+	if p.DateColumn == "" {
+		return fmt.Errorf("%w: needs a date column", ErrInvalidProfile)
+	}
+	if _, err := dateLayout(p.DateFormat); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidProfile, err.Error())
+	}
+
+	split := p.DebitColumn != "" || p.CreditColumn != ""
+	switch {
+	case p.AmountColumn != "" && split:
+		return fmt.Errorf("%w: has both a signed amount column and debit/credit columns", ErrInvalidProfile)
+	case p.AmountColumn == "" && !split:
+		return fmt.Errorf("%w: needs an amount column, or debit and credit columns", ErrInvalidProfile)
+	case split && (p.DebitColumn == "" || p.CreditColumn == ""):
+		return fmt.Errorf("%w: needs both debit and credit columns", ErrInvalidProfile)
+	}
+
+	if p.ClearedColumn != "" && p.ClearedValue == "" {
+		return fmt.Errorf("%w: cleared column without the value that marks a row cleared", ErrInvalidProfile)
+	}
+
+	// Names need the header row to be found in
+	for _, col := range columns(p) {
+		if !p.Header && !isPosition(col) {
+			return fmt.Errorf("%w: column %q is a name, but the profile has no header row", ErrInvalidProfile, col)
+		}
+	}
+	return nil
+}
+
+// Every column the profile reads
+func columns(p model.CSVProfile) []string {
+	cols := make([]string, 0, 8)
+	for _, col := range []string{p.DateColumn, p.AmountColumn, p.DebitColumn, p.CreditColumn, p.ClearedColumn, p.IDColumn} {
+		if col != "" {
+			cols = append(cols, col)
+		}
+	}
+	return append(cols, memoColumns(p)...)
+}
+
+// The memo joins several columns when given as a comma separated list
+func memoColumns(p model.CSVProfile) []string {
+	cols := make([]string, 0, 2)
+	for _, col := range strings.Split(p.MemoColumn, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// The JSON file shape, a list of these
+type jsonProfile struct {
+	Name          string `json:"name"`
+	Delimiter     string `json:"delimiter"`
+	SkipRows      int    `json:"skipRows"`
+	Header        bool   `json:"header"`
+	DateColumn    string `json:"dateColumn"`
+	DateFormat    string `json:"dateFormat"`
+	AmountColumn  string `json:"amountColumn"`
+	DebitColumn   string `json:"debitColumn"`
+	CreditColumn  string `json:"creditColumn"`
+	Negate        bool   `json:"negate"`
+	DecimalComma  bool   `json:"decimalComma"`
+	MemoColumn    string `json:"memoColumn"`
+	ClearedColumn string `json:"clearedColumn"`
+	ClearedValue  string `json:"clearedValue"`
+	IDColumn      string `json:"idColumn"`
+}
+
+// Read a JSON list of profiles, each one validated
+func LoadProfiles(r io.Reader) ([]model.CSVProfile, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	jps := make([]jsonProfile, 0)
+	if err := dec.Decode(&jps); err != nil {
+		return nil, fmt.Errorf("LoadProfiles.Decode -- %w", err)
+	}
+
+	ps := make([]model.CSVProfile, 0, len(jps))
+	for _, jp := range jps {
+		p := model.CSVProfile{
+			Name:          jp.Name,
+			Delimiter:     jp.Delimiter,
+			SkipRows:      jp.SkipRows,
+			Header:        jp.Header,
+			DateColumn:    jp.DateColumn,
+			DateFormat:    jp.DateFormat,
+			AmountColumn:  jp.AmountColumn,
+			DebitColumn:   jp.DebitColumn,
+			CreditColumn:  jp.CreditColumn,
+			Negate:        jp.Negate,
+			DecimalComma:  jp.DecimalComma,
+			MemoColumn:    jp.MemoColumn,
+			ClearedColumn: jp.ClearedColumn,
+			ClearedValue:  jp.ClearedValue,
+			IDColumn:      jp.IDColumn,
+		}
+		if err := Validate(p); err != nil {
+			return nil, fmt.Errorf("LoadProfiles.Validate.%s -- %w", jp.Name, err)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// Date format tokens and the Go layout they stand for, longest first so MM wins over M
+var dateTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"},
+	{"YY", "06"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"M", "1"},
+	{"DD", "02"},
+	{"D", "2"},
+}
+
+// Turn a format like DD/MM/YYYY into a time layout, anything but the tokens is taken literally
+func dateLayout(format string) (string, error) {
+	var b strings.Builder
+	var year, month, day bool
+
+	for rest := format; rest != ""; {
+		matched := false
+		for _, dt := range dateTokens {
+			if strings.HasPrefix(rest, dt.token) {
+				b.WriteString(dt.layout)
+				rest = rest[len(dt.token):]
+				year = year || dt.token[0] == 'Y'
+				month = month || dt.token[0] == 'M'
+				day = day || dt.token[0] == 'D'
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(rest[0])
+			rest = rest[1:]
+		}
+	}
+
+	if !year || !month || !day {
+		return "", fmt.Errorf("date format %q needs a year, month and day, like DD/MM/YYYY", format)
+	}
+	return b.String(), nil
+}
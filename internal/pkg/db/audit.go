@@ -0,0 +1,514 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit log and undo
+// Triggers on every table but the monthly checkpoints write a JSON image of each row before and after a change into audit
+// Every exported call that writes brackets itself with logOp, which files the audit rows it made as one AuditOp
+// Undo replays the images of the latest ops backwards, then recomputes the checkpoints they touched
+// Calls in one process take turns through opLock, so the audit rows between the two ends of a call are its own
+// Another process writing to the same DB at the same time can slip its rows into an op, undo then trips over them as conflicts
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrUndoConflict  = errors.New("row changed since the operation")
+)
+
+// Name of the ops Undo files, one per op it reverts
+const UndoName = "Undo"
+
+type AuditOp struct {
+	ID    model.PKEY
+	At    time.Time
+	Actor string
+	// The DB call, NewAccount, UpdateTransfer, ...
+	Name string
+	// Set on the ops Undo made, the op they reverted
+	Undoes sql.NullInt32
+	// Reverted by a later Undo
+	Undone bool
+	// Audit rows the op made
+	Rows int
+}
+
+// One row an op changed, Before is nil for an insert and After for a delete
+// Keys are lower case column names
+type AuditChange struct {
+	Table  string
+	Before map[string]any
+	After  map[string]any
+}
+
+func (o AuditOp) String() string {
+	s := fmt.Sprintf("%03d %s %s by %q, %d rows", o.ID, o.At.Format(time.RFC3339), o.Name, o.Actor, o.Rows)
+	if o.Undoes.Valid {
+		s += fmt.Sprintf(", undoes %03d", o.Undoes.Int32)
+	}
+	if o.Undone {
+		s += ", undone"
+	}
+	return s
+}
+
+func (c AuditChange) String() string {
+	switch {
+	case c.Before == nil:
+		return fmt.Sprintf("%s insert %v", c.Table, c.After)
+	case c.After == nil:
+		return fmt.Sprintf("%s delete %v", c.Table, c.Before)
+	}
+	return fmt.Sprintf("%s update %v -> %v", c.Table, c.Before, c.After)
+}
+
+var opLock sync.Mutex
+
+// The driver side of logOp
+type opLogger interface {
+	auditHead() (int64, error)
+	addOp(name string, head int64) error
+}
+
+// Call as the first line of a writing call, defer logOp(s, "Name", &err)() with err its named error result
+// A call that wrote nothing, failed ones included, files no op
+func logOp(l opLogger, name string, err *error) func() {
+	opLock.Lock()
+	head, herr := l.auditHead()
+	return func() {
+		defer opLock.Unlock()
+		if herr == nil {
+			herr = l.addOp(name, head)
+		}
+		if herr != nil && *err == nil {
+			*err = fmt.Errorf("%s.logOp -- %w", name, herr)
+		}
+	}
+}
+
+// Key columns of the audited tables
+var auditKeys = map[string][]string{
+	"e_grp":        {"id"},
+	"a":            {"id"},
+	"e":            {"id"},
+	"a_t":          {"id"},
+	"e_t":          {"id"},
+	"a_chk":        {"accountid", "month"},
+	"e_chk":        {"envelopeid", "month"},
+	"a_t_split":    {"id"},
+	"a_t_transfer": {"id"},
+	"p":            {"id"},
+	"r":            {"id"},
+	"csv_profile":  {"id"},
+	"sch":          {"id"},
+}
+
+const auditOpSelect = "SELECT ID, loggedAt, actor, name, undoes, lastID - firstID + 1, EXISTS (SELECT 1 FROM audit_op u WHERE u.undoes = o.ID) FROM audit_op o"
+
+func scanAuditOp(row interface{ Scan(...any) error }) (AuditOp, error) {
+	op := AuditOp{}
+	var at int64
+	err := row.Scan(&op.ID, &at, &op.Actor, &op.Name, &op.Undoes, &op.Rows, &op.Undone)
+	op.At = time.Unix(at, 0)
+	return op, err
+}
+
+func getHistory(q queryer, ph func(int) string, n int) ([]AuditOp, error) {
+	ops := make([]AuditOp, 0)
+
+	rows, err := q.Query(auditOpSelect+" ORDER BY ID DESC LIMIT "+ph(1), n)
+	if err != nil {
+		return nil, fmt.Errorf("getHistory.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		op, err := scanAuditOp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("getHistory.Scan -- %w", err)
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getHistory.Err -- %w", err)
+	}
+
+	return ops, nil
+}
+
+func getAuditChanges(q queryer, ph func(int) string, id model.PKEY) ([]AuditChange, error) {
+	var first, last int64
+	row := q.QueryRow("SELECT firstID, lastID FROM audit_op WHERE ID = "+ph(1), id)
+	if err := row.Scan(&first, &last); err != nil {
+		return nil, fmt.Errorf("getAuditChanges.Select.audit_op.Scan -- %w", err)
+	}
+	return loadChanges(q, ph, first, last)
+}
+
+func loadChanges(q queryer, ph func(int) string, first, last int64) ([]AuditChange, error) {
+	cs := make([]AuditChange, 0)
+
+	rows, err := q.Query("SELECT tbl, oldRow, newRow FROM audit WHERE ID >= "+ph(1)+" AND ID <= "+ph(2)+" ORDER BY ID", first, last)
+	if err != nil {
+		return nil, fmt.Errorf("loadChanges.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := AuditChange{}
+		var before, after sql.NullString
+		if err := rows.Scan(&c.Table, &before, &after); err != nil {
+			return nil, fmt.Errorf("loadChanges.Scan -- %w", err)
+		}
+		if c.Before, err = decodeImage(before); err != nil {
+			return nil, fmt.Errorf("loadChanges.decodeImage -- %w", err)
+		}
+		if c.After, err = decodeImage(after); err != nil {
+			return nil, fmt.Errorf("loadChanges.decodeImage -- %w", err)
+		}
+		cs = append(cs, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadChanges.Err -- %w", err)
+	}
+
+	return cs, nil
+}
+
+// Every number in a row is an integer, so they come back as int64
+func decodeImage(s sql.NullString) (map[string]any, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(s.String))
+	dec.UseNumber()
+	img := make(map[string]any)
+	if err := dec.Decode(&img); err != nil {
+		return nil, err
+	}
+	for k, v := range img {
+		if n, ok := v.(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("column %s -- %w", k, err)
+			}
+			img[k] = i
+		}
+	}
+	return img, nil
+}
+
+// Latest op that is neither an undo nor undone yet
+func lastOp(q queryer) (AuditOp, int64, int64, error) {
+	var first, last int64
+	op := AuditOp{}
+	var at int64
+	row := q.QueryRow("SELECT ID, loggedAt, actor, name, firstID, lastID FROM audit_op o WHERE undoes IS NULL AND NOT EXISTS (SELECT 1 FROM audit_op u WHERE u.undoes = o.ID) ORDER BY ID DESC LIMIT 1")
+	if err := row.Scan(&op.ID, &at, &op.Actor, &op.Name, &first, &last); err != nil {
+		return op, 0, 0, err
+	}
+	op.At = time.Unix(at, 0)
+	op.Rows = int(last - first + 1)
+	return op, first, last, nil
+}
+
+func insertOp(tx *sql.Tx, ph func(int) string, actor, name string, first, last int64, undoes sql.NullInt32) error {
+	_, err := tx.Exec("INSERT INTO audit_op (loggedAt,actor,name,firstID,lastID,undoes) VALUES ("+phList(ph, 1, 6)+")", time.Now().Unix(), actor, name, first, last, undoes)
+	return err
+}
+
+func phList(ph func(int) string, from, n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = ph(from + i)
+	}
+	return strings.Join(ps, ",")
+}
+
+func sortedColumns(img map[string]any) []string {
+	cols := make([]string, 0, len(img))
+	for c := range img {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// The row as it stands now, nil when it is gone
+func currentRow(tx *sql.Tx, ph func(int) string, table string, keys []string, img map[string]any) (map[string]any, error) {
+	where, args := keyWhere(ph, 1, keys, img)
+	rows, err := tx.Query("SELECT * FROM "+table+" WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	cur := make(map[string]any, len(cols))
+	for i, c := range cols {
+		cur[strings.ToLower(c)] = vals[i]
+	}
+	return cur, nil
+}
+
+func keyWhere(ph func(int) string, from int, keys []string, img map[string]any) (string, []any) {
+	conds := make([]string, len(keys))
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		conds[i] = `"` + k + `" = ` + ph(from+i)
+		args[i] = img[k]
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Compared as text, SQLite hands back TEXT as []byte at times
+func sameRow(cur, img map[string]any) bool {
+	for k, v := range img {
+		c := cur[k]
+		if b, ok := c.([]byte); ok {
+			c = string(b)
+		}
+		if fmt.Sprint(c) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Put one row back the way it was before the op, the row has to be as the op left it
+func revertChange(tx *sql.Tx, ph func(int) string, c AuditChange) error {
+	keys, ok := auditKeys[c.Table]
+	if !ok {
+		return fmt.Errorf("revertChange -- table %s is not audited", c.Table)
+	}
+	img := c.After
+	if img == nil {
+		img = c.Before
+	}
+
+	cur, err := currentRow(tx, ph, c.Table, keys, img)
+	if err != nil {
+		return fmt.Errorf("revertChange.currentRow -- %w", err)
+	}
+	if (c.After == nil && cur != nil) || (c.After != nil && (cur == nil || !sameRow(cur, c.After))) {
+		_, args := keyWhere(ph, 1, keys, img)
+		return fmt.Errorf("revertChange -- %w: %s %v", ErrUndoConflict, c.Table, args)
+	}
+
+	switch {
+	case c.Before == nil:
+		// Monthly checkpoints are not audited, the recompute after the undo puts back what is still needed
+		switch c.Table {
+		case "a":
+			_, err = tx.Exec("DELETE FROM a_chk WHERE accountID = "+ph(1)+" AND month > 0", img["id"])
+		case "e":
+			_, err = tx.Exec("DELETE FROM e_chk WHERE envelopeID = "+ph(1)+" AND month > 0", img["id"])
+		}
+		if err != nil {
+			return fmt.Errorf("revertChange.Delete.chk -- %w", err)
+		}
+		where, args := keyWhere(ph, 1, keys, img)
+		if _, err := tx.Exec("DELETE FROM "+c.Table+" WHERE "+where, args...); err != nil {
+			return fmt.Errorf("revertChange.Delete.%s -- %w", c.Table, err)
+		}
+
+	case c.After == nil:
+		cols := sortedColumns(c.Before)
+		args := make([]any, len(cols))
+		for i, col := range cols {
+			args[i] = c.Before[col]
+			cols[i] = `"` + col + `"`
+		}
+		if _, err := tx.Exec("INSERT INTO "+c.Table+" ("+strings.Join(cols, ",")+") VALUES ("+phList(ph, 1, len(cols))+")", args...); err != nil {
+			return fmt.Errorf("revertChange.Insert.%s -- %w", c.Table, err)
+		}
+
+	default:
+		cols := sortedColumns(c.Before)
+		sets := make([]string, len(cols))
+		args := make([]any, len(cols))
+		for i, col := range cols {
+			sets[i] = `"` + col + `" = ` + ph(i+1)
+			args[i] = c.Before[col]
+		}
+		where, kargs := keyWhere(ph, len(cols)+1, keys, c.After)
+		if _, err := tx.Exec("UPDATE "+c.Table+" SET "+strings.Join(sets, ", ")+" WHERE "+where, append(args, kargs...)...); err != nil {
+			return fmt.Errorf("revertChange.Update.%s -- %w", c.Table, err)
+		}
+	}
+
+	return nil
+}
+
+// What an undo has to recompute checkpoints for, from start on
+type touched struct {
+	start bcdate.BCDate
+	aids  map[model.PKEY]bool
+	eids  map[model.PKEY]bool
+	// Transactions whose splits changed, their dates are looked up once the undo is done
+	tids map[model.PKEY]bool
+}
+
+func newTouched() touched {
+	return touched{start: bcdate.Never(), aids: make(map[model.PKEY]bool), eids: make(map[model.PKEY]bool), tids: make(map[model.PKEY]bool)}
+}
+
+func (t *touched) add(table string, img map[string]any) {
+	if img == nil {
+		return
+	}
+	id := func(col string) (model.PKEY, bool) {
+		v, ok := img[col].(int64)
+		return model.PKEY(v), ok
+	}
+	date := func(col string) {
+		if d, ok := img[col].(int64); ok {
+			t.start = bcdate.Oldest(t.start, bcdate.BCDate(d))
+		}
+	}
+
+	switch table {
+	case "a", "a_chk":
+		col := "id"
+		if table == "a_chk" {
+			col = "accountid"
+		}
+		if aid, ok := id(col); ok {
+			t.aids[aid] = true
+		}
+		t.start = bcdate.Epoch()
+	case "e", "e_chk":
+		col := "id"
+		if table == "e_chk" {
+			col = "envelopeid"
+		}
+		if eid, ok := id(col); ok {
+			t.eids[eid] = true
+		}
+		t.start = bcdate.Epoch()
+	case "a_t":
+		if aid, ok := id("accountid"); ok {
+			t.aids[aid] = true
+		}
+		if eid, ok := id("envelopeid"); ok {
+			t.eids[eid] = true
+		}
+		date("postdate")
+	case "e_t":
+		if eid, ok := id("envelopeid"); ok {
+			t.eids[eid] = true
+		}
+		date("postdate")
+	case "a_t_split":
+		if eid, ok := id("envelopeid"); ok {
+			t.eids[eid] = true
+		}
+		if tid, ok := id("transactionid"); ok {
+			t.tids[tid] = true
+		}
+	}
+}
+
+// Split dates, then the accounts and envelopes that still exist
+func (t *touched) settle(tx *sql.Tx, ph func(int) string) (aids []model.PKEY, eids []model.PKEY, err error) {
+	for _, tid := range sortedKeys(t.tids) {
+		var d bcdate.BCDate
+		err := tx.QueryRow("SELECT postDate FROM a_t WHERE ID = "+ph(1), tid).Scan(&d)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Gone with the undo, its own image carries the date
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("settle.Select.a_t.Scan -- %w", err)
+		}
+		t.start = bcdate.Oldest(t.start, d)
+	}
+
+	exists := func(table string, ids map[model.PKEY]bool) ([]model.PKEY, error) {
+		out := make([]model.PKEY, 0, len(ids))
+		for _, id := range sortedKeys(ids) {
+			var n int
+			if err := tx.QueryRow("SELECT count(*) FROM "+table+" WHERE ID = "+ph(1), id).Scan(&n); err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				out = append(out, id)
+			}
+		}
+		return out, nil
+	}
+	if aids, err = exists("a", t.aids); err != nil {
+		return nil, nil, fmt.Errorf("settle.Select.a -- %w", err)
+	}
+	if eids, err = exists("e", t.eids); err != nil {
+		return nil, nil, fmt.Errorf("settle.Select.e -- %w", err)
+	}
+	return aids, eids, nil
+}
+
+// Revert the latest n ops in tx, filing an UndoName op for each, returns the ops reverted
+func undoOps(tx *sql.Tx, ph func(int) string, actor string, n int, t *touched) ([]AuditOp, error) {
+	ops := make([]AuditOp, 0, n)
+	for len(ops) < n {
+		op, first, last, err := lastOp(tx)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("undoOps.lastOp -- %w", err)
+		}
+
+		cs, err := loadChanges(tx, ph, first, last)
+		if err != nil {
+			return nil, fmt.Errorf("undoOps.loadChanges -- %w", err)
+		}
+
+		var head int64
+		if err := tx.QueryRow("SELECT coalesce(max(ID), 0) FROM audit").Scan(&head); err != nil {
+			return nil, fmt.Errorf("undoOps.Select.audit.Scan -- %w", err)
+		}
+		for i := len(cs) - 1; i >= 0; i-- {
+			if err := revertChange(tx, ph, cs[i]); err != nil {
+				return nil, fmt.Errorf("undoOps.revertChange -- op %d %s -- %w", op.ID, op.Name, err)
+			}
+			t.add(cs[i].Table, cs[i].Before)
+			t.add(cs[i].Table, cs[i].After)
+		}
+		var now int64
+		if err := tx.QueryRow("SELECT coalesce(max(ID), 0) FROM audit").Scan(&now); err != nil {
+			return nil, fmt.Errorf("undoOps.Select.audit.Scan -- %w", err)
+		}
+
+		if err := insertOp(tx, ph, actor, UndoName, head+1, now, sql.NullInt32{Int32: int32(op.ID), Valid: true}); err != nil {
+			return nil, fmt.Errorf("undoOps.insertOp -- %w", err)
+		}
+		op.Undone = true
+		ops = append(ops, op)
+	}
+
+	if len(ops) == 0 {
+		return nil, ErrNothingToUndo
+	}
+	return ops, nil
+}
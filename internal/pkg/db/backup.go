@@ -0,0 +1,8 @@
+package db
+
+import "errors"
+
+// Online backups: a consistent copy of the live DB written while it stays open
+// SQLite copies through VACUUM INTO, Postgres has pg_dump for that
+
+var ErrBackupUnsupported = errors.New("online backups are not supported by this driver")
@@ -0,0 +1,850 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Consistency checker for the invariants in docs/DB_notes.txt
+// Loads the raw tables and recomputes every checkpoint from the transactions, so it shares no code with the update paths it verifies
+// Queries take no parameters and stick to SQL both drivers accept
+
+// One broken invariant
+// Key names the row, Column is empty when the whole row is missing or unexpected
+type Violation struct {
+	Check    string
+	Table    string
+	Key      string
+	Column   string
+	Expected string
+	Actual   string
+}
+
+func (v Violation) String() string {
+	col := ""
+	if v.Column != "" {
+		col = "." + v.Column
+	}
+	return fmt.Sprintf("%s: %s[%s]%s -- expected %s, actual %s", v.Check, v.Table, v.Key, col, v.Expected, v.Actual)
+}
+
+const (
+	present = "present"
+	missing = "missing"
+)
+
+type chkAccount struct {
+	id        model.PKEY
+	offbudget bool
+	debt      bool
+	closed    sql.NullInt32
+}
+
+type chkEnvelope struct {
+	id          model.PKEY
+	debtAccount sql.NullInt32
+}
+
+type chkPayee struct {
+	id         model.PKEY
+	envelopeID sql.NullInt32
+}
+
+type chkRule struct {
+	id         model.PKEY
+	accountID  sql.NullInt32
+	envelopeID sql.NullInt32
+}
+
+type chkSchedule struct {
+	id         model.PKEY
+	accountID  model.PKEY
+	envelopeID sql.NullInt32
+	payeeID    sql.NullInt32
+}
+
+type chkAT struct {
+	id         model.PKEY
+	accountID  model.PKEY
+	envelopeID sql.NullInt32
+	payeeID    sql.NullInt32
+	typ        model.TransactionType
+	postDate   bcdate.BCDate
+	amount     int
+	cleared    bool
+	reconciled bool
+}
+
+type chkSplit struct {
+	id            model.PKEY
+	transactionID model.PKEY
+	envelopeID    sql.NullInt32
+	amount        int
+}
+
+type chkTransfer struct {
+	id     model.PKEY
+	fromID model.PKEY
+	toID   model.PKEY
+}
+
+type chkET struct {
+	id         model.PKEY
+	envelopeID model.PKEY
+	postDate   bcdate.BCDate
+	amount     int
+}
+
+// Checkpoint columns in a fixed order, so the comparisons read alike for all three tables
+var (
+	aChkCols = []string{"bal", "in", "out", "uncleared"}
+	eChkCols = []string{"bal", "in", "out"}
+	sChkCols = []string{"float", "income", "expenses", "delta", "banked", "netWorth"}
+)
+
+type checker struct {
+	q  queryer
+	vs []Violation
+
+	accounts  []chkAccount
+	envelopes []chkEnvelope
+	payees    []chkPayee
+	rules     []chkRule
+	schedules []chkSchedule
+	ats       []chkAT
+	splits    []chkSplit
+	transfers []chkTransfer
+	ets       []chkET
+
+	// Keyed by ID then month
+	aChk map[model.PKEY]map[bcdate.BCDate][]int
+	eChk map[model.PKEY]map[bcdate.BCDate][]int
+	// Keyed by month
+	sChk map[bcdate.BCDate][]int
+}
+
+func checkConsistency(q queryer) ([]Violation, error) {
+	c := &checker{q: q, vs: make([]Violation, 0)}
+
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("checkConsistency.load -- %w", err)
+	}
+
+	c.checkEpochs()
+	c.checkDebtEnvelopes()
+	c.checkOrphans()
+	c.checkSplits()
+	c.checkTransfers()
+	c.checkReconciled()
+	c.checkClosed()
+	c.checkAccountCheckpoints()
+	c.checkEnvelopeCheckpoints()
+	c.checkSummaryCheckpoints()
+
+	return c.vs, nil
+}
+
+func (c *checker) report(check, table, key, column, expected, actual string) {
+	c.vs = append(c.vs, Violation{check, table, key, column, expected, actual})
+}
+
+func month(d bcdate.BCDate) bcdate.BCDate {
+	return d - d%100
+}
+
+func (c *checker) load() error {
+	rows, err := c.q.Query("SELECT ID, offbudget, debt, closed FROM a ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.a -- %w", err)
+	}
+	for rows.Next() {
+		a := chkAccount{}
+		if err := rows.Scan(&a.id, &a.offbudget, &a.debt, &a.closed); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.a -- %w", err)
+		}
+		c.accounts = append(c.accounts, a)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.a -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, debtAccount FROM e ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.e -- %w", err)
+	}
+	for rows.Next() {
+		e := chkEnvelope{}
+		if err := rows.Scan(&e.id, &e.debtAccount); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.e -- %w", err)
+		}
+		c.envelopes = append(c.envelopes, e)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.e -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, envelopeID FROM p ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.p -- %w", err)
+	}
+	for rows.Next() {
+		p := chkPayee{}
+		if err := rows.Scan(&p.id, &p.envelopeID); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.p -- %w", err)
+		}
+		c.payees = append(c.payees, p)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.p -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, accountID, envelopeID FROM r ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.r -- %w", err)
+	}
+	for rows.Next() {
+		r := chkRule{}
+		if err := rows.Scan(&r.id, &r.accountID, &r.envelopeID); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.r -- %w", err)
+		}
+		c.rules = append(c.rules, r)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.r -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, accountID, envelopeID, payeeID FROM sch ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.sch -- %w", err)
+	}
+	for rows.Next() {
+		sc := chkSchedule{}
+		if err := rows.Scan(&sc.id, &sc.accountID, &sc.envelopeID, &sc.payeeID); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.sch -- %w", err)
+		}
+		c.schedules = append(c.schedules, sc)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.sch -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, accountID, envelopeID, payeeID, type, postDate, amount, cleared, reconciled FROM a_t ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.a_t -- %w", err)
+	}
+	for rows.Next() {
+		at := chkAT{}
+		if err := rows.Scan(&at.id, &at.accountID, &at.envelopeID, &at.payeeID, &at.typ, &at.postDate, &at.amount, &at.cleared, &at.reconciled); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.a_t -- %w", err)
+		}
+		c.ats = append(c.ats, at)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.a_t -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, transactionID, envelopeID, amount FROM a_t_split ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.a_t_split -- %w", err)
+	}
+	for rows.Next() {
+		sp := chkSplit{}
+		if err := rows.Scan(&sp.id, &sp.transactionID, &sp.envelopeID, &sp.amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.a_t_split -- %w", err)
+		}
+		c.splits = append(c.splits, sp)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.a_t_split -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, fromID, toID FROM a_t_transfer ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.a_t_transfer -- %w", err)
+	}
+	for rows.Next() {
+		x := chkTransfer{}
+		if err := rows.Scan(&x.id, &x.fromID, &x.toID); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.a_t_transfer -- %w", err)
+		}
+		c.transfers = append(c.transfers, x)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.a_t_transfer -- %w", err)
+	}
+
+	rows, err = c.q.Query("SELECT ID, envelopeID, postDate, amount FROM e_t ORDER BY ID")
+	if err != nil {
+		return fmt.Errorf("Select.e_t -- %w", err)
+	}
+	for rows.Next() {
+		et := chkET{}
+		if err := rows.Scan(&et.id, &et.envelopeID, &et.postDate, &et.amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan.e_t -- %w", err)
+		}
+		c.ets = append(c.ets, et)
+	}
+	if err := closeRows(rows); err != nil {
+		return fmt.Errorf("Rows.e_t -- %w", err)
+	}
+
+	if c.aChk, c.eChk, c.sChk, err = loadCheckpoints(c.q); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func loadChk(q queryer, query string, ncols int) (map[model.PKEY]map[bcdate.BCDate][]int, error) {
+	ret := make(map[model.PKEY]map[bcdate.BCDate][]int)
+
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("Select -- %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key model.PKEY
+		var m bcdate.BCDate
+		vals := make([]int, ncols)
+
+		dest := []any{&key, &m}
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("Scan -- %w", err)
+		}
+
+		if ret[key] == nil {
+			ret[key] = make(map[bcdate.BCDate][]int)
+		}
+		ret[key][m] = vals
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Err -- %w", err)
+	}
+
+	return ret, nil
+}
+
+// All three checkpoint tables, columns in the order of aChkCols, eChkCols and sChkCols
+func loadCheckpoints(q queryer) (aChk, eChk map[model.PKEY]map[bcdate.BCDate][]int, sChk map[bcdate.BCDate][]int, err error) {
+	if aChk, err = loadChk(q, "SELECT accountID, month, bal, \"in\", out, uncleared FROM a_chk", len(aChkCols)); err != nil {
+		return nil, nil, nil, fmt.Errorf("a_chk -- %w", err)
+	}
+	if eChk, err = loadChk(q, "SELECT envelopeID, month, bal, \"in\", out FROM e_chk", len(eChkCols)); err != nil {
+		return nil, nil, nil, fmt.Errorf("e_chk -- %w", err)
+	}
+
+	// Summaries have no owner, load them under a single zero key
+	s, err := loadChk(q, "SELECT 0, month, \"float\", income, expenses, delta, banked, netWorth FROM s_chk", len(sChkCols))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("s_chk -- %w", err)
+	}
+	sChk = s[0]
+	if sChk == nil {
+		sChk = make(map[bcdate.BCDate][]int)
+	}
+
+	return aChk, eChk, sChk, nil
+}
+
+func closeRows(rows *sql.Rows) error {
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	return rows.Close()
+}
+
+// Starting checkpoint exists at EPOCH (date=0) for all accounts, envelopes, and summary
+func (c *checker) checkEpochs() {
+	for _, a := range c.accounts {
+		if _, ok := c.aChk[a.id][bcdate.Epoch()]; !ok {
+			c.report("epoch", "a_chk", chkKey("accountID", a.id, bcdate.Epoch()), "", present, missing)
+		}
+	}
+	for _, e := range c.envelopes {
+		if _, ok := c.eChk[e.id][bcdate.Epoch()]; !ok {
+			c.report("epoch", "e_chk", chkKey("envelopeID", e.id, bcdate.Epoch()), "", present, missing)
+		}
+	}
+	if _, ok := c.sChk[bcdate.Epoch()]; !ok {
+		c.report("epoch", "s_chk", monthKey(bcdate.Epoch()), "", present, missing)
+	}
+}
+
+// Debt envelopes exist iff account is a debt account
+func (c *checker) checkDebtEnvelopes() {
+	debtEnvelopes := make(map[model.PKEY][]model.PKEY)
+	for _, e := range c.envelopes {
+		if e.debtAccount.Valid {
+			aid := model.PKEY(e.debtAccount.Int32)
+			debtEnvelopes[aid] = append(debtEnvelopes[aid], e.id)
+		}
+	}
+
+	accounts := make(map[model.PKEY]chkAccount, len(c.accounts))
+	for _, a := range c.accounts {
+		accounts[a.id] = a
+
+		n := len(debtEnvelopes[a.id])
+		switch {
+		case a.debt && n != 1:
+			c.report("debt envelope", "e", "debtAccount="+strconv.Itoa(int(a.id)), "", "1 envelope", fmt.Sprintf("%d envelopes", n))
+		case !a.debt && n != 0:
+			c.report("debt envelope", "e", "debtAccount="+strconv.Itoa(int(a.id)), "", "0 envelopes for a non-debt account", fmt.Sprintf("%d envelopes", n))
+		}
+	}
+
+	for _, e := range c.envelopes {
+		if !e.debtAccount.Valid {
+			continue
+		}
+		if _, ok := accounts[model.PKEY(e.debtAccount.Int32)]; !ok {
+			c.report("debt envelope", "e", idKey(e.id), "debtAccount", "an existing account", strconv.Itoa(int(e.debtAccount.Int32)))
+		}
+	}
+}
+
+// No a_t/e_t exist without an a/e, no reference points at a deleted account, payee or envelope, and no checkpoints outlive their owner
+func (c *checker) checkOrphans() {
+	accounts := make(map[model.PKEY]bool, len(c.accounts))
+	for _, a := range c.accounts {
+		accounts[a.id] = true
+	}
+	envelopes := make(map[model.PKEY]bool, len(c.envelopes))
+	for _, e := range c.envelopes {
+		envelopes[e.id] = true
+	}
+
+	for _, at := range c.ats {
+		if !accounts[at.accountID] {
+			c.report("orphan", "a_t", idKey(at.id), "accountID", "an existing account", strconv.Itoa(int(at.accountID)))
+		}
+		if at.envelopeID.Valid && !envelopes[model.PKEY(at.envelopeID.Int32)] {
+			c.report("orphan", "a_t", idKey(at.id), "envelopeID", "an existing envelope or NULL", strconv.Itoa(int(at.envelopeID.Int32)))
+		}
+	}
+	payees := make(map[model.PKEY]bool, len(c.payees))
+	for _, p := range c.payees {
+		payees[p.id] = true
+		if p.envelopeID.Valid && !envelopes[model.PKEY(p.envelopeID.Int32)] {
+			c.report("orphan", "p", idKey(p.id), "envelopeID", "an existing envelope or NULL", strconv.Itoa(int(p.envelopeID.Int32)))
+		}
+	}
+	for _, r := range c.rules {
+		if r.accountID.Valid && !accounts[model.PKEY(r.accountID.Int32)] {
+			c.report("orphan", "r", idKey(r.id), "accountID", "an existing account or NULL", strconv.Itoa(int(r.accountID.Int32)))
+		}
+		if r.envelopeID.Valid && !envelopes[model.PKEY(r.envelopeID.Int32)] {
+			c.report("orphan", "r", idKey(r.id), "envelopeID", "an existing envelope or NULL", strconv.Itoa(int(r.envelopeID.Int32)))
+		}
+	}
+	for _, at := range c.ats {
+		if at.payeeID.Valid && !payees[model.PKEY(at.payeeID.Int32)] {
+			c.report("orphan", "a_t", idKey(at.id), "payeeID", "an existing payee or NULL", strconv.Itoa(int(at.payeeID.Int32)))
+		}
+	}
+	for _, sc := range c.schedules {
+		if !accounts[sc.accountID] {
+			c.report("orphan", "sch", idKey(sc.id), "accountID", "an existing account", strconv.Itoa(int(sc.accountID)))
+		}
+		if sc.envelopeID.Valid && !envelopes[model.PKEY(sc.envelopeID.Int32)] {
+			c.report("orphan", "sch", idKey(sc.id), "envelopeID", "an existing envelope or NULL", strconv.Itoa(int(sc.envelopeID.Int32)))
+		}
+		if sc.payeeID.Valid && !payees[model.PKEY(sc.payeeID.Int32)] {
+			c.report("orphan", "sch", idKey(sc.id), "payeeID", "an existing payee or NULL", strconv.Itoa(int(sc.payeeID.Int32)))
+		}
+	}
+	for _, sp := range c.splits {
+		if sp.envelopeID.Valid && !envelopes[model.PKEY(sp.envelopeID.Int32)] {
+			c.report("orphan", "a_t_split", idKey(sp.id), "envelopeID", "an existing envelope or NULL", strconv.Itoa(int(sp.envelopeID.Int32)))
+		}
+	}
+	for _, et := range c.ets {
+		if !envelopes[et.envelopeID] {
+			c.report("orphan", "e_t", idKey(et.id), "envelopeID", "an existing envelope", strconv.Itoa(int(et.envelopeID)))
+		}
+	}
+
+	for _, aid := range sortedKeys(c.aChk) {
+		if !accounts[aid] {
+			c.report("orphan", "a_chk", "accountID="+strconv.Itoa(int(aid)), "", "an existing account", fmt.Sprintf("%d checkpoints", len(c.aChk[aid])))
+		}
+	}
+	for _, eid := range sortedKeys(c.eChk) {
+		if !envelopes[eid] {
+			c.report("orphan", "e_chk", "envelopeID="+strconv.Itoa(int(eid)), "", "an existing envelope", fmt.Sprintf("%d checkpoints", len(c.eChk[eid])))
+		}
+	}
+}
+
+// Split rows belong to an existing a_t without an envelope of its own, and sum to its amount
+func (c *checker) checkSplits() {
+	ats := make(map[model.PKEY]chkAT, len(c.ats))
+	for _, at := range c.ats {
+		ats[at.id] = at
+	}
+
+	totals := make(map[model.PKEY]int)
+	for _, sp := range c.splits {
+		if _, ok := ats[sp.transactionID]; !ok {
+			c.report("orphan", "a_t_split", idKey(sp.id), "transactionID", "an existing transaction", strconv.Itoa(int(sp.transactionID)))
+			continue
+		}
+		totals[sp.transactionID] += sp.amount
+	}
+
+	for _, id := range sortedKeys(totals) {
+		at := ats[id]
+		if at.envelopeID.Valid {
+			c.report("split", "a_t", idKey(id), "envelopeID", "NULL on a split transaction", strconv.Itoa(int(at.envelopeID.Int32)))
+		}
+		if totals[id] != at.amount {
+			c.report("split", "a_t", idKey(id), "amount", strconv.Itoa(totals[id])+" (sum of splits)", strconv.Itoa(at.amount))
+		}
+	}
+}
+
+// Transfer legs are two existing TT_TRANSFER a_t in different accounts, each in one transfer only
+// The legs mirror each other in date and amount, and only the negative From leg may have an envelope
+func (c *checker) checkTransfers() {
+	ats := make(map[model.PKEY]chkAT, len(c.ats))
+	for _, at := range c.ats {
+		ats[at.id] = at
+	}
+
+	legs := make(map[model.PKEY]model.PKEY)
+	for _, x := range c.transfers {
+		key := idKey(x.id)
+
+		for _, leg := range []model.PKEY{x.fromID, x.toID} {
+			if other, ok := legs[leg]; ok {
+				c.report("transfer", "a_t_transfer", key, "", "transaction "+strconv.Itoa(int(leg))+" in one transfer", "also in transfer "+strconv.Itoa(int(other)))
+			}
+			legs[leg] = x.id
+		}
+
+		from, fromOK := ats[x.fromID]
+		to, toOK := ats[x.toID]
+		if !fromOK {
+			c.report("orphan", "a_t_transfer", key, "fromID", "an existing transaction", strconv.Itoa(int(x.fromID)))
+		}
+		if !toOK {
+			c.report("orphan", "a_t_transfer", key, "toID", "an existing transaction", strconv.Itoa(int(x.toID)))
+		}
+		if !fromOK || !toOK {
+			continue
+		}
+
+		if from.accountID == to.accountID {
+			c.report("transfer", "a_t_transfer", key, "toID", "a leg outside account "+strconv.Itoa(int(from.accountID)), strconv.Itoa(int(x.toID)))
+		}
+		for _, at := range []chkAT{from, to} {
+			if at.typ != model.TT_TRANSFER {
+				c.report("transfer", "a_t", idKey(at.id), "type", strconv.Itoa(int(model.TT_TRANSFER)), strconv.Itoa(int(at.typ)))
+			}
+		}
+		if from.amount >= 0 {
+			c.report("transfer", "a_t", idKey(from.id), "amount", "negative on the from leg", strconv.Itoa(from.amount))
+		}
+		if to.amount != -from.amount {
+			c.report("transfer", "a_t", idKey(to.id), "amount", strconv.Itoa(-from.amount)+" (from leg negated)", strconv.Itoa(to.amount))
+		}
+		if to.postDate != from.postDate {
+			c.report("transfer", "a_t", idKey(to.id), "postDate", strconv.Itoa(int(from.postDate))+" (from leg)", strconv.Itoa(int(to.postDate)))
+		}
+		if to.envelopeID.Valid {
+			c.report("transfer", "a_t", idKey(to.id), "envelopeID", "NULL on the to leg", strconv.Itoa(int(to.envelopeID.Int32)))
+		}
+	}
+}
+
+// Only cleared transactions can be reconciled
+func (c *checker) checkReconciled() {
+	for _, at := range c.ats {
+		if at.reconciled && !at.cleared {
+			c.report("reconciled", "a_t", idKey(at.id), "cleared", "true on a reconciled transaction", "false")
+		}
+	}
+}
+
+// Closed accounts hold nothing after their close date
+func (c *checker) checkClosed() {
+	closed := make(map[model.PKEY]sql.NullInt32, len(c.accounts))
+	for _, a := range c.accounts {
+		closed[a.id] = a.closed
+	}
+	for _, at := range c.ats {
+		if d := closed[at.accountID]; d.Valid && at.postDate > bcdate.BCDate(d.Int32) {
+			c.report("closed", "a_t", idKey(at.id), "postDate", "on or before "+strconv.Itoa(int(d.Int32)), strconv.Itoa(int(at.postDate)))
+		}
+	}
+}
+
+// Every month holding transactions has a checkpoint, and every checkpoint matches the transactions
+func (c *checker) checkAccountCheckpoints() {
+	// Account -> month -> in, out, uncleared
+	flows := make(map[model.PKEY]map[bcdate.BCDate][]int)
+	for _, at := range c.ats {
+		f := monthFlows(flows, at.accountID, month(at.postDate), 3)
+		if at.amount > 0 {
+			f[0] += at.amount
+		} else {
+			f[1] += at.amount
+		}
+		if !at.cleared {
+			f[2] += at.amount
+		}
+	}
+
+	for _, a := range c.accounts {
+		chks := c.aChk[a.id]
+		if _, ok := chks[bcdate.Epoch()]; !ok {
+			// Already reported, and without a starting balance there is nothing to compare to
+			continue
+		}
+
+		c.compareChain("a_chk", "accountID", a.id, aChkCols, chks, flows[a.id], func(bal int, f []int) []int {
+			return []int{bal, f[0], f[1], f[2]}
+		})
+	}
+}
+
+func (c *checker) checkEnvelopeCheckpoints() {
+	// Envelope -> month -> in (e_t), out (a_t)
+	flows := make(map[model.PKEY]map[bcdate.BCDate][]int)
+	for _, et := range c.ets {
+		monthFlows(flows, et.envelopeID, month(et.postDate), 2)[0] += et.amount
+	}
+	ats := make(map[model.PKEY]chkAT, len(c.ats))
+	for _, at := range c.ats {
+		ats[at.id] = at
+		if at.envelopeID.Valid {
+			monthFlows(flows, model.PKEY(at.envelopeID.Int32), month(at.postDate), 2)[1] += at.amount
+		}
+	}
+	for _, sp := range c.splits {
+		at, ok := ats[sp.transactionID]
+		if ok && sp.envelopeID.Valid {
+			monthFlows(flows, model.PKEY(sp.envelopeID.Int32), month(at.postDate), 2)[1] += sp.amount
+		}
+	}
+
+	for _, e := range c.envelopes {
+		chks := c.eChk[e.id]
+		if _, ok := chks[bcdate.Epoch()]; !ok {
+			continue
+		}
+
+		c.compareChain("e_chk", "envelopeID", e.id, eChkCols, chks, flows[e.id], func(bal int, f []int) []int {
+			return []int{bal, f[0], f[1]}
+		})
+	}
+}
+
+func monthFlows(flows map[model.PKEY]map[bcdate.BCDate][]int, id model.PKEY, m bcdate.BCDate, n int) []int {
+	if flows[id] == nil {
+		flows[id] = make(map[bcdate.BCDate][]int)
+	}
+	f := flows[id][m]
+	if f == nil {
+		f = make([]int, n)
+		flows[id][m] = f
+	}
+	return f
+}
+
+// Walk the months holding transactions or checkpoints in order, carrying the balance from the EPOCH checkpoint
+// expect turns the running balance and the month's flows into the checkpoint columns
+func (c *checker) compareChain(table, keyName string, id model.PKEY, cols []string, chks map[bcdate.BCDate][]int, flows map[bcdate.BCDate][]int, expect func(bal int, f []int) []int) {
+	months := make(map[bcdate.BCDate]bool)
+	for m := range flows {
+		months[m] = true
+	}
+	for m := range chks {
+		if m != bcdate.Epoch() {
+			months[m] = true
+		}
+	}
+
+	bal := chks[bcdate.Epoch()][0]
+	for _, m := range sortedMonths(months) {
+		f := flows[m]
+		if f == nil {
+			f = make([]int, len(cols)-1)
+		}
+		for _, v := range f[:2] {
+			bal += v
+		}
+
+		got, ok := chks[m]
+		if !ok {
+			c.report("checkpoint", table, chkKey(keyName, id, m), "", present, missing)
+			continue
+		}
+		c.compareRow("checkpoint", table, chkKey(keyName, id, m), cols, expect(bal, f), got)
+	}
+}
+
+func (c *checker) checkSummaryCheckpoints() {
+	onBudget := make(map[model.PKEY]bool)
+	for _, a := range c.accounts {
+		onBudget[a.id] = !a.offbudget
+	}
+
+	months := make(map[bcdate.BCDate]bool)
+	for m := range c.sChk {
+		if m != bcdate.Epoch() {
+			months[m] = true
+		}
+	}
+
+	// Month -> income, expenses, delta over on budget accounts
+	flows := make(map[bcdate.BCDate][]int)
+	for _, at := range c.ats {
+		m := month(at.postDate)
+		months[m] = true
+		if !onBudget[at.accountID] {
+			continue
+		}
+		f := flows[m]
+		if f == nil {
+			f = make([]int, 3)
+			flows[m] = f
+		}
+		switch at.typ {
+		case model.TT_INCOME:
+			f[0] += at.amount
+		case model.TT_NORM:
+			f[1] += at.amount
+		}
+		f[2] += at.amount
+	}
+	for _, et := range c.ets {
+		months[month(et.postDate)] = true
+	}
+
+	// Balances as of each month come from the transactions, not the stored checkpoints, so one bad checkpoint is not hidden by another
+	abal := make(map[model.PKEY]int)
+	for _, a := range c.accounts {
+		if chk, ok := c.aChk[a.id][bcdate.Epoch()]; ok {
+			abal[a.id] = chk[0]
+		}
+	}
+	ebal := make(map[model.PKEY]int)
+	for _, e := range c.envelopes {
+		if chk, ok := c.eChk[e.id][bcdate.Epoch()]; ok {
+			ebal[e.id] = chk[0]
+		}
+	}
+
+	ats := append([]chkAT(nil), c.ats...)
+	sort.Slice(ats, func(i, j int) bool { return ats[i].postDate < ats[j].postDate })
+	ets := append([]chkET(nil), c.ets...)
+	// Split shares move their envelope like an e_t on the transaction's date
+	byID := make(map[model.PKEY]chkAT, len(c.ats))
+	for _, at := range c.ats {
+		byID[at.id] = at
+	}
+	for _, sp := range c.splits {
+		if at, ok := byID[sp.transactionID]; ok && sp.envelopeID.Valid {
+			ets = append(ets, chkET{id: sp.id, envelopeID: model.PKEY(sp.envelopeID.Int32), postDate: at.postDate, amount: sp.amount})
+		}
+	}
+	sort.Slice(ets, func(i, j int) bool { return ets[i].postDate < ets[j].postDate })
+
+	ai, ei := 0, 0
+	for _, m := range sortedMonths(months) {
+		for ; ai < len(ats) && month(ats[ai].postDate) <= m; ai++ {
+			at := ats[ai]
+			abal[at.accountID] += at.amount
+			if at.envelopeID.Valid {
+				ebal[model.PKEY(at.envelopeID.Int32)] += at.amount
+			}
+		}
+		for ; ei < len(ets) && month(ets[ei].postDate) <= m; ei++ {
+			ebal[ets[ei].envelopeID] += ets[ei].amount
+		}
+
+		var float, banked, nw int
+		for _, a := range c.accounts {
+			nw += abal[a.id]
+			if !a.offbudget {
+				banked += abal[a.id]
+				if !a.debt {
+					float += abal[a.id]
+				}
+			}
+		}
+		for _, e := range c.envelopes {
+			float -= ebal[e.id]
+		}
+
+		f := flows[m]
+		if f == nil {
+			f = make([]int, 3)
+		}
+
+		got, ok := c.sChk[m]
+		if !ok {
+			c.report("checkpoint", "s_chk", monthKey(m), "", present, missing)
+			continue
+		}
+		c.compareRow("checkpoint", "s_chk", monthKey(m), sChkCols, []int{float, f[0], f[1], f[2], banked, nw}, got)
+	}
+}
+
+func (c *checker) compareRow(check, table, key string, cols []string, expected, actual []int) {
+	for i, col := range cols {
+		if expected[i] != actual[i] {
+			c.report(check, table, key, col, strconv.Itoa(expected[i]), strconv.Itoa(actual[i]))
+		}
+	}
+}
+
+func idKey(id model.PKEY) string {
+	return "ID=" + strconv.Itoa(int(id))
+}
+
+func chkKey(keyName string, id model.PKEY, m bcdate.BCDate) string {
+	return fmt.Sprintf("%s=%d,month=%d", keyName, id, m)
+}
+
+func monthKey(m bcdate.BCDate) string {
+	return fmt.Sprintf("month=%d", m)
+}
+
+func sortedMonths(ms map[bcdate.BCDate]bool) []bcdate.BCDate {
+	ret := make([]bcdate.BCDate, 0, len(ms))
+	for m := range ms {
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+func sortedKeys[V any](m map[model.PKEY]V) []model.PKEY {
+	ret := make([]model.PKEY, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Closing an account keeps its history and stops it at the close date
+// The balance has to be zero by then, or is moved out by a final transfer dated the close date
+// Nothing can be entered into a closed account after the close date, its schedules end there
+// A closed debt account's envelope hands what it still holds back to the float on the close date and is hidden
+
+var (
+	ErrInvalidClose  = errors.New("invalid account close")
+	ErrAccountClosed = errors.New("account is closed")
+)
+
+// Memo of the final transfer CloseAccount makes
+const CloseMemo = "Closing balance"
+
+type Closing struct {
+	AccountID model.PKEY
+	Date      bcdate.BCDate
+	// Where a balance left on the account goes, closing fails without one
+	TransferTo sql.NullInt32
+	// Envelope of the final transfer, see Transfer
+	EnvelopeID sql.NullInt32
+}
+
+func validateClosing(c Closing) error {
+	if c.Date%100 == 0 {
+		return fmt.Errorf("%w: close date %d is not a date", ErrInvalidClose, c.Date)
+	}
+	if c.TransferTo.Valid && model.PKEY(c.TransferTo.Int32) == c.AccountID {
+		return fmt.Errorf("%w: account %d cannot transfer to itself", ErrInvalidClose, c.AccountID)
+	}
+	return nil
+}
+
+// The transfer moving bal out of the account being closed
+func closingTransfer(c Closing, bal int) model.Transfer {
+	t := model.Transfer{FromAccountID: c.AccountID, ToAccountID: model.PKEY(c.TransferTo.Int32), EnvelopeID: c.EnvelopeID, PostDate: c.Date, Amount: bal, Memo: CloseMemo}
+	if bal < 0 {
+		t.FromAccountID, t.ToAccountID, t.Amount = t.ToAccountID, t.FromAccountID, -bal
+	}
+	return t
+}
+
+// Nothing goes into a closed account after its close date
+func checkOpen(closed sql.NullInt32, aid model.PKEY, date bcdate.BCDate) error {
+	if closed.Valid && date > bcdate.BCDate(closed.Int32) {
+		return fmt.Errorf("%w: account %d closed on %d", ErrAccountClosed, aid, closed.Int32)
+	}
+	return nil
+}
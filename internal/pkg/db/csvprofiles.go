@@ -0,0 +1,29 @@
+package db
+
+import "budgeting/internal/pkg/model"
+
+// CSV import profiles, stored as given, csvimport says what makes one usable
+
+// Same columns in the same order for every profile read, see scanCSVProfile
+const csvProfileSelect = "SELECT ID, name, delimiter, skipRows, header, dateColumn, dateFormat, amountColumn, debitColumn, creditColumn, negate, decimalComma, memoColumn, clearedColumn, clearedValue, idColumn FROM csv_profile"
+
+func scanCSVProfile(row interface{ Scan(...any) error }, cp *model.CSVProfile) error {
+	return row.Scan(
+		&cp.ID,
+		&cp.Name,
+		&cp.Delimiter,
+		&cp.SkipRows,
+		&cp.Header,
+		&cp.DateColumn,
+		&cp.DateFormat,
+		&cp.AmountColumn,
+		&cp.DebitColumn,
+		&cp.CreditColumn,
+		&cp.Negate,
+		&cp.DecimalComma,
+		&cp.MemoColumn,
+		&cp.ClearedColumn,
+		&cp.ClearedValue,
+		&cp.IDColumn,
+	)
+}
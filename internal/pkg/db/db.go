@@ -3,6 +3,8 @@ package db
 import (
 	"budgeting/internal/pkg/bcdate"
 	"budgeting/internal/pkg/model"
+	"strings"
+	"time"
 )
 
 // Interface wrapping various DB drivers with our Models
@@ -11,6 +13,13 @@ type DB interface {
 	Open(string) error
 	Init() error
 	Run(fname string) error
+	Close() error
+
+	// Connect without migrating, Open is Connect followed by Migrate
+	Connect(string) error
+	SchemaVersion() (int, error)
+	GetMigrations() ([]Migration, error)
+	Migrate() ([]Migration, error)
 
 	GetAccounts() ([]model.Account, error)
 	GetAccount(id model.PKEY) (model.Account, error)
@@ -18,6 +27,10 @@ type DB interface {
 	UpdateAccount(model.Account) error
 	DeleteAccount(id model.PKEY) error
 
+	// Closed accounts keep their history, see Closing
+	CloseAccount(c Closing) error
+	ReopenAccount(id model.PKEY) error
+
 	GetStartingBalance(id model.PKEY) (int, error)
 	SetStartingBalance(id model.PKEY, balance int) error
 
@@ -36,16 +49,77 @@ type DB interface {
 	UpdateEnvelope(model.Envelope) error
 	DeleteEnvelope(id model.PKEY) error
 
+	GetPayees() ([]model.Payee, error)
+	GetPayee(id model.PKEY) (model.Payee, error)
+	GetPayeeByName(name string) (model.Payee, error)
+	NewPayee(*model.Payee) error
+	UpdatePayee(model.Payee) error
+	DeletePayee(id model.PKEY) error
+
+	// Rules run in Batch_NewAccountTransaction, PreviewRules shows what they would do to a batch without writing it
+	GetRules() ([]model.Rule, error)
+	GetRule(id model.PKEY) (model.Rule, error)
+	NewRule(*model.Rule) error
+	UpdateRule(model.Rule) error
+	DeleteRule(id model.PKEY) error
+	PreviewRules(ats []model.AccountTransaction) ([]RuleMatch, error)
+
+	// Schedules enter their occurrences into the account as RunSchedules finds them due, cmd/server runs it on a timer
+	GetSchedules() ([]model.Schedule, error)
+	GetAccountSchedules(id model.PKEY) ([]model.Schedule, error)
+	GetSchedule(id model.PKEY) (model.Schedule, error)
+	NewSchedule(*model.Schedule) error
+	UpdateSchedule(model.Schedule) error
+	DeleteSchedule(id model.PKEY) error
+	// Pass over the next occurrence without entering it, returns its date
+	SkipSchedule(id model.PKEY) (bcdate.BCDate, error)
+	// Enter every occurrence due on or before today, returns the new transactions
+	// A schedule that cannot be entered is left out and returned with why, the others still go in
+	RunSchedules(today bcdate.BCDate) ([]model.AccountTransaction, []ScheduleFailure, error)
+
+	// Profiles for csvimport, names are unique
+	GetCSVProfiles() ([]model.CSVProfile, error)
+	GetCSVProfile(id model.PKEY) (model.CSVProfile, error)
+	GetCSVProfileByName(name string) (model.CSVProfile, error)
+	NewCSVProfile(*model.CSVProfile) error
+	UpdateCSVProfile(model.CSVProfile) error
+	DeleteCSVProfile(id model.PKEY) error
+
 	GetAllTransactions(month bcdate.BCDate) ([]model.AccountTransaction, error)
 
 	GetAllAccountTransactions(id model.PKEY) ([]model.AccountTransaction, error)
 	GetAccountTransactions(month bcdate.BCDate, id model.PKEY) ([]model.AccountTransaction, error)
+	GetAccountTransaction(id model.PKEY) (model.AccountTransaction, error)
 	NewAccountTransaction(*model.AccountTransaction) error
 	UpdateAccountTransaction(model.AccountTransaction) error
 	DeleteAccountTransaction(id model.PKEY) error
+	// Deletes and enters again on another account in one DB transaction, see ErrInvalidMove
+	MoveAccountTransaction(*model.AccountTransaction) error
+
+	// Reconciled transactions are locked, see Reconciliation
+	// SetReconciled locks or unlocks one transaction by hand, locking needs it cleared
+	Reconcile(r Reconciliation) (ReconcileResult, error)
+	SetReconciled(id model.PKEY, reconciled bool) error
+
+	// Bulk inserts in one DB transaction, Batch_NewAccountTransaction also runs the rules over each row
+	// and refuses likely duplicates unless told to allow them, see ErrDuplicate
+	Batch_NewAccountTransaction(ats []model.AccountTransaction, allowDuplicates bool) error
+	Batch_NewEnvelopeTransaction(ets []model.EnvelopeTransaction) error
+	GetImportIDs(id model.PKEY) (map[string]bool, error)
+
+	// Transactions that look like the same money entered twice, see Duplicate
+	FindDuplicates(ats []model.AccountTransaction) ([]Duplicate, error)
+	GetDuplicates() ([]Duplicate, error)
+
+	// Transfers keep their legs in sync, the legs are also readable and editable as account transactions
+	GetTransfer(id model.PKEY) (model.Transfer, error)
+	NewTransfer(*model.Transfer) error
+	UpdateTransfer(model.Transfer) error
+	DeleteTransfer(id model.PKEY) error
 
 	GetAllEnvelopeTransactions(id model.PKEY) ([]model.EnvelopeTransaction, error)
 	GetEnvelopeTransactions(month bcdate.BCDate, id model.PKEY) ([]model.EnvelopeTransaction, error)
+	GetEnvelopeTransaction(id model.PKEY) (model.EnvelopeTransaction, error)
 	NewEnvelopeTransaction(*model.EnvelopeTransaction) error
 	UpdateEnvelopeTransaction(model.EnvelopeTransaction) error
 	DeleteEnvelopeTransaction(id model.PKEY) error
@@ -53,4 +127,50 @@ type DB interface {
 	GetAccountSummary(month bcdate.BCDate, id model.PKEY) (model.AccountSummary, error)
 	GetEnvelopeSummary(month bcdate.BCDate, id model.PKEY) (model.EnvelopeSummary, error)
 	GetOverallSummary(month bcdate.BCDate) (model.Summary, error)
+
+	Check() ([]Violation, error)
+	Rebuild(dryRun bool) ([]CheckpointChange, error)
+
+	// Copy the live DB to dest, which must not exist yet, see ErrBackupUnsupported
+	Backup(dest string) error
+
+	// Every call above that writes is logged as one AuditOp with the rows it changed, see audit.go
+	// WithActor shares the connection and puts the writes made through it down to actor
+	WithActor(actor string) DB
+	// Latest n ops first
+	GetHistory(n int) ([]AuditOp, error)
+	GetAuditChanges(id model.PKEY) ([]AuditChange, error)
+	// Revert the latest n ops not undone yet and recompute their checkpoints, returns the ops reverted
+	Undo(n int) ([]AuditOp, error)
+
+	// Users and tokens for middleware/auth, hashed by the caller and not audited
+	GetUsers() ([]model.User, error)
+	GetUser(id model.PKEY) (model.User, error)
+	GetUserByName(name string) (model.User, error)
+	NewUser(*model.User) error
+	// Changing the hash deletes their sessions
+	UpdateUser(model.User) error
+	// Deletes their tokens too
+	DeleteUser(id model.PKEY) error
+	GetTokens(userID model.PKEY) ([]model.Token, error)
+	GetTokenByHash(hash string) (model.Token, error)
+	NewToken(*model.Token) error
+	DeleteToken(id model.PKEY) error
+
+	// Sessions for middleware/session, only UpdateSession's lastSeen and qm change after NewSession
+	GetSessions(userID model.PKEY) ([]model.Session, error)
+	GetSessionByHash(hash string) (model.Session, error)
+	NewSession(*model.Session) error
+	UpdateSession(model.Session) error
+	DeleteSession(id model.PKEY) error
+	// Delete the sessions idle since seenBefore or started before createdBefore, returns how many
+	PruneSessions(seenBefore, createdBefore time.Time) (int, error)
+}
+
+// Pick a driver from the DB name: postgres:// URLs go to Postgres, anything else is a SQLite file
+func NewFor(dbname string) DB {
+	if strings.HasPrefix(dbname, "postgres://") || strings.HasPrefix(dbname, "postgresql://") {
+		return NewPostgres()
+	}
+	return NewSQLite()
 }
@@ -0,0 +1,1821 @@
+package db_test
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Behavioural tests shared by every driver
+// Postgres runs only when BUDGETING_TEST_POSTGRES holds a DSN for a throwaway database, as Init drops every table
+
+func forEachDriver(t *testing.T, test func(t *testing.T, d db.DB)) {
+	t.Run("SQLite", func(t *testing.T) {
+		d := db.NewSQLite()
+		if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+			t.Fatalf("Open: %s", err)
+		}
+		if err := d.Init(); err != nil {
+			t.Fatalf("Init: %s", err)
+		}
+		test(t, d)
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		dsn := os.Getenv("BUDGETING_TEST_POSTGRES")
+		if dsn == "" {
+			t.Skip("BUDGETING_TEST_POSTGRES not set")
+		}
+		d := db.NewPostgres()
+		if err := d.Open(dsn); err != nil {
+			t.Fatalf("Open: %s", err)
+		}
+		if err := d.Init(); err != nil {
+			t.Fatalf("Init: %s", err)
+		}
+		test(t, d)
+	})
+}
+
+// Months used by the tests, kept at or before the current month as that is where checkpoints stop
+var (
+	m2 = bcdate.CurrentMonth()
+	m1 = m2.PrevMonth()
+	m0 = m1.PrevMonth()
+)
+
+func nullID(id model.PKEY) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(id), Valid: true}
+}
+
+func mustAccount(t *testing.T, d db.DB, a model.Account) model.Account {
+	t.Helper()
+	if err := d.NewAccount(&a); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	return a
+}
+
+func mustEnvelope(t *testing.T, d db.DB, e model.Envelope) model.Envelope {
+	t.Helper()
+	if e.GroupID == 0 {
+		e.GroupID = 1
+	}
+	if err := d.NewEnvelope(&e); err != nil {
+		t.Fatalf("NewEnvelope: %s", err)
+	}
+	return e
+}
+
+func mustAT(t *testing.T, d db.DB, at model.AccountTransaction) model.AccountTransaction {
+	t.Helper()
+	if err := d.NewAccountTransaction(&at); err != nil {
+		t.Fatalf("NewAccountTransaction: %s", err)
+	}
+	return at
+}
+
+func accountSummary(t *testing.T, d db.DB, month bcdate.BCDate, id model.PKEY) model.AccountSummary {
+	t.Helper()
+	s, err := d.GetAccountSummary(month, id)
+	if err != nil {
+		t.Fatalf("GetAccountSummary: %s", err)
+	}
+	return s
+}
+
+func envelopeSummary(t *testing.T, d db.DB, month bcdate.BCDate, id model.PKEY) model.EnvelopeSummary {
+	t.Helper()
+	s, err := d.GetEnvelopeSummary(month, id)
+	if err != nil {
+		t.Fatalf("GetEnvelopeSummary: %s", err)
+	}
+	return s
+}
+
+func overallSummary(t *testing.T, d db.DB, month bcdate.BCDate) model.Summary {
+	t.Helper()
+	s, err := d.GetOverallSummary(month)
+	if err != nil {
+		t.Fatalf("GetOverallSummary: %s", err)
+	}
+	return s
+}
+
+func TestAccounts(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking", Class: model.AT_CHECKING})
+		mustAccount(t, d, model.Account{Institution: "Bank", Name: "Brokerage", Offbudget: true, Class: model.AT_INVESTMENT})
+
+		accts, err := d.GetAccounts()
+		if err != nil {
+			t.Fatalf("GetAccounts: %s", err)
+		}
+		if len(accts) != 2 || accts[0].Name != "Brokerage" || accts[1].Name != "Checking" {
+			t.Fatalf("GetAccounts returned %v", accts)
+		}
+
+		chk.Name = "Main"
+		chk.Hidden = true
+		if err := d.UpdateAccount(chk); err != nil {
+			t.Fatalf("UpdateAccount: %s", err)
+		}
+		got, err := d.GetAccount(chk.ID)
+		if err != nil {
+			t.Fatalf("GetAccount: %s", err)
+		}
+		if got != chk {
+			t.Fatalf("GetAccount returned %v, want %v", got, chk)
+		}
+
+		if err := d.SetStartingBalance(chk.ID, 10000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+		sbal, err := d.GetStartingBalance(chk.ID)
+		if err != nil || sbal != 10000 {
+			t.Fatalf("GetStartingBalance returned %d, %v", sbal, err)
+		}
+
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 5, Amount: -2500, Cleared: true})
+		if s := accountSummary(t, d, m2, chk.ID); s.Bal != 7500 {
+			t.Fatalf("Balance after starting balance and transaction = %d, want 7500", s.Bal)
+		}
+		if s := overallSummary(t, d, m2); s.Float != 7500 || s.Banked != 7500 || s.NetWorth != 7500 {
+			t.Fatalf("Overall summary = %+v", s)
+		}
+	})
+}
+
+func TestDebtAccounts(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		cc := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card", Debt: true, Class: model.AT_CREDITCARD})
+
+		e, err := d.GetDebtEnvelopeFor(cc.ID)
+		if err != nil {
+			t.Fatalf("GetDebtEnvelopeFor: %s", err)
+		}
+		if e.Name != cc.DebtEnvelopeName() || !e.DebtAccount.Valid || model.PKEY(e.DebtAccount.Int32) != cc.ID {
+			t.Fatalf("Debt envelope = %v", e)
+		}
+
+		cc.Name = "Travel Card"
+		if err := d.UpdateAccount(cc); err != nil {
+			t.Fatalf("UpdateAccount: %s", err)
+		}
+		if e, err = d.GetDebtEnvelopeFor(cc.ID); err != nil || e.Name != cc.DebtEnvelopeName() {
+			t.Fatalf("Debt envelope after rename = %v, %v", e, err)
+		}
+
+		cc.Debt = false
+		if err := d.UpdateAccount(cc); err != nil {
+			t.Fatalf("UpdateAccount: %s", err)
+		}
+		if _, err := d.GetDebtEnvelopeFor(cc.ID); err == nil {
+			t.Fatal("Debt envelope still exists after clearing the debt flag")
+		}
+	})
+}
+
+func TestEnvelopeGroups(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		eg := model.EnvelopeGroup{Name: "Bills", Sort: 10}
+		if err := d.NewEnvelopeGroup(&eg); err != nil {
+			t.Fatalf("NewEnvelopeGroup: %s", err)
+		}
+
+		eg.Name = "Monthly Bills"
+		if err := d.UpdateEnvelopeGroup(eg); err != nil {
+			t.Fatalf("UpdateEnvelopeGroup: %s", err)
+		}
+		if got, err := d.GetEnvelopeGroup(eg.ID); err != nil || got != eg {
+			t.Fatalf("GetEnvelopeGroup returned %v, %v", got, err)
+		}
+
+		egs, err := d.GetEnvelopeGroups()
+		if err != nil || len(egs) != 2 || egs[0] != eg {
+			t.Fatalf("GetEnvelopeGroups returned %v, %v", egs, err)
+		}
+
+		rent := mustEnvelope(t, d, model.Envelope{GroupID: eg.ID, Name: "Rent"})
+		if es, err := d.GetEnvelopesInGroup(eg.ID); err != nil || len(es) != 1 || es[0].ID != rent.ID {
+			t.Fatalf("GetEnvelopesInGroup returned %v, %v", es, err)
+		}
+
+		if err := d.DeleteEnvelopeGroup(eg.ID); err != nil {
+			t.Fatalf("DeleteEnvelopeGroup: %s", err)
+		}
+		if got, err := d.GetEnvelope(rent.ID); err != nil || got.GroupID != 1 {
+			t.Fatalf("Envelope after group delete = %v, %v", got, err)
+		}
+	})
+}
+
+func TestEnvelopes(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		e := mustEnvelope(t, d, model.Envelope{Name: "Groceries", Goal: model.GT_RECUR, GoalAmt: 40000})
+
+		e.Notes = "Food only"
+		e.GoalAmt = 50000
+		if err := d.UpdateEnvelope(e); err != nil {
+			t.Fatalf("UpdateEnvelope: %s", err)
+		}
+		if got, err := d.GetEnvelope(e.ID); err != nil || got != e {
+			t.Fatalf("GetEnvelope returned %v, %v", got, err)
+		}
+		if es, err := d.GetEnvelopes(); err != nil || len(es) != 1 {
+			t.Fatalf("GetEnvelopes returned %v, %v", es, err)
+		}
+		if s := envelopeSummary(t, d, m2, e.ID); s.Bal != 0 || s.Month != bcdate.Epoch() {
+			t.Fatalf("New envelope summary = %v", s)
+		}
+	})
+}
+
+func TestTransactionCheckpoints(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: m0 + 1, Amount: 100000, Cleared: true})
+
+		et := model.EnvelopeTransaction{EnvelopeID: food.ID, PostDate: m0 + 2, Amount: 30000}
+		if err := d.NewEnvelopeTransaction(&et); err != nil {
+			t.Fatalf("NewEnvelopeTransaction: %s", err)
+		}
+
+		groc := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, EnvelopeID: nullID(food.ID), PostDate: m1 + 3, Amount: -12000})
+
+		if s := accountSummary(t, d, m0, chk.ID); s.Bal != 100000 || s.In != 100000 || s.Out != 0 {
+			t.Fatalf("m0 account summary = %v", s)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != 88000 || s.Out != -12000 || s.Uncleared != -12000 {
+			t.Fatalf("m1 account summary = %v", s)
+		}
+		if s := envelopeSummary(t, d, m1, food.ID); s.Bal != 18000 || s.Out != -12000 || s.In != 0 {
+			t.Fatalf("m1 envelope summary = %v", s)
+		}
+		if s := overallSummary(t, d, m0); s.Float != 70000 || s.Income != 100000 || s.Delta != 100000 {
+			t.Fatalf("m0 overall summary = %+v", s)
+		}
+		if s := overallSummary(t, d, m1); s.Float != 70000 || s.Expenses != -12000 || s.Banked != 88000 {
+			t.Fatalf("m1 overall summary = %+v", s)
+		}
+
+		// Amount change in the same envelope must still flow into its checkpoints
+		groc.Amount = -15000
+		groc.Cleared = true
+		if err := d.UpdateAccountTransaction(groc); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+		if s := envelopeSummary(t, d, m2, food.ID); s.Bal != 15000 {
+			t.Fatalf("Envelope balance after amount change = %d, want 15000", s.Bal)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != 85000 || s.Uncleared != 0 {
+			t.Fatalf("Account summary after amount change = %v", s)
+		}
+
+		// Moving it out of the envelope restores the envelope and leaves the account alone
+		groc.EnvelopeID = sql.NullInt32{}
+		if err := d.UpdateAccountTransaction(groc); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+		if s := envelopeSummary(t, d, m2, food.ID); s.Bal != 30000 {
+			t.Fatalf("Envelope balance after unassigning = %d, want 30000", s.Bal)
+		}
+
+		ats, err := d.GetAccountTransactions(m1, chk.ID)
+		if err != nil || len(ats) != 1 || !reflect.DeepEqual(ats[0], groc) {
+			t.Fatalf("GetAccountTransactions returned %v, %v", ats, err)
+		}
+		if ats, err := d.GetAllAccountTransactions(chk.ID); err != nil || len(ats) != 2 {
+			t.Fatalf("GetAllAccountTransactions returned %v, %v", ats, err)
+		}
+		if ats, err := d.GetAllTransactions(m0); err != nil || len(ats) != 1 {
+			t.Fatalf("GetAllTransactions returned %v, %v", ats, err)
+		}
+
+		if err := d.DeleteAccountTransaction(groc.ID); err != nil {
+			t.Fatalf("DeleteAccountTransaction: %s", err)
+		}
+		if s := accountSummary(t, d, m2, chk.ID); s.Bal != 100000 {
+			t.Fatalf("Account balance after delete = %d, want 100000", s.Bal)
+		}
+
+		et.Amount = 40000
+		if err := d.UpdateEnvelopeTransaction(et); err != nil {
+			t.Fatalf("UpdateEnvelopeTransaction: %s", err)
+		}
+		if ets, err := d.GetEnvelopeTransactions(m0, food.ID); err != nil || len(ets) != 1 || ets[0] != et {
+			t.Fatalf("GetEnvelopeTransactions returned %v, %v", ets, err)
+		}
+		if s := overallSummary(t, d, m2); s.Float != 60000 {
+			t.Fatalf("Float after envelope update = %d, want 60000", s.Float)
+		}
+
+		if err := d.DeleteEnvelopeTransaction(et.ID); err != nil {
+			t.Fatalf("DeleteEnvelopeTransaction: %s", err)
+		}
+		if ets, err := d.GetAllEnvelopeTransactions(food.ID); err != nil || len(ets) != 0 {
+			t.Fatalf("GetAllEnvelopeTransactions returned %v, %v", ets, err)
+		}
+		if s := overallSummary(t, d, m2); s.Float != 100000 {
+			t.Fatalf("Float after envelope delete = %d, want 100000", s.Float)
+		}
+	})
+}
+
+func TestDeletes(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		cc := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card", Debt: true})
+		fun := mustEnvelope(t, d, model.Envelope{Name: "Fun"})
+
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: m1 + 1, Amount: 50000})
+		mustAT(t, d, model.AccountTransaction{AccountID: cc.ID, EnvelopeID: nullID(fun.ID), PostDate: m1 + 2, Amount: -2000})
+
+		if s := envelopeSummary(t, d, m2, fun.ID); s.Bal != -2000 {
+			t.Fatalf("Envelope balance = %d, want -2000", s.Bal)
+		}
+
+		if err := d.DeleteAccount(cc.ID); err != nil {
+			t.Fatalf("DeleteAccount: %s", err)
+		}
+		if _, err := d.GetAccount(cc.ID); err == nil {
+			t.Fatal("Account still exists after delete")
+		}
+		if s := envelopeSummary(t, d, m2, fun.ID); s.Bal != 0 {
+			t.Fatalf("Envelope balance after account delete = %d, want 0", s.Bal)
+		}
+		if s := overallSummary(t, d, m2); s.NetWorth != 50000 || s.Float != 50000 {
+			t.Fatalf("Overall summary after account delete = %+v", s)
+		}
+
+		at := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, EnvelopeID: nullID(fun.ID), PostDate: m1 + 3, Amount: -1000})
+		if err := d.DeleteEnvelope(fun.ID); err != nil {
+			t.Fatalf("DeleteEnvelope: %s", err)
+		}
+		ats, err := d.GetAccountTransactions(m1, chk.ID)
+		if err != nil {
+			t.Fatalf("GetAccountTransactions: %s", err)
+		}
+		for _, got := range ats {
+			if got.ID == at.ID && got.EnvelopeID.Valid {
+				t.Fatal("Transaction still references the deleted envelope")
+			}
+		}
+		if s := overallSummary(t, d, m2); s.Float != 49000 {
+			t.Fatalf("Float after envelope delete = %d, want 49000", s.Float)
+		}
+	})
+}
+
+func TestMoveTransaction(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		sav := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Savings"})
+		cc := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card", Debt: true})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: m0 + 1, Amount: 50000})
+		at := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, EnvelopeID: nullID(food.ID), PostDate: m1 + 5, Amount: -3000, Memo: "Groceries"})
+
+		at.AccountID = sav.ID
+		if err := d.UpdateAccountTransaction(at); !errors.Is(err, db.ErrInvalidMove) {
+			t.Fatalf("UpdateAccountTransaction to another account = %v, want ErrInvalidMove", err)
+		}
+
+		oldID := at.ID
+		if err := d.MoveAccountTransaction(&at); err != nil {
+			t.Fatalf("MoveAccountTransaction: %s", err)
+		}
+		if at.ID == oldID {
+			t.Fatal("Moved transaction kept its ID")
+		}
+		if _, err := d.GetAccountTransaction(oldID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetAccountTransaction(old) = %v, want ErrNoRows", err)
+		}
+		if got, err := d.GetAccountTransaction(at.ID); err != nil || got.AccountID != sav.ID || got.Memo != "Groceries" {
+			t.Fatalf("GetAccountTransaction(new) = %+v, %v", got, err)
+		}
+		if s := accountSummary(t, d, m2, chk.ID); s.Bal != 50000 {
+			t.Fatalf("Checking balance after the move = %d, want 50000", s.Bal)
+		}
+		if s := accountSummary(t, d, m2, sav.ID); s.Bal != -3000 {
+			t.Fatalf("Savings balance after the move = %d, want -3000", s.Bal)
+		}
+		if s := envelopeSummary(t, d, m2, food.ID); s.Bal != -3000 {
+			t.Fatalf("Envelope balance after the move = %d, want -3000", s.Bal)
+		}
+
+		// Once more, onto a debt account
+		at.AccountID = cc.ID
+		if err := d.MoveAccountTransaction(&at); err != nil {
+			t.Fatalf("MoveAccountTransaction to the card: %s", err)
+		}
+		if s := accountSummary(t, d, m2, sav.ID); s.Bal != 0 {
+			t.Fatalf("Savings balance after moving out = %d, want 0", s.Bal)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check after the moves = %v, %v", vs, err)
+		}
+
+		if err := d.MoveAccountTransaction(&at); !errors.Is(err, db.ErrInvalidMove) {
+			t.Fatalf("Moving onto the same account = %v, want ErrInvalidMove", err)
+		}
+
+		x := mustTransfer(t, d, model.Transfer{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: m1 + 6, Amount: 1000})
+		leg, err := d.GetAccountTransaction(x.ToID)
+		if err != nil {
+			t.Fatalf("GetAccountTransaction(leg): %s", err)
+		}
+		leg.AccountID = cc.ID
+		if err := d.MoveAccountTransaction(&leg); !errors.Is(err, db.ErrInvalidTransfer) {
+			t.Fatalf("Moving a transfer leg = %v, want ErrInvalidTransfer", err)
+		}
+
+		locked := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 7, Amount: -100, Cleared: true})
+		if err := d.SetReconciled(locked.ID, true); err != nil {
+			t.Fatalf("SetReconciled: %s", err)
+		}
+		locked.AccountID = sav.ID
+		if err := d.MoveAccountTransaction(&locked); !errors.Is(err, db.ErrReconciled) {
+			t.Fatalf("Moving a reconciled transaction = %v, want ErrReconciled", err)
+		}
+	})
+}
+
+func runScript(t *testing.T, d db.DB, query string) {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "script.sql")
+	if err := os.WriteFile(fname, []byte(query), 0o644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	if err := d.Run(fname); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+}
+
+func TestSplits(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+		home := mustEnvelope(t, d, model.Envelope{Name: "Household"})
+		meds := mustEnvelope(t, d, model.Envelope{Name: "Pharmacy"})
+
+		split := func(eid model.PKEY, amount int) model.Split {
+			return model.Split{EnvelopeID: nullID(eid), Amount: amount}
+		}
+
+		// One receipt over three envelopes
+		receipt := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 4, Amount: -10000, Memo: "Store", Splits: []model.Split{
+			split(food.ID, -6000),
+			split(home.ID, -2500),
+			split(meds.ID, -1500),
+		}})
+
+		if s := envelopeSummary(t, d, m1, food.ID); s.Bal != -6000 || s.Out != -6000 {
+			t.Fatalf("Food summary = %v", s)
+		}
+		if s := envelopeSummary(t, d, m2, home.ID); s.Bal != -2500 {
+			t.Fatalf("Household summary = %v", s)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != -10000 || s.Out != -10000 {
+			t.Fatalf("Account summary = %v", s)
+		}
+		if s := overallSummary(t, d, m1); s.Float != 0 || s.Expenses != -10000 {
+			t.Fatalf("Overall summary = %+v", s)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check with splits = %v, %v", vs, err)
+		}
+
+		got, err := d.GetAccountTransaction(receipt.ID)
+		if err != nil || !reflect.DeepEqual(got, receipt) {
+			t.Fatalf("GetAccountTransaction = %+v, %v, want %+v", got, err, receipt)
+		}
+		if ats, err := d.GetAccountTransactions(m1, chk.ID); err != nil || len(ats) != 1 || len(ats[0].Splits) != 3 {
+			t.Fatalf("GetAccountTransactions = %+v, %v", ats, err)
+		}
+
+		// Rework the shares: Pharmacy drops out, so its checkpoints go back to zero
+		receipt.Splits = []model.Split{split(food.ID, -7000), split(home.ID, -3000)}
+		if err := d.UpdateAccountTransaction(receipt); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+		if s := envelopeSummary(t, d, m2, meds.ID); s.Bal != 0 {
+			t.Fatalf("Pharmacy balance after update = %d, want 0", s.Bal)
+		}
+		if s := envelopeSummary(t, d, m2, food.ID); s.Bal != -7000 {
+			t.Fatalf("Food balance after update = %d, want -7000", s.Bal)
+		}
+
+		// Collapsing back to one envelope clears the splits
+		receipt.Splits = nil
+		receipt.EnvelopeID = nullID(meds.ID)
+		if err := d.UpdateAccountTransaction(receipt); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+		if s := envelopeSummary(t, d, m2, food.ID); s.Bal != 0 {
+			t.Fatalf("Food balance after unsplitting = %d, want 0", s.Bal)
+		}
+		if got, err := d.GetAccountTransaction(receipt.ID); err != nil || got.IsSplit() || got.EnvelopeID != nullID(meds.ID) {
+			t.Fatalf("GetAccountTransaction after unsplitting = %+v, %v", got, err)
+		}
+
+		bad := []model.AccountTransaction{
+			{AccountID: chk.ID, PostDate: m1 + 5, Amount: -100, Splits: []model.Split{split(food.ID, -60), split(home.ID, -30)}},
+			{AccountID: chk.ID, PostDate: m1 + 5, Amount: -100, Splits: []model.Split{split(food.ID, -100)}},
+			{AccountID: chk.ID, PostDate: m1 + 5, Amount: -100, EnvelopeID: nullID(food.ID), Splits: []model.Split{split(food.ID, -60), split(home.ID, -40)}},
+		}
+		for _, at := range bad {
+			if err := d.NewAccountTransaction(&at); !errors.Is(err, db.ErrInvalidSplit) {
+				t.Fatalf("NewAccountTransaction(%+v) = %v, want ErrInvalidSplit", at, err)
+			}
+		}
+
+		// A deleted envelope leaves its share unassigned rather than breaking the sum
+		other := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m2 + 1, Amount: -900, Splits: []model.Split{
+			split(food.ID, -400),
+			{Amount: -500, Memo: "Not sure yet"},
+		}})
+		if err := d.DeleteEnvelope(food.ID); err != nil {
+			t.Fatalf("DeleteEnvelope: %s", err)
+		}
+		if got, err := d.GetAccountTransaction(other.ID); err != nil || len(got.Splits) != 2 || got.Splits[0].EnvelopeID.Valid {
+			t.Fatalf("Split after DeleteEnvelope = %+v, %v", got, err)
+		}
+
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+
+		if err := d.DeleteAccountTransaction(other.ID); err != nil {
+			t.Fatalf("DeleteAccountTransaction: %s", err)
+		}
+		if err := d.DeleteAccount(chk.ID); err != nil {
+			t.Fatalf("DeleteAccount: %s", err)
+		}
+		if s := envelopeSummary(t, d, m2, meds.ID); s.Bal != 0 {
+			t.Fatalf("Pharmacy balance after DeleteAccount = %d, want 0", s.Bal)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check after deletes = %v, %v", vs, err)
+		}
+	})
+}
+
+func TestPayees(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+		fun := mustEnvelope(t, d, model.Envelope{Name: "Fun"})
+
+		grocer := model.Payee{Name: "Corner Grocer", EnvelopeID: nullID(food.ID)}
+		if err := d.NewPayee(&grocer); err != nil {
+			t.Fatalf("NewPayee: %s", err)
+		}
+		employer := model.Payee{Name: "Employer"}
+		if err := d.NewPayee(&employer); err != nil {
+			t.Fatalf("NewPayee: %s", err)
+		}
+		if err := d.NewPayee(&model.Payee{Name: "Corner Grocer"}); err == nil {
+			t.Fatalf("NewPayee with a taken name succeeded")
+		}
+
+		if got, err := d.GetPayee(grocer.ID); err != nil || got != grocer {
+			t.Fatalf("GetPayee = %+v, %v, want %+v", got, err, grocer)
+		}
+		if got, err := d.GetPayeeByName("Employer"); err != nil || got != employer {
+			t.Fatalf("GetPayeeByName = %+v, %v, want %+v", got, err, employer)
+		}
+		if ps, err := d.GetPayees(); err != nil || len(ps) != 2 || ps[0] != grocer {
+			t.Fatalf("GetPayees = %+v, %v", ps, err)
+		}
+
+		// Spending without an envelope takes the payee's, anything given or not spending is left alone
+		shop := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PayeeID: nullID(grocer.ID), PostDate: m1 + 2, Amount: -4000})
+		if shop.EnvelopeID != nullID(food.ID) {
+			t.Fatalf("Envelope from payee = %v, want %d", shop.EnvelopeID, food.ID)
+		}
+		if s := envelopeSummary(t, d, m1, food.ID); s.Out != -4000 {
+			t.Fatalf("Food out = %d, want -4000", s.Out)
+		}
+		treat := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PayeeID: nullID(grocer.ID), EnvelopeID: nullID(fun.ID), PostDate: m1 + 3, Amount: -500})
+		if treat.EnvelopeID != nullID(fun.ID) {
+			t.Fatalf("Given envelope replaced by %v", treat.EnvelopeID)
+		}
+		refund := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PayeeID: nullID(grocer.ID), Typ: model.TT_INCOME, PostDate: m1 + 4, Amount: 300})
+		if refund.EnvelopeID.Valid {
+			t.Fatalf("Income picked up envelope %v", refund.EnvelopeID)
+		}
+
+		if got, err := d.GetAccountTransaction(shop.ID); err != nil || got.PayeeID != nullID(grocer.ID) {
+			t.Fatalf("GetAccountTransaction = %+v, %v", got, err)
+		}
+
+		// Deleting the envelope leaves the payee without a default
+		if err := d.DeleteEnvelope(food.ID); err != nil {
+			t.Fatalf("DeleteEnvelope: %s", err)
+		}
+		if got, err := d.GetPayee(grocer.ID); err != nil || got.EnvelopeID.Valid {
+			t.Fatalf("Payee after DeleteEnvelope = %+v, %v", got, err)
+		}
+
+		grocer.Name, grocer.EnvelopeID = "Grocer", nullID(fun.ID)
+		if err := d.UpdatePayee(grocer); err != nil {
+			t.Fatalf("UpdatePayee: %s", err)
+		}
+		if got, err := d.GetPayee(grocer.ID); err != nil || got != grocer {
+			t.Fatalf("GetPayee after update = %+v, %v", got, err)
+		}
+
+		if err := d.DeletePayee(grocer.ID); err != nil {
+			t.Fatalf("DeletePayee: %s", err)
+		}
+		if got, err := d.GetAccountTransaction(treat.ID); err != nil || got.PayeeID.Valid || got.EnvelopeID != nullID(fun.ID) {
+			t.Fatalf("Transaction after DeletePayee = %+v, %v", got, err)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+	})
+}
+
+func mustRule(t *testing.T, d db.DB, r model.Rule) model.Rule {
+	t.Helper()
+	if err := d.NewRule(&r); err != nil {
+		t.Fatalf("NewRule: %s", err)
+	}
+	return r
+}
+
+func TestRules(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		card := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card"})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+		fun := mustEnvelope(t, d, model.Envelope{Name: "Fun"})
+
+		grocer := model.Payee{Name: "Grocer", EnvelopeID: nullID(fun.ID)}
+		if err := d.NewPayee(&grocer); err != nil {
+			t.Fatalf("NewPayee: %s", err)
+		}
+
+		// Priority decides the order, so the memo is already rewritten when the card rule looks at it
+		tidy := mustRule(t, d, model.Rule{Priority: 1, Name: "Tidy", MemoPattern: `^POS (\w+) \d+`, Memo: sql.NullString{String: "$1", Valid: true}})
+		groceries := mustRule(t, d, model.Rule{Priority: 2, Name: "Groceries", MemoPattern: `^Grocer$`, MaxAmount: sql.NullInt64{Int64: -1, Valid: true}, EnvelopeID: nullID(food.ID)})
+		cardDay := mustRule(t, d, model.Rule{Priority: 3, Name: "Card", AccountID: nullID(card.ID), FromDate: sql.NullInt32{Int32: int32(m1), Valid: true}, ToDate: sql.NullInt32{Int32: int32(m1 + 31), Valid: true}, Cleared: sql.NullBool{Bool: true, Valid: true}})
+		salary := mustRule(t, d, model.Rule{Name: "Salary", MemoPattern: `(?i)payroll`, MinAmount: sql.NullInt64{Int64: 1, Valid: true}, Typ: sql.NullInt32{Int32: int32(model.TT_INCOME), Valid: true}})
+
+		if rs, err := d.GetRules(); err != nil || len(rs) != 4 || rs[0] != salary || rs[1] != tidy || rs[3] != cardDay {
+			t.Fatalf("GetRules = %+v, %v", rs, err)
+		}
+		if got, err := d.GetRule(groceries.ID); err != nil || got != groceries {
+			t.Fatalf("GetRule = %+v, %v, want %+v", got, err, groceries)
+		}
+
+		for _, bad := range []model.Rule{
+			{Memo: sql.NullString{Valid: true}},
+			{Name: "Nothing"},
+			{Name: "Regex", MemoPattern: "(", Cleared: sql.NullBool{Valid: true}},
+			{Name: "Amounts", MinAmount: sql.NullInt64{Int64: 5, Valid: true}, MaxAmount: sql.NullInt64{Int64: 1, Valid: true}, Cleared: sql.NullBool{Valid: true}},
+			{Name: "Transfer", Typ: sql.NullInt32{Int32: int32(model.TT_TRANSFER), Valid: true}},
+		} {
+			if err := d.NewRule(&bad); !errors.Is(err, db.ErrInvalidRule) {
+				t.Fatalf("NewRule(%+v) = %v, want ErrInvalidRule", bad, err)
+			}
+		}
+
+		batch := []model.AccountTransaction{
+			{AccountID: chk.ID, PostDate: m1 + 2, Amount: -4000, Memo: "POS Grocer 1234", PayeeID: nullID(grocer.ID)},
+			{AccountID: card.ID, PostDate: m1 + 3, Amount: -500, Memo: "POS Grocer 99"},
+			{AccountID: chk.ID, PostDate: m1 + 4, Amount: 300000, Memo: "ACME PAYROLL"},
+			{AccountID: chk.ID, PostDate: m1 + 5, Amount: -700, Memo: "Kiosk", PayeeID: nullID(grocer.ID)},
+			{AccountID: card.ID, PostDate: m2 + 1, Amount: -800, Memo: "Kiosk"},
+		}
+
+		ms, err := d.PreviewRules(batch)
+		if err != nil {
+			t.Fatalf("PreviewRules: %s", err)
+		}
+		fired := make(map[int][]model.PKEY)
+		for _, m := range ms {
+			fired[m.Index] = m.Rules
+		}
+		want := map[int][]model.PKEY{
+			0: {tidy.ID, groceries.ID},
+			1: {tidy.ID, groceries.ID, cardDay.ID},
+			2: {salary.ID},
+		}
+		if !reflect.DeepEqual(fired, want) {
+			t.Fatalf("PreviewRules fired %v, want %v", fired, want)
+		}
+		if ms[0].After.Memo != "Grocer" || ms[0].Before.Memo != "POS Grocer 1234" || batch[0].Memo != "POS Grocer 1234" {
+			t.Fatalf("Preview memo %q from %q, batch %q", ms[0].After.Memo, ms[0].Before.Memo, batch[0].Memo)
+		}
+		if n := len(accountTransactions(t, d, chk.ID)); n != 0 {
+			t.Fatalf("PreviewRules wrote %d transactions", n)
+		}
+
+		if err := d.Batch_NewAccountTransaction(batch, false); err != nil {
+			t.Fatalf("Batch_NewAccountTransaction: %s", err)
+		}
+
+		got := append(accountTransactions(t, d, chk.ID), accountTransactions(t, d, card.ID)...)
+		if len(got) != 5 {
+			t.Fatalf("Inserted %d transactions, want 5", len(got))
+		}
+		byMemo := make(map[string]model.AccountTransaction)
+		for _, at := range got {
+			byMemo[fmt.Sprintf("%d/%s", at.AccountID, at.Memo)] = at
+		}
+
+		// A rule's envelope beats the payee's, the payee still fills in where no rule set one
+		if at := byMemo[fmt.Sprintf("%d/Grocer", chk.ID)]; at.EnvelopeID != nullID(food.ID) || at.Cleared {
+			t.Fatalf("Checking grocer = %+v", at)
+		}
+		if at := byMemo[fmt.Sprintf("%d/Grocer", card.ID)]; at.EnvelopeID != nullID(food.ID) || !at.Cleared {
+			t.Fatalf("Card grocer = %+v", at)
+		}
+		if at := byMemo[fmt.Sprintf("%d/ACME PAYROLL", chk.ID)]; at.Typ != model.TT_INCOME || at.EnvelopeID.Valid {
+			t.Fatalf("Payroll = %+v", at)
+		}
+		if at := byMemo[fmt.Sprintf("%d/Kiosk", chk.ID)]; at.EnvelopeID != nullID(fun.ID) {
+			t.Fatalf("Kiosk with payee = %+v", at)
+		}
+		if at := byMemo[fmt.Sprintf("%d/Kiosk", card.ID)]; at.Cleared || at.EnvelopeID.Valid {
+			t.Fatalf("Card kiosk after the date window = %+v", at)
+		}
+		if s := envelopeSummary(t, d, m1, food.ID); s.Out != -4500 {
+			t.Fatalf("Food out = %d, want -4500", s.Out)
+		}
+
+		// Hand entered transactions are left as given
+		hand := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 6, Amount: -100, Memo: "Grocer"})
+		if hand.EnvelopeID.Valid {
+			t.Fatalf("NewAccountTransaction ran the rules, envelope %v", hand.EnvelopeID)
+		}
+
+		groceries.MemoPattern, groceries.Priority = "", 10
+		if err := d.UpdateRule(groceries); err != nil {
+			t.Fatalf("UpdateRule: %s", err)
+		}
+		if got, err := d.GetRule(groceries.ID); err != nil || got != groceries {
+			t.Fatalf("GetRule after update = %+v, %v", got, err)
+		}
+
+		// Deleting what a rule points at leaves no dangling references
+		if err := d.DeleteEnvelope(food.ID); err != nil {
+			t.Fatalf("DeleteEnvelope: %s", err)
+		}
+		if got, err := d.GetRule(groceries.ID); err != nil || got.EnvelopeID.Valid {
+			t.Fatalf("Rule after DeleteEnvelope = %+v, %v", got, err)
+		}
+		if err := d.DeleteAccount(card.ID); err != nil {
+			t.Fatalf("DeleteAccount: %s", err)
+		}
+		if _, err := d.GetRule(cardDay.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("Rule for deleted account = %v, want ErrNoRows", err)
+		}
+
+		if err := d.DeleteRule(tidy.ID); err != nil {
+			t.Fatalf("DeleteRule: %s", err)
+		}
+		if rs, err := d.GetRules(); err != nil || len(rs) != 2 {
+			t.Fatalf("GetRules after deletes = %+v, %v", rs, err)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+	})
+}
+
+func TestScheduleRecurrence(t *testing.T) {
+	end := sql.NullInt32{Int32: 20240301, Valid: true}
+	for _, c := range []struct {
+		s     model.Schedule
+		after bcdate.BCDate
+		want  bcdate.BCDate
+	}{
+		{model.Schedule{Kind: model.SK_MONTHLY, N: 15, Start: 20240120}, bcdate.Epoch(), 20240215},
+		{model.Schedule{Kind: model.SK_MONTHLY, N: 31, Start: 20240101}, 20240131, 20240229},
+		{model.Schedule{Kind: model.SK_MONTHLY, N: 31, Start: 20240101}, 20240229, 20240331},
+		{model.Schedule{Kind: model.SK_MONTHLY, N: 31, Start: 20240101}, 20241231, 20250131},
+		{model.Schedule{Kind: model.SK_MONTHLY, N: 1, Start: 20240101, End: end}, 20240201, 20240301},
+		{model.Schedule{Kind: model.SK_MONTHLY, N: 1, Start: 20240101, End: end}, 20240301, bcdate.Never()},
+		{model.Schedule{Kind: model.SK_WEEKLY, N: 2, Start: 20240103}, bcdate.Epoch(), 20240103},
+		{model.Schedule{Kind: model.SK_WEEKLY, N: 2, Start: 20240103}, 20240103, 20240117},
+		{model.Schedule{Kind: model.SK_WEEKLY, N: 2, Start: 20240103}, 20240110, 20240117},
+		{model.Schedule{Kind: model.SK_WEEKLY, N: 1, Start: 20241225}, 20241231, 20250101},
+		{model.Schedule{Kind: model.SK_YEARLY, Start: 20200229}, 20230301, 20240229},
+		{model.Schedule{Kind: model.SK_YEARLY, Start: 20200229}, 20240229, 20250228},
+		{model.Schedule{Kind: model.SK_LASTBUSINESS, Start: 20240301}, bcdate.Epoch(), 20240329},
+		{model.Schedule{Kind: model.SK_LASTBUSINESS, Start: 20240301}, 20240329, 20240430},
+		{model.Schedule{Kind: model.SK_LASTBUSINESS, Start: 20240301}, 20240815, 20240830},
+	} {
+		if got := c.s.NextAfter(c.after); got != c.want {
+			t.Errorf("%s NextAfter(%d) = %d, want %d", c.s.Recurrence(), c.after, got, c.want)
+		}
+	}
+}
+
+func mustSchedule(t *testing.T, d db.DB, s model.Schedule) model.Schedule {
+	t.Helper()
+	if err := d.NewSchedule(&s); err != nil {
+		t.Fatalf("NewSchedule: %s", err)
+	}
+	return s
+}
+
+func TestSchedules(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		card := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card"})
+		rent := mustEnvelope(t, d, model.Envelope{Name: "Rent"})
+		fun := mustEnvelope(t, d, model.Envelope{Name: "Fun"})
+
+		stream := model.Payee{Name: "Stream", EnvelopeID: nullID(fun.ID)}
+		if err := d.NewPayee(&stream); err != nil {
+			t.Fatalf("NewPayee: %s", err)
+		}
+
+		monthly := mustSchedule(t, d, model.Schedule{AccountID: chk.ID, EnvelopeID: nullID(rent.ID), Amount: -120000, Memo: "Rent", Kind: model.SK_MONTHLY, N: 5, Start: m0 + 1})
+		// The payee's envelope is picked up on the way in, as for anything entered by hand
+		weekly := mustSchedule(t, d, model.Schedule{AccountID: card.ID, PayeeID: nullID(stream.ID), Amount: -999, Memo: "Stream", Kind: model.SK_WEEKLY, N: 4, Start: m1 + 1})
+		// Ended before anything came due
+		mustSchedule(t, d, model.Schedule{AccountID: chk.ID, Amount: 100, Kind: model.SK_YEARLY, Start: m0 + 1, End: sql.NullInt32{Int32: int32(m0 + 1), Valid: true}, Last: m0 + 1})
+
+		if ss, err := d.GetAccountSchedules(chk.ID); err != nil || len(ss) != 2 || ss[0] != monthly {
+			t.Fatalf("GetAccountSchedules = %+v, %v", ss, err)
+		}
+		if got, err := d.GetSchedule(weekly.ID); err != nil || got != weekly {
+			t.Fatalf("GetSchedule = %+v, %v, want %+v", got, err, weekly)
+		}
+
+		for _, bad := range []model.Schedule{
+			{AccountID: chk.ID, Kind: model.SK_MONTHLY, N: 1, Start: m0 + 1},
+			{AccountID: chk.ID, Amount: 1, Kind: model.SK_MONTHLY, N: 32, Start: m0 + 1},
+			{AccountID: chk.ID, Amount: 1, Kind: model.SK_WEEKLY, Start: m0 + 1},
+			{AccountID: chk.ID, Amount: 1, Kind: model.SK_YEARLY, Start: m0},
+			{AccountID: chk.ID, Amount: 1, Kind: model.SK_YEARLY, Start: m1 + 1, End: sql.NullInt32{Int32: int32(m0 + 1), Valid: true}},
+			{AccountID: chk.ID, Amount: 1, Kind: model.SK_YEARLY, Start: m0 + 1, Typ: model.TT_TRANSFER},
+		} {
+			if err := d.NewSchedule(&bad); !errors.Is(err, db.ErrInvalidSchedule) {
+				t.Fatalf("NewSchedule(%+v) = %v, want ErrInvalidSchedule", bad, err)
+			}
+		}
+
+		// Rent on the 5th of m0 and m1, the stream on the 1st of m1 and again four weeks on, after today
+		today := m1 + 28
+		ats, failed, err := d.RunSchedules(today)
+		if err != nil || len(failed) != 0 {
+			t.Fatalf("RunSchedules: %v, %v", failed, err)
+		}
+		if len(ats) != 3 {
+			t.Fatalf("RunSchedules entered %d, want 3: %+v", len(ats), ats)
+		}
+		got := accountTransactions(t, d, chk.ID)
+		if len(got) != 2 || got[0].PostDate != m1+5 || got[1].PostDate != m0+5 || got[0].EnvelopeID != nullID(rent.ID) || got[0].Cleared {
+			t.Fatalf("Checking = %+v", got)
+		}
+		if got := accountTransactions(t, d, card.ID); len(got) != 1 || got[0].PostDate != m1+1 || got[0].EnvelopeID != nullID(fun.ID) || got[0].PayeeID != nullID(stream.ID) {
+			t.Fatalf("Card = %+v", got)
+		}
+		if s := envelopeSummary(t, d, m1, rent.ID); s.Out != -120000 || s.Bal != -240000 {
+			t.Fatalf("Rent summary = %+v", s)
+		}
+		if s := accountSummary(t, d, m1, card.ID); s.Bal != -999 || s.Uncleared != -999 {
+			t.Fatalf("Card summary = %+v", s)
+		}
+
+		// Entered once only
+		if ats, _, err := d.RunSchedules(today); err != nil || len(ats) != 0 {
+			t.Fatalf("RunSchedules again = %+v, %v", ats, err)
+		}
+
+		weekly, _ = d.GetSchedule(weekly.ID)
+		next := weekly.Next()
+		if next != (m1 + 1).AddDays(28) {
+			t.Fatalf("Next = %d, want %d", next, (m1 + 1).AddDays(28))
+		}
+		if skipped, err := d.SkipSchedule(weekly.ID); err != nil || skipped != next {
+			t.Fatalf("SkipSchedule = %d, %v, want %d", skipped, err, next)
+		}
+		if ats, _, err := d.RunSchedules(next); err != nil || len(ats) != 0 {
+			t.Fatalf("RunSchedules over a skipped occurrence = %+v, %v", ats, err)
+		}
+
+		// Changing the recurrence keeps what was entered and skipped
+		monthly.N = 20
+		if err := d.UpdateSchedule(monthly); err != nil {
+			t.Fatalf("UpdateSchedule: %s", err)
+		}
+		if got, err := d.GetSchedule(monthly.ID); err != nil || got.Last != m1+5 || got.Next() != m1+20 {
+			t.Fatalf("GetSchedule after update = %+v, %v", got, err)
+		}
+
+		ended, err := d.GetAccountSchedules(chk.ID)
+		if err != nil {
+			t.Fatalf("GetAccountSchedules: %s", err)
+		}
+		if _, err := d.SkipSchedule(ended[1].ID); !errors.Is(err, db.ErrInvalidSchedule) {
+			t.Fatalf("SkipSchedule past the end = %v, want ErrInvalidSchedule", err)
+		}
+
+		// Deleting what a schedule points at leaves no dangling references
+		if err := d.DeletePayee(stream.ID); err != nil {
+			t.Fatalf("DeletePayee: %s", err)
+		}
+		if err := d.DeleteEnvelope(rent.ID); err != nil {
+			t.Fatalf("DeleteEnvelope: %s", err)
+		}
+		if got, err := d.GetSchedule(monthly.ID); err != nil || got.EnvelopeID.Valid {
+			t.Fatalf("Schedule after DeleteEnvelope = %+v, %v", got, err)
+		}
+		if err := d.DeleteAccount(card.ID); err != nil {
+			t.Fatalf("DeleteAccount: %s", err)
+		}
+		if _, err := d.GetSchedule(weekly.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("Schedule for deleted account = %v, want ErrNoRows", err)
+		}
+
+		if err := d.DeleteSchedule(monthly.ID); err != nil {
+			t.Fatalf("DeleteSchedule: %s", err)
+		}
+		if ss, err := d.GetSchedules(); err != nil || len(ss) != 1 {
+			t.Fatalf("GetSchedules after deletes = %+v, %v", ss, err)
+		}
+		if n := len(accountTransactions(t, d, chk.ID)); n != 2 {
+			t.Fatalf("Checking holds %d transactions after DeleteSchedule, want 2", n)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+	})
+}
+
+// A schedule on an account closed behind its back fails alone, the good one next to it still goes in
+func TestRunSchedulesSkipsFailures(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		old := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Old card"})
+		bad := mustSchedule(t, d, model.Schedule{AccountID: old.ID, Amount: -500, Memo: "Gym", Kind: model.SK_MONTHLY, N: 3, Start: m1 + 1})
+		good := mustSchedule(t, d, model.Schedule{AccountID: chk.ID, Amount: -1000, Memo: "Phone", Kind: model.SK_MONTHLY, N: 4, Start: m1 + 1})
+		runScript(t, d, fmt.Sprintf("UPDATE a SET closed = %d WHERE ID = %d;", m0+1, old.ID))
+
+		today := m1 + 10
+		ats, failed, err := d.RunSchedules(today)
+		if err != nil {
+			t.Fatalf("RunSchedules: %s", err)
+		}
+		if len(ats) != 1 || ats[0].AccountID != chk.ID || ats[0].ID == 0 {
+			t.Fatalf("RunSchedules entered %+v, want the phone bill", ats)
+		}
+		if len(failed) != 1 || failed[0].ScheduleID != bad.ID || !errors.Is(failed[0].Err, db.ErrAccountClosed) {
+			t.Fatalf("RunSchedules failed = %+v, want the gym on the closed card", failed)
+		}
+
+		if got, err := d.GetSchedule(good.ID); err != nil || got.Last != m1+4 {
+			t.Fatalf("Good schedule after the run = %+v, %v", got, err)
+		}
+		if got, err := d.GetSchedule(bad.ID); err != nil || got.Last != bcdate.Epoch() {
+			t.Fatalf("Failed schedule after the run = %+v, %v", got, err)
+		}
+		if n := len(accountTransactions(t, d, old.ID)); n != 0 {
+			t.Fatalf("Closed card holds %d transactions, want 0", n)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != -1000 {
+			t.Fatalf("Checking summary = %+v", s)
+		}
+
+		// Still failing next time, and still only that one
+		if ats, failed, err := d.RunSchedules(today); err != nil || len(ats) != 0 || len(failed) != 1 {
+			t.Fatalf("RunSchedules again = %+v, %+v, %v", ats, failed, err)
+		}
+	})
+}
+
+func accountTransactions(t *testing.T, d db.DB, id model.PKEY) []model.AccountTransaction {
+	t.Helper()
+	ats, err := d.GetAllAccountTransactions(id)
+	if err != nil {
+		t.Fatalf("GetAllAccountTransactions: %s", err)
+	}
+	return ats
+}
+
+func mustTransfer(t *testing.T, d db.DB, x model.Transfer) model.Transfer {
+	t.Helper()
+	if err := d.NewTransfer(&x); err != nil {
+		t.Fatalf("NewTransfer: %s", err)
+	}
+	return x
+}
+
+func TestTransfers(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		sav := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Savings"})
+		card := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card", Debt: true})
+		stocks := mustAccount(t, d, model.Account{Institution: "Broker", Name: "Stocks", Offbudget: true})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+		if err := d.SetStartingBalance(chk.ID, 100000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+
+		// Between two budget accounts: no envelope, and the float stays put
+		xfer := mustTransfer(t, d, model.Transfer{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: m1 + 3, Amount: 20000, Memo: "Saving"})
+		if s := overallSummary(t, d, m1); s.Float != 100000 {
+			t.Fatalf("Float after transfer = %d, want 100000", s.Float)
+		}
+		if got, err := d.GetTransfer(xfer.ID); err != nil || got != xfer {
+			t.Fatalf("GetTransfer = %+v, %v, want %+v", got, err, xfer)
+		}
+		ats, err := d.GetAccountTransactions(m1, chk.ID)
+		if err != nil || len(ats) != 1 {
+			t.Fatalf("GetAccountTransactions = %+v, %v", ats, err)
+		}
+		if at := ats[0]; at.Typ != model.TT_TRANSFER || at.Amount != -20000 || at.CounterAccount != nullID(sav.ID) || at.TransferID != nullID(xfer.ID) {
+			t.Fatalf("From leg = %+v", at)
+		}
+
+		// Editing one leg carries date and amount over, cleared stays per leg
+		leg, err := d.GetAccountTransaction(xfer.ToID)
+		if err != nil {
+			t.Fatalf("GetAccountTransaction: %s", err)
+		}
+		leg.Amount, leg.PostDate, leg.Cleared = 25000, m2+1, true
+		if err := d.UpdateAccountTransaction(leg); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+		if from, err := d.GetAccountTransaction(xfer.FromID); err != nil || from.Amount != -25000 || from.PostDate != m2+1 || from.Cleared {
+			t.Fatalf("From leg after editing the to leg = %+v, %v", from, err)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != 100000 {
+			t.Fatalf("Checking balance in the old month = %d, want 100000", s.Bal)
+		}
+		if s := accountSummary(t, d, m2, sav.ID); s.Bal != 25000 {
+			t.Fatalf("Savings balance = %d, want 25000", s.Bal)
+		}
+
+		// Paying down debt spends from the debt envelope unless told otherwise
+		debt, err := d.GetDebtEnvelopeFor(card.ID)
+		if err != nil {
+			t.Fatalf("GetDebtEnvelopeFor: %s", err)
+		}
+		pay := mustTransfer(t, d, model.Transfer{FromAccountID: chk.ID, ToAccountID: card.ID, PostDate: m2 + 2, Amount: 5000})
+		if pay.EnvelopeID != nullID(debt.ID) {
+			t.Fatalf("Card payment envelope = %v, want %d", pay.EnvelopeID, debt.ID)
+		}
+		if s := envelopeSummary(t, d, m2, debt.ID); s.Out != -5000 {
+			t.Fatalf("Debt envelope out = %d, want -5000", s.Out)
+		}
+
+		// Editing the To leg moves the From leg's envelope too
+		leg, err = d.GetAccountTransaction(pay.ToID)
+		if err != nil {
+			t.Fatalf("GetAccountTransaction: %s", err)
+		}
+		leg.Amount, leg.PostDate = 7000, m1+2
+		if err := d.UpdateAccountTransaction(leg); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+		if s := envelopeSummary(t, d, m1, debt.ID); s.Out != -7000 {
+			t.Fatalf("Debt envelope out after editing the to leg = %d, want -7000", s.Out)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check after editing the to leg = %v, %v", vs, err)
+		}
+		leg.Amount, leg.PostDate = 5000, m2+2
+		if err := d.UpdateAccountTransaction(leg); err != nil {
+			t.Fatalf("UpdateAccountTransaction: %s", err)
+		}
+
+		// Leaving the budget needs an envelope, anything else takes none
+		bad := []model.Transfer{
+			{FromAccountID: chk.ID, ToAccountID: stocks.ID, PostDate: m2 + 3, Amount: 1000},
+			{FromAccountID: chk.ID, ToAccountID: sav.ID, EnvelopeID: nullID(food.ID), PostDate: m2 + 3, Amount: 1000},
+			{FromAccountID: stocks.ID, ToAccountID: chk.ID, EnvelopeID: nullID(food.ID), PostDate: m2 + 3, Amount: 1000},
+			{FromAccountID: chk.ID, ToAccountID: chk.ID, PostDate: m2 + 3, Amount: 1000},
+			{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: m2 + 3, Amount: -1000},
+		}
+		for _, x := range bad {
+			if err := d.NewTransfer(&x); !errors.Is(err, db.ErrInvalidTransfer) {
+				t.Fatalf("NewTransfer(%+v) = %v, want ErrInvalidTransfer", x, err)
+			}
+		}
+		invest := mustTransfer(t, d, model.Transfer{FromAccountID: chk.ID, ToAccountID: stocks.ID, EnvelopeID: nullID(food.ID), PostDate: m2 + 3, Amount: 1000})
+		if s := envelopeSummary(t, d, m2, food.ID); s.Out != -1000 {
+			t.Fatalf("Food out = %d, want -1000", s.Out)
+		}
+
+		// Legs keep the shape of their transfer
+		leg, err = d.GetAccountTransaction(invest.FromID)
+		if err != nil {
+			t.Fatalf("GetAccountTransaction: %s", err)
+		}
+		leg.Amount = 1000
+		if err := d.UpdateAccountTransaction(leg); !errors.Is(err, db.ErrInvalidTransfer) {
+			t.Fatalf("Flipping the from leg = %v, want ErrInvalidTransfer", err)
+		}
+		leg.Amount, leg.EnvelopeID = -1000, sql.NullInt32{}
+		if err := d.UpdateAccountTransaction(leg); !errors.Is(err, db.ErrInvalidTransfer) {
+			t.Fatalf("Dropping the envelope leaving the budget = %v, want ErrInvalidTransfer", err)
+		}
+
+		invest.Amount, invest.Memo = 1500, "Top up"
+		if err := d.UpdateTransfer(invest); err != nil {
+			t.Fatalf("UpdateTransfer: %s", err)
+		}
+		if to, err := d.GetAccountTransaction(invest.ToID); err != nil || to.Amount != 1500 || to.Memo != "Top up" {
+			t.Fatalf("To leg after UpdateTransfer = %+v, %v", to, err)
+		}
+		invest.ToAccountID = sav.ID
+		if err := d.UpdateTransfer(invest); !errors.Is(err, db.ErrInvalidTransfer) {
+			t.Fatalf("Moving a transfer to another account = %v, want ErrInvalidTransfer", err)
+		}
+
+		// Every transfer so far either stayed in the float or was spent from an envelope
+		if s := overallSummary(t, d, m2); s.Float != 100000 {
+			t.Fatalf("Float = %d, want 100000", s.Float)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+
+		// Deleting either leg deletes the transfer
+		if err := d.DeleteAccountTransaction(pay.ToID); err != nil {
+			t.Fatalf("DeleteAccountTransaction: %s", err)
+		}
+		if _, err := d.GetAccountTransaction(pay.FromID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("From leg after deleting the to leg = %v, want sql.ErrNoRows", err)
+		}
+		if _, err := d.GetTransfer(pay.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetTransfer after delete = %v, want sql.ErrNoRows", err)
+		}
+		if s := envelopeSummary(t, d, m2, debt.ID); s.Out != 0 {
+			t.Fatalf("Debt envelope out after delete = %d, want 0", s.Out)
+		}
+		if err := d.DeleteTransfer(invest.ID); err != nil {
+			t.Fatalf("DeleteTransfer: %s", err)
+		}
+
+		// Deleting an account unlinks its transfers, the other legs stay
+		if err := d.DeleteAccount(sav.ID); err != nil {
+			t.Fatalf("DeleteAccount: %s", err)
+		}
+		if from, err := d.GetAccountTransaction(xfer.FromID); err != nil || from.TransferID.Valid || from.Amount != -25000 {
+			t.Fatalf("From leg after DeleteAccount = %+v, %v", from, err)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check after deletes = %v, %v", vs, err)
+		}
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	dbname := filepath.Join(t.TempDir(), "test.db")
+
+	d := db.NewSQLite()
+	if err := d.Open(dbname); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	ms, err := d.GetMigrations()
+	if err != nil {
+		t.Fatalf("GetMigrations: %s", err)
+	}
+	for _, m := range ms {
+		if m.Applied == "" {
+			t.Fatalf("Migration %04d_%s still pending after Open", m.Version, m.Name)
+		}
+	}
+	if v, err := d.SchemaVersion(); err != nil || v != len(ms) {
+		t.Fatalf("SchemaVersion = %d, %v, want %d", v, err, len(ms))
+	}
+
+	// A schema from before migrations existed gets baselined and brought up to date, keeping its data
+	legacy := db.NewSQLite()
+	if err := legacy.Connect(filepath.Join(t.TempDir(), "legacy.db")); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	if err := legacy.Run("migrations/sqlite3/0001_init.sql"); err != nil {
+		t.Fatalf("Run initial schema: %s", err)
+	}
+	runScript(t, legacy, "INSERT INTO a (institution,name) VALUES ('Bank','Checking'); INSERT INTO a_chk (accountID,month) VALUES (1,0);")
+	if v, err := legacy.SchemaVersion(); err != nil || v != 1 {
+		t.Fatalf("SchemaVersion of legacy db = %d, %v, want 1", v, err)
+	}
+	if done, err := legacy.Migrate(); err != nil || len(done) != len(ms)-1 {
+		t.Fatalf("Migrate legacy db applied %v, %v, want %d steps", done, err, len(ms)-1)
+	}
+	if _, err := legacy.GetAccount(1); err != nil {
+		t.Fatalf("GetAccount after baseline: %s", err)
+	}
+	if done, err := legacy.Migrate(); err != nil || len(done) != 0 {
+		t.Fatalf("Second Migrate applied %v, %v", done, err)
+	}
+
+	// A database touched by a newer binary is refused
+	runScript(t, d, "INSERT INTO schema_version (version,name,applied) VALUES (9999,'future','2100-01-01T00:00:00Z');")
+	d = db.NewSQLite()
+	if err := d.Open(dbname); !errors.Is(err, db.ErrSchemaTooNew) {
+		t.Fatalf("Open of newer db = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		cc := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card", Debt: true})
+		mustAccount(t, d, model.Account{Institution: "Broker", Name: "Stocks", Offbudget: true})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+		fun := mustEnvelope(t, d, model.Envelope{Name: "Fun"})
+
+		if err := d.SetStartingBalance(chk.ID, 10000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: m0 + 1, Amount: 50000, Cleared: true})
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, EnvelopeID: nullID(food.ID), PostDate: m1 + 5, Amount: -3000})
+		mustAT(t, d, model.AccountTransaction{AccountID: cc.ID, EnvelopeID: nullID(fun.ID), PostDate: m2 + 2, Amount: -1500})
+		if err := d.NewEnvelopeTransaction(&model.EnvelopeTransaction{EnvelopeID: food.ID, PostDate: m0 + 2, Amount: 20000}); err != nil {
+			t.Fatalf("NewEnvelopeTransaction: %s", err)
+		}
+
+		vs, err := d.Check()
+		if err != nil {
+			t.Fatalf("Check: %s", err)
+		}
+		if len(vs) != 0 {
+			t.Fatalf("Check on a consistent db = %v", vs)
+		}
+
+		runScript(t, d, fmt.Sprintf(`
+			UPDATE a_chk SET uncleared = uncleared + 5 WHERE accountID = %d AND month = %d;
+			DELETE FROM e_chk WHERE envelopeID = %d AND month = 0;
+			UPDATE a SET debt = %s WHERE ID = %d;`,
+			chk.ID, m1, fun.ID, falseFor(d), cc.ID))
+
+		vs, err = d.Check()
+		if err != nil {
+			t.Fatalf("Check: %s", err)
+		}
+
+		want := []db.Violation{
+			{Check: "checkpoint", Table: "a_chk", Key: fmt.Sprintf("accountID=%d,month=%d", chk.ID, m1), Column: "uncleared", Expected: "-3000", Actual: "-2995"},
+			{Check: "epoch", Table: "e_chk", Key: fmt.Sprintf("envelopeID=%d,month=0", fun.ID), Expected: "present", Actual: "missing"},
+			{Check: "debt envelope", Table: "e", Key: fmt.Sprintf("debtAccount=%d", cc.ID), Expected: "0 envelopes for a non-debt account", Actual: "1 envelopes"},
+		}
+		for _, w := range want {
+			found := false
+			for _, v := range vs {
+				if v == w {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Check did not report %s, got %v", w, vs)
+			}
+		}
+	})
+}
+
+// Raw scripts have to spell booleans the way each schema stores them
+func falseFor(d db.DB) string {
+	if _, ok := d.(*db.Postgres); ok {
+		return "FALSE"
+	}
+	return "0"
+}
+
+func TestRebuild(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+
+		if err := d.SetStartingBalance(chk.ID, 10000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: m0 + 1, Amount: 50000})
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, EnvelopeID: nullID(food.ID), PostDate: m1 + 5, Amount: -3000})
+		// Future dated, checkpoints run past the current month to cover it
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, EnvelopeID: nullID(food.ID), PostDate: m2.NextMonth() + 9, Amount: -700})
+		if err := d.NewEnvelopeTransaction(&model.EnvelopeTransaction{EnvelopeID: food.ID, PostDate: m0 + 2, Amount: 20000}); err != nil {
+			t.Fatalf("NewEnvelopeTransaction: %s", err)
+		}
+
+		if changes, err := d.Rebuild(true); err != nil || len(changes) != 0 {
+			t.Fatalf("Rebuild dry run on a consistent db = %v, %v", changes, err)
+		}
+
+		runScript(t, d, fmt.Sprintf(`
+			UPDATE a_chk SET bal = bal + 7 WHERE accountID = %d AND month = %d;
+			DELETE FROM e_chk WHERE envelopeID = %d AND month = %d;`,
+			chk.ID, m1, food.ID, m0))
+
+		changes, err := d.Rebuild(true)
+		if err != nil {
+			t.Fatalf("Rebuild dry run: %s", err)
+		}
+		want := []db.CheckpointChange{
+			{Table: "a_chk", Key: fmt.Sprintf("accountID=%d,month=%d", chk.ID, m1), Column: "bal", Before: "57007", After: "57000"},
+			{Table: "e_chk", Key: fmt.Sprintf("envelopeID=%d,month=%d", food.ID, m0), Column: "bal", Before: "missing", After: "20000"},
+		}
+		for _, w := range want {
+			found := false
+			for _, c := range changes {
+				if c == w {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Rebuild dry run did not report %s, got %v", w, changes)
+			}
+		}
+
+		// A dry run leaves the damage in place
+		if vs, err := d.Check(); err != nil || len(vs) == 0 {
+			t.Fatalf("Check after dry run = %v, %v", vs, err)
+		}
+
+		if _, err := d.Rebuild(false); err != nil {
+			t.Fatalf("Rebuild: %s", err)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check after rebuild = %v, %v", vs, err)
+		}
+		if sbal, err := d.GetStartingBalance(chk.ID); err != nil || sbal != 10000 {
+			t.Fatalf("Starting balance after rebuild = %d, %v", sbal, err)
+		}
+		if s := accountSummary(t, d, m2.NextMonth(), chk.ID); s.Bal != 56300 {
+			t.Fatalf("Future balance after rebuild = %d, want 56300", s.Bal)
+		}
+		if changes, err := d.Rebuild(true); err != nil || len(changes) != 0 {
+			t.Fatalf("Rebuild dry run after rebuild = %v, %v", changes, err)
+		}
+	})
+}
+
+func TestDuplicates(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		card := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card"})
+
+		grocer := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 2, Amount: -4567, Memo: "CORNER GROCER 0042"})
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 10, Amount: -1250, Memo: "Parking", ImportID: sql.NullString{String: "R-3", Valid: true}})
+
+		batch := []model.AccountTransaction{
+			{AccountID: chk.ID, PostDate: m1 + 3, Amount: -4567, Memo: "Corner Grocer"},
+			// The bank listed both, so they are two charges
+			{AccountID: chk.ID, PostDate: m1 + 10, Amount: -1250, Memo: "Parking", ImportID: sql.NullString{String: "R-4", Valid: true}},
+			// Too late, and the stored grocer charge is already taken by the first row
+			{AccountID: chk.ID, PostDate: m1 + 6, Amount: -4567, Memo: "Corner Grocer"},
+			{AccountID: card.ID, PostDate: m1 + 2, Amount: -4567, Memo: "CORNER GROCER 0042"},
+			{AccountID: chk.ID, PostDate: m1 + 20, Amount: -999, Memo: "Kiosk"},
+			{AccountID: chk.ID, PostDate: m1 + 20, Amount: -999, Memo: "Kiosk"},
+			// Same day and amount, but nothing in the memos in common
+			{AccountID: chk.ID, PostDate: m1 + 21, Amount: -450, Memo: "Blue Bottle"},
+			{AccountID: chk.ID, PostDate: m1 + 21, Amount: -450, Memo: "Starbucks"},
+		}
+		ds, err := d.FindDuplicates(batch)
+		if err != nil {
+			t.Fatalf("FindDuplicates: %s", err)
+		}
+		if len(ds) != 2 || ds[0].Index != 0 || ds[0].Match.ID != grocer.ID || ds[1].Index != 5 || ds[1].Match.ID != 0 || ds[1].Score != 1 {
+			t.Fatalf("FindDuplicates = %v", ds)
+		}
+
+		// The batch insert refuses them and writes nothing, unless told to let them in
+		if err := d.Batch_NewAccountTransaction(batch, false); !errors.Is(err, db.ErrDuplicate) {
+			t.Fatalf("Batch_NewAccountTransaction with duplicates = %v, want ErrDuplicate", err)
+		}
+		if ats := accountTransactions(t, d, card.ID); len(ats) != 0 {
+			t.Fatalf("Refused batch wrote %v", ats)
+		}
+		if err := d.Batch_NewAccountTransaction(batch[3:4], false); err != nil {
+			t.Fatalf("Batch_NewAccountTransaction without duplicates: %s", err)
+		}
+		if err := d.Batch_NewAccountTransaction(batch[4:], true); err != nil {
+			t.Fatalf("Batch_NewAccountTransaction allowing duplicates: %s", err)
+		}
+
+		again := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 4, Amount: -4567, Memo: "Corner Grocer"})
+		ds, err = d.GetDuplicates()
+		if err != nil {
+			t.Fatalf("GetDuplicates: %s", err)
+		}
+		// The grocer charge entered again, then the kiosk pair let in above
+		if len(ds) != 2 || ds[0].Index != -1 || ds[0].Transaction.ID != again.ID || ds[0].Match.ID != grocer.ID || ds[0].Score < db.DupeThreshold || ds[1].Score != 1 {
+			t.Fatalf("GetDuplicates = %v", ds)
+		}
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		sav := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Savings"})
+		if err := d.SetStartingBalance(chk.ID, 10000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+
+		rent := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 2, Amount: -2000, Cleared: true, Memo: "Rent"})
+		cafe := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 5, Amount: -500, Memo: "Cafe"})
+		late := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 20, Amount: -1000, Memo: "Late"})
+		xfer := mustTransfer(t, d, model.Transfer{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: m1 + 3, Amount: 1000})
+
+		// A statement that does not balance changes nothing
+		stmt := db.Reconciliation{AccountID: chk.ID, Date: m1 + 10, Balance: 6000, Cleared: []model.PKEY{cafe.ID, xfer.FromID}}
+		if res, err := d.Reconcile(stmt); !errors.Is(err, db.ErrReconcileMismatch) || res.Difference != -500 {
+			t.Fatalf("Reconcile off by 500 = %+v, %v, want ErrReconcileMismatch", res, err)
+		}
+		if at, err := d.GetAccountTransaction(cafe.ID); err != nil || at.Cleared {
+			t.Fatalf("Transaction after a failed reconcile = %+v, %v", at, err)
+		}
+		if _, err := d.Reconcile(db.Reconciliation{AccountID: chk.ID, Date: m1 + 10, Balance: 6500, Cleared: []model.PKEY{late.ID}}); !errors.Is(err, db.ErrInvalidReconcile) {
+			t.Fatalf("Reconcile past the statement date = %v, want ErrInvalidReconcile", err)
+		}
+
+		stmt.Balance = 6500
+		res, err := d.Reconcile(stmt)
+		if err != nil || res.ClearedBalance != 6500 || res.Difference != 0 || res.Adjustment.Valid || res.Reconciled != 3 {
+			t.Fatalf("Reconcile = %+v, %v", res, err)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Uncleared != -1000 {
+			t.Fatalf("Uncleared after reconcile = %d, want -1000", s.Uncleared)
+		}
+
+		// Locked: the memo can change, the money cannot
+		locked, err := d.GetAccountTransaction(rent.ID)
+		if err != nil || !locked.Reconciled {
+			t.Fatalf("GetAccountTransaction = %+v, %v", locked, err)
+		}
+		locked.Memo = "Rent, March"
+		if err := d.UpdateAccountTransaction(locked); err != nil {
+			t.Fatalf("UpdateAccountTransaction memo: %s", err)
+		}
+		locked.Amount = -2100
+		if err := d.UpdateAccountTransaction(locked); !errors.Is(err, db.ErrReconciled) {
+			t.Fatalf("UpdateAccountTransaction amount = %v, want ErrReconciled", err)
+		}
+		if err := d.DeleteAccountTransaction(rent.ID); !errors.Is(err, db.ErrReconciled) {
+			t.Fatalf("DeleteAccountTransaction = %v, want ErrReconciled", err)
+		}
+		// Either leg of a transfer holds the other one too
+		if err := d.DeleteAccountTransaction(xfer.ToID); !errors.Is(err, db.ErrReconciled) {
+			t.Fatalf("DeleteAccountTransaction of the other leg = %v, want ErrReconciled", err)
+		}
+		xfer.Amount = 1500
+		if err := d.UpdateTransfer(xfer); !errors.Is(err, db.ErrReconciled) {
+			t.Fatalf("UpdateTransfer = %v, want ErrReconciled", err)
+		}
+
+		// The difference goes in as an adjustment when asked
+		res, err = d.Reconcile(db.Reconciliation{AccountID: chk.ID, Date: m1 + 25, Balance: 5000, Cleared: []model.PKEY{late.ID}, Adjust: true})
+		if err != nil || res.ClearedBalance != 5500 || res.Difference != -500 || !res.Adjustment.Valid || res.Reconciled != 2 {
+			t.Fatalf("Reconcile with adjustment = %+v, %v", res, err)
+		}
+		adj, err := d.GetAccountTransaction(model.PKEY(res.Adjustment.Int32))
+		if err != nil || adj.Typ != model.TT_ADJUST || adj.Amount != -500 || !adj.Reconciled {
+			t.Fatalf("Adjustment = %+v, %v", adj, err)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != 5000 {
+			t.Fatalf("Balance after adjustment = %d, want 5000", s.Bal)
+		}
+
+		if err := d.SetReconciled(rent.ID, false); err != nil {
+			t.Fatalf("SetReconciled: %s", err)
+		}
+		if err := d.DeleteAccountTransaction(rent.ID); err != nil {
+			t.Fatalf("DeleteAccountTransaction after unlocking: %s", err)
+		}
+		open := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 26, Amount: -1})
+		if err := d.SetReconciled(open.ID, true); !errors.Is(err, db.ErrInvalidReconcile) {
+			t.Fatalf("SetReconciled of an uncleared transaction = %v, want ErrInvalidReconcile", err)
+		}
+
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+	})
+}
+
+func TestCloseAccount(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		sav := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Savings"})
+		card := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Card", Debt: true})
+		if err := d.SetStartingBalance(chk.ID, 10000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 2, Amount: -3000})
+		mustSchedule(t, d, model.Schedule{AccountID: chk.ID, Amount: -100, Kind: model.SK_MONTHLY, N: 1, Start: m0 + 1})
+		mustSchedule(t, d, model.Schedule{AccountID: chk.ID, Amount: -100, Kind: model.SK_MONTHLY, N: 1, Start: m2 + 1})
+
+		closing := db.Closing{AccountID: chk.ID, Date: m1 + 10}
+		if err := d.CloseAccount(closing); !errors.Is(err, db.ErrInvalidClose) {
+			t.Fatalf("CloseAccount with a balance = %v, want ErrInvalidClose", err)
+		}
+		late := mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 15, Amount: -100})
+		closing.TransferTo = nullID(sav.ID)
+		if err := d.CloseAccount(closing); !errors.Is(err, db.ErrInvalidClose) {
+			t.Fatalf("CloseAccount before later transactions = %v, want ErrInvalidClose", err)
+		}
+		if err := d.DeleteAccountTransaction(late.ID); err != nil {
+			t.Fatalf("DeleteAccountTransaction: %s", err)
+		}
+
+		// The balance moves out and the history stays
+		if err := d.CloseAccount(closing); err != nil {
+			t.Fatalf("CloseAccount: %s", err)
+		}
+		if s := accountSummary(t, d, m1, sav.ID); s.Bal != 7000 {
+			t.Fatalf("Savings after the closing transfer = %d, want 7000", s.Bal)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s.Bal != 0 || s.Out != -10000 {
+			t.Fatalf("Checking after closing = %+v", s)
+		}
+		a, err := d.GetAccount(chk.ID)
+		if err != nil || a.Closed != nullID(model.PKEY(m1+10)) || a.ClosedBefore(m1) || !a.ClosedBefore(m2) {
+			t.Fatalf("GetAccount = %+v, %v", a, err)
+		}
+		if ss, err := d.GetAccountSchedules(chk.ID); err != nil || len(ss) != 1 || ss[0].End.Int32 != int32(m1+10) {
+			t.Fatalf("Schedules after closing = %+v, %v", ss, err)
+		}
+
+		// Nothing goes in after the closing date
+		if err := d.NewAccountTransaction(&model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 11, Amount: -1}); !errors.Is(err, db.ErrAccountClosed) {
+			t.Fatalf("NewAccountTransaction after closing = %v, want ErrAccountClosed", err)
+		}
+		if err := d.Batch_NewAccountTransaction([]model.AccountTransaction{{AccountID: chk.ID, PostDate: m2 + 1, Amount: -1}}, false); !errors.Is(err, db.ErrAccountClosed) {
+			t.Fatalf("Batch_NewAccountTransaction after closing = %v, want ErrAccountClosed", err)
+		}
+		if err := d.NewTransfer(&model.Transfer{FromAccountID: sav.ID, ToAccountID: chk.ID, PostDate: m2 + 1, Amount: 1}); !errors.Is(err, db.ErrAccountClosed) {
+			t.Fatalf("NewTransfer after closing = %v, want ErrAccountClosed", err)
+		}
+		if err := d.CloseAccount(closing); !errors.Is(err, db.ErrInvalidClose) {
+			t.Fatalf("CloseAccount twice = %v, want ErrInvalidClose", err)
+		}
+
+		// A debt account's envelope hands its money back to the float and hides
+		debt, err := d.GetDebtEnvelopeFor(card.ID)
+		if err != nil {
+			t.Fatalf("GetDebtEnvelopeFor: %s", err)
+		}
+		mustAT(t, d, model.AccountTransaction{AccountID: card.ID, PostDate: m1 + 3, Amount: -2000})
+		if err := d.NewEnvelopeTransaction(&model.EnvelopeTransaction{EnvelopeID: debt.ID, PostDate: m1 + 1, Amount: 500}); err != nil {
+			t.Fatalf("NewEnvelopeTransaction: %s", err)
+		}
+		if err := d.CloseAccount(db.Closing{AccountID: card.ID, Date: m1 + 12, TransferTo: nullID(sav.ID)}); err != nil {
+			t.Fatalf("CloseAccount of a debt account: %s", err)
+		}
+		if s := envelopeSummary(t, d, m1, debt.ID); s.Bal != 0 {
+			t.Fatalf("Debt envelope after closing = %d, want 0", s.Bal)
+		}
+		if e, err := d.GetEnvelope(debt.ID); err != nil || !e.Hidden {
+			t.Fatalf("Debt envelope = %+v, %v", e, err)
+		}
+		if s := overallSummary(t, d, m1); s.Float != 5000 {
+			t.Fatalf("Float after closing = %d, want 5000", s.Float)
+		}
+
+		if err := d.ReopenAccount(card.ID); err != nil {
+			t.Fatalf("ReopenAccount: %s", err)
+		}
+		if a, err := d.GetAccount(card.ID); err != nil || a.Closed.Valid {
+			t.Fatalf("GetAccount after reopening = %+v, %v", a, err)
+		}
+		if e, err := d.GetEnvelope(debt.ID); err != nil || e.Hidden {
+			t.Fatalf("Debt envelope after reopening = %+v, %v", e, err)
+		}
+		if err := d.ReopenAccount(card.ID); !errors.Is(err, db.ErrInvalidClose) {
+			t.Fatalf("ReopenAccount twice = %v, want ErrInvalidClose", err)
+		}
+
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+	})
+}
+
+func TestUndo(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		d = d.WithActor("alice")
+		chk := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Checking"})
+		sav := mustAccount(t, d, model.Account{Institution: "Bank", Name: "Savings"})
+		food := mustEnvelope(t, d, model.Envelope{Name: "Food"})
+		if err := d.SetStartingBalance(chk.ID, 10000); err != nil {
+			t.Fatalf("SetStartingBalance: %s", err)
+		}
+		mustAT(t, d, model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 2, Amount: -3000, Splits: []model.Split{
+			{EnvelopeID: nullID(food.ID), Amount: -2000},
+			{Amount: -1000},
+		}})
+		x := mustTransfer(t, d, model.Transfer{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: m1 + 3, Amount: 1000})
+		want := overallSummary(t, d, m1)
+		wantChk := accountSummary(t, d, m1, chk.ID)
+
+		// A call that fails logs nothing
+		if err := d.CloseAccount(db.Closing{AccountID: chk.ID, Date: m1 + 10}); !errors.Is(err, db.ErrInvalidClose) {
+			t.Fatalf("CloseAccount with a balance = %v, want ErrInvalidClose", err)
+		}
+		if err := d.DeleteAccount(chk.ID); err != nil {
+			t.Fatalf("DeleteAccount: %s", err)
+		}
+		ops, err := d.GetHistory(2)
+		if err != nil || len(ops) != 2 || ops[0].Name != "DeleteAccount" || ops[0].Actor != "alice" || ops[1].Name != "NewTransfer" {
+			t.Fatalf("GetHistory = %+v, %v", ops, err)
+		}
+		cs, err := d.GetAuditChanges(ops[0].ID)
+		if err != nil || len(cs) != ops[0].Rows || cs[len(cs)-1].Table != "a" || cs[len(cs)-1].After != nil {
+			t.Fatalf("GetAuditChanges = %+v, %v", cs, err)
+		}
+
+		// The account comes back with its history, transfer link and checkpoints
+		undone, err := d.Undo(1)
+		if err != nil || len(undone) != 1 || undone[0].ID != ops[0].ID {
+			t.Fatalf("Undo = %+v, %v", undone, err)
+		}
+		if s := accountSummary(t, d, m1, chk.ID); s != wantChk {
+			t.Fatalf("Checking after undo = %+v, want %+v", s, wantChk)
+		}
+		if s := overallSummary(t, d, m1); s != want {
+			t.Fatalf("Summary after undo = %+v, want %+v", s, want)
+		}
+		if s := envelopeSummary(t, d, m1, food.ID); s.Out != -2000 {
+			t.Fatalf("Food after undo = %+v", s)
+		}
+		if _, err := d.GetTransfer(x.ID); err != nil {
+			t.Fatalf("GetTransfer after undo: %s", err)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+		ops, err = d.GetHistory(2)
+		if err != nil || ops[0].Name != db.UndoName || ops[0].Undoes.Int32 != int32(undone[0].ID) || !ops[1].Undone {
+			t.Fatalf("GetHistory after undo = %+v, %v", ops, err)
+		}
+
+		// Undo skips its own ops and what it already undid
+		undone, err = d.Undo(2)
+		if err != nil || len(undone) != 2 || undone[0].Name != "NewTransfer" || undone[1].Name != "NewAccountTransaction" {
+			t.Fatalf("Undo(2) = %+v, %v", undone, err)
+		}
+		if s := accountSummary(t, d, m1, sav.ID); s.Bal != 0 {
+			t.Fatalf("Savings after undo = %+v", s)
+		}
+		if s := envelopeSummary(t, d, m1, food.ID); s.Out != 0 {
+			t.Fatalf("Food after undo = %+v", s)
+		}
+
+		if undone, err = d.Undo(100); err != nil || len(undone) != 4 {
+			t.Fatalf("Undo(100) = %+v, %v", undone, err)
+		}
+		if as, err := d.GetAccounts(); err != nil || len(as) != 0 {
+			t.Fatalf("GetAccounts after undoing everything = %+v, %v", as, err)
+		}
+		if vs, err := d.Check(); err != nil || len(vs) != 0 {
+			t.Fatalf("Check = %v, %v", vs, err)
+		}
+		if _, err := d.Undo(1); !errors.Is(err, db.ErrNothingToUndo) {
+			t.Fatalf("Undo with nothing left = %v, want ErrNothingToUndo", err)
+		}
+	})
+}
+
+func TestUsers(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		alice := model.User{Name: "alice", Hash: "hash-a"}
+		if err := d.NewUser(&alice); err != nil {
+			t.Fatalf("NewUser: %s", err)
+		}
+		bob := model.User{Name: "bob", Hash: "hash-b"}
+		if err := d.NewUser(&bob); err != nil {
+			t.Fatalf("NewUser: %s", err)
+		}
+		if err := d.NewUser(&model.User{Name: "alice", Hash: "x"}); err == nil {
+			t.Fatalf("NewUser with a taken name succeeded")
+		}
+
+		if got, err := d.GetUserByName("alice"); err != nil || got != alice {
+			t.Fatalf("GetUserByName = %+v, %v, want %+v", got, err, alice)
+		}
+		alice.Hash = "hash-a2"
+		if err := d.UpdateUser(alice); err != nil {
+			t.Fatalf("UpdateUser: %s", err)
+		}
+		if got, err := d.GetUser(alice.ID); err != nil || got != alice {
+			t.Fatalf("GetUser = %+v, %v, want %+v", got, err, alice)
+		}
+		if us, err := d.GetUsers(); err != nil || len(us) != 2 || us[0] != alice || us[1] != bob {
+			t.Fatalf("GetUsers = %+v, %v", us, err)
+		}
+
+		cron := model.Token{UserID: alice.ID, Name: "cron", Hash: "t1"}
+		if err := d.NewToken(&cron); err != nil {
+			t.Fatalf("NewToken: %s", err)
+		}
+		if err := d.NewToken(&model.Token{UserID: bob.ID, Hash: "t1"}); err == nil {
+			t.Fatalf("NewToken with a taken hash succeeded")
+		}
+		if err := d.NewToken(&model.Token{UserID: alice.ID, Name: "phone", Hash: "t2"}); err != nil {
+			t.Fatalf("NewToken: %s", err)
+		}
+		if got, err := d.GetTokenByHash("t1"); err != nil || got != cron {
+			t.Fatalf("GetTokenByHash = %+v, %v, want %+v", got, err, cron)
+		}
+		if err := d.DeleteToken(cron.ID); err != nil {
+			t.Fatalf("DeleteToken: %s", err)
+		}
+		if _, err := d.GetTokenByHash("t1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetTokenByHash of a deleted token = %v", err)
+		}
+		if ts, err := d.GetTokens(alice.ID); err != nil || len(ts) != 1 || ts[0].Name != "phone" {
+			t.Fatalf("GetTokens = %+v, %v", ts, err)
+		}
+
+		// Tokens go with their user, and neither shows in the audit log
+		if err := d.DeleteUser(alice.ID); err != nil {
+			t.Fatalf("DeleteUser: %s", err)
+		}
+		if _, err := d.GetTokenByHash("t2"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetTokenByHash after DeleteUser = %v", err)
+		}
+		if ops, err := d.GetHistory(10); err != nil || len(ops) != 0 {
+			t.Fatalf("GetHistory = %+v, %v", ops, err)
+		}
+	})
+}
+
+func TestSessions(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, d db.DB) {
+		alice := model.User{Name: "alice", Hash: "x"}
+		if err := d.NewUser(&alice); err != nil {
+			t.Fatalf("NewUser: %s", err)
+		}
+
+		now := time.Unix(time.Now().Unix(), 0)
+		fresh := model.Session{UserID: alice.ID, Hash: "s1", Created: now.Add(-time.Hour), LastSeen: now, Agent: "curl", Addr: "127.0.0.1:1"}
+		idle := model.Session{UserID: alice.ID, Hash: "s2", Created: now.Add(-time.Hour), LastSeen: now.Add(-time.Hour)}
+		old := model.Session{UserID: alice.ID, Hash: "s3", Created: now.Add(-48 * time.Hour), LastSeen: now}
+		for _, s := range []*model.Session{&fresh, &idle, &old} {
+			if err := d.NewSession(s); err != nil {
+				t.Fatalf("NewSession: %s", err)
+			}
+		}
+		if err := d.NewSession(&model.Session{UserID: alice.ID, Hash: "s1", Created: now, LastSeen: now}); err == nil {
+			t.Fatalf("NewSession with a taken hash succeeded")
+		}
+
+		fresh.QM = 20240300
+		if err := d.UpdateSession(fresh); err != nil {
+			t.Fatalf("UpdateSession: %s", err)
+		}
+		if got, err := d.GetSessionByHash("s1"); err != nil || got != fresh {
+			t.Fatalf("GetSessionByHash = %+v, %v, want %+v", got, err, fresh)
+		}
+
+		if n, err := d.PruneSessions(now.Add(-30*time.Minute), now.Add(-24*time.Hour)); err != nil || n != 2 {
+			t.Fatalf("PruneSessions = %d, %v, want 2", n, err)
+		}
+		if ss, err := d.GetSessions(alice.ID); err != nil || len(ss) != 1 || ss[0] != fresh {
+			t.Fatalf("GetSessions = %+v, %v", ss, err)
+		}
+
+		// A rename keeps the session, a new password ends it
+		alice.Name = "alice2"
+		if err := d.UpdateUser(alice); err != nil {
+			t.Fatalf("UpdateUser: %s", err)
+		}
+		if _, err := d.GetSessionByHash("s1"); err != nil {
+			t.Fatalf("GetSessionByHash after a rename = %v", err)
+		}
+		alice.Hash = "y"
+		if err := d.UpdateUser(alice); err != nil {
+			t.Fatalf("UpdateUser: %s", err)
+		}
+		if _, err := d.GetSessionByHash("s1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetSessionByHash after a new password = %v", err)
+		}
+		if u, err := d.GetUserByName("alice2"); err != nil || u.Hash != "y" {
+			t.Fatalf("GetUserByName = %+v, %v", u, err)
+		}
+		if err := d.NewSession(&model.Session{UserID: alice.ID, Hash: "s1", Created: now, LastSeen: now}); err != nil {
+			t.Fatalf("NewSession: %s", err)
+		}
+
+		if err := d.DeleteUser(alice.ID); err != nil {
+			t.Fatalf("DeleteUser: %s", err)
+		}
+		if _, err := d.GetSessionByHash("s1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetSessionByHash after DeleteUser = %v", err)
+		}
+	})
+}
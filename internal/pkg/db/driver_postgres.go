@@ -1,7 +1,1359 @@
 package db
 
-// TODO: Postgres specific code
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
 
+	_ "github.com/lib/pq"
+)
+
+// Glue between our DB and the Postgres driver
 type Postgres struct {
-	// TODO: Implement DB interface
+	db *sql.DB
+	// Who the audit log puts the writes down to, see WithActor
+	actor string
+}
+
+func NewPostgres() DB {
+	return &Postgres{}
+}
+
+func (p *Postgres) Connect(dsn string) error {
+	var err error
+
+	p.db, err = sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open db connection: %w", err)
+	}
+
+	if err := p.db.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to db: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) Open(dsn string) error {
+	if err := p.Connect(dsn); err != nil {
+		return err
+	}
+
+	if _, err := p.Migrate(); err != nil {
+		return fmt.Errorf("failed to migrate db: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) Init() error {
+	if p.db == nil {
+		return fmt.Errorf("cannot init DB before opening")
+	}
+
+	tables := make([]string, 0)
+	rows, err := p.db.Query("SELECT tablename FROM pg_tables WHERE schemaname = current_schema()")
+	if err != nil {
+		return fmt.Errorf("failed listing tables: %w", err)
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed listing tables: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("failed listing tables: %w", err)
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		if _, err := p.db.Exec("DROP TABLE IF EXISTS \"" + table + "\" CASCADE"); err != nil {
+			return fmt.Errorf("failed dropping table %s: %w", table, err)
+		}
+	}
+
+	if _, err := p.Migrate(); err != nil {
+		return fmt.Errorf("failed running DB migrations: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) Run(fname string) error {
+	query, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("failed reading DB script: %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Run.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(string(query)); err != nil {
+		return fmt.Errorf("failed running DB script: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Run.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetAccounts() ([]model.Account, error) {
+	accts := make([]model.Account, 0)
+
+	rows, err := p.db.Query("SELECT * FROM a ORDER BY institution ASC, name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetAccounts.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var acct model.Account
+		if err := rows.Scan(
+			&acct.ID,
+			&acct.Hidden,
+			&acct.Offbudget,
+			&acct.Debt,
+			&acct.Institution,
+			&acct.Name,
+			&acct.Class,
+			&acct.Closed,
+		); err != nil {
+			return nil, fmt.Errorf("GetAccounts.Scan -- %w", err)
+		}
+		accts = append(accts, acct)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetAccounts.Err -- %w", err)
+	}
+	return accts, nil
+}
+func (p *Postgres) GetAccount(id model.PKEY) (model.Account, error) {
+	a := model.Account{}
+	row := p.db.QueryRow("SELECT * FROM a WHERE ID = $1", id)
+	if err := row.Scan(
+		&a.ID,
+		&a.Hidden,
+		&a.Offbudget,
+		&a.Debt,
+		&a.Institution,
+		&a.Name,
+		&a.Class,
+		&a.Closed,
+	); err != nil {
+		return a, fmt.Errorf("GetAccount.Scan.a -- %w", err)
+	}
+
+	return a, nil
+}
+func (p *Postgres) NewAccount(a *model.Account) (err error) {
+	defer logOp(p, "NewAccount", &err)()
+	var id int
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("NewAccount.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("INSERT INTO a (hidden,offbudget,debt,institution,name,class) VALUES ($1,$2,$3,$4,$5,$6) RETURNING ID", a.Hidden, a.Offbudget, a.Debt, a.Institution, a.Name, a.Class)
+	if err := row.Scan(&id); err != nil {
+		return fmt.Errorf("NewAccount.Insert.a.Scan -- %w", err)
+	}
+	a.ID = model.PKEY(id)
+
+	if a.Debt {
+		if err := p.newDebtEnvelope(tx, a.ID, a.DebtEnvelopeName()); err != nil {
+			return fmt.Errorf("NewAccount.newDebtEnvelope -- %s", err.Error())
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO a_chk (accountID,month,bal) VALUES ($1,$2,$3)", id, bcdate.Epoch(), 0)
+	if err != nil {
+		return fmt.Errorf("NewAccount.Insert.a_chk -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("NewAccount.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) UpdateAccount(a model.Account) (err error) {
+	defer logOp(p, "UpdateAccount", &err)()
+	var oldDebt bool
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateAccount.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("SELECT debt FROM a WHERE ID = $1", a.ID)
+	if err := row.Scan(&oldDebt); err != nil {
+		return fmt.Errorf("UpdateAccount.Scan.a -- %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE a SET hidden = $1, offbudget = $2, debt = $3, institution = $4, name = $5, class = $6 WHERE ID = $7", a.Hidden, a.Offbudget, a.Debt, a.Institution, a.Name, a.Class, a.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccount.Update.a -- %w", err)
+	}
+
+	if a.Debt {
+		if !oldDebt {
+			if err := p.newDebtEnvelope(tx, a.ID, a.DebtEnvelopeName()); err != nil {
+				return fmt.Errorf("UpdateAccount.newDebtEnvelope -- %s", err.Error())
+			}
+		} else {
+			if err := p.updateDebtEnvelope(tx, a.ID, a.DebtEnvelopeName()); err != nil {
+				return fmt.Errorf("UpdateAccount.updateDebtEnvelope -- %s", err.Error())
+			}
+		}
+	} else if !a.Debt && oldDebt {
+		if err := p.deleteDebtEnvelope(tx, a.ID); err != nil {
+			return fmt.Errorf("UpdateAccount.deleteDebtEnvelope -- %s", err.Error())
+		}
+		if err := p.updateSummaries(tx, bcdate.Epoch()); err != nil {
+			return fmt.Errorf("UpdateAccount.updateSummaries -- %s", err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateAccount.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) DeleteAccount(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteAccount", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	var debt bool
+	row := tx.QueryRow("SELECT debt FROM a WHERE ID = $1", id)
+	if err := row.Scan(&debt); err != nil {
+		return fmt.Errorf("DeleteAccount.Scan.a -- %w", err)
+	}
+
+	if debt {
+		if err := p.deleteDebtEnvelope(tx, id); err != nil {
+			return fmt.Errorf("DeleteAccount.deleteDebtEnvelope -- %s", err.Error())
+		}
+	}
+
+	type atupdate struct {
+		postdate   bcdate.BCDate
+		envelopeID model.PKEY
+	}
+	atus := make([]atupdate, 0)
+	rows, err := tx.Query("SELECT min(postDate) AS postDate, envelopeID FROM (SELECT postDate, envelopeID FROM a_t WHERE accountID = $1 UNION ALL SELECT a_t.postDate, a_t_split.envelopeID FROM a_t_split JOIN a_t ON a_t_split.transactionID = a_t.ID WHERE a_t.accountID = $1) t WHERE envelopeID IS NOT NULL GROUP BY envelopeID", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Select.a_t -- %w", err)
+	}
+	for rows.Next() {
+		var atu atupdate
+		if err := rows.Scan(
+			&atu.postdate,
+			&atu.envelopeID,
+		); err != nil {
+			rows.Close()
+			return fmt.Errorf("DeleteAccount.Scan.a_t -- %w", err)
+		}
+		atus = append(atus, atu)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("DeleteAccount.Select.a_t.Err -- %w", err)
+	}
+	rows.Close()
+
+	// Transfers with this account lose their link, the legs in other accounts stay as they were
+	_, err = tx.Exec("DELETE FROM a_t_transfer WHERE fromID IN (SELECT ID FROM a_t WHERE accountID = $1) OR toID IN (SELECT ID FROM a_t WHERE accountID = $1)", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a_t_transfer -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID IN (SELECT ID FROM a_t WHERE accountID = $1)", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a_t_split -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM a_t WHERE accountID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a_t -- %w", err)
+	}
+	// Rules scoped to the account go with it, dropping the scope would let them loose on every account
+	_, err = tx.Exec("DELETE FROM r WHERE accountID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.r -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM sch WHERE accountID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.sch -- %w", err)
+	}
+
+	for _, atu := range atus {
+		if err := p.updateEnvelopeSummaries(tx, atu.postdate, atu.envelopeID); err != nil {
+			return fmt.Errorf("DeleteAccount.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM a_chk WHERE accountID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a_chk -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a -- %w", err)
+	}
+
+	if err := p.updateSummaries(tx, bcdate.Epoch()); err != nil {
+		return fmt.Errorf("DeleteAccount.updateSummaries -- %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteAccount.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetStartingBalance(id model.PKEY) (int, error) {
+	var sbal int
+	row := p.db.QueryRow("SELECT bal FROM a_chk WHERE accountID = $1 AND month = $2", id, bcdate.Epoch())
+	if err := row.Scan(&sbal); err != nil {
+		return sbal, fmt.Errorf("GetStartingBalance.Scan.a_chk -- %w", err)
+	}
+	return sbal, nil
+}
+func (p *Postgres) SetStartingBalance(id model.PKEY, balance int) (err error) {
+	defer logOp(p, "SetStartingBalance", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SetStartingBalance.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE a_chk SET bal = $1 WHERE accountID = $2 AND month = $3", balance, id, bcdate.Epoch())
+	if err != nil {
+		return fmt.Errorf("SetStartingBalance.Update.a_chk -- %w", err)
+	}
+
+	if err := p.updateAccountSummaries(tx, bcdate.Epoch(), id); err != nil {
+		return fmt.Errorf("SetStartingBalance.updateAccountSummaries -- %s", err.Error())
+	}
+	if err := p.updateSummaries(tx, bcdate.Epoch()); err != nil {
+		return fmt.Errorf("SetStartingBalance.updateSummaries -- %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("SetStartingBalance.Commit -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) GetEnvelopeGroups() ([]model.EnvelopeGroup, error) {
+	egs := make([]model.EnvelopeGroup, 0)
+
+	rows, err := p.db.Query("SELECT * FROM e_grp ORDER BY sort ASC, name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetEnvelopeGroups -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eg model.EnvelopeGroup
+		if err := rows.Scan(
+			&eg.ID,
+			&eg.Name,
+			&eg.Sort,
+		); err != nil {
+			return nil, fmt.Errorf("GetEnvelopeGroups -- %w", err)
+		}
+		egs = append(egs, eg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetEnvelopeGroups -- %w", err)
+	}
+	return egs, nil
+}
+func (p *Postgres) GetEnvelopeGroup(id model.PKEY) (model.EnvelopeGroup, error) {
+	eg := model.EnvelopeGroup{}
+	row := p.db.QueryRow("SELECT * FROM e_grp WHERE ID = $1", id)
+	if err := row.Scan(
+		&eg.ID,
+		&eg.Name,
+		&eg.Sort,
+	); err != nil {
+		return eg, fmt.Errorf("GetEnvelopeGroup.Scan.e_grp -- %w", err)
+	}
+	return eg, nil
+}
+func (p *Postgres) NewEnvelopeGroup(eg *model.EnvelopeGroup) (err error) {
+	defer logOp(p, "NewEnvelopeGroup", &err)()
+	var eid int
+	row := p.db.QueryRow("INSERT INTO e_grp (name,sort) VALUES ($1,$2) RETURNING ID", eg.Name, eg.Sort)
+	if err := row.Scan(&eid); err != nil {
+		return fmt.Errorf("NewEnvelopeGroup.Insert.e_grp.Scan -- %w", err)
+	}
+	eg.ID = model.PKEY(eid)
+	return nil
+}
+func (p *Postgres) UpdateEnvelopeGroup(eg model.EnvelopeGroup) (err error) {
+	defer logOp(p, "UpdateEnvelopeGroup", &err)()
+	_, err = p.db.Exec("UPDATE e_grp SET name = $1, sort = $2 WHERE ID = $3", eg.Name, eg.Sort, eg.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateEnvelopeGroup.Update.e_grp -- %w", err)
+	}
+	return nil
+}
+func (p *Postgres) DeleteEnvelopeGroup(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteEnvelopeGroup", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelopeGroup.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE e SET groupID = $1 WHERE groupID = $2", 1, id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelopeGroup.Update.e -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM e_grp WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelopeGroup.Delete.e_grp -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteEnvelopeGroup.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetEnvelopesInGroup(id model.PKEY) ([]model.Envelope, error) {
+	es := make([]model.Envelope, 0)
+
+	rows, err := p.db.Query("SELECT * FROM e WHERE groupID = $1 ORDER BY sort ASC, name ASC", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetEnvelopesInGroup.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e model.Envelope
+		if err := rows.Scan(
+			&e.ID,
+			&e.GroupID,
+			&e.Hidden,
+			&e.DebtAccount,
+			&e.Name,
+			&e.Notes,
+			&e.Goal,
+			&e.GoalAmt,
+			&e.GoalTgt,
+			&e.Sort,
+		); err != nil {
+			return nil, fmt.Errorf("GetEnvelopesInGroup.Scan -- %w", err)
+		}
+		es = append(es, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetEnvelopesInGroup.Err -- %w", err)
+	}
+	return es, nil
+}
+func (p *Postgres) GetDebtEnvelopeFor(id model.PKEY) (model.Envelope, error) {
+	e := model.Envelope{}
+
+	row := p.db.QueryRow("SELECT * FROM e WHERE debtAccount = $1", id)
+	if err := row.Scan(
+		&e.ID,
+		&e.GroupID,
+		&e.Hidden,
+		&e.DebtAccount,
+		&e.Name,
+		&e.Notes,
+		&e.Goal,
+		&e.GoalAmt,
+		&e.GoalTgt,
+		&e.Sort,
+	); err != nil {
+		return e, fmt.Errorf("GetDebtEnvelopeFor.Scan.e -- %w", err)
+	}
+	return e, nil
+}
+
+func (p *Postgres) GetEnvelopes() ([]model.Envelope, error) {
+	es := make([]model.Envelope, 0)
+
+	rows, err := p.db.Query("SELECT * FROM e ORDER BY sort ASC, name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetEnvelopes.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e model.Envelope
+		if err := rows.Scan(
+			&e.ID,
+			&e.GroupID,
+			&e.Hidden,
+			&e.DebtAccount,
+			&e.Name,
+			&e.Notes,
+			&e.Goal,
+			&e.GoalAmt,
+			&e.GoalTgt,
+			&e.Sort,
+		); err != nil {
+			return nil, fmt.Errorf("GetEnvelopes.Scan -- %w", err)
+		}
+		es = append(es, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetEnvelopes.Err -- %w", err)
+	}
+	return es, nil
+}
+func (p *Postgres) GetEnvelope(id model.PKEY) (model.Envelope, error) {
+	e := model.Envelope{}
+
+	row := p.db.QueryRow("SELECT * FROM e WHERE ID = $1", id)
+	if err := row.Scan(
+		&e.ID,
+		&e.GroupID,
+		&e.Hidden,
+		&e.DebtAccount,
+		&e.Name,
+		&e.Notes,
+		&e.Goal,
+		&e.GoalAmt,
+		&e.GoalTgt,
+		&e.Sort,
+	); err != nil {
+		return e, fmt.Errorf("GetEnvelope.Scan.e -- %w", err)
+	}
+	return e, nil
+}
+func (p *Postgres) NewEnvelope(e *model.Envelope) (err error) {
+	defer logOp(p, "NewEnvelope", &err)()
+	var id int
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("NewEnvelope.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("INSERT INTO e (groupID,hidden,name,notes,goalType,goalAmt,goalTgt,sort) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING ID", e.GroupID, e.Hidden, e.Name, e.Notes, e.Goal, e.GoalAmt, e.GoalTgt, e.Sort)
+	if err := row.Scan(&id); err != nil {
+		return fmt.Errorf("NewEnvelope.Insert.e.Scan -- %w", err)
+	}
+	e.ID = model.PKEY(id)
+
+	_, err = tx.Exec("INSERT INTO e_chk (envelopeID,month,bal) VALUES ($1,$2,$3)", id, bcdate.Epoch(), 0)
+	if err != nil {
+		return fmt.Errorf("NewEnvelope.Insert.e_chk -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("NewEnvelope.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) UpdateEnvelope(e model.Envelope) (err error) {
+	defer logOp(p, "UpdateEnvelope", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateEnvelope.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE e SET groupID = $1, hidden = $2, name = $3, notes = $4, goalType = $5, goalAmt = $6, goalTgt = $7, sort = $8 WHERE ID = $9", e.GroupID, e.Hidden, e.Name, e.Notes, e.Goal, e.GoalAmt, e.GoalTgt, e.Sort, e.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateEnvelope.Update.e -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateEnvelope.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) DeleteEnvelope(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteEnvelope", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Begin-- %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE a_t SET envelopeID = NULL WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.a_t -- %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE a_t_split SET envelopeID = NULL WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.a_t_split -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE p SET envelopeID = NULL WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.p -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE r SET envelopeID = NULL WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.r -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE sch SET envelopeID = NULL WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.sch -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM e_t WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Delete.e_t -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM e_chk WHERE envelopeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Delete.e_chk -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM e WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Delete.e -- %w", err)
+	}
+
+	if err := p.updateSummaries(tx, bcdate.Epoch()); err != nil {
+		return fmt.Errorf("DeleteEnvelope.updateSummaries -- %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteEnvelope.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetAllTransactions(month bcdate.BCDate) ([]model.AccountTransaction, error) {
+	ats := make([]model.AccountTransaction, 0)
+
+	rows, err := p.db.Query("SELECT * FROM a_t WHERE postDate-mod(postDate,100) = $1 ORDER BY postDate DESC", month)
+	if err != nil {
+		return nil, fmt.Errorf("GetAllTransactions.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		at := model.AccountTransaction{}
+		if err := rows.Scan(
+			&at.ID,
+			&at.AccountID,
+			&at.Typ,
+			&at.EnvelopeID,
+			&at.PostDate,
+			&at.Amount,
+			&at.Cleared,
+			&at.Memo,
+			&at.PayeeID,
+			&at.ImportID,
+			&at.Reconciled,
+		); err != nil {
+			return nil, fmt.Errorf("GetAllTransactions.Scan -- %w", err)
+		}
+		ats = append(ats, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetAllTransactions.Err -- %w", err)
+	}
+	if err := attachSplits(p.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllTransactions.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(p.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllTransactions.attachTransfers -- %w", err)
+	}
+	return ats, nil
+}
+
+func (p *Postgres) GetAllAccountTransactions(id model.PKEY) ([]model.AccountTransaction, error) {
+	ats := make([]model.AccountTransaction, 0)
+
+	rows, err := p.db.Query("SELECT * FROM a_t WHERE accountID = $1 ORDER BY postDate DESC", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetAllAccountTransactions.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		at := model.AccountTransaction{}
+		if err := rows.Scan(
+			&at.ID,
+			&at.AccountID,
+			&at.Typ,
+			&at.EnvelopeID,
+			&at.PostDate,
+			&at.Amount,
+			&at.Cleared,
+			&at.Memo,
+			&at.PayeeID,
+			&at.ImportID,
+			&at.Reconciled,
+		); err != nil {
+			return nil, fmt.Errorf("GetAllAccountTransactions.Scan -- %w", err)
+		}
+		ats = append(ats, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetAllAccountTransactions.Err -- %w", err)
+	}
+	if err := attachSplits(p.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllAccountTransactions.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(p.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllAccountTransactions.attachTransfers -- %w", err)
+	}
+	return ats, nil
+}
+func (p *Postgres) GetAccountTransactions(month bcdate.BCDate, id model.PKEY) ([]model.AccountTransaction, error) {
+	ats := make([]model.AccountTransaction, 0)
+
+	rows, err := p.db.Query("SELECT * FROM a_t WHERE accountID = $1 AND postDate-mod(postDate,100) = $2 ORDER BY postDate DESC", id, month)
+	if err != nil {
+		return nil, fmt.Errorf("GetAccountTransactions.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		at := model.AccountTransaction{}
+		if err := rows.Scan(
+			&at.ID,
+			&at.AccountID,
+			&at.Typ,
+			&at.EnvelopeID,
+			&at.PostDate,
+			&at.Amount,
+			&at.Cleared,
+			&at.Memo,
+			&at.PayeeID,
+			&at.ImportID,
+			&at.Reconciled,
+		); err != nil {
+			return nil, fmt.Errorf("GetAccountTransactions.Scan -- %w", err)
+		}
+		ats = append(ats, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetAccountTransactions.Err -- %w", err)
+	}
+	if err := attachSplits(p.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAccountTransactions.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(p.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAccountTransactions.attachTransfers -- %w", err)
+	}
+	return ats, nil
+}
+
+func (p *Postgres) GetAccountTransaction(id model.PKEY) (model.AccountTransaction, error) {
+	at := model.AccountTransaction{}
+	row := p.db.QueryRow("SELECT * FROM a_t WHERE ID = $1", id)
+	if err := row.Scan(
+		&at.ID,
+		&at.AccountID,
+		&at.Typ,
+		&at.EnvelopeID,
+		&at.PostDate,
+		&at.Amount,
+		&at.Cleared,
+		&at.Memo,
+		&at.PayeeID,
+		&at.ImportID,
+		&at.Reconciled,
+	); err != nil {
+		return at, fmt.Errorf("GetAccountTransaction.Scan -- %w", err)
+	}
+
+	ats := []model.AccountTransaction{at}
+	if err := attachSplits(p.db, ats); err != nil {
+		return at, fmt.Errorf("GetAccountTransaction.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(p.db, ats); err != nil {
+		return at, fmt.Errorf("GetAccountTransaction.attachTransfers -- %w", err)
+	}
+	return ats[0], nil
+}
+
+func (p *Postgres) NewAccountTransaction(at *model.AccountTransaction) (err error) {
+	defer logOp(p, "NewAccountTransaction", &err)()
+	if err := validateSplits(*at); err != nil {
+		return fmt.Errorf("NewAccountTransaction.validateSplits -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("NewAccountTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := p.insertAccountTransaction(tx, at); err != nil {
+		return fmt.Errorf("NewAccountTransaction.insertAccountTransaction -- %w", err)
+	}
+
+	for _, eid := range at.EnvelopeIDs() {
+		if err := p.updateEnvelopeSummaries(tx, at.PostDate, eid); err != nil {
+			return fmt.Errorf("NewAccountTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	if err := p.updateAccountSummaries(tx, at.PostDate, at.AccountID); err != nil {
+		return fmt.Errorf("NewAccountTransaction.updateAccountSummaries -- %w", err)
+	}
+	if err := p.updateSummaries(tx, at.PostDate); err != nil {
+		return fmt.Errorf("NewAccountTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("NewAccountTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// The row and its splits, with the payee's envelope filled in, checkpoints are left to the caller
+func (p *Postgres) insertAccountTransaction(tx *sql.Tx, at *model.AccountTransaction) error {
+	if err := p.accountOpen(tx, at.AccountID, at.PostDate); err != nil {
+		return fmt.Errorf("insertAccountTransaction.accountOpen -- %w", err)
+	}
+	if err := p.applyPayeeDefault(tx, at); err != nil {
+		return fmt.Errorf("insertAccountTransaction.applyPayeeDefault -- %w", err)
+	}
+
+	var atid int
+	row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,cleared,memo,payeeID,importID) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING ID", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.PayeeID, at.ImportID)
+	if err := row.Scan(&atid); err != nil {
+		return fmt.Errorf("insertAccountTransaction.Insert.a_t.Scan -- %w", err)
+	}
+	at.ID = model.PKEY(atid)
+
+	if err := p.insertSplits(tx, at); err != nil {
+		return fmt.Errorf("insertAccountTransaction.insertSplits -- %w", err)
+	}
+	return nil
+}
+func (p *Postgres) UpdateAccountTransaction(at model.AccountTransaction) (err error) {
+	defer logOp(p, "UpdateAccountTransaction", &err)()
+	if err := validateSplits(at); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.validateSplits -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", at.ID))
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.Select.a_t.Scan -- %w", err)
+	}
+	if err := checkLocked(old, at); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction -- %w", err)
+	}
+	// Another account is MoveAccountTransaction's job
+	if old.AccountID != at.AccountID {
+		return fmt.Errorf("UpdateAccountTransaction -- %w: transaction %d stays on account %d, move it instead", ErrInvalidMove, at.ID, old.AccountID)
+	}
+	if err := p.accountOpen(tx, at.AccountID, at.PostDate); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.accountOpen -- %w", err)
+	}
+	oldest := old.PostDate
+	oldeids, err := p.transactionEnvelopes(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.transactionEnvelopes -- %w", err)
+	}
+	oldest = bcdate.Oldest(oldest, at.PostDate)
+
+	// A transfer leg takes its other leg along
+	var peer legPeer
+	t, leg, err := p.legTransfer(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.legTransfer -- %w", err)
+	}
+	if leg {
+		if peer, err = p.syncLeg(tx, t, &at); err != nil {
+			return fmt.Errorf("UpdateAccountTransaction.syncLeg -- %w", err)
+		}
+		oldest = bcdate.Oldest(oldest, peer.postDate)
+	}
+
+	_, err = tx.Exec("UPDATE a_t SET accountID = $1, type = $2, envelopeID = $3, postDate = $4, amount = $5, cleared = $6, memo = $7, payeeID = $8 WHERE ID = $9", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.PayeeID, at.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.Update.a_t -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID = $1", at.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.Delete.a_t_split -- %w", err)
+	}
+	if err := p.insertSplits(tx, &at); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.insertSplits -- %w", err)
+	}
+
+	for _, eid := range unionIDs(at.EnvelopeIDs(), oldeids, peer.envelopeIDs) {
+		if err := p.updateEnvelopeSummaries(tx, oldest, eid); err != nil {
+			return fmt.Errorf("UpdateAccountTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+
+	if err := p.updateAccountSummaries(tx, oldest, at.AccountID); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.updateAccountSummaries -- %w", err)
+	}
+	if leg {
+		if err := p.updateAccountSummaries(tx, oldest, peer.accountID); err != nil {
+			return fmt.Errorf("UpdateAccountTransaction.updateAccountSummaries -- %w", err)
+		}
+	}
+	if err := p.updateSummaries(tx, oldest); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Removes the transaction from its account and enters it on at.AccountID, at.ID is set to the new ID
+func (p *Postgres) MoveAccountTransaction(at *model.AccountTransaction) (err error) {
+	defer logOp(p, "MoveAccountTransaction", &err)()
+	if err := validateSplits(*at); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.validateSplits -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", at.ID))
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Select.a_t.Scan -- %w", err)
+	}
+	_, leg, err := p.legTransfer(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.legTransfer -- %w", err)
+	}
+	if err := validateMove(old, *at, leg); err != nil {
+		return fmt.Errorf("MoveAccountTransaction -- %w", err)
+	}
+	oldeids, err := p.transactionEnvelopes(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.transactionEnvelopes -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID = $1", at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Delete.a_t_split -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM a_t WHERE ID = $1", at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Delete.a_t -- %w", err)
+	}
+
+	if err := p.insertAccountTransaction(tx, at); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.insertAccountTransaction -- %w", err)
+	}
+
+	oldest := bcdate.Oldest(old.PostDate, at.PostDate)
+	for _, eid := range unionIDs(at.EnvelopeIDs(), oldeids) {
+		if err := p.updateEnvelopeSummaries(tx, oldest, eid); err != nil {
+			return fmt.Errorf("MoveAccountTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	for _, aid := range []model.PKEY{old.AccountID, at.AccountID} {
+		if err := p.updateAccountSummaries(tx, oldest, aid); err != nil {
+			return fmt.Errorf("MoveAccountTransaction.updateAccountSummaries -- %w", err)
+		}
+	}
+	if err := p.updateSummaries(tx, oldest); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) DeleteAccountTransaction(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteAccountTransaction", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	// Deleting either leg of a transfer deletes the whole transfer
+	t, leg, err := p.legTransfer(tx, id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.legTransfer -- %w", err)
+	}
+	if leg {
+		if err := p.deleteTransfer(tx, t); err != nil {
+			return fmt.Errorf("DeleteAccountTransaction.deleteTransfer -- %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("DeleteAccountTransaction.Commit -- %w", err)
+		}
+		return nil
+	}
+
+	old, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", id))
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.Select.a_t.Scan -- %w", err)
+	}
+	if old.Reconciled {
+		return fmt.Errorf("DeleteAccountTransaction -- %w: transaction %d", ErrReconciled, id)
+	}
+	aid, postdate := old.AccountID, old.PostDate
+	eids, err := p.transactionEnvelopes(tx, id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.transactionEnvelopes -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.Delete.a_t_split -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a_t WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.Delete.a_t -- %w", err)
+	}
+
+	for _, eid := range eids {
+		if err := p.updateEnvelopeSummaries(tx, postdate, eid); err != nil {
+			return fmt.Errorf("DeleteAccountTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+
+	if err := p.updateAccountSummaries(tx, postdate, aid); err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.updateAccountSummaries -- %w", err)
+	}
+	if err := p.updateSummaries(tx, postdate); err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetAllEnvelopeTransactions(id model.PKEY) ([]model.EnvelopeTransaction, error) {
+	ets := make([]model.EnvelopeTransaction, 0)
+
+	rows, err := p.db.Query("SELECT * FROM e_t WHERE envelopeID = $1 ORDER BY postDate DESC", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetAllEnvelopeTransactions.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		et := model.EnvelopeTransaction{}
+		if err := rows.Scan(
+			&et.ID,
+			&et.EnvelopeID,
+			&et.PostDate,
+			&et.Amount,
+		); err != nil {
+			return nil, fmt.Errorf("GetAllEnvelopeTransactions.Scan -- %w", err)
+		}
+		ets = append(ets, et)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetAllEnvelopeTransactions.Err -- %w", err)
+	}
+	return ets, nil
+}
+func (p *Postgres) GetEnvelopeTransactions(month bcdate.BCDate, id model.PKEY) ([]model.EnvelopeTransaction, error) {
+	ets := make([]model.EnvelopeTransaction, 0)
+
+	rows, err := p.db.Query("SELECT * FROM e_t WHERE envelopeID = $1 AND postDate-mod(postDate,100) = $2 ORDER BY postDate DESC", id, month)
+	if err != nil {
+		return nil, fmt.Errorf("GetEnvelopeTransactions.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		et := model.EnvelopeTransaction{}
+		if err := rows.Scan(
+			&et.ID,
+			&et.EnvelopeID,
+			&et.PostDate,
+			&et.Amount,
+		); err != nil {
+			return nil, fmt.Errorf("GetEnvelopeTransactions.Scan -- %w", err)
+		}
+		ets = append(ets, et)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetEnvelopeTransactions.Err -- %w", err)
+	}
+	return ets, nil
+}
+
+func (p *Postgres) GetEnvelopeTransaction(id model.PKEY) (model.EnvelopeTransaction, error) {
+	et := model.EnvelopeTransaction{}
+	row := p.db.QueryRow("SELECT * FROM e_t WHERE ID = $1", id)
+	if err := row.Scan(
+		&et.ID,
+		&et.EnvelopeID,
+		&et.PostDate,
+		&et.Amount,
+	); err != nil {
+		return et, fmt.Errorf("GetEnvelopeTransaction.Scan -- %w", err)
+	}
+	return et, nil
+}
+
+func (p *Postgres) NewEnvelopeTransaction(et *model.EnvelopeTransaction) (err error) {
+	defer logOp(p, "NewEnvelopeTransaction", &err)()
+	var etid int
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("NewEnvelopeTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("INSERT INTO e_t (envelopeID,postDate,amount) VALUES ($1,$2,$3) RETURNING ID", et.EnvelopeID, et.PostDate, et.Amount)
+	if err := row.Scan(&etid); err != nil {
+		return fmt.Errorf("NewEnvelopeTransaction.Insert.e_t.Scan -- %w", err)
+	}
+	et.ID = model.PKEY(etid)
+
+	if err := p.updateEnvelopeSummaries(tx, et.PostDate, et.EnvelopeID); err != nil {
+		return fmt.Errorf("NewEnvelopeTransaction.updateEnvelopeSummaries -- %w", err)
+	}
+
+	if err := p.updateSummaries(tx, et.PostDate); err != nil {
+		return fmt.Errorf("NewEnvelopeTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("NewEnvelopeTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) UpdateEnvelopeTransaction(et model.EnvelopeTransaction) (err error) {
+	defer logOp(p, "UpdateEnvelopeTransaction", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateEnvelopeTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldest bcdate.BCDate
+	row := tx.QueryRow("SELECT postDate FROM e_t WHERE ID = $1", et.ID)
+	if err := row.Scan(&oldest); err != nil {
+		return fmt.Errorf("UpdateEnvelopeTransaction.Select.e_t.Scan -- %w", err)
+	}
+	oldest = bcdate.Oldest(oldest, et.PostDate)
+
+	_, err = tx.Exec("UPDATE e_t SET envelopeID = $1, postDate = $2, amount = $3 WHERE ID = $4", et.EnvelopeID, et.PostDate, et.Amount, et.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateEnvelopeTransaction.Update.e_t -- %w", err)
+	}
+
+	if err := p.updateEnvelopeSummaries(tx, oldest, et.EnvelopeID); err != nil {
+		return fmt.Errorf("UpdateEnvelopeTransaction.updateEnvelopeSummaries -- %w", err)
+	}
+
+	if err := p.updateSummaries(tx, oldest); err != nil {
+		return fmt.Errorf("UpdateEnvelopeTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateEnvelopeTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) DeleteEnvelopeTransaction(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteEnvelopeTransaction", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelopeTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var eid model.PKEY
+	var postdate bcdate.BCDate
+	row := tx.QueryRow("SELECT envelopeID, postDate FROM e_t WHERE ID = $1", id)
+	if err := row.Scan(&eid, &postdate); err != nil {
+		return fmt.Errorf("DeleteEnvelopeTransaction.Select.e_t.Scan -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM e_t WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelopeTransaction.Delete.e_t -- %w", err)
+	}
+
+	if err := p.updateEnvelopeSummaries(tx, postdate, eid); err != nil {
+		return fmt.Errorf("DeleteEnvelopeTransaction.updateEnvelopeSummaries -- %w", err)
+	}
+
+	if err := p.updateSummaries(tx, postdate); err != nil {
+		return fmt.Errorf("DeleteEnvelopeTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteEnvelopeTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetAccountSummary(month bcdate.BCDate, id model.PKEY) (model.AccountSummary, error) {
+	summ := model.AccountSummary{}
+	row := p.db.QueryRow("SELECT * FROM a_chk WHERE month <= $1 AND accountID = $2 ORDER BY month DESC LIMIT 1", month, id)
+	if err := row.Scan(
+		&summ.AccountID,
+		&summ.Month,
+		&summ.Bal,
+		&summ.In,
+		&summ.Out,
+		&summ.Uncleared,
+	); err != nil {
+		return summ, fmt.Errorf("GetAccountSummary.Scan.a_chk -- %w", err)
+	}
+
+	return summ, nil
+}
+func (p *Postgres) GetEnvelopeSummary(month bcdate.BCDate, id model.PKEY) (model.EnvelopeSummary, error) {
+	summ := model.EnvelopeSummary{}
+	row := p.db.QueryRow("SELECT * FROM e_chk WHERE month <= $1 AND envelopeID = $2 ORDER BY month DESC LIMIT 1", month, id)
+	if err := row.Scan(
+		&summ.EnvelopeID,
+		&summ.Month,
+		&summ.Bal,
+		&summ.In,
+		&summ.Out,
+	); err != nil {
+		return summ, fmt.Errorf("GetEnvelopeSummary.Scan.e_chk -- %w", err)
+	}
+
+	return summ, nil
+}
+func (p *Postgres) GetOverallSummary(month bcdate.BCDate) (model.Summary, error) {
+	summ := model.Summary{}
+	row := p.db.QueryRow("SELECT * FROM s_chk WHERE month <= $1 ORDER BY month DESC LIMIT 1", month)
+	if err := row.Scan(
+		&summ.Month,
+		&summ.Float,
+		&summ.Income,
+		&summ.Expenses,
+		&summ.Delta,
+		&summ.Banked,
+		&summ.NetWorth,
+	); err != nil {
+		return summ, fmt.Errorf("GetOverallSummary.Scan.s_chk -- %w", err)
+	}
+
+	return summ, nil
+}
+
+// Verify the sanity invariants, read in one transaction so the tables agree with each other
+func (p *Postgres) Check() ([]Violation, error) {
+	// Read committed would give every query its own snapshot
+	tx, err := p.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("Check.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	vs, err := checkConsistency(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Check.checkConsistency -- %w", err)
+	}
+
+	return vs, nil
+}
+
+func (p *Postgres) newDebtEnvelope(tx *sql.Tx, aID model.PKEY, eName string) error {
+	var eid int
+
+	row := tx.QueryRow("INSERT INTO e (groupID,debtAccount,name) VALUES ($1,$2,$3) RETURNING ID", 1, aID, eName)
+	if err := row.Scan(&eid); err != nil {
+		return fmt.Errorf("newDebtEnvelope.Insert.e.Scan -- %w", err)
+	}
+	_, err := tx.Exec("INSERT INTO e_chk (envelopeID,month,bal) VALUES ($1,$2,$3)", eid, bcdate.Epoch(), 0)
+	if err != nil {
+		return fmt.Errorf("newDebtEnvelope.Insert.e_chk -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) updateDebtEnvelope(tx *sql.Tx, aID model.PKEY, eName string) error {
+	_, err := tx.Exec("UPDATE e SET name = $1 WHERE debtAccount = $2", eName, aID)
+	if err != nil {
+		return fmt.Errorf("updateDebtEnvelope.Update.e -- %w", err)
+	}
+
+	return nil
+}
+func (p *Postgres) deleteDebtEnvelope(tx *sql.Tx, aID model.PKEY) error {
+	var eid int
+
+	row := tx.QueryRow("SELECT ID FROM e WHERE debtAccount = $1", aID)
+	if err := row.Scan(&eid); err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Select.e.Scan -- %w", err)
+	}
+
+	// Postgres checks foreign keys immediately, so clear out everything pointing at the envelope first
+	_, err := tx.Exec("UPDATE a_t SET envelopeID = NULL WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.a_t -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE a_t_split SET envelopeID = NULL WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.a_t_split -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE p SET envelopeID = NULL WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.p -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE r SET envelopeID = NULL WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.r -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE sch SET envelopeID = NULL WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.sch -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM e_t WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Delete.e_t -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM e_chk WHERE envelopeID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Delete.e_chk -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM e WHERE ID = $1", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Delete.e -- %w", err)
+	}
+
+	return nil
 }
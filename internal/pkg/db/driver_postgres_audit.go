@@ -0,0 +1,98 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+func postgresPH(i int) string {
+	return "$" + strconv.Itoa(i)
+}
+
+func (p *Postgres) WithActor(actor string) DB {
+	c := *p
+	c.actor = actor
+	return &c
+}
+
+func (p *Postgres) auditHead() (int64, error) {
+	var head int64
+	if err := p.db.QueryRow("SELECT coalesce(max(ID), 0) FROM audit").Scan(&head); err != nil {
+		return 0, fmt.Errorf("auditHead.Select.audit.Scan -- %w", err)
+	}
+	return head, nil
+}
+
+func (p *Postgres) addOp(name string, head int64) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("addOp.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var last int64
+	if err := tx.QueryRow("SELECT coalesce(max(ID), 0) FROM audit").Scan(&last); err != nil {
+		return fmt.Errorf("addOp.Select.audit.Scan -- %w", err)
+	}
+	if last == head {
+		return nil
+	}
+	if err := insertOp(tx, postgresPH, p.actor, name, head+1, last, sql.NullInt32{}); err != nil {
+		return fmt.Errorf("addOp.insertOp -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("addOp.Commit -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) GetHistory(n int) ([]AuditOp, error) {
+	ops, err := getHistory(p.db, postgresPH, n)
+	if err != nil {
+		return nil, fmt.Errorf("GetHistory -- %w", err)
+	}
+	return ops, nil
+}
+
+func (p *Postgres) GetAuditChanges(id model.PKEY) ([]AuditChange, error) {
+	cs, err := getAuditChanges(p.db, postgresPH, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetAuditChanges -- %w", err)
+	}
+	return cs, nil
+}
+
+func (p *Postgres) Undo(n int) ([]AuditOp, error) {
+	opLock.Lock()
+	defer opLock.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Undo.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	// Rows come back in reverse, cascades run as triggers ahead of the audit ones so children are logged before their parent
+	t := newTouched()
+	ops, err := undoOps(tx, postgresPH, p.actor, n, &t)
+	if err != nil {
+		return nil, fmt.Errorf("Undo.undoOps -- %w", err)
+	}
+
+	aids, eids, err := t.settle(tx, postgresPH)
+	if err != nil {
+		return nil, fmt.Errorf("Undo.settle -- %w", err)
+	}
+	if err := p.updateCheckpoints(tx, t.start, aids, eids); err != nil {
+		return nil, fmt.Errorf("Undo.updateCheckpoints -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Undo.Commit -- %w", err)
+	}
+
+	return ops, nil
+}
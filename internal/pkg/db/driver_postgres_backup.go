@@ -0,0 +1,16 @@
+package db
+
+import (
+	"fmt"
+)
+
+func (p *Postgres) Backup(dest string) error {
+	return fmt.Errorf("Backup -- %w, use pg_dump", ErrBackupUnsupported)
+}
+
+func (p *Postgres) Close() error {
+	if err := p.db.Close(); err != nil {
+		return fmt.Errorf("Close -- %w", err)
+	}
+	return nil
+}
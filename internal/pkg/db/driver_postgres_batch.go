@@ -0,0 +1,150 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"fmt"
+)
+
+// Rows looking like a stored transaction or an earlier row fail the batch with ErrDuplicate, unless allowDuplicates
+func (p *Postgres) Batch_NewAccountTransaction(ats []model.AccountTransaction, allowDuplicates bool) (err error) {
+	defer logOp(p, "Batch_NewAccountTransaction", &err)()
+	var atid int
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Batch_NewAccountTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	aids := make([]model.PKEY, 0)
+	eids := make([]model.PKEY, 0)
+
+	oldest := bcdate.CurrentMonth()
+
+	rs, err := loadRules(tx)
+	if err != nil {
+		return fmt.Errorf("Batch_NewAccountTransaction.loadRules -- %w", err)
+	}
+
+	if !allowDuplicates {
+		ds, err := findDuplicates(tx, ats)
+		if err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.findDuplicates -- %w", err)
+		}
+		if err := checkDuplicates(ds); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction -- %w", err)
+		}
+	}
+
+	for _, at := range ats {
+		// Rules go first, an envelope they set wins over the payee's default
+		applyRules(rs, &at)
+		if err := validateSplits(at); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.validateSplits -- %w", err)
+		}
+		if err := p.applyPayeeDefault(tx, &at); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.applyPayeeDefault -- %w", err)
+		}
+		if err := p.accountOpen(tx, at.AccountID, at.PostDate); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.accountOpen -- %w", err)
+		}
+
+		row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,cleared,memo,payeeID,importID) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING ID", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.PayeeID, at.ImportID)
+		if err := row.Scan(&atid); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.Insert.a_t.Scan -- %w", err)
+		}
+
+		at.ID = model.PKEY(atid)
+		if err := p.insertSplits(tx, &at); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.insertSplits -- %w", err)
+		}
+
+		aids = append(aids, at.AccountID)
+		eids = append(eids, at.EnvelopeIDs()...)
+
+		oldest = bcdate.Oldest(oldest, at.PostDate)
+	}
+
+	for _, eid := range eids {
+		if err := p.updateEnvelopeSummaries(tx, oldest, eid); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	for _, aid := range aids {
+		if err := p.updateAccountSummaries(tx, oldest, aid); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.updateAccountSummaries -- %w", err)
+		}
+	}
+	if err := p.updateSummaries(tx, oldest); err != nil {
+		return fmt.Errorf("Batch_NewAccountTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Batch_NewAccountTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Import IDs already used in the account, importers skip statement rows found here
+func (p *Postgres) GetImportIDs(id model.PKEY) (map[string]bool, error) {
+	ids := make(map[string]bool)
+
+	rows, err := p.db.Query("SELECT importID FROM a_t WHERE accountID = $1 AND importID IS NOT NULL", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetImportIDs.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var importID string
+		if err := rows.Scan(&importID); err != nil {
+			return nil, fmt.Errorf("GetImportIDs.Scan -- %w", err)
+		}
+		ids[importID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetImportIDs.Err -- %w", err)
+	}
+	return ids, nil
+}
+
+func (p *Postgres) Batch_NewEnvelopeTransaction(ets []model.EnvelopeTransaction) (err error) {
+	defer logOp(p, "Batch_NewEnvelopeTransaction", &err)()
+	var etid int
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Batch_NewEnvelopeTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	eids := make([]model.PKEY, 0)
+
+	oldest := bcdate.CurrentMonth()
+
+	for _, et := range ets {
+		row := tx.QueryRow("INSERT INTO e_t (envelopeID,postDate,amount) VALUES ($1,$2,$3) RETURNING ID", et.EnvelopeID, et.PostDate, et.Amount)
+		if err := row.Scan(&etid); err != nil {
+			return fmt.Errorf("Batch_NewEnvelopeTransaction.Insert.e_t.Scan -- %w", err)
+		}
+
+		eids = append(eids, et.EnvelopeID)
+
+		oldest = bcdate.Oldest(oldest, et.PostDate)
+	}
+
+	for _, eid := range eids {
+		if err := p.updateEnvelopeSummaries(tx, oldest, eid); err != nil {
+			return fmt.Errorf("Batch_NewEnvelopeTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+
+	if err := p.updateSummaries(tx, oldest); err != nil {
+		return fmt.Errorf("Batch_NewEnvelopeTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Batch_NewEnvelopeTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
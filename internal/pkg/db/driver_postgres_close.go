@@ -0,0 +1,141 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (p *Postgres) CloseAccount(c Closing) (err error) {
+	defer logOp(p, "CloseAccount", &err)()
+	if err := validateClosing(c); err != nil {
+		return fmt.Errorf("CloseAccount.validateClosing -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("CloseAccount.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var debt bool
+	var closed sql.NullInt32
+	row := tx.QueryRow("SELECT debt, closed FROM a WHERE ID = $1", c.AccountID)
+	if err := row.Scan(&debt, &closed); err != nil {
+		return fmt.Errorf("CloseAccount.Select.a.Scan -- %w", err)
+	}
+	if closed.Valid {
+		return fmt.Errorf("CloseAccount -- %w: account %d is already closed", ErrInvalidClose, c.AccountID)
+	}
+
+	var later int
+	row = tx.QueryRow("SELECT count(*) FROM a_t WHERE accountID = $1 AND postDate > $2", c.AccountID, c.Date)
+	if err := row.Scan(&later); err != nil {
+		return fmt.Errorf("CloseAccount.Select.a_t.Scan -- %w", err)
+	}
+	if later > 0 {
+		return fmt.Errorf("CloseAccount -- %w: account %d has %d transactions after %d", ErrInvalidClose, c.AccountID, later, c.Date)
+	}
+
+	// Nothing comes after the close date, so the latest checkpoint holds the closing balance
+	var bal int
+	row = tx.QueryRow("SELECT bal FROM a_chk WHERE accountID = $1 ORDER BY month DESC LIMIT 1", c.AccountID)
+	if err := row.Scan(&bal); err != nil {
+		return fmt.Errorf("CloseAccount.Select.a_chk.Scan -- %w", err)
+	}
+	if bal != 0 {
+		if !c.TransferTo.Valid {
+			return fmt.Errorf("CloseAccount -- %w: account %d has a balance of %d", ErrInvalidClose, c.AccountID, bal)
+		}
+		t := closingTransfer(c, bal)
+		if err := p.newTransfer(tx, &t); err != nil {
+			return fmt.Errorf("CloseAccount.newTransfer -- %w", err)
+		}
+	}
+
+	if debt {
+		var eid model.PKEY
+		row = tx.QueryRow("SELECT ID FROM e WHERE debtAccount = $1", c.AccountID)
+		if err := row.Scan(&eid); err != nil {
+			return fmt.Errorf("CloseAccount.Select.e.Scan -- %w", err)
+		}
+		var ebal int
+		row = tx.QueryRow("SELECT bal FROM e_chk WHERE envelopeID = $1 ORDER BY month DESC LIMIT 1", eid)
+		if err := row.Scan(&ebal); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("CloseAccount.Select.e_chk.Scan -- %w", err)
+		}
+		if ebal != 0 {
+			if _, err := tx.Exec("INSERT INTO e_t (envelopeID,postDate,amount) VALUES ($1,$2,$3)", eid, c.Date, -ebal); err != nil {
+				return fmt.Errorf("CloseAccount.Insert.e_t -- %w", err)
+			}
+			if err := p.updateCheckpoints(tx, c.Date, nil, []model.PKEY{eid}); err != nil {
+				return fmt.Errorf("CloseAccount.updateCheckpoints -- %w", err)
+			}
+		}
+		if _, err := tx.Exec("UPDATE e SET hidden = TRUE WHERE ID = $1", eid); err != nil {
+			return fmt.Errorf("CloseAccount.Update.e -- %w", err)
+		}
+	}
+
+	// Schedules that never got going go, the rest end on the close date
+	if _, err := tx.Exec("DELETE FROM sch WHERE accountID = $1 AND startDate > $2", c.AccountID, c.Date); err != nil {
+		return fmt.Errorf("CloseAccount.Delete.sch -- %w", err)
+	}
+	if _, err := tx.Exec("UPDATE sch SET endDate = $1 WHERE accountID = $2 AND (endDate IS NULL OR endDate > $1)", c.Date, c.AccountID); err != nil {
+		return fmt.Errorf("CloseAccount.Update.sch -- %w", err)
+	}
+
+	if _, err := tx.Exec("UPDATE a SET closed = $1 WHERE ID = $2", c.Date, c.AccountID); err != nil {
+		return fmt.Errorf("CloseAccount.Update.a -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("CloseAccount.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Schedules ended by the close stay ended
+func (p *Postgres) ReopenAccount(id model.PKEY) (err error) {
+	defer logOp(p, "ReopenAccount", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ReopenAccount.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var closed sql.NullInt32
+	row := tx.QueryRow("SELECT closed FROM a WHERE ID = $1", id)
+	if err := row.Scan(&closed); err != nil {
+		return fmt.Errorf("ReopenAccount.Select.a.Scan -- %w", err)
+	}
+	if !closed.Valid {
+		return fmt.Errorf("ReopenAccount -- %w: account %d is not closed", ErrInvalidClose, id)
+	}
+
+	if _, err := tx.Exec("UPDATE a SET closed = NULL WHERE ID = $1", id); err != nil {
+		return fmt.Errorf("ReopenAccount.Update.a -- %w", err)
+	}
+	if _, err := tx.Exec("UPDATE e SET hidden = FALSE WHERE debtAccount = $1", id); err != nil {
+		return fmt.Errorf("ReopenAccount.Update.e -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ReopenAccount.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Accounts that do not exist are left for the insert to trip over
+func (p *Postgres) accountOpen(tx *sql.Tx, aid model.PKEY, date bcdate.BCDate) error {
+	var closed sql.NullInt32
+	row := tx.QueryRow("SELECT closed FROM a WHERE ID = $1", aid)
+	if err := row.Scan(&closed); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("accountOpen.Select.a.Scan -- %w", err)
+	}
+	return checkOpen(closed, aid, date)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"fmt"
+)
+
+func (p *Postgres) GetCSVProfiles() ([]model.CSVProfile, error) {
+	cps := make([]model.CSVProfile, 0)
+
+	rows, err := p.db.Query(csvProfileSelect + " ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetCSVProfiles.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cp model.CSVProfile
+		if err := scanCSVProfile(rows, &cp); err != nil {
+			return nil, fmt.Errorf("GetCSVProfiles.Scan -- %w", err)
+		}
+		cps = append(cps, cp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetCSVProfiles.Err -- %w", err)
+	}
+	return cps, nil
+}
+
+func (p *Postgres) GetCSVProfile(id model.PKEY) (model.CSVProfile, error) {
+	cp := model.CSVProfile{}
+	row := p.db.QueryRow(csvProfileSelect+" WHERE ID = $1", id)
+	if err := scanCSVProfile(row, &cp); err != nil {
+		return cp, fmt.Errorf("GetCSVProfile.Scan.csv_profile -- %w", err)
+	}
+	return cp, nil
+}
+
+func (p *Postgres) GetCSVProfileByName(name string) (model.CSVProfile, error) {
+	cp := model.CSVProfile{}
+	row := p.db.QueryRow(csvProfileSelect+" WHERE name = $1", name)
+	if err := scanCSVProfile(row, &cp); err != nil {
+		return cp, fmt.Errorf("GetCSVProfileByName.Scan.csv_profile -- %w", err)
+	}
+	return cp, nil
+}
+
+func (p *Postgres) NewCSVProfile(cp *model.CSVProfile) (err error) {
+	defer logOp(p, "NewCSVProfile", &err)()
+	row := p.db.QueryRow("INSERT INTO csv_profile (name,delimiter,skipRows,header,dateColumn,dateFormat,amountColumn,debitColumn,creditColumn,negate,decimalComma,memoColumn,clearedColumn,clearedValue,idColumn) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) RETURNING ID",
+		cp.Name, cp.Delimiter, cp.SkipRows, cp.Header, cp.DateColumn, cp.DateFormat, cp.AmountColumn, cp.DebitColumn, cp.CreditColumn, cp.Negate, cp.DecimalComma, cp.MemoColumn, cp.ClearedColumn, cp.ClearedValue, cp.IDColumn)
+	if err := row.Scan(&cp.ID); err != nil {
+		return fmt.Errorf("NewCSVProfile.Insert.csv_profile.Scan -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) UpdateCSVProfile(cp model.CSVProfile) (err error) {
+	defer logOp(p, "UpdateCSVProfile", &err)()
+	_, err = p.db.Exec("UPDATE csv_profile SET name = $1, delimiter = $2, skipRows = $3, header = $4, dateColumn = $5, dateFormat = $6, amountColumn = $7, debitColumn = $8, creditColumn = $9, negate = $10, decimalComma = $11, memoColumn = $12, clearedColumn = $13, clearedValue = $14, idColumn = $15 WHERE ID = $16",
+		cp.Name, cp.Delimiter, cp.SkipRows, cp.Header, cp.DateColumn, cp.DateFormat, cp.AmountColumn, cp.DebitColumn, cp.CreditColumn, cp.Negate, cp.DecimalComma, cp.MemoColumn, cp.ClearedColumn, cp.ClearedValue, cp.IDColumn, cp.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateCSVProfile.Update.csv_profile -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteCSVProfile(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteCSVProfile", &err)()
+	_, err = p.db.Exec("DELETE FROM csv_profile WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteCSVProfile.Delete.csv_profile -- %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+func (p *Postgres) GetMigrations() ([]Migration, error) {
+	ms, err := loadMigrations("postgres")
+	if err != nil {
+		return nil, fmt.Errorf("GetMigrations.loadMigrations -- %w", err)
+	}
+
+	applied, err := p.appliedMigrations(p.db)
+	if err != nil {
+		return nil, fmt.Errorf("GetMigrations.appliedMigrations -- %w", err)
+	}
+
+	return markApplied(ms, applied)
+}
+
+func (p *Postgres) SchemaVersion() (int, error) {
+	applied, err := p.appliedMigrations(p.db)
+	if err != nil {
+		return 0, fmt.Errorf("SchemaVersion.appliedMigrations -- %w", err)
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+func (p *Postgres) Migrate() ([]Migration, error) {
+	ms, err := loadMigrations("postgres")
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.loadMigrations -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied TEXT NOT NULL)"); err != nil {
+		return nil, fmt.Errorf("Migrate.Create.schema_version -- %w", err)
+	}
+
+	// Several servers can share one database, only let one of them migrate at a time
+	if _, err := tx.Exec("LOCK TABLE schema_version IN EXCLUSIVE MODE"); err != nil {
+		return nil, fmt.Errorf("Migrate.Lock.schema_version -- %w", err)
+	}
+
+	applied, err := p.appliedMigrations(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.appliedMigrations -- %w", err)
+	}
+	if ms, err = markApplied(ms, applied); err != nil {
+		return nil, err
+	}
+
+	// Databases from before migrations existed already hold the initial schema, record it
+	if ms[0].Applied == unrecorded {
+		ms[0].Applied = time.Now().Format(time.RFC3339)
+		if _, err := tx.Exec("INSERT INTO schema_version (version,name,applied) VALUES ($1,$2,$3)", ms[0].Version, ms[0].Name, ms[0].Applied); err != nil {
+			return nil, fmt.Errorf("Migrate.Insert.schema_version.legacy -- %w", err)
+		}
+	}
+
+	done := make([]Migration, 0)
+	for _, m := range ms {
+		if m.Applied != "" {
+			continue
+		}
+
+		if _, err := tx.Exec(m.query); err != nil {
+			return nil, fmt.Errorf("Migrate.Exec.%04d_%s -- %w", m.Version, m.Name, err)
+		}
+
+		m.Applied = time.Now().Format(time.RFC3339)
+		if _, err := tx.Exec("INSERT INTO schema_version (version,name,applied) VALUES ($1,$2,$3)", m.Version, m.Name, m.Applied); err != nil {
+			return nil, fmt.Errorf("Migrate.Insert.schema_version -- %w", err)
+		}
+
+		done = append(done, m)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Migrate.Commit -- %w", err)
+	}
+
+	return done, nil
+}
+
+func (p *Postgres) appliedMigrations(q queryer) (map[int]string, error) {
+	applied := make(map[int]string)
+
+	var hasVersion bool
+	var hasSchema bool
+	if err := q.QueryRow("SELECT to_regclass('schema_version') IS NOT NULL, to_regclass('a') IS NOT NULL").Scan(&hasVersion, &hasSchema); err != nil {
+		return nil, fmt.Errorf("appliedMigrations.Select.to_regclass -- %w", err)
+	}
+
+	// Databases from before migrations existed have the schema but no history
+	if !hasVersion {
+		if hasSchema {
+			applied[1] = unrecorded
+		}
+		return applied, nil
+	}
+
+	rows, err := q.Query("SELECT version, applied FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("appliedMigrations.Select.schema_version -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		var when string
+		if err := rows.Scan(&v, &when); err != nil {
+			return nil, fmt.Errorf("appliedMigrations.Scan -- %w", err)
+		}
+		applied[v] = when
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("appliedMigrations.Err -- %w", err)
+	}
+
+	// An empty history next to an existing schema means the version table was only just created
+	if len(applied) == 0 && hasSchema {
+		applied[1] = unrecorded
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+func (p *Postgres) GetPayees() ([]model.Payee, error) {
+	ps := make([]model.Payee, 0)
+
+	rows, err := p.db.Query("SELECT ID, name, envelopeID FROM p ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetPayees.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pe model.Payee
+		if err := rows.Scan(
+			&pe.ID,
+			&pe.Name,
+			&pe.EnvelopeID,
+		); err != nil {
+			return nil, fmt.Errorf("GetPayees.Scan -- %w", err)
+		}
+		ps = append(ps, pe)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetPayees.Err -- %w", err)
+	}
+	return ps, nil
+}
+
+func (p *Postgres) GetPayee(id model.PKEY) (model.Payee, error) {
+	pe := model.Payee{}
+	row := p.db.QueryRow("SELECT ID, name, envelopeID FROM p WHERE ID = $1", id)
+	if err := row.Scan(
+		&pe.ID,
+		&pe.Name,
+		&pe.EnvelopeID,
+	); err != nil {
+		return pe, fmt.Errorf("GetPayee.Scan.p -- %w", err)
+	}
+	return pe, nil
+}
+
+func (p *Postgres) GetPayeeByName(name string) (model.Payee, error) {
+	pe := model.Payee{}
+	row := p.db.QueryRow("SELECT ID, name, envelopeID FROM p WHERE name = $1", name)
+	if err := row.Scan(
+		&pe.ID,
+		&pe.Name,
+		&pe.EnvelopeID,
+	); err != nil {
+		return pe, fmt.Errorf("GetPayeeByName.Scan.p -- %w", err)
+	}
+	return pe, nil
+}
+
+func (p *Postgres) NewPayee(pe *model.Payee) (err error) {
+	defer logOp(p, "NewPayee", &err)()
+	row := p.db.QueryRow("INSERT INTO p (name,envelopeID) VALUES ($1,$2) RETURNING ID", pe.Name, pe.EnvelopeID)
+	if err := row.Scan(&pe.ID); err != nil {
+		return fmt.Errorf("NewPayee.Insert.p.Scan -- %w", err)
+	}
+	return nil
+}
+
+// Only new transactions use the default envelope, changing it leaves existing ones alone
+func (p *Postgres) UpdatePayee(pe model.Payee) (err error) {
+	defer logOp(p, "UpdatePayee", &err)()
+	_, err = p.db.Exec("UPDATE p SET name = $1, envelopeID = $2 WHERE ID = $3", pe.Name, pe.EnvelopeID, pe.ID)
+	if err != nil {
+		return fmt.Errorf("UpdatePayee.Update.p -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeletePayee(id model.PKEY) (err error) {
+	defer logOp(p, "DeletePayee", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE a_t SET payeeID = NULL WHERE payeeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Update.a_t -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE sch SET payeeID = NULL WHERE payeeID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Update.sch -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM p WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Delete.p -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeletePayee.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Give a transaction without an envelope the default envelope of its payee
+func (p *Postgres) applyPayeeDefault(tx *sql.Tx, at *model.AccountTransaction) error {
+	if !wantsPayeeDefault(*at) {
+		return nil
+	}
+	row := tx.QueryRow("SELECT envelopeID FROM p WHERE ID = $1", at.PayeeID)
+	if err := row.Scan(&at.EnvelopeID); err != nil {
+		return fmt.Errorf("applyPayeeDefault.Scan.p -- %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"fmt"
+)
+
+func (p *Postgres) Rebuild(dryRun bool) ([]CheckpointChange, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	// Writers wait until this commits or rolls back, readers keep seeing the old checkpoints until commit
+	if _, err := tx.Exec("LOCK TABLE a, e, a_t, e_t, a_chk, e_chk, s_chk IN EXCLUSIVE MODE"); err != nil {
+		return nil, fmt.Errorf("Rebuild.Lock -- %w", err)
+	}
+
+	before, err := snapshotCheckpoints(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.snapshotCheckpoints.before -- %w", err)
+	}
+
+	aids, eids, err := resetCheckpoints(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.resetCheckpoints -- %w", err)
+	}
+
+	for _, aid := range aids {
+		if err := p.updateAccountSummaries(tx, bcdate.Epoch(), aid); err != nil {
+			return nil, fmt.Errorf("Rebuild.updateAccountSummaries -- %w", err)
+		}
+	}
+	for _, eid := range eids {
+		if err := p.updateEnvelopeSummaries(tx, bcdate.Epoch(), eid); err != nil {
+			return nil, fmt.Errorf("Rebuild.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	if err := p.updateSummaries(tx, bcdate.Epoch()); err != nil {
+		return nil, fmt.Errorf("Rebuild.updateSummaries -- %w", err)
+	}
+
+	after, err := snapshotCheckpoints(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.snapshotCheckpoints.after -- %w", err)
+	}
+
+	changes := diffCheckpoints(before, after)
+
+	if dryRun {
+		return changes, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Rebuild.Commit -- %w", err)
+	}
+
+	return changes, nil
+}
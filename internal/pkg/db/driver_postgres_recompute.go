@@ -0,0 +1,202 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+// Set based checkpoint recompute, see recompute.go
+// Same queries as the SQLite driver, with booleans, casts for the untyped parameters and ON CONFLICT upserts
+// Bind parameters: $1 first month, $2 last month, $3 account or envelope ID
+
+var postgresAccountRecompute = `WITH RECURSIVE ` + fmt.Sprintf(monthSeries, "$1::integer", "$2::integer") + `,
+flows(m, "in", out, uncleared) AS (
+	SELECT postDate - postDate % 100,
+		sum(CASE WHEN amount > 0 THEN amount ELSE 0 END),
+		sum(CASE WHEN amount < 0 THEN amount ELSE 0 END),
+		sum(CASE WHEN NOT cleared THEN amount ELSE 0 END)
+	FROM a_t WHERE accountID = $3 AND postDate >= $1
+	GROUP BY postDate - postDate % 100
+)
+INSERT INTO a_chk (accountID, month, bal, "in", out, uncleared)
+SELECT $3::integer, months.m,
+	coalesce((SELECT bal FROM a_chk WHERE accountID = $3 AND month < $1 ORDER BY month DESC LIMIT 1), 0)
+		+ sum(coalesce(flows."in", 0) + coalesce(flows.out, 0)) OVER (ORDER BY months.m),
+	coalesce(flows."in", 0), coalesce(flows.out, 0), coalesce(flows.uncleared, 0)
+FROM months LEFT JOIN flows ON flows.m = months.m
+ON CONFLICT (accountID, month) DO UPDATE SET bal = EXCLUDED.bal, "in" = EXCLUDED."in", out = EXCLUDED.out, uncleared = EXCLUDED.uncleared`
+
+// Envelope "in" is everything assigned through e_t, "out" everything spent through a_t, whole or split
+var postgresEnvelopeRecompute = `WITH RECURSIVE ` + fmt.Sprintf(monthSeries, "$1::integer", "$2::integer") + `,
+flows(m, "in", out) AS (
+	SELECT m, sum(e_amt), sum(a_amt) FROM (
+		SELECT postDate - postDate % 100 AS m, amount AS e_amt, 0 AS a_amt FROM e_t WHERE envelopeID = $3 AND postDate >= $1
+		UNION ALL
+		SELECT postDate - postDate % 100, 0, amount FROM a_t WHERE envelopeID = $3 AND postDate >= $1
+		UNION ALL
+		SELECT a_t.postDate - a_t.postDate % 100, 0, a_t_split.amount FROM a_t_split JOIN a_t ON a_t_split.transactionID = a_t.ID WHERE a_t_split.envelopeID = $3 AND a_t.postDate >= $1
+	) t
+	GROUP BY m
+)
+INSERT INTO e_chk (envelopeID, month, bal, "in", out)
+SELECT $3::integer, months.m,
+	coalesce((SELECT bal FROM e_chk WHERE envelopeID = $3 AND month < $1 ORDER BY month DESC LIMIT 1), 0)
+		+ sum(coalesce(flows."in", 0) + coalesce(flows.out, 0)) OVER (ORDER BY months.m),
+	coalesce(flows."in", 0), coalesce(flows.out, 0)
+FROM months LEFT JOIN flows ON flows.m = months.m
+ON CONFLICT (envelopeID, month) DO UPDATE SET bal = EXCLUDED.bal, "in" = EXCLUDED."in", out = EXCLUDED.out`
+
+// Balances are summed as per month changes of every checkpoint chain, so a month adds what moved in it and the months before $1 give the base
+// Net worth counts every a_chk row, float and banked only those of accounts that still exist, as the loop version did
+var postgresSummaryRecompute = `WITH RECURSIVE ` + fmt.Sprintf(monthSeries, "$1::integer", "$2::integer") + `,
+ad(m, fl, bk, nw) AS (
+	SELECT month,
+		sum(CASE WHEN NOT debt AND NOT offbudget THEN d ELSE 0 END),
+		sum(CASE WHEN NOT offbudget THEN d ELSE 0 END),
+		sum(d)
+	FROM (
+		SELECT month, debt, offbudget, bal - coalesce(lag(bal) OVER (PARTITION BY accountID ORDER BY month), 0) AS d
+		FROM a_chk LEFT JOIN a ON a_chk.accountID = a.ID
+	) t
+	GROUP BY month
+),
+ed(m, eb) AS (
+	SELECT month, sum(d) FROM (
+		SELECT month, bal - coalesce(lag(bal) OVER (PARTITION BY envelopeID ORDER BY month), 0) AS d FROM e_chk
+	) t
+	GROUP BY month
+),
+base(fl, bk, nw, eb) AS (
+	SELECT
+		(SELECT coalesce(sum(fl), 0) FROM ad WHERE m < $1),
+		(SELECT coalesce(sum(bk), 0) FROM ad WHERE m < $1),
+		(SELECT coalesce(sum(nw), 0) FROM ad WHERE m < $1),
+		(SELECT coalesce(sum(eb), 0) FROM ed WHERE m < $1)
+),
+tf(m, income, expenses, delta) AS (
+	SELECT postDate - postDate % 100,
+		sum(CASE WHEN type = 1 THEN amount ELSE 0 END),
+		sum(CASE WHEN type = 0 THEN amount ELSE 0 END),
+		sum(amount)
+	FROM a_t JOIN a ON a_t.accountID = a.ID
+	WHERE NOT a.offbudget AND postDate >= $1
+	GROUP BY postDate - postDate % 100
+),
+run(m, fl, bk, nw, eb) AS (
+	SELECT months.m,
+		sum(coalesce(ad.fl, 0)) OVER w,
+		sum(coalesce(ad.bk, 0)) OVER w,
+		sum(coalesce(ad.nw, 0)) OVER w,
+		sum(coalesce(ed.eb, 0)) OVER w
+	FROM months LEFT JOIN ad ON ad.m = months.m LEFT JOIN ed ON ed.m = months.m
+	WINDOW w AS (ORDER BY months.m)
+)
+INSERT INTO s_chk (month, "float", income, expenses, delta, banked, netWorth)
+SELECT run.m,
+	base.fl + run.fl - base.eb - run.eb,
+	coalesce(tf.income, 0), coalesce(tf.expenses, 0), coalesce(tf.delta, 0),
+	base.bk + run.bk,
+	base.nw + run.nw
+FROM run CROSS JOIN base LEFT JOIN tf ON tf.m = run.m
+ON CONFLICT (month) DO UPDATE SET "float" = EXCLUDED."float", income = EXCLUDED.income, expenses = EXCLUDED.expenses, delta = EXCLUDED.delta, banked = EXCLUDED.banked, netWorth = EXCLUDED.netWorth`
+
+func (p *Postgres) updateAccountSummaries(tx *sql.Tx, start bcdate.BCDate, aID model.PKEY) error {
+	var first_t, first_c, last_t, last_c sql.NullInt32
+	err := tx.QueryRow(`SELECT
+		(SELECT min(postDate) FROM a_t WHERE accountID = $1),
+		(SELECT min(month) FROM a_chk WHERE accountID = $1 AND month > 0),
+		(SELECT max(postDate) FROM a_t WHERE accountID = $1),
+		(SELECT max(month) FROM a_chk WHERE accountID = $1 AND month > 0)`, aID).Scan(&first_t, &first_c, &last_t, &last_c)
+	if err != nil {
+		return fmt.Errorf("updateAccountSummaries.Select.range -- %w", err)
+	}
+
+	from, to, ok := recomputeRange(start, []sql.NullInt32{first_t, first_c}, []sql.NullInt32{last_t, last_c})
+	if !ok {
+		return nil
+	}
+
+	if _, err := tx.Exec(postgresAccountRecompute, from, to, aID); err != nil {
+		return fmt.Errorf("updateAccountSummaries.Upsert.a_chk -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) updateEnvelopeSummaries(tx *sql.Tx, start bcdate.BCDate, eID model.PKEY) error {
+	var first_a, first_s, first_e, first_c, last_a, last_s, last_e, last_c sql.NullInt32
+	err := tx.QueryRow(`SELECT
+		(SELECT min(postDate) FROM a_t WHERE envelopeID = $1),
+		(SELECT min(a_t.postDate) FROM a_t_split JOIN a_t ON a_t_split.transactionID = a_t.ID WHERE a_t_split.envelopeID = $1),
+		(SELECT min(postDate) FROM e_t WHERE envelopeID = $1),
+		(SELECT min(month) FROM e_chk WHERE envelopeID = $1 AND month > 0),
+		(SELECT max(postDate) FROM a_t WHERE envelopeID = $1),
+		(SELECT max(a_t.postDate) FROM a_t_split JOIN a_t ON a_t_split.transactionID = a_t.ID WHERE a_t_split.envelopeID = $1),
+		(SELECT max(postDate) FROM e_t WHERE envelopeID = $1),
+		(SELECT max(month) FROM e_chk WHERE envelopeID = $1 AND month > 0)`, eID).Scan(&first_a, &first_s, &first_e, &first_c, &last_a, &last_s, &last_e, &last_c)
+	if err != nil {
+		return fmt.Errorf("updateEnvelopeSummaries.Select.range -- %w", err)
+	}
+
+	from, to, ok := recomputeRange(start, []sql.NullInt32{first_a, first_s, first_e, first_c}, []sql.NullInt32{last_a, last_s, last_e, last_c})
+	if !ok {
+		return nil
+	}
+
+	if _, err := tx.Exec(postgresEnvelopeRecompute, from, to, eID); err != nil {
+		return fmt.Errorf("updateEnvelopeSummaries.Upsert.e_chk -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) updateSummaries(tx *sql.Tx, start bcdate.BCDate) error {
+	var firsts, lasts [5]sql.NullInt32
+	err := tx.QueryRow(`SELECT
+		(SELECT min(postDate) FROM a_t),
+		(SELECT min(postDate) FROM e_t),
+		(SELECT min(month) FROM a_chk WHERE month > 0),
+		(SELECT min(month) FROM e_chk WHERE month > 0),
+		(SELECT min(month) FROM s_chk WHERE month > 0),
+		(SELECT max(postDate) FROM a_t),
+		(SELECT max(postDate) FROM e_t),
+		(SELECT max(month) FROM a_chk WHERE month > 0),
+		(SELECT max(month) FROM e_chk WHERE month > 0),
+		(SELECT max(month) FROM s_chk WHERE month > 0)`).Scan(
+		&firsts[0], &firsts[1], &firsts[2], &firsts[3], &firsts[4],
+		&lasts[0], &lasts[1], &lasts[2], &lasts[3], &lasts[4])
+	if err != nil {
+		return fmt.Errorf("updateSummaries.Select.range -- %w", err)
+	}
+
+	from, to, ok := recomputeRange(start, firsts[:], lasts[:])
+	if !ok {
+		return nil
+	}
+
+	if _, err := tx.Exec(postgresSummaryRecompute, from, to); err != nil {
+		return fmt.Errorf("updateSummaries.Upsert.s_chk -- %w", err)
+	}
+
+	return nil
+}
+
+// Recompute everything a change from start touched: the given accounts and envelopes, then the summary
+func (p *Postgres) updateCheckpoints(tx *sql.Tx, start bcdate.BCDate, aids []model.PKEY, eids []model.PKEY) error {
+	for _, eid := range eids {
+		if err := p.updateEnvelopeSummaries(tx, start, eid); err != nil {
+			return fmt.Errorf("updateCheckpoints.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	for _, aid := range aids {
+		if err := p.updateAccountSummaries(tx, start, aid); err != nil {
+			return fmt.Errorf("updateCheckpoints.updateAccountSummaries -- %w", err)
+		}
+	}
+	if err := p.updateSummaries(tx, start); err != nil {
+		return fmt.Errorf("updateCheckpoints.updateSummaries -- %w", err)
+	}
+	return nil
+}
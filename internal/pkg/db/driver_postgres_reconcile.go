@@ -0,0 +1,106 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (p *Postgres) Reconcile(r Reconciliation) (_ ReconcileResult, err error) {
+	defer logOp(p, "Reconcile", &err)()
+	res := ReconcileResult{}
+	if err := validateReconciliation(r); err != nil {
+		return res, fmt.Errorf("Reconcile.validateReconciliation -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return res, fmt.Errorf("Reconcile.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var sbal int
+	row := tx.QueryRow("SELECT bal FROM a_chk WHERE accountID = $1 AND month = $2", r.AccountID, bcdate.Epoch())
+	if err := row.Scan(&sbal); err != nil {
+		return res, fmt.Errorf("Reconcile.Select.a_chk.Scan -- %w", err)
+	}
+
+	oldest := r.Date
+	for _, id := range r.Cleared {
+		at, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", id))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && at.AccountID != r.AccountID) {
+			return res, fmt.Errorf("Reconcile -- %w: transaction %d is not in account %d", ErrInvalidReconcile, id, r.AccountID)
+		}
+		if err != nil {
+			return res, fmt.Errorf("Reconcile.Select.a_t.Scan -- %w", err)
+		}
+		if at.PostDate > r.Date {
+			return res, fmt.Errorf("Reconcile -- %w: transaction %d is after the statement date", ErrInvalidReconcile, id)
+		}
+		if at.Cleared {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE a_t SET cleared = TRUE WHERE ID = $1", id); err != nil {
+			return res, fmt.Errorf("Reconcile.Update.a_t -- %w", err)
+		}
+		oldest = bcdate.Oldest(oldest, at.PostDate)
+	}
+
+	var cleared int
+	row = tx.QueryRow("SELECT coalesce(sum(amount), 0) FROM a_t WHERE accountID = $1 AND cleared AND postDate <= $2", r.AccountID, r.Date)
+	if err := row.Scan(&cleared); err != nil {
+		return res, fmt.Errorf("Reconcile.Select.a_t.Scan -- %w", err)
+	}
+	res.ClearedBalance = sbal + cleared
+	res.Difference = r.Balance - res.ClearedBalance
+
+	if res.Difference != 0 {
+		if !r.Adjust {
+			return res, fmt.Errorf("Reconcile -- %w: off by %d", ErrReconcileMismatch, res.Difference)
+		}
+		adj := model.AccountTransaction{AccountID: r.AccountID, Typ: model.TT_ADJUST, PostDate: r.Date, Amount: res.Difference, Cleared: true, Memo: ReconcileMemo}
+		if err := p.insertAccountTransaction(tx, &adj); err != nil {
+			return res, fmt.Errorf("Reconcile.insertAccountTransaction -- %w", err)
+		}
+		res.Adjustment = sql.NullInt32{Int32: int32(adj.ID), Valid: true}
+	}
+
+	result, err := tx.Exec("UPDATE a_t SET reconciled = TRUE WHERE accountID = $1 AND cleared AND NOT reconciled AND postDate <= $2", r.AccountID, r.Date)
+	if err != nil {
+		return res, fmt.Errorf("Reconcile.Update.a_t -- %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return res, fmt.Errorf("Reconcile.RowsAffected -- %w", err)
+	}
+	res.Reconciled = int(n)
+
+	if err := p.updateCheckpoints(tx, oldest, []model.PKEY{r.AccountID}, nil); err != nil {
+		return res, fmt.Errorf("Reconcile.updateCheckpoints -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("Reconcile.Commit -- %w", err)
+	}
+
+	return res, nil
+}
+
+func (p *Postgres) SetReconciled(id model.PKEY, reconciled bool) (err error) {
+	defer logOp(p, "SetReconciled", &err)()
+	at, err := scanLock(p.db.QueryRow(lockSelect+" WHERE ID = $1", id))
+	if err != nil {
+		return fmt.Errorf("SetReconciled.Select.a_t.Scan -- %w", err)
+	}
+	if reconciled && !at.Cleared {
+		return fmt.Errorf("SetReconciled -- %w: transaction %d is not cleared", ErrInvalidReconcile, id)
+	}
+
+	_, err = p.db.Exec("UPDATE a_t SET reconciled = $1 WHERE ID = $2", reconciled, id)
+	if err != nil {
+		return fmt.Errorf("SetReconciled.Update.a_t -- %w", err)
+	}
+	return nil
+}
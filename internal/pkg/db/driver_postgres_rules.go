@@ -0,0 +1,70 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"fmt"
+)
+
+func (p *Postgres) GetRules() ([]model.Rule, error) {
+	rs, err := selectRules(p.db)
+	if err != nil {
+		return nil, fmt.Errorf("GetRules.selectRules -- %w", err)
+	}
+	return rs, nil
+}
+
+func (p *Postgres) GetRule(id model.PKEY) (model.Rule, error) {
+	r := model.Rule{}
+	row := p.db.QueryRow(ruleSelect+" WHERE ID = $1", id)
+	if err := scanRule(row, &r); err != nil {
+		return r, fmt.Errorf("GetRule.Scan.r -- %w", err)
+	}
+	return r, nil
+}
+
+func (p *Postgres) NewRule(r *model.Rule) (err error) {
+	defer logOp(p, "NewRule", &err)()
+	if err := validateRule(*r); err != nil {
+		return fmt.Errorf("NewRule.validateRule -- %w", err)
+	}
+
+	row := p.db.QueryRow("INSERT INTO r (priority,name,memoPattern,minAmount,maxAmount,accountID,fromDate,toDate,envelopeID,type,cleared,memo) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING ID",
+		r.Priority, r.Name, patternValue(*r), r.MinAmount, r.MaxAmount, r.AccountID, r.FromDate, r.ToDate, r.EnvelopeID, r.Typ, r.Cleared, r.Memo)
+	if err := row.Scan(&r.ID); err != nil {
+		return fmt.Errorf("NewRule.Insert.r.Scan -- %w", err)
+	}
+	return nil
+}
+
+// Rules only act on new batches, changing one leaves existing transactions alone
+func (p *Postgres) UpdateRule(r model.Rule) (err error) {
+	defer logOp(p, "UpdateRule", &err)()
+	if err := validateRule(r); err != nil {
+		return fmt.Errorf("UpdateRule.validateRule -- %w", err)
+	}
+
+	_, err = p.db.Exec("UPDATE r SET priority = $1, name = $2, memoPattern = $3, minAmount = $4, maxAmount = $5, accountID = $6, fromDate = $7, toDate = $8, envelopeID = $9, type = $10, cleared = $11, memo = $12 WHERE ID = $13",
+		r.Priority, r.Name, patternValue(r), r.MinAmount, r.MaxAmount, r.AccountID, r.FromDate, r.ToDate, r.EnvelopeID, r.Typ, r.Cleared, r.Memo, r.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateRule.Update.r -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteRule(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteRule", &err)()
+	_, err = p.db.Exec("DELETE FROM r WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteRule.Delete.r -- %w", err)
+	}
+	return nil
+}
+
+// Dry run of the rules over a batch, nothing is written
+func (p *Postgres) PreviewRules(ats []model.AccountTransaction) ([]RuleMatch, error) {
+	rs, err := loadRules(p.db)
+	if err != nil {
+		return nil, fmt.Errorf("PreviewRules.loadRules -- %w", err)
+	}
+	return previewRules(rs, ats), nil
+}
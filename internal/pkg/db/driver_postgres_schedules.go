@@ -0,0 +1,164 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+func (p *Postgres) GetSchedules() ([]model.Schedule, error) {
+	ss, err := selectSchedules(p.db, "")
+	if err != nil {
+		return nil, fmt.Errorf("GetSchedules.selectSchedules -- %w", err)
+	}
+	return ss, nil
+}
+
+func (p *Postgres) GetAccountSchedules(id model.PKEY) ([]model.Schedule, error) {
+	ss, err := selectSchedules(p.db, "WHERE accountID = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetAccountSchedules.selectSchedules -- %w", err)
+	}
+	return ss, nil
+}
+
+func (p *Postgres) GetSchedule(id model.PKEY) (model.Schedule, error) {
+	sc := model.Schedule{}
+	row := p.db.QueryRow(scheduleSelect+" WHERE ID = $1", id)
+	if err := scanSchedule(row, &sc); err != nil {
+		return sc, fmt.Errorf("GetSchedule.Scan.sch -- %w", err)
+	}
+	return sc, nil
+}
+
+func (p *Postgres) NewSchedule(sc *model.Schedule) (err error) {
+	defer logOp(p, "NewSchedule", &err)()
+	if err := validateSchedule(*sc); err != nil {
+		return fmt.Errorf("NewSchedule.validateSchedule -- %w", err)
+	}
+
+	row := p.db.QueryRow("INSERT INTO sch (accountID,envelopeID,payeeID,type,amount,memo,kind,n,startDate,endDate,lastDate) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING ID",
+		sc.AccountID, sc.EnvelopeID, sc.PayeeID, sc.Typ, sc.Amount, sc.Memo, sc.Kind, sc.N, sc.Start, sc.End, sc.Last)
+	if err := row.Scan(&sc.ID); err != nil {
+		return fmt.Errorf("NewSchedule.Insert.sch.Scan -- %w", err)
+	}
+	return nil
+}
+
+// Occurrences already entered or skipped stay that way, the new recurrence takes over after them
+func (p *Postgres) UpdateSchedule(sc model.Schedule) (err error) {
+	defer logOp(p, "UpdateSchedule", &err)()
+	if err := validateSchedule(sc); err != nil {
+		return fmt.Errorf("UpdateSchedule.validateSchedule -- %w", err)
+	}
+
+	_, err = p.db.Exec("UPDATE sch SET accountID = $1, envelopeID = $2, payeeID = $3, type = $4, amount = $5, memo = $6, kind = $7, n = $8, startDate = $9, endDate = $10 WHERE ID = $11",
+		sc.AccountID, sc.EnvelopeID, sc.PayeeID, sc.Typ, sc.Amount, sc.Memo, sc.Kind, sc.N, sc.Start, sc.End, sc.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateSchedule.Update.sch -- %w", err)
+	}
+	return nil
+}
+
+// Transactions it already entered are left alone
+func (p *Postgres) DeleteSchedule(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteSchedule", &err)()
+	_, err = p.db.Exec("DELETE FROM sch WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteSchedule.Delete.sch -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) SkipSchedule(id model.PKEY) (_ bcdate.BCDate, err error) {
+	defer logOp(p, "SkipSchedule", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	sc := model.Schedule{}
+	if err := scanSchedule(tx.QueryRow(scheduleSelect+" WHERE ID = $1", id), &sc); err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Scan.sch -- %w", err)
+	}
+	next := sc.Next()
+	if next == bcdate.Never() {
+		return 0, fmt.Errorf("SkipSchedule -- %w: no occurrences left", ErrInvalidSchedule)
+	}
+
+	if _, err := tx.Exec("UPDATE sch SET lastDate = $1 WHERE ID = $2", next, id); err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Update.sch -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Commit -- %w", err)
+	}
+	return next, nil
+}
+
+func (p *Postgres) RunSchedules(today bcdate.BCDate) (_ []model.AccountTransaction, _ []ScheduleFailure, err error) {
+	defer logOp(p, "RunSchedules", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	ss, err := selectSchedules(tx, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.selectSchedules -- %w", err)
+	}
+
+	entered := make([]model.AccountTransaction, 0)
+	failed := make([]ScheduleFailure, 0)
+	for _, sc := range ss {
+		ats, last := dueOccurrences(sc, today)
+		if len(ats) == 0 {
+			continue
+		}
+
+		// Each schedule in its own savepoint, one that fails is undone and the rest still go in
+		if _, err := tx.Exec("SAVEPOINT schedule"); err != nil {
+			return nil, nil, fmt.Errorf("RunSchedules.Savepoint -- %w", err)
+		}
+		if serr := p.enterSchedule(tx, sc.ID, ats, last); serr != nil {
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT schedule"); err != nil {
+				return nil, nil, fmt.Errorf("RunSchedules.RollbackTo -- %w", err)
+			}
+			failed = append(failed, ScheduleFailure{ScheduleID: sc.ID, Err: serr})
+		} else {
+			entered = append(entered, ats...)
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT schedule"); err != nil {
+			return nil, nil, fmt.Errorf("RunSchedules.Release -- %w", err)
+		}
+	}
+	if len(entered) == 0 {
+		return entered, failed, nil
+	}
+
+	oldest, aids, eids := scheduledTouches(entered)
+	if err := p.updateCheckpoints(tx, oldest, aids, eids); err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.updateCheckpoints -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.Commit -- %w", err)
+	}
+	return entered, failed, nil
+}
+
+// The due occurrences of one schedule and its new lastDate, the IDs are filled into ats
+func (p *Postgres) enterSchedule(tx *sql.Tx, id model.PKEY, ats []model.AccountTransaction, last bcdate.BCDate) error {
+	for i := range ats {
+		if err := p.insertAccountTransaction(tx, &ats[i]); err != nil {
+			return fmt.Errorf("enterSchedule.insertAccountTransaction -- %w", err)
+		}
+	}
+	if _, err := tx.Exec("UPDATE sch SET lastDate = $1 WHERE ID = $2", last, id); err != nil {
+		return fmt.Errorf("enterSchedule.Update.sch -- %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+// Envelopes a stored transaction draws on, through its own envelopeID or its splits
+func (p *Postgres) transactionEnvelopes(tx *sql.Tx, id model.PKEY) ([]model.PKEY, error) {
+	rows, err := tx.Query("SELECT envelopeID FROM a_t WHERE ID = $1 AND envelopeID IS NOT NULL UNION SELECT envelopeID FROM a_t_split WHERE transactionID = $1 AND envelopeID IS NOT NULL", id)
+	if err != nil {
+		return nil, fmt.Errorf("transactionEnvelopes.Select -- %w", err)
+	}
+	defer rows.Close()
+
+	eids := make([]model.PKEY, 0)
+	for rows.Next() {
+		var eid model.PKEY
+		if err := rows.Scan(&eid); err != nil {
+			return nil, fmt.Errorf("transactionEnvelopes.Scan -- %w", err)
+		}
+		eids = append(eids, eid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("transactionEnvelopes.Err -- %w", err)
+	}
+
+	return eids, nil
+}
+
+func (p *Postgres) insertSplits(tx *sql.Tx, at *model.AccountTransaction) error {
+	for i := range at.Splits {
+		sp := &at.Splits[i]
+		sp.TransactionID = at.ID
+
+		row := tx.QueryRow("INSERT INTO a_t_split (transactionID,envelopeID,amount,memo) VALUES ($1,$2,$3,$4) RETURNING ID", sp.TransactionID, sp.EnvelopeID, sp.Amount, sp.Memo)
+		if err := row.Scan(&sp.ID); err != nil {
+			return fmt.Errorf("insertSplits.Insert.a_t_split.Scan -- %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (p *Postgres) GetTransfer(id model.PKEY) (model.Transfer, error) {
+	t, err := scanTransfer(p.db.QueryRow(transferSelect+" WHERE x.ID = $1", id))
+	if err != nil {
+		return t, fmt.Errorf("GetTransfer.Scan -- %w", err)
+	}
+	return t, nil
+}
+
+func (p *Postgres) NewTransfer(t *model.Transfer) (err error) {
+	defer logOp(p, "NewTransfer", &err)()
+	if err := validateTransfer(*t); err != nil {
+		return fmt.Errorf("NewTransfer.validateTransfer -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("NewTransfer.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := p.newTransfer(tx, t); err != nil {
+		return fmt.Errorf("NewTransfer.newTransfer -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("NewTransfer.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) newTransfer(tx *sql.Tx, t *model.Transfer) error {
+	from, to, err := p.transferAccounts(tx, t.FromAccountID, t.ToAccountID)
+	if err != nil {
+		return fmt.Errorf("newTransfer.transferAccounts -- %w", err)
+	}
+	if err := transferOpen(from, to, t.PostDate); err != nil {
+		return fmt.Errorf("newTransfer -- %w", err)
+	}
+	if t.EnvelopeID, err = transferEnvelope(from, to, t.EnvelopeID); err != nil {
+		return fmt.Errorf("newTransfer.transferEnvelope -- %w", err)
+	}
+
+	fl, tl := transferLegs(*t)
+	for _, leg := range []struct {
+		at *model.AccountTransaction
+		id *model.PKEY
+	}{{&fl, &t.FromID}, {&tl, &t.ToID}} {
+		row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,memo) VALUES ($1,$2,$3,$4,$5,$6) RETURNING ID", leg.at.AccountID, leg.at.Typ, leg.at.EnvelopeID, leg.at.PostDate, leg.at.Amount, leg.at.Memo)
+		if err := row.Scan(leg.id); err != nil {
+			return fmt.Errorf("newTransfer.Insert.a_t.Scan -- %w", err)
+		}
+	}
+
+	row := tx.QueryRow("INSERT INTO a_t_transfer (fromID,toID) VALUES ($1,$2) RETURNING ID", t.FromID, t.ToID)
+	if err := row.Scan(&t.ID); err != nil {
+		return fmt.Errorf("newTransfer.Insert.a_t_transfer.Scan -- %w", err)
+	}
+
+	if err := p.updateCheckpoints(tx, t.PostDate, []model.PKEY{t.FromAccountID, t.ToAccountID}, fl.EnvelopeIDs()); err != nil {
+		return fmt.Errorf("newTransfer.updateCheckpoints -- %w", err)
+	}
+
+	return nil
+}
+
+// Rewrites both legs, leaving their cleared flags alone
+// The accounts cannot change, delete the transfer and make a new one instead
+func (p *Postgres) UpdateTransfer(t model.Transfer) (err error) {
+	defer logOp(p, "UpdateTransfer", &err)()
+	if err := validateTransfer(t); err != nil {
+		return fmt.Errorf("UpdateTransfer.validateTransfer -- %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateTransfer.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := scanTransfer(tx.QueryRow(transferSelect+" WHERE x.ID = $1", t.ID))
+	if err != nil {
+		return fmt.Errorf("UpdateTransfer.Select.a_t_transfer.Scan -- %w", err)
+	}
+	if old.FromAccountID != t.FromAccountID || old.ToAccountID != t.ToAccountID {
+		return fmt.Errorf("UpdateTransfer -- %w: accounts of transfer %d cannot be changed", ErrInvalidTransfer, t.ID)
+	}
+	t.FromID, t.ToID = old.FromID, old.ToID
+
+	from, to, err := p.transferAccounts(tx, t.FromAccountID, t.ToAccountID)
+	if err != nil {
+		return fmt.Errorf("UpdateTransfer.transferAccounts -- %w", err)
+	}
+	if err := transferOpen(from, to, t.PostDate); err != nil {
+		return fmt.Errorf("UpdateTransfer -- %w", err)
+	}
+	if t.EnvelopeID, err = transferEnvelope(from, to, t.EnvelopeID); err != nil {
+		return fmt.Errorf("UpdateTransfer.transferEnvelope -- %w", err)
+	}
+
+	fl, tl := transferLegs(t)
+	for _, leg := range []model.AccountTransaction{fl, tl} {
+		stored, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", leg.ID))
+		if err != nil {
+			return fmt.Errorf("UpdateTransfer.Select.a_t.Scan -- %w", err)
+		}
+		leg.Cleared = stored.Cleared
+		if err := checkLocked(stored, leg); err != nil {
+			return fmt.Errorf("UpdateTransfer -- %w", err)
+		}
+		_, err = tx.Exec("UPDATE a_t SET envelopeID = $1, postDate = $2, amount = $3, memo = $4 WHERE ID = $5", leg.EnvelopeID, leg.PostDate, leg.Amount, leg.Memo, leg.ID)
+		if err != nil {
+			return fmt.Errorf("UpdateTransfer.Update.a_t -- %w", err)
+		}
+	}
+
+	oldfl, _ := transferLegs(old)
+	if err := p.updateCheckpoints(tx, bcdate.Oldest(old.PostDate, t.PostDate), []model.PKEY{t.FromAccountID, t.ToAccountID}, unionIDs(fl.EnvelopeIDs(), oldfl.EnvelopeIDs())); err != nil {
+		return fmt.Errorf("UpdateTransfer.updateCheckpoints -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateTransfer.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) DeleteTransfer(id model.PKEY) (err error) {
+	defer logOp(p, "DeleteTransfer", &err)()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeleteTransfer.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := scanTransfer(tx.QueryRow(transferSelect+" WHERE x.ID = $1", id))
+	if err != nil {
+		return fmt.Errorf("DeleteTransfer.Select.a_t_transfer.Scan -- %w", err)
+	}
+	if err := p.deleteTransfer(tx, t); err != nil {
+		return fmt.Errorf("DeleteTransfer.deleteTransfer -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeleteTransfer.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Refuses while either leg is reconciled
+func (p *Postgres) deleteTransfer(tx *sql.Tx, t model.Transfer) error {
+	for _, id := range []model.PKEY{t.FromID, t.ToID} {
+		leg, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", id))
+		if err != nil {
+			return fmt.Errorf("deleteTransfer.Select.a_t.Scan -- %w", err)
+		}
+		if leg.Reconciled {
+			return fmt.Errorf("deleteTransfer -- %w: transaction %d", ErrReconciled, id)
+		}
+	}
+
+	_, err := tx.Exec("DELETE FROM a_t_transfer WHERE ID = $1", t.ID)
+	if err != nil {
+		return fmt.Errorf("deleteTransfer.Delete.a_t_transfer -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM a_t WHERE ID IN ($1,$2)", t.FromID, t.ToID)
+	if err != nil {
+		return fmt.Errorf("deleteTransfer.Delete.a_t -- %w", err)
+	}
+
+	fl, _ := transferLegs(t)
+	if err := p.updateCheckpoints(tx, t.PostDate, []model.PKEY{t.FromAccountID, t.ToAccountID}, fl.EnvelopeIDs()); err != nil {
+		return fmt.Errorf("deleteTransfer.updateCheckpoints -- %w", err)
+	}
+
+	return nil
+}
+
+// The transfer a stored transaction is a leg of, ok is false for any other transaction
+func (p *Postgres) legTransfer(tx *sql.Tx, atID model.PKEY) (t model.Transfer, ok bool, err error) {
+	t, err = scanTransfer(tx.QueryRow(transferSelect+" WHERE x.fromID = $1 OR x.toID = $1", atID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, false, nil
+	}
+	if err != nil {
+		return t, false, fmt.Errorf("legTransfer.Scan -- %w", err)
+	}
+	return t, true, nil
+}
+
+// Check an edited leg against its transfer and carry its date and amount over to the other leg
+// Fills in the From leg's envelope like NewTransfer, returns the other leg as it was
+func (p *Postgres) syncLeg(tx *sql.Tx, t model.Transfer, at *model.AccountTransaction) (legPeer, error) {
+	if err := validateLeg(t, *at); err != nil {
+		return legPeer{}, err
+	}
+
+	peer, peerAccount := t.FromID, t.FromAccountID
+	if at.ID == t.FromID {
+		peer, peerAccount = t.ToID, t.ToAccountID
+
+		from, to, err := p.transferAccounts(tx, t.FromAccountID, t.ToAccountID)
+		if err != nil {
+			return legPeer{}, fmt.Errorf("syncLeg.transferAccounts -- %w", err)
+		}
+		if at.EnvelopeID, err = transferEnvelope(from, to, at.EnvelopeID); err != nil {
+			return legPeer{}, fmt.Errorf("syncLeg.transferEnvelope -- %w", err)
+		}
+	}
+
+	peerAcct, err := p.transferAccount(tx, peerAccount)
+	if err != nil {
+		return legPeer{}, fmt.Errorf("syncLeg.transferAccount -- %w", err)
+	}
+	if err := checkOpen(peerAcct.closed, peerAccount, at.PostDate); err != nil {
+		return legPeer{}, err
+	}
+
+	stored, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = $1", peer))
+	if err != nil {
+		return legPeer{}, fmt.Errorf("syncLeg.Select.a_t.Scan -- %w", err)
+	}
+	eids, err := p.transactionEnvelopes(tx, peer)
+	if err != nil {
+		return legPeer{}, fmt.Errorf("syncLeg.transactionEnvelopes -- %w", err)
+	}
+	moved := stored
+	moved.PostDate, moved.Amount = at.PostDate, -at.Amount
+	if err := checkLocked(stored, moved); err != nil {
+		return legPeer{}, err
+	}
+
+	_, err = tx.Exec("UPDATE a_t SET postDate = $1, amount = $2 WHERE ID = $3", at.PostDate, -at.Amount, peer)
+	if err != nil {
+		return legPeer{}, fmt.Errorf("syncLeg.Update.a_t -- %w", err)
+	}
+
+	return legPeer{accountID: peerAccount, postDate: stored.PostDate, envelopeIDs: eids}, nil
+}
+
+func (p *Postgres) transferAccounts(tx *sql.Tx, fromID, toID model.PKEY) (from, to transferAccount, err error) {
+	if from, err = p.transferAccount(tx, fromID); err != nil {
+		return from, to, err
+	}
+	to, err = p.transferAccount(tx, toID)
+	return from, to, err
+}
+
+func (p *Postgres) transferAccount(tx *sql.Tx, id model.PKEY) (transferAccount, error) {
+	a := transferAccount{id: id}
+	row := tx.QueryRow("SELECT offbudget, debt, closed, (SELECT ID FROM e WHERE debtAccount = a.ID) FROM a WHERE ID = $1", id)
+	if err := row.Scan(&a.offbudget, &a.debt, &a.closed, &a.debtEnvelope); err != nil {
+		return a, fmt.Errorf("transferAccount.Scan.a -- %w", err)
+	}
+	return a, nil
+}
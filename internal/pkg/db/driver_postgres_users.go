@@ -0,0 +1,202 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"fmt"
+	"time"
+)
+
+func (p *Postgres) GetUsers() ([]model.User, error) {
+	us := make([]model.User, 0)
+
+	rows, err := p.db.Query(userSelect + " ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetUsers.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u model.User
+		if err := scanUser(rows, &u); err != nil {
+			return nil, fmt.Errorf("GetUsers.Scan -- %w", err)
+		}
+		us = append(us, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetUsers.Err -- %w", err)
+	}
+	return us, nil
+}
+
+func (p *Postgres) GetUser(id model.PKEY) (model.User, error) {
+	u := model.User{}
+	row := p.db.QueryRow(userSelect+" WHERE ID = $1", id)
+	if err := scanUser(row, &u); err != nil {
+		return u, fmt.Errorf("GetUser.Scan.usr -- %w", err)
+	}
+	return u, nil
+}
+
+func (p *Postgres) GetUserByName(name string) (model.User, error) {
+	u := model.User{}
+	row := p.db.QueryRow(userSelect+" WHERE name = $1", name)
+	if err := scanUser(row, &u); err != nil {
+		return u, fmt.Errorf("GetUserByName.Scan.usr -- %w", err)
+	}
+	return u, nil
+}
+
+func (p *Postgres) NewUser(u *model.User) error {
+	row := p.db.QueryRow("INSERT INTO usr (name,hash) VALUES ($1,$2) RETURNING ID", u.Name, u.Hash)
+	if err := row.Scan(&u.ID); err != nil {
+		return fmt.Errorf("NewUser.Insert.usr.Scan -- %w", err)
+	}
+	return nil
+}
+
+// A new hash deletes the user's sessions so the old password logs no one in
+func (p *Postgres) UpdateUser(u model.User) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("UpdateUser.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRow("SELECT hash FROM usr WHERE ID = $1", u.ID).Scan(&old); err != nil {
+		return fmt.Errorf("UpdateUser.Select.usr.Scan -- %w", err)
+	}
+	if _, err := tx.Exec("UPDATE usr SET name = $1, hash = $2 WHERE ID = $3", u.Name, u.Hash, u.ID); err != nil {
+		return fmt.Errorf("UpdateUser.Update.usr -- %w", err)
+	}
+	if u.Hash != old {
+		if _, err := tx.Exec("DELETE FROM sess WHERE userID = $1", u.ID); err != nil {
+			return fmt.Errorf("UpdateUser.Delete.sess -- %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpdateUser.Commit -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteUser(id model.PKEY) error {
+	_, err := p.db.Exec("DELETE FROM usr WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteUser.Delete.usr -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) GetTokens(userID model.PKEY) ([]model.Token, error) {
+	ts := make([]model.Token, 0)
+
+	rows, err := p.db.Query(tokenSelect+" WHERE userID = $1 ORDER BY ID ASC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("GetTokens.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t model.Token
+		if err := scanToken(rows, &t); err != nil {
+			return nil, fmt.Errorf("GetTokens.Scan -- %w", err)
+		}
+		ts = append(ts, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetTokens.Err -- %w", err)
+	}
+	return ts, nil
+}
+
+func (p *Postgres) GetTokenByHash(hash string) (model.Token, error) {
+	t := model.Token{}
+	row := p.db.QueryRow(tokenSelect+" WHERE hash = $1", hash)
+	if err := scanToken(row, &t); err != nil {
+		return t, fmt.Errorf("GetTokenByHash.Scan.usr_token -- %w", err)
+	}
+	return t, nil
+}
+
+func (p *Postgres) NewToken(t *model.Token) error {
+	row := p.db.QueryRow("INSERT INTO usr_token (userID,name,hash) VALUES ($1,$2,$3) RETURNING ID", t.UserID, t.Name, t.Hash)
+	if err := row.Scan(&t.ID); err != nil {
+		return fmt.Errorf("NewToken.Insert.usr_token.Scan -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteToken(id model.PKEY) error {
+	_, err := p.db.Exec("DELETE FROM usr_token WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteToken.Delete.usr_token -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) GetSessions(userID model.PKEY) ([]model.Session, error) {
+	ss := make([]model.Session, 0)
+
+	rows, err := p.db.Query(sessionSelect+" WHERE userID = $1 ORDER BY lastSeen DESC, ID DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("GetSessions.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sess model.Session
+		if err := scanSession(rows, &sess); err != nil {
+			return nil, fmt.Errorf("GetSessions.Scan -- %w", err)
+		}
+		ss = append(ss, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetSessions.Err -- %w", err)
+	}
+	return ss, nil
+}
+
+func (p *Postgres) GetSessionByHash(hash string) (model.Session, error) {
+	sess := model.Session{}
+	row := p.db.QueryRow(sessionSelect+" WHERE hash = $1", hash)
+	if err := scanSession(row, &sess); err != nil {
+		return sess, fmt.Errorf("GetSessionByHash.Scan.sess -- %w", err)
+	}
+	return sess, nil
+}
+
+func (p *Postgres) NewSession(sess *model.Session) error {
+	row := p.db.QueryRow("INSERT INTO sess (userID,hash,createdAt,lastSeen,qm,agent,addr) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING ID",
+		sess.UserID, sess.Hash, sess.Created.Unix(), sess.LastSeen.Unix(), sess.QM, sess.Agent, sess.Addr)
+	if err := row.Scan(&sess.ID); err != nil {
+		return fmt.Errorf("NewSession.Insert.sess.Scan -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) UpdateSession(sess model.Session) error {
+	_, err := p.db.Exec("UPDATE sess SET lastSeen = $1, qm = $2 WHERE ID = $3", sess.LastSeen.Unix(), sess.QM, sess.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateSession.Update.sess -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteSession(id model.PKEY) error {
+	_, err := p.db.Exec("DELETE FROM sess WHERE ID = $1", id)
+	if err != nil {
+		return fmt.Errorf("DeleteSession.Delete.sess -- %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) PruneSessions(seenBefore, createdBefore time.Time) (int, error) {
+	res, err := p.db.Exec("DELETE FROM sess WHERE lastSeen < $1 OR createdAt < $2", seenBefore.Unix(), createdBefore.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("PruneSessions.Delete.sess -- %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("PruneSessions.RowsAffected -- %w", err)
+	}
+	return int(n), nil
+}
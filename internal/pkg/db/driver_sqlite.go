@@ -3,6 +3,7 @@ package db
 import (
 	"budgeting/internal/pkg/bcdate"
 	"budgeting/internal/pkg/model"
+	"context"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
@@ -15,36 +16,44 @@ import (
 // Glue between our DB and the SQLite driver
 type SQLite struct {
 	db *sql.DB
+	// What Connect opened db with, for Rebuild's own pool
+	dsn string
+	// Who the audit log puts the writes down to, see WithActor
+	actor string
 }
 
 func NewSQLite() DB {
-	return &SQLite{nil}
+	return &SQLite{}
 }
 
 // TODO: Pass over all calls and queries to use NullXxx variables instead
 
-func (s *SQLite) Open(dbname string) error {
+func (s *SQLite) Connect(dbname string) error {
 	_, err := os.Stat(dbname)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
 
-	if err == nil || os.IsNotExist(err) {
-		s.db, err = sql.Open("sqlite3", dbname)
-		if err != nil {
-			return fmt.Errorf("failed to open db file: %w", err)
-		}
+	// Foreign keys are a per-connection setting, so ask for them in the DSN to cover the whole pool
+	s.dsn = dbname + "?_foreign_keys=on"
+	s.db, err = sql.Open("sqlite3", s.dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open db file: %w", err)
+	}
 
-		if os.IsNotExist(err) {
-			return s.Init()
-		}
+	return nil
+}
 
-		_, err = s.db.Exec("PRAGMA foreign_keys = ON")
-		if err != nil {
-			return fmt.Errorf("failed to enable foreign key handling: %w", err)
-		}
+func (s *SQLite) Open(dbname string) error {
+	if err := s.Connect(dbname); err != nil {
+		return err
+	}
 
-		return nil
-	} else {
-		return fmt.Errorf("failed to stat file: %w", err)
+	if _, err := s.Migrate(); err != nil {
+		return fmt.Errorf("failed to migrate db: %w", err)
 	}
+
+	return nil
 }
 
 func (s *SQLite) Init() error {
@@ -52,21 +61,46 @@ func (s *SQLite) Init() error {
 		return fmt.Errorf("cannot init DB before opening")
 	}
 
-	if _, err := s.db.Exec(string("PRAGMA foreign_keys = OFF")); err != nil {
+	ctx := context.Background()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting a connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
 		return fmt.Errorf("failed disabling foreign keys: %w", err)
 	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
 
-	query, err := ioutil.ReadFile("init/sqlite3.sql")
+	tables := make([]string, 0)
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
 	if err != nil {
-		return fmt.Errorf("failed reading DB setup file: %w", err)
+		return fmt.Errorf("failed listing tables: %w", err)
 	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed listing tables: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("failed listing tables: %w", err)
+	}
+	rows.Close()
 
-	if _, err := s.db.Exec(string(query)); err != nil {
-		return fmt.Errorf("failed running DB setup command: %w", err)
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, "DROP TABLE \""+table+"\""); err != nil {
+			return fmt.Errorf("failed dropping table %s: %w", table, err)
+		}
 	}
 
-	if _, err := s.db.Exec(string("PRAGMA foreign_keys = ON")); err != nil {
-		return fmt.Errorf("failed re-enabling foreign keys: %w", err)
+	if _, err := s.Migrate(); err != nil {
+		return fmt.Errorf("failed running DB migrations: %w", err)
 	}
 
 	return nil
@@ -114,6 +148,7 @@ func (s *SQLite) GetAccounts() ([]model.Account, error) {
 			&acct.Institution,
 			&acct.Name,
 			&acct.Class,
+			&acct.Closed,
 		); err != nil {
 			return nil, fmt.Errorf("GetAccounts.Scan -- %w", err)
 		}
@@ -135,13 +170,15 @@ func (s *SQLite) GetAccount(id model.PKEY) (model.Account, error) {
 		&a.Institution,
 		&a.Name,
 		&a.Class,
+		&a.Closed,
 	); err != nil {
 		return a, fmt.Errorf("GetAccount.Scan.a -- %w", err)
 	}
 
 	return a, nil
 }
-func (s *SQLite) NewAccount(a *model.Account) error {
+func (s *SQLite) NewAccount(a *model.Account) (err error) {
+	defer logOp(s, "NewAccount", &err)()
 	var id int
 
 	tx, err := s.db.Begin()
@@ -173,7 +210,8 @@ func (s *SQLite) NewAccount(a *model.Account) error {
 
 	return nil
 }
-func (s *SQLite) UpdateAccount(a model.Account) error {
+func (s *SQLite) UpdateAccount(a model.Account) (err error) {
+	defer logOp(s, "UpdateAccount", &err)()
 	var oldDebt bool
 
 	tx, err := s.db.Begin()
@@ -217,7 +255,8 @@ func (s *SQLite) UpdateAccount(a model.Account) error {
 
 	return nil
 }
-func (s *SQLite) DeleteAccount(id model.PKEY) error {
+func (s *SQLite) DeleteAccount(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteAccount", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("DeleteAccount.Begin-- %w", err)
@@ -231,7 +270,9 @@ func (s *SQLite) DeleteAccount(id model.PKEY) error {
 	}
 
 	if debt {
-		s.deleteDebtEnvelope(tx, id)
+		if err := s.deleteDebtEnvelope(tx, id); err != nil {
+			return fmt.Errorf("DeleteAccount.deleteDebtEnvelope -- %s", err.Error())
+		}
 	}
 
 	type atupdate struct {
@@ -239,7 +280,7 @@ func (s *SQLite) DeleteAccount(id model.PKEY) error {
 		envelopeID sql.NullInt32
 	}
 	atus := make([]atupdate, 0)
-	rows, err := s.db.Query("SELECT min(postDate) AS postDate, envelopeID FROM a_t GROUP BY envelopeID")
+	rows, err := tx.Query("SELECT min(postDate) AS postDate, envelopeID FROM (SELECT postDate, envelopeID FROM a_t WHERE accountID = ?1 UNION ALL SELECT a_t.postDate, a_t_split.envelopeID FROM a_t_split JOIN a_t ON a_t_split.transactionID = a_t.ID WHERE a_t.accountID = ?1) t GROUP BY envelopeID", id)
 	if err != nil {
 		return fmt.Errorf("DeleteAccount.Select.a_t -- %w", err)
 	}
@@ -260,13 +301,33 @@ func (s *SQLite) DeleteAccount(id model.PKEY) error {
 	}
 	rows.Close()
 
+	// Transfers with this account lose their link, the legs in other accounts stay as they were
+	_, err = tx.Exec("DELETE FROM a_t_transfer WHERE fromID IN (SELECT ID FROM a_t WHERE accountID = ?1) OR toID IN (SELECT ID FROM a_t WHERE accountID = ?1)", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a_t_transfer -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID IN (SELECT ID FROM a_t WHERE accountID = ?)", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.a_t_split -- %w", err)
+	}
 	_, err = tx.Exec("DELETE FROM a_t WHERE accountID = ?", id)
 	if err != nil {
 		return fmt.Errorf("DeleteAccount.Delete.a_t -- %w", err)
 	}
+	// Rules scoped to the account go with it, dropping the scope would let them loose on every account
+	_, err = tx.Exec("DELETE FROM r WHERE accountID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.r -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM sch WHERE accountID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccount.Delete.sch -- %w", err)
+	}
 
 	for _, atu := range atus {
-		s.updateEnvelopeSummaries(tx, atu.postdate, model.PKEY(atu.envelopeID.Int32))
+		if err := s.updateEnvelopeSummaries(tx, atu.postdate, model.PKEY(atu.envelopeID.Int32)); err != nil {
+			return fmt.Errorf("DeleteAccount.updateEnvelopeSummaries -- %w", err)
+		}
 	}
 
 	_, err = tx.Exec("DELETE FROM a_chk WHERE accountID = ?", id)
@@ -298,7 +359,8 @@ func (s *SQLite) GetStartingBalance(id model.PKEY) (int, error) {
 	}
 	return sbal, nil
 }
-func (s *SQLite) SetStartingBalance(id model.PKEY, balance int) error {
+func (s *SQLite) SetStartingBalance(id model.PKEY, balance int) (err error) {
+	defer logOp(s, "SetStartingBalance", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("SetStartingBalance.Begin-- %w", err)
@@ -359,7 +421,8 @@ func (s *SQLite) GetEnvelopeGroup(id model.PKEY) (model.EnvelopeGroup, error) {
 	}
 	return eg, nil
 }
-func (s *SQLite) NewEnvelopeGroup(eg *model.EnvelopeGroup) error {
+func (s *SQLite) NewEnvelopeGroup(eg *model.EnvelopeGroup) (err error) {
+	defer logOp(s, "NewEnvelopeGroup", &err)()
 	var eid int
 	row := s.db.QueryRow("INSERT INTO e_grp (name,sort) VALUES (?,?) RETURNING ID", eg.Name, eg.Sort)
 	if err := row.Scan(&eid); err != nil {
@@ -368,23 +431,25 @@ func (s *SQLite) NewEnvelopeGroup(eg *model.EnvelopeGroup) error {
 	eg.ID = model.PKEY(eid)
 	return nil
 }
-func (s *SQLite) UpdateEnvelopeGroup(eg model.EnvelopeGroup) error {
-	_, err := s.db.Exec("UPDATE e_grp SET name = ?, sort = ? WHERE ID = ?", eg.Name, eg.Sort, eg.ID)
+func (s *SQLite) UpdateEnvelopeGroup(eg model.EnvelopeGroup) (err error) {
+	defer logOp(s, "UpdateEnvelopeGroup", &err)()
+	_, err = s.db.Exec("UPDATE e_grp SET name = ?, sort = ? WHERE ID = ?", eg.Name, eg.Sort, eg.ID)
 	if err != nil {
 		return fmt.Errorf("UpdateEnvelopeGroup.Update.e_grp -- %w", err)
 	}
 	return nil
 }
-func (s *SQLite) DeleteEnvelopeGroup(id model.PKEY) error {
+func (s *SQLite) DeleteEnvelopeGroup(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteEnvelopeGroup", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("DeleteEnvelopeGroup.Begin-- %w", err)
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("UPDATE e SET groupID = ? WHERE envelopeID = ?", 1, id)
+	_, err = tx.Exec("UPDATE e SET groupID = ? WHERE groupID = ?", 1, id)
 	if err != nil {
-		return fmt.Errorf("DeleteEnvelopeGroup.Update.a_t -- %w", err)
+		return fmt.Errorf("DeleteEnvelopeGroup.Update.e -- %w", err)
 	}
 
 	_, err = tx.Exec("DELETE FROM e_grp WHERE ID = ?", id)
@@ -502,7 +567,8 @@ func (s *SQLite) GetEnvelope(id model.PKEY) (model.Envelope, error) {
 	}
 	return e, nil
 }
-func (s *SQLite) NewEnvelope(e *model.Envelope) error {
+func (s *SQLite) NewEnvelope(e *model.Envelope) (err error) {
+	defer logOp(s, "NewEnvelope", &err)()
 	var id int
 
 	tx, err := s.db.Begin()
@@ -528,7 +594,8 @@ func (s *SQLite) NewEnvelope(e *model.Envelope) error {
 
 	return nil
 }
-func (s *SQLite) UpdateEnvelope(e model.Envelope) error {
+func (s *SQLite) UpdateEnvelope(e model.Envelope) (err error) {
+	defer logOp(s, "UpdateEnvelope", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("UpdateEnvelope.Begin-- %w", err)
@@ -546,7 +613,8 @@ func (s *SQLite) UpdateEnvelope(e model.Envelope) error {
 
 	return nil
 }
-func (s *SQLite) DeleteEnvelope(id model.PKEY) error {
+func (s *SQLite) DeleteEnvelope(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteEnvelope", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("DeleteEnvelope.Begin-- %w", err)
@@ -558,6 +626,23 @@ func (s *SQLite) DeleteEnvelope(id model.PKEY) error {
 		return fmt.Errorf("DeleteEnvelope.Update.a_t -- %w", err)
 	}
 
+	_, err = tx.Exec("UPDATE a_t_split SET envelopeID = NULL WHERE envelopeID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.a_t_split -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE p SET envelopeID = NULL WHERE envelopeID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.p -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE r SET envelopeID = NULL WHERE envelopeID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.r -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE sch SET envelopeID = NULL WHERE envelopeID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEnvelope.Update.sch -- %w", err)
+	}
+
 	_, err = tx.Exec("DELETE FROM e_t WHERE envelopeID = ?", id)
 	if err != nil {
 		return fmt.Errorf("DeleteEnvelope.Delete.e_t -- %w", err)
@@ -603,6 +688,9 @@ func (s *SQLite) GetAllTransactions(month bcdate.BCDate) ([]model.AccountTransac
 			&at.Amount,
 			&at.Cleared,
 			&at.Memo,
+			&at.PayeeID,
+			&at.ImportID,
+			&at.Reconciled,
 		); err != nil {
 			return nil, fmt.Errorf("GetAllTransactions.Scan -- %w", err)
 		}
@@ -611,6 +699,12 @@ func (s *SQLite) GetAllTransactions(month bcdate.BCDate) ([]model.AccountTransac
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("GetAllTransactions.Err -- %w", err)
 	}
+	if err := attachSplits(s.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllTransactions.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(s.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllTransactions.attachTransfers -- %w", err)
+	}
 	return ats, nil
 }
 
@@ -633,6 +727,9 @@ func (s *SQLite) GetAllAccountTransactions(id model.PKEY) ([]model.AccountTransa
 			&at.Amount,
 			&at.Cleared,
 			&at.Memo,
+			&at.PayeeID,
+			&at.ImportID,
+			&at.Reconciled,
 		); err != nil {
 			return nil, fmt.Errorf("GetAllTransactions.Scan -- %w", err)
 		}
@@ -641,6 +738,12 @@ func (s *SQLite) GetAllAccountTransactions(id model.PKEY) ([]model.AccountTransa
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("GetAllTransactions.Err -- %w", err)
 	}
+	if err := attachSplits(s.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllAccountTransactions.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(s.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAllAccountTransactions.attachTransfers -- %w", err)
+	}
 	return ats, nil
 }
 func (s *SQLite) GetAccountTransactions(month bcdate.BCDate, id model.PKEY) ([]model.AccountTransaction, error) {
@@ -662,6 +765,9 @@ func (s *SQLite) GetAccountTransactions(month bcdate.BCDate, id model.PKEY) ([]m
 			&at.Amount,
 			&at.Cleared,
 			&at.Memo,
+			&at.PayeeID,
+			&at.ImportID,
+			&at.Reconciled,
 		); err != nil {
 			return nil, fmt.Errorf("GetAllTransactions.Scan -- %w", err)
 		}
@@ -670,25 +776,62 @@ func (s *SQLite) GetAccountTransactions(month bcdate.BCDate, id model.PKEY) ([]m
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("GetAllTransactions.Err -- %w", err)
 	}
+	if err := attachSplits(s.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAccountTransactions.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(s.db, ats); err != nil {
+		return nil, fmt.Errorf("GetAccountTransactions.attachTransfers -- %w", err)
+	}
 	return ats, nil
 }
 
-func (s *SQLite) NewAccountTransaction(at *model.AccountTransaction) error {
-	var atid int
+func (s *SQLite) GetAccountTransaction(id model.PKEY) (model.AccountTransaction, error) {
+	at := model.AccountTransaction{}
+	row := s.db.QueryRow("SELECT * FROM a_t WHERE ID = ?", id)
+	if err := row.Scan(
+		&at.ID,
+		&at.AccountID,
+		&at.Typ,
+		&at.EnvelopeID,
+		&at.PostDate,
+		&at.Amount,
+		&at.Cleared,
+		&at.Memo,
+		&at.PayeeID,
+		&at.ImportID,
+		&at.Reconciled,
+	); err != nil {
+		return at, fmt.Errorf("GetAccountTransaction.Scan -- %w", err)
+	}
+
+	ats := []model.AccountTransaction{at}
+	if err := attachSplits(s.db, ats); err != nil {
+		return at, fmt.Errorf("GetAccountTransaction.attachSplits -- %w", err)
+	}
+	if err := attachTransfers(s.db, ats); err != nil {
+		return at, fmt.Errorf("GetAccountTransaction.attachTransfers -- %w", err)
+	}
+	return ats[0], nil
+}
+
+func (s *SQLite) NewAccountTransaction(at *model.AccountTransaction) (err error) {
+	defer logOp(s, "NewAccountTransaction", &err)()
+	if err := validateSplits(*at); err != nil {
+		return fmt.Errorf("NewAccountTransaction.validateSplits -- %w", err)
+	}
+
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("NewAccountTransaction.Begin -- %w", err)
 	}
 	defer tx.Rollback()
 
-	row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,cleared,memo) VALUES (?,?,?,?,?,?,?) RETURNING ID", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo)
-	if err := row.Scan(&atid); err != nil {
-		return fmt.Errorf("NewAccountTransaction.Insert.a_t.Scan -- %w", err)
+	if err := s.insertAccountTransaction(tx, at); err != nil {
+		return fmt.Errorf("NewAccountTransaction.insertAccountTransaction -- %w", err)
 	}
-	at.ID = model.PKEY(atid)
 
-	if at.EnvelopeID.Valid {
-		if err := s.updateEnvelopeSummaries(tx, at.PostDate, model.PKEY(at.EnvelopeID.Int32)); err != nil {
+	for _, eid := range at.EnvelopeIDs() {
+		if err := s.updateEnvelopeSummaries(tx, at.PostDate, eid); err != nil {
 			return fmt.Errorf("NewAccountTransaction.updateEnvelopeSummaries -- %w", err)
 		}
 	}
@@ -705,40 +848,101 @@ func (s *SQLite) NewAccountTransaction(at *model.AccountTransaction) error {
 
 	return nil
 }
-func (s *SQLite) UpdateAccountTransaction(at model.AccountTransaction) error {
+
+// The row and its splits, with the payee's envelope filled in, checkpoints are left to the caller
+func (s *SQLite) insertAccountTransaction(tx *sql.Tx, at *model.AccountTransaction) error {
+	if err := s.accountOpen(tx, at.AccountID, at.PostDate); err != nil {
+		return fmt.Errorf("insertAccountTransaction.accountOpen -- %w", err)
+	}
+	if err := s.applyPayeeDefault(tx, at); err != nil {
+		return fmt.Errorf("insertAccountTransaction.applyPayeeDefault -- %w", err)
+	}
+
+	var atid int
+	row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,cleared,memo,payeeID,importID) VALUES (?,?,?,?,?,?,?,?,?) RETURNING ID", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.PayeeID, at.ImportID)
+	if err := row.Scan(&atid); err != nil {
+		return fmt.Errorf("insertAccountTransaction.Insert.a_t.Scan -- %w", err)
+	}
+	at.ID = model.PKEY(atid)
+
+	if err := s.insertSplits(tx, at); err != nil {
+		return fmt.Errorf("insertAccountTransaction.insertSplits -- %w", err)
+	}
+	return nil
+}
+func (s *SQLite) UpdateAccountTransaction(at model.AccountTransaction) (err error) {
+	defer logOp(s, "UpdateAccountTransaction", &err)()
+	if err := validateSplits(at); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.validateSplits -- %w", err)
+	}
+
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("NewAccountTransaction.Begin -- %w", err)
 	}
 	defer tx.Rollback()
 
-	var oldest bcdate.BCDate
-	var oldeid sql.NullInt32
-	row := tx.QueryRow("SELECT postDate, envelopeID FROM a_t WHERE ID = ?", at.ID)
-	if err := row.Scan(&oldest, &oldeid); err != nil {
+	old, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = ?", at.ID))
+	if err != nil {
 		return fmt.Errorf("NewAccountTransaction.Select.a_t.Scan -- %w", err)
 	}
+	if err := checkLocked(old, at); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction -- %w", err)
+	}
+	// Another account is MoveAccountTransaction's job
+	if old.AccountID != at.AccountID {
+		return fmt.Errorf("UpdateAccountTransaction -- %w: transaction %d stays on account %d, move it instead", ErrInvalidMove, at.ID, old.AccountID)
+	}
+	if err := s.accountOpen(tx, at.AccountID, at.PostDate); err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.accountOpen -- %w", err)
+	}
+	oldest := old.PostDate
+	oldeids, err := s.transactionEnvelopes(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("NewAccountTransaction.transactionEnvelopes -- %w", err)
+	}
 	oldest = bcdate.Oldest(oldest, at.PostDate)
 
-	_, err = tx.Exec("UPDATE a_t SET accountID = ?, type = ?, envelopeID = ?, postDate = ?, amount = ?, cleared = ?, memo = ? WHERE ID = ?", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.ID)
+	// A transfer leg takes its other leg along
+	var peer legPeer
+	t, leg, err := s.legTransfer(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccountTransaction.legTransfer -- %w", err)
+	}
+	if leg {
+		if peer, err = s.syncLeg(tx, t, &at); err != nil {
+			return fmt.Errorf("UpdateAccountTransaction.syncLeg -- %w", err)
+		}
+		oldest = bcdate.Oldest(oldest, peer.postDate)
+	}
+
+	_, err = tx.Exec("UPDATE a_t SET accountID = ?, type = ?, envelopeID = ?, postDate = ?, amount = ?, cleared = ?, memo = ?, payeeID = ? WHERE ID = ?", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.PayeeID, at.ID)
 	if err != nil {
 		return fmt.Errorf("NewAccountTransaction.Update.a_t -- %w", err)
 	}
 
-	if at.EnvelopeID.Valid && (!oldeid.Valid || oldeid.Int32 != at.EnvelopeID.Int32) {
-		if err := s.updateEnvelopeSummaries(tx, oldest, model.PKEY(at.EnvelopeID.Int32)); err != nil {
-			return fmt.Errorf("NewAccountTransaction.updateEnvelopeSummaries -- %w", err)
-		}
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID = ?", at.ID)
+	if err != nil {
+		return fmt.Errorf("NewAccountTransaction.Delete.a_t_split -- %w", err)
 	}
-	if oldeid.Valid && (!at.EnvelopeID.Valid || oldeid.Int32 != at.EnvelopeID.Int32) {
-		if err := s.updateEnvelopeSummaries(tx, oldest, model.PKEY(oldeid.Int32)); err != nil {
-			return fmt.Errorf("NewAccountTransaction.updateEnvelopeSummaries.oldeid -- %w", err)
+	if err := s.insertSplits(tx, &at); err != nil {
+		return fmt.Errorf("NewAccountTransaction.insertSplits -- %w", err)
+	}
+
+	for _, eid := range unionIDs(at.EnvelopeIDs(), oldeids, peer.envelopeIDs) {
+		if err := s.updateEnvelopeSummaries(tx, oldest, eid); err != nil {
+			return fmt.Errorf("NewAccountTransaction.updateEnvelopeSummaries -- %w", err)
 		}
 	}
 
 	if err := s.updateAccountSummaries(tx, oldest, at.AccountID); err != nil {
 		return fmt.Errorf("NewAccountTransaction.updateAccountSummaries -- %w", err)
 	}
+	if leg {
+		if err := s.updateAccountSummaries(tx, oldest, peer.accountID); err != nil {
+			return fmt.Errorf("UpdateAccountTransaction.updateAccountSummaries -- %w", err)
+		}
+	}
 	if err := s.updateSummaries(tx, oldest); err != nil {
 		return fmt.Errorf("DeleteAccountTransaction.updateSummaries -- %w", err)
 	}
@@ -749,28 +953,118 @@ func (s *SQLite) UpdateAccountTransaction(at model.AccountTransaction) error {
 
 	return nil
 }
-func (s *SQLite) DeleteAccountTransaction(id model.PKEY) error {
+
+// Removes the transaction from its account and enters it on at.AccountID, at.ID is set to the new ID
+func (s *SQLite) MoveAccountTransaction(at *model.AccountTransaction) (err error) {
+	defer logOp(s, "MoveAccountTransaction", &err)()
+	if err := validateSplits(*at); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.validateSplits -- %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = ?", at.ID))
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Select.a_t.Scan -- %w", err)
+	}
+	_, leg, err := s.legTransfer(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.legTransfer -- %w", err)
+	}
+	if err := validateMove(old, *at, leg); err != nil {
+		return fmt.Errorf("MoveAccountTransaction -- %w", err)
+	}
+	oldeids, err := s.transactionEnvelopes(tx, at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.transactionEnvelopes -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID = ?", at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Delete.a_t_split -- %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM a_t WHERE ID = ?", at.ID)
+	if err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Delete.a_t -- %w", err)
+	}
+
+	if err := s.insertAccountTransaction(tx, at); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.insertAccountTransaction -- %w", err)
+	}
+
+	oldest := bcdate.Oldest(old.PostDate, at.PostDate)
+	for _, eid := range unionIDs(at.EnvelopeIDs(), oldeids) {
+		if err := s.updateEnvelopeSummaries(tx, oldest, eid); err != nil {
+			return fmt.Errorf("MoveAccountTransaction.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	for _, aid := range []model.PKEY{old.AccountID, at.AccountID} {
+		if err := s.updateAccountSummaries(tx, oldest, aid); err != nil {
+			return fmt.Errorf("MoveAccountTransaction.updateAccountSummaries -- %w", err)
+		}
+	}
+	if err := s.updateSummaries(tx, oldest); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.updateSummaries -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("MoveAccountTransaction.Commit -- %w", err)
+	}
+
+	return nil
+}
+func (s *SQLite) DeleteAccountTransaction(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteAccountTransaction", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("DeleteAccountTransaction.Begin -- %w", err)
 	}
 	defer tx.Rollback()
 
-	var eid sql.NullInt32
-	var aid model.PKEY
-	var postdate bcdate.BCDate
-	row := tx.QueryRow("SELECT envelopeID, accountID, postDate FROM a_t WHERE ID = ?", id)
-	if err := row.Scan(&eid, &aid, &postdate); err != nil {
+	// Deleting either leg of a transfer deletes the whole transfer
+	t, leg, err := s.legTransfer(tx, id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.legTransfer -- %w", err)
+	}
+	if leg {
+		if err := s.deleteTransfer(tx, t); err != nil {
+			return fmt.Errorf("DeleteAccountTransaction.deleteTransfer -- %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("DeleteAccountTransaction.Commit -- %w", err)
+		}
+		return nil
+	}
+
+	old, err := scanLock(tx.QueryRow(lockSelect+" WHERE ID = ?", id))
+	if err != nil {
 		return fmt.Errorf("DeleteAccountTransaction.Select.a_t.Scan -- %w", err)
 	}
+	if old.Reconciled {
+		return fmt.Errorf("DeleteAccountTransaction -- %w: transaction %d", ErrReconciled, id)
+	}
+	aid, postdate := old.AccountID, old.PostDate
+	eids, err := s.transactionEnvelopes(tx, id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.transactionEnvelopes -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM a_t_split WHERE transactionID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteAccountTransaction.Delete.a_t_split -- %w", err)
+	}
 
 	_, err = tx.Exec("DELETE FROM a_t WHERE ID = ?", id)
 	if err != nil {
 		return fmt.Errorf("DeleteAccountTransaction.Update.a_t -- %w", err)
 	}
 
-	if eid.Valid {
-		if err := s.updateEnvelopeSummaries(tx, postdate, model.PKEY(eid.Int32)); err != nil {
+	for _, eid := range eids {
+		if err := s.updateEnvelopeSummaries(tx, postdate, eid); err != nil {
 			return fmt.Errorf("DeleteAccountTransaction.updateEnvelopeSummaries -- %w", err)
 		}
 	}
@@ -840,7 +1134,22 @@ func (s *SQLite) GetEnvelopeTransactions(month bcdate.BCDate, id model.PKEY) ([]
 	return ets, nil
 }
 
-func (s *SQLite) NewEnvelopeTransaction(et *model.EnvelopeTransaction) error {
+func (s *SQLite) GetEnvelopeTransaction(id model.PKEY) (model.EnvelopeTransaction, error) {
+	et := model.EnvelopeTransaction{}
+	row := s.db.QueryRow("SELECT * FROM e_t WHERE ID = ?", id)
+	if err := row.Scan(
+		&et.ID,
+		&et.EnvelopeID,
+		&et.PostDate,
+		&et.Amount,
+	); err != nil {
+		return et, fmt.Errorf("GetEnvelopeTransaction.Scan -- %w", err)
+	}
+	return et, nil
+}
+
+func (s *SQLite) NewEnvelopeTransaction(et *model.EnvelopeTransaction) (err error) {
+	defer logOp(s, "NewEnvelopeTransaction", &err)()
 	var etid int
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -868,7 +1177,8 @@ func (s *SQLite) NewEnvelopeTransaction(et *model.EnvelopeTransaction) error {
 
 	return nil
 }
-func (s *SQLite) UpdateEnvelopeTransaction(et model.EnvelopeTransaction) error {
+func (s *SQLite) UpdateEnvelopeTransaction(et model.EnvelopeTransaction) (err error) {
+	defer logOp(s, "UpdateEnvelopeTransaction", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("UpdateEnvelopeTransaction.Begin -- %w", err)
@@ -901,7 +1211,8 @@ func (s *SQLite) UpdateEnvelopeTransaction(et model.EnvelopeTransaction) error {
 
 	return nil
 }
-func (s *SQLite) DeleteEnvelopeTransaction(id model.PKEY) error {
+func (s *SQLite) DeleteEnvelopeTransaction(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteEnvelopeTransaction", &err)()
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("DeleteEnvelopeTransaction.Begin -- %w", err)
@@ -984,6 +1295,22 @@ func (s *SQLite) GetOverallSummary(month bcdate.BCDate) (model.Summary, error) {
 	return summ, nil
 }
 
+// Verify the sanity invariants, read in one transaction so the tables agree with each other
+func (s *SQLite) Check() ([]Violation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Check.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	vs, err := checkConsistency(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Check.checkConsistency -- %w", err)
+	}
+
+	return vs, nil
+}
+
 func (s *SQLite) newDebtEnvelope(tx *sql.Tx, aID model.PKEY, eName string) error {
 	var eid int
 
@@ -1014,413 +1341,38 @@ func (s *SQLite) deleteDebtEnvelope(tx *sql.Tx, aID model.PKEY) error {
 		return fmt.Errorf("deleteDebtEnvelope.Select.e.Scan -- %w", err)
 	}
 
-	_, err := tx.Exec("DELETE FROM e WHERE ID = ?", eid)
+	// Clear out everything pointing at the envelope first, or the foreign keys reject the delete
+	_, err := tx.Exec("UPDATE a_t SET envelopeID = NULL WHERE envelopeID = ?", eid)
 	if err != nil {
-		return fmt.Errorf("deleteDebtEnvelope.Delete.e -- %w", err)
+		return fmt.Errorf("deleteDebtEnvelope.Update.a_t -- %w", err)
 	}
-	_, err = tx.Exec("DELETE FROM e_chk WHERE envelopeID = ?", eid)
+	_, err = tx.Exec("UPDATE a_t_split SET envelopeID = NULL WHERE envelopeID = ?", eid)
 	if err != nil {
-		return fmt.Errorf("deleteDebtEnvelope.Delete.e_chk -- %w", err)
-	}
-
-	return nil
-}
-
-func (s *SQLite) updateAccountSummaries(tx *sql.Tx, start bcdate.BCDate, aID model.PKEY) error {
-	oldest := start
-	if oldest == bcdate.Epoch() {
-		oldest = bcdate.Never()
-	}
-	oldest = oldest - (oldest % 100)
-
-	var oldest_t sql.NullInt32
-	var oldest_m bcdate.BCDate
-	err := tx.QueryRow("SELECT min(postDate) FROM a_t WHERE accountID = ? AND postDate >= ?", aID, start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateAccountSummaries.Select.a_t.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-	err = tx.QueryRow("SELECT min(month) FROM a_chk WHERE accountID = ? AND month >= ? AND month > 0", aID, start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateAccountSummaries.Select.a_chk.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-
-	if oldest == bcdate.Never() {
-		return nil
-	}
-
-	var latest bcdate.BCDate = bcdate.CurrentMonth()
-	var latest_t sql.NullInt32
-	var latest_m bcdate.BCDate
-	err = tx.QueryRow("SELECT max(postDate) FROM a_t WHERE accountID = ?", aID).Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateAccountSummaries.Select.a_t.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-	err = tx.QueryRow("SELECT max(month) FROM a_chk WHERE accountID = ? AND month > 0", aID).Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateAccountSummaries.Select.a_chk.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-
-	for ; oldest <= latest; oldest = oldest.NextMonth() {
-
-		var lastbal int
-		var bal int
-		var in int
-		var out int
-		var uncleared int
-
-		err := tx.QueryRow("SELECT bal FROM a_chk WHERE accountID = ? AND month < ? ORDER BY month DESC LIMIT 1", aID, oldest).Scan(&lastbal)
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("updateAccountSummaries.Select.a_chk.lastbal -- %w", err)
-		}
-
-		err = tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE accountID = ? AND postDate-mod(postDate,100) = ? AND amount > 0", aID, oldest).Scan(&in)
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("updateAccountSummaries.Select.a_t.in -- %w", err)
-		}
-
-		err = tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE accountID = ? AND postDate-mod(postDate,100) = ? AND amount < 0", aID, oldest).Scan(&out)
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("updateAccountSummaries.Select.a_t.out -- %w", err)
-		}
-		bal = lastbal + in + out
-
-		err = tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE accountID = ? AND postDate-mod(postDate,100) = ? AND cleared = 0", aID, oldest).Scan(&uncleared)
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("updateAccountSummaries.Select.a_t.uncleared -- %w", err)
-		}
-
-		_, err = tx.Exec("INSERT OR REPLACE INTO a_chk (accountID,month,bal,\"in\",out,uncleared) VALUES (?,?,?,?,?,?)", aID, oldest, bal, in, out, uncleared)
-		if err != nil {
-			return fmt.Errorf("updateAccountSummaries.Replace.a_chk -- %w", err)
-		}
-
-	}
-
-	return nil
-}
-func (s *SQLite) updateEnvelopeSummaries(tx *sql.Tx, start bcdate.BCDate, eID model.PKEY) error {
-	oldest := start
-	if oldest == bcdate.Epoch() {
-		oldest = bcdate.Never()
-	}
-	oldest = oldest - (oldest % 100)
-
-	var oldest_t sql.NullInt32
-	var oldest_m bcdate.BCDate
-	err := tx.QueryRow("SELECT min(postDate) FROM a_t WHERE envelopeID = ? AND postDate >= ?", eID, start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateEnvelopeSummaries.Select.a_t.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-	err = tx.QueryRow("SELECT min(postDate) FROM e_t WHERE envelopeID = ? AND postDate >= ?", eID, start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateEnvelopeSummaries.Select.e_t.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-	err = tx.QueryRow("SELECT min(month) FROM e_chk WHERE envelopeID = ? AND month >= ? AND month > 0", eID, start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateEnvelopeSummaries.Select.e_chk.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-
-	if oldest == bcdate.Never() {
-		return nil
-	}
-
-	var latest bcdate.BCDate = bcdate.CurrentMonth()
-	var latest_t sql.NullInt32
-	var latest_m bcdate.BCDate
-	err = tx.QueryRow("SELECT max(postDate) FROM a_t WHERE envelopeID = ?", eID).Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateEnvelopeSummaries.Select.a_t.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-	err = tx.QueryRow("SELECT max(postDate) FROM e_t WHERE envelopeID = ?", eID).Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateEnvelopeSummaries.Select.e_t.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-	err = tx.QueryRow("SELECT max(month) FROM e_chk WHERE envelopeID = ? AND month > 0", eID).Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateEnvelopeSummaries.Select.e_chk.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-
-	for ; oldest <= latest; oldest = oldest.NextMonth() {
-
-		var lastbal int
-		var bal int
-		var in int
-		var in_a int
-		var out int
-		var out_a int
-
-		if err := tx.QueryRow("SELECT bal FROM e_chk WHERE envelopeID = ? AND month < ? ORDER BY month DESC LIMIT 1", eID, oldest).Scan(&lastbal); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateEnvelopeSummaries.Select.e_chk.lastbal -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount > 0", eID, oldest).Scan(&in_a); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateEnvelopeSummaries.Select.a_t.in -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount < 0", eID, oldest).Scan(&out_a); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateEnvelopeSummaries.Select.a_t.out -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM e_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount > 0", eID, oldest).Scan(&in); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateEnvelopeSummaries.Select.e_t.in -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM e_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount < 0", eID, oldest).Scan(&out); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateEnvelopeSummaries.Select.e_t.out -- %w", err)
-			}
-		}
-		bal = lastbal + in_a + out_a + in + out
-
-		_, err = tx.Exec("INSERT OR REPLACE INTO e_chk (envelopeID,month,bal,\"in\",out) VALUES (?,?,?,?,?)", eID, oldest, bal, in+out, in_a+out_a)
-		if err != nil {
-			return fmt.Errorf("updateEnvelopeSummaries.Replace.e_chk -- %w", err)
-		}
-
-	}
-
-	return nil
-}
-func (s *SQLite) updateSummaries(tx *sql.Tx, start bcdate.BCDate) error {
-	oldest := start
-	if oldest == bcdate.Epoch() {
-		oldest = bcdate.Never()
-	}
-	oldest = oldest - (oldest % 100)
-
-	var oldest_t sql.NullInt32
-	var oldest_m bcdate.BCDate
-	err := tx.QueryRow("SELECT min(postDate) FROM a_t WHERE postDate >= ?", start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.a_t.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-
-	err = tx.QueryRow("SELECT min(postDate) FROM e_t WHERE postDate >= ?", start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.e_t.Min -- %w", err)
+		return fmt.Errorf("deleteDebtEnvelope.Update.a_t_split -- %w", err)
 	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-	err = tx.QueryRow("SELECT min(month) FROM a_chk WHERE month >= ? AND month > 0", start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.a_chk.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-	err = tx.QueryRow("SELECT min(month) FROM e_chk WHERE month >= ? AND month > 0", start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.e_chk.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-	err = tx.QueryRow("SELECT min(month) FROM s_chk WHERE month >= ? AND month > 0", start).Scan(&oldest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.s_chk.Min -- %w", err)
-	}
-	if oldest_t.Valid {
-		oldest_m = bcdate.BCDate(oldest_t.Int32)
-		oldest_m = oldest_m - (oldest_m % 100)
-
-		oldest = bcdate.Oldest(oldest, oldest_m)
-	}
-
-	if oldest == bcdate.Never() {
-		return nil
-	}
-
-	var latest bcdate.BCDate = bcdate.CurrentMonth()
-	var latest_t sql.NullInt32
-	var latest_m bcdate.BCDate
-	err = tx.QueryRow("SELECT max(postDate) FROM a_t").Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.a_t.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-	err = tx.QueryRow("SELECT max(postDate) FROM e_t").Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.e_t.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
-	}
-	err = tx.QueryRow("SELECT max(month) FROM e_chk WHERE month > 0").Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.e_chk.Max -- %w", err)
-	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
+	_, err = tx.Exec("UPDATE p SET envelopeID = NULL WHERE envelopeID = ?", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.p -- %w", err)
 	}
-	err = tx.QueryRow("SELECT max(month) FROM a_chk WHERE month > 0").Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.a_chk.Max -- %w", err)
+	_, err = tx.Exec("UPDATE r SET envelopeID = NULL WHERE envelopeID = ?", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.r -- %w", err)
 	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
+	_, err = tx.Exec("UPDATE sch SET envelopeID = NULL WHERE envelopeID = ?", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Update.sch -- %w", err)
 	}
-	err = tx.QueryRow("SELECT max(month) FROM s_chk WHERE month > 0").Scan(&latest_t)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("updateSummaries.Select.s_chk.Max -- %w", err)
+	_, err = tx.Exec("DELETE FROM e_t WHERE envelopeID = ?", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Delete.e_t -- %w", err)
 	}
-	if latest_t.Valid {
-		latest_m = bcdate.BCDate(latest_t.Int32)
-		latest_m = latest_m - (latest_m % 100)
-
-		latest = bcdate.Latest(latest, latest_m)
+	_, err = tx.Exec("DELETE FROM e_chk WHERE envelopeID = ?", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Delete.e_chk -- %w", err)
 	}
-
-	for ; oldest <= latest; oldest = oldest.NextMonth() {
-
-		var a_bal int
-		var e_bal int
-
-		if err := tx.QueryRow("SELECT sum(bal) FROM ( SELECT bal, max(month) FROM a_chk JOIN a ON a_chk.accountID = a.ID WHERE month <= ? AND debt = 0 AND offbudget = 0 GROUP BY accountID )", oldest).Scan(&a_bal); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.a_chk.debt.bal -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT sum(bal) FROM ( SELECT bal, max(month) FROM e_chk WHERE month <= ? GROUP BY envelopeID )", oldest).Scan(&e_bal); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.e_chk.bal -- %w", err)
-			}
-		}
-
-		var float int = a_bal - e_bal
-
-		var banked int
-		if err := tx.QueryRow("SELECT sum(bal) FROM ( SELECT bal, max(month) FROM a_chk JOIN a ON a_chk.accountID = a.ID WHERE month <= ? AND offbudget = 0 GROUP BY accountID )", oldest).Scan(&banked); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.a_chk.debt.bal -- %w", err)
-			}
-		}
-
-		var nw int
-		if err := tx.QueryRow("SELECT sum(bal) FROM ( SELECT bal, max(month) FROM a_chk WHERE month <= ? GROUP BY accountID )", oldest).Scan(&nw); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.a_chk.banked -- %w", err)
-			}
-		}
-
-		var inc int
-		var exp int
-		var delta int
-
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t JOIN a ON a_t.accountID = a.ID WHERE a.offbudget = 0 AND postDate-mod(postDate,100) = ? AND type = 1", oldest).Scan(&inc); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.inc -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t JOIN a ON a_t.accountID = a.ID WHERE a.offbudget = 0 AND postDate-mod(postDate,100) = ? AND type = 0", oldest).Scan(&exp); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.inc -- %w", err)
-			}
-		}
-		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t JOIN a ON a_t.accountID = a.ID WHERE a.offbudget = 0 AND postDate-mod(postDate,100) = ?", oldest).Scan(&delta); err != nil {
-			if err != sql.ErrNoRows {
-				return fmt.Errorf("updateSummaries.Select.inc -- %w", err)
-			}
-		}
-
-		_, err = tx.Exec("INSERT OR REPLACE INTO s_chk (month,float,income,expenses,delta,banked,netWorth) VALUES (?,?,?,?,?,?,?)", oldest, float, inc, exp, delta, banked, nw)
-		if err != nil {
-			return fmt.Errorf("updateSummaries.Replace.e_chk -- %w", err)
-		}
-
+	_, err = tx.Exec("DELETE FROM e WHERE ID = ?", eid)
+	if err != nil {
+		return fmt.Errorf("deleteDebtEnvelope.Delete.e -- %w", err)
 	}
 
 	return nil
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+func sqlitePH(int) string {
+	return "?"
+}
+
+func (s *SQLite) WithActor(actor string) DB {
+	c := *s
+	c.actor = actor
+	return &c
+}
+
+func (s *SQLite) auditHead() (int64, error) {
+	var head int64
+	if err := s.db.QueryRow("SELECT coalesce(max(ID), 0) FROM audit").Scan(&head); err != nil {
+		return 0, fmt.Errorf("auditHead.Select.audit.Scan -- %w", err)
+	}
+	return head, nil
+}
+
+func (s *SQLite) addOp(name string, head int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("addOp.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	var last int64
+	if err := tx.QueryRow("SELECT coalesce(max(ID), 0) FROM audit").Scan(&last); err != nil {
+		return fmt.Errorf("addOp.Select.audit.Scan -- %w", err)
+	}
+	if last == head {
+		return nil
+	}
+	if err := insertOp(tx, sqlitePH, s.actor, name, head+1, last, sql.NullInt32{}); err != nil {
+		return fmt.Errorf("addOp.insertOp -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("addOp.Commit -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) GetHistory(n int) ([]AuditOp, error) {
+	ops, err := getHistory(s.db, sqlitePH, n)
+	if err != nil {
+		return nil, fmt.Errorf("GetHistory -- %w", err)
+	}
+	return ops, nil
+}
+
+func (s *SQLite) GetAuditChanges(id model.PKEY) ([]AuditChange, error) {
+	cs, err := getAuditChanges(s.db, sqlitePH, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetAuditChanges -- %w", err)
+	}
+	return cs, nil
+}
+
+func (s *SQLite) Undo(n int) ([]AuditOp, error) {
+	opLock.Lock()
+	defer opLock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Undo.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	// Rows come back in reverse, a cascade may have logged a child before its parent
+	if _, err := tx.Exec("PRAGMA defer_foreign_keys = ON"); err != nil {
+		return nil, fmt.Errorf("Undo.Pragma -- %w", err)
+	}
+
+	t := newTouched()
+	ops, err := undoOps(tx, sqlitePH, s.actor, n, &t)
+	if err != nil {
+		return nil, fmt.Errorf("Undo.undoOps -- %w", err)
+	}
+
+	aids, eids, err := t.settle(tx, sqlitePH)
+	if err != nil {
+		return nil, fmt.Errorf("Undo.settle -- %w", err)
+	}
+	if err := s.updateCheckpoints(tx, t.start, aids, eids); err != nil {
+		return nil, fmt.Errorf("Undo.updateCheckpoints -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Undo.Commit -- %w", err)
+	}
+
+	return ops, nil
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"fmt"
+)
+
+// VACUUM INTO reads through one transaction, so writers carry on and the copy is consistent
+func (s *SQLite) Backup(dest string) error {
+	if _, err := s.db.Exec("VACUUM INTO ?", dest); err != nil {
+		return fmt.Errorf("Backup.Vacuum -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("Close -- %w", err)
+	}
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 )
 
-func (s *SQLite) Batch_NewAccountTransaction(ats []model.AccountTransaction) error {
+// Rows looking like a stored transaction or an earlier row fail the batch with ErrDuplicate, unless allowDuplicates
+func (s *SQLite) Batch_NewAccountTransaction(ats []model.AccountTransaction, allowDuplicates bool) (err error) {
+	defer logOp(s, "Batch_NewAccountTransaction", &err)()
 	var atid int
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -19,18 +21,47 @@ func (s *SQLite) Batch_NewAccountTransaction(ats []model.AccountTransaction) err
 
 	oldest := bcdate.CurrentMonth()
 
+	rs, err := loadRules(tx)
+	if err != nil {
+		return fmt.Errorf("Batch_NewAccountTransaction.loadRules -- %w", err)
+	}
+
+	if !allowDuplicates {
+		ds, err := findDuplicates(tx, ats)
+		if err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.findDuplicates -- %w", err)
+		}
+		if err := checkDuplicates(ds); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction -- %w", err)
+		}
+	}
+
 	for _, at := range ats {
-		row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,cleared,memo) VALUES (?,?,?,?,?,?,?) RETURNING ID", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo)
+		// Rules go first, an envelope they set wins over the payee's default
+		applyRules(rs, &at)
+		if err := validateSplits(at); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.validateSplits -- %w", err)
+		}
+		if err := s.applyPayeeDefault(tx, &at); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.applyPayeeDefault -- %w", err)
+		}
+		if err := s.accountOpen(tx, at.AccountID, at.PostDate); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.accountOpen -- %w", err)
+		}
+
+		row := tx.QueryRow("INSERT INTO a_t (accountID,type,envelopeID,postDate,amount,cleared,memo,payeeID,importID) VALUES (?,?,?,?,?,?,?,?,?) RETURNING ID", at.AccountID, at.Typ, at.EnvelopeID, at.PostDate, at.Amount, at.Cleared, at.Memo, at.PayeeID, at.ImportID)
 		if err := row.Scan(&atid); err != nil {
 			return fmt.Errorf("Batch_NewAccountTransaction.Insert.a_t.Scan -- %w", err)
 		}
 
-		aids = append(aids, at.AccountID)
-
-		if at.EnvelopeID.Valid {
-			eids = append(eids, model.PKEY(at.EnvelopeID.Int32))
+		at.ID = model.PKEY(atid)
+		if err := s.insertSplits(tx, &at); err != nil {
+			return fmt.Errorf("Batch_NewAccountTransaction.insertSplits -- %w", err)
 		}
 
+		aids = append(aids, at.AccountID)
+		eids = append(eids, at.EnvelopeIDs()...)
+
 		oldest = bcdate.Oldest(oldest, at.PostDate)
 	}
 
@@ -55,7 +86,30 @@ func (s *SQLite) Batch_NewAccountTransaction(ats []model.AccountTransaction) err
 	return nil
 }
 
-func (s *SQLite) Batch_NewEnvelopeTransaction(ets []model.EnvelopeTransaction) error {
+// Import IDs already used in the account, importers skip statement rows found here
+func (s *SQLite) GetImportIDs(id model.PKEY) (map[string]bool, error) {
+	ids := make(map[string]bool)
+
+	rows, err := s.db.Query("SELECT importID FROM a_t WHERE accountID = ? AND importID IS NOT NULL", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetImportIDs.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var importID string
+		if err := rows.Scan(&importID); err != nil {
+			return nil, fmt.Errorf("GetImportIDs.Scan -- %w", err)
+		}
+		ids[importID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetImportIDs.Err -- %w", err)
+	}
+	return ids, nil
+}
+
+func (s *SQLite) Batch_NewEnvelopeTransaction(ets []model.EnvelopeTransaction) (err error) {
+	defer logOp(s, "Batch_NewEnvelopeTransaction", &err)()
 	var etid int
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"fmt"
+)
+
+func (s *SQLite) GetCSVProfiles() ([]model.CSVProfile, error) {
+	cps := make([]model.CSVProfile, 0)
+
+	rows, err := s.db.Query(csvProfileSelect + " ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetCSVProfiles.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cp model.CSVProfile
+		if err := scanCSVProfile(rows, &cp); err != nil {
+			return nil, fmt.Errorf("GetCSVProfiles.Scan -- %w", err)
+		}
+		cps = append(cps, cp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetCSVProfiles.Err -- %w", err)
+	}
+	return cps, nil
+}
+
+func (s *SQLite) GetCSVProfile(id model.PKEY) (model.CSVProfile, error) {
+	cp := model.CSVProfile{}
+	row := s.db.QueryRow(csvProfileSelect+" WHERE ID = ?", id)
+	if err := scanCSVProfile(row, &cp); err != nil {
+		return cp, fmt.Errorf("GetCSVProfile.Scan.csv_profile -- %w", err)
+	}
+	return cp, nil
+}
+
+func (s *SQLite) GetCSVProfileByName(name string) (model.CSVProfile, error) {
+	cp := model.CSVProfile{}
+	row := s.db.QueryRow(csvProfileSelect+" WHERE name = ?", name)
+	if err := scanCSVProfile(row, &cp); err != nil {
+		return cp, fmt.Errorf("GetCSVProfileByName.Scan.csv_profile -- %w", err)
+	}
+	return cp, nil
+}
+
+func (s *SQLite) NewCSVProfile(cp *model.CSVProfile) (err error) {
+	defer logOp(s, "NewCSVProfile", &err)()
+	row := s.db.QueryRow("INSERT INTO csv_profile (name,delimiter,skipRows,header,dateColumn,dateFormat,amountColumn,debitColumn,creditColumn,negate,decimalComma,memoColumn,clearedColumn,clearedValue,idColumn) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?) RETURNING ID",
+		cp.Name, cp.Delimiter, cp.SkipRows, cp.Header, cp.DateColumn, cp.DateFormat, cp.AmountColumn, cp.DebitColumn, cp.CreditColumn, cp.Negate, cp.DecimalComma, cp.MemoColumn, cp.ClearedColumn, cp.ClearedValue, cp.IDColumn)
+	if err := row.Scan(&cp.ID); err != nil {
+		return fmt.Errorf("NewCSVProfile.Insert.csv_profile.Scan -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) UpdateCSVProfile(cp model.CSVProfile) (err error) {
+	defer logOp(s, "UpdateCSVProfile", &err)()
+	_, err = s.db.Exec("UPDATE csv_profile SET name = ?, delimiter = ?, skipRows = ?, header = ?, dateColumn = ?, dateFormat = ?, amountColumn = ?, debitColumn = ?, creditColumn = ?, negate = ?, decimalComma = ?, memoColumn = ?, clearedColumn = ?, clearedValue = ?, idColumn = ? WHERE ID = ?",
+		cp.Name, cp.Delimiter, cp.SkipRows, cp.Header, cp.DateColumn, cp.DateFormat, cp.AmountColumn, cp.DebitColumn, cp.CreditColumn, cp.Negate, cp.DecimalComma, cp.MemoColumn, cp.ClearedColumn, cp.ClearedValue, cp.IDColumn, cp.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateCSVProfile.Update.csv_profile -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) DeleteCSVProfile(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteCSVProfile", &err)()
+	_, err = s.db.Exec("DELETE FROM csv_profile WHERE ID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteCSVProfile.Delete.csv_profile -- %w", err)
+	}
+	return nil
+}
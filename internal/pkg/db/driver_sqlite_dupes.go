@@ -0,0 +1,23 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"fmt"
+)
+
+// Rows of the batch that look like a stored transaction or an earlier row, see findDuplicates
+func (s *SQLite) FindDuplicates(ats []model.AccountTransaction) ([]Duplicate, error) {
+	ds, err := findDuplicates(s.db, ats)
+	if err != nil {
+		return nil, fmt.Errorf("FindDuplicates.findDuplicates -- %w", err)
+	}
+	return ds, nil
+}
+
+func (s *SQLite) GetDuplicates() ([]Duplicate, error) {
+	ds, err := storedDuplicates(s.db)
+	if err != nil {
+		return nil, fmt.Errorf("GetDuplicates.storedDuplicates -- %w", err)
+	}
+	return ds, nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (s *SQLite) GetMigrations() ([]Migration, error) {
+	ms, err := loadMigrations("sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("GetMigrations.loadMigrations -- %w", err)
+	}
+
+	applied, err := s.appliedMigrations(s.db)
+	if err != nil {
+		return nil, fmt.Errorf("GetMigrations.appliedMigrations -- %w", err)
+	}
+
+	return markApplied(ms, applied)
+}
+
+func (s *SQLite) SchemaVersion() (int, error) {
+	applied, err := s.appliedMigrations(s.db)
+	if err != nil {
+		return 0, fmt.Errorf("SchemaVersion.appliedMigrations -- %w", err)
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+func (s *SQLite) Migrate() ([]Migration, error) {
+	ms, err := loadMigrations("sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.loadMigrations -- %w", err)
+	}
+
+	ctx := context.Background()
+
+	// Table rebuilds need foreign keys off, which SQLite only allows outside a transaction, so pin one connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.Conn -- %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("Migrate.foreign_keys.Off -- %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	applied, err := s.appliedMigrations(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.appliedMigrations -- %w", err)
+	}
+	if ms, err = markApplied(ms, applied); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied TEXT NOT NULL)"); err != nil {
+		return nil, fmt.Errorf("Migrate.Create.schema_version -- %w", err)
+	}
+
+	// Databases from before migrations existed already hold the initial schema, record it
+	if ms[0].Applied == unrecorded {
+		ms[0].Applied = time.Now().Format(time.RFC3339)
+		if _, err := tx.Exec("INSERT INTO schema_version (version,name,applied) VALUES (?,?,?)", ms[0].Version, ms[0].Name, ms[0].Applied); err != nil {
+			return nil, fmt.Errorf("Migrate.Insert.schema_version.legacy -- %w", err)
+		}
+	}
+
+	done := make([]Migration, 0)
+	for _, m := range ms {
+		if m.Applied != "" {
+			continue
+		}
+
+		if _, err := tx.Exec(m.query); err != nil {
+			return nil, fmt.Errorf("Migrate.Exec.%04d_%s -- %w", m.Version, m.Name, err)
+		}
+
+		m.Applied = time.Now().Format(time.RFC3339)
+		if _, err := tx.Exec("INSERT INTO schema_version (version,name,applied) VALUES (?,?,?)", m.Version, m.Name, m.Applied); err != nil {
+			return nil, fmt.Errorf("Migrate.Insert.schema_version -- %w", err)
+		}
+
+		done = append(done, m)
+	}
+
+	// With the foreign keys off nothing stopped a step from orphaning rows, so check before committing
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("Migrate.foreign_key_check -- %w", err)
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		return nil, fmt.Errorf("Migrate.foreign_key_check -- migration left rows with broken foreign keys")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Migrate.Commit -- %w", err)
+	}
+
+	return done, nil
+}
+
+func (s *SQLite) appliedMigrations(q queryer) (map[int]string, error) {
+	applied := make(map[int]string)
+
+	var hasVersion bool
+	var hasSchema bool
+	if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'), EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'a')").Scan(&hasVersion, &hasSchema); err != nil {
+		return nil, fmt.Errorf("appliedMigrations.Select.sqlite_master -- %w", err)
+	}
+
+	// Databases from before migrations existed have the schema but no history
+	if !hasVersion {
+		if hasSchema {
+			applied[1] = unrecorded
+		}
+		return applied, nil
+	}
+
+	rows, err := q.Query("SELECT version, applied FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("appliedMigrations.Select.schema_version -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		var when string
+		if err := rows.Scan(&v, &when); err != nil {
+			return nil, fmt.Errorf("appliedMigrations.Scan -- %w", err)
+		}
+		applied[v] = when
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("appliedMigrations.Err -- %w", err)
+	}
+
+	// An empty history next to an existing schema means the version table was only just created
+	if len(applied) == 0 && hasSchema {
+		applied[1] = unrecorded
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+func (s *SQLite) GetPayees() ([]model.Payee, error) {
+	ps := make([]model.Payee, 0)
+
+	rows, err := s.db.Query("SELECT ID, name, envelopeID FROM p ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("GetPayees.Select -- %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p model.Payee
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.EnvelopeID,
+		); err != nil {
+			return nil, fmt.Errorf("GetPayees.Scan -- %w", err)
+		}
+		ps = append(ps, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetPayees.Err -- %w", err)
+	}
+	return ps, nil
+}
+
+func (s *SQLite) GetPayee(id model.PKEY) (model.Payee, error) {
+	p := model.Payee{}
+	row := s.db.QueryRow("SELECT ID, name, envelopeID FROM p WHERE ID = ?", id)
+	if err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.EnvelopeID,
+	); err != nil {
+		return p, fmt.Errorf("GetPayee.Scan.p -- %w", err)
+	}
+	return p, nil
+}
+
+func (s *SQLite) GetPayeeByName(name string) (model.Payee, error) {
+	p := model.Payee{}
+	row := s.db.QueryRow("SELECT ID, name, envelopeID FROM p WHERE name = ?", name)
+	if err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.EnvelopeID,
+	); err != nil {
+		return p, fmt.Errorf("GetPayeeByName.Scan.p -- %w", err)
+	}
+	return p, nil
+}
+
+func (s *SQLite) NewPayee(p *model.Payee) (err error) {
+	defer logOp(s, "NewPayee", &err)()
+	row := s.db.QueryRow("INSERT INTO p (name,envelopeID) VALUES (?,?) RETURNING ID", p.Name, p.EnvelopeID)
+	if err := row.Scan(&p.ID); err != nil {
+		return fmt.Errorf("NewPayee.Insert.p.Scan -- %w", err)
+	}
+	return nil
+}
+
+// Only new transactions use the default envelope, changing it leaves existing ones alone
+func (s *SQLite) UpdatePayee(p model.Payee) (err error) {
+	defer logOp(s, "UpdatePayee", &err)()
+	_, err = s.db.Exec("UPDATE p SET name = ?, envelopeID = ? WHERE ID = ?", p.Name, p.EnvelopeID, p.ID)
+	if err != nil {
+		return fmt.Errorf("UpdatePayee.Update.p -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) DeletePayee(id model.PKEY) (err error) {
+	defer logOp(s, "DeletePayee", &err)()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE a_t SET payeeID = NULL WHERE payeeID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Update.a_t -- %w", err)
+	}
+	_, err = tx.Exec("UPDATE sch SET payeeID = NULL WHERE payeeID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Update.sch -- %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM p WHERE ID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeletePayee.Delete.p -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeletePayee.Commit -- %w", err)
+	}
+
+	return nil
+}
+
+// Give a transaction without an envelope the default envelope of its payee
+func (s *SQLite) applyPayeeDefault(tx *sql.Tx, at *model.AccountTransaction) error {
+	if !wantsPayeeDefault(*at) {
+		return nil
+	}
+	row := tx.QueryRow("SELECT envelopeID FROM p WHERE ID = ?", at.PayeeID)
+	if err := row.Scan(&at.EnvelopeID); err != nil {
+		return fmt.Errorf("applyPayeeDefault.Scan.p -- %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"database/sql"
+	"fmt"
+)
+
+// The write lock has to be held before the "before" snapshot, or a writer committing in between makes the dry run and the real rebuild disagree
+// database/sql has no way to ask for BEGIN IMMEDIATE on one transaction, so Rebuild opens a pool of its own that always does
+// Other writers wait it out, readers keep seeing the old checkpoints until commit
+func (s *SQLite) Rebuild(dryRun bool) ([]CheckpointChange, error) {
+	wdb, err := sql.Open("sqlite3", s.dsn+"&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.Open -- %w", err)
+	}
+	defer wdb.Close()
+
+	tx, err := wdb.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotCheckpoints(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.snapshotCheckpoints.before -- %w", err)
+	}
+
+	aids, eids, err := resetCheckpoints(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.resetCheckpoints -- %w", err)
+	}
+
+	for _, aid := range aids {
+		if err := s.updateAccountSummaries(tx, bcdate.Epoch(), aid); err != nil {
+			return nil, fmt.Errorf("Rebuild.updateAccountSummaries -- %w", err)
+		}
+	}
+	for _, eid := range eids {
+		if err := s.updateEnvelopeSummaries(tx, bcdate.Epoch(), eid); err != nil {
+			return nil, fmt.Errorf("Rebuild.updateEnvelopeSummaries -- %w", err)
+		}
+	}
+	if err := s.updateSummaries(tx, bcdate.Epoch()); err != nil {
+		return nil, fmt.Errorf("Rebuild.updateSummaries -- %w", err)
+	}
+
+	after, err := snapshotCheckpoints(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Rebuild.snapshotCheckpoints.after -- %w", err)
+	}
+
+	changes := diffCheckpoints(before, after)
+
+	if dryRun {
+		return changes, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Rebuild.Commit -- %w", err)
+	}
+
+	return changes, nil
+}
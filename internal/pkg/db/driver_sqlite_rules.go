@@ -0,0 +1,70 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"fmt"
+)
+
+func (s *SQLite) GetRules() ([]model.Rule, error) {
+	rs, err := selectRules(s.db)
+	if err != nil {
+		return nil, fmt.Errorf("GetRules.selectRules -- %w", err)
+	}
+	return rs, nil
+}
+
+func (s *SQLite) GetRule(id model.PKEY) (model.Rule, error) {
+	r := model.Rule{}
+	row := s.db.QueryRow(ruleSelect+" WHERE ID = ?", id)
+	if err := scanRule(row, &r); err != nil {
+		return r, fmt.Errorf("GetRule.Scan.r -- %w", err)
+	}
+	return r, nil
+}
+
+func (s *SQLite) NewRule(r *model.Rule) (err error) {
+	defer logOp(s, "NewRule", &err)()
+	if err := validateRule(*r); err != nil {
+		return fmt.Errorf("NewRule.validateRule -- %w", err)
+	}
+
+	row := s.db.QueryRow("INSERT INTO r (priority,name,memoPattern,minAmount,maxAmount,accountID,fromDate,toDate,envelopeID,type,cleared,memo) VALUES (?,?,?,?,?,?,?,?,?,?,?,?) RETURNING ID",
+		r.Priority, r.Name, patternValue(*r), r.MinAmount, r.MaxAmount, r.AccountID, r.FromDate, r.ToDate, r.EnvelopeID, r.Typ, r.Cleared, r.Memo)
+	if err := row.Scan(&r.ID); err != nil {
+		return fmt.Errorf("NewRule.Insert.r.Scan -- %w", err)
+	}
+	return nil
+}
+
+// Rules only act on new batches, changing one leaves existing transactions alone
+func (s *SQLite) UpdateRule(r model.Rule) (err error) {
+	defer logOp(s, "UpdateRule", &err)()
+	if err := validateRule(r); err != nil {
+		return fmt.Errorf("UpdateRule.validateRule -- %w", err)
+	}
+
+	_, err = s.db.Exec("UPDATE r SET priority = ?, name = ?, memoPattern = ?, minAmount = ?, maxAmount = ?, accountID = ?, fromDate = ?, toDate = ?, envelopeID = ?, type = ?, cleared = ?, memo = ? WHERE ID = ?",
+		r.Priority, r.Name, patternValue(r), r.MinAmount, r.MaxAmount, r.AccountID, r.FromDate, r.ToDate, r.EnvelopeID, r.Typ, r.Cleared, r.Memo, r.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateRule.Update.r -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) DeleteRule(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteRule", &err)()
+	_, err = s.db.Exec("DELETE FROM r WHERE ID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteRule.Delete.r -- %w", err)
+	}
+	return nil
+}
+
+// Dry run of the rules over a batch, nothing is written
+func (s *SQLite) PreviewRules(ats []model.AccountTransaction) ([]RuleMatch, error) {
+	rs, err := loadRules(s.db)
+	if err != nil {
+		return nil, fmt.Errorf("PreviewRules.loadRules -- %w", err)
+	}
+	return previewRules(rs, ats), nil
+}
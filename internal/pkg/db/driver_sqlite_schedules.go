@@ -0,0 +1,164 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+func (s *SQLite) GetSchedules() ([]model.Schedule, error) {
+	ss, err := selectSchedules(s.db, "")
+	if err != nil {
+		return nil, fmt.Errorf("GetSchedules.selectSchedules -- %w", err)
+	}
+	return ss, nil
+}
+
+func (s *SQLite) GetAccountSchedules(id model.PKEY) ([]model.Schedule, error) {
+	ss, err := selectSchedules(s.db, "WHERE accountID = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("GetAccountSchedules.selectSchedules -- %w", err)
+	}
+	return ss, nil
+}
+
+func (s *SQLite) GetSchedule(id model.PKEY) (model.Schedule, error) {
+	sc := model.Schedule{}
+	row := s.db.QueryRow(scheduleSelect+" WHERE ID = ?", id)
+	if err := scanSchedule(row, &sc); err != nil {
+		return sc, fmt.Errorf("GetSchedule.Scan.sch -- %w", err)
+	}
+	return sc, nil
+}
+
+func (s *SQLite) NewSchedule(sc *model.Schedule) (err error) {
+	defer logOp(s, "NewSchedule", &err)()
+	if err := validateSchedule(*sc); err != nil {
+		return fmt.Errorf("NewSchedule.validateSchedule -- %w", err)
+	}
+
+	row := s.db.QueryRow("INSERT INTO sch (accountID,envelopeID,payeeID,type,amount,memo,kind,n,startDate,endDate,lastDate) VALUES (?,?,?,?,?,?,?,?,?,?,?) RETURNING ID",
+		sc.AccountID, sc.EnvelopeID, sc.PayeeID, sc.Typ, sc.Amount, sc.Memo, sc.Kind, sc.N, sc.Start, sc.End, sc.Last)
+	if err := row.Scan(&sc.ID); err != nil {
+		return fmt.Errorf("NewSchedule.Insert.sch.Scan -- %w", err)
+	}
+	return nil
+}
+
+// Occurrences already entered or skipped stay that way, the new recurrence takes over after them
+func (s *SQLite) UpdateSchedule(sc model.Schedule) (err error) {
+	defer logOp(s, "UpdateSchedule", &err)()
+	if err := validateSchedule(sc); err != nil {
+		return fmt.Errorf("UpdateSchedule.validateSchedule -- %w", err)
+	}
+
+	_, err = s.db.Exec("UPDATE sch SET accountID = ?, envelopeID = ?, payeeID = ?, type = ?, amount = ?, memo = ?, kind = ?, n = ?, startDate = ?, endDate = ? WHERE ID = ?",
+		sc.AccountID, sc.EnvelopeID, sc.PayeeID, sc.Typ, sc.Amount, sc.Memo, sc.Kind, sc.N, sc.Start, sc.End, sc.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateSchedule.Update.sch -- %w", err)
+	}
+	return nil
+}
+
+// Transactions it already entered are left alone
+func (s *SQLite) DeleteSchedule(id model.PKEY) (err error) {
+	defer logOp(s, "DeleteSchedule", &err)()
+	_, err = s.db.Exec("DELETE FROM sch WHERE ID = ?", id)
+	if err != nil {
+		return fmt.Errorf("DeleteSchedule.Delete.sch -- %w", err)
+	}
+	return nil
+}
+
+func (s *SQLite) SkipSchedule(id model.PKEY) (_ bcdate.BCDate, err error) {
+	defer logOp(s, "SkipSchedule", &err)()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	sc := model.Schedule{}
+	if err := scanSchedule(tx.QueryRow(scheduleSelect+" WHERE ID = ?", id), &sc); err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Scan.sch -- %w", err)
+	}
+	next := sc.Next()
+	if next == bcdate.Never() {
+		return 0, fmt.Errorf("SkipSchedule -- %w: no occurrences left", ErrInvalidSchedule)
+	}
+
+	if _, err := tx.Exec("UPDATE sch SET lastDate = ? WHERE ID = ?", next, id); err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Update.sch -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("SkipSchedule.Commit -- %w", err)
+	}
+	return next, nil
+}
+
+func (s *SQLite) RunSchedules(today bcdate.BCDate) (_ []model.AccountTransaction, _ []ScheduleFailure, err error) {
+	defer logOp(s, "RunSchedules", &err)()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.Begin -- %w", err)
+	}
+	defer tx.Rollback()
+
+	ss, err := selectSchedules(tx, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.selectSchedules -- %w", err)
+	}
+
+	entered := make([]model.AccountTransaction, 0)
+	failed := make([]ScheduleFailure, 0)
+	for _, sc := range ss {
+		ats, last := dueOccurrences(sc, today)
+		if len(ats) == 0 {
+			continue
+		}
+
+		// Each schedule in its own savepoint, one that fails is undone and the rest still go in
+		if _, err := tx.Exec("SAVEPOINT schedule"); err != nil {
+			return nil, nil, fmt.Errorf("RunSchedules.Savepoint -- %w", err)
+		}
+		if serr := s.enterSchedule(tx, sc.ID, ats, last); serr != nil {
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT schedule"); err != nil {
+				return nil, nil, fmt.Errorf("RunSchedules.RollbackTo -- %w", err)
+			}
+			failed = append(failed, ScheduleFailure{ScheduleID: sc.ID, Err: serr})
+		} else {
+			entered = append(entered, ats...)
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT schedule"); err != nil {
+			return nil, nil, fmt.Errorf("RunSchedules.Release -- %w", err)
+		}
+	}
+	if len(entered) == 0 {
+		return entered, failed, nil
+	}
+
+	oldest, aids, eids := scheduledTouches(entered)
+	if err := s.updateCheckpoints(tx, oldest, aids, eids); err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.updateCheckpoints -- %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("RunSchedules.Commit -- %w", err)
+	}
+	return entered, failed, nil
+}
+
+// The due occurrences of one schedule and its new lastDate, the IDs are filled into ats
+func (s *SQLite) enterSchedule(tx *sql.Tx, id model.PKEY, ats []model.AccountTransaction, last bcdate.BCDate) error {
+	for i := range ats {
+		if err := s.insertAccountTransaction(tx, &ats[i]); err != nil {
+			return fmt.Errorf("enterSchedule.insertAccountTransaction -- %w", err)
+		}
+	}
+	if _, err := tx.Exec("UPDATE sch SET lastDate = ? WHERE ID = ?", last, id); err != nil {
+		return fmt.Errorf("enterSchedule.Update.sch -- %w", err)
+	}
+	return nil
+}
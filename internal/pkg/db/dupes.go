@@ -0,0 +1,223 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Duplicates: the same money entered twice in one account, like a hand entered charge and its statement line
+// Only transactions of the same amount within DupeWindow days are compared, the score then weighs how close the dates and memos are
+
+// Inserts that would enter a likely duplicate fail with this unless duplicates are allowed
+var ErrDuplicate = errors.New("likely duplicate transaction")
+
+const (
+	// Days apart two transactions can be and still be the same charge
+	DupeWindow = 3
+	// Scores from this up are reported, a same day match with a little memo in common makes it
+	DupeThreshold = 0.7
+)
+
+// A transaction that looks like another one
+type Duplicate struct {
+	// Position in the batch FindDuplicates was given, -1 for stored pairs
+	Index       int
+	Transaction model.AccountTransaction
+	// The stored transaction it looks like, or an earlier row of the same batch with no ID
+	Match model.AccountTransaction
+	Score float64
+}
+
+func (d Duplicate) String() string {
+	return fmt.Sprintf("%.2f -- %03d %08d %d %q ~ %03d %08d %q",
+		d.Score, d.Transaction.ID, d.Transaction.PostDate, d.Transaction.Amount, d.Transaction.Memo,
+		d.Match.ID, d.Match.PostDate, d.Match.Memo)
+}
+
+// 0 to 1, how likely the two are the same money
+// Amounts must be equal and the dates within the window, rows the bank gave different import IDs are never the same
+// Neither are memos with nothing in common, two coffees on one day are two purchases
+func dupeScore(a, b model.AccountTransaction) float64 {
+	if a.AccountID != b.AccountID || a.Amount != b.Amount {
+		return 0
+	}
+	if a.ImportID.Valid && b.ImportID.Valid && a.ImportID.String != b.ImportID.String {
+		return 0
+	}
+	days := bcdate.DaysBetween(a.PostDate, b.PostDate)
+	if days < 0 {
+		days = -days
+	}
+	if days > DupeWindow {
+		return 0
+	}
+
+	memoScore := memoSimilarity(a.Memo, b.Memo)
+	if memoScore == 0 {
+		return 0
+	}
+	dateScore := 1 - float64(days)/float64(DupeWindow+1)
+	return 0.4 + 0.3*dateScore + 0.3*memoScore
+}
+
+// Dice coefficient over letter pairs, ignoring case, spaces and punctuation
+func memoSimilarity(a, b string) float64 {
+	na, nb := normalizeMemo(a), normalizeMemo(b)
+	if na == nb {
+		return 1
+	}
+	if len(na) < 2 || len(nb) < 2 {
+		return 0
+	}
+
+	pairs := make(map[string]int)
+	for i := 0; i+1 < len(na); i++ {
+		pairs[na[i:i+2]]++
+	}
+	shared := 0
+	for i := 0; i+1 < len(nb); i++ {
+		if p := nb[i : i+2]; pairs[p] > 0 {
+			pairs[p]--
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(na)-1+len(nb)-1)
+}
+
+func normalizeMemo(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+const dupeSelect = "SELECT ID, accountID, type, envelopeID, postDate, amount, cleared, memo, payeeID, importID, reconciled FROM a_t"
+
+// Stored transactions that could match, optionally limited to the given accounts and dates
+func loadDupeCandidates(q queryer, where string) ([]model.AccountTransaction, error) {
+	rows, err := q.Query(dupeSelect + where + " ORDER BY accountID, amount, postDate, ID")
+	if err != nil {
+		return nil, fmt.Errorf("loadDupeCandidates.Select -- %w", err)
+	}
+	defer rows.Close()
+
+	ats := make([]model.AccountTransaction, 0)
+	for rows.Next() {
+		at := model.AccountTransaction{}
+		if err := rows.Scan(&at.ID, &at.AccountID, &at.Typ, &at.EnvelopeID, &at.PostDate, &at.Amount, &at.Cleared, &at.Memo, &at.PayeeID, &at.ImportID, &at.Reconciled); err != nil {
+			return nil, fmt.Errorf("loadDupeCandidates.Scan -- %w", err)
+		}
+		ats = append(ats, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadDupeCandidates.Err -- %w", err)
+	}
+	return ats, nil
+}
+
+// The best match of each row of the batch among stored transactions and earlier rows
+// Each transaction matches at most one row, so two identical charges against one stored charge report one duplicate
+func findDuplicates(q queryer, ats []model.AccountTransaction) ([]Duplicate, error) {
+	ds := make([]Duplicate, 0)
+	if len(ats) == 0 {
+		return ds, nil
+	}
+
+	accounts := make(map[model.PKEY]bool)
+	oldest, latest := bcdate.Never(), bcdate.Epoch()
+	for _, at := range ats {
+		accounts[at.AccountID] = true
+		oldest = bcdate.Oldest(oldest, at.PostDate)
+		latest = bcdate.Latest(latest, at.PostDate)
+	}
+	ids := make([]string, 0, len(accounts))
+	for _, id := range sortedKeys(accounts) {
+		ids = append(ids, strconv.Itoa(int(id)))
+	}
+	stored, err := loadDupeCandidates(q, fmt.Sprintf(" WHERE accountID IN (%s) AND postDate >= %d AND postDate <= %d",
+		strings.Join(ids, ","), oldest.AddDays(-DupeWindow), latest.AddDays(DupeWindow)))
+	if err != nil {
+		return nil, fmt.Errorf("findDuplicates.loadDupeCandidates -- %w", err)
+	}
+
+	storedUsed := make(map[model.PKEY]bool)
+	batchUsed := make(map[int]bool)
+	for i, at := range ats {
+		best := Duplicate{Index: i, Transaction: at}
+		bestStored, bestRow := model.PKEY(0), -1
+
+		for _, s := range stored {
+			if score := dupeScore(at, s); score >= DupeThreshold && score > best.Score && !storedUsed[s.ID] {
+				best.Match, best.Score, bestStored, bestRow = s, score, s.ID, -1
+			}
+		}
+		for j := 0; j < i; j++ {
+			if score := dupeScore(at, ats[j]); score >= DupeThreshold && score > best.Score && !batchUsed[j] {
+				best.Match, best.Score, bestStored, bestRow = ats[j], score, 0, j
+			}
+		}
+
+		switch {
+		case bestRow >= 0:
+			batchUsed[bestRow] = true
+		case bestStored != 0:
+			storedUsed[bestStored] = true
+		default:
+			continue
+		}
+		batchUsed[i] = true
+		ds = append(ds, best)
+	}
+	return ds, nil
+}
+
+// The first duplicate as an ErrDuplicate, nil when there are none
+func checkDuplicates(ds []Duplicate) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	d := ds[0]
+	if d.Match.ID == 0 {
+		return fmt.Errorf("%w: row %d looks like an earlier row of the batch", ErrDuplicate, d.Index)
+	}
+	return fmt.Errorf("%w: row %d looks like transaction %d", ErrDuplicate, d.Index, d.Match.ID)
+}
+
+// Every stored pair scoring at least DupeThreshold, the later one as the Transaction
+func storedDuplicates(q queryer) ([]Duplicate, error) {
+	ats, err := loadDupeCandidates(q, "")
+	if err != nil {
+		return nil, fmt.Errorf("storedDuplicates.loadDupeCandidates -- %w", err)
+	}
+
+	// Sorted by account, amount and date, so candidates for a row are the ones just before it
+	ds := make([]Duplicate, 0)
+	for i, at := range ats {
+		for j := i - 1; j >= 0; j-- {
+			prev := ats[j]
+			if prev.AccountID != at.AccountID || prev.Amount != at.Amount || bcdate.DaysBetween(prev.PostDate, at.PostDate) > DupeWindow {
+				break
+			}
+			if score := dupeScore(at, prev); score >= DupeThreshold {
+				ds = append(ds, Duplicate{Index: -1, Transaction: at, Match: prev, Score: score})
+			}
+		}
+	}
+
+	sort.SliceStable(ds, func(i, j int) bool {
+		if ds[i].Transaction.AccountID != ds[j].Transaction.AccountID {
+			return ds[i].Transaction.AccountID < ds[j].Transaction.AccountID
+		}
+		return ds[i].Transaction.PostDate < ds[j].Transaction.PostDate
+	})
+	return ds, nil
+}
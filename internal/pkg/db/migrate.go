@@ -0,0 +1,88 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema migrations: ordered, forward-only steps embedded in the binary
+// Each driver keeps its own copy under migrations/<driver>/NNNN_name.sql, versions must run 1..N with no gaps
+// Applied steps are recorded in the schema_version table
+
+//go:embed migrations
+var migrationFS embed.FS
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Applied marker for a schema that predates the schema_version table
+const unrecorded = "unrecorded"
+
+type Migration struct {
+	Version int
+	Name    string
+
+	// When the step was applied, RFC3339, empty while pending
+	Applied string
+
+	query string
+}
+
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+
+	files, err := migrationFS.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("loadMigrations.ReadDir -- %w", err)
+	}
+
+	ms := make([]Migration, 0, len(files))
+	for _, f := range files {
+		vs, name, ok := strings.Cut(strings.TrimSuffix(f.Name(), ".sql"), "_")
+		if !ok || !strings.HasSuffix(f.Name(), ".sql") {
+			return nil, fmt.Errorf("loadMigrations -- malformed migration name: %s", f.Name())
+		}
+		v, err := strconv.Atoi(vs)
+		if err != nil {
+			return nil, fmt.Errorf("loadMigrations.Atoi -- %w", err)
+		}
+		query, err := migrationFS.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("loadMigrations.ReadFile -- %w", err)
+		}
+		ms = append(ms, Migration{Version: v, Name: name, query: string(query)})
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, m := range ms {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("loadMigrations -- expected version %d, found %d (%s)", i+1, m.Version, m.Name)
+		}
+	}
+
+	return ms, nil
+}
+
+// Overlay the recorded history onto the known steps, refusing histories this binary does not know about
+func markApplied(ms []Migration, applied map[int]string) ([]Migration, error) {
+	for v := range applied {
+		if v > len(ms) {
+			return nil, fmt.Errorf("%w: database is at version %d, binary knows up to %d", ErrSchemaTooNew, v, len(ms))
+		}
+	}
+	for i := range ms {
+		ms[i].Applied = applied[ms[i].Version]
+	}
+	return ms, nil
+}
+
+// Reads shared by *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
@@ -0,0 +1,116 @@
+CREATE TABLE e_grp (
+    ID SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    sort INTEGER NOT NULL DEFAULT (100)
+);
+
+CREATE TABLE a (
+    ID SERIAL PRIMARY KEY,
+    hidden BOOLEAN NOT NULL DEFAULT (FALSE),
+    offbudget BOOLEAN NOT NULL DEFAULT (FALSE),
+    debt BOOLEAN NOT NULL DEFAULT (FALSE),
+    institution TEXT NOT NULL,
+    name TEXT NOT NULL,
+    class INTEGER NOT NULL DEFAULT (0)
+);
+
+CREATE TABLE e (
+    ID SERIAL PRIMARY KEY,
+    groupID INTEGER REFERENCES e_grp(ID) NOT NULL,
+    hidden BOOLEAN NOT NULL DEFAULT (FALSE),
+    debtAccount INTEGER REFERENCES a(ID) UNIQUE,
+    name TEXT NOT NULL,
+    notes TEXT NOT NULL DEFAULT (''),
+    goalType INTEGER NOT NULL DEFAULT(0),
+    goalAmt BIGINT NOT NULL DEFAULT(0),
+    goalTgt BIGINT NOT NULL DEFAULT(0),
+    sort INTEGER NOT NULL DEFAULT (999)
+);
+
+CREATE TABLE a_t (
+    ID SERIAL PRIMARY KEY,
+    accountID INTEGER REFERENCES a(ID) NOT NULL,
+    type INTEGER NOT NULL DEFAULT (0),
+    envelopeID INTEGER REFERENCES e(ID),
+    postDate INTEGER NOT NULL,
+    amount BIGINT NOT NULL,
+    cleared BOOLEAN NOT NULL DEFAULT (FALSE),
+    memo TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE INDEX a_t_date ON a_t (postDate);
+CREATE INDEX a_t_aid ON a_t (accountID);
+CREATE INDEX a_t_eid ON a_t (envelopeID);
+
+CREATE OR REPLACE FUNCTION a_t_u() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'Changing a_t accountID not supported';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER a_t_u
+BEFORE UPDATE
+ON a_t
+FOR EACH ROW
+WHEN (NEW.accountID != OLD.accountID)
+EXECUTE FUNCTION a_t_u();
+
+CREATE TABLE e_t (
+    ID SERIAL PRIMARY KEY,
+    envelopeID INTEGER REFERENCES e(ID) NOT NULL,
+    postDate INTEGER NOT NULL,
+    amount BIGINT NOT NULL
+);
+
+CREATE INDEX e_t_date ON e_t (postDate);
+CREATE INDEX e_t_eid ON e_t (envelopeID);
+
+CREATE OR REPLACE FUNCTION e_t_u() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'Changing e_t envelopeID not supported';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER e_t_u
+BEFORE UPDATE
+ON e_t
+FOR EACH ROW
+WHEN (NEW.envelopeID != OLD.envelopeID)
+EXECUTE FUNCTION e_t_u();
+
+CREATE TABLE a_chk (
+    accountID INTEGER REFERENCES a(ID) NOT NULL,
+    month INTEGER NOT NULL,
+    bal BIGINT NOT NULL DEFAULT(0),
+    "in" BIGINT NOT NULL DEFAULT(0),
+    out BIGINT NOT NULL DEFAULT(0),
+    uncleared BIGINT NOT NULL DEFAULT(0),
+
+    PRIMARY KEY(accountID, month)
+);
+
+CREATE TABLE e_chk (
+    envelopeID INTEGER REFERENCES e(ID) NOT NULL,
+    month INTEGER NOT NULL,
+    bal BIGINT NOT NULL DEFAULT(0),
+    "in" BIGINT NOT NULL DEFAULT(0),
+    out BIGINT NOT NULL DEFAULT(0),
+
+    PRIMARY KEY(envelopeID, month)
+);
+
+CREATE TABLE s_chk (
+    month INTEGER PRIMARY KEY,
+    "float" BIGINT NOT NULL DEFAULT(0),
+    income BIGINT NOT NULL DEFAULT(0),
+    expenses BIGINT NOT NULL DEFAULT(0),
+    delta BIGINT NOT NULL DEFAULT(0),
+    banked BIGINT NOT NULL DEFAULT(0),
+    netWorth BIGINT NOT NULL DEFAULT(0)
+);
+
+-- Initial summary
+INSERT INTO s_chk (month) VALUES (0);
+
+-- Default Envelope Groups
+INSERT INTO e_grp (name,sort) VALUES ('Misc', 999);
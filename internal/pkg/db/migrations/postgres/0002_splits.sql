@@ -0,0 +1,12 @@
+-- Split transactions: an a_t spread over several envelopes
+-- A split a_t has a NULL envelopeID and its rows here sum to its amount, a row without an envelope is the unassigned rest
+CREATE TABLE a_t_split (
+    ID SERIAL PRIMARY KEY,
+    transactionID INTEGER REFERENCES a_t(ID) ON DELETE CASCADE NOT NULL,
+    envelopeID INTEGER REFERENCES e(ID),
+    amount BIGINT NOT NULL,
+    memo TEXT NOT NULL DEFAULT ('')
+);
+
+CREATE INDEX a_t_split_tid ON a_t_split (transactionID);
+CREATE INDEX a_t_split_eid ON a_t_split (envelopeID);
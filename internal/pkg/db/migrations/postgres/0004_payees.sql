@@ -0,0 +1,11 @@
+-- Payees: who a transaction was with, and the envelope their spending usually goes to
+-- envelopeID is only a default, new TT_NORM a_t without an envelope of their own pick it up
+CREATE TABLE p (
+    ID SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    envelopeID INTEGER REFERENCES e(ID)
+);
+
+ALTER TABLE a_t ADD COLUMN payeeID INTEGER REFERENCES p(ID);
+
+CREATE INDEX a_t_pid ON a_t (payeeID);
@@ -0,0 +1,5 @@
+-- The ID a statement gave a transaction, FITID for OFX, so importing an overlapping statement again can skip what is already here
+-- NULL for anything entered by hand, unique per account otherwise
+ALTER TABLE a_t ADD COLUMN importID TEXT;
+
+CREATE UNIQUE INDEX a_t_import ON a_t (accountID, importID);
@@ -0,0 +1,21 @@
+-- How to read one bank's CSV export, see internal/pkg/csvimport
+-- Columns are header names, or 1 based positions when all digits
+-- Either amountColumn holds signed amounts, or debitColumn and creditColumn split them
+CREATE TABLE csv_profile (
+    ID SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    delimiter TEXT NOT NULL DEFAULT (','),
+    skipRows INTEGER NOT NULL DEFAULT (0),
+    header BOOLEAN NOT NULL DEFAULT (FALSE),
+    dateColumn TEXT NOT NULL,
+    dateFormat TEXT NOT NULL,
+    amountColumn TEXT NOT NULL DEFAULT (''),
+    debitColumn TEXT NOT NULL DEFAULT (''),
+    creditColumn TEXT NOT NULL DEFAULT (''),
+    negate BOOLEAN NOT NULL DEFAULT (FALSE),
+    decimalComma BOOLEAN NOT NULL DEFAULT (FALSE),
+    memoColumn TEXT NOT NULL DEFAULT (''),
+    clearedColumn TEXT NOT NULL DEFAULT (''),
+    clearedValue TEXT NOT NULL DEFAULT (''),
+    idColumn TEXT NOT NULL DEFAULT ('')
+);
@@ -0,0 +1,20 @@
+-- Scheduled transactions: RunSchedules enters each occurrence into a_t once it comes due, see internal/pkg/db/schedules.go
+-- kind picks the recurrence, n is its day of the month or the weeks between occurrences
+-- lastDate is the latest occurrence entered or skipped, 0 before the first
+CREATE TABLE sch (
+    ID SERIAL PRIMARY KEY,
+    accountID INTEGER REFERENCES a(ID) NOT NULL,
+    envelopeID INTEGER REFERENCES e(ID),
+    payeeID INTEGER REFERENCES p(ID),
+    type INTEGER NOT NULL DEFAULT (0),
+    amount BIGINT NOT NULL,
+    memo TEXT NOT NULL DEFAULT (''),
+
+    kind INTEGER NOT NULL,
+    n INTEGER NOT NULL DEFAULT (0),
+    startDate INTEGER NOT NULL,
+    endDate INTEGER,
+    lastDate INTEGER NOT NULL DEFAULT (0)
+);
+
+CREATE INDEX sch_aid ON sch (accountID);
@@ -0,0 +1,3 @@
+-- Set on cleared transactions once matched against a statement, see Reconcile
+-- Their amount, date and cleared flag are then locked until SetReconciled clears it again
+ALTER TABLE a_t ADD COLUMN reconciled BOOLEAN NOT NULL DEFAULT (FALSE);
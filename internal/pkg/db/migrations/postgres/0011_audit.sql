@@ -0,0 +1,164 @@
+-- Audit log: every row an audited call writes, as JSON images of the row before and after it
+-- Keys are lower case column names, oldRow is NULL for an insert and newRow for a delete
+-- Checkpoints are derived and left out, bar the EPOCH rows that hold starting balances
+-- Each call is one audit_op over the audit rows firstID to lastID, undoes is set on the ops Undo makes
+-- Both tables are append-only
+CREATE TABLE audit (
+    ID BIGSERIAL PRIMARY KEY,
+    tbl TEXT NOT NULL,
+    oldRow TEXT,
+    newRow TEXT
+);
+
+CREATE TABLE audit_op (
+    ID SERIAL PRIMARY KEY,
+    loggedAt BIGINT NOT NULL,
+    actor TEXT NOT NULL DEFAULT (''),
+    name TEXT NOT NULL,
+    firstID BIGINT NOT NULL,
+    lastID BIGINT NOT NULL,
+    undoes INTEGER REFERENCES audit_op(ID)
+);
+
+CREATE INDEX audit_op_undoes ON audit_op (undoes);
+
+CREATE OR REPLACE FUNCTION audit_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_ud
+BEFORE UPDATE OR DELETE
+ON audit
+FOR EACH ROW
+EXECUTE FUNCTION audit_append_only();
+
+CREATE TRIGGER audit_op_ud
+BEFORE UPDATE OR DELETE
+ON audit_op
+FOR EACH ROW
+EXECUTE FUNCTION audit_append_only();
+
+CREATE OR REPLACE FUNCTION audit_row() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO audit (tbl, oldRow, newRow) VALUES (TG_TABLE_NAME, NULL, to_jsonb(NEW)::text);
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF to_jsonb(OLD) IS DISTINCT FROM to_jsonb(NEW) THEN
+            INSERT INTO audit (tbl, oldRow, newRow) VALUES (TG_TABLE_NAME, to_jsonb(OLD)::text, to_jsonb(NEW)::text);
+        END IF;
+    ELSE
+        INSERT INTO audit (tbl, oldRow, newRow) VALUES (TG_TABLE_NAME, to_jsonb(OLD)::text, NULL);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER e_grp_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON e_grp
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON a
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER e_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON e
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_t_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON a_t
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER e_t_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON e_t
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_chk_audit_i
+AFTER INSERT
+ON a_chk
+FOR EACH ROW
+WHEN (NEW.month = 0)
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_chk_audit_u
+AFTER UPDATE
+ON a_chk
+FOR EACH ROW
+WHEN (NEW.month = 0)
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_chk_audit_d
+AFTER DELETE
+ON a_chk
+FOR EACH ROW
+WHEN (OLD.month = 0)
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER e_chk_audit_i
+AFTER INSERT
+ON e_chk
+FOR EACH ROW
+WHEN (NEW.month = 0)
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER e_chk_audit_u
+AFTER UPDATE
+ON e_chk
+FOR EACH ROW
+WHEN (NEW.month = 0)
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER e_chk_audit_d
+AFTER DELETE
+ON e_chk
+FOR EACH ROW
+WHEN (OLD.month = 0)
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_t_split_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON a_t_split
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER a_t_transfer_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON a_t_transfer
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER p_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON p
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER r_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON r
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER csv_profile_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON csv_profile
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
+
+CREATE TRIGGER sch_audit
+AFTER INSERT OR UPDATE OR DELETE
+ON sch
+FOR EACH ROW
+EXECUTE FUNCTION audit_row();
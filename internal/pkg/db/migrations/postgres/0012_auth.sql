@@ -0,0 +1,16 @@
+-- Who may use the server, see internal/pkg/middleware/auth
+-- hash is the bcrypt hash of the password, tokens only keep the SHA-256 of what the script sends
+-- Neither table is audited, the log should not keep password hashes around
+CREATE TABLE usr (
+    ID SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    hash TEXT NOT NULL
+);
+
+CREATE TABLE usr_token (
+    ID SERIAL PRIMARY KEY,
+    userID INTEGER REFERENCES usr(ID) ON DELETE CASCADE NOT NULL,
+    name TEXT NOT NULL DEFAULT (''),
+    hash TEXT NOT NULL UNIQUE
+);
+CREATE INDEX usr_token_userID ON usr_token(userID);
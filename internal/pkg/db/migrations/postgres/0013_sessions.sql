@@ -0,0 +1,13 @@
+-- Logged in browsers, see internal/pkg/middleware/session
+-- hash is the SHA-256 of the cookie, times are unix seconds, qm is the last query month used or 0
+CREATE TABLE sess (
+    ID SERIAL PRIMARY KEY,
+    userID INTEGER REFERENCES usr(ID) ON DELETE CASCADE NOT NULL,
+    hash TEXT NOT NULL UNIQUE,
+    createdAt BIGINT NOT NULL,
+    lastSeen BIGINT NOT NULL,
+    qm INTEGER NOT NULL DEFAULT (0),
+    agent TEXT NOT NULL DEFAULT (''),
+    addr TEXT NOT NULL DEFAULT ('')
+);
+CREATE INDEX sess_userID ON sess(userID);
@@ -0,0 +1,2 @@
+-- Sessions replace the login tokens the auth middleware used to hand browsers
+DELETE FROM usr_token WHERE name = 'login';
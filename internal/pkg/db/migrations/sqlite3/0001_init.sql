@@ -1,11 +1,9 @@
-DROP TABLE IF EXISTS e_grp;
 CREATE TABLE e_grp (
     ID INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL,
     sort INTEGER NOT NULL DEFAULT (100)
 );
 
-DROP TABLE IF EXISTS a;
 CREATE TABLE a (
     ID INTEGER PRIMARY KEY AUTOINCREMENT,
     hidden INTEGER NOT NULL DEFAULT (0),
@@ -16,7 +14,6 @@ CREATE TABLE a (
     class INTEGER NOT NULL DEFAULT (0)
 );
 
-DROP TABLE IF EXISTS e;
 CREATE TABLE e (
     ID INTEGER PRIMARY KEY AUTOINCREMENT,
     groupID INTEGER REFERENCES e_grp(ID) NOT NULL,
@@ -30,7 +27,6 @@ CREATE TABLE e (
     sort INTEGER NOT NULL DEFAULT (999)
 );
 
-DROP TABLE IF EXISTS a_t;
 CREATE TABLE a_t (
     ID INTEGER PRIMARY KEY AUTOINCREMENT,
     accountID INTEGER REFERENCES a(ID) NOT NULL,
@@ -42,14 +38,10 @@ CREATE TABLE a_t (
     memo TEXT NOT NULL DEFAULT ('')
 );
 
-DROP INDEX IF EXISTS a_t_date;
-DROP INDEX IF EXISTS a_t_aid;
-DROP INDEX IF EXISTS a_t_eid;
 CREATE INDEX a_t_date ON a_t (postDate);
 CREATE INDEX a_t_aid ON a_t (accountID);
 CREATE INDEX a_t_eid ON a_t (envelopeID);
 
-DROP TRIGGER IF EXISTS a_t_u;
 CREATE TRIGGER a_t_u
 BEFORE UPDATE
 ON a_t
@@ -58,7 +50,6 @@ BEGIN
     SELECT RAISE (ABORT, 'Changing a_t accountID not supported');
 END;
 
-DROP TABLE IF EXISTS e_t;
 CREATE TABLE e_t (
     ID INTEGER PRIMARY KEY AUTOINCREMENT,
     envelopeID INTEGER REFERENCES e(ID) NOT NULL,
@@ -66,12 +57,9 @@ CREATE TABLE e_t (
     amount INTEGER NOT NULL
 );
 
-DROP INDEX IF EXISTS e_t_date;
-DROP INDEX IF EXISTS e_t_eid;
 CREATE INDEX e_t_date ON e_t (postDate);
 CREATE INDEX e_t_eid ON e_t (envelopeID);
 
-DROP TRIGGER IF EXISTS e_t_u;
 CREATE TRIGGER e_t_u
 BEFORE UPDATE
 ON e_t
@@ -80,7 +68,6 @@ BEGIN
     SELECT RAISE (ABORT, 'Changing e_t envelopeID not supported');
 END;
 
-DROP TABLE IF EXISTS a_chk;
 CREATE TABLE a_chk (
     accountID INTEGER REFERENCES a(ID) NOT NULL,
     month INTEGER NOT NULL,
@@ -92,7 +79,6 @@ CREATE TABLE a_chk (
     PRIMARY KEY(accountID, month)
 );
 
-DROP TABLE IF EXISTS e_chk;
 CREATE TABLE e_chk (
     envelopeID INTEGER REFERENCES e(ID) NOT NULL,
     month INTEGER NOT NULL,
@@ -103,7 +89,6 @@ CREATE TABLE e_chk (
     PRIMARY KEY(envelopeID, month)
 );
 
-DROP TABLE IF EXISTS s_chk;
 CREATE TABLE s_chk (
     month INTEGER PRIMARY KEY,
     float INTEGER NOT NULL DEFAULT(0),
@@ -114,15 +99,8 @@ CREATE TABLE s_chk (
     netWorth INTEGER NOT NULL DEFAULT(0)
 );
 
-DELETE FROM sqlite_sequence;
-INSERT INTO sqlite_sequence (name, seq) VALUES ('a', 0);
-INSERT INTO sqlite_sequence (name, seq) VALUES ('a_t', 0);
-INSERT INTO sqlite_sequence (name, seq) VALUES ('e_grp', 0);
-INSERT INTO sqlite_sequence (name, seq) VALUES ('e', 0);
-INSERT INTO sqlite_sequence (name, seq) VALUES ('e_t', 0);
-
 -- Initial summary
 INSERT INTO s_chk (month) VALUES (0);
 
 -- Default Envelope Groups
-INSERT INTO e_grp (name,sort) VALUES ('Misc', 999);
\ No newline at end of file
+INSERT INTO e_grp (name,sort) VALUES ('Misc', 999);
@@ -0,0 +1,7 @@
+-- Transfers: money moved between two accounts, kept as one TT_TRANSFER a_t in each
+-- fromID is the negative leg and the only one that may carry an envelope, toID the positive one, both share date and amount
+CREATE TABLE a_t_transfer (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    fromID INTEGER REFERENCES a_t(ID) ON DELETE CASCADE NOT NULL UNIQUE,
+    toID INTEGER REFERENCES a_t(ID) ON DELETE CASCADE NOT NULL UNIQUE
+);
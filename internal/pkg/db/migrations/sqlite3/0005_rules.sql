@@ -0,0 +1,22 @@
+-- Rules: categorise new transactions as they come in through the batch path and importers
+-- Every match column left NULL matches anything, every action column left NULL leaves the field alone
+-- Rules run by ascending priority then ID, each sees the transaction as the rules before it left it
+CREATE TABLE r (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    priority INTEGER NOT NULL DEFAULT 0,
+    name TEXT NOT NULL,
+
+    memoPattern TEXT,
+    minAmount INTEGER,
+    maxAmount INTEGER,
+    accountID INTEGER REFERENCES a(ID),
+    fromDate INTEGER,
+    toDate INTEGER,
+
+    envelopeID INTEGER REFERENCES e(ID),
+    type INTEGER,
+    cleared BOOLEAN,
+    memo TEXT
+);
+
+CREATE INDEX r_priority ON r (priority, ID);
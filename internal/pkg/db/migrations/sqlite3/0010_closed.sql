@@ -0,0 +1,3 @@
+-- Date the account was closed, see CloseAccount
+-- Nothing can be entered into the account after it, history up to it stays
+ALTER TABLE a ADD COLUMN closed INTEGER;
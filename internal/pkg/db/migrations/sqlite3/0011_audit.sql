@@ -0,0 +1,341 @@
+-- Audit log: every row an audited call writes, as JSON images of the row before and after it
+-- Keys are lower case column names, oldRow is NULL for an insert and newRow for a delete
+-- Checkpoints are derived and left out, bar the EPOCH rows that hold starting balances
+-- Each call is one audit_op over the audit rows firstID to lastID, undoes is set on the ops Undo makes
+-- Both tables are append-only
+CREATE TABLE audit (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    tbl TEXT NOT NULL,
+    oldRow TEXT,
+    newRow TEXT
+);
+
+CREATE TABLE audit_op (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    loggedAt INTEGER NOT NULL,
+    actor TEXT NOT NULL DEFAULT (''),
+    name TEXT NOT NULL,
+    firstID INTEGER NOT NULL,
+    lastID INTEGER NOT NULL,
+    undoes INTEGER REFERENCES audit_op(ID)
+);
+
+CREATE INDEX audit_op_undoes ON audit_op (undoes);
+
+CREATE TRIGGER audit_u
+BEFORE UPDATE
+ON audit
+BEGIN
+    SELECT RAISE (ABORT, 'audit is append-only');
+END;
+
+CREATE TRIGGER audit_d
+BEFORE DELETE
+ON audit
+BEGIN
+    SELECT RAISE (ABORT, 'audit is append-only');
+END;
+
+CREATE TRIGGER audit_op_u
+BEFORE UPDATE
+ON audit_op
+BEGIN
+    SELECT RAISE (ABORT, 'audit_op is append-only');
+END;
+
+CREATE TRIGGER audit_op_d
+BEFORE DELETE
+ON audit_op
+BEGIN
+    SELECT RAISE (ABORT, 'audit_op is append-only');
+END;
+
+CREATE TRIGGER e_grp_audit_i
+AFTER INSERT
+ON e_grp
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_grp', NULL, json_object('id', NEW.ID, 'name', NEW.name, 'sort', NEW.sort));
+END;
+
+CREATE TRIGGER e_grp_audit_u
+AFTER UPDATE
+ON e_grp
+WHEN json_object('id', OLD.ID, 'name', OLD.name, 'sort', OLD.sort) IS NOT json_object('id', NEW.ID, 'name', NEW.name, 'sort', NEW.sort)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_grp', json_object('id', OLD.ID, 'name', OLD.name, 'sort', OLD.sort), json_object('id', NEW.ID, 'name', NEW.name, 'sort', NEW.sort));
+END;
+
+CREATE TRIGGER e_grp_audit_d
+AFTER DELETE
+ON e_grp
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_grp', json_object('id', OLD.ID, 'name', OLD.name, 'sort', OLD.sort), NULL);
+END;
+
+CREATE TRIGGER a_audit_i
+AFTER INSERT
+ON a
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a', NULL, json_object('id', NEW.ID, 'hidden', NEW.hidden, 'offbudget', NEW.offbudget, 'debt', NEW.debt, 'institution', NEW.institution, 'name', NEW.name, 'class', NEW.class, 'closed', NEW.closed));
+END;
+
+CREATE TRIGGER a_audit_u
+AFTER UPDATE
+ON a
+WHEN json_object('id', OLD.ID, 'hidden', OLD.hidden, 'offbudget', OLD.offbudget, 'debt', OLD.debt, 'institution', OLD.institution, 'name', OLD.name, 'class', OLD.class, 'closed', OLD.closed) IS NOT json_object('id', NEW.ID, 'hidden', NEW.hidden, 'offbudget', NEW.offbudget, 'debt', NEW.debt, 'institution', NEW.institution, 'name', NEW.name, 'class', NEW.class, 'closed', NEW.closed)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a', json_object('id', OLD.ID, 'hidden', OLD.hidden, 'offbudget', OLD.offbudget, 'debt', OLD.debt, 'institution', OLD.institution, 'name', OLD.name, 'class', OLD.class, 'closed', OLD.closed), json_object('id', NEW.ID, 'hidden', NEW.hidden, 'offbudget', NEW.offbudget, 'debt', NEW.debt, 'institution', NEW.institution, 'name', NEW.name, 'class', NEW.class, 'closed', NEW.closed));
+END;
+
+CREATE TRIGGER a_audit_d
+AFTER DELETE
+ON a
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a', json_object('id', OLD.ID, 'hidden', OLD.hidden, 'offbudget', OLD.offbudget, 'debt', OLD.debt, 'institution', OLD.institution, 'name', OLD.name, 'class', OLD.class, 'closed', OLD.closed), NULL);
+END;
+
+CREATE TRIGGER e_audit_i
+AFTER INSERT
+ON e
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e', NULL, json_object('id', NEW.ID, 'groupid', NEW.groupID, 'hidden', NEW.hidden, 'debtaccount', NEW.debtAccount, 'name', NEW.name, 'notes', NEW.notes, 'goaltype', NEW.goalType, 'goalamt', NEW.goalAmt, 'goaltgt', NEW.goalTgt, 'sort', NEW.sort));
+END;
+
+CREATE TRIGGER e_audit_u
+AFTER UPDATE
+ON e
+WHEN json_object('id', OLD.ID, 'groupid', OLD.groupID, 'hidden', OLD.hidden, 'debtaccount', OLD.debtAccount, 'name', OLD.name, 'notes', OLD.notes, 'goaltype', OLD.goalType, 'goalamt', OLD.goalAmt, 'goaltgt', OLD.goalTgt, 'sort', OLD.sort) IS NOT json_object('id', NEW.ID, 'groupid', NEW.groupID, 'hidden', NEW.hidden, 'debtaccount', NEW.debtAccount, 'name', NEW.name, 'notes', NEW.notes, 'goaltype', NEW.goalType, 'goalamt', NEW.goalAmt, 'goaltgt', NEW.goalTgt, 'sort', NEW.sort)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e', json_object('id', OLD.ID, 'groupid', OLD.groupID, 'hidden', OLD.hidden, 'debtaccount', OLD.debtAccount, 'name', OLD.name, 'notes', OLD.notes, 'goaltype', OLD.goalType, 'goalamt', OLD.goalAmt, 'goaltgt', OLD.goalTgt, 'sort', OLD.sort), json_object('id', NEW.ID, 'groupid', NEW.groupID, 'hidden', NEW.hidden, 'debtaccount', NEW.debtAccount, 'name', NEW.name, 'notes', NEW.notes, 'goaltype', NEW.goalType, 'goalamt', NEW.goalAmt, 'goaltgt', NEW.goalTgt, 'sort', NEW.sort));
+END;
+
+CREATE TRIGGER e_audit_d
+AFTER DELETE
+ON e
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e', json_object('id', OLD.ID, 'groupid', OLD.groupID, 'hidden', OLD.hidden, 'debtaccount', OLD.debtAccount, 'name', OLD.name, 'notes', OLD.notes, 'goaltype', OLD.goalType, 'goalamt', OLD.goalAmt, 'goaltgt', OLD.goalTgt, 'sort', OLD.sort), NULL);
+END;
+
+CREATE TRIGGER a_t_audit_i
+AFTER INSERT
+ON a_t
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t', NULL, json_object('id', NEW.ID, 'accountid', NEW.accountID, 'type', NEW.type, 'envelopeid', NEW.envelopeID, 'postdate', NEW.postDate, 'amount', NEW.amount, 'cleared', NEW.cleared, 'memo', NEW.memo, 'payeeid', NEW.payeeID, 'importid', NEW.importID, 'reconciled', NEW.reconciled));
+END;
+
+CREATE TRIGGER a_t_audit_u
+AFTER UPDATE
+ON a_t
+WHEN json_object('id', OLD.ID, 'accountid', OLD.accountID, 'type', OLD.type, 'envelopeid', OLD.envelopeID, 'postdate', OLD.postDate, 'amount', OLD.amount, 'cleared', OLD.cleared, 'memo', OLD.memo, 'payeeid', OLD.payeeID, 'importid', OLD.importID, 'reconciled', OLD.reconciled) IS NOT json_object('id', NEW.ID, 'accountid', NEW.accountID, 'type', NEW.type, 'envelopeid', NEW.envelopeID, 'postdate', NEW.postDate, 'amount', NEW.amount, 'cleared', NEW.cleared, 'memo', NEW.memo, 'payeeid', NEW.payeeID, 'importid', NEW.importID, 'reconciled', NEW.reconciled)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t', json_object('id', OLD.ID, 'accountid', OLD.accountID, 'type', OLD.type, 'envelopeid', OLD.envelopeID, 'postdate', OLD.postDate, 'amount', OLD.amount, 'cleared', OLD.cleared, 'memo', OLD.memo, 'payeeid', OLD.payeeID, 'importid', OLD.importID, 'reconciled', OLD.reconciled), json_object('id', NEW.ID, 'accountid', NEW.accountID, 'type', NEW.type, 'envelopeid', NEW.envelopeID, 'postdate', NEW.postDate, 'amount', NEW.amount, 'cleared', NEW.cleared, 'memo', NEW.memo, 'payeeid', NEW.payeeID, 'importid', NEW.importID, 'reconciled', NEW.reconciled));
+END;
+
+CREATE TRIGGER a_t_audit_d
+AFTER DELETE
+ON a_t
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t', json_object('id', OLD.ID, 'accountid', OLD.accountID, 'type', OLD.type, 'envelopeid', OLD.envelopeID, 'postdate', OLD.postDate, 'amount', OLD.amount, 'cleared', OLD.cleared, 'memo', OLD.memo, 'payeeid', OLD.payeeID, 'importid', OLD.importID, 'reconciled', OLD.reconciled), NULL);
+END;
+
+CREATE TRIGGER e_t_audit_i
+AFTER INSERT
+ON e_t
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_t', NULL, json_object('id', NEW.ID, 'envelopeid', NEW.envelopeID, 'postdate', NEW.postDate, 'amount', NEW.amount));
+END;
+
+CREATE TRIGGER e_t_audit_u
+AFTER UPDATE
+ON e_t
+WHEN json_object('id', OLD.ID, 'envelopeid', OLD.envelopeID, 'postdate', OLD.postDate, 'amount', OLD.amount) IS NOT json_object('id', NEW.ID, 'envelopeid', NEW.envelopeID, 'postdate', NEW.postDate, 'amount', NEW.amount)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_t', json_object('id', OLD.ID, 'envelopeid', OLD.envelopeID, 'postdate', OLD.postDate, 'amount', OLD.amount), json_object('id', NEW.ID, 'envelopeid', NEW.envelopeID, 'postdate', NEW.postDate, 'amount', NEW.amount));
+END;
+
+CREATE TRIGGER e_t_audit_d
+AFTER DELETE
+ON e_t
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_t', json_object('id', OLD.ID, 'envelopeid', OLD.envelopeID, 'postdate', OLD.postDate, 'amount', OLD.amount), NULL);
+END;
+
+CREATE TRIGGER a_chk_audit_i
+AFTER INSERT
+ON a_chk
+WHEN NEW.month = 0
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_chk', NULL, json_object('accountid', NEW.accountID, 'month', NEW.month, 'bal', NEW.bal, 'in', NEW."in", 'out', NEW.out, 'uncleared', NEW.uncleared));
+END;
+
+CREATE TRIGGER a_chk_audit_u
+AFTER UPDATE
+ON a_chk
+WHEN NEW.month = 0 AND json_object('accountid', OLD.accountID, 'month', OLD.month, 'bal', OLD.bal, 'in', OLD."in", 'out', OLD.out, 'uncleared', OLD.uncleared) IS NOT json_object('accountid', NEW.accountID, 'month', NEW.month, 'bal', NEW.bal, 'in', NEW."in", 'out', NEW.out, 'uncleared', NEW.uncleared)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_chk', json_object('accountid', OLD.accountID, 'month', OLD.month, 'bal', OLD.bal, 'in', OLD."in", 'out', OLD.out, 'uncleared', OLD.uncleared), json_object('accountid', NEW.accountID, 'month', NEW.month, 'bal', NEW.bal, 'in', NEW."in", 'out', NEW.out, 'uncleared', NEW.uncleared));
+END;
+
+CREATE TRIGGER a_chk_audit_d
+AFTER DELETE
+ON a_chk
+WHEN OLD.month = 0
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_chk', json_object('accountid', OLD.accountID, 'month', OLD.month, 'bal', OLD.bal, 'in', OLD."in", 'out', OLD.out, 'uncleared', OLD.uncleared), NULL);
+END;
+
+CREATE TRIGGER e_chk_audit_i
+AFTER INSERT
+ON e_chk
+WHEN NEW.month = 0
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_chk', NULL, json_object('envelopeid', NEW.envelopeID, 'month', NEW.month, 'bal', NEW.bal, 'in', NEW."in", 'out', NEW.out));
+END;
+
+CREATE TRIGGER e_chk_audit_u
+AFTER UPDATE
+ON e_chk
+WHEN NEW.month = 0 AND json_object('envelopeid', OLD.envelopeID, 'month', OLD.month, 'bal', OLD.bal, 'in', OLD."in", 'out', OLD.out) IS NOT json_object('envelopeid', NEW.envelopeID, 'month', NEW.month, 'bal', NEW.bal, 'in', NEW."in", 'out', NEW.out)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_chk', json_object('envelopeid', OLD.envelopeID, 'month', OLD.month, 'bal', OLD.bal, 'in', OLD."in", 'out', OLD.out), json_object('envelopeid', NEW.envelopeID, 'month', NEW.month, 'bal', NEW.bal, 'in', NEW."in", 'out', NEW.out));
+END;
+
+CREATE TRIGGER e_chk_audit_d
+AFTER DELETE
+ON e_chk
+WHEN OLD.month = 0
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('e_chk', json_object('envelopeid', OLD.envelopeID, 'month', OLD.month, 'bal', OLD.bal, 'in', OLD."in", 'out', OLD.out), NULL);
+END;
+
+CREATE TRIGGER a_t_split_audit_i
+AFTER INSERT
+ON a_t_split
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t_split', NULL, json_object('id', NEW.ID, 'transactionid', NEW.transactionID, 'envelopeid', NEW.envelopeID, 'amount', NEW.amount, 'memo', NEW.memo));
+END;
+
+CREATE TRIGGER a_t_split_audit_u
+AFTER UPDATE
+ON a_t_split
+WHEN json_object('id', OLD.ID, 'transactionid', OLD.transactionID, 'envelopeid', OLD.envelopeID, 'amount', OLD.amount, 'memo', OLD.memo) IS NOT json_object('id', NEW.ID, 'transactionid', NEW.transactionID, 'envelopeid', NEW.envelopeID, 'amount', NEW.amount, 'memo', NEW.memo)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t_split', json_object('id', OLD.ID, 'transactionid', OLD.transactionID, 'envelopeid', OLD.envelopeID, 'amount', OLD.amount, 'memo', OLD.memo), json_object('id', NEW.ID, 'transactionid', NEW.transactionID, 'envelopeid', NEW.envelopeID, 'amount', NEW.amount, 'memo', NEW.memo));
+END;
+
+CREATE TRIGGER a_t_split_audit_d
+AFTER DELETE
+ON a_t_split
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t_split', json_object('id', OLD.ID, 'transactionid', OLD.transactionID, 'envelopeid', OLD.envelopeID, 'amount', OLD.amount, 'memo', OLD.memo), NULL);
+END;
+
+CREATE TRIGGER a_t_transfer_audit_i
+AFTER INSERT
+ON a_t_transfer
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t_transfer', NULL, json_object('id', NEW.ID, 'fromid', NEW.fromID, 'toid', NEW.toID));
+END;
+
+CREATE TRIGGER a_t_transfer_audit_u
+AFTER UPDATE
+ON a_t_transfer
+WHEN json_object('id', OLD.ID, 'fromid', OLD.fromID, 'toid', OLD.toID) IS NOT json_object('id', NEW.ID, 'fromid', NEW.fromID, 'toid', NEW.toID)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t_transfer', json_object('id', OLD.ID, 'fromid', OLD.fromID, 'toid', OLD.toID), json_object('id', NEW.ID, 'fromid', NEW.fromID, 'toid', NEW.toID));
+END;
+
+CREATE TRIGGER a_t_transfer_audit_d
+AFTER DELETE
+ON a_t_transfer
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('a_t_transfer', json_object('id', OLD.ID, 'fromid', OLD.fromID, 'toid', OLD.toID), NULL);
+END;
+
+CREATE TRIGGER p_audit_i
+AFTER INSERT
+ON p
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('p', NULL, json_object('id', NEW.ID, 'name', NEW.name, 'envelopeid', NEW.envelopeID));
+END;
+
+CREATE TRIGGER p_audit_u
+AFTER UPDATE
+ON p
+WHEN json_object('id', OLD.ID, 'name', OLD.name, 'envelopeid', OLD.envelopeID) IS NOT json_object('id', NEW.ID, 'name', NEW.name, 'envelopeid', NEW.envelopeID)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('p', json_object('id', OLD.ID, 'name', OLD.name, 'envelopeid', OLD.envelopeID), json_object('id', NEW.ID, 'name', NEW.name, 'envelopeid', NEW.envelopeID));
+END;
+
+CREATE TRIGGER p_audit_d
+AFTER DELETE
+ON p
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('p', json_object('id', OLD.ID, 'name', OLD.name, 'envelopeid', OLD.envelopeID), NULL);
+END;
+
+CREATE TRIGGER r_audit_i
+AFTER INSERT
+ON r
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('r', NULL, json_object('id', NEW.ID, 'priority', NEW.priority, 'name', NEW.name, 'memopattern', NEW.memoPattern, 'minamount', NEW.minAmount, 'maxamount', NEW.maxAmount, 'accountid', NEW.accountID, 'fromdate', NEW.fromDate, 'todate', NEW.toDate, 'envelopeid', NEW.envelopeID, 'type', NEW.type, 'cleared', NEW.cleared, 'memo', NEW.memo));
+END;
+
+CREATE TRIGGER r_audit_u
+AFTER UPDATE
+ON r
+WHEN json_object('id', OLD.ID, 'priority', OLD.priority, 'name', OLD.name, 'memopattern', OLD.memoPattern, 'minamount', OLD.minAmount, 'maxamount', OLD.maxAmount, 'accountid', OLD.accountID, 'fromdate', OLD.fromDate, 'todate', OLD.toDate, 'envelopeid', OLD.envelopeID, 'type', OLD.type, 'cleared', OLD.cleared, 'memo', OLD.memo) IS NOT json_object('id', NEW.ID, 'priority', NEW.priority, 'name', NEW.name, 'memopattern', NEW.memoPattern, 'minamount', NEW.minAmount, 'maxamount', NEW.maxAmount, 'accountid', NEW.accountID, 'fromdate', NEW.fromDate, 'todate', NEW.toDate, 'envelopeid', NEW.envelopeID, 'type', NEW.type, 'cleared', NEW.cleared, 'memo', NEW.memo)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('r', json_object('id', OLD.ID, 'priority', OLD.priority, 'name', OLD.name, 'memopattern', OLD.memoPattern, 'minamount', OLD.minAmount, 'maxamount', OLD.maxAmount, 'accountid', OLD.accountID, 'fromdate', OLD.fromDate, 'todate', OLD.toDate, 'envelopeid', OLD.envelopeID, 'type', OLD.type, 'cleared', OLD.cleared, 'memo', OLD.memo), json_object('id', NEW.ID, 'priority', NEW.priority, 'name', NEW.name, 'memopattern', NEW.memoPattern, 'minamount', NEW.minAmount, 'maxamount', NEW.maxAmount, 'accountid', NEW.accountID, 'fromdate', NEW.fromDate, 'todate', NEW.toDate, 'envelopeid', NEW.envelopeID, 'type', NEW.type, 'cleared', NEW.cleared, 'memo', NEW.memo));
+END;
+
+CREATE TRIGGER r_audit_d
+AFTER DELETE
+ON r
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('r', json_object('id', OLD.ID, 'priority', OLD.priority, 'name', OLD.name, 'memopattern', OLD.memoPattern, 'minamount', OLD.minAmount, 'maxamount', OLD.maxAmount, 'accountid', OLD.accountID, 'fromdate', OLD.fromDate, 'todate', OLD.toDate, 'envelopeid', OLD.envelopeID, 'type', OLD.type, 'cleared', OLD.cleared, 'memo', OLD.memo), NULL);
+END;
+
+CREATE TRIGGER csv_profile_audit_i
+AFTER INSERT
+ON csv_profile
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('csv_profile', NULL, json_object('id', NEW.ID, 'name', NEW.name, 'delimiter', NEW.delimiter, 'skiprows', NEW.skipRows, 'header', NEW.header, 'datecolumn', NEW.dateColumn, 'dateformat', NEW.dateFormat, 'amountcolumn', NEW.amountColumn, 'debitcolumn', NEW.debitColumn, 'creditcolumn', NEW.creditColumn, 'negate', NEW.negate, 'decimalcomma', NEW.decimalComma, 'memocolumn', NEW.memoColumn, 'clearedcolumn', NEW.clearedColumn, 'clearedvalue', NEW.clearedValue, 'idcolumn', NEW.idColumn));
+END;
+
+CREATE TRIGGER csv_profile_audit_u
+AFTER UPDATE
+ON csv_profile
+WHEN json_object('id', OLD.ID, 'name', OLD.name, 'delimiter', OLD.delimiter, 'skiprows', OLD.skipRows, 'header', OLD.header, 'datecolumn', OLD.dateColumn, 'dateformat', OLD.dateFormat, 'amountcolumn', OLD.amountColumn, 'debitcolumn', OLD.debitColumn, 'creditcolumn', OLD.creditColumn, 'negate', OLD.negate, 'decimalcomma', OLD.decimalComma, 'memocolumn', OLD.memoColumn, 'clearedcolumn', OLD.clearedColumn, 'clearedvalue', OLD.clearedValue, 'idcolumn', OLD.idColumn) IS NOT json_object('id', NEW.ID, 'name', NEW.name, 'delimiter', NEW.delimiter, 'skiprows', NEW.skipRows, 'header', NEW.header, 'datecolumn', NEW.dateColumn, 'dateformat', NEW.dateFormat, 'amountcolumn', NEW.amountColumn, 'debitcolumn', NEW.debitColumn, 'creditcolumn', NEW.creditColumn, 'negate', NEW.negate, 'decimalcomma', NEW.decimalComma, 'memocolumn', NEW.memoColumn, 'clearedcolumn', NEW.clearedColumn, 'clearedvalue', NEW.clearedValue, 'idcolumn', NEW.idColumn)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('csv_profile', json_object('id', OLD.ID, 'name', OLD.name, 'delimiter', OLD.delimiter, 'skiprows', OLD.skipRows, 'header', OLD.header, 'datecolumn', OLD.dateColumn, 'dateformat', OLD.dateFormat, 'amountcolumn', OLD.amountColumn, 'debitcolumn', OLD.debitColumn, 'creditcolumn', OLD.creditColumn, 'negate', OLD.negate, 'decimalcomma', OLD.decimalComma, 'memocolumn', OLD.memoColumn, 'clearedcolumn', OLD.clearedColumn, 'clearedvalue', OLD.clearedValue, 'idcolumn', OLD.idColumn), json_object('id', NEW.ID, 'name', NEW.name, 'delimiter', NEW.delimiter, 'skiprows', NEW.skipRows, 'header', NEW.header, 'datecolumn', NEW.dateColumn, 'dateformat', NEW.dateFormat, 'amountcolumn', NEW.amountColumn, 'debitcolumn', NEW.debitColumn, 'creditcolumn', NEW.creditColumn, 'negate', NEW.negate, 'decimalcomma', NEW.decimalComma, 'memocolumn', NEW.memoColumn, 'clearedcolumn', NEW.clearedColumn, 'clearedvalue', NEW.clearedValue, 'idcolumn', NEW.idColumn));
+END;
+
+CREATE TRIGGER csv_profile_audit_d
+AFTER DELETE
+ON csv_profile
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('csv_profile', json_object('id', OLD.ID, 'name', OLD.name, 'delimiter', OLD.delimiter, 'skiprows', OLD.skipRows, 'header', OLD.header, 'datecolumn', OLD.dateColumn, 'dateformat', OLD.dateFormat, 'amountcolumn', OLD.amountColumn, 'debitcolumn', OLD.debitColumn, 'creditcolumn', OLD.creditColumn, 'negate', OLD.negate, 'decimalcomma', OLD.decimalComma, 'memocolumn', OLD.memoColumn, 'clearedcolumn', OLD.clearedColumn, 'clearedvalue', OLD.clearedValue, 'idcolumn', OLD.idColumn), NULL);
+END;
+
+CREATE TRIGGER sch_audit_i
+AFTER INSERT
+ON sch
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('sch', NULL, json_object('id', NEW.ID, 'accountid', NEW.accountID, 'envelopeid', NEW.envelopeID, 'payeeid', NEW.payeeID, 'type', NEW.type, 'amount', NEW.amount, 'memo', NEW.memo, 'kind', NEW.kind, 'n', NEW.n, 'startdate', NEW.startDate, 'enddate', NEW.endDate, 'lastdate', NEW.lastDate));
+END;
+
+CREATE TRIGGER sch_audit_u
+AFTER UPDATE
+ON sch
+WHEN json_object('id', OLD.ID, 'accountid', OLD.accountID, 'envelopeid', OLD.envelopeID, 'payeeid', OLD.payeeID, 'type', OLD.type, 'amount', OLD.amount, 'memo', OLD.memo, 'kind', OLD.kind, 'n', OLD.n, 'startdate', OLD.startDate, 'enddate', OLD.endDate, 'lastdate', OLD.lastDate) IS NOT json_object('id', NEW.ID, 'accountid', NEW.accountID, 'envelopeid', NEW.envelopeID, 'payeeid', NEW.payeeID, 'type', NEW.type, 'amount', NEW.amount, 'memo', NEW.memo, 'kind', NEW.kind, 'n', NEW.n, 'startdate', NEW.startDate, 'enddate', NEW.endDate, 'lastdate', NEW.lastDate)
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('sch', json_object('id', OLD.ID, 'accountid', OLD.accountID, 'envelopeid', OLD.envelopeID, 'payeeid', OLD.payeeID, 'type', OLD.type, 'amount', OLD.amount, 'memo', OLD.memo, 'kind', OLD.kind, 'n', OLD.n, 'startdate', OLD.startDate, 'enddate', OLD.endDate, 'lastdate', OLD.lastDate), json_object('id', NEW.ID, 'accountid', NEW.accountID, 'envelopeid', NEW.envelopeID, 'payeeid', NEW.payeeID, 'type', NEW.type, 'amount', NEW.amount, 'memo', NEW.memo, 'kind', NEW.kind, 'n', NEW.n, 'startdate', NEW.startDate, 'enddate', NEW.endDate, 'lastdate', NEW.lastDate));
+END;
+
+CREATE TRIGGER sch_audit_d
+AFTER DELETE
+ON sch
+BEGIN
+    INSERT INTO audit (tbl, oldRow, newRow) VALUES ('sch', json_object('id', OLD.ID, 'accountid', OLD.accountID, 'envelopeid', OLD.envelopeID, 'payeeid', OLD.payeeID, 'type', OLD.type, 'amount', OLD.amount, 'memo', OLD.memo, 'kind', OLD.kind, 'n', OLD.n, 'startdate', OLD.startDate, 'enddate', OLD.endDate, 'lastdate', OLD.lastDate), NULL);
+END;
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"errors"
+	"fmt"
+)
+
+// Moving a transaction to another account removes it from the old account and enters it again on the new one
+// Both happen in one DB transaction and the moved transaction gets a new ID
+// UpdateAccountTransaction keeps the account, transfer legs move with their transfer and reconciled transactions stay put
+
+var ErrInvalidMove = errors.New("invalid transaction move")
+
+func validateMove(old, at model.AccountTransaction, leg bool) error {
+	if old.AccountID == at.AccountID {
+		return fmt.Errorf("%w: transaction %d is already on account %d", ErrInvalidMove, old.ID, at.AccountID)
+	}
+	if leg {
+		return fmt.Errorf("%w: transaction %d is a transfer leg", ErrInvalidTransfer, old.ID)
+	}
+	if old.Reconciled {
+		return fmt.Errorf("%w: transaction %d", ErrReconciled, old.ID)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package db
+
+import "budgeting/internal/pkg/model"
+
+// Payees: a name per counterparty and the envelope its spending usually goes to
+
+// Only ordinary spending picks up the payee's envelope, income, transfers and adjustments stay as given
+func wantsPayeeDefault(at model.AccountTransaction) bool {
+	return at.PayeeID.Valid && !at.EnvelopeID.Valid && !at.IsSplit() && at.Typ == model.TT_NORM
+}
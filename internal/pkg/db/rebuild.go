@@ -0,0 +1,147 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// Repair mode: throw away every monthly checkpoint and recompute them from a_t and e_t
+// EPOCH checkpoints hold the starting balances, so they are kept, and only created where missing
+// The drivers lock, then run resetCheckpoints and their own update* functions, so a rebuild produces exactly what the normal write paths would
+
+// One checkpoint value a rebuild changed, or would change on a dry run
+// Before or After is "missing" when the row does not exist on that side
+type CheckpointChange struct {
+	Table  string
+	Key    string
+	Column string
+	Before string
+	After  string
+}
+
+func (c CheckpointChange) String() string {
+	return fmt.Sprintf("%s[%s].%s: %s -> %s", c.Table, c.Key, c.Column, c.Before, c.After)
+}
+
+// Statements shared by both drivers to clear the way for a rebuild
+var resetCheckpointQueries = []string{
+	// Checkpoints of rows that no longer exist
+	"DELETE FROM a_chk WHERE accountID NOT IN (SELECT ID FROM a)",
+	"DELETE FROM e_chk WHERE envelopeID NOT IN (SELECT ID FROM e)",
+
+	"DELETE FROM a_chk WHERE month > 0",
+	"DELETE FROM e_chk WHERE month > 0",
+	"DELETE FROM s_chk WHERE month > 0",
+
+	// EPOCH only carries a balance, flows start with the first month
+	"UPDATE a_chk SET \"in\" = 0, out = 0, uncleared = 0 WHERE month = 0",
+	"UPDATE e_chk SET \"in\" = 0, out = 0 WHERE month = 0",
+
+	"INSERT INTO a_chk (accountID, month) SELECT ID, 0 FROM a WHERE ID NOT IN (SELECT accountID FROM a_chk WHERE month = 0)",
+	"INSERT INTO e_chk (envelopeID, month) SELECT ID, 0 FROM e WHERE ID NOT IN (SELECT envelopeID FROM e_chk WHERE month = 0)",
+	"INSERT INTO s_chk (month) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM s_chk WHERE month = 0)",
+}
+
+// Clear all monthly checkpoints, returning the account and envelope IDs to recompute
+func resetCheckpoints(tx *sql.Tx) (aids []model.PKEY, eids []model.PKEY, err error) {
+	for _, query := range resetCheckpointQueries {
+		if _, err := tx.Exec(query); err != nil {
+			return nil, nil, fmt.Errorf("resetCheckpoints.Exec -- %s -- %w", query, err)
+		}
+	}
+
+	if aids, err = selectIDs(tx, "SELECT ID FROM a ORDER BY ID"); err != nil {
+		return nil, nil, fmt.Errorf("resetCheckpoints.Select.a -- %w", err)
+	}
+	if eids, err = selectIDs(tx, "SELECT ID FROM e ORDER BY ID"); err != nil {
+		return nil, nil, fmt.Errorf("resetCheckpoints.Select.e -- %w", err)
+	}
+
+	return aids, eids, nil
+}
+
+func selectIDs(q queryer, query string) ([]model.PKEY, error) {
+	ids := make([]model.PKEY, 0)
+
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id model.PKEY
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Snapshot of the three checkpoint tables, compared before and after a rebuild
+type checkpoints struct {
+	a map[model.PKEY]map[bcdate.BCDate][]int
+	e map[model.PKEY]map[bcdate.BCDate][]int
+	s map[bcdate.BCDate][]int
+}
+
+func snapshotCheckpoints(q queryer) (checkpoints, error) {
+	a, e, s, err := loadCheckpoints(q)
+	return checkpoints{a, e, s}, err
+}
+
+func diffCheckpoints(before, after checkpoints) []CheckpointChange {
+	changes := make([]CheckpointChange, 0)
+
+	diffOwned := func(table, keyName string, cols []string, b, a map[model.PKEY]map[bcdate.BCDate][]int) {
+		ids := make(map[model.PKEY]bool)
+		for id := range b {
+			ids[id] = true
+		}
+		for id := range a {
+			ids[id] = true
+		}
+		for _, id := range sortedKeys(ids) {
+			changes = diffMonths(changes, table, func(m bcdate.BCDate) string { return chkKey(keyName, id, m) }, cols, b[id], a[id])
+		}
+	}
+
+	diffOwned("a_chk", "accountID", aChkCols, before.a, after.a)
+	diffOwned("e_chk", "envelopeID", eChkCols, before.e, after.e)
+	changes = diffMonths(changes, "s_chk", monthKey, sChkCols, before.s, after.s)
+
+	return changes
+}
+
+func diffMonths(changes []CheckpointChange, table string, key func(bcdate.BCDate) string, cols []string, before, after map[bcdate.BCDate][]int) []CheckpointChange {
+	months := make(map[bcdate.BCDate]bool)
+	for m := range before {
+		months[m] = true
+	}
+	for m := range after {
+		months[m] = true
+	}
+
+	for _, m := range sortedMonths(months) {
+		b, bok := before[m]
+		a, aok := after[m]
+		for i, col := range cols {
+			bv, av := missing, missing
+			if bok {
+				bv = strconv.Itoa(b[i])
+			}
+			if aok {
+				av = strconv.Itoa(a[i])
+			}
+			if bv != av {
+				changes = append(changes, CheckpointChange{table, key(m), col, bv, av})
+			}
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"database/sql"
+)
+
+// Checkpoint recompute, shared between the drivers
+// Each update* function runs one bounds query, then a single INSERT ... SELECT that walks a generated series of months
+// Monthly flows are grouped once, and balances are a running sum over the series on top of the last checkpoint before it, so the cost no longer grows with one round trip per month
+
+// Recursive CTE body producing every month from the first to the second bind parameter, both YYYYMM00
+// Driver files wrap it with their own placeholders
+const monthSeries = `months(m) AS (
+	SELECT %[1]s
+	UNION ALL
+	SELECT CASE WHEN m %% 10000 = 1200 THEN m + 8900 ELSE m + 100 END FROM months WHERE m < %[2]s
+)`
+
+// Months a recompute from start has to rewrite
+// From start's month, or from the first month holding any data when start is EPOCH, through the current month or the last month holding any data, whichever is later
+// ok is false when there is nothing to write
+func recomputeRange(start bcdate.BCDate, firsts []sql.NullInt32, lasts []sql.NullInt32) (from bcdate.BCDate, to bcdate.BCDate, ok bool) {
+	from = start - (start % 100)
+	if start == bcdate.Epoch() {
+		from = bcdate.Never()
+		for _, f := range firsts {
+			if f.Valid {
+				from = bcdate.Oldest(from, monthOf(f.Int32))
+			}
+		}
+		if from == bcdate.Never() {
+			return 0, 0, false
+		}
+	}
+
+	to = bcdate.CurrentMonth()
+	for _, l := range lasts {
+		if l.Valid {
+			to = bcdate.Latest(to, monthOf(l.Int32))
+		}
+	}
+
+	return from, to, from <= to
+}
+
+func monthOf(d int32) bcdate.BCDate {
+	m := bcdate.BCDate(d)
+	return m - (m % 100)
+}
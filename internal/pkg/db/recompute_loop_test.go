@@ -0,0 +1,414 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+// The month by month recompute that the set based queries replaced
+// Kept only for tests, as the baseline of BenchmarkRecompute and the oracle of TestRecomputeMatchesLoop
+// Predates split transactions and ignores a_t_split, so only compare it on ledgers without splits
+
+func (s *SQLite) updateAccountSummariesLoop(tx *sql.Tx, start bcdate.BCDate, aID model.PKEY) error {
+	oldest := start
+	if oldest == bcdate.Epoch() {
+		oldest = bcdate.Never()
+	}
+	oldest = oldest - (oldest % 100)
+
+	var oldest_t sql.NullInt32
+	var oldest_m bcdate.BCDate
+	err := tx.QueryRow("SELECT min(postDate) FROM a_t WHERE accountID = ? AND postDate >= ?", aID, start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateAccountSummariesLoop.Select.a_t.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+	err = tx.QueryRow("SELECT min(month) FROM a_chk WHERE accountID = ? AND month >= ? AND month > 0", aID, start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateAccountSummariesLoop.Select.a_chk.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+
+	if oldest == bcdate.Never() {
+		return nil
+	}
+
+	var latest bcdate.BCDate = bcdate.CurrentMonth()
+	var latest_t sql.NullInt32
+	var latest_m bcdate.BCDate
+	err = tx.QueryRow("SELECT max(postDate) FROM a_t WHERE accountID = ?", aID).Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateAccountSummariesLoop.Select.a_t.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(month) FROM a_chk WHERE accountID = ? AND month > 0", aID).Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateAccountSummariesLoop.Select.a_chk.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+
+	for ; oldest <= latest; oldest = oldest.NextMonth() {
+
+		var lastbal int
+		var bal int
+		var in int
+		var out int
+		var uncleared int
+
+		err := tx.QueryRow("SELECT bal FROM a_chk WHERE accountID = ? AND month < ? ORDER BY month DESC LIMIT 1", aID, oldest).Scan(&lastbal)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("updateAccountSummariesLoop.Select.a_chk.lastbal -- %w", err)
+		}
+
+		err = tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE accountID = ? AND postDate-mod(postDate,100) = ? AND amount > 0", aID, oldest).Scan(&in)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("updateAccountSummariesLoop.Select.a_t.in -- %w", err)
+		}
+
+		err = tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE accountID = ? AND postDate-mod(postDate,100) = ? AND amount < 0", aID, oldest).Scan(&out)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("updateAccountSummariesLoop.Select.a_t.out -- %w", err)
+		}
+		bal = lastbal + in + out
+
+		err = tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE accountID = ? AND postDate-mod(postDate,100) = ? AND cleared = 0", aID, oldest).Scan(&uncleared)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("updateAccountSummariesLoop.Select.a_t.uncleared -- %w", err)
+		}
+
+		_, err = tx.Exec("INSERT OR REPLACE INTO a_chk (accountID,month,bal,\"in\",out,uncleared) VALUES (?,?,?,?,?,?)", aID, oldest, bal, in, out, uncleared)
+		if err != nil {
+			return fmt.Errorf("updateAccountSummariesLoop.Replace.a_chk -- %w", err)
+		}
+
+	}
+
+	return nil
+}
+
+func (s *SQLite) updateEnvelopeSummariesLoop(tx *sql.Tx, start bcdate.BCDate, eID model.PKEY) error {
+	oldest := start
+	if oldest == bcdate.Epoch() {
+		oldest = bcdate.Never()
+	}
+	oldest = oldest - (oldest % 100)
+
+	var oldest_t sql.NullInt32
+	var oldest_m bcdate.BCDate
+	err := tx.QueryRow("SELECT min(postDate) FROM a_t WHERE envelopeID = ? AND postDate >= ?", eID, start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateEnvelopeSummariesLoop.Select.a_t.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+	err = tx.QueryRow("SELECT min(postDate) FROM e_t WHERE envelopeID = ? AND postDate >= ?", eID, start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_t.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+	err = tx.QueryRow("SELECT min(month) FROM e_chk WHERE envelopeID = ? AND month >= ? AND month > 0", eID, start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_chk.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+
+	if oldest == bcdate.Never() {
+		return nil
+	}
+
+	var latest bcdate.BCDate = bcdate.CurrentMonth()
+	var latest_t sql.NullInt32
+	var latest_m bcdate.BCDate
+	err = tx.QueryRow("SELECT max(postDate) FROM a_t WHERE envelopeID = ?", eID).Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateEnvelopeSummariesLoop.Select.a_t.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(postDate) FROM e_t WHERE envelopeID = ?", eID).Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_t.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(month) FROM e_chk WHERE envelopeID = ? AND month > 0", eID).Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_chk.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+
+	for ; oldest <= latest; oldest = oldest.NextMonth() {
+
+		var lastbal int
+		var bal int
+		var in int
+		var in_a int
+		var out int
+		var out_a int
+
+		if err := tx.QueryRow("SELECT bal FROM e_chk WHERE envelopeID = ? AND month < ? ORDER BY month DESC LIMIT 1", eID, oldest).Scan(&lastbal); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_chk.lastbal -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount > 0", eID, oldest).Scan(&in_a); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateEnvelopeSummariesLoop.Select.a_t.in -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount < 0", eID, oldest).Scan(&out_a); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateEnvelopeSummariesLoop.Select.a_t.out -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM e_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount > 0", eID, oldest).Scan(&in); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_t.in -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM e_t WHERE envelopeID = ? AND postDate-mod(postDate,100) = ? AND amount < 0", eID, oldest).Scan(&out); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateEnvelopeSummariesLoop.Select.e_t.out -- %w", err)
+			}
+		}
+		bal = lastbal + in_a + out_a + in + out
+
+		_, err = tx.Exec("INSERT OR REPLACE INTO e_chk (envelopeID,month,bal,\"in\",out) VALUES (?,?,?,?,?)", eID, oldest, bal, in+out, in_a+out_a)
+		if err != nil {
+			return fmt.Errorf("updateEnvelopeSummariesLoop.Replace.e_chk -- %w", err)
+		}
+
+	}
+
+	return nil
+}
+
+func (s *SQLite) updateSummariesLoop(tx *sql.Tx, start bcdate.BCDate) error {
+	oldest := start
+	if oldest == bcdate.Epoch() {
+		oldest = bcdate.Never()
+	}
+	oldest = oldest - (oldest % 100)
+
+	var oldest_t sql.NullInt32
+	var oldest_m bcdate.BCDate
+	err := tx.QueryRow("SELECT min(postDate) FROM a_t WHERE postDate >= ?", start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.a_t.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+
+	err = tx.QueryRow("SELECT min(postDate) FROM e_t WHERE postDate >= ?", start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.e_t.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+	err = tx.QueryRow("SELECT min(month) FROM a_chk WHERE month >= ? AND month > 0", start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.a_chk.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+	err = tx.QueryRow("SELECT min(month) FROM e_chk WHERE month >= ? AND month > 0", start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.e_chk.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+	err = tx.QueryRow("SELECT min(month) FROM s_chk WHERE month >= ? AND month > 0", start).Scan(&oldest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.s_chk.Min -- %w", err)
+	}
+	if oldest_t.Valid {
+		oldest_m = bcdate.BCDate(oldest_t.Int32)
+		oldest_m = oldest_m - (oldest_m % 100)
+
+		oldest = bcdate.Oldest(oldest, oldest_m)
+	}
+
+	if oldest == bcdate.Never() {
+		return nil
+	}
+
+	var latest bcdate.BCDate = bcdate.CurrentMonth()
+	var latest_t sql.NullInt32
+	var latest_m bcdate.BCDate
+	err = tx.QueryRow("SELECT max(postDate) FROM a_t").Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.a_t.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(postDate) FROM e_t").Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.e_t.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(month) FROM e_chk WHERE month > 0").Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.e_chk.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(month) FROM a_chk WHERE month > 0").Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.a_chk.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+	err = tx.QueryRow("SELECT max(month) FROM s_chk WHERE month > 0").Scan(&latest_t)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("updateSummariesLoop.Select.s_chk.Max -- %w", err)
+	}
+	if latest_t.Valid {
+		latest_m = bcdate.BCDate(latest_t.Int32)
+		latest_m = latest_m - (latest_m % 100)
+
+		latest = bcdate.Latest(latest, latest_m)
+	}
+
+	for ; oldest <= latest; oldest = oldest.NextMonth() {
+
+		var a_bal int
+		var e_bal int
+
+		if err := tx.QueryRow("SELECT coalesce(sum(bal),0) FROM ( SELECT bal, max(month) FROM a_chk JOIN a ON a_chk.accountID = a.ID WHERE month <= ? AND debt = 0 AND offbudget = 0 GROUP BY accountID )", oldest).Scan(&a_bal); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.a_chk.debt.bal -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(bal),0) FROM ( SELECT bal, max(month) FROM e_chk WHERE month <= ? GROUP BY envelopeID )", oldest).Scan(&e_bal); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.e_chk.bal -- %w", err)
+			}
+		}
+
+		var float int = a_bal - e_bal
+
+		var banked int
+		if err := tx.QueryRow("SELECT coalesce(sum(bal),0) FROM ( SELECT bal, max(month) FROM a_chk JOIN a ON a_chk.accountID = a.ID WHERE month <= ? AND offbudget = 0 GROUP BY accountID )", oldest).Scan(&banked); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.a_chk.debt.bal -- %w", err)
+			}
+		}
+
+		var nw int
+		if err := tx.QueryRow("SELECT coalesce(sum(bal),0) FROM ( SELECT bal, max(month) FROM a_chk WHERE month <= ? GROUP BY accountID )", oldest).Scan(&nw); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.a_chk.banked -- %w", err)
+			}
+		}
+
+		var inc int
+		var exp int
+		var delta int
+
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t JOIN a ON a_t.accountID = a.ID WHERE a.offbudget = 0 AND postDate-mod(postDate,100) = ? AND type = 1", oldest).Scan(&inc); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.inc -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t JOIN a ON a_t.accountID = a.ID WHERE a.offbudget = 0 AND postDate-mod(postDate,100) = ? AND type = 0", oldest).Scan(&exp); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.inc -- %w", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT coalesce(sum(amount),0) FROM a_t JOIN a ON a_t.accountID = a.ID WHERE a.offbudget = 0 AND postDate-mod(postDate,100) = ?", oldest).Scan(&delta); err != nil {
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("updateSummariesLoop.Select.inc -- %w", err)
+			}
+		}
+
+		_, err = tx.Exec("INSERT OR REPLACE INTO s_chk (month,float,income,expenses,delta,banked,netWorth) VALUES (?,?,?,?,?,?,?)", oldest, float, inc, exp, delta, banked, nw)
+		if err != nil {
+			return fmt.Errorf("updateSummariesLoop.Replace.e_chk -- %w", err)
+		}
+
+	}
+
+	return nil
+}
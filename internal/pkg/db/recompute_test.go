@@ -0,0 +1,287 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// The two ways of recomputing checkpoints, the set based queries and the month by month loops they replaced
+type recomputePath struct {
+	name     string
+	account  func(tx *sql.Tx, start bcdate.BCDate, aID model.PKEY) error
+	envelope func(tx *sql.Tx, start bcdate.BCDate, eID model.PKEY) error
+	summary  func(tx *sql.Tx, start bcdate.BCDate) error
+}
+
+func recomputePaths(s *SQLite) []recomputePath {
+	return []recomputePath{
+		{"set", s.updateAccountSummaries, s.updateEnvelopeSummaries, s.updateSummaries},
+		{"loop", s.updateAccountSummariesLoop, s.updateEnvelopeSummariesLoop, s.updateSummariesLoop},
+	}
+}
+
+func (p recomputePath) run(tx *sql.Tx, start bcdate.BCDate, aids []model.PKEY, eids []model.PKEY) error {
+	for _, id := range aids {
+		if err := p.account(tx, start, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range eids {
+		if err := p.envelope(tx, start, id); err != nil {
+			return err
+		}
+	}
+	return p.summary(tx, start)
+}
+
+// A household ledger covering the given number of years up to the current month
+// Salary, budgeting, a savings transfer, card spending and payment, and off budget investment swings every month, about 50 transactions a month
+func newLedger(tb testing.TB, years int) *SQLite {
+	tb.Helper()
+
+	s := NewSQLite().(*SQLite)
+	if err := s.Open(filepath.Join(tb.TempDir(), "ledger.db")); err != nil {
+		tb.Fatalf("Open: %s", err)
+	}
+	if err := s.Init(); err != nil {
+		tb.Fatalf("Init: %s", err)
+	}
+
+	checking := model.Account{Institution: "Bank", Name: "Checking", Class: model.AT_CHECKING}
+	savings := model.Account{Institution: "Bank", Name: "Savings", Class: model.AT_SAVINGS}
+	visa := model.Account{Institution: "Card Co", Name: "Visa", Class: model.AT_CREDITCARD, Debt: true}
+	brokerage := model.Account{Institution: "Broker", Name: "Brokerage", Class: model.AT_INVESTMENT, Offbudget: true}
+	for _, a := range []*model.Account{&checking, &savings, &visa, &brokerage} {
+		if err := s.NewAccount(a); err != nil {
+			tb.Fatalf("NewAccount: %s", err)
+		}
+	}
+	if err := s.SetStartingBalance(checking.ID, 250000); err != nil {
+		tb.Fatalf("SetStartingBalance: %s", err)
+	}
+
+	var visaEnvelope model.PKEY
+	if err := s.db.QueryRow("SELECT ID FROM e WHERE debtAccount = ?", visa.ID).Scan(&visaEnvelope); err != nil {
+		tb.Fatalf("Select debt envelope: %s", err)
+	}
+
+	budgets := []struct {
+		name   string
+		amount int
+	}{
+		{"Groceries", 60000},
+		{"Rent", 150000},
+		{"Utilities", 25000},
+		{"Dining", 20000},
+		{"Holiday", 15000},
+	}
+	envelopes := make([]model.PKEY, len(budgets))
+	for i, b := range budgets {
+		e := model.Envelope{GroupID: 1, Name: b.name}
+		if err := s.NewEnvelope(&e); err != nil {
+			tb.Fatalf("NewEnvelope: %s", err)
+		}
+		envelopes[i] = e.ID
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		tb.Fatalf("Begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	at := func(aid model.PKEY, eid model.PKEY, typ model.TransactionType, date bcdate.BCDate, amount int, cleared bool, memo string) {
+		envelope := sql.NullInt32{Int32: int32(eid), Valid: eid != 0}
+		if _, err := tx.Exec("INSERT INTO a_t (accountID, envelopeID, type, postDate, amount, cleared, memo) VALUES (?,?,?,?,?,?,?)", aid, envelope, typ, date, amount, cleared, memo); err != nil {
+			tb.Fatalf("Insert a_t: %s", err)
+		}
+	}
+	et := func(eid model.PKEY, date bcdate.BCDate, amount int) {
+		if _, err := tx.Exec("INSERT INTO e_t (envelopeID, postDate, amount) VALUES (?,?,?)", eid, date, amount); err != nil {
+			tb.Fatalf("Insert e_t: %s", err)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	current := bcdate.CurrentMonth()
+	first := current
+	for i := 1; i < years*12; i++ {
+		first = first.PrevMonth()
+	}
+
+	for m := first; m <= current; m = m.NextMonth() {
+		cleared := func() bool { return m < current || r.Intn(2) == 0 }
+
+		at(checking.ID, 0, model.TT_INCOME, m+1, 350000, true, "Salary")
+		for i, b := range budgets {
+			et(envelopes[i], m+1, b.amount)
+		}
+
+		at(checking.ID, 0, model.TT_TRANSFER, m+2, -20000, true, "To savings")
+		at(savings.ID, 0, model.TT_TRANSFER, m+2, 20000, true, "From checking")
+
+		for j := 0; j < 45; j++ {
+			i := r.Intn(len(budgets))
+			aid := checking.ID
+			if r.Intn(3) == 0 {
+				aid = visa.ID
+			}
+			at(aid, envelopes[i], model.TT_NORM, m+bcdate.BCDate(1+r.Intn(28)), -(100 + r.Intn(budgets[i].amount/15)), cleared(), budgets[i].name)
+		}
+
+		payment := 30000 + r.Intn(20000)
+		at(checking.ID, visaEnvelope, model.TT_TRANSFER, m+25, -payment, cleared(), "Card payment")
+		at(visa.ID, 0, model.TT_TRANSFER, m+25, payment, cleared(), "Card payment")
+
+		at(brokerage.ID, 0, model.TT_INCOME, m+28, r.Intn(40000)-15000, true, "Market")
+	}
+
+	if err := tx.Commit(); err != nil {
+		tb.Fatalf("Commit: %s", err)
+	}
+
+	if _, err := s.Rebuild(false); err != nil {
+		tb.Fatalf("Rebuild: %s", err)
+	}
+
+	return s
+}
+
+// The newest checking purchase, moved around by the tests and benchmarks
+func latestPurchase(tb testing.TB, s *SQLite) model.AccountTransaction {
+	tb.Helper()
+	var id model.PKEY
+	if err := s.db.QueryRow("SELECT ID FROM a_t WHERE accountID = 1 AND type = 0 AND envelopeID IS NOT NULL ORDER BY postDate DESC, ID DESC LIMIT 1").Scan(&id); err != nil {
+		tb.Fatalf("Select purchase: %s", err)
+	}
+	at, err := s.GetAccountTransaction(id)
+	if err != nil {
+		tb.Fatalf("GetAccountTransaction: %s", err)
+	}
+	return at
+}
+
+func yearsBack(d bcdate.BCDate, years int) bcdate.BCDate {
+	return d - bcdate.BCDate(years*10000)
+}
+
+func TestRecomputeMatchesLoop(t *testing.T) {
+	s := newLedger(t, 2)
+
+	if v, err := s.Check(); err != nil || len(v) != 0 {
+		t.Fatalf("Check after Rebuild = %v, %v", v, err)
+	}
+
+	purchase := latestPurchase(t, s)
+	eid := model.PKEY(purchase.EnvelopeID.Int32)
+	future := bcdate.CurrentMonth().NextMonth().NextMonth().NextMonth() + 10
+
+	// Each case changes the data without touching checkpoints, then returns where to recompute from
+	tests := []struct {
+		name   string
+		change func(tx *sql.Tx) (bcdate.BCDate, []model.PKEY, []model.PKEY, error)
+	}{
+		{"Rebuild", func(tx *sql.Tx) (bcdate.BCDate, []model.PKEY, []model.PKEY, error) {
+			aids, eids, err := resetCheckpoints(tx)
+			return bcdate.Epoch(), aids, eids, err
+		}},
+		{"Backdate", func(tx *sql.Tx) (bcdate.BCDate, []model.PKEY, []model.PKEY, error) {
+			back := yearsBack(purchase.PostDate, 1)
+			_, err := tx.Exec("UPDATE a_t SET postDate = ? WHERE ID = ?", back, purchase.ID)
+			return back, []model.PKEY{purchase.AccountID}, []model.PKEY{eid}, err
+		}},
+		{"Amount", func(tx *sql.Tx) (bcdate.BCDate, []model.PKEY, []model.PKEY, error) {
+			_, err := tx.Exec("UPDATE a_t SET amount = amount - 12345, cleared = 0 WHERE ID = ?", purchase.ID)
+			return purchase.PostDate, []model.PKEY{purchase.AccountID}, []model.PKEY{eid}, err
+		}},
+		{"Future", func(tx *sql.Tx) (bcdate.BCDate, []model.PKEY, []model.PKEY, error) {
+			_, err := tx.Exec("INSERT INTO a_t (accountID, envelopeID, postDate, amount) VALUES (?,?,?,?)", purchase.AccountID, eid, future, -5000)
+			return future, []model.PKEY{purchase.AccountID}, []model.PKEY{eid}, err
+		}},
+		{"Delete", func(tx *sql.Tx) (bcdate.BCDate, []model.PKEY, []model.PKEY, error) {
+			_, err := tx.Exec("DELETE FROM a_t WHERE ID = ?", purchase.ID)
+			return purchase.PostDate, []model.PKEY{purchase.AccountID}, []model.PKEY{eid}, err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make([]checkpoints, 0, 2)
+
+			for _, p := range recomputePaths(s) {
+				tx, err := s.db.Begin()
+				if err != nil {
+					t.Fatalf("Begin: %s", err)
+				}
+				start, aids, eids, err := tt.change(tx)
+				if err != nil {
+					tx.Rollback()
+					t.Fatalf("change: %s", err)
+				}
+				if err := p.run(tx, start, aids, eids); err != nil {
+					tx.Rollback()
+					t.Fatalf("%s recompute: %s", p.name, err)
+				}
+				chk, err := snapshotCheckpoints(tx)
+				tx.Rollback()
+				if err != nil {
+					t.Fatalf("snapshotCheckpoints: %s", err)
+				}
+				results = append(results, chk)
+			}
+
+			if changes := diffCheckpoints(results[1], results[0]); len(changes) != 0 {
+				t.Fatalf("set based recompute differs from the loop in %d values, first %s", len(changes), changes[0])
+			}
+		})
+	}
+}
+
+// Old and new recompute on ten years of data
+// backdate moves the newest purchase five years into the past, the worst case of an ordinary edit, rebuild recomputes everything from EPOCH
+func BenchmarkRecompute(b *testing.B) {
+	s := newLedger(b, 10)
+
+	purchase := latestPurchase(b, s)
+	eid := model.PKEY(purchase.EnvelopeID.Int32)
+	back := yearsBack(purchase.PostDate, 5)
+
+	for _, p := range recomputePaths(s) {
+		b.Run("backdate/"+p.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tx, err := s.db.Begin()
+				if err != nil {
+					b.Fatalf("Begin: %s", err)
+				}
+				if _, err := tx.Exec("UPDATE a_t SET postDate = ? WHERE ID = ?", back, purchase.ID); err != nil {
+					b.Fatalf("Update: %s", err)
+				}
+				if err := p.run(tx, back, []model.PKEY{purchase.AccountID}, []model.PKEY{eid}); err != nil {
+					b.Fatalf("recompute: %s", err)
+				}
+				tx.Rollback()
+			}
+		})
+
+		b.Run("rebuild/"+p.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tx, err := s.db.Begin()
+				if err != nil {
+					b.Fatalf("Begin: %s", err)
+				}
+				aids, eids, err := resetCheckpoints(tx)
+				if err != nil {
+					b.Fatalf("resetCheckpoints: %s", err)
+				}
+				if err := p.run(tx, bcdate.Epoch(), aids, eids); err != nil {
+					b.Fatalf("recompute: %s", err)
+				}
+				tx.Rollback()
+			}
+		})
+	}
+}
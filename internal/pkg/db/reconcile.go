@@ -0,0 +1,78 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Reconciling an account against a statement
+// The transactions ticked off the statement are cleared, then the starting balance plus every cleared transaction up to
+// the statement date has to match the statement's balance, and those transactions are locked as reconciled
+// A reconciled transaction keeps its date, amount and cleared flag and cannot be deleted until it is unlocked again
+
+var (
+	ErrInvalidReconcile  = errors.New("invalid reconciliation")
+	ErrReconciled        = errors.New("transaction is reconciled")
+	ErrReconcileMismatch = errors.New("cleared balance does not match the statement")
+)
+
+// Memo of the TT_ADJUST a reconciliation enters for the difference
+const ReconcileMemo = "Reconciliation adjustment"
+
+type Reconciliation struct {
+	AccountID model.PKEY
+	// Statement date and ending balance
+	Date    bcdate.BCDate
+	Balance int
+	// Transactions ticked off the statement, they are cleared before the balances are compared
+	Cleared []model.PKEY
+	// Enter any difference as a TT_ADJUST instead of failing with ErrReconcileMismatch
+	Adjust bool
+}
+
+type ReconcileResult struct {
+	// Before any adjustment
+	ClearedBalance int
+	Difference     int
+	// The TT_ADJUST entered for the difference, if any
+	Adjustment sql.NullInt32
+	// Transactions newly locked
+	Reconciled int
+}
+
+// What reconciling cares about in a stored transaction, callers add the WHERE
+const lockSelect = "SELECT ID, accountID, postDate, amount, cleared, reconciled FROM a_t"
+
+func scanLock(row *sql.Row) (model.AccountTransaction, error) {
+	at := model.AccountTransaction{}
+	err := row.Scan(
+		&at.ID,
+		&at.AccountID,
+		&at.PostDate,
+		&at.Amount,
+		&at.Cleared,
+		&at.Reconciled,
+	)
+	return at, err
+}
+
+func validateReconciliation(r Reconciliation) error {
+	if r.Date%100 == 0 {
+		return fmt.Errorf("%w: statement date %d is not a date", ErrInvalidReconcile, r.Date)
+	}
+	return nil
+}
+
+// A reconciled transaction cannot move, change amount or go back to uncleared
+func checkLocked(old, at model.AccountTransaction) error {
+	if !old.Reconciled {
+		return nil
+	}
+	if at.PostDate != old.PostDate || at.Amount != old.Amount || !at.Cleared {
+		return fmt.Errorf("%w: transaction %d", ErrReconciled, old.ID)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rules: user defined categorisation of transactions coming in through Batch_NewAccountTransaction, and so every importer
+// A transaction entered by hand keeps what was entered, rules only see batches
+// Rules run by ascending priority then ID, each one that matches applies its actions before the next is tried
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Same columns in the same order for every rule read, see scanRule
+const ruleSelect = "SELECT ID, priority, name, memoPattern, minAmount, maxAmount, accountID, fromDate, toDate, envelopeID, type, cleared, memo FROM r"
+
+// What the rules would do to one transaction of a batch
+type RuleMatch struct {
+	// Position in the batch
+	Index int
+	// The rules that fired, in the order they ran
+	Rules  []model.PKEY
+	Before model.AccountTransaction
+	After  model.AccountTransaction
+}
+
+func (m RuleMatch) String() string {
+	ids := make([]string, 0, len(m.Rules))
+	for _, id := range m.Rules {
+		ids = append(ids, fmt.Sprintf("%03d", id))
+	}
+	envelope := "NULL"
+	if m.After.EnvelopeID.Valid {
+		envelope = fmt.Sprintf("%03d", m.After.EnvelopeID.Int32)
+	}
+	return fmt.Sprintf("#%d %08d %d %q -- rules %s -- envelope %s type %d cleared %t memo %q",
+		m.Index, m.Before.PostDate, m.Before.Amount, m.Before.Memo, strings.Join(ids, ","),
+		envelope, m.After.Typ, m.After.Cleared, m.After.Memo)
+}
+
+// A rule needs a name, something to do, and match bounds that can be met
+// Transfers only come from linking two legs, so no rule may turn a transaction into one
+func validateRule(r model.Rule) error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: needs a name", ErrInvalidRule)
+	}
+	if _, err := regexp.Compile(r.MemoPattern); err != nil {
+		return fmt.Errorf("%w: memo pattern -- %s", ErrInvalidRule, err.Error())
+	}
+	if r.MinAmount.Valid && r.MaxAmount.Valid && r.MinAmount.Int64 > r.MaxAmount.Int64 {
+		return fmt.Errorf("%w: minimum amount %d is above the maximum %d", ErrInvalidRule, r.MinAmount.Int64, r.MaxAmount.Int64)
+	}
+	if r.FromDate.Valid && r.ToDate.Valid && r.FromDate.Int32 > r.ToDate.Int32 {
+		return fmt.Errorf("%w: from date %d is after the to date %d", ErrInvalidRule, r.FromDate.Int32, r.ToDate.Int32)
+	}
+	if r.Typ.Valid {
+		switch model.TransactionType(r.Typ.Int32) {
+		case model.TT_NORM, model.TT_INCOME, model.TT_ADJUST:
+		default:
+			return fmt.Errorf("%w: cannot set type %d", ErrInvalidRule, r.Typ.Int32)
+		}
+	}
+	if !r.EnvelopeID.Valid && !r.Typ.Valid && !r.Cleared.Valid && !r.Memo.Valid {
+		return fmt.Errorf("%w: sets nothing", ErrInvalidRule)
+	}
+	return nil
+}
+
+type compiledRule struct {
+	model.Rule
+	re *regexp.Regexp
+}
+
+// Load every rule in the order they run, the query takes no parameters so both drivers share it
+func loadRules(q queryer) ([]compiledRule, error) {
+	rs, err := selectRules(q)
+	if err != nil {
+		return nil, fmt.Errorf("loadRules.selectRules -- %w", err)
+	}
+
+	crs := make([]compiledRule, 0, len(rs))
+	for _, r := range rs {
+		cr := compiledRule{Rule: r}
+		if r.MemoPattern != "" {
+			if cr.re, err = regexp.Compile(r.MemoPattern); err != nil {
+				return nil, fmt.Errorf("loadRules.Compile.%d -- %w", r.ID, err)
+			}
+		}
+		crs = append(crs, cr)
+	}
+	return crs, nil
+}
+
+func selectRules(q queryer) ([]model.Rule, error) {
+	rows, err := q.Query(ruleSelect + " ORDER BY priority, ID")
+	if err != nil {
+		return nil, fmt.Errorf("selectRules.Select -- %w", err)
+	}
+
+	rs := make([]model.Rule, 0)
+	for rows.Next() {
+		var r model.Rule
+		if err := scanRule(rows, &r); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("selectRules.Scan -- %w", err)
+		}
+		rs = append(rs, r)
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, fmt.Errorf("selectRules.Err -- %w", err)
+	}
+	return rs, nil
+}
+
+func scanRule(row interface{ Scan(...any) error }, r *model.Rule) error {
+	var pattern sql.NullString
+	if err := row.Scan(
+		&r.ID,
+		&r.Priority,
+		&r.Name,
+		&pattern,
+		&r.MinAmount,
+		&r.MaxAmount,
+		&r.AccountID,
+		&r.FromDate,
+		&r.ToDate,
+		&r.EnvelopeID,
+		&r.Typ,
+		&r.Cleared,
+		&r.Memo,
+	); err != nil {
+		return err
+	}
+	r.MemoPattern = pattern.String
+	return nil
+}
+
+// An empty pattern is stored as NULL, matching anything either way
+func patternValue(r model.Rule) sql.NullString {
+	return sql.NullString{String: r.MemoPattern, Valid: r.MemoPattern != ""}
+}
+
+// Run one rule against at, changing it when the rule matches
+func (r compiledRule) apply(at *model.AccountTransaction) bool {
+	var m []int
+	if r.re != nil {
+		if m = r.re.FindStringSubmatchIndex(at.Memo); m == nil {
+			return false
+		}
+	}
+	if r.MinAmount.Valid && int64(at.Amount) < r.MinAmount.Int64 {
+		return false
+	}
+	if r.MaxAmount.Valid && int64(at.Amount) > r.MaxAmount.Int64 {
+		return false
+	}
+	if r.AccountID.Valid && at.AccountID != model.PKEY(r.AccountID.Int32) {
+		return false
+	}
+	if r.FromDate.Valid && int32(at.PostDate) < r.FromDate.Int32 {
+		return false
+	}
+	if r.ToDate.Valid && int32(at.PostDate) > r.ToDate.Int32 {
+		return false
+	}
+
+	// Splits already say where the money goes
+	if r.EnvelopeID.Valid && !at.IsSplit() {
+		at.EnvelopeID = r.EnvelopeID
+	}
+	if r.Typ.Valid {
+		at.Typ = model.TransactionType(r.Typ.Int32)
+	}
+	if r.Cleared.Valid {
+		at.Cleared = r.Cleared.Bool
+	}
+	if r.Memo.Valid {
+		if r.re != nil {
+			at.Memo = string(r.re.ExpandString(nil, r.Memo.String, at.Memo, m))
+		} else {
+			at.Memo = r.Memo.String
+		}
+	}
+	return true
+}
+
+// Run the rules in order against at, returning the IDs of those that fired
+// Transfer legs are left alone, their envelope follows the float, see transfers.go
+func applyRules(rs []compiledRule, at *model.AccountTransaction) []model.PKEY {
+	fired := make([]model.PKEY, 0)
+	if at.Typ == model.TT_TRANSFER {
+		return fired
+	}
+	for _, r := range rs {
+		if r.apply(at) {
+			fired = append(fired, r.ID)
+		}
+	}
+	return fired
+}
+
+// What the rules would do to the batch, transactions no rule fires on are left out
+func previewRules(rs []compiledRule, ats []model.AccountTransaction) []RuleMatch {
+	ms := make([]RuleMatch, 0)
+	for i, at := range ats {
+		after := at
+		if fired := applyRules(rs, &after); len(fired) > 0 {
+			ms = append(ms, RuleMatch{Index: i, Rules: fired, Before: at, After: after})
+		}
+	}
+	return ms
+}
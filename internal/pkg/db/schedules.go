@@ -0,0 +1,136 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"fmt"
+)
+
+// Scheduled transactions: RunSchedules enters every occurrence that has come due into a_t, the way NewAccountTransaction does
+// An occurrence is entered once, lastDate moves past it in the same DB transaction as the insert
+// Skipping moves lastDate without entering anything, so the schedule picks up again at the occurrence after
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// A schedule RunSchedules could not enter, nothing of it went in and the next run tries again
+type ScheduleFailure struct {
+	ScheduleID model.PKEY
+	Err        error
+}
+
+// Same columns in the same order for every schedule read, see scanSchedule
+const scheduleSelect = "SELECT ID, accountID, envelopeID, payeeID, type, amount, memo, kind, n, startDate, endDate, lastDate FROM sch"
+
+// A schedule needs an amount, a recurrence it can keep, and dates that leave room for an occurrence
+// Transfers only come from linking two legs, so a schedule cannot make one
+func validateSchedule(s model.Schedule) error {
+	if s.Amount == 0 {
+		return fmt.Errorf("%w: needs an amount", ErrInvalidSchedule)
+	}
+	switch s.Typ {
+	case model.TT_NORM, model.TT_INCOME:
+	default:
+		return fmt.Errorf("%w: cannot enter type %d", ErrInvalidSchedule, s.Typ)
+	}
+	switch s.Kind {
+	case model.SK_MONTHLY:
+		if s.N < 1 || s.N > 31 {
+			return fmt.Errorf("%w: day of the month must be 1 to 31, got %d", ErrInvalidSchedule, s.N)
+		}
+	case model.SK_WEEKLY:
+		if s.N < 1 {
+			return fmt.Errorf("%w: weeks between occurrences must be at least 1, got %d", ErrInvalidSchedule, s.N)
+		}
+	case model.SK_YEARLY, model.SK_LASTBUSINESS:
+	default:
+		return fmt.Errorf("%w: unknown kind %d", ErrInvalidSchedule, s.Kind)
+	}
+	if s.Start%100 == 0 {
+		return fmt.Errorf("%w: start %d is not a date", ErrInvalidSchedule, s.Start)
+	}
+	if s.End.Valid && bcdate.BCDate(s.End.Int32) < s.Start {
+		return fmt.Errorf("%w: end %d is before the start %d", ErrInvalidSchedule, s.End.Int32, s.Start)
+	}
+	return nil
+}
+
+func selectSchedules(q queryer, where string, args ...any) ([]model.Schedule, error) {
+	rows, err := q.Query(scheduleSelect+" "+where+" ORDER BY ID", args...)
+	if err != nil {
+		return nil, fmt.Errorf("selectSchedules.Select -- %w", err)
+	}
+
+	ss := make([]model.Schedule, 0)
+	for rows.Next() {
+		var s model.Schedule
+		if err := scanSchedule(rows, &s); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("selectSchedules.Scan -- %w", err)
+		}
+		ss = append(ss, s)
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, fmt.Errorf("selectSchedules.Err -- %w", err)
+	}
+	return ss, nil
+}
+
+func scanSchedule(row interface{ Scan(...any) error }, s *model.Schedule) error {
+	return row.Scan(
+		&s.ID,
+		&s.AccountID,
+		&s.EnvelopeID,
+		&s.PayeeID,
+		&s.Typ,
+		&s.Amount,
+		&s.Memo,
+		&s.Kind,
+		&s.N,
+		&s.Start,
+		&s.End,
+		&s.Last,
+	)
+}
+
+// The transactions for every occurrence of s due by today, oldest first, and the last of them
+func dueOccurrences(s model.Schedule, today bcdate.BCDate) ([]model.AccountTransaction, bcdate.BCDate) {
+	ats := make([]model.AccountTransaction, 0)
+	last := s.Last
+	for d := s.Next(); d <= today; d = s.NextAfter(d) {
+		ats = append(ats, model.AccountTransaction{
+			AccountID:  s.AccountID,
+			EnvelopeID: s.EnvelopeID,
+			Typ:        s.Typ,
+			PostDate:   d,
+			Amount:     s.Amount,
+			Memo:       s.Memo,
+			PayeeID:    s.PayeeID,
+		})
+		last = d
+	}
+	return ats, last
+}
+
+// Where the checkpoints need recomputing from after entering ats, accounts and envelopes each once
+func scheduledTouches(ats []model.AccountTransaction) (bcdate.BCDate, []model.PKEY, []model.PKEY) {
+	oldest := bcdate.Never()
+	aids := make([]model.PKEY, 0)
+	eids := make([]model.PKEY, 0)
+	seenA := make(map[model.PKEY]bool)
+	seenE := make(map[model.PKEY]bool)
+	for _, at := range ats {
+		oldest = bcdate.Oldest(oldest, at.PostDate)
+		if !seenA[at.AccountID] {
+			seenA[at.AccountID] = true
+			aids = append(aids, at.AccountID)
+		}
+		for _, eid := range at.EnvelopeIDs() {
+			if !seenE[eid] {
+				seenE[eid] = true
+				eids = append(eids, eid)
+			}
+		}
+	}
+	return oldest, aids, eids
+}
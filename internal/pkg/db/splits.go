@@ -0,0 +1,103 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Split transactions: an a_t spread over several envelopes through a_t_split rows
+// A split a_t keeps a NULL envelopeID, envelope checkpoints count each split row in the month of its a_t
+
+var ErrInvalidSplit = errors.New("invalid split transaction")
+
+// How many transaction IDs go into one IN list when loading splits or transfers
+const idChunk = 500
+
+// Splits need at least two shares summing to the amount, and replace the single envelope
+func validateSplits(at model.AccountTransaction) error {
+	if !at.IsSplit() {
+		return nil
+	}
+	if at.EnvelopeID.Valid {
+		return fmt.Errorf("%w: has both an envelope and splits", ErrInvalidSplit)
+	}
+	if len(at.Splits) < 2 {
+		return fmt.Errorf("%w: needs at least two splits, use the envelope for one", ErrInvalidSplit)
+	}
+
+	total := 0
+	for _, s := range at.Splits {
+		total += s.Amount
+	}
+	if total != at.Amount {
+		return fmt.Errorf("%w: splits sum to %d, amount is %d", ErrInvalidSplit, total, at.Amount)
+	}
+
+	return nil
+}
+
+// Fill in Splits on the given transactions, transactions without splits keep a nil slice
+func attachSplits(q queryer, ats []model.AccountTransaction) error {
+	idx := make(map[model.PKEY]int, len(ats))
+	for i, at := range ats {
+		idx[at.ID] = i
+	}
+
+	for _, ids := range idLists(ats) {
+		rows, err := q.Query("SELECT ID, transactionID, envelopeID, amount, memo FROM a_t_split WHERE transactionID IN (" + ids + ") ORDER BY ID")
+		if err != nil {
+			return fmt.Errorf("attachSplits.Select -- %w", err)
+		}
+		for rows.Next() {
+			s := model.Split{}
+			if err := rows.Scan(&s.ID, &s.TransactionID, &s.EnvelopeID, &s.Amount, &s.Memo); err != nil {
+				rows.Close()
+				return fmt.Errorf("attachSplits.Scan -- %w", err)
+			}
+			i := idx[s.TransactionID]
+			ats[i].Splits = append(ats[i].Splits, s)
+		}
+		if err := closeRows(rows); err != nil {
+			return fmt.Errorf("attachSplits.Err -- %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Transaction IDs as comma separated lists of at most idChunk, ready for an IN clause
+// IDs are our own integers, so they can go into the query text and skip driver specific placeholders
+func idLists(ats []model.AccountTransaction) []string {
+	ret := make([]string, 0, len(ats)/idChunk+1)
+	for start := 0; start < len(ats); start += idChunk {
+		end := start + idChunk
+		if end > len(ats) {
+			end = len(ats)
+		}
+
+		ids := make([]string, 0, end-start)
+		for _, at := range ats[start:end] {
+			ids = append(ids, strconv.Itoa(int(at.ID)))
+		}
+		ret = append(ret, strings.Join(ids, ","))
+	}
+	return ret
+}
+
+// Merge envelope ID lists, keeping the first occurrence of each
+func unionIDs(lists ...[]model.PKEY) []model.PKEY {
+	seen := make(map[model.PKEY]bool)
+	ret := make([]model.PKEY, 0)
+	for _, list := range lists {
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				ret = append(ret, id)
+			}
+		}
+	}
+	return ret
+}
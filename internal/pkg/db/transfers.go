@@ -0,0 +1,174 @@
+package db
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Transfers: two TT_TRANSFER a_t linked through a_t_transfer
+// Both legs share date and amount, editing either leg carries the change over and deleting either deletes both
+
+var ErrInvalidTransfer = errors.New("invalid transfer")
+
+// Reads a transfer from its legs, callers add the WHERE
+// Amount comes from the To leg so it is positive
+const transferSelect = "SELECT x.ID, x.fromID, x.toID, f.accountID, t.accountID, f.envelopeID, f.postDate, t.amount, f.memo FROM a_t_transfer x JOIN a_t f ON f.ID = x.fromID JOIN a_t t ON t.ID = x.toID"
+
+func scanTransfer(row *sql.Row) (model.Transfer, error) {
+	t := model.Transfer{}
+	err := row.Scan(
+		&t.ID,
+		&t.FromID,
+		&t.ToID,
+		&t.FromAccountID,
+		&t.ToAccountID,
+		&t.EnvelopeID,
+		&t.PostDate,
+		&t.Amount,
+		&t.Memo,
+	)
+	return t, err
+}
+
+// An account as far as the transfer rules care
+type transferAccount struct {
+	id           model.PKEY
+	offbudget    bool
+	debt         bool
+	closed       sql.NullInt32
+	debtEnvelope sql.NullInt32
+}
+
+// Both accounts have to be open on the transfer date
+func transferOpen(from, to transferAccount, date bcdate.BCDate) error {
+	if err := checkOpen(from.closed, from.id, date); err != nil {
+		return err
+	}
+	return checkOpen(to.closed, to.id, date)
+}
+
+// The float only counts on budget accounts that are not debt
+func (a transferAccount) inFloat() bool {
+	return !a.offbudget && !a.debt
+}
+
+func validateTransfer(t model.Transfer) error {
+	if t.FromAccountID == t.ToAccountID {
+		return fmt.Errorf("%w: both legs are in account %d", ErrInvalidTransfer, t.FromAccountID)
+	}
+	if t.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be positive, got %d", ErrInvalidTransfer, t.Amount)
+	}
+	return nil
+}
+
+// Pick the envelope of the From leg
+// Money staying inside the float, or staying outside it, takes no envelope
+// Money leaving the float is spent from an envelope, paying down a debt account defaults to its debt envelope
+// Money entering the float is new float, like income
+func transferEnvelope(from, to transferAccount, want sql.NullInt32) (sql.NullInt32, error) {
+	if !from.inFloat() || to.inFloat() {
+		if want.Valid {
+			return want, fmt.Errorf("%w: a transfer from account %d to %d takes no envelope", ErrInvalidTransfer, from.id, to.id)
+		}
+		return want, nil
+	}
+
+	if want.Valid {
+		return want, nil
+	}
+	if to.debtEnvelope.Valid {
+		return to.debtEnvelope, nil
+	}
+	return want, fmt.Errorf("%w: a transfer out of the budget to account %d needs an envelope", ErrInvalidTransfer, to.id)
+}
+
+// The two a_t of a transfer, IDs are only set once the legs are stored
+func transferLegs(t model.Transfer) (from, to model.AccountTransaction) {
+	from = model.AccountTransaction{
+		ID:         t.FromID,
+		AccountID:  t.FromAccountID,
+		EnvelopeID: t.EnvelopeID,
+		Typ:        model.TT_TRANSFER,
+		PostDate:   t.PostDate,
+		Amount:     -t.Amount,
+		Memo:       t.Memo,
+	}
+	to = model.AccountTransaction{
+		ID:        t.ToID,
+		AccountID: t.ToAccountID,
+		Typ:       model.TT_TRANSFER,
+		PostDate:  t.PostDate,
+		Amount:    t.Amount,
+		Memo:      t.Memo,
+	}
+	return from, to
+}
+
+// The other leg of an edited leg as syncLeg found it, its checkpoints need recomputing from postDate too
+// syncLeg leaves its envelope alone so envelopeIDs are both the old and the new ones
+type legPeer struct {
+	accountID   model.PKEY
+	postDate    bcdate.BCDate
+	envelopeIDs []model.PKEY
+}
+
+// An edited leg keeps the shape of its transfer, the From leg's envelope is checked by transferEnvelope
+func validateLeg(t model.Transfer, at model.AccountTransaction) error {
+	if at.Typ != model.TT_TRANSFER {
+		return fmt.Errorf("%w: transaction %d is a leg of transfer %d and must stay a transfer", ErrInvalidTransfer, at.ID, t.ID)
+	}
+	if at.IsSplit() {
+		return fmt.Errorf("%w: transaction %d is a leg of transfer %d and cannot be split", ErrInvalidTransfer, at.ID, t.ID)
+	}
+	if at.ID == t.FromID && at.Amount >= 0 {
+		return fmt.Errorf("%w: the from leg of transfer %d must stay negative", ErrInvalidTransfer, t.ID)
+	}
+	if at.ID == t.ToID {
+		if at.Amount <= 0 {
+			return fmt.Errorf("%w: the to leg of transfer %d must stay positive", ErrInvalidTransfer, t.ID)
+		}
+		if at.EnvelopeID.Valid {
+			return fmt.Errorf("%w: the to leg of transfer %d takes no envelope", ErrInvalidTransfer, t.ID)
+		}
+	}
+	return nil
+}
+
+// Fill in TransferID and CounterAccount on the given transactions that are transfer legs
+func attachTransfers(q queryer, ats []model.AccountTransaction) error {
+	idx := make(map[model.PKEY]int, len(ats))
+	for i, at := range ats {
+		idx[at.ID] = i
+	}
+
+	for _, ids := range idLists(ats) {
+		rows, err := q.Query("SELECT x.ID, x.fromID, f.accountID, x.toID, t.accountID FROM a_t_transfer x JOIN a_t f ON f.ID = x.fromID JOIN a_t t ON t.ID = x.toID WHERE x.fromID IN (" + ids + ") OR x.toID IN (" + ids + ")")
+		if err != nil {
+			return fmt.Errorf("attachTransfers.Select -- %w", err)
+		}
+		for rows.Next() {
+			var id, fromID, fromAccount, toID, toAccount model.PKEY
+			if err := rows.Scan(&id, &fromID, &fromAccount, &toID, &toAccount); err != nil {
+				rows.Close()
+				return fmt.Errorf("attachTransfers.Scan -- %w", err)
+			}
+			if i, ok := idx[fromID]; ok {
+				ats[i].TransferID = sql.NullInt32{Int32: int32(id), Valid: true}
+				ats[i].CounterAccount = sql.NullInt32{Int32: int32(toAccount), Valid: true}
+			}
+			if i, ok := idx[toID]; ok {
+				ats[i].TransferID = sql.NullInt32{Int32: int32(id), Valid: true}
+				ats[i].CounterAccount = sql.NullInt32{Int32: int32(fromAccount), Valid: true}
+			}
+		}
+		if err := closeRows(rows); err != nil {
+			return fmt.Errorf("attachTransfers.Err -- %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"budgeting/internal/pkg/model"
+	"time"
+)
+
+// Users, their API tokens and sessions, the auth and session middleware decide what the hashes mean
+
+const userSelect = "SELECT ID, name, hash FROM usr"
+
+func scanUser(row interface{ Scan(...any) error }, u *model.User) error {
+	return row.Scan(&u.ID, &u.Name, &u.Hash)
+}
+
+const tokenSelect = "SELECT ID, userID, name, hash FROM usr_token"
+
+func scanToken(row interface{ Scan(...any) error }, t *model.Token) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash)
+}
+
+// Times are kept as unix seconds
+const sessionSelect = "SELECT ID, userID, hash, createdAt, lastSeen, qm, agent, addr FROM sess"
+
+func scanSession(row interface{ Scan(...any) error }, s *model.Session) error {
+	var created, seen int64
+	if err := row.Scan(&s.ID, &s.UserID, &s.Hash, &created, &seen, &s.QM, &s.Agent, &s.Addr); err != nil {
+		return err
+	}
+	s.Created = time.Unix(created, 0)
+	s.LastSeen = time.Unix(seen, 0)
+	return nil
+}
@@ -0,0 +1,119 @@
+package importer
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// The insert path shared by the statement importers
+// Format packages turn a file into Rows, Import skips what is already there and hands the rest to Batch_NewAccountTransaction, so rules run and checkpoints stay right
+
+// One statement line ready to insert
+type Row struct {
+	model.AccountTransaction
+	// Name to link an existing payee by, see model.PayeeName
+	Payee string
+}
+
+// What Import did, or would do on a dry run
+type Result struct {
+	// Rows not imported before, in statement order
+	New []model.AccountTransaction
+	// Rows whose ImportID their account already holds
+	Skipped []model.AccountTransaction
+	// The rules that would fire on New, only filled on dry runs as the batch runs them itself
+	Matches []db.RuleMatch
+	// Rows looking like a transaction the account already has, Index is the row's position in the input
+	// Left out of New with SkipDuplicates, imported with the rest otherwise
+	Duplicates []db.Duplicate
+}
+
+type Options struct {
+	// Report what would happen without writing anything
+	DryRun bool
+	// Leave out rows that look like a stored transaction, see db.Duplicate
+	SkipDuplicates bool
+}
+
+// Insert the rows not imported before
+// A row whose ImportID its account already holds is skipped, so importing an overlapping statement again adds only what is new
+// Rows that only look like a stored transaction are listed in Duplicates, and skipped with SkipDuplicates
+// Names matching an existing payee link to it and no payees are created
+func Import(sdb db.DB, rows []Row, opts Options) (Result, error) {
+	res := Result{New: make([]model.AccountTransaction, 0), Skipped: make([]model.AccountTransaction, 0), Duplicates: make([]db.Duplicate, 0)}
+
+	// Input position of each row of New
+	index := make([]int, 0, len(rows))
+
+	known := make(map[model.PKEY]map[string]bool)
+	for i, row := range rows {
+		at := row.AccountTransaction
+
+		if at.ImportID.Valid {
+			ids, ok := known[at.AccountID]
+			if !ok {
+				var err error
+				if ids, err = sdb.GetImportIDs(at.AccountID); err != nil {
+					return res, fmt.Errorf("Import.GetImportIDs -- %w", err)
+				}
+				known[at.AccountID] = ids
+			}
+			if ids[at.ImportID.String] {
+				res.Skipped = append(res.Skipped, at)
+				continue
+			}
+			ids[at.ImportID.String] = true
+		}
+
+		if name := model.PayeeName(row.Payee); name != "" && !at.PayeeID.Valid {
+			p, err := sdb.GetPayeeByName(name)
+			switch {
+			case err == nil:
+				at.PayeeID = sql.NullInt32{Int32: int32(p.ID), Valid: true}
+			case !errors.Is(err, sql.ErrNoRows):
+				return res, fmt.Errorf("Import.GetPayeeByName -- %w", err)
+			}
+		}
+		res.New = append(res.New, at)
+		index = append(index, i)
+	}
+
+	ds, err := sdb.FindDuplicates(res.New)
+	if err != nil {
+		return res, fmt.Errorf("Import.FindDuplicates -- %w", err)
+	}
+	dupe := make(map[int]bool, len(ds))
+	for _, d := range ds {
+		dupe[d.Index] = true
+		d.Index = index[d.Index]
+		res.Duplicates = append(res.Duplicates, d)
+	}
+	if opts.SkipDuplicates && len(ds) > 0 {
+		kept := make([]model.AccountTransaction, 0, len(res.New)-len(ds))
+		for i, at := range res.New {
+			if !dupe[i] {
+				kept = append(kept, at)
+			}
+		}
+		res.New = kept
+	}
+
+	if opts.DryRun {
+		if res.Matches, err = sdb.PreviewRules(res.New); err != nil {
+			return res, fmt.Errorf("Import.PreviewRules -- %w", err)
+		}
+		return res, nil
+	}
+
+	if len(res.New) == 0 {
+		return res, nil
+	}
+	// Duplicates were flagged or skipped above
+	if err := sdb.Batch_NewAccountTransaction(res.New, true); err != nil {
+		return res, fmt.Errorf("Import.Batch_NewAccountTransaction -- %w", err)
+	}
+	return res, nil
+}
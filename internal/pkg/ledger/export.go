@@ -0,0 +1,263 @@
+package ledger
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"fmt"
+	"sort"
+)
+
+// Read everything through the DB interface, rows in the order they were made
+func Export(sdb db.DB) (Ledger, error) {
+	l := Ledger{
+		Version:              Version,
+		Accounts:             make([]Account, 0),
+		EnvelopeGroups:       make([]EnvelopeGroup, 0),
+		Envelopes:            make([]Envelope, 0),
+		Payees:               make([]Payee, 0),
+		Rules:                make([]Rule, 0),
+		CSVProfiles:          make([]CSVProfile, 0),
+		Schedules:            make([]Schedule, 0),
+		AccountTransactions:  make([]AccountTransaction, 0),
+		Transfers:            make([]Transfer, 0),
+		EnvelopeTransactions: make([]EnvelopeTransaction, 0),
+	}
+
+	as, err := sdb.GetAccounts()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetAccounts -- %w", err)
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].ID < as[j].ID })
+	accounts := make(refs, len(as))
+	for i, a := range as {
+		accounts[a.ID] = model.PKEY(i + 1)
+		sbal, err := sdb.GetStartingBalance(a.ID)
+		if err != nil {
+			return l, fmt.Errorf("Export.GetStartingBalance -- %w", err)
+		}
+		ja := Account{
+			ID:              accounts[a.ID],
+			Institution:     a.Institution,
+			Name:            a.Name,
+			Class:           a.Class,
+			Hidden:          a.Hidden,
+			Offbudget:       a.Offbudget,
+			Debt:            a.Debt,
+			StartingBalance: sbal,
+		}
+		if a.Closed.Valid {
+			closed := bcdate.BCDate(a.Closed.Int32)
+			ja.Closed = &closed
+		}
+		l.Accounts = append(l.Accounts, ja)
+	}
+
+	egs, err := sdb.GetEnvelopeGroups()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetEnvelopeGroups -- %w", err)
+	}
+	sort.Slice(egs, func(i, j int) bool { return egs[i].ID < egs[j].ID })
+	groups := make(refs, len(egs))
+	for i, eg := range egs {
+		groups[eg.ID] = model.PKEY(i + 1)
+		l.EnvelopeGroups = append(l.EnvelopeGroups, EnvelopeGroup{ID: groups[eg.ID], Name: eg.Name, Sort: eg.Sort})
+	}
+
+	es, err := sdb.GetEnvelopes()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetEnvelopes -- %w", err)
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].ID < es[j].ID })
+	envelopes := make(refs, len(es))
+	for i, e := range es {
+		envelopes[e.ID] = model.PKEY(i + 1)
+		l.Envelopes = append(l.Envelopes, Envelope{
+			ID:            envelopes[e.ID],
+			GroupID:       groups[e.GroupID],
+			Name:          e.Name,
+			Notes:         e.Notes,
+			Hidden:        e.Hidden,
+			Goal:          e.Goal,
+			GoalAmt:       e.GoalAmt,
+			GoalTgt:       e.GoalTgt,
+			Sort:          e.Sort,
+			DebtAccountID: accounts.ref(e.DebtAccount),
+		})
+	}
+
+	ps, err := sdb.GetPayees()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetPayees -- %w", err)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+	payees := make(refs, len(ps))
+	for i, p := range ps {
+		payees[p.ID] = model.PKEY(i + 1)
+		l.Payees = append(l.Payees, Payee{ID: payees[p.ID], Name: p.Name, EnvelopeID: envelopes.ref(p.EnvelopeID)})
+	}
+
+	rs, err := sdb.GetRules()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetRules -- %w", err)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].ID < rs[j].ID })
+	for _, r := range rs {
+		jr := Rule{
+			Priority:    r.Priority,
+			Name:        r.Name,
+			MemoPattern: r.MemoPattern,
+			AccountID:   accounts.ref(r.AccountID),
+			EnvelopeID:  envelopes.ref(r.EnvelopeID),
+		}
+		if r.MinAmount.Valid {
+			n := r.MinAmount.Int64
+			jr.MinAmount = &n
+		}
+		if r.MaxAmount.Valid {
+			n := r.MaxAmount.Int64
+			jr.MaxAmount = &n
+		}
+		if r.FromDate.Valid {
+			d := bcdate.BCDate(r.FromDate.Int32)
+			jr.FromDate = &d
+		}
+		if r.ToDate.Valid {
+			d := bcdate.BCDate(r.ToDate.Int32)
+			jr.ToDate = &d
+		}
+		if r.Typ.Valid {
+			tt := model.TransactionType(r.Typ.Int32)
+			jr.Typ = &tt
+		}
+		if r.Cleared.Valid {
+			cleared := r.Cleared.Bool
+			jr.Cleared = &cleared
+		}
+		if r.Memo.Valid {
+			memo := r.Memo.String
+			jr.Memo = &memo
+		}
+		l.Rules = append(l.Rules, jr)
+	}
+
+	cps, err := sdb.GetCSVProfiles()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetCSVProfiles -- %w", err)
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].ID < cps[j].ID })
+	for _, p := range cps {
+		l.CSVProfiles = append(l.CSVProfiles, CSVProfile{
+			Name:          p.Name,
+			Delimiter:     p.Delimiter,
+			SkipRows:      p.SkipRows,
+			Header:        p.Header,
+			DateColumn:    p.DateColumn,
+			DateFormat:    p.DateFormat,
+			AmountColumn:  p.AmountColumn,
+			DebitColumn:   p.DebitColumn,
+			CreditColumn:  p.CreditColumn,
+			Negate:        p.Negate,
+			DecimalComma:  p.DecimalComma,
+			MemoColumn:    p.MemoColumn,
+			ClearedColumn: p.ClearedColumn,
+			ClearedValue:  p.ClearedValue,
+			IDColumn:      p.IDColumn,
+		})
+	}
+
+	scs, err := sdb.GetSchedules()
+	if err != nil {
+		return l, fmt.Errorf("Export.GetSchedules -- %w", err)
+	}
+	sort.Slice(scs, func(i, j int) bool { return scs[i].ID < scs[j].ID })
+	for _, sc := range scs {
+		js := Schedule{
+			AccountID:  accounts[sc.AccountID],
+			EnvelopeID: envelopes.ref(sc.EnvelopeID),
+			PayeeID:    payees.ref(sc.PayeeID),
+			Typ:        sc.Typ,
+			Amount:     sc.Amount,
+			Memo:       sc.Memo,
+			Kind:       sc.Kind,
+			N:          sc.N,
+			Start:      sc.Start,
+			Last:       sc.Last,
+		}
+		if sc.End.Valid {
+			end := bcdate.BCDate(sc.End.Int32)
+			js.End = &end
+		}
+		l.Schedules = append(l.Schedules, js)
+	}
+
+	ats := make([]model.AccountTransaction, 0)
+	for _, a := range as {
+		aats, err := sdb.GetAllAccountTransactions(a.ID)
+		if err != nil {
+			return l, fmt.Errorf("Export.GetAllAccountTransactions -- %w", err)
+		}
+		ats = append(ats, aats...)
+	}
+	sort.Slice(ats, func(i, j int) bool { return ats[i].ID < ats[j].ID })
+	transactions := make(refs, len(ats))
+	transfers := make(map[model.PKEY]bool)
+	for i, at := range ats {
+		transactions[at.ID] = model.PKEY(i + 1)
+		jt := AccountTransaction{
+			ID:         transactions[at.ID],
+			AccountID:  accounts[at.AccountID],
+			Typ:        at.Typ,
+			EnvelopeID: envelopes.ref(at.EnvelopeID),
+			PostDate:   at.PostDate,
+			Amount:     at.Amount,
+			Cleared:    at.Cleared,
+			Memo:       at.Memo,
+			PayeeID:    payees.ref(at.PayeeID),
+			Reconciled: at.Reconciled,
+		}
+		if at.ImportID.Valid {
+			importID := at.ImportID.String
+			jt.ImportID = &importID
+		}
+		for _, s := range at.Splits {
+			jt.Splits = append(jt.Splits, Split{EnvelopeID: envelopes.ref(s.EnvelopeID), Amount: s.Amount, Memo: s.Memo})
+		}
+		l.AccountTransactions = append(l.AccountTransactions, jt)
+		if at.TransferID.Valid {
+			transfers[model.PKEY(at.TransferID.Int32)] = true
+		}
+	}
+
+	for _, id := range sortedIDs(transfers) {
+		t, err := sdb.GetTransfer(id)
+		if err != nil {
+			return l, fmt.Errorf("Export.GetTransfer -- %w", err)
+		}
+		l.Transfers = append(l.Transfers, Transfer{FromID: transactions[t.FromID], ToID: transactions[t.ToID]})
+	}
+
+	ets := make([]model.EnvelopeTransaction, 0)
+	for _, e := range es {
+		eets, err := sdb.GetAllEnvelopeTransactions(e.ID)
+		if err != nil {
+			return l, fmt.Errorf("Export.GetAllEnvelopeTransactions -- %w", err)
+		}
+		ets = append(ets, eets...)
+	}
+	sort.Slice(ets, func(i, j int) bool { return ets[i].ID < ets[j].ID })
+	for _, et := range ets {
+		l.EnvelopeTransactions = append(l.EnvelopeTransactions, EnvelopeTransaction{EnvelopeID: envelopes[et.EnvelopeID], PostDate: et.PostDate, Amount: et.Amount})
+	}
+
+	return l, nil
+}
+
+func sortedIDs(m map[model.PKEY]bool) []model.PKEY {
+	ids := make([]model.PKEY, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
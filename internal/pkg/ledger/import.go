@@ -0,0 +1,503 @@
+package ledger
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Rebuild the ledger in a freshly initialised DB, rows are made in document order so they get IDs in the same order
+// Accounts start out without debt, a debt envelope is made when its turn in the envelope list comes
+// Payee envelopes and rules go in last so they cannot touch the transactions on the way in
+// Checkpoints are recomputed from scratch at the end
+func Import(sdb db.DB, l Ledger) error {
+	if l.Version < 1 || l.Version > Version {
+		return fmt.Errorf("Import -- %w: %d, want 1 to %d", ErrVersion, l.Version, Version)
+	}
+	if err := checkEmpty(sdb); err != nil {
+		return fmt.Errorf("Import.checkEmpty -- %w", err)
+	}
+
+	accounts := make(refs, len(l.Accounts))
+	for _, ja := range l.Accounts {
+		if _, ok := accounts[ja.ID]; ok {
+			return fmt.Errorf("Import -- %w: account %d twice", ErrInvalid, ja.ID)
+		}
+		a := model.Account{Institution: ja.Institution, Name: ja.Name, Class: ja.Class, Hidden: ja.Hidden, Offbudget: ja.Offbudget}
+		if err := sdb.NewAccount(&a); err != nil {
+			return fmt.Errorf("Import.NewAccount -- %w", err)
+		}
+		accounts[ja.ID] = a.ID
+		if ja.StartingBalance != 0 {
+			if err := sdb.SetStartingBalance(a.ID, ja.StartingBalance); err != nil {
+				return fmt.Errorf("Import.SetStartingBalance -- %w", err)
+			}
+		}
+	}
+
+	egs, err := sdb.GetEnvelopeGroups()
+	if err != nil {
+		return fmt.Errorf("Import.GetEnvelopeGroups -- %w", err)
+	}
+	groups := make(refs, len(l.EnvelopeGroups))
+	for i, jg := range l.EnvelopeGroups {
+		if _, ok := groups[jg.ID]; ok {
+			return fmt.Errorf("Import -- %w: envelope group %d twice", ErrInvalid, jg.ID)
+		}
+		eg := model.EnvelopeGroup{Name: jg.Name, Sort: jg.Sort}
+		if i == 0 {
+			eg.ID = egs[0].ID
+			if err := sdb.UpdateEnvelopeGroup(eg); err != nil {
+				return fmt.Errorf("Import.UpdateEnvelopeGroup -- %w", err)
+			}
+		} else if err := sdb.NewEnvelopeGroup(&eg); err != nil {
+			return fmt.Errorf("Import.NewEnvelopeGroup -- %w", err)
+		}
+		groups[jg.ID] = eg.ID
+	}
+
+	envelopes := make(refs, len(l.Envelopes))
+	for _, je := range l.Envelopes {
+		if _, ok := envelopes[je.ID]; ok {
+			return fmt.Errorf("Import -- %w: envelope %d twice", ErrInvalid, je.ID)
+		}
+		e := model.Envelope{Name: je.Name, Notes: je.Notes, Hidden: je.Hidden, Goal: je.Goal, GoalAmt: je.GoalAmt, GoalTgt: je.GoalTgt, Sort: je.Sort}
+		if e.GroupID, err = groups.id("envelope group", je.GroupID); err != nil {
+			return fmt.Errorf("Import.envelope -- %w", err)
+		}
+
+		if je.DebtAccountID == nil {
+			if err := sdb.NewEnvelope(&e); err != nil {
+				return fmt.Errorf("Import.NewEnvelope -- %w", err)
+			}
+			envelopes[je.ID] = e.ID
+			continue
+		}
+
+		if e.DebtAccount, err = accounts.null("account", je.DebtAccountID); err != nil {
+			return fmt.Errorf("Import.envelope -- %w", err)
+		}
+		if err := makeDebt(sdb, model.PKEY(e.DebtAccount.Int32)); err != nil {
+			return fmt.Errorf("Import.makeDebt -- %w", err)
+		}
+		debt, err := sdb.GetDebtEnvelopeFor(model.PKEY(e.DebtAccount.Int32))
+		if err != nil {
+			return fmt.Errorf("Import.GetDebtEnvelopeFor -- %w", err)
+		}
+		e.ID = debt.ID
+		if err := sdb.UpdateEnvelope(e); err != nil {
+			return fmt.Errorf("Import.UpdateEnvelope -- %w", err)
+		}
+		envelopes[je.ID] = e.ID
+	}
+	// Debt accounts whose envelope is missing get one anyway
+	for _, ja := range l.Accounts {
+		if a, err := sdb.GetAccount(accounts[ja.ID]); err != nil {
+			return fmt.Errorf("Import.GetAccount -- %w", err)
+		} else if ja.Debt && !a.Debt {
+			if err := makeDebt(sdb, a.ID); err != nil {
+				return fmt.Errorf("Import.makeDebt -- %w", err)
+			}
+		}
+	}
+
+	payees := make(refs, len(l.Payees))
+	for _, jp := range l.Payees {
+		if _, ok := payees[jp.ID]; ok {
+			return fmt.Errorf("Import -- %w: payee %d twice", ErrInvalid, jp.ID)
+		}
+		p := model.Payee{Name: jp.Name}
+		if err := sdb.NewPayee(&p); err != nil {
+			return fmt.Errorf("Import.NewPayee -- %w", err)
+		}
+		payees[jp.ID] = p.ID
+	}
+
+	made, err := importTransactions(sdb, l, accounts, envelopes, payees)
+	if err != nil {
+		return fmt.Errorf("Import.importTransactions -- %w", err)
+	}
+	if err := lockReconciled(sdb, l, accounts, made); err != nil {
+		return fmt.Errorf("Import.lockReconciled -- %w", err)
+	}
+
+	ets := make([]model.EnvelopeTransaction, 0, len(l.EnvelopeTransactions))
+	for _, jt := range l.EnvelopeTransactions {
+		et := model.EnvelopeTransaction{PostDate: jt.PostDate, Amount: jt.Amount}
+		if et.EnvelopeID, err = envelopes.id("envelope", jt.EnvelopeID); err != nil {
+			return fmt.Errorf("Import.envelopeTransaction -- %w", err)
+		}
+		ets = append(ets, et)
+	}
+	if len(ets) > 0 {
+		if err := sdb.Batch_NewEnvelopeTransaction(ets); err != nil {
+			return fmt.Errorf("Import.Batch_NewEnvelopeTransaction -- %w", err)
+		}
+	}
+
+	for _, jp := range l.Payees {
+		if jp.EnvelopeID == nil {
+			continue
+		}
+		p := model.Payee{ID: payees[jp.ID], Name: jp.Name}
+		if p.EnvelopeID, err = envelopes.null("envelope", jp.EnvelopeID); err != nil {
+			return fmt.Errorf("Import.payee -- %w", err)
+		}
+		if err := sdb.UpdatePayee(p); err != nil {
+			return fmt.Errorf("Import.UpdatePayee -- %w", err)
+		}
+	}
+
+	for _, jr := range l.Rules {
+		r, err := jr.model(accounts, envelopes)
+		if err != nil {
+			return fmt.Errorf("Import.rule -- %w", err)
+		}
+		if err := sdb.NewRule(&r); err != nil {
+			return fmt.Errorf("Import.NewRule -- %w", err)
+		}
+	}
+
+	for _, jp := range l.CSVProfiles {
+		p := jp.model()
+		if err := sdb.NewCSVProfile(&p); err != nil {
+			return fmt.Errorf("Import.NewCSVProfile -- %w", err)
+		}
+	}
+
+	for _, js := range l.Schedules {
+		sc, err := js.model(accounts, envelopes, payees)
+		if err != nil {
+			return fmt.Errorf("Import.schedule -- %w", err)
+		}
+		if err := sdb.NewSchedule(&sc); err != nil {
+			return fmt.Errorf("Import.NewSchedule -- %w", err)
+		}
+	}
+
+	if _, err := sdb.Rebuild(false); err != nil {
+		return fmt.Errorf("Import.Rebuild -- %w", err)
+	}
+
+	// The closing transfer is already in, so each balance is zero by its close date
+	for _, ja := range l.Accounts {
+		if ja.Closed == nil {
+			continue
+		}
+		if err := sdb.CloseAccount(db.Closing{AccountID: accounts[ja.ID], Date: *ja.Closed}); err != nil {
+			return fmt.Errorf("Import.CloseAccount -- %w", err)
+		}
+	}
+	return nil
+}
+
+// Nothing but what Init makes, the catch-all envelope group
+func checkEmpty(sdb db.DB) error {
+	as, err := sdb.GetAccounts()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetAccounts -- %w", err)
+	}
+	es, err := sdb.GetEnvelopes()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetEnvelopes -- %w", err)
+	}
+	egs, err := sdb.GetEnvelopeGroups()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetEnvelopeGroups -- %w", err)
+	}
+	ps, err := sdb.GetPayees()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetPayees -- %w", err)
+	}
+	rs, err := sdb.GetRules()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetRules -- %w", err)
+	}
+	cps, err := sdb.GetCSVProfiles()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetCSVProfiles -- %w", err)
+	}
+	scs, err := sdb.GetSchedules()
+	if err != nil {
+		return fmt.Errorf("checkEmpty.GetSchedules -- %w", err)
+	}
+
+	if len(as) > 0 || len(es) > 0 || len(egs) != 1 || len(ps) > 0 || len(rs) > 0 || len(cps) > 0 || len(scs) > 0 {
+		return fmt.Errorf("%w: %d accounts, %d envelopes, %d envelope groups, %d payees, %d rules, %d CSV profiles, %d schedules",
+			ErrNotEmpty, len(as), len(es), len(egs), len(ps), len(rs), len(cps), len(scs))
+	}
+	return nil
+}
+
+func makeDebt(sdb db.DB, id model.PKEY) error {
+	a, err := sdb.GetAccount(id)
+	if err != nil {
+		return fmt.Errorf("makeDebt.GetAccount -- %w", err)
+	}
+	if a.Debt {
+		return fmt.Errorf("%w: account %s has two debt envelopes", ErrInvalid, a.Name)
+	}
+	a.Debt = true
+	if err := sdb.UpdateAccount(a); err != nil {
+		return fmt.Errorf("makeDebt.UpdateAccount -- %w", err)
+	}
+	return nil
+}
+
+// Runs of plain transactions go in through the batch path, each transfer is made when its first leg comes up
+// NewTransfer makes the legs uncleared with the From leg's memo, legs that differ are updated afterwards
+// Returns the document IDs in the order their rows were made
+func importTransactions(sdb db.DB, l Ledger, accounts, envelopes, payees refs) ([]model.PKEY, error) {
+	byID := make(map[model.PKEY]int, len(l.AccountTransactions))
+	for i, jt := range l.AccountTransactions {
+		if _, ok := byID[jt.ID]; ok {
+			return nil, fmt.Errorf("%w: account transaction %d twice", ErrInvalid, jt.ID)
+		}
+		byID[jt.ID] = i
+	}
+	legs := make(map[model.PKEY]Transfer, 2*len(l.Transfers))
+	for _, t := range l.Transfers {
+		for _, id := range []model.PKEY{t.FromID, t.ToID} {
+			if _, ok := byID[id]; !ok {
+				return nil, fmt.Errorf("%w: no account transaction %d for a transfer", ErrInvalid, id)
+			}
+			if _, ok := legs[id]; ok {
+				return nil, fmt.Errorf("%w: account transaction %d is in two transfers", ErrInvalid, id)
+			}
+			legs[id] = t
+		}
+	}
+
+	order := make([]model.PKEY, 0, len(l.AccountTransactions))
+	batch := make([]model.AccountTransaction, 0)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		// A ledger holds what it holds, look-alikes included
+		if err := sdb.Batch_NewAccountTransaction(batch, true); err != nil {
+			return fmt.Errorf("flush.Batch_NewAccountTransaction -- %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	made := make(map[Transfer]bool, len(l.Transfers))
+	for _, jt := range l.AccountTransactions {
+		at, err := jt.model(accounts, envelopes, payees)
+		if err != nil {
+			return nil, fmt.Errorf("importTransactions.transaction -- %w", err)
+		}
+
+		t, leg := legs[jt.ID]
+		if !leg {
+			batch = append(batch, at)
+			order = append(order, jt.ID)
+			continue
+		}
+		if made[t] {
+			continue
+		}
+		made[t] = true
+		if err := flush(); err != nil {
+			return nil, fmt.Errorf("importTransactions.flush -- %w", err)
+		}
+
+		from, err := l.AccountTransactions[byID[t.FromID]].model(accounts, envelopes, payees)
+		if err != nil {
+			return nil, fmt.Errorf("importTransactions.transaction -- %w", err)
+		}
+		to, err := l.AccountTransactions[byID[t.ToID]].model(accounts, envelopes, payees)
+		if err != nil {
+			return nil, fmt.Errorf("importTransactions.transaction -- %w", err)
+		}
+		x := model.Transfer{
+			FromAccountID: from.AccountID,
+			ToAccountID:   to.AccountID,
+			EnvelopeID:    from.EnvelopeID,
+			PostDate:      from.PostDate,
+			Amount:        -from.Amount,
+			Memo:          from.Memo,
+		}
+		if to.PostDate != from.PostDate || to.Amount != x.Amount {
+			return nil, fmt.Errorf("%w: legs %d and %d of a transfer differ in date or amount", ErrInvalid, t.FromID, t.ToID)
+		}
+		if err := sdb.NewTransfer(&x); err != nil {
+			return nil, fmt.Errorf("importTransactions.NewTransfer -- %w", err)
+		}
+		order = append(order, t.FromID, t.ToID)
+
+		from.ID, to.ID = x.FromID, x.ToID
+		to.EnvelopeID = sql.NullInt32{}
+		for _, at := range []model.AccountTransaction{from, to} {
+			if at.Cleared || at.PayeeID.Valid || at.Memo != x.Memo {
+				if err := sdb.UpdateAccountTransaction(at); err != nil {
+					return nil, fmt.Errorf("importTransactions.UpdateAccountTransaction -- %w", err)
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, fmt.Errorf("importTransactions.flush -- %w", err)
+	}
+	return order, nil
+}
+
+// Reconciled rows are locked once everything is in, updating transfer legs on the way in would trip the lock
+// Stored IDs grow in the order rows were made, so sorting them lines them up with the order importTransactions made them in
+func lockReconciled(sdb db.DB, l Ledger, accounts refs, made []model.PKEY) error {
+	reconciled := make(map[model.PKEY]bool)
+	for _, jt := range l.AccountTransactions {
+		if !jt.Reconciled {
+			continue
+		}
+		if !jt.Cleared {
+			return fmt.Errorf("%w: account transaction %d is reconciled but not cleared", ErrInvalid, jt.ID)
+		}
+		reconciled[jt.ID] = true
+	}
+	if len(reconciled) == 0 {
+		return nil
+	}
+
+	stored := make([]model.PKEY, 0, len(made))
+	for _, id := range accounts {
+		ats, err := sdb.GetAllAccountTransactions(id)
+		if err != nil {
+			return fmt.Errorf("lockReconciled.GetAllAccountTransactions -- %w", err)
+		}
+		for _, at := range ats {
+			stored = append(stored, at.ID)
+		}
+	}
+	if len(stored) != len(made) {
+		return fmt.Errorf("lockReconciled -- %d transactions stored, %d imported", len(stored), len(made))
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i] < stored[j] })
+
+	for i, id := range made {
+		if !reconciled[id] {
+			continue
+		}
+		if err := sdb.SetReconciled(stored[i], true); err != nil {
+			return fmt.Errorf("lockReconciled.SetReconciled -- %w", err)
+		}
+	}
+	return nil
+}
+
+func (jt AccountTransaction) model(accounts, envelopes, payees refs) (model.AccountTransaction, error) {
+	at := model.AccountTransaction{
+		Typ:      jt.Typ,
+		PostDate: jt.PostDate,
+		Amount:   jt.Amount,
+		Cleared:  jt.Cleared,
+		Memo:     jt.Memo,
+	}
+	var err error
+	if at.AccountID, err = accounts.id("account", jt.AccountID); err != nil {
+		return at, err
+	}
+	if at.EnvelopeID, err = envelopes.null("envelope", jt.EnvelopeID); err != nil {
+		return at, err
+	}
+	if at.PayeeID, err = payees.null("payee", jt.PayeeID); err != nil {
+		return at, err
+	}
+	if jt.ImportID != nil {
+		at.ImportID = sql.NullString{String: *jt.ImportID, Valid: true}
+	}
+	for _, js := range jt.Splits {
+		s := model.Split{Amount: js.Amount, Memo: js.Memo}
+		if s.EnvelopeID, err = envelopes.null("envelope", js.EnvelopeID); err != nil {
+			return at, err
+		}
+		at.Splits = append(at.Splits, s)
+	}
+	return at, nil
+}
+
+func (jr Rule) model(accounts, envelopes refs) (model.Rule, error) {
+	r := model.Rule{
+		Priority:    jr.Priority,
+		Name:        jr.Name,
+		MemoPattern: jr.MemoPattern,
+	}
+	var err error
+	if r.AccountID, err = accounts.null("account", jr.AccountID); err != nil {
+		return r, err
+	}
+	if r.EnvelopeID, err = envelopes.null("envelope", jr.EnvelopeID); err != nil {
+		return r, err
+	}
+	if jr.MinAmount != nil {
+		r.MinAmount = sql.NullInt64{Int64: *jr.MinAmount, Valid: true}
+	}
+	if jr.MaxAmount != nil {
+		r.MaxAmount = sql.NullInt64{Int64: *jr.MaxAmount, Valid: true}
+	}
+	if jr.FromDate != nil {
+		r.FromDate = sql.NullInt32{Int32: int32(*jr.FromDate), Valid: true}
+	}
+	if jr.ToDate != nil {
+		r.ToDate = sql.NullInt32{Int32: int32(*jr.ToDate), Valid: true}
+	}
+	if jr.Typ != nil {
+		r.Typ = sql.NullInt32{Int32: int32(*jr.Typ), Valid: true}
+	}
+	if jr.Cleared != nil {
+		r.Cleared = sql.NullBool{Bool: *jr.Cleared, Valid: true}
+	}
+	if jr.Memo != nil {
+		r.Memo = sql.NullString{String: *jr.Memo, Valid: true}
+	}
+	return r, nil
+}
+
+func (jp CSVProfile) model() model.CSVProfile {
+	return model.CSVProfile{
+		Name:          jp.Name,
+		Delimiter:     jp.Delimiter,
+		SkipRows:      jp.SkipRows,
+		Header:        jp.Header,
+		DateColumn:    jp.DateColumn,
+		DateFormat:    jp.DateFormat,
+		AmountColumn:  jp.AmountColumn,
+		DebitColumn:   jp.DebitColumn,
+		CreditColumn:  jp.CreditColumn,
+		Negate:        jp.Negate,
+		DecimalComma:  jp.DecimalComma,
+		MemoColumn:    jp.MemoColumn,
+		ClearedColumn: jp.ClearedColumn,
+		ClearedValue:  jp.ClearedValue,
+		IDColumn:      jp.IDColumn,
+	}
+}
+
+func (js Schedule) model(accounts, envelopes, payees refs) (model.Schedule, error) {
+	sc := model.Schedule{
+		Typ:    js.Typ,
+		Amount: js.Amount,
+		Memo:   js.Memo,
+		Kind:   js.Kind,
+		N:      js.N,
+		Start:  js.Start,
+		Last:   js.Last,
+	}
+	var err error
+	if sc.AccountID, err = accounts.id("account", js.AccountID); err != nil {
+		return sc, err
+	}
+	if sc.EnvelopeID, err = envelopes.null("envelope", js.EnvelopeID); err != nil {
+		return sc, err
+	}
+	if sc.PayeeID, err = payees.null("payee", js.PayeeID); err != nil {
+		return sc, err
+	}
+	if js.End != nil {
+		sc.End = sql.NullInt32{Int32: int32(*js.End), Valid: true}
+	}
+	return sc, nil
+}
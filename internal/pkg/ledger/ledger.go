@@ -0,0 +1,224 @@
+package ledger
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The whole ledger as one JSON document, to back up a budget or move it to another machine or driver
+// IDs in the document are positions, 1 for the first row of each list, so they do not depend on the DB they came from
+// Checkpoints are not kept, Import recomputes them
+
+// Bumped whenever the document changes shape, Read refuses versions it does not know
+// Version 2 added schedules, a version 1 document reads as one without any
+// Version 3 added reconciled, older documents read as nothing reconciled
+// Version 4 added closed accounts, older documents read as every account open
+const Version = 4
+
+var (
+	ErrInvalid  = errors.New("invalid ledger")
+	ErrVersion  = errors.New("unsupported ledger version")
+	ErrNotEmpty = errors.New("database is not empty")
+)
+
+type Ledger struct {
+	Version int `json:"version"`
+
+	Accounts       []Account       `json:"accounts"`
+	EnvelopeGroups []EnvelopeGroup `json:"envelopeGroups"`
+	Envelopes      []Envelope      `json:"envelopes"`
+	Payees         []Payee         `json:"payees"`
+	Rules          []Rule          `json:"rules"`
+	CSVProfiles    []CSVProfile    `json:"csvProfiles"`
+	Schedules      []Schedule      `json:"schedules"`
+
+	AccountTransactions  []AccountTransaction  `json:"accountTransactions"`
+	Transfers            []Transfer            `json:"transfers"`
+	EnvelopeTransactions []EnvelopeTransaction `json:"envelopeTransactions"`
+}
+
+type Account struct {
+	ID          model.PKEY         `json:"id"`
+	Institution string             `json:"institution"`
+	Name        string             `json:"name"`
+	Class       model.AccountClass `json:"class"`
+	Hidden      bool               `json:"hidden"`
+	Offbudget   bool               `json:"offbudget"`
+	Debt        bool               `json:"debt"`
+	// Closed last, once its whole history is in
+	Closed *bcdate.BCDate `json:"closed,omitempty"`
+
+	StartingBalance int `json:"startingBalance"`
+}
+
+// The first group is the catch-all every DB starts with
+type EnvelopeGroup struct {
+	ID   model.PKEY `json:"id"`
+	Name string     `json:"name"`
+	Sort int        `json:"sort"`
+}
+
+type Envelope struct {
+	ID      model.PKEY     `json:"id"`
+	GroupID model.PKEY     `json:"groupId"`
+	Name    string         `json:"name"`
+	Notes   string         `json:"notes"`
+	Hidden  bool           `json:"hidden"`
+	Goal    model.GoalType `json:"goal"`
+	GoalAmt int            `json:"goalAmt"`
+	GoalTgt int            `json:"goalTgt"`
+	Sort    int            `json:"sort"`
+
+	// Debt envelopes are made along with their account
+	DebtAccountID *model.PKEY `json:"debtAccountId"`
+}
+
+type Payee struct {
+	ID         model.PKEY  `json:"id"`
+	Name       string      `json:"name"`
+	EnvelopeID *model.PKEY `json:"envelopeId"`
+}
+
+type Rule struct {
+	Priority int    `json:"priority"`
+	Name     string `json:"name"`
+
+	MemoPattern string         `json:"memoPattern"`
+	MinAmount   *int64         `json:"minAmount"`
+	MaxAmount   *int64         `json:"maxAmount"`
+	AccountID   *model.PKEY    `json:"accountId"`
+	FromDate    *bcdate.BCDate `json:"fromDate"`
+	ToDate      *bcdate.BCDate `json:"toDate"`
+
+	EnvelopeID *model.PKEY            `json:"envelopeId"`
+	Typ        *model.TransactionType `json:"type"`
+	Cleared    *bool                  `json:"cleared"`
+	Memo       *string                `json:"memo"`
+}
+
+type CSVProfile struct {
+	Name          string `json:"name"`
+	Delimiter     string `json:"delimiter"`
+	SkipRows      int    `json:"skipRows"`
+	Header        bool   `json:"header"`
+	DateColumn    string `json:"dateColumn"`
+	DateFormat    string `json:"dateFormat"`
+	AmountColumn  string `json:"amountColumn"`
+	DebitColumn   string `json:"debitColumn"`
+	CreditColumn  string `json:"creditColumn"`
+	Negate        bool   `json:"negate"`
+	DecimalComma  bool   `json:"decimalComma"`
+	MemoColumn    string `json:"memoColumn"`
+	ClearedColumn string `json:"clearedColumn"`
+	ClearedValue  string `json:"clearedValue"`
+	IDColumn      string `json:"idColumn"`
+}
+
+// Last carries over so an import does not enter occurrences again
+type Schedule struct {
+	AccountID  model.PKEY            `json:"accountId"`
+	EnvelopeID *model.PKEY           `json:"envelopeId"`
+	PayeeID    *model.PKEY           `json:"payeeId"`
+	Typ        model.TransactionType `json:"type"`
+	Amount     int                   `json:"amount"`
+	Memo       string                `json:"memo"`
+	Kind       model.ScheduleKind    `json:"kind"`
+	N          int                   `json:"n"`
+	Start      bcdate.BCDate         `json:"start"`
+	End        *bcdate.BCDate        `json:"end"`
+	Last       bcdate.BCDate         `json:"last"`
+}
+
+type AccountTransaction struct {
+	ID         model.PKEY            `json:"id"`
+	AccountID  model.PKEY            `json:"accountId"`
+	Typ        model.TransactionType `json:"type"`
+	EnvelopeID *model.PKEY           `json:"envelopeId"`
+	PostDate   bcdate.BCDate         `json:"postDate"`
+	Amount     int                   `json:"amount"`
+	Cleared    bool                  `json:"cleared"`
+	Memo       string                `json:"memo"`
+	PayeeID    *model.PKEY           `json:"payeeId"`
+	ImportID   *string               `json:"importId"`
+	Splits     []Split               `json:"splits,omitempty"`
+	Reconciled bool                  `json:"reconciled,omitempty"`
+}
+
+type Split struct {
+	EnvelopeID *model.PKEY `json:"envelopeId"`
+	Amount     int         `json:"amount"`
+	Memo       string      `json:"memo"`
+}
+
+// Links two TT_TRANSFER account transactions, the legs themselves are in AccountTransactions
+type Transfer struct {
+	FromID model.PKEY `json:"fromId"`
+	ToID   model.PKEY `json:"toId"`
+}
+
+type EnvelopeTransaction struct {
+	EnvelopeID model.PKEY    `json:"envelopeId"`
+	PostDate   bcdate.BCDate `json:"postDate"`
+	Amount     int           `json:"amount"`
+}
+
+// Read a ledger document, rejecting unknown fields and versions
+func Read(r io.Reader) (Ledger, error) {
+	var l Ledger
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&l); err != nil {
+		return l, fmt.Errorf("Read.Decode -- %w: %s", ErrInvalid, err.Error())
+	}
+	if l.Version < 1 || l.Version > Version {
+		return l, fmt.Errorf("Read -- %w: %d, want 1 to %d", ErrVersion, l.Version, Version)
+	}
+	return l, nil
+}
+
+// Write the ledger indented, the same ledger always gives the same bytes
+func Write(w io.Writer, l Ledger) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(l); err != nil {
+		return fmt.Errorf("Write.Encode -- %w", err)
+	}
+	return nil
+}
+
+// Positions in the document for DB IDs, and back
+
+type refs map[model.PKEY]model.PKEY
+
+func (m refs) ref(id sql.NullInt32) *model.PKEY {
+	if !id.Valid {
+		return nil
+	}
+	r := m[model.PKEY(id.Int32)]
+	return &r
+}
+
+func (m refs) null(what string, r *model.PKEY) (sql.NullInt32, error) {
+	if r == nil {
+		return sql.NullInt32{}, nil
+	}
+	id, ok := m[*r]
+	if !ok {
+		return sql.NullInt32{}, fmt.Errorf("%w: no %s %d", ErrInvalid, what, *r)
+	}
+	return sql.NullInt32{Int32: int32(id), Valid: true}, nil
+}
+
+func (m refs) id(what string, r model.PKEY) (model.PKEY, error) {
+	id, ok := m[r]
+	if !ok {
+		return 0, fmt.Errorf("%w: no %s %d", ErrInvalid, what, r)
+	}
+	return id, nil
+}
@@ -0,0 +1,182 @@
+package ledger_test
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/ledger"
+	"budgeting/internal/pkg/model"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newDB(t *testing.T, name string) db.DB {
+	t.Helper()
+	sdb := db.NewSQLite()
+	if err := sdb.Open(filepath.Join(t.TempDir(), name)); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err := sdb.Init(); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+	return sdb
+}
+
+func nullID(id model.PKEY) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(id), Valid: true}
+}
+
+// A bit of everything, with deleted rows leaving gaps in the IDs
+func fill(t *testing.T, sdb db.DB) {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("fill: %s", err)
+		}
+	}
+	m1 := bcdate.CurrentMonth().PrevMonth()
+
+	gone := model.Account{Institution: "Bank", Name: "Gone"}
+	must(sdb.NewAccount(&gone))
+	chk := model.Account{Institution: "Bank", Name: "Checking"}
+	must(sdb.NewAccount(&chk))
+	must(sdb.SetStartingBalance(chk.ID, 100000))
+	sav := model.Account{Institution: "Bank", Name: "Savings", Offbudget: true, Class: model.AT_SAVINGS}
+	must(sdb.NewAccount(&sav))
+	must(sdb.DeleteAccount(gone.ID))
+
+	bills := model.EnvelopeGroup{Name: "Bills", Sort: 1}
+	must(sdb.NewEnvelopeGroup(&bills))
+	rent := model.Envelope{GroupID: bills.ID, Name: "Rent", Goal: model.GT_RECUR, GoalAmt: 120000}
+	must(sdb.NewEnvelope(&rent))
+	old := model.Envelope{GroupID: 1, Name: "Old"}
+	must(sdb.NewEnvelope(&old))
+	must(sdb.DeleteEnvelope(old.ID))
+	// The debt envelope comes after Rent and before Food
+	visa := model.Account{Institution: "Bank", Name: "Visa", Debt: true, Class: model.AT_CREDITCARD}
+	must(sdb.NewAccount(&visa))
+	food := model.Envelope{GroupID: 1, Name: "Food", Notes: "Groceries too", Hidden: true, Goal: model.GT_RECTIL, GoalAmt: 500, GoalTgt: 20000, Sort: 3}
+	must(sdb.NewEnvelope(&food))
+
+	grocer := model.Payee{Name: "Grocer", EnvelopeID: nullID(food.ID)}
+	must(sdb.NewPayee(&grocer))
+	must(sdb.NewRule(&model.Rule{Priority: 2, Name: "Cafe", MemoPattern: `(?i)cafe`, MaxAmount: sql.NullInt64{Int64: -1, Valid: true}, AccountID: nullID(chk.ID), EnvelopeID: nullID(food.ID), Memo: sql.NullString{String: "Cafe", Valid: true}}))
+	must(sdb.NewRule(&model.Rule{Priority: 1, Name: "Payroll", MemoPattern: `(?i)payroll`, MinAmount: sql.NullInt64{Int64: 1, Valid: true}, Typ: sql.NullInt32{Int32: int32(model.TT_INCOME), Valid: true}, Cleared: sql.NullBool{Bool: true, Valid: true}}))
+	must(sdb.NewCSVProfile(&model.CSVProfile{Name: "Plain", DateColumn: "1", DateFormat: "YYYY-MM-DD", AmountColumn: "2", Negate: true}))
+	must(sdb.NewSchedule(&model.Schedule{AccountID: chk.ID, EnvelopeID: nullID(rent.ID), Amount: -120000, Memo: "Rent", Kind: model.SK_MONTHLY, N: 1, Start: m1 + 1, End: sql.NullInt32{Int32: int32(m1.NextMonth().NextMonth().NextMonth()) + 1, Valid: true}, Last: m1 + 1}))
+
+	must(sdb.Batch_NewEnvelopeTransaction([]model.EnvelopeTransaction{
+		{EnvelopeID: rent.ID, PostDate: m1, Amount: 120000},
+		{EnvelopeID: food.ID, PostDate: m1, Amount: 30000},
+	}))
+	salary := model.AccountTransaction{AccountID: chk.ID, Typ: model.TT_INCOME, PostDate: m1 + 1, Amount: 250000, Cleared: true, Memo: "Salary", ImportID: sql.NullString{String: "S-1", Valid: true}}
+	must(sdb.NewAccountTransaction(&salary))
+	must(sdb.SetReconciled(salary.ID, true))
+	// The payee's envelope was picked on the way in, an unassigned one must stay unassigned
+	must(sdb.NewAccountTransaction(&model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 2, Amount: -4500, Memo: "Grocer", PayeeID: nullID(grocer.ID)}))
+	at := model.AccountTransaction{AccountID: chk.ID, PostDate: m1 + 3, Amount: -1000, Memo: "Cafe", PayeeID: nullID(grocer.ID)}
+	must(sdb.NewAccountTransaction(&at))
+	at.EnvelopeID = sql.NullInt32{}
+	must(sdb.UpdateAccountTransaction(at))
+	must(sdb.NewAccountTransaction(&model.AccountTransaction{AccountID: visa.ID, PostDate: m1 + 4, Amount: -3000, Memo: "Market", Splits: []model.Split{
+		{EnvelopeID: nullID(food.ID), Amount: -2000, Memo: "Food"},
+		{Amount: -1000},
+	}}))
+
+	x := model.Transfer{FromAccountID: chk.ID, ToAccountID: visa.ID, PostDate: m1 + 5, Amount: 3000, Memo: "Card payment"}
+	must(sdb.NewTransfer(&x))
+	leg, err := sdb.GetAccountTransaction(x.ToID)
+	must(err)
+	leg.Cleared, leg.Memo = true, "Thank you"
+	must(sdb.UpdateAccountTransaction(leg))
+	must(sdb.SetReconciled(leg.ID, true))
+	must(sdb.NewTransfer(&model.Transfer{FromAccountID: chk.ID, ToAccountID: sav.ID, PostDate: m1 + 6, Amount: 5000, EnvelopeID: nullID(rent.ID)}))
+	must(sdb.CloseAccount(db.Closing{AccountID: sav.ID, Date: m1 + 7, TransferTo: nullID(chk.ID)}))
+}
+
+func export(t *testing.T, sdb db.DB) string {
+	t.Helper()
+	l, err := ledger.Export(sdb)
+	if err != nil {
+		t.Fatalf("Export: %s", err)
+	}
+	var out bytes.Buffer
+	if err := ledger.Write(&out, l); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	return out.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := newDB(t, "src.db")
+	fill(t, src)
+	first := export(t, src)
+
+	l, err := ledger.Read(strings.NewReader(first))
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	if jt := l.AccountTransactions[0]; jt.ImportID == nil || *jt.ImportID != "S-1" || !jt.Reconciled {
+		t.Fatalf("Salary = %+v", jt)
+	}
+	if r := l.Rules[0]; r.MaxAmount == nil || *r.MaxAmount != -1 || r.Memo == nil || *r.Memo != "Cafe" || r.Cleared != nil {
+		t.Fatalf("Rule = %+v", r)
+	}
+	if sc := l.Schedules[0]; sc.Last == 0 || sc.End == nil || sc.EnvelopeID == nil || sc.PayeeID != nil {
+		t.Fatalf("Schedule = %+v", sc)
+	}
+	if len(l.Accounts) != 3 || len(l.Envelopes) != 3 || len(l.AccountTransactions) != 10 || len(l.Transfers) != 3 || l.Envelopes[1].DebtAccountID == nil || l.Accounts[1].Closed == nil {
+		t.Fatalf("Read = %+v", l)
+	}
+
+	dst := newDB(t, "dst.db")
+	if err := ledger.Import(dst, l); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+	if again := export(t, dst); again != first {
+		t.Fatalf("Export after import =\n%s\nwant\n%s", again, first)
+	}
+
+	if vs, err := dst.Check(); err != nil || len(vs) != 0 {
+		t.Fatalf("Check = %v, %v", vs, err)
+	}
+	want, err := src.GetOverallSummary(bcdate.CurrentMonth())
+	if err != nil {
+		t.Fatalf("GetOverallSummary: %s", err)
+	}
+	if got, err := dst.GetOverallSummary(bcdate.CurrentMonth()); err != nil || got != want {
+		t.Fatalf("GetOverallSummary = %+v, %v, want %+v", got, err, want)
+	}
+
+	if err := ledger.Import(dst, l); !errors.Is(err, ledger.ErrNotEmpty) {
+		t.Fatalf("Import twice = %v, want ErrNotEmpty", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"version":       fmt.Sprintf(`{"version": %d}`, ledger.Version+1),
+		"no version":    `{"accounts": []}`,
+		"unknown field": `{"version": 1, "acounts": []}`,
+		"not json":      `version 1`,
+	} {
+		_, err := ledger.Read(strings.NewReader(data))
+		if !errors.Is(err, ledger.ErrInvalid) && !errors.Is(err, ledger.ErrVersion) {
+			t.Errorf("Read %s = %v, want an error", name, err)
+		}
+	}
+
+	bad := ledger.Ledger{
+		Version:             ledger.Version,
+		Accounts:            []ledger.Account{{ID: 1, Institution: "Bank", Name: "Checking"}},
+		AccountTransactions: []ledger.AccountTransaction{{ID: 1, AccountID: 2, PostDate: 20240101, Amount: -1}},
+	}
+	if err := ledger.Import(newDB(t, "bad.db"), bad); !errors.Is(err, ledger.ErrInvalid) {
+		t.Fatalf("Import unknown account = %v, want ErrInvalid", err)
+	}
+}
@@ -1,3 +1,258 @@
 package auth
 
-// TODO: Auth middleware that allows user authentication
+import (
+	"budgeting/internal/pkg/middleware/session"
+	"budgeting/internal/pkg/model"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Auth middleware, lets a request through once it says who made it
+//
+// Browsers log in with a name and password at /login, which starts a session until /logout, see middleware/session
+// Scripts send one of their user's API tokens as "Authorization: Bearer <token>"
+// Anything else is sent to /login, or gets a 401 under /api/
+
+type userKeyType int
+
+const userKey userKeyType = 0
+
+const (
+	loginPath  = "/login"
+	logoutPath = "/logout"
+
+	bcryptCost = 12
+)
+
+var ErrBadPassword = errors.New("password must be between 8 and 72 bytes")
+
+// Where users and their tokens live, db.DB is one
+type Store interface {
+	GetUser(id model.PKEY) (model.User, error)
+	GetUserByName(name string) (model.User, error)
+	GetTokenByHash(hash string) (model.Token, error)
+}
+
+type Auth struct {
+	next  http.Handler
+	store Store
+	// Compared against when the name is unknown, so a wrong name takes as long as a wrong password
+	dummy []byte
+}
+
+func NewAuth(next http.Handler, store Store) http.Handler {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("not a password"), bcryptCost)
+	if err != nil {
+		panic(fmt.Errorf("failed to hash the dummy password -- %w", err))
+	}
+	return &Auth{next, store, dummy}
+}
+
+func (h *Auth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch r.URL.Path {
+	case loginPath:
+		h.login(w, r)
+		return
+	case logoutPath:
+		h.logout(w, r)
+		return
+	}
+
+	// The login page may need these before anyone is logged in
+	if strings.HasPrefix(r.URL.Path, "/static/") {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	u, ok := h.authenticate(r)
+	if !ok {
+		if isAPI(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="budget"`)
+			writeUnauthorized(w)
+			return
+		}
+		http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, u)))
+}
+
+// Name of the user who made r, ok is false when no auth middleware let it through
+func GetUser(r *http.Request) (name string, ok bool) {
+	u, ok := r.Context().Value(userKey).(model.User)
+	return u.Name, ok
+}
+
+func GetUserID(r *http.Request) (id model.PKEY, ok bool) {
+	u, ok := r.Context().Value(userKey).(model.User)
+	return u.ID, ok
+}
+
+// Bcrypt hash to store for a new password
+func HashPassword(password string) (string, error) {
+	if len(password) < 8 || len(password) > 72 {
+		return "", ErrBadPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("HashPassword -- %w", err)
+	}
+	return string(hash), nil
+}
+
+// A fresh API token to hand out once, and the hash to store for it
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("NewToken -- %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check name and password, the user is empty unless they match
+func (h *Auth) checkPassword(name, password string) (model.User, bool) {
+	u, err := h.store.GetUserByName(name)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(h.dummy, []byte(password))
+		return model.User{}, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(password)); err != nil {
+		return model.User{}, false
+	}
+	return u, true
+}
+
+// Who sent r, from its bearer token or else its session
+func (h *Auth) authenticate(r *http.Request) (model.User, bool) {
+	var id model.PKEY
+	if v := r.Header.Get("Authorization"); v != "" {
+		scheme, token, _ := strings.Cut(v, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return model.User{}, false
+		}
+		t, err := h.store.GetTokenByHash(HashToken(strings.TrimSpace(token)))
+		if err != nil {
+			return model.User{}, false
+		}
+		id = t.UserID
+	} else if uid, ok := session.GetUserID(r); ok {
+		id = uid
+	} else {
+		return model.User{}, false
+	}
+
+	u, err := h.store.GetUser(id)
+	if err != nil {
+		return model.User{}, false
+	}
+	return u, true
+}
+
+// GET shows the form, POST checks it and starts a session
+func (h *Auth) login(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+
+	switch r.Method {
+	case http.MethodGet:
+		renderLogin(w, http.StatusOK, next, "")
+
+	case http.MethodPost:
+		u, ok := h.checkPassword(r.PostFormValue("name"), r.PostFormValue("password"))
+		if !ok {
+			log.Printf("Auth -- failed login for %q from %s", r.PostFormValue("name"), r.RemoteAddr)
+			renderLogin(w, http.StatusUnauthorized, next, "Wrong name or password")
+			return
+		}
+
+		if err := session.Start(w, r, u.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POST ends the session, API tokens are left alone
+func (h *Auth) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := session.End(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, loginPath, http.StatusSeeOther)
+}
+
+func isAPI(r *http.Request) bool {
+	return r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// Only paths on this server, so the login form cannot be used to send someone elsewhere
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// Same shape as the API errors, see app.writeError
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(struct {
+		Status  int    `json:"status"`
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{
+		Status:  http.StatusUnauthorized,
+		Error:   http.StatusText(http.StatusUnauthorized),
+		Message: "log in, or send an API token in an Authorization: Bearer header",
+	})
+}
+
+// Parsed on each render like the views, so a missing template only breaks the form
+func renderLogin(w http.ResponseWriter, status int, next, message string) {
+	tmpl, err := template.ParseFiles("web/template/login.html")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse login template -- %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, struct {
+		Next    string
+		Message string
+	}{next, message}); err != nil {
+		log.Printf("Auth -- login template -- %s", err.Error())
+	}
+}
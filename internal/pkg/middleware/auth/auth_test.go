@@ -0,0 +1,220 @@
+package auth_test
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/auth"
+	"budgeting/internal/pkg/middleware/session"
+	"budgeting/internal/pkg/model"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An auth middleware behind sessions over a fresh DB holding alice, the wrapped handler echoes who it was called for
+func newAuth(t *testing.T) (http.Handler, db.DB) {
+	t.Helper()
+
+	// The login form is read from web/template like the views
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %s", err)
+	}
+	if err := os.Chdir("../../../.."); err != nil {
+		t.Fatalf("Chdir: %s", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	d := db.NewSQLite()
+	if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %s", err)
+	}
+	if err := d.NewUser(&model.User{Name: "alice", Hash: hash}); err != nil {
+		t.Fatalf("NewUser: %s", err)
+	}
+
+	return session.NewSessions(auth.NewAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _ := auth.GetUser(r)
+		w.Write([]byte(name))
+	}), d), d, session.Config{Idle: session.DefaultIdle, Absolute: session.DefaultAbsolute}), d
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func login(h http.Handler, name, password, next string) *httptest.ResponseRecorder {
+	form := url.Values{"name": {name}, "password": {password}, "next": {next}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(h, r)
+}
+
+func TestHashPassword(t *testing.T) {
+	if _, err := auth.HashPassword("short"); err != auth.ErrBadPassword {
+		t.Fatalf("HashPassword of a short password = %v", err)
+	}
+	if _, err := auth.HashPassword(strings.Repeat("x", 73)); err != auth.ErrBadPassword {
+		t.Fatalf("HashPassword of a long password = %v", err)
+	}
+	a, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %s", err)
+	}
+	b, _ := auth.HashPassword("correct horse")
+	if a == b || strings.Contains(a, "correct horse") {
+		t.Fatalf("HashPassword is not salted: %q, %q", a, b)
+	}
+}
+
+func TestUnauthenticated(t *testing.T) {
+	h, _ := newAuth(t)
+
+	w := serve(h, httptest.NewRequest("GET", "/envelopes?qm=2024-01", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?next=%2Fenvelopes%3Fqm%3D2024-01" {
+		t.Fatalf("GET /envelopes = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serve(h, httptest.NewRequest("GET", "/api/accounts", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") != "application/json" || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("GET /api/accounts = %d %v", w.Code, w.Header())
+	}
+
+	r := httptest.NewRequest("GET", "/api/accounts", nil)
+	r.Header.Set("Authorization", "Bearer nope")
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/accounts with a bad token = %d", w.Code)
+	}
+
+	if w := serve(h, httptest.NewRequest("GET", "/static/app.css", nil)); w.Code != http.StatusOK {
+		t.Fatalf("GET /static = %d", w.Code)
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/login", nil)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "password") {
+		t.Fatalf("GET /login = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLogin(t *testing.T) {
+	h, _ := newAuth(t)
+
+	if w := login(h, "alice", "wrong horse", "/"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("POST /login with a wrong password = %d", w.Code)
+	}
+	if w := login(h, "mallory", "correct horse", "/"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("POST /login with an unknown name = %d", w.Code)
+	}
+
+	// Only paths on this server are followed after logging in
+	if w := login(h, "alice", "correct horse", "//evil.example"); w.Header().Get("Location") != "/" {
+		t.Fatalf("POST /login next = %q", w.Header().Get("Location"))
+	}
+
+	w := login(h, "alice", "correct horse", "/accounts")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/accounts" {
+		t.Fatalf("POST /login = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cs := w.Result().Cookies()
+	if len(cs) != 1 || cs[0].Name != session.CookieName || cs[0].Value == "" {
+		t.Fatalf("POST /login cookies = %+v", cs)
+	}
+
+	r := httptest.NewRequest("GET", "/accounts", nil)
+	r.AddCookie(cs[0])
+	if w := serve(h, r); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("GET /accounts logged in = %d %q", w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(cs[0])
+	if w := serve(h, r); w.Code != http.StatusSeeOther || w.Result().Cookies()[0].MaxAge >= 0 {
+		t.Fatalf("POST /logout = %d", w.Code)
+	}
+	r = httptest.NewRequest("GET", "/api/accounts", nil)
+	r.AddCookie(cs[0])
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/accounts after logout = %d", w.Code)
+	}
+}
+
+// A browser on plain HTTP, as cmd/server serves, logs in and is still logged in where the redirect takes it
+// The jar keeps the browser's rules, a cookie marked Secure is not sent back over http to anything but localhost
+func TestLoginOverPlainHTTP(t *testing.T) {
+	h, _ := newAuth(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New: %s", err)
+	}
+
+	form := url.Values{"name": {"alice"}, "password": {"correct horse"}, "next": {"/accounts"}}
+	r := httptest.NewRequest("POST", "http://budget.example/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := serve(h, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /login = %d", w.Code)
+	}
+	jar.SetCookies(r.URL, w.Result().Cookies())
+
+	next, err := r.URL.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Location %q: %s", w.Header().Get("Location"), err)
+	}
+	r = httptest.NewRequest("GET", next.String(), nil)
+	for _, c := range jar.Cookies(next) {
+		r.AddCookie(c)
+	}
+	if w := serve(h, r); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("GET %s after logging in = %d to %q", next, w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestTokens(t *testing.T) {
+	h, d := newAuth(t)
+
+	alice, err := d.GetUserByName("alice")
+	if err != nil {
+		t.Fatalf("GetUserByName: %s", err)
+	}
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		t.Fatalf("NewToken: %s", err)
+	}
+	tok := model.Token{UserID: alice.ID, Name: "cron", Hash: hash}
+	if err := d.NewToken(&tok); err != nil {
+		t.Fatalf("NewToken: %s", err)
+	}
+
+	r := httptest.NewRequest("GET", "/api/accounts", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if w := serve(h, r); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("GET /api/accounts with a token = %d %q", w.Code, w.Body.String())
+	}
+
+	// A token is no session, there is nothing to log out of
+	r = httptest.NewRequest("POST", "/logout", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	serve(h, r)
+	r = httptest.NewRequest("GET", "/api/accounts", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if w := serve(h, r); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/accounts after logout = %d", w.Code)
+	}
+
+	if err := d.DeleteToken(tok.ID); err != nil {
+		t.Fatalf("DeleteToken: %s", err)
+	}
+	r = httptest.NewRequest("GET", "/api/accounts", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/accounts with a revoked token = %d", w.Code)
+	}
+}
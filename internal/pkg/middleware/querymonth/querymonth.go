@@ -2,6 +2,7 @@ package querymonth
 
 import (
 	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/middleware/session"
 	"context"
 	"log"
 	"net/http"
@@ -31,17 +32,28 @@ func (h *QueryMonth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				month, err := strconv.Atoi(match[1] + match[2] + "00")
 				if err == nil {
 					log.Printf("Found querymonth: %s-%s =  %d", match[1], match[2], month)
+					if err := session.SetQM(r, month); err != nil {
+						log.Printf("Failed to remember querymonth: %s", err.Error())
+					}
 					h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), queryMonthKey, int(month))))
 					return
 				}
 			}
 		}
+	} else if qm, ok := session.GetQM(r); ok {
+		// Without one asked for, carry on with the month the session last used
+		log.Printf("Session querymonth: %d", qm)
+		h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), queryMonthKey, qm)))
+		return
 	}
 
 	// If valid was found, we returned above
 	// Construct today
 	qm := int(bcdate.CurrentMonth())
 	log.Printf("Missing or mal-parsed date, use today: %d", qm)
+	if err := session.SetQM(r, qm); err != nil {
+		log.Printf("Failed to remember querymonth: %s", err.Error())
+	}
 	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), queryMonthKey, qm)))
 
 }
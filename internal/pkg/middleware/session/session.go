@@ -1,3 +1,243 @@
 package session
 
-// TODO: Session middleware that eliminates logging in each time
+import (
+	"budgeting/internal/pkg/model"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Session middleware, remembers a logged in browser with a cookie so it logs in once
+//
+// The cookie holds a random ID, the store only keeps its SHA-256 along with the user and the last query month
+// A session ends when idle for longer than Config.Idle, Config.Absolute after it started, on End, or when revoked from the store
+// Start and End are called by the auth middleware on login and logout
+
+type sessionKeyType int
+
+const sessionKey sessionKeyType = 0
+
+const CookieName = "budget_session"
+
+// LastSeen is only written back this often, so not every request is a write
+const touchEvery = time.Minute
+
+// Expiry cmd/server and querytool use unless told otherwise
+const (
+	DefaultIdle     = 24 * time.Hour
+	DefaultAbsolute = 30 * 24 * time.Hour
+)
+
+var ErrNoSessions = errors.New("no session middleware on the request")
+
+// Where sessions live, db.DB is one
+type Store interface {
+	GetSessionByHash(hash string) (model.Session, error)
+	NewSession(*model.Session) error
+	UpdateSession(model.Session) error
+	DeleteSession(id model.PKEY) error
+	PruneSessions(seenBefore, createdBefore time.Time) (int, error)
+}
+
+type Config struct {
+	// Ends a session not used for this long
+	Idle time.Duration
+	// Ends a session this long after login however much it is used
+	Absolute time.Duration
+	// Mark the cookie HTTPS only on every request, for a server behind an HTTPS proxy
+	// Requests that came in over TLS get a secure cookie either way, plain HTTP ones otherwise do not
+	Secure bool
+}
+
+type Sessions struct {
+	next   http.Handler
+	store  Store
+	config Config
+}
+
+// What the middleware puts on the request, a pointer so Start and End can change it for the handlers after them
+type state struct {
+	h    *Sessions
+	sess model.Session
+	ok   bool
+}
+
+func NewSessions(next http.Handler, store Store, config Config) http.Handler {
+	return &Sessions{next, store, config}
+}
+
+func (h *Sessions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	st := &state{h: h}
+
+	if c, err := r.Cookie(CookieName); err == nil && c.Value != "" {
+		sess, err := h.store.GetSessionByHash(hashID(c.Value))
+		now := time.Now()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Unknown, revoked or pruned, the browser can stop sending it
+			h.clearCookie(w, r)
+		case err != nil:
+			log.Printf("Session -- failed to look up session: %s", err.Error())
+		case h.expired(sess, now):
+			if err := h.store.DeleteSession(sess.ID); err != nil {
+				log.Printf("Session -- failed to delete expired session %d: %s", sess.ID, err.Error())
+			}
+			h.clearCookie(w, r)
+		default:
+			if now.Sub(sess.LastSeen) >= touchEvery {
+				sess.LastSeen = now
+				if err := h.store.UpdateSession(sess); err != nil {
+					log.Printf("Session -- failed to touch session %d: %s", sess.ID, err.Error())
+				}
+			}
+			st.sess, st.ok = sess, true
+		}
+	}
+
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey, st)))
+}
+
+func (h *Sessions) expired(sess model.Session, now time.Time) bool {
+	return now.Sub(sess.LastSeen) > h.config.Idle || now.Sub(sess.Created) > h.config.Absolute
+}
+
+// A secure cookie on plain HTTP is dropped by the browser and the login never sticks
+func (h *Sessions) secure(r *http.Request) bool {
+	return h.config.Secure || r.TLS != nil
+}
+
+func (h *Sessions) setCookie(w http.ResponseWriter, r *http.Request, id string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   h.secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *Sessions) clearCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func getState(r *http.Request) *state {
+	st, _ := r.Context().Value(sessionKey).(*state)
+	return st
+}
+
+// The session r came with, ok is false when it has none
+func Get(r *http.Request) (sess model.Session, ok bool) {
+	if st := getState(r); st != nil && st.ok {
+		return st.sess, true
+	}
+	return model.Session{}, false
+}
+
+// Who the session of r is logged in as
+func GetUserID(r *http.Request) (id model.PKEY, ok bool) {
+	sess, ok := Get(r)
+	return sess.UserID, ok
+}
+
+// The query month the session last asked for, ok is false without a session or before it asked for one
+func GetQM(r *http.Request) (qm int, ok bool) {
+	sess, ok := Get(r)
+	return sess.QM, ok && sess.QM != 0
+}
+
+// Remember qm as the last query month of the session of r, if it has one
+func SetQM(r *http.Request, qm int) error {
+	st := getState(r)
+	if st == nil || !st.ok || st.sess.QM == qm {
+		return nil
+	}
+	st.sess.QM = qm
+	if err := st.h.store.UpdateSession(st.sess); err != nil {
+		return fmt.Errorf("SetQM -- %w", err)
+	}
+	return nil
+}
+
+// Log the browser of r in as userID, replacing any session it had
+func Start(w http.ResponseWriter, r *http.Request, userID model.PKEY) error {
+	st := getState(r)
+	if st == nil {
+		return ErrNoSessions
+	}
+	h := st.h
+
+	now := time.Now()
+	if _, err := h.store.PruneSessions(now.Add(-h.config.Idle), now.Add(-h.config.Absolute)); err != nil {
+		return fmt.Errorf("Start.PruneSessions -- %w", err)
+	}
+	if st.ok {
+		if err := h.store.DeleteSession(st.sess.ID); err != nil {
+			return fmt.Errorf("Start.DeleteSession -- %w", err)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("Start.Read -- %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	sess := model.Session{
+		UserID:   userID,
+		Hash:     hashID(id),
+		Created:  now,
+		LastSeen: now,
+		QM:       st.sess.QM,
+		Agent:    r.UserAgent(),
+		Addr:     r.RemoteAddr,
+	}
+	if err := h.store.NewSession(&sess); err != nil {
+		return fmt.Errorf("Start.NewSession -- %w", err)
+	}
+
+	h.setCookie(w, r, id, now.Add(h.config.Absolute))
+	st.sess, st.ok = sess, true
+	return nil
+}
+
+// Log the browser of r out, deleting its session
+func End(w http.ResponseWriter, r *http.Request) error {
+	st := getState(r)
+	if st == nil {
+		return ErrNoSessions
+	}
+
+	if st.ok {
+		if err := st.h.store.DeleteSession(st.sess.ID); err != nil {
+			return fmt.Errorf("End.DeleteSession -- %w", err)
+		}
+	}
+
+	st.h.clearCookie(w, r)
+	st.sess, st.ok = model.Session{}, false
+	return nil
+}
+
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
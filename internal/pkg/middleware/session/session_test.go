@@ -0,0 +1,200 @@
+package session_test
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/session"
+	"budgeting/internal/pkg/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var config = session.Config{Idle: time.Hour, Absolute: 24 * time.Hour}
+
+// Sessions over a fresh DB holding alice
+// /login starts a session for alice, /logout ends it, /qm?qm=<n> sets the query month, anything else echoes the session
+func newSessions(t *testing.T) (http.Handler, db.DB, model.User) {
+	t.Helper()
+	return newSessionsWith(t, config)
+}
+
+func newSessionsWith(t *testing.T, c session.Config) (http.Handler, db.DB, model.User) {
+	t.Helper()
+	d := db.NewSQLite()
+	if err := d.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	alice := model.User{Name: "alice", Hash: "x"}
+	if err := d.NewUser(&alice); err != nil {
+		t.Fatalf("NewUser: %s", err)
+	}
+
+	return session.NewSessions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.URL.Path {
+		case "/login":
+			err = session.Start(w, r, alice.ID)
+		case "/logout":
+			err = session.End(w, r)
+		case "/qm":
+			var qm int
+			fmt.Sscan(r.URL.Query().Get("qm"), &qm)
+			err = session.SetQM(r, qm)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		uid, ok := session.GetUserID(r)
+		qm, _ := session.GetQM(r)
+		fmt.Fprintf(w, "%v %d %d", ok, uid, qm)
+	}), d, c), d, alice
+}
+
+func get(t *testing.T, h http.Handler, path string, c *http.Cookie) (string, []*http.Cookie) {
+	t.Helper()
+	r := httptest.NewRequest("GET", path, nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", path, w.Code, w.Body.String())
+	}
+	return w.Body.String(), w.Result().Cookies()
+}
+
+func hash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSessions(t *testing.T) {
+	h, d, alice := newSessions(t)
+
+	if body, _ := get(t, h, "/", nil); body != "false 0 0" {
+		t.Fatalf("GET / without a cookie = %q", body)
+	}
+
+	body, cs := get(t, h, "/login", nil)
+	if body != fmt.Sprintf("true %d 0", alice.ID) {
+		t.Fatalf("GET /login = %q", body)
+	}
+	if len(cs) != 1 || !cs[0].HttpOnly || cs[0].SameSite != http.SameSiteLaxMode || cs[0].Path != "/" {
+		t.Fatalf("GET /login cookies = %+v", cs)
+	}
+	c := cs[0]
+
+	if body, _ := get(t, h, "/qm?qm=20240300", c); body != fmt.Sprintf("true %d 20240300", alice.ID) {
+		t.Fatalf("GET /qm = %q", body)
+	}
+	if body, _ := get(t, h, "/", c); body != fmt.Sprintf("true %d 20240300", alice.ID) {
+		t.Fatalf("GET / with a cookie = %q", body)
+	}
+
+	// Logging in again replaces the session
+	_, cs = get(t, h, "/login", c)
+	if ss, err := d.GetSessions(alice.ID); err != nil || len(ss) != 1 || ss[0].QM != 20240300 || ss[0].Hash != hash(cs[0].Value) {
+		t.Fatalf("GetSessions after a second login = %+v, %v", ss, err)
+	}
+	if body, _ := get(t, h, "/", c); body != "false 0 0" {
+		t.Fatalf("GET / with the replaced cookie = %q", body)
+	}
+	c = cs[0]
+
+	_, cs = get(t, h, "/logout", c)
+	if len(cs) != 1 || cs[0].MaxAge >= 0 {
+		t.Fatalf("GET /logout cookies = %+v", cs)
+	}
+	if body, _ := get(t, h, "/", c); body != "false 0 0" {
+		t.Fatalf("GET / after logout = %q", body)
+	}
+	if ss, err := d.GetSessions(alice.ID); err != nil || len(ss) != 0 {
+		t.Fatalf("GetSessions after logout = %+v, %v", ss, err)
+	}
+}
+
+// The cookie is HTTPS only when the request came over TLS or the config asks for it, a plain HTTP login has to stick
+func TestSecureCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		secure bool
+		want   bool
+	}{
+		{"plain", "http://budget.example/login", false, false},
+		{"tls", "https://budget.example/login", false, true},
+		{"forced", "http://budget.example/login", true, true},
+	}
+	for _, tt := range tests {
+		c := config
+		c.Secure = tt.secure
+		h, _, _ := newSessionsWith(t, c)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		cs := w.Result().Cookies()
+		if len(cs) != 1 || cs[0].Secure != tt.want {
+			t.Fatalf("%s login cookies = %+v, want Secure %v", tt.name, cs, tt.want)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	h, d, alice := newSessions(t)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		created  time.Time
+		lastSeen time.Time
+		ok       bool
+	}{
+		{"fresh", now.Add(-time.Hour), now.Add(-time.Minute), true},
+		{"idle", now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), false},
+		{"old", now.Add(-25 * time.Hour), now.Add(-time.Minute), false},
+	}
+	for _, tt := range tests {
+		sess := model.Session{UserID: alice.ID, Hash: hash(tt.name), Created: tt.created, LastSeen: tt.lastSeen}
+		if err := d.NewSession(&sess); err != nil {
+			t.Fatalf("NewSession: %s", err)
+		}
+
+		body, cs := get(t, h, "/", &http.Cookie{Name: session.CookieName, Value: tt.name})
+		if got := body != "false 0 0"; got != tt.ok {
+			t.Fatalf("%s session let through = %v, want %v", tt.name, got, tt.ok)
+		}
+		if cleared := len(cs) == 1 && cs[0].MaxAge < 0; cleared == tt.ok {
+			t.Fatalf("%s session cookie cleared = %v", tt.name, cleared)
+		}
+		if _, err := d.GetSessionByHash(hash(tt.name)); (err == nil) != tt.ok {
+			t.Fatalf("%s session kept = %v", tt.name, err == nil)
+		}
+	}
+
+	// Last used a minute ago, which is long enough to move its LastSeen up
+	if sess, err := d.GetSessionByHash(hash("fresh")); err != nil || now.Sub(sess.LastSeen) > time.Minute/2 {
+		t.Fatalf("fresh session LastSeen = %s, %v", sess.LastSeen, err)
+	}
+
+	// Revoking is deleting it from the store
+	sess, _ := d.GetSessionByHash(hash("fresh"))
+	if err := d.DeleteSession(sess.ID); err != nil {
+		t.Fatalf("DeleteSession: %s", err)
+	}
+	if body, _ := get(t, h, "/", &http.Cookie{Name: session.CookieName, Value: "fresh"}); body != "false 0 0" {
+		t.Fatalf("GET / with a revoked session = %q", body)
+	}
+
+	// Without the middleware there is nothing to start
+	if err := session.Start(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), alice.ID); err != session.ErrNoSessions {
+		t.Fatalf("Start without middleware = %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package model
 import (
 	"budgeting/internal/pkg/bcdate"
 	"database/sql"
+	"strings"
+	"time"
 )
 
 // All structure definitions should go here
@@ -30,6 +32,14 @@ type Account struct {
 	Institution string
 	Name        string
 	Class       AccountClass
+
+	// Date the account was closed, only CloseAccount and ReopenAccount change it
+	Closed sql.NullInt32
+}
+
+// Closed in a month before month, so it has nothing to show for it
+func (a Account) ClosedBefore(month bcdate.BCDate) bool {
+	return a.Closed.Valid && bcdate.BCDate(a.Closed.Int32)-bcdate.BCDate(a.Closed.Int32)%100 < month-month%100
 }
 
 type TransactionType uint16
@@ -53,6 +63,250 @@ type AccountTransaction struct {
 	Amount  int
 	Cleared bool
 	Memo    string
+	PayeeID sql.NullInt32
+
+	// Cleared and matched against a statement, amount, date and cleared are locked while set
+	// Only Reconcile and SetReconciled change it, inserts and updates leave it alone
+	Reconciled bool
+
+	// Set by importers to the ID the statement gave the transaction, never changed afterwards
+	ImportID sql.NullString
+
+	// Set when the amount is spread over several envelopes, EnvelopeID is then unset
+	Splits []Split
+
+	// Set on reads of a transfer leg: the transfer, and the account holding its other leg
+	TransferID     sql.NullInt32
+	CounterAccount sql.NullInt32
+}
+
+// One envelope's share of a split AccountTransaction
+// The shares sum to the transaction amount, a share without an envelope is left unassigned
+type Split struct {
+	ID            PKEY
+	TransactionID PKEY
+	EnvelopeID    sql.NullInt32
+
+	Amount int
+	Memo   string
+}
+
+func (at AccountTransaction) IsSplit() bool {
+	return len(at.Splits) > 0
+}
+
+// Every envelope the transaction draws on, each once
+func (at AccountTransaction) EnvelopeIDs() []PKEY {
+	eids := make([]PKEY, 0, 1)
+	if at.EnvelopeID.Valid {
+		eids = append(eids, PKEY(at.EnvelopeID.Int32))
+	}
+	for _, s := range at.Splits {
+		if !s.EnvelopeID.Valid {
+			continue
+		}
+		eid := PKEY(s.EnvelopeID.Int32)
+		seen := false
+		for _, e := range eids {
+			seen = seen || e == eid
+		}
+		if !seen {
+			eids = append(eids, eid)
+		}
+	}
+	return eids
+}
+
+// Money moved from one account to another, stored as a TT_TRANSFER leg in each
+// Amount is positive, the From leg holds -Amount and the To leg Amount
+// Only the From leg carries an envelope, and only when the money leaves the float
+type Transfer struct {
+	ID            PKEY
+	FromID        PKEY
+	ToID          PKEY
+	FromAccountID PKEY
+	ToAccountID   PKEY
+	EnvelopeID    sql.NullInt32
+
+	PostDate bcdate.BCDate
+
+	Amount int
+	Memo   string
+}
+
+// Who a transaction was with
+// New spending from the payee without an envelope of its own goes to EnvelopeID
+type Payee struct {
+	ID         PKEY
+	Name       string
+	EnvelopeID sql.NullInt32
+}
+
+// Payee name from free text like a bank memo
+// Keeps the words before the first one holding a digit, which tends to be a store number or reference
+func PayeeName(memo string) string {
+	words := strings.Fields(memo)
+	for i, w := range words {
+		if i > 0 && strings.ContainsAny(w, "0123456789") {
+			words = words[:i]
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Categorises transactions coming in through the batch path and importers
+// Match fields left unset match anything, action fields left unset keep what the transaction has
+// A Memo action is expanded against the MemoPattern match, so $1 picks up the first group
+type Rule struct {
+	ID       PKEY
+	Priority int
+	Name     string
+
+	MemoPattern string
+	MinAmount   sql.NullInt64
+	MaxAmount   sql.NullInt64
+	AccountID   sql.NullInt32
+	FromDate    sql.NullInt32
+	ToDate      sql.NullInt32
+
+	EnvelopeID sql.NullInt32
+	Typ        sql.NullInt32
+	Cleared    sql.NullBool
+	Memo       sql.NullString
+}
+
+// How to read one bank's CSV export into AccountTransactions
+// Columns are header names, or 1 based positions when all digits, a memo may join several columns separated by commas
+// Amounts come signed from AmountColumn, or split over DebitColumn and CreditColumn
+type CSVProfile struct {
+	ID   PKEY
+	Name string
+
+	Delimiter string
+	SkipRows  int
+	Header    bool
+
+	DateColumn string
+	// Like DD/MM/YYYY, see csvimport
+	DateFormat string
+
+	AmountColumn string
+	DebitColumn  string
+	CreditColumn string
+	// For exports that show spending as positive
+	Negate       bool
+	DecimalComma bool
+
+	MemoColumn string
+	// Rows are cleared when ClearedColumn holds ClearedValue, or always without a ClearedColumn
+	ClearedColumn string
+	ClearedValue  string
+	// The bank's reference for the row, kept as the ImportID so importing again skips it
+	IDColumn string
+}
+
+type ScheduleKind uint16
+
+const (
+	// On day N of every month, the last day in months too short for it
+	SK_MONTHLY ScheduleKind = iota
+	// Every N weeks from Start
+	SK_WEEKLY
+	// On the month and day of Start every year, Feb 29 falls on the 28th outside leap years
+	SK_YEARLY
+	// On the last Monday to Friday of every month
+	SK_LASTBUSINESS
+)
+
+// A transaction entered into an account on a fixed schedule
+// Occurrences fall on or after Start and, when set, on or before End
+// Last is the latest occurrence entered or skipped, Epoch before the first
+type Schedule struct {
+	ID         PKEY
+	AccountID  PKEY
+	EnvelopeID sql.NullInt32
+	PayeeID    sql.NullInt32
+
+	Typ    TransactionType
+	Amount int
+	Memo   string
+
+	Kind  ScheduleKind
+	N     int
+	Start bcdate.BCDate
+	End   sql.NullInt32
+
+	Last bcdate.BCDate
+}
+
+// The first occurrence after d, Never when the schedule ends before one
+func (s Schedule) NextAfter(d bcdate.BCDate) bcdate.BCDate {
+	from := s.Start
+	if d >= s.Start {
+		from = d.AddDays(1)
+	}
+	month := from / 100 * 100
+
+	var next bcdate.BCDate
+	switch s.Kind {
+	case SK_MONTHLY:
+		next = monthDay(month, s.N)
+		if next < from {
+			next = monthDay(month.NextMonth(), s.N)
+		}
+	case SK_WEEKLY:
+		if s.N < 1 {
+			return bcdate.Never()
+		}
+		step := 7 * s.N
+		weeks := (bcdate.DaysBetween(s.Start, from) + step - 1) / step
+		next = s.Start.AddDays(weeks * step)
+	case SK_YEARLY:
+		year := from / 10000 * 10000
+		next = monthDay(year+s.Start%10000/100*100, int(s.Start%100))
+		if next < from {
+			next = monthDay(year+10000+s.Start%10000/100*100, int(s.Start%100))
+		}
+	case SK_LASTBUSINESS:
+		next = lastBusinessDay(month)
+		if next < from {
+			next = lastBusinessDay(month.NextMonth())
+		}
+	default:
+		return bcdate.Never()
+	}
+
+	if s.End.Valid && next > bcdate.BCDate(s.End.Int32) {
+		return bcdate.Never()
+	}
+	return next
+}
+
+// The next occurrence not yet entered or skipped
+func (s Schedule) Next() bcdate.BCDate {
+	return s.NextAfter(s.Last)
+}
+
+// Day n of the month, or its last day when the month is shorter
+func monthDay(month bcdate.BCDate, n int) bcdate.BCDate {
+	last := month.LastDay()
+	if n < 1 || bcdate.BCDate(n) > last%100 {
+		return last
+	}
+	return month + bcdate.BCDate(n)
+}
+
+func lastBusinessDay(month bcdate.BCDate) bcdate.BCDate {
+	d := month.LastDay()
+	for {
+		switch d.Time().Weekday() {
+		case time.Saturday, time.Sunday:
+			d = d.AddDays(-1)
+		default:
+			return d
+		}
+	}
 }
 
 type AccountSummary struct {
@@ -111,6 +365,26 @@ func (e Envelope) GoalWant(bal int) int {
 	return 0
 }
 
+// What is left to assign for the goal this month, given the balance and what was assigned this month already
+func (e Envelope) GoalNeed(bal int, in int) int {
+	need := 0
+	switch e.Goal {
+	case GT_RECUR:
+		need = e.GoalAmt - in
+	case GT_TGT:
+		need = e.GoalTgt - bal
+	case GT_RECTIL:
+		need = e.GoalAmt - in
+		if e.GoalTgt-bal < need {
+			need = e.GoalTgt - bal
+		}
+	}
+	if need < 0 {
+		return 0
+	}
+	return need
+}
+
 type EnvelopeTransaction struct {
 	ID         PKEY
 	EnvelopeID PKEY
@@ -143,3 +417,35 @@ func (s Summary) Gain() int {
 func (s Summary) Missing() int {
 	return s.Delta - s.Income - s.Expenses
 }
+
+// Someone let in by the auth middleware, Hash is the bcrypt hash of their password
+type User struct {
+	ID   PKEY
+	Name string
+	Hash string
+}
+
+// Lets a script in as UserID, only the SHA-256 of the token itself is kept
+type Token struct {
+	ID     PKEY
+	UserID PKEY
+	// What the token is for, so it can be found again to revoke it
+	Name string
+	Hash string
+}
+
+// A logged in browser, only the SHA-256 of its cookie is kept
+type Session struct {
+	ID     PKEY
+	UserID PKEY
+	Hash   string
+
+	Created  time.Time
+	LastSeen time.Time
+	// Last query month the browser asked for, 0 before it asked for one
+	QM int
+
+	// User agent and address it logged in from, to tell sessions apart when revoking one
+	Agent string
+	Addr  string
+}
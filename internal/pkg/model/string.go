@@ -40,6 +40,9 @@ func (a Account) String() string {
 	}
 	ret += ":"
 	ret += a.Class.String()
+	if a.Closed.Valid {
+		ret += fmt.Sprintf(" closed %d", a.Closed.Int32)
+	}
 	return ret
 }
 
@@ -97,6 +100,19 @@ func (e Envelope) String() string {
 	return ret
 }
 
+// The goal in words, empty without one
+func (e Envelope) GoalDesc() string {
+	switch e.Goal {
+	case GT_RECUR:
+		return fmt.Sprintf("%s every month", FormatVal(e.GoalAmt))
+	case GT_TGT:
+		return fmt.Sprintf("Save up %s", FormatVal(e.GoalTgt))
+	case GT_RECTIL:
+		return fmt.Sprintf("%s every month until %s", FormatVal(e.GoalAmt), FormatVal(e.GoalTgt))
+	}
+	return ""
+}
+
 func (s EnvelopeSummary) String() string {
 	return fmt.Sprintf("%03d -- %08d -- %05d --  ->%05d  <-%05d", s.EnvelopeID, s.Month, s.Bal, s.In, s.Out)
 }
@@ -110,3 +126,69 @@ func FormatVal(v int) string {
 		return fmt.Sprintf("$\u00A0%.2f", float32(v)/100.0)
 	}
 }
+
+func (r Rule) String() string {
+	ret := fmt.Sprintf("%03d: %4d %20s -- ", r.ID, r.Priority, r.Name)
+	if r.MemoPattern != "" {
+		ret += fmt.Sprintf(" memo~%q", r.MemoPattern)
+	}
+	if r.MinAmount.Valid {
+		ret += fmt.Sprintf(" amount>=%d", r.MinAmount.Int64)
+	}
+	if r.MaxAmount.Valid {
+		ret += fmt.Sprintf(" amount<=%d", r.MaxAmount.Int64)
+	}
+	if r.AccountID.Valid {
+		ret += fmt.Sprintf(" account=%03d", r.AccountID.Int32)
+	}
+	if r.FromDate.Valid {
+		ret += fmt.Sprintf(" date>=%08d", r.FromDate.Int32)
+	}
+	if r.ToDate.Valid {
+		ret += fmt.Sprintf(" date<=%08d", r.ToDate.Int32)
+	}
+	ret += " ->"
+	if r.EnvelopeID.Valid {
+		ret += fmt.Sprintf(" envelope=%03d", r.EnvelopeID.Int32)
+	}
+	if r.Typ.Valid {
+		ret += fmt.Sprintf(" type=%d", r.Typ.Int32)
+	}
+	if r.Cleared.Valid {
+		ret += fmt.Sprintf(" cleared=%t", r.Cleared.Bool)
+	}
+	if r.Memo.Valid {
+		ret += fmt.Sprintf(" memo=%q", r.Memo.String)
+	}
+	return ret
+}
+
+// How often the schedule comes round, for people
+func (s Schedule) Recurrence() string {
+	switch s.Kind {
+	case SK_MONTHLY:
+		return fmt.Sprintf("Monthly on day %d", s.N)
+	case SK_WEEKLY:
+		if s.N == 1 {
+			return "Weekly"
+		}
+		return fmt.Sprintf("Every %d weeks", s.N)
+	case SK_YEARLY:
+		return "Yearly on " + s.Start.FmtDate()[5:]
+	case SK_LASTBUSINESS:
+		return "Last business day"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func (s Schedule) String() string {
+	ret := fmt.Sprintf("%03d: a %03d %08d %d %q -- %s from %08d", s.ID, s.AccountID, s.Next(), s.Amount, s.Memo, s.Recurrence(), s.Start)
+	if s.End.Valid {
+		ret += fmt.Sprintf(" to %08d", s.End.Int32)
+	}
+	if s.EnvelopeID.Valid {
+		ret += fmt.Sprintf(" -> envelope=%03d", s.EnvelopeID.Int32)
+	}
+	return ret
+}
@@ -0,0 +1,28 @@
+package ofx
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+)
+
+// Insert the statement's new transactions into the account, see importer.Import
+// FITIDs are the import IDs, so a transaction the account already has is skipped
+func Import(sdb db.DB, account model.PKEY, st Statement, opts importer.Options) (importer.Result, error) {
+	rows := make([]importer.Row, 0, len(st.Transactions))
+	for _, t := range st.Transactions {
+		rows = append(rows, importer.Row{AccountTransaction: t.AccountTransaction(account), Payee: t.Name})
+	}
+
+	res, err := importer.Import(sdb, rows, opts)
+	if err != nil {
+		return res, fmt.Errorf("Import.importer -- %w", err)
+	}
+	return res, nil
+}
+
+func sqlString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,356 @@
+package ofx
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/model"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Reader for OFX bank and credit card statements, QFX is OFX with a few Quicken extras that are ignored
+// OFX 1.x is SGML where leaf elements have no closing tag, 2.x is XML, the same tag scanner reads both
+
+var ErrInvalid = errors.New("invalid OFX")
+
+// One account's statement
+type Statement struct {
+	// The account as the bank names it, ACCTID
+	AccountID string
+	Currency  string
+
+	Start bcdate.BCDate
+	End   bcdate.BCDate
+
+	// Ledger balance as of BalanceDate
+	Balance     int
+	BalanceDate bcdate.BCDate
+
+	Transactions []Transaction
+}
+
+// One STMTTRN, amounts in cents
+type Transaction struct {
+	// Unique per account at the bank, the key for skipping what was already imported
+	FITID string
+	// TRNTYPE, like DEBIT, CREDIT, CHECK or XFER
+	Type string
+
+	Posted bcdate.BCDate
+	Amount int
+
+	Name     string
+	Memo     string
+	CheckNum string
+}
+
+// The transaction as a cleared row for the given account
+// Money in is income and money out spending, rules can say otherwise
+func (t Transaction) AccountTransaction(account model.PKEY) model.AccountTransaction {
+	at := model.AccountTransaction{
+		AccountID: account,
+		Typ:       model.TT_NORM,
+		PostDate:  t.Posted,
+		Amount:    t.Amount,
+		Cleared:   true,
+		Memo:      t.Name,
+		ImportID:  sqlString(t.FITID),
+	}
+	if t.Amount > 0 {
+		at.Typ = model.TT_INCOME
+	}
+	if t.Memo != "" && t.Memo != t.Name {
+		at.Memo = strings.TrimSpace(t.Name + " " + t.Memo)
+	}
+	return at
+}
+
+// Read every bank and credit card statement in the file
+func Parse(r io.Reader) ([]Statement, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Parse.ReadAll -- %w", err)
+	}
+
+	root, err := parseTree(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Parse.parseTree -- %w", err)
+	}
+
+	sts := make([]Statement, 0)
+	for _, agg := range []struct{ stmt, from string }{{"STMTRS", "BANKACCTFROM"}, {"CCSTMTRS", "CCACCTFROM"}} {
+		for _, n := range root.find(agg.stmt) {
+			st, err := parseStatement(n, agg.from)
+			if err != nil {
+				return nil, fmt.Errorf("Parse.%s -- %w", agg.stmt, err)
+			}
+			sts = append(sts, st)
+		}
+	}
+	if len(sts) == 0 {
+		return nil, fmt.Errorf("Parse -- %w: no statements", ErrInvalid)
+	}
+	return sts, nil
+}
+
+func parseStatement(n *node, from string) (Statement, error) {
+	st := Statement{
+		AccountID:    n.text(from, "ACCTID"),
+		Currency:     n.text("CURDEF"),
+		Transactions: make([]Transaction, 0),
+	}
+
+	var err error
+	if st.Start, err = optionalDate(n.text("BANKTRANLIST", "DTSTART")); err != nil {
+		return st, fmt.Errorf("DTSTART -- %w", err)
+	}
+	if st.End, err = optionalDate(n.text("BANKTRANLIST", "DTEND")); err != nil {
+		return st, fmt.Errorf("DTEND -- %w", err)
+	}
+	if bal := n.child("LEDGERBAL"); bal != nil {
+		if st.Balance, err = parseAmount(bal.text("BALAMT")); err != nil {
+			return st, fmt.Errorf("BALAMT -- %w", err)
+		}
+		if st.BalanceDate, err = optionalDate(bal.text("DTASOF")); err != nil {
+			return st, fmt.Errorf("DTASOF -- %w", err)
+		}
+	}
+
+	list := n.child("BANKTRANLIST")
+	if list == nil {
+		return st, nil
+	}
+	for _, tn := range list.all("STMTTRN") {
+		t, err := parseTransaction(tn)
+		if err != nil {
+			return st, fmt.Errorf("STMTTRN %d -- %w", len(st.Transactions)+1, err)
+		}
+		st.Transactions = append(st.Transactions, t)
+	}
+	return st, nil
+}
+
+func parseTransaction(n *node) (Transaction, error) {
+	t := Transaction{
+		FITID:    n.text("FITID"),
+		Type:     n.text("TRNTYPE"),
+		Name:     n.text("NAME"),
+		Memo:     n.text("MEMO"),
+		CheckNum: n.text("CHECKNUM"),
+	}
+	if t.FITID == "" {
+		return t, fmt.Errorf("%w: no FITID", ErrInvalid)
+	}
+	// Some banks put the name in a PAYEE aggregate instead
+	if t.Name == "" {
+		t.Name = n.text("PAYEE", "NAME")
+	}
+
+	var err error
+	if t.Posted, err = parseDate(n.text("DTPOSTED")); err != nil {
+		return t, fmt.Errorf("DTPOSTED -- %w", err)
+	}
+	if t.Amount, err = parseAmount(n.text("TRNAMT")); err != nil {
+		return t, fmt.Errorf("TRNAMT -- %w", err)
+	}
+	return t, nil
+}
+
+// OFX dates are YYYYMMDD with an optional time and zone after, only the day is kept
+func parseDate(s string) (bcdate.BCDate, error) {
+	if len(s) < 8 {
+		return 0, fmt.Errorf("%w: date %q", ErrInvalid, s)
+	}
+	d, err := strconv.Atoi(s[:8])
+	if err != nil {
+		return 0, fmt.Errorf("%w: date %q", ErrInvalid, s)
+	}
+	day, mon := d%100, (d/100)%100
+	if day < 1 || day > 31 || mon < 1 || mon > 12 {
+		return 0, fmt.Errorf("%w: date %q", ErrInvalid, s)
+	}
+	return bcdate.BCDate(d), nil
+}
+
+func optionalDate(s string) (bcdate.BCDate, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return parseDate(s)
+}
+
+// Decimal amount to cents, a comma is taken as the decimal point when there is no period
+func parseAmount(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg, s = true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	// Fractions of a cent are only allowed when they are zero
+	if len(frac) > 2 {
+		if strings.Trim(frac[2:], "0") != "" {
+			return 0, fmt.Errorf("%w: amount %q has fractions of a cent", ErrInvalid, s)
+		}
+		frac = frac[:2]
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	w, err := strconv.Atoi(whole)
+	if err != nil || w < 0 {
+		return 0, fmt.Errorf("%w: amount %q", ErrInvalid, s)
+	}
+	f, err := strconv.Atoi(frac)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%w: amount %q", ErrInvalid, s)
+	}
+
+	cents := w*100 + f
+	if neg {
+		cents = -cents
+	}
+	return cents, nil
+}
+
+// Element tree
+
+type node struct {
+	name     string
+	value    string
+	leaf     bool
+	children []*node
+}
+
+// First direct child with the name
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Every direct child with the name
+func (n *node) all(name string) []*node {
+	ret := make([]*node, 0)
+	for _, c := range n.children {
+		if c.name == name {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// Every element below n with the name, in document order
+func (n *node) find(name string) []*node {
+	ret := make([]*node, 0)
+	for _, c := range n.children {
+		if c.name == name {
+			ret = append(ret, c)
+		}
+		ret = append(ret, c.find(name)...)
+	}
+	return ret
+}
+
+// Value of the leaf at the path of child names, empty when any step is missing
+func (n *node) text(path ...string) string {
+	for _, name := range path {
+		if n = n.child(name); n == nil {
+			return ""
+		}
+	}
+	return n.value
+}
+
+// Build the element tree from <OFX> on, the headers before it differ between versions and say nothing needed here
+// An open tag followed by text is a leaf, its closing tag is optional, anything else opens an aggregate
+func parseTree(data string) (*node, error) {
+	start := strings.Index(data, "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("%w: no <OFX> element", ErrInvalid)
+	}
+
+	root := &node{name: "root"}
+	stack := []*node{root}
+
+	for i := start; i < len(data); {
+		lt := strings.IndexByte(data[i:], '<')
+		if lt < 0 {
+			break
+		}
+		lt += i
+		gt := strings.IndexByte(data[lt:], '>')
+		if gt < 0 {
+			return nil, fmt.Errorf("%w: unterminated tag at %d", ErrInvalid, lt)
+		}
+		gt += lt
+		tag := strings.TrimSpace(data[lt+1 : gt])
+
+		next := strings.IndexByte(data[gt+1:], '<')
+		if next < 0 {
+			next = len(data)
+		} else {
+			next += gt + 1
+		}
+		text := strings.TrimSpace(data[gt+1 : next])
+		i = next
+
+		top := stack[len(stack)-1]
+		switch {
+		case strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!"):
+			// Processing instructions and comments
+
+		case strings.HasPrefix(tag, "/"):
+			name := tag[1:]
+			if last := lastChild(top); last != nil && last.leaf && last.name == name {
+				continue
+			}
+			j := len(stack) - 1
+			for j > 0 && stack[j].name != name {
+				j--
+			}
+			if j == 0 {
+				return nil, fmt.Errorf("%w: </%s> closes nothing", ErrInvalid, name)
+			}
+			stack = stack[:j]
+
+		case strings.HasSuffix(tag, "/"):
+			// Empty XML element
+			top.children = append(top.children, &node{name: strings.TrimSpace(tag[:len(tag)-1]), leaf: true})
+
+		default:
+			n := &node{name: tag}
+			top.children = append(top.children, n)
+			if text != "" {
+				n.value, n.leaf = html.UnescapeString(text), true
+			} else {
+				stack = append(stack, n)
+			}
+		}
+	}
+
+	return root, nil
+}
+
+func lastChild(n *node) *node {
+	if len(n.children) == 0 {
+		return nil
+	}
+	return n.children[len(n.children)-1]
+}
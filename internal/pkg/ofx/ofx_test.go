@@ -0,0 +1,180 @@
+package ofx_test
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/ofx"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseFile(t *testing.T, name string) []ofx.Statement {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+
+	sts, err := ofx.Parse(f)
+	if err != nil {
+		t.Fatalf("Parse %s: %s", name, err)
+	}
+	return sts
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file    string
+		acct    string
+		cur     string
+		start   bcdate.BCDate
+		balance int
+		want    []ofx.Transaction
+	}{
+		{"checking_v1.ofx", "000123456", "USD", 20240201, 125433, []ofx.Transaction{
+			{FITID: "202402010001", Type: "CREDIT", Posted: 20240201, Amount: 250000, Name: "ACME CORP PAYROLL", Memo: "Direct deposit"},
+			{FITID: "202402030002", Type: "DEBIT", Posted: 20240203, Amount: -4567, Name: "CORNER GROCER 0042", Memo: "CORNER GROCER 0042"},
+			{FITID: "202402100003", Type: "CHECK", Posted: 20240210, Amount: -120000, Name: "Smith & Sons Rent", CheckNum: "1001"},
+		}},
+		{"checking_v2.ofx", "000123456", "USD", 20240210, 374183, []ofx.Transaction{
+			{FITID: "202402100003", Type: "CHECK", Posted: 20240210, Amount: -120000, Name: "Smith & Sons Rent", CheckNum: "1001"},
+			{FITID: "202403020004", Type: "DEBIT", Posted: 20240302, Amount: -1250, Name: "City Parking"},
+			{FITID: "202403010005", Type: "CREDIT", Posted: 20240301, Amount: 250000, Name: "ACME CORP PAYROLL", Memo: "Direct deposit"},
+		}},
+		{"card.qfx", "4111XXXXXXXX1111", "EUR", 20240201, -41010, []ofx.Transaction{
+			{FITID: "CC-1", Type: "DEBIT", Posted: 20240214, Amount: -8990, Name: "BISTRO LUNA"},
+			{FITID: "CC-2", Type: "PAYMENT", Posted: 20240220, Amount: 50000, Name: "PAYMENT THANK YOU"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			sts := parseFile(t, tt.file)
+			if len(sts) != 1 {
+				t.Fatalf("Parse found %d statements, want 1", len(sts))
+			}
+			st := sts[0]
+			if st.AccountID != tt.acct || st.Currency != tt.cur || st.Start != tt.start || st.Balance != tt.balance {
+				t.Fatalf("Statement = %s %s from %d balance %d", st.AccountID, st.Currency, st.Start, st.Balance)
+			}
+			if len(st.Transactions) != len(tt.want) {
+				t.Fatalf("Parse found %d transactions, want %d", len(st.Transactions), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if st.Transactions[i] != want {
+					t.Fatalf("Transaction %d = %+v, want %+v", i, st.Transactions[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"no OFX":        "OFXHEADER:100\n\nnothing here",
+		"no statements": "<OFX><SIGNONMSGSRSV1></SIGNONMSGSRSV1></OFX>",
+		"no FITID":      "<OFX><STMTRS><BANKTRANLIST><STMTTRN><DTPOSTED>20240101<TRNAMT>1</STMTTRN></BANKTRANLIST></STMTRS></OFX>",
+		"bad amount":    "<OFX><STMTRS><BANKTRANLIST><STMTTRN><FITID>1<DTPOSTED>20240101<TRNAMT>1.005</STMTTRN></BANKTRANLIST></STMTRS></OFX>",
+		"bad date":      "<OFX><STMTRS><BANKTRANLIST><STMTTRN><FITID>1<DTPOSTED>20241301<TRNAMT>1</STMTTRN></BANKTRANLIST></STMTRS></OFX>",
+		"stray close":   "<OFX><STMTRS></BANKTRANLIST></STMTRS></OFX>",
+	} {
+		if _, err := ofx.Parse(strings.NewReader(data)); !errors.Is(err, ofx.ErrInvalid) {
+			t.Errorf("Parse %s = %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	sdb := db.NewSQLite()
+	if err := sdb.Open(filepath.Join(t.TempDir(), "ofx.db")); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err := sdb.Init(); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+
+	chk := model.Account{Institution: "Bank", Name: "Checking"}
+	if err := sdb.NewAccount(&chk); err != nil {
+		t.Fatalf("NewAccount: %s", err)
+	}
+	rent := model.Envelope{GroupID: 1, Name: "Rent"}
+	if err := sdb.NewEnvelope(&rent); err != nil {
+		t.Fatalf("NewEnvelope: %s", err)
+	}
+	grocer := model.Payee{Name: "CORNER GROCER"}
+	if err := sdb.NewPayee(&grocer); err != nil {
+		t.Fatalf("NewPayee: %s", err)
+	}
+	rule := model.Rule{Name: "Rent", MemoPattern: "Rent$", EnvelopeID: sql.NullInt32{Int32: int32(rent.ID), Valid: true}}
+	if err := sdb.NewRule(&rule); err != nil {
+		t.Fatalf("NewRule: %s", err)
+	}
+
+	v1 := parseFile(t, "checking_v1.ofx")[0]
+
+	// A dry run reports what would happen and writes nothing
+	res, err := ofx.Import(sdb, chk.ID, v1, importer.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Import dry run: %s", err)
+	}
+	if len(res.New) != 3 || len(res.Skipped) != 0 || len(res.Matches) != 1 || res.Matches[0].Index != 2 {
+		t.Fatalf("Import dry run = %d new, %d skipped, matches %v", len(res.New), len(res.Skipped), res.Matches)
+	}
+	if ats, err := sdb.GetAllAccountTransactions(chk.ID); err != nil || len(ats) != 0 {
+		t.Fatalf("Dry run wrote %d transactions, %v", len(ats), err)
+	}
+
+	if res, err = ofx.Import(sdb, chk.ID, v1, importer.Options{}); err != nil || len(res.New) != 3 {
+		t.Fatalf("Import v1 = %d new, %v", len(res.New), err)
+	}
+
+	// The second statement overlaps the first by the rent check
+	v2 := parseFile(t, "checking_v2.ofx")[0]
+	res, err = ofx.Import(sdb, chk.ID, v2, importer.Options{})
+	if err != nil {
+		t.Fatalf("Import v2: %s", err)
+	}
+	if len(res.New) != 2 || len(res.Skipped) != 1 || res.Skipped[0].ImportID.String != "202402100003" {
+		t.Fatalf("Import v2 = %d new, skipped %+v", len(res.New), res.Skipped)
+	}
+	if res, err = ofx.Import(sdb, chk.ID, v2, importer.Options{}); err != nil || len(res.New) != 0 || len(res.Skipped) != 3 {
+		t.Fatalf("Import v2 again = %d new, %d skipped, %v", len(res.New), len(res.Skipped), err)
+	}
+
+	ats, err := sdb.GetAllAccountTransactions(chk.ID)
+	if err != nil || len(ats) != 5 {
+		t.Fatalf("GetAllAccountTransactions = %d, %v", len(ats), err)
+	}
+	byID := make(map[string]model.AccountTransaction)
+	for _, at := range ats {
+		byID[at.ImportID.String] = at
+	}
+	if at := byID["202402010001"]; at.Typ != model.TT_INCOME || !at.Cleared || at.Memo != "ACME CORP PAYROLL Direct deposit" {
+		t.Fatalf("Payroll = %+v", at)
+	}
+	if at := byID["202402030002"]; at.Typ != model.TT_NORM || at.PayeeID.Int32 != int32(grocer.ID) || at.Memo != "CORNER GROCER 0042" {
+		t.Fatalf("Grocer = %+v", at)
+	}
+	if at := byID["202402100003"]; at.EnvelopeID.Int32 != int32(rent.ID) {
+		t.Fatalf("Rent = %+v", at)
+	}
+
+	// Statement balances and the checkpoints agree
+	s, err := sdb.GetAccountSummary(20240300, chk.ID)
+	if err != nil {
+		t.Fatalf("GetAccountSummary: %s", err)
+	}
+	if s.Bal != v2.Balance {
+		t.Fatalf("March balance = %d, statement says %d", s.Bal, v2.Balance)
+	}
+	if vs, err := sdb.Check(); err != nil || len(vs) != 0 {
+		t.Fatalf("Check = %v, %v", vs, err)
+	}
+}
@@ -0,0 +1,92 @@
+package qif
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// The account's whole history as a QIF list, oldest first
+// Envelopes become categories of the same name, transfer legs name the other account
+// Payees go on the P line and memos on the M line, so Import puts both back where they were
+func Export(sdb db.DB, account model.PKEY) (Account, error) {
+	a, err := sdb.GetAccount(account)
+	if err != nil {
+		return Account{}, fmt.Errorf("Export.GetAccount -- %w", err)
+	}
+	qa := Account{Name: a.Name, Type: "Bank", Transactions: make([]Transaction, 0)}
+	if a.Debt {
+		qa.Type = "CCard"
+	}
+
+	envelopes := make(map[model.PKEY]string)
+	es, err := sdb.GetEnvelopes()
+	if err != nil {
+		return qa, fmt.Errorf("Export.GetEnvelopes -- %w", err)
+	}
+	for _, e := range es {
+		envelopes[e.ID] = e.Name
+	}
+	category := func(id sql.NullInt32) string {
+		if !id.Valid {
+			return ""
+		}
+		return envelopes[model.PKEY(id.Int32)]
+	}
+
+	payees := make(map[model.PKEY]string)
+	ps, err := sdb.GetPayees()
+	if err != nil {
+		return qa, fmt.Errorf("Export.GetPayees -- %w", err)
+	}
+	for _, p := range ps {
+		payees[p.ID] = p.Name
+	}
+
+	accounts := make(map[model.PKEY]string)
+	as, err := sdb.GetAccounts()
+	if err != nil {
+		return qa, fmt.Errorf("Export.GetAccounts -- %w", err)
+	}
+	for _, ac := range as {
+		accounts[ac.ID] = ac.Name
+	}
+
+	ats, err := sdb.GetAllAccountTransactions(account)
+	if err != nil {
+		return qa, fmt.Errorf("Export.GetAllAccountTransactions -- %w", err)
+	}
+	sort.SliceStable(ats, func(i, j int) bool {
+		if ats[i].PostDate != ats[j].PostDate {
+			return ats[i].PostDate < ats[j].PostDate
+		}
+		return ats[i].ID < ats[j].ID
+	})
+
+	for _, at := range ats {
+		t := Transaction{Date: at.PostDate, Amount: at.Amount, Memo: at.Memo}
+		if at.Reconciled {
+			t.Status = "X"
+		} else if at.Cleared {
+			t.Status = "*"
+		}
+		if at.PayeeID.Valid {
+			t.Payee = payees[model.PKEY(at.PayeeID.Int32)]
+		}
+
+		switch {
+		case at.CounterAccount.Valid:
+			t.Transfer = accounts[model.PKEY(at.CounterAccount.Int32)]
+		case at.IsSplit():
+			for _, s := range at.Splits {
+				t.Splits = append(t.Splits, Split{Category: category(s.EnvelopeID), Memo: s.Memo, Amount: s.Amount})
+			}
+		default:
+			t.Category = category(at.EnvelopeID)
+		}
+		qa.Transactions = append(qa.Transactions, t)
+	}
+	return qa, nil
+}
@@ -0,0 +1,182 @@
+package qif
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// What Import did, or would do on a dry run
+type Result struct {
+	importer.Result
+	// Transfers made, on a dry run the ones that would be made with no IDs
+	Transfers []model.Transfer
+	// Transfers the account already holds, like when the other account's QIF went in first
+	SkippedTransfers []model.Transfer
+	// Categories naming no envelope, their money is left unassigned
+	Unknown []string
+}
+
+// Insert the list's transactions into the account
+// Categories pick the envelope of the same name, Group:Envelope categories also match on the envelope part
+// [Account] lines and split lines become transfers to the account of that name, the rest of a split stays on the transaction
+// QIF has no transaction IDs, rows are checked for duplicates and transfers against the legs the account already holds
+// Rows go in one batch through importer.Import, transfers are made one at a time afterwards
+func Import(sdb db.DB, account model.PKEY, a Account, opts importer.Options) (Result, error) {
+	res := Result{Transfers: make([]model.Transfer, 0), SkippedTransfers: make([]model.Transfer, 0), Unknown: make([]string, 0)}
+
+	es, err := sdb.GetEnvelopes()
+	if err != nil {
+		return res, fmt.Errorf("Import.GetEnvelopes -- %w", err)
+	}
+	envelopes := make(map[string]model.PKEY, len(es))
+	for _, e := range es {
+		envelopes[strings.ToLower(e.Name)] = e.ID
+	}
+	unknown := make(map[string]bool)
+	envelope := func(category string) sql.NullInt32 {
+		if category == "" {
+			return sql.NullInt32{}
+		}
+		id, ok := envelopes[strings.ToLower(category)]
+		if i := strings.LastIndex(category, ":"); !ok && i >= 0 {
+			id, ok = envelopes[strings.ToLower(category[i+1:])]
+		}
+		if !ok {
+			if !unknown[category] {
+				unknown[category] = true
+				res.Unknown = append(res.Unknown, category)
+			}
+			return sql.NullInt32{}
+		}
+		return sql.NullInt32{Int32: int32(id), Valid: true}
+	}
+
+	as, err := sdb.GetAccounts()
+	if err != nil {
+		return res, fmt.Errorf("Import.GetAccounts -- %w", err)
+	}
+	accounts := make(map[string]model.PKEY, len(as))
+	for _, ac := range as {
+		accounts[strings.ToLower(ac.Name)] = ac.ID
+	}
+
+	// Transfer legs already in the account, by date, amount and the other account
+	type legKey struct {
+		date    bcdate.BCDate
+		amount  int
+		counter model.PKEY
+	}
+	ats, err := sdb.GetAllAccountTransactions(account)
+	if err != nil {
+		return res, fmt.Errorf("Import.GetAllAccountTransactions -- %w", err)
+	}
+	legs := make(map[legKey]int)
+	for _, at := range ats {
+		if at.CounterAccount.Valid {
+			legs[legKey{at.PostDate, at.Amount, model.PKEY(at.CounterAccount.Int32)}]++
+		}
+	}
+
+	transfer := func(t Transaction, name string, amount int) error {
+		other, ok := accounts[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("%w: no account named %q to transfer to", ErrInvalid, name)
+		}
+		if other == account {
+			return fmt.Errorf("%w: transfer from %q to itself", ErrInvalid, name)
+		}
+
+		x := model.Transfer{FromAccountID: account, ToAccountID: other, PostDate: t.Date, Amount: -amount, Memo: memo(t)}
+		if amount > 0 {
+			x.FromAccountID, x.ToAccountID, x.Amount = other, account, amount
+		}
+
+		if k := (legKey{t.Date, amount, other}); legs[k] > 0 {
+			legs[k]--
+			res.SkippedTransfers = append(res.SkippedTransfers, x)
+			return nil
+		}
+		res.Transfers = append(res.Transfers, x)
+		return nil
+	}
+
+	rows := make([]importer.Row, 0, len(a.Transactions))
+	// Position in the list of each row, transfers have no row
+	index := make([]int, 0, len(a.Transactions))
+	for i, t := range a.Transactions {
+		at := model.AccountTransaction{
+			AccountID: account,
+			Typ:       model.TT_NORM,
+			PostDate:  t.Date,
+			Amount:    t.Amount,
+			Cleared:   t.Cleared(),
+			Memo:      memo(t),
+		}
+
+		switch {
+		case len(t.Splits) > 0:
+			for _, s := range t.Splits {
+				if s.Transfer != "" {
+					if err := transfer(t, s.Transfer, s.Amount); err != nil {
+						return res, fmt.Errorf("Import.transfer -- %w", err)
+					}
+					at.Amount -= s.Amount
+					continue
+				}
+				at.Splits = append(at.Splits, model.Split{EnvelopeID: envelope(s.Category), Amount: s.Amount, Memo: s.Memo})
+			}
+			switch len(at.Splits) {
+			case 0:
+				// All of it was transfers
+				continue
+			case 1:
+				at.EnvelopeID, at.Splits = at.Splits[0].EnvelopeID, nil
+			}
+		case t.Transfer != "":
+			if err := transfer(t, t.Transfer, t.Amount); err != nil {
+				return res, fmt.Errorf("Import.transfer -- %w", err)
+			}
+			continue
+		default:
+			at.EnvelopeID = envelope(t.Category)
+		}
+
+		if at.Amount > 0 && !at.EnvelopeID.Valid && !at.IsSplit() {
+			at.Typ = model.TT_INCOME
+		}
+		rows = append(rows, importer.Row{AccountTransaction: at, Payee: t.Payee})
+		index = append(index, i)
+	}
+
+	ir, err := importer.Import(sdb, rows, opts)
+	res.Result = ir
+	if err != nil {
+		return res, fmt.Errorf("Import.importer -- %w", err)
+	}
+	for i := range res.Duplicates {
+		res.Duplicates[i].Index = index[res.Duplicates[i].Index]
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+
+	for i := range res.Transfers {
+		if err := sdb.NewTransfer(&res.Transfers[i]); err != nil {
+			return res, fmt.Errorf("Import.NewTransfer -- %w", err)
+		}
+	}
+	return res, nil
+}
+
+// The memo, or the payee for transactions without one
+func memo(t Transaction) string {
+	if t.Memo != "" {
+		return t.Memo
+	}
+	return t.Payee
+}
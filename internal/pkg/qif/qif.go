@@ -0,0 +1,346 @@
+package qif
+
+import (
+	"budgeting/internal/pkg/bcdate"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quicken Interchange Format, as read and written by older personal finance tools
+// Parse and Write only know QIF, Import and Export map it onto the ledger
+
+var ErrInvalid = errors.New("invalid QIF")
+
+// One account's transaction list
+type Account struct {
+	// From the !Account block before the list, empty in single account files
+	Name string
+	// The !Type header: Bank, Cash, CCard, Oth A or Oth L
+	Type         string
+	Transactions []Transaction
+}
+
+type Transaction struct {
+	Date   bcdate.BCDate
+	Amount int
+	// The C line: empty, * or c when cleared, X or R when reconciled
+	Status string
+	Number string
+	Payee  string
+	Memo   string
+
+	// The L line is either a category, or a transfer to the account in [brackets]
+	Category string
+	Transfer string
+
+	// S, E and $ lines, when set L only repeats the first split
+	Splits []Split
+}
+
+type Split struct {
+	Category string
+	Transfer string
+	Memo     string
+	Amount   int
+}
+
+func (t Transaction) Cleared() bool {
+	return t.Status != ""
+}
+
+// Account types holding plain transactions, investment lists are not read
+var listTypes = []string{"Bank", "Cash", "CCard", "Oth A", "Oth L"}
+
+// Read every transaction list in the file
+// Lists of categories, classes and memorized transactions are skipped
+func Parse(r io.Reader) ([]Account, error) {
+	accts := make([]Account, 0, 1)
+
+	var (
+		acct    *Account
+		t       *Transaction
+		pending string // Name from the last !Account block
+		inAcct  bool   // Inside an !Account block
+		skip    bool   // Inside a list we do not read
+	)
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimRight(sc.Text(), "\r")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		if text[0] == '!' {
+			if t != nil {
+				return nil, fmt.Errorf("%w: line %d: %s before the transaction ended with ^", ErrInvalid, line, text)
+			}
+			header := strings.TrimSpace(text[1:])
+			inAcct, skip = false, false
+			switch {
+			case strings.EqualFold(header, "Account"):
+				inAcct, pending = true, ""
+			case strings.HasPrefix(strings.ToLower(header), "type:"):
+				typ, ok := listType(strings.TrimSpace(header[len("type:"):]))
+				switch {
+				case ok:
+					accts = append(accts, Account{Name: pending, Type: typ, Transactions: make([]Transaction, 0)})
+					acct, pending = &accts[len(accts)-1], ""
+				case strings.HasPrefix(strings.ToLower(header), "type:invst"):
+					return nil, fmt.Errorf("%w: line %d: investment accounts are not supported", ErrInvalid, line)
+				default:
+					acct, skip = nil, true
+				}
+			case strings.HasPrefix(strings.ToLower(header), "option:"), strings.HasPrefix(strings.ToLower(header), "clear:"):
+			default:
+				return nil, fmt.Errorf("%w: line %d: unknown header %s", ErrInvalid, line, text)
+			}
+			continue
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+		switch {
+		case skip:
+			continue
+		case inAcct:
+			// Only the name matters, the type comes with the list
+			if code == 'N' {
+				pending = value
+			}
+			continue
+		case acct == nil:
+			return nil, fmt.Errorf("%w: line %d: %s outside a transaction list", ErrInvalid, line, text)
+		}
+
+		if code == '^' {
+			if t == nil {
+				continue
+			}
+			if t.Date == 0 {
+				return nil, fmt.Errorf("%w: line %d: transaction without a date", ErrInvalid, line)
+			}
+			acct.Transactions = append(acct.Transactions, *t)
+			t = nil
+			continue
+		}
+		if t == nil {
+			t = &Transaction{}
+		}
+
+		var err error
+		switch code {
+		case 'D':
+			t.Date, err = parseDate(value)
+		case 'T':
+			t.Amount, err = parseAmount(value)
+		case 'U':
+			// Repeats T with more digits in some exports
+		case 'C':
+			t.Status = value
+		case 'N':
+			t.Number = value
+		case 'P':
+			t.Payee = value
+		case 'M':
+			t.Memo = value
+		case 'L':
+			t.Category, t.Transfer = splitCategory(value)
+		case 'S':
+			cat, xfer := splitCategory(value)
+			t.Splits = append(t.Splits, Split{Category: cat, Transfer: xfer})
+		case 'E', '$':
+			if len(t.Splits) == 0 {
+				return nil, fmt.Errorf("%w: line %d: %s before any S line", ErrInvalid, line, text)
+			}
+			s := &t.Splits[len(t.Splits)-1]
+			if code == 'E' {
+				s.Memo = value
+			} else {
+				s.Amount, err = parseAmount(value)
+			}
+		case 'A', '%', 'F', 'K':
+			// Address lines, split percentages and flags carry nothing we keep
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown field %s", ErrInvalid, line, text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalid, line, err.Error())
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("Parse.Scan -- %w", err)
+	}
+	if t != nil {
+		return nil, fmt.Errorf("%w: the last transaction has no closing ^", ErrInvalid)
+	}
+	if len(accts) == 0 {
+		return nil, fmt.Errorf("%w: no transaction lists", ErrInvalid)
+	}
+	return accts, nil
+}
+
+// Write the accounts as QIF, Parse reads the output back into the same accounts
+func Write(w io.Writer, accts []Account) error {
+	bw := bufio.NewWriter(w)
+	for _, a := range accts {
+		typ, ok := listType(a.Type)
+		if !ok {
+			return fmt.Errorf("%w: account %q has type %q", ErrInvalid, a.Name, a.Type)
+		}
+		if a.Name != "" {
+			fmt.Fprintf(bw, "!Account\nN%s\nT%s\n^\n", a.Name, typ)
+		}
+		fmt.Fprintf(bw, "!Type:%s\n", typ)
+
+		for _, t := range a.Transactions {
+			fmt.Fprintf(bw, "D%s\n", formatDate(t.Date))
+			fmt.Fprintf(bw, "T%s\n", formatAmount(t.Amount))
+			for _, f := range []struct {
+				code  byte
+				value string
+			}{
+				{'C', t.Status},
+				{'N', t.Number},
+				{'P', t.Payee},
+				{'M', t.Memo},
+				{'L', joinCategory(t.Category, t.Transfer)},
+			} {
+				if f.value != "" {
+					fmt.Fprintf(bw, "%c%s\n", f.code, f.value)
+				}
+			}
+			for _, s := range t.Splits {
+				fmt.Fprintf(bw, "S%s\n", joinCategory(s.Category, s.Transfer))
+				if s.Memo != "" {
+					fmt.Fprintf(bw, "E%s\n", s.Memo)
+				}
+				fmt.Fprintf(bw, "$%s\n", formatAmount(s.Amount))
+			}
+			fmt.Fprint(bw, "^\n")
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("Write.Flush -- %w", err)
+	}
+	return nil
+}
+
+func listType(typ string) (string, bool) {
+	for _, lt := range listTypes {
+		if strings.EqualFold(typ, lt) {
+			return lt, true
+		}
+	}
+	return "", false
+}
+
+// [Account] is a transfer, anything else a category
+func splitCategory(value string) (category, transfer string) {
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		return "", value[1 : len(value)-1]
+	}
+	return value, ""
+}
+
+func joinCategory(category, transfer string) string {
+	if transfer != "" {
+		return "[" + transfer + "]"
+	}
+	return category
+}
+
+// Dates are M/D/YY, M/D/YYYY, M/D'YY for this century, or YYYY-MM-DD
+func parseDate(s string) (bcdate.BCDate, error) {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, " ", ""), "'", "/")
+
+	var parts []string
+	var y, m, d int
+	var err error
+	if parts = strings.Split(s, "-"); len(parts) == 3 && len(parts[0]) == 4 {
+		y, err = strconv.Atoi(parts[0])
+		if err == nil {
+			m, err = strconv.Atoi(parts[1])
+		}
+		if err == nil {
+			d, err = strconv.Atoi(parts[2])
+		}
+	} else if parts = strings.Split(s, "/"); len(parts) == 3 {
+		m, err = strconv.Atoi(parts[0])
+		if err == nil {
+			d, err = strconv.Atoi(parts[1])
+		}
+		if err == nil {
+			y, err = strconv.Atoi(parts[2])
+		}
+		if err == nil && len(parts[2]) <= 2 {
+			if y < 70 {
+				y += 2000
+			} else {
+				y += 1900
+			}
+		}
+	} else {
+		return 0, fmt.Errorf("date %q", s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("date %q", s)
+	}
+
+	if t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC); t.Year() != y || int(t.Month()) != m || t.Day() != d {
+		return 0, fmt.Errorf("date %q", s)
+	}
+	return bcdate.BCDate(y*10000 + m*100 + d), nil
+}
+
+func formatDate(d bcdate.BCDate) string {
+	return fmt.Sprintf("%02d/%02d/%04d", d/100%100, d%100, d/10000)
+}
+
+// Amounts like -1,234.56, in cents
+func parseAmount(s string) (int, error) {
+	v := strings.ReplaceAll(s, ",", "")
+	neg := strings.HasPrefix(v, "-")
+	v = strings.TrimLeft(v, "+-")
+
+	whole, frac, _ := strings.Cut(v, ".")
+	if len(frac) > 2 {
+		if strings.TrimRight(frac[2:], "0") != "" {
+			return 0, fmt.Errorf("amount %q has fractions of a cent", s)
+		}
+		frac = frac[:2]
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("amount %q", s)
+		}
+	}
+	cents, err := strconv.Atoi(whole + frac)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q", s)
+	}
+	if neg {
+		cents = -cents
+	}
+	return cents, nil
+}
+
+func formatAmount(v int) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
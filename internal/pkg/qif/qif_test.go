@@ -0,0 +1,218 @@
+package qif_test
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/qif"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseFile(t *testing.T, name string) []qif.Account {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+
+	accts, err := qif.Parse(f)
+	if err != nil {
+		t.Fatalf("Parse %s: %s", name, err)
+	}
+	return accts
+}
+
+var legacyChecking = qif.Account{Name: "Checking", Type: "Bank", Transactions: []qif.Transaction{
+	{Date: 20240105, Amount: 250000, Status: "*", Payee: "ACME Payroll", Memo: "January salary"},
+	{Date: 20240108, Amount: -14567, Status: "X", Number: "1001", Payee: "Corner Grocer", Category: "Household:Groceries"},
+	{Date: 20240115, Amount: -120000, Payee: "Smith & Sons", Memo: "Rent", Category: "Rent"},
+	{Date: 20240120, Amount: -30000, Payee: "Corner Grocer", Memo: "Groceries and card payment", Category: "Groceries", Splits: []qif.Split{
+		{Category: "Groceries", Memo: "Food", Amount: -8000},
+		{Category: "Hobbies", Amount: -2000},
+		{Transfer: "Visa", Memo: "Card payment", Amount: -20000},
+	}},
+	{Date: 20240125, Amount: -5000, Transfer: "Visa"},
+}}
+
+func TestParse(t *testing.T) {
+	accts := parseFile(t, "legacy.qif")
+	if len(accts) != 2 {
+		t.Fatalf("Parse found %d accounts, want 2", len(accts))
+	}
+	if !reflect.DeepEqual(accts[0], legacyChecking) {
+		t.Fatalf("Checking = %+v\nwant %+v", accts[0], legacyChecking)
+	}
+	if visa := accts[1]; visa.Name != "Visa" || visa.Type != "CCard" || len(visa.Transactions) != 3 || visa.Transactions[2].Amount != -4210 {
+		t.Fatalf("Visa = %+v", visa)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	accts := parseFile(t, "legacy.qif")
+
+	var out bytes.Buffer
+	if err := qif.Write(&out, accts); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	written := out.String()
+	if !strings.Contains(written, "!Account\nNChecking\nTBank\n^\n!Type:Bank\nD01/05/2024\nT2500.00\nC*\nPACME Payroll\n") {
+		t.Fatalf("Write = %s", written)
+	}
+
+	again, err := qif.Parse(strings.NewReader(written))
+	if err != nil {
+		t.Fatalf("Parse written: %s", err)
+	}
+	if !reflect.DeepEqual(again, accts) {
+		t.Fatalf("Parse(Write(accts)) = %+v\nwant %+v", again, accts)
+	}
+
+	// Written files are already in the writer's form
+	out.Reset()
+	if err := qif.Write(&out, again); err != nil {
+		t.Fatalf("Write again: %s", err)
+	}
+	if out.String() != written {
+		t.Fatalf("Write(Parse(written)) = %s\nwant %s", out.String(), written)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":           "",
+		"no list":         "D01/01/2024\nT1.00\n^\n",
+		"investments":     "!Type:Invst\nD01/01/2024\n^\n",
+		"unknown header":  "!Type:Bank\n!Nonsense\n",
+		"unknown field":   "!Type:Bank\nD01/01/2024\nZ1\n^\n",
+		"bad date":        "!Type:Bank\nD02/30/2024\nT1.00\n^\n",
+		"bad amount":      "!Type:Bank\nD01/01/2024\nT1.005\n^\n",
+		"no date":         "!Type:Bank\nT1.00\n^\n",
+		"split amount":    "!Type:Bank\nD01/01/2024\nT1.00\n$1.00\n^\n",
+		"unterminated":    "!Type:Bank\nD01/01/2024\nT1.00\n",
+		"header in entry": "!Type:Bank\nD01/01/2024\n!Type:Cash\n",
+	} {
+		if _, err := qif.Parse(strings.NewReader(data)); !errors.Is(err, qif.ErrInvalid) {
+			t.Errorf("Parse %s = %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+// Accounts, envelopes and payees the legacy file refers to
+func newLedger(t *testing.T, name string) (db.DB, model.PKEY, model.PKEY) {
+	t.Helper()
+	sdb := db.NewSQLite()
+	if err := sdb.Open(filepath.Join(t.TempDir(), name)); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if err := sdb.Init(); err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+
+	chk := model.Account{Institution: "Bank", Name: "Checking"}
+	visa := model.Account{Institution: "Bank", Name: "Visa", Debt: true}
+	for _, a := range []*model.Account{&chk, &visa} {
+		if err := sdb.NewAccount(a); err != nil {
+			t.Fatalf("NewAccount: %s", err)
+		}
+	}
+	for _, name := range []string{"Groceries", "Rent", "Dining"} {
+		if err := sdb.NewEnvelope(&model.Envelope{GroupID: 1, Name: name}); err != nil {
+			t.Fatalf("NewEnvelope: %s", err)
+		}
+	}
+	if err := sdb.NewPayee(&model.Payee{Name: "Corner Grocer"}); err != nil {
+		t.Fatalf("NewPayee: %s", err)
+	}
+	return sdb, chk.ID, visa.ID
+}
+
+func TestImportExport(t *testing.T) {
+	sdb, chk, visa := newLedger(t, "qif.db")
+	accts := parseFile(t, "legacy.qif")
+
+	// A dry run reports what would happen and writes nothing
+	res, err := qif.Import(sdb, chk, accts[0], importer.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Import dry run: %s", err)
+	}
+	if len(res.New) != 4 || len(res.Transfers) != 2 || !reflect.DeepEqual(res.Unknown, []string{"Hobbies"}) {
+		t.Fatalf("Import dry run = %d new, transfers %+v, unknown %v", len(res.New), res.Transfers, res.Unknown)
+	}
+	if ats, err := sdb.GetAllAccountTransactions(chk); err != nil || len(ats) != 0 {
+		t.Fatalf("Dry run wrote %d transactions, %v", len(ats), err)
+	}
+
+	if res, err = qif.Import(sdb, chk, accts[0], importer.Options{}); err != nil {
+		t.Fatalf("Import Checking: %s", err)
+	}
+	if x := res.Transfers[0]; x.ID == 0 || x.FromAccountID != chk || x.ToAccountID != visa || x.Amount != 20000 || !x.EnvelopeID.Valid {
+		t.Fatalf("Transfer = %+v", x)
+	}
+
+	// The Visa list holds the same two transfers from the other side
+	if res, err = qif.Import(sdb, visa, accts[1], importer.Options{}); err != nil {
+		t.Fatalf("Import Visa: %s", err)
+	}
+	if len(res.New) != 1 || len(res.Transfers) != 0 || len(res.SkippedTransfers) != 2 {
+		t.Fatalf("Import Visa = %d new, %d transfers, %d skipped", len(res.New), len(res.Transfers), len(res.SkippedTransfers))
+	}
+
+	exported, err := qif.Export(sdb, chk)
+	if err != nil {
+		t.Fatalf("Export: %s", err)
+	}
+	want := qif.Account{Name: "Checking", Type: "Bank", Transactions: []qif.Transaction{
+		{Date: 20240105, Amount: 250000, Status: "*", Memo: "January salary"},
+		{Date: 20240108, Amount: -14567, Status: "*", Payee: "Corner Grocer", Memo: "Corner Grocer", Category: "Groceries"},
+		{Date: 20240115, Amount: -120000, Memo: "Rent", Category: "Rent"},
+		{Date: 20240120, Amount: -10000, Payee: "Corner Grocer", Memo: "Groceries and card payment", Splits: []qif.Split{
+			{Category: "Groceries", Memo: "Food", Amount: -8000},
+			{Amount: -2000},
+		}},
+		{Date: 20240120, Amount: -20000, Memo: "Groceries and card payment", Transfer: "Visa"},
+		{Date: 20240125, Amount: -5000, Transfer: "Visa"},
+	}}
+	if !reflect.DeepEqual(exported, want) {
+		t.Fatalf("Export = %+v\nwant %+v", exported, want)
+	}
+	if vs, err := sdb.Check(); err != nil || len(vs) != 0 {
+		t.Fatalf("Check = %v, %v", vs, err)
+	}
+
+	// Exported history goes into another ledger and comes out the same
+	var out bytes.Buffer
+	if err := qif.Write(&out, []qif.Account{exported}); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	read, err := qif.Parse(&out)
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	other, chk2, _ := newLedger(t, "other.db")
+	if _, err := qif.Import(other, chk2, read[0], importer.Options{}); err != nil {
+		t.Fatalf("Import into other: %s", err)
+	}
+	if again, err := qif.Export(other, chk2); err != nil || !reflect.DeepEqual(again, exported) {
+		t.Fatalf("Export other = %+v, %v\nwant %+v", again, err, exported)
+	}
+
+	s1, err := sdb.GetAccountSummary(20240100, chk)
+	if err != nil {
+		t.Fatalf("GetAccountSummary: %s", err)
+	}
+	s2, err := other.GetAccountSummary(20240100, chk2)
+	if err != nil || s2.Bal != s1.Bal {
+		t.Fatalf("Other balance = %d, %v, want %d", s2.Bal, err, s1.Bal)
+	}
+
+	if _, err := qif.Import(sdb, chk, qif.Account{Type: "Bank", Transactions: []qif.Transaction{{Date: 20240101, Amount: -1, Transfer: "Nowhere"}}}, importer.Options{DryRun: true}); !errors.Is(err, qif.ErrInvalid) {
+		t.Fatalf("Import to an unknown account = %v, want ErrInvalid", err)
+	}
+}
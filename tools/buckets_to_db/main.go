@@ -314,23 +314,31 @@ func main() {
 
 	log.Printf("Migrate Account Transactions")
 
-	rows, err = bdb.Query("SELECT AT.account_id, AT.posted, AT.amount, AT.memo, AT.general_cat, AT.cleared, AT.fi_id, BT.bucket_id FROM account_transaction AT LEFT JOIN bucket_transaction BT ON BT.account_trans_id = AT.id")
+	// One row per bucket share, a transaction spread over several buckets comes back as several rows in a row
+	rows, err = bdb.Query("SELECT AT.id, AT.account_id, AT.posted, AT.amount, AT.memo, AT.general_cat, AT.cleared, AT.fi_id, BT.bucket_id, coalesce(BT.amount, 0), coalesce(BT.memo, '') FROM account_transaction AT LEFT JOIN bucket_transaction BT ON BT.account_trans_id = AT.id ORDER BY AT.id, BT.id")
 	if err != nil {
 		log.Fatalf("Failed to query account transactions: %s", err.Error())
 	}
 	defer rows.Close()
+
+	lastID := -1
+	var shares []model.Split
 	for rows.Next() {
 		var t struct {
-			AccountID int
-			Posted    string
-			Amount    int
-			Memo      string
-			Category  string
-			Cleared   bool
-			FI_ID     sql.NullString
-			BucketID  sql.NullInt32
+			ID           int
+			AccountID    int
+			Posted       string
+			Amount       int
+			Memo         string
+			Category     string
+			Cleared      bool
+			FI_ID        sql.NullString
+			BucketID     sql.NullInt32
+			BucketAmount int
+			BucketMemo   string
 		}
 		if err := rows.Scan(
+			&t.ID,
 			&t.AccountID,
 			&t.Posted,
 			&t.Amount,
@@ -339,10 +347,23 @@ func main() {
 			&t.Cleared,
 			&t.FI_ID,
 			&t.BucketID,
+			&t.BucketAmount,
+			&t.BucketMemo,
 		); err != nil {
 			log.Fatalf("Failed to scan next account transaction: %s", err.Error())
 		}
 
+		// Another bucket share of the transaction we just added
+		if t.ID == lastID {
+			shares = append(shares, model.Split{EnvelopeID: sql.NullInt32{Valid: true, Int32: int32(e_map[int(t.BucketID.Int32)])}, Amount: t.BucketAmount, Memo: t.BucketMemo})
+			continue
+		}
+		if lastID != -1 {
+			assignBuckets(&toInsA[len(toInsA)-1], shares)
+		}
+		lastID = t.ID
+		shares = nil
+
 		nt := model.AccountTransaction{
 			AccountID: a_map[t.AccountID],
 			Amount:    t.Amount,
@@ -351,8 +372,7 @@ func main() {
 		}
 
 		if t.BucketID.Valid {
-			nt.EnvelopeID.Valid = true
-			nt.EnvelopeID.Int32 = int32(e_map[int(t.BucketID.Int32)])
+			shares = append(shares, model.Split{EnvelopeID: sql.NullInt32{Valid: true, Int32: int32(e_map[int(t.BucketID.Int32)])}, Amount: t.BucketAmount, Memo: t.BucketMemo})
 		}
 
 		switch t.Category {
@@ -369,7 +389,7 @@ func main() {
 
 		match := bucketspostdate.FindStringSubmatch(t.Posted)
 		if match == nil {
-			log.Fatalf("Failed to match postdate with regex: %s", err.Error())
+			log.Fatalf("Failed to match postdate with regex: %s", t.Posted)
 		}
 		postdate, err := strconv.Atoi(match[1] + match[2] + match[3])
 		if err != nil {
@@ -384,6 +404,9 @@ func main() {
 	if err := rows.Err(); err != nil {
 		log.Fatalf("Failed after account transaction rows: %s", err.Error())
 	}
+	if lastID != -1 {
+		assignBuckets(&toInsA[len(toInsA)-1], shares)
+	}
 
 	// BucketTrans.linked_trans_id IS NOT NULL --> Debt envelope transactions
 
@@ -477,14 +500,73 @@ func main() {
 		log.Fatalf("Failed after envelope transaction rows: %s", err.Error())
 	}
 
+	// Payees from the memos, transfers and balance updates have none
+
+	log.Printf("Migrate Payees")
+
+	payeeOf := make([]string, len(toInsA))
+	payeeUses := make(map[string]map[int32]int)
+	payeeNames := make([]string, 0)
+	for i, at := range toInsA {
+		if at.Typ != model.TT_NORM && at.Typ != model.TT_INCOME {
+			continue
+		}
+		name := model.PayeeName(at.Memo)
+		if name == "" {
+			continue
+		}
+		payeeOf[i] = name
+		if _, ok := payeeUses[name]; !ok {
+			payeeUses[name] = make(map[int32]int)
+			payeeNames = append(payeeNames, name)
+		}
+		if at.Typ == model.TT_NORM && at.EnvelopeID.Valid {
+			payeeUses[name][at.EnvelopeID.Int32]++
+		}
+	}
+
+	var p_map map[string]model.PKEY = make(map[string]model.PKEY)
+	for _, name := range payeeNames {
+		np := model.Payee{Name: name}
+		if err := sdb.NewPayee(&np); err != nil {
+			log.Fatalf("Failed to add new Payee: %s", err.Error())
+		}
+		p_map[name] = np.ID
+	}
+	for i := range toInsA {
+		if payeeOf[i] != "" {
+			toInsA[i].PayeeID = sql.NullInt32{Valid: true, Int32: int32(p_map[payeeOf[i]])}
+		}
+	}
+
 	// Do the batch inserstions to save some processing time
 
 	log.Printf("Insert %d Account Transactions", len(toInsA))
 
-	if err = sdb.Batch_NewAccountTransaction(toInsA); err != nil {
+	// Buckets already holds these as they are, look-alikes included
+	if err = sdb.Batch_NewAccountTransaction(toInsA, true); err != nil {
 		log.Fatalf("Failed to insert all the Account Transactions: %s", err.Error())
 	}
 
+	// Default envelopes go on after the insert, so transactions Buckets left unassigned stay that way
+	// Each payee defaults to the envelope most of its spending went to, the lowest ID on a tie
+
+	for _, name := range payeeNames {
+		var best int32
+		for eid, n := range payeeUses[name] {
+			if n > payeeUses[name][best] || (n == payeeUses[name][best] && eid < best) {
+				best = eid
+			}
+		}
+		if best == 0 {
+			continue
+		}
+		np := model.Payee{ID: p_map[name], Name: name, EnvelopeID: sql.NullInt32{Valid: true, Int32: best}}
+		if err := sdb.UpdatePayee(np); err != nil {
+			log.Fatalf("Failed to set default envelope of Payee: %s", err.Error())
+		}
+	}
+
 	log.Printf("Insert %d Envelope Transactions", len(toInsE))
 	if err = sdb.Batch_NewEnvelopeTransaction(toInsE); err != nil {
 		log.Fatalf("Failed to insert all the Envelope Transactions: %s", err.Error())
@@ -507,3 +589,24 @@ func main() {
 	}
 
 }
+
+// A transaction fully in one bucket keeps a plain envelope, anything else becomes a split
+// Whatever the buckets leave over goes to an unassigned split
+func assignBuckets(nt *model.AccountTransaction, shares []model.Split) {
+	if len(shares) == 0 {
+		return
+	}
+	if len(shares) == 1 && shares[0].Amount == nt.Amount {
+		nt.EnvelopeID = shares[0].EnvelopeID
+		return
+	}
+
+	rest := nt.Amount
+	for _, sh := range shares {
+		rest -= sh.Amount
+	}
+	if rest != 0 {
+		shares = append(shares, model.Split{Amount: rest})
+	}
+	nt.Splits = shares
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"budgeting/internal/pkg/csvimport"
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Tool to import bank CSV exports into an account, read through a per-bank profile
+
+func printUsage() {
+	log.Print("Usages:")
+	log.Print("<dbfile> is a SQLite file, or a postgres:// URL")
+	log.Print("List the profiles stored in the DB:")
+	log.Print("csv_import <dbfile> profiles")
+	log.Print("Store the profiles of a JSON file in the DB, replacing those of the same name:")
+	log.Print("csv_import <dbfile> save <profiles.json>")
+	log.Print("Delete a stored profile:")
+	log.Print("csv_import <dbfile> delete <profile>")
+	log.Print("Import the file into the account:")
+	log.Print("csv_import <dbfile> import [--preview] [--dry] [--skip-dupes] [--profiles <profiles.json>] <profile> <account id> <file.csv>")
+	log.Print("--preview only prints the rows as the profile reads them, --dry also shows what is already imported and the rules that would fire")
+	log.Print("--profiles takes the profile from a JSON file instead of the DB")
+	log.Print("Rows looking like a transaction the account already has are listed as dupes, --skip-dupes leaves them out")
+
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+
+	dbname := os.Args[1]
+	op := os.Args[2]
+	args := os.Args[3:]
+
+	var sdb db.DB = db.NewFor(dbname)
+
+	log.Printf("Open: %s", dbname)
+	if err := sdb.Open(dbname); err != nil {
+		log.Fatalf("Error opening DB: %s", err.Error())
+	}
+
+	switch op {
+	case "profiles":
+		listProfiles(sdb)
+	case "save":
+		if len(args) != 1 {
+			printUsage()
+		}
+		saveProfiles(sdb, args[0])
+	case "delete":
+		if len(args) != 1 {
+			printUsage()
+		}
+		p, err := sdb.GetCSVProfileByName(args[0])
+		if err != nil {
+			log.Fatalf("Error getting profile %s: %s", args[0], err.Error())
+		}
+		if err := sdb.DeleteCSVProfile(p.ID); err != nil {
+			log.Fatalf("Error deleting profile: %s", err.Error())
+		}
+		log.Printf("Deleted %s", p.Name)
+	case "import":
+		importFile(sdb, args)
+	default:
+		log.Printf("ERROR: Unknown operation %s", op)
+		printUsage()
+	}
+}
+
+func listProfiles(sdb db.DB) {
+	ps, err := sdb.GetCSVProfiles()
+	if err != nil {
+		log.Fatalf("Error getting profiles: %s", err.Error())
+	}
+	for _, p := range ps {
+		amount := "amount " + p.AmountColumn
+		if p.AmountColumn == "" {
+			amount = "debit " + p.DebitColumn + " credit " + p.CreditColumn
+		}
+		log.Printf("%d %s: date %s (%s), %s, memo %s", p.ID, p.Name, p.DateColumn, p.DateFormat, amount, p.MemoColumn)
+	}
+}
+
+func readProfiles(fname string) []model.CSVProfile {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Error opening profiles: %s", err.Error())
+	}
+	defer f.Close()
+
+	ps, err := csvimport.LoadProfiles(f)
+	if err != nil {
+		log.Fatalf("Error reading profiles: %s", err.Error())
+	}
+	return ps
+}
+
+func saveProfiles(sdb db.DB, fname string) {
+	for _, p := range readProfiles(fname) {
+		old, err := sdb.GetCSVProfileByName(p.Name)
+		switch {
+		case err == nil:
+			p.ID = old.ID
+			if err := sdb.UpdateCSVProfile(p); err != nil {
+				log.Fatalf("Error updating profile %s: %s", p.Name, err.Error())
+			}
+			log.Printf("Updated %d %s", p.ID, p.Name)
+		case errors.Is(err, sql.ErrNoRows):
+			if err := sdb.NewCSVProfile(&p); err != nil {
+				log.Fatalf("Error adding profile %s: %s", p.Name, err.Error())
+			}
+			log.Printf("Added %d %s", p.ID, p.Name)
+		default:
+			log.Fatalf("Error getting profile %s: %s", p.Name, err.Error())
+		}
+	}
+}
+
+func importFile(sdb db.DB, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	preview := fs.Bool(
+		"preview",
+		false,
+		"Print the rows as read without touching the DB")
+	dry := fs.Bool(
+		"dry",
+		false,
+		"Print what would be imported without importing it")
+	skipDupes := fs.Bool(
+		"skip-dupes",
+		false,
+		"Leave out rows that look like a transaction the account already has")
+	profiles := fs.String(
+		"profiles",
+		"",
+		"JSON file to take the profile from")
+	fs.Usage = printUsage
+	fs.Parse(args)
+
+	if fs.NArg() != 3 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+
+	name := fs.Arg(0)
+	account, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("Error: account id %q is not a number", fs.Arg(1))
+	}
+	fname := fs.Arg(2)
+
+	var p model.CSVProfile
+	if *profiles != "" {
+		found := false
+		for _, fp := range readProfiles(*profiles) {
+			if fp.Name == name {
+				p, found = fp, true
+			}
+		}
+		if !found {
+			log.Fatalf("Error: no profile %s in %s", name, *profiles)
+		}
+	} else if p, err = sdb.GetCSVProfileByName(name); err != nil {
+		log.Fatalf("Error getting profile %s: %s", name, err.Error())
+	}
+
+	a, err := sdb.GetAccount(model.PKEY(account))
+	if err != nil {
+		log.Fatalf("Error getting account: %s", err.Error())
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Error opening file: %s", err.Error())
+	}
+	defer f.Close()
+
+	if *preview {
+		rows, err := csvimport.Parse(p, a.ID, f)
+		if err != nil {
+			log.Fatalf("Error reading file: %s", err.Error())
+		}
+		for _, row := range rows {
+			log.Printf("\t%08d %s %v %s %s", row.PostDate, model.FormatVal(row.Amount), row.Cleared, row.ImportID.String, row.Memo)
+		}
+		log.Printf("Preview, %d rows read with profile %s for %s", len(rows), p.Name, a.Name)
+		return
+	}
+
+	res, err := csvimport.Import(sdb, p, a.ID, f, importer.Options{DryRun: *dry, SkipDuplicates: *skipDupes})
+	if err != nil {
+		log.Fatalf("Error importing: %s", err.Error())
+	}
+
+	for _, at := range res.Skipped {
+		log.Printf("\tskip %s %08d %s %s", at.ImportID.String, at.PostDate, model.FormatVal(at.Amount), at.Memo)
+	}
+	for _, at := range res.New {
+		log.Printf("\tnew  %s %08d %s %s", at.ImportID.String, at.PostDate, model.FormatVal(at.Amount), at.Memo)
+	}
+	for _, m := range res.Matches {
+		log.Printf("\t%s", m)
+	}
+	for _, d := range res.Duplicates {
+		log.Printf("\tdupe #%d %s", d.Index, d)
+	}
+
+	if *dry {
+		log.Printf("Dry run, would import %d into %s and skip %d already imported, %d look like duplicates", len(res.New), a.Name, len(res.Skipped), len(res.Duplicates))
+	} else {
+		log.Printf("Imported %d into %s, skipped %d already imported, %d look like duplicates", len(res.New), a.Name, len(res.Skipped), len(res.Duplicates))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/ledger"
+	"flag"
+	"io"
+	"log"
+	"os"
+)
+
+// Tool to save a whole budget as versioned JSON and load it into another DB, of either driver
+
+func printUsage() {
+	log.Print("Usages:")
+	log.Print("<dbfile> is a SQLite file, or a postgres:// URL")
+	log.Print("Write every account, envelope, transaction and setting as JSON, to stdout without a file:")
+	log.Print("ledger <dbfile> export [<file.json>]")
+	log.Print("Load an export into an empty DB and recompute its checkpoints:")
+	log.Print("ledger <dbfile> import [--init] <file.json>")
+	log.Print("--init wipes the DB first, everything in it is lost")
+
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+
+	dbname := os.Args[1]
+	op := os.Args[2]
+	args := os.Args[3:]
+
+	var sdb db.DB = db.NewFor(dbname)
+
+	log.Printf("Open: %s", dbname)
+	if err := sdb.Open(dbname); err != nil {
+		log.Fatalf("Error opening DB: %s", err.Error())
+	}
+
+	switch op {
+	case "export":
+		exportLedger(sdb, args)
+	case "import":
+		importLedger(sdb, args)
+	default:
+		log.Printf("ERROR: Unknown operation %s", op)
+		printUsage()
+	}
+}
+
+func exportLedger(sdb db.DB, args []string) {
+	if len(args) > 1 {
+		printUsage()
+	}
+
+	l, err := ledger.Export(sdb)
+	if err != nil {
+		log.Fatalf("Error exporting: %s", err.Error())
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			log.Fatalf("Error creating file: %s", err.Error())
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := ledger.Write(w, l); err != nil {
+		log.Fatalf("Error writing: %s", err.Error())
+	}
+	log.Printf("Exported %d accounts, %d envelopes, %d account transactions and %d envelope transactions",
+		len(l.Accounts), len(l.Envelopes), len(l.AccountTransactions), len(l.EnvelopeTransactions))
+}
+
+func importLedger(sdb db.DB, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	wipe := fs.Bool(
+		"init",
+		false,
+		"Wipe the DB before importing")
+	fs.Usage = printUsage
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error opening file: %s", err.Error())
+	}
+	l, err := ledger.Read(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Error reading file: %s", err.Error())
+	}
+
+	if *wipe {
+		log.Print("Init: wiping the DB")
+		if err := sdb.Init(); err != nil {
+			log.Fatalf("Error initing DB: %s", err.Error())
+		}
+	}
+
+	if err := ledger.Import(sdb, l); err != nil {
+		log.Fatalf("Error importing: %s", err.Error())
+	}
+	log.Printf("Imported %d accounts, %d envelopes, %d account transactions and %d envelope transactions",
+		len(l.Accounts), len(l.Envelopes), len(l.AccountTransactions), len(l.EnvelopeTransactions))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/ofx"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Tool to import OFX or QFX bank statements into an account
+
+func printUsage() {
+	log.Print("Usages:")
+	log.Print("<dbfile> is a SQLite file, or a postgres:// URL")
+	log.Print("Import new transactions of the statement into the account, --dry only prints what would be imported:")
+	log.Print("ofx_import [--dry] [--skip-dupes] [--acctid <bank account>] <dbfile> <account id> <statement.ofx>")
+	log.Print("--acctid picks the statement when the file holds several accounts")
+	log.Print("Rows looking like a transaction the account already has are listed as dupes, --skip-dupes leaves them out")
+
+	os.Exit(1)
+}
+
+func main() {
+	fs := flag.NewFlagSet("ofx_import", flag.ExitOnError)
+	dry := fs.Bool(
+		"dry",
+		false,
+		"Print what would be imported without importing it")
+	skipDupes := fs.Bool(
+		"skip-dupes",
+		false,
+		"Leave out rows that look like a transaction the account already has")
+	acctid := fs.String(
+		"acctid",
+		"",
+		"ACCTID of the statement to import")
+	fs.Usage = printUsage
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() != 3 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+
+	dbname := fs.Arg(0)
+	account, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("Error: account id %q is not a number", fs.Arg(1))
+	}
+	fname := fs.Arg(2)
+
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Error opening statement: %s", err.Error())
+	}
+	sts, err := ofx.Parse(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Error reading statement: %s", err.Error())
+	}
+
+	var st *ofx.Statement
+	for i := range sts {
+		if *acctid == "" || sts[i].AccountID == *acctid {
+			if st != nil {
+				log.Print("ERROR: The file holds several statements, pick one with --acctid:")
+				for _, s := range sts {
+					log.Printf("\t%s", s.AccountID)
+				}
+				os.Exit(1)
+			}
+			st = &sts[i]
+		}
+	}
+	if st == nil {
+		log.Fatalf("Error: no statement for account %s in %s", *acctid, fname)
+	}
+
+	var sdb db.DB = db.NewFor(dbname)
+
+	log.Printf("Open: %s", dbname)
+	if err := sdb.Open(dbname); err != nil {
+		log.Fatalf("Error opening DB: %s", err.Error())
+	}
+
+	a, err := sdb.GetAccount(model.PKEY(account))
+	if err != nil {
+		log.Fatalf("Error getting account: %s", err.Error())
+	}
+
+	log.Printf("Statement %s %s, %08d to %08d, %d transactions, into %s", st.AccountID, st.Currency, st.Start, st.End, len(st.Transactions), a.Name)
+
+	res, err := ofx.Import(sdb, a.ID, *st, importer.Options{DryRun: *dry, SkipDuplicates: *skipDupes})
+	if err != nil {
+		log.Fatalf("Error importing: %s", err.Error())
+	}
+
+	for _, at := range res.Skipped {
+		log.Printf("\tskip %s %08d %s %s", at.ImportID.String, at.PostDate, model.FormatVal(at.Amount), at.Memo)
+	}
+	for _, at := range res.New {
+		log.Printf("\tnew  %s %08d %s %s", at.ImportID.String, at.PostDate, model.FormatVal(at.Amount), at.Memo)
+	}
+	for _, m := range res.Matches {
+		log.Printf("\t%s", m)
+	}
+	for _, d := range res.Duplicates {
+		log.Printf("\tdupe #%d %s", d.Index, d)
+	}
+
+	if *dry {
+		log.Printf("Dry run, would import %d and skip %d already imported, %d look like duplicates", len(res.New), len(res.Skipped), len(res.Duplicates))
+	} else {
+		log.Printf("Imported %d, skipped %d already imported, %d look like duplicates", len(res.New), len(res.Skipped), len(res.Duplicates))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/importer"
+	"budgeting/internal/pkg/model"
+	"budgeting/internal/pkg/qif"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Tool to move account history in and out as QIF, for tools that only speak that
+
+func printUsage() {
+	log.Print("Usages:")
+	log.Print("<dbfile> is a SQLite file, or a postgres:// URL")
+	log.Print("Write the account's whole history as QIF, to stdout without a file:")
+	log.Print("qif <dbfile> export <account id> [<file.qif>]")
+	log.Print("Import the file's transactions into the account, --dry only prints what would be imported:")
+	log.Print("qif <dbfile> import [--dry] [--skip-dupes] [--name <QIF account>] <account id> <file.qif>")
+	log.Print("--name picks the list when the file holds several accounts")
+	log.Print("Rows looking like a transaction the account already has are listed as dupes, --skip-dupes leaves them out")
+
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 4 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+
+	dbname := os.Args[1]
+	op := os.Args[2]
+	args := os.Args[3:]
+
+	var sdb db.DB = db.NewFor(dbname)
+
+	log.Printf("Open: %s", dbname)
+	if err := sdb.Open(dbname); err != nil {
+		log.Fatalf("Error opening DB: %s", err.Error())
+	}
+
+	switch op {
+	case "export":
+		exportAccount(sdb, args)
+	case "import":
+		importFile(sdb, args)
+	default:
+		log.Printf("ERROR: Unknown operation %s", op)
+		printUsage()
+	}
+}
+
+func accountArg(s string) model.PKEY {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("Error: account id %q is not a number", s)
+	}
+	return model.PKEY(id)
+}
+
+func exportAccount(sdb db.DB, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		printUsage()
+	}
+
+	a, err := qif.Export(sdb, accountArg(args[0]))
+	if err != nil {
+		log.Fatalf("Error exporting: %s", err.Error())
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) == 2 {
+		f, err := os.Create(args[1])
+		if err != nil {
+			log.Fatalf("Error creating file: %s", err.Error())
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := qif.Write(w, []qif.Account{a}); err != nil {
+		log.Fatalf("Error writing: %s", err.Error())
+	}
+	log.Printf("Exported %d transactions of %s", len(a.Transactions), a.Name)
+}
+
+func importFile(sdb db.DB, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dry := fs.Bool(
+		"dry",
+		false,
+		"Print what would be imported without importing it")
+	skipDupes := fs.Bool(
+		"skip-dupes",
+		false,
+		"Leave out rows that look like a transaction the account already has")
+	name := fs.String(
+		"name",
+		"",
+		"Name of the QIF account to import")
+	fs.Usage = printUsage
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		log.Print("ERROR: Incorrect arguments provided")
+		printUsage()
+	}
+	account := accountArg(fs.Arg(0))
+	fname := fs.Arg(1)
+
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Error opening file: %s", err.Error())
+	}
+	accts, err := qif.Parse(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Error reading file: %s", err.Error())
+	}
+
+	var qa *qif.Account
+	for i := range accts {
+		if *name == "" || accts[i].Name == *name {
+			if qa != nil {
+				log.Print("ERROR: The file holds several accounts, pick one with --name:")
+				for _, a := range accts {
+					log.Printf("\t%s", a.Name)
+				}
+				os.Exit(1)
+			}
+			qa = &accts[i]
+		}
+	}
+	if qa == nil {
+		log.Fatalf("Error: no account %s in %s", *name, fname)
+	}
+
+	a, err := sdb.GetAccount(account)
+	if err != nil {
+		log.Fatalf("Error getting account: %s", err.Error())
+	}
+	log.Printf("%s list %s, %d transactions, into %s", qa.Type, qa.Name, len(qa.Transactions), a.Name)
+
+	res, err := qif.Import(sdb, a.ID, *qa, importer.Options{DryRun: *dry, SkipDuplicates: *skipDupes})
+	if err != nil {
+		log.Fatalf("Error importing: %s", err.Error())
+	}
+
+	for _, at := range res.New {
+		log.Printf("\tnew  %08d %s %s", at.PostDate, model.FormatVal(at.Amount), at.Memo)
+	}
+	for _, x := range res.Transfers {
+		log.Printf("\txfer %08d %s %d -> %d %s", x.PostDate, model.FormatVal(x.Amount), x.FromAccountID, x.ToAccountID, x.Memo)
+	}
+	for _, x := range res.SkippedTransfers {
+		log.Printf("\tskip %08d %s %d -> %d %s", x.PostDate, model.FormatVal(x.Amount), x.FromAccountID, x.ToAccountID, x.Memo)
+	}
+	for _, m := range res.Matches {
+		log.Printf("\t%s", m)
+	}
+	for _, d := range res.Duplicates {
+		log.Printf("\tdupe #%d %s", d.Index, d)
+	}
+	for _, c := range res.Unknown {
+		log.Printf("\tno envelope for category %s, left unassigned", c)
+	}
+
+	if *dry {
+		log.Printf("Dry run, would import %d and make %d transfers, %d already there, %d look like duplicates", len(res.New), len(res.Transfers), len(res.SkippedTransfers), len(res.Duplicates))
+	} else {
+		log.Printf("Imported %d and made %d transfers, %d already there, %d look like duplicates", len(res.New), len(res.Transfers), len(res.SkippedTransfers), len(res.Duplicates))
+	}
+}
@@ -1,22 +1,57 @@
 package main
 
 import (
+	"budgeting/internal/pkg/backup"
 	"budgeting/internal/pkg/bcdate"
 	"budgeting/internal/pkg/db"
+	"budgeting/internal/pkg/middleware/auth"
+	"budgeting/internal/pkg/middleware/session"
 	"budgeting/internal/pkg/model"
+	"bufio"
+	"database/sql"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 // Tool to query the DB without starting a webserver
 
 func printUsage() {
 	log.Print("Usages:")
+	log.Print("<dbfile> is a SQLite file, or a postgres:// URL")
 	log.Print("Re-init file:")
 	log.Print("querytool <dbfile> init")
 	log.Print("Re-init and populate with default data:")
 	log.Print("querytool <dbfile> default")
+	log.Print("Show applied and pending schema migrations:")
+	log.Print("querytool <dbfile> migrate status")
+	log.Print("Apply pending schema migrations:")
+	log.Print("querytool <dbfile> migrate up")
+	log.Print("Check checkpoints and other invariants, exits 1 on any violation:")
+	log.Print("querytool <dbfile> check")
+	log.Print("Recompute all checkpoints from the transactions, --dry only prints what would change:")
+	log.Print("querytool <dbfile> rebuild [--dry]")
+	log.Print("Dry run the rules over the stored transactions of one or all accounts:")
+	log.Print("querytool <dbfile> rules [--acct id]")
+	log.Print("List pairs of stored transactions that look like the same money entered twice:")
+	log.Print("querytool <dbfile> dupes")
+	log.Print("Copy the DB while it stays in use, a directory <dest> gets a timestamped snapshot:")
+	log.Print("querytool <dbfile> backup <dest>")
+	log.Print("Replace a SQLite <dbfile> with a snapshot that passes check, the old file is kept as <dbfile>.pre-restore-<time>:")
+	log.Print("querytool <dbfile> restore <snapshot>")
+	log.Print("List the latest logged changes newest first, --op lists the rows one of them changed:")
+	log.Print("querytool <dbfile> history [--n count] [--op id]")
+	log.Print("Revert the latest changes not undone yet and recompute their checkpoints:")
+	log.Print("querytool <dbfile> undo [--n count]")
+	log.Print("Manage who may log in to the server, add and passwd read the password from stdin:")
+	log.Print("querytool <dbfile> user (list|add|passwd|del) [--name name]")
+	log.Print("Manage API tokens for scripts, add prints the token once:")
+	log.Print("querytool <dbfile> token (list|add|del) [--user name] [--name label] [--id id]")
+	log.Print("List or revoke logged in browsers, prune drops the expired ones:")
+	log.Print("querytool <dbfile> session (list|revoke|prune) [--user name] [--id id] [--idle dur] [--max dur]")
 	log.Print("Dump file contents:")
 	log.Print("querytool <dbfile> dump")
 	log.Print("Account:")
@@ -29,6 +64,8 @@ func printUsage() {
 	log.Print("querytool <dbfile> (sel|ins|upd|del) a_t [flags...]")
 	log.Print("Envelope Transaction:")
 	log.Print("querytool <dbfile> (sel|ins|upd|del) e_t [flags...]")
+	log.Print("Rule, sel without --id lists all in the order they run:")
+	log.Print("querytool <dbfile> (sel|ins|upd|del) r [flags...]")
 	log.Print("Account Summaries:")
 	log.Print("querytool <dbfile> (sel|ins|upd|del) a_chk [flags...]")
 	log.Print("Envelope Summaries:")
@@ -46,12 +83,44 @@ func main() {
 	dbname := os.Args[1]
 	op := os.Args[2]
 
-	var sdb db.DB = db.NewSQLite()
+	var sdb db.DB = db.NewFor(dbname)
+
+	// Migrating is explicit here, everything else migrates on Open
+	if op == "migrate" {
+		log.Printf("Connect: %s", dbname)
+		if err := sdb.Connect(dbname); err != nil {
+			log.Fatalf("Error connecting to DB: %s", err.Error())
+		}
+
+		handleMigrate(sdb, os.Args[3:])
+		return
+	}
+
+	// Restoring swaps the file, so it must not be open
+	if op == "restore" {
+		if len(os.Args) < 4 {
+			log.Print("ERROR: restore needs a snapshot")
+			printUsage()
+		}
+		log.Printf("Restore: %s from %s", dbname, os.Args[3])
+
+		now := time.Now()
+		vs, err := backup.Restore(os.Args[3], dbname, now)
+		for _, v := range vs {
+			log.Printf("\t%s", v)
+		}
+		if err != nil {
+			log.Fatalf("Error restoring snapshot: %s", err.Error())
+		}
+		log.Printf("Restored, the old file is kept as %s", backup.PreRestorePath(dbname, now))
+		return
+	}
 
 	log.Printf("Open: %s", dbname)
 	if err := sdb.Open(dbname); err != nil {
 		log.Fatalf("Error opening DB: %s", err.Error())
 	}
+	sdb = sdb.WithActor("querytool")
 
 	switch op {
 
@@ -73,6 +142,171 @@ func main() {
 			log.Fatalf("Error running default data script: %s", err.Error())
 		}
 
+	case "check":
+		log.Printf("Check: %s", dbname)
+
+		vs, err := sdb.Check()
+		if err != nil {
+			log.Fatalf("Error checking DB: %s", err.Error())
+		}
+
+		for _, v := range vs {
+			log.Printf("\t%s", v)
+		}
+		if len(vs) > 0 {
+			log.Fatalf("Found %d violations", len(vs))
+		}
+		log.Print("No violations found")
+
+	case "rebuild":
+		fs := flag.NewFlagSet("Rebuild", flag.ExitOnError)
+		dry := fs.Bool(
+			"dry",
+			false,
+			"Print changes without applying them")
+		fs.Parse(os.Args[3:])
+
+		log.Printf("Rebuild: %s", dbname)
+
+		changes, err := sdb.Rebuild(*dry)
+		if err != nil {
+			log.Fatalf("Error rebuilding checkpoints: %s", err.Error())
+		}
+
+		for _, c := range changes {
+			log.Printf("\t%s", c)
+		}
+		if *dry {
+			log.Printf("Dry run, %d values would change", len(changes))
+		} else {
+			log.Printf("Rebuilt, %d values changed", len(changes))
+		}
+
+	case "rules":
+		fs := flag.NewFlagSet("Rules", flag.ExitOnError)
+		acct := fs.Int(
+			"acct",
+			0,
+			"Only this account's transactions")
+		fs.Parse(os.Args[3:])
+
+		log.Printf("Rules: %s", dbname)
+
+		accts, err := sdb.GetAccounts()
+		if err != nil {
+			log.Fatalf("Error getting Accounts: %s", err.Error())
+		}
+
+		ats := make([]model.AccountTransaction, 0)
+		for _, a := range accts {
+			if *acct != 0 && a.ID != model.PKEY(*acct) {
+				continue
+			}
+			as, err := sdb.GetAllAccountTransactions(a.ID)
+			if err != nil {
+				log.Fatalf("Error getting Account transactions: %s", err.Error())
+			}
+			ats = append(ats, as...)
+		}
+
+		ms, err := sdb.PreviewRules(ats)
+		if err != nil {
+			log.Fatalf("Error previewing rules: %s", err.Error())
+		}
+
+		for _, m := range ms {
+			log.Printf("\ta_t %03d %s", m.Before.ID, m)
+		}
+		log.Printf("Dry run, rules fire on %d of %d transactions", len(ms), len(ats))
+
+	case "dupes":
+		log.Printf("Dupes: %s", dbname)
+
+		ds, err := sdb.GetDuplicates()
+		if err != nil {
+			log.Fatalf("Error finding duplicates: %s", err.Error())
+		}
+
+		for _, d := range ds {
+			log.Printf("\ta %03d %s", d.Transaction.AccountID, d)
+		}
+		log.Printf("Found %d suspected duplicates", len(ds))
+
+	case "backup":
+		if len(os.Args) < 4 {
+			log.Print("ERROR: backup needs a destination")
+			printUsage()
+		}
+		dest := os.Args[3]
+		log.Printf("Backup: %s to %s", dbname, dest)
+
+		if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+			snap, err := backup.Dir{Path: dest}.Snapshot(sdb, time.Now())
+			if err != nil {
+				log.Fatalf("Error writing snapshot: %s", err.Error())
+			}
+			log.Printf("Wrote %s, %d bytes", snap.Path, snap.Size)
+			break
+		}
+		if err := sdb.Backup(dest); err != nil {
+			log.Fatalf("Error writing backup: %s", err.Error())
+		}
+		log.Printf("Wrote %s", dest)
+
+	case "history":
+		fs := flag.NewFlagSet("History", flag.ExitOnError)
+		n := fs.Int(
+			"n",
+			20,
+			"How many changes to list")
+		opID := fs.Int(
+			"op",
+			0,
+			"List the rows this change wrote instead")
+		fs.Parse(os.Args[3:])
+
+		if *opID != 0 {
+			cs, err := sdb.GetAuditChanges(model.PKEY(*opID))
+			if err != nil {
+				log.Fatalf("Error getting changes: %s", err.Error())
+			}
+
+			log.Printf("Change %d wrote %d rows:", *opID, len(cs))
+			for _, c := range cs {
+				log.Printf("\t%s", c)
+			}
+			break
+		}
+
+		ops, err := sdb.GetHistory(*n)
+		if err != nil {
+			log.Fatalf("Error getting history: %s", err.Error())
+		}
+
+		for _, o := range ops {
+			log.Printf("\t%s", o)
+		}
+
+	case "undo":
+		fs := flag.NewFlagSet("Undo", flag.ExitOnError)
+		n := fs.Int(
+			"n",
+			1,
+			"How many changes to revert")
+		fs.Parse(os.Args[3:])
+
+		log.Printf("Undo: %s", dbname)
+
+		ops, err := sdb.Undo(*n)
+		if err != nil {
+			log.Fatalf("Error undoing: %s", err.Error())
+		}
+
+		for _, o := range ops {
+			log.Printf("\t%s", o)
+		}
+		log.Printf("Undid %d changes", len(ops))
+
 	case "dump":
 		log.Print("Accounts in DB:")
 
@@ -120,6 +354,13 @@ func main() {
 			log.Fatalf("Error getting Envelope Groups: %s", err.Error())
 		}
 
+	case "user":
+		handleUser(sdb, os.Args[3:])
+	case "token":
+		handleToken(sdb, os.Args[3:])
+	case "session":
+		handleSession(sdb, os.Args[3:])
+
 	case "sel":
 		handleDBOP(sdb, op, os.Args[3:])
 	case "ins":
@@ -137,6 +378,273 @@ func main() {
 
 }
 
+func handleMigrate(sdb db.DB, args []string) {
+	if len(args) < 1 {
+		log.Print("ERROR: migrate needs status or up")
+		printUsage()
+	}
+
+	switch args[0] {
+	case "status":
+		ms, err := sdb.GetMigrations()
+		if err != nil {
+			log.Fatalf("Error getting migrations: %s", err.Error())
+		}
+
+		version, err := sdb.SchemaVersion()
+		if err != nil {
+			log.Fatalf("Error getting schema version: %s", err.Error())
+		}
+
+		log.Printf("Schema version %d, latest %d", version, len(ms))
+		for _, m := range ms {
+			applied := m.Applied
+			if applied == "" {
+				applied = "pending"
+			}
+			log.Printf("\t%04d_%s\t%s", m.Version, m.Name, applied)
+		}
+
+	case "up":
+		done, err := sdb.Migrate()
+		if err != nil {
+			log.Fatalf("Error migrating: %s", err.Error())
+		}
+
+		if len(done) == 0 {
+			log.Print("Already up to date")
+		}
+		for _, m := range done {
+			log.Printf("Applied %04d_%s", m.Version, m.Name)
+		}
+
+	default:
+		log.Printf("ERROR: Unrecognized migrate operation: %s", args[0])
+		printUsage()
+	}
+}
+
+func handleUser(sdb db.DB, args []string) {
+	if len(args) < 1 {
+		log.Print("ERROR: user needs list, add, passwd or del")
+		printUsage()
+	}
+
+	fs := flag.NewFlagSet("User", flag.ExitOnError)
+	name := fs.String(
+		"name",
+		"",
+		"Name --    |add|passwd|del")
+	fs.Parse(args[1:])
+
+	if args[0] != "list" && *name == "" {
+		log.Printf("Error: To %s, --name is required", args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		us, err := sdb.GetUsers()
+		if err != nil {
+			log.Fatalf("Error getting users: %s", err.Error())
+		}
+		for _, u := range us {
+			log.Printf("\t%03d %s", u.ID, u.Name)
+		}
+
+	case "add":
+		hash, err := auth.HashPassword(readPassword())
+		if err != nil {
+			log.Fatalf("Error hashing password: %s", err.Error())
+		}
+		u := model.User{Name: *name, Hash: hash}
+		if err := sdb.NewUser(&u); err != nil {
+			log.Fatalf("Error inserting user: %s", err.Error())
+		}
+		log.Printf("Added user %03d %s", u.ID, u.Name)
+
+	case "passwd":
+		u, err := sdb.GetUserByName(*name)
+		if err != nil {
+			log.Fatalf("Error getting user: %s", err.Error())
+		}
+		if u.Hash, err = auth.HashPassword(readPassword()); err != nil {
+			log.Fatalf("Error hashing password: %s", err.Error())
+		}
+		if err := sdb.UpdateUser(u); err != nil {
+			log.Fatalf("Error updating user: %s", err.Error())
+		}
+		log.Printf("Changed the password of %s and logged out their sessions", u.Name)
+
+	case "del":
+		u, err := sdb.GetUserByName(*name)
+		if err != nil {
+			log.Fatalf("Error getting user: %s", err.Error())
+		}
+		if err := sdb.DeleteUser(u.ID); err != nil {
+			log.Fatalf("Error deleting user: %s", err.Error())
+		}
+		log.Printf("Deleted user %s and their tokens", u.Name)
+
+	default:
+		log.Printf("ERROR: Unrecognized user operation: %s", args[0])
+		printUsage()
+	}
+}
+
+func handleToken(sdb db.DB, args []string) {
+	if len(args) < 1 {
+		log.Print("ERROR: token needs list, add or del")
+		printUsage()
+	}
+
+	fs := flag.NewFlagSet("Token", flag.ExitOnError)
+	user := fs.String(
+		"user",
+		"",
+		"User  -- list|add|   ")
+	name := fs.String(
+		"name",
+		"",
+		"Label --     |add|   ")
+	id := fs.Int(
+		"id",
+		0,
+		"ID    --     |   |del")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "list", "add":
+		if *user == "" {
+			log.Printf("Error: To %s, --user is required", args[0])
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+		u, err := sdb.GetUserByName(*user)
+		if err != nil {
+			log.Fatalf("Error getting user: %s", err.Error())
+		}
+
+		if args[0] == "list" {
+			ts, err := sdb.GetTokens(u.ID)
+			if err != nil {
+				log.Fatalf("Error getting tokens: %s", err.Error())
+			}
+			for _, t := range ts {
+				log.Printf("\t%03d %s", t.ID, t.Name)
+			}
+			break
+		}
+
+		token, hash, err := auth.NewToken()
+		if err != nil {
+			log.Fatalf("Error making token: %s", err.Error())
+		}
+		t := model.Token{UserID: u.ID, Name: *name, Hash: hash}
+		if err := sdb.NewToken(&t); err != nil {
+			log.Fatalf("Error inserting token: %s", err.Error())
+		}
+		log.Printf("Added token %03d for %s, send it as Authorization: Bearer <token>, it is not shown again:", t.ID, u.Name)
+		fmt.Println(token)
+
+	case "del":
+		if *id == 0 {
+			log.Print("Error: To delete, --id is required")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+		if err := sdb.DeleteToken(model.PKEY(*id)); err != nil {
+			log.Fatalf("Error deleting token: %s", err.Error())
+		}
+		log.Print("Deleted token")
+
+	default:
+		log.Printf("ERROR: Unrecognized token operation: %s", args[0])
+		printUsage()
+	}
+}
+
+func handleSession(sdb db.DB, args []string) {
+	if len(args) < 1 {
+		log.Print("ERROR: session needs list, revoke or prune")
+		printUsage()
+	}
+
+	fs := flag.NewFlagSet("Session", flag.ExitOnError)
+	user := fs.String(
+		"user",
+		"",
+		"User     -- list|      |     ")
+	id := fs.Int(
+		"id",
+		0,
+		"ID       --     |revoke|     ")
+	idle := fs.Duration(
+		"idle",
+		session.DefaultIdle,
+		"Idle max --     |      |prune")
+	maxAge := fs.Duration(
+		"max",
+		session.DefaultAbsolute,
+		"Age max  --     |      |prune")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "list":
+		// Everyone's unless --user says whose
+		us, err := sdb.GetUsers()
+		if err != nil {
+			log.Fatalf("Error getting users: %s", err.Error())
+		}
+		for _, u := range us {
+			if *user != "" && u.Name != *user {
+				continue
+			}
+			ss, err := sdb.GetSessions(u.ID)
+			if err != nil {
+				log.Fatalf("Error getting sessions: %s", err.Error())
+			}
+			for _, s := range ss {
+				log.Printf("\t%03d %s since %s, last seen %s from %s %q", s.ID, u.Name, s.Created.Format(time.RFC3339), s.LastSeen.Format(time.RFC3339), s.Addr, s.Agent)
+			}
+		}
+
+	case "revoke":
+		if *id == 0 {
+			log.Print("Error: To revoke, --id is required")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+		if err := sdb.DeleteSession(model.PKEY(*id)); err != nil {
+			log.Fatalf("Error deleting session: %s", err.Error())
+		}
+		log.Print("Revoked session")
+
+	case "prune":
+		now := time.Now()
+		n, err := sdb.PruneSessions(now.Add(-*idle), now.Add(-*maxAge))
+		if err != nil {
+			log.Fatalf("Error pruning sessions: %s", err.Error())
+		}
+		log.Printf("Pruned %d sessions", n)
+
+	default:
+		log.Printf("ERROR: Unrecognized session operation: %s", args[0])
+		printUsage()
+	}
+}
+
+// First line of stdin, so a password can also be piped in
+func readPassword() string {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("Error reading password: %s", err.Error())
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
 func handleDBOP(sdb db.DB, op string, args []string) {
 
 	switch args[0] {
@@ -158,6 +666,10 @@ func handleDBOP(sdb db.DB, op string, args []string) {
 		default:
 		}
 
+	case "r":
+
+		handleRule(sdb, op, args[1:])
+
 	case "a_t":
 
 		switch op {
@@ -390,3 +902,202 @@ func handleEnvelopeGroup(sdb db.DB, op string, args []string) {
 	default:
 	}
 }
+
+func handleRule(sdb db.DB, op string, args []string) {
+	fs := flag.NewFlagSet("Rule", flag.ContinueOnError)
+
+	id := fs.Int(
+		"id",
+		0,
+		"ID          -- sel|   |upd|del")
+	prio := fs.Int(
+		"prio",
+		0,
+		"Priority    --    |ins|upd|   ")
+	name := fs.String(
+		"name",
+		"",
+		"Name        --    |ins|upd|   ")
+	pattern := fs.String(
+		"match",
+		"",
+		"Memo regex  --    |ins|upd|   ")
+	minAmt := fs.Int(
+		"min",
+		0,
+		"Min amount  --    |ins|upd|   ")
+	maxAmt := fs.Int(
+		"max",
+		0,
+		"Max amount  --    |ins|upd|   ")
+	acct := fs.Int(
+		"acct",
+		0,
+		"Account     --    |ins|upd|   ")
+	from := fs.Int(
+		"from",
+		0,
+		"From date   --    |ins|upd|   ")
+	to := fs.Int(
+		"to",
+		0,
+		"To date     --    |ins|upd|   ")
+	env := fs.Int(
+		"env",
+		0,
+		"Set env     --    |ins|upd|   ")
+	typ := fs.Int(
+		"type",
+		0,
+		"Set type    --    |ins|upd|   ")
+	cleared := fs.Bool(
+		"cleared",
+		false,
+		"Set cleared --    |ins|upd|   ")
+	memo := fs.String(
+		"memo",
+		"",
+		"Set memo    --    |ins|upd|   ")
+	unset := fs.String(
+		"clear",
+		"",
+		"Unset these comma separated flags --    |   |upd|   ")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	// Only flags given on the command line count, the rest stay unset
+	set := func(r *model.Rule, f string) {
+		switch f {
+		case "prio":
+			r.Priority = *prio
+		case "name":
+			r.Name = *name
+		case "match":
+			r.MemoPattern = *pattern
+		case "min":
+			r.MinAmount = sql.NullInt64{Int64: int64(*minAmt), Valid: true}
+		case "max":
+			r.MaxAmount = sql.NullInt64{Int64: int64(*maxAmt), Valid: true}
+		case "acct":
+			r.AccountID = sql.NullInt32{Int32: int32(*acct), Valid: true}
+		case "from":
+			r.FromDate = sql.NullInt32{Int32: int32(*from), Valid: true}
+		case "to":
+			r.ToDate = sql.NullInt32{Int32: int32(*to), Valid: true}
+		case "env":
+			r.EnvelopeID = sql.NullInt32{Int32: int32(*env), Valid: true}
+		case "type":
+			r.Typ = sql.NullInt32{Int32: int32(*typ), Valid: true}
+		case "cleared":
+			r.Cleared = sql.NullBool{Bool: *cleared, Valid: true}
+		case "memo":
+			r.Memo = sql.NullString{String: *memo, Valid: true}
+		}
+	}
+
+	switch op {
+	case "sel":
+		// Without an ID, list them all
+		if *id == 0 {
+			rs, err := sdb.GetRules()
+			if err != nil {
+				log.Fatalf("Error getting rules: %s", err.Error())
+			}
+
+			log.Print("Rules in order:")
+			for _, r := range rs {
+				log.Printf("%s", r)
+			}
+			return
+		}
+
+		r, err := sdb.GetRule(model.PKEY(*id))
+		if err != nil {
+			log.Fatalf("Error getting rule: %s", err.Error())
+		}
+
+		log.Print("Rule result:")
+		log.Printf("%s", r)
+
+	case "ins":
+		// ID will be overwritten
+		r := model.Rule{}
+		fs.Visit(func(f *flag.Flag) { set(&r, f.Name) })
+
+		if err := sdb.NewRule(&r); err != nil {
+			log.Fatalf("Error inserting rule: %s", err.Error())
+		}
+
+		log.Print("Rule:")
+		log.Printf("%s", r)
+
+	case "upd":
+		// Need ID to query, then overlay given, then unset those in --clear
+		if *id == 0 {
+			log.Print("Error: To update, --id is required")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+
+		r, err := sdb.GetRule(model.PKEY(*id))
+		if err != nil {
+			log.Fatalf("Error getting rule: %s", err.Error())
+		}
+
+		fs.Visit(func(f *flag.Flag) { set(&r, f.Name) })
+
+		if *unset != "" {
+			for _, f := range strings.Split(*unset, ",") {
+				switch f {
+				case "match":
+					r.MemoPattern = ""
+				case "min":
+					r.MinAmount = sql.NullInt64{}
+				case "max":
+					r.MaxAmount = sql.NullInt64{}
+				case "acct":
+					r.AccountID = sql.NullInt32{}
+				case "from":
+					r.FromDate = sql.NullInt32{}
+				case "to":
+					r.ToDate = sql.NullInt32{}
+				case "env":
+					r.EnvelopeID = sql.NullInt32{}
+				case "type":
+					r.Typ = sql.NullInt32{}
+				case "cleared":
+					r.Cleared = sql.NullBool{}
+				case "memo":
+					r.Memo = sql.NullString{}
+				default:
+					log.Fatalf("Error: cannot clear %s", f)
+				}
+			}
+		}
+
+		if err := sdb.UpdateRule(r); err != nil {
+			log.Fatalf("Error updating rule: %s", err.Error())
+		}
+
+		log.Print("Updated rule:")
+		log.Printf("%s", r)
+
+	case "del":
+		// Need ID, ignore others
+		if *id == 0 {
+			log.Print("Error: To delete, --id is required")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+
+		if err := sdb.DeleteRule(model.PKEY(*id)); err != nil {
+			log.Fatalf("Error deleting rule: %s", err.Error())
+		}
+
+		log.Print("Deleted rule")
+
+	default:
+	}
+}